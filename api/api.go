@@ -0,0 +1,18 @@
+// Package api holds the descriptions of the service API: the OpenAPI document
+// of the HTTP routes with its docs page and the protobuf definitions.
+package api
+
+import (
+	// embed the documents into the binary
+	_ "embed"
+)
+
+// OpenAPI is the OpenAPI 3 document of the HTTP API, served at /openapi.json.
+//
+//go:embed openapi.json
+var OpenAPI []byte
+
+// Docs is the page rendering OpenAPI, served at /docs.
+//
+//go:embed docs.html
+var Docs []byte
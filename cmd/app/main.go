@@ -1,16 +1,24 @@
 package main
 
 import (
+	"autumn-2021-intern-assignment/pkg/grpcapi/balancev1"
 	"autumn-2021-intern-assignment/pkg/handlers"
+	"autumn-2021-intern-assignment/pkg/scheduler"
 	"autumn-2021-intern-assignment/pkg/transaction"
+	"context"
 	"database/sql"
+	"expvar"
 	"fmt"
-	"github.com/gorilla/mux"
 	_ "github.com/lib/pq"
 	"go.uber.org/zap"
+	"google.golang.org/grpc"
 	"log"
+	"net"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 )
 
 func main() {
@@ -37,14 +45,205 @@ func main() {
 	defer zapLogger.Sync() // flushes buffer, if any
 	logger := zapLogger.Sugar()
 
-	repo := transaction.NewRepository(db)
-	handler := handlers.ItemsHandler{ItemRepo: repo, Logger: logger}
-	r := mux.NewRouter()
-	r.HandleFunc("/user", handler.GetBalanceFromUser)
-	r.HandleFunc("/balance/add", handler.IncreaseBalance)
-	r.HandleFunc("/balance/reduce", handler.DecreaseBalance)
-	r.HandleFunc("/balance/transfer", handler.TransferBalance)
-	r.HandleFunc("/info", handler.ListTransaction)
+	// reads of the balance and the history go to the replicas
+	var replicas []*sql.DB
+	if hosts := os.Getenv("PG_REPLICA_HOSTS"); hosts != "" {
+		for _, host := range strings.Split(hosts, ",") {
+			replica, err := sql.Open("postgres", fmt.Sprintf("user=%s password=%s dbname=%s sslmode=%s host=%s port=%s",
+				name, password, dbname, "disable", strings.TrimSpace(host), "5432"))
+			if err != nil {
+				fmt.Println("no open replica:", err)
+				return
+			}
+			replicas = append(replicas, replica)
+		}
+	}
+
+	repo := transaction.NewRepository(db, replicas...)
+	if timeout := os.Getenv("DB_TIMEOUT"); timeout != "" {
+		repo.Timeout, err = time.ParseDuration(timeout)
+		if err != nil {
+			fmt.Println("bad DB_TIMEOUT:", err)
+			return
+		}
+	}
+	if lag := os.Getenv("DB_MAX_REPLICA_LAG"); lag != "" {
+		repo.MaxReplicaLag, err = time.ParseDuration(lag)
+		if err != nil {
+			fmt.Println("bad DB_MAX_REPLICA_LAG:", err)
+			return
+		}
+	}
+
+	repo.RiskRules, err = transaction.ParseRiskRules(os.Getenv("RISK_RULES"))
+	if err != nil {
+		fmt.Println("bad RISK_RULES:", err)
+		return
+	}
+
+	if threshold := os.Getenv("APPROVAL_THRESHOLD"); threshold != "" {
+		repo.ApprovalThreshold, err = strconv.ParseFloat(threshold, 64)
+		if err != nil || repo.ApprovalThreshold < 0 {
+			fmt.Println("bad APPROVAL_THRESHOLD, an amount is expected")
+			return
+		}
+	}
+	if ttl := os.Getenv("APPROVAL_TTL"); ttl != "" {
+		repo.ApprovalTTL, err = time.ParseDuration(ttl)
+		if err != nil {
+			fmt.Println("bad APPROVAL_TTL:", err)
+			return
+		}
+	}
+
+	if key := os.Getenv("CURRENCYLAYER_KEY"); key != "" {
+		repo.LiveRate = transaction.CurrencyLayerRate(key)
+	}
+	if age := os.Getenv("RATE_MAX_AGE"); age != "" {
+		repo.MaxRateAge, err = time.ParseDuration(age)
+		if err != nil {
+			fmt.Println("bad RATE_MAX_AGE:", err)
+			return
+		}
+	}
+	if timeout := os.Getenv("IDEMPOTENCY_TIMEOUT"); timeout != "" {
+		repo.IdempotencyTimeout, err = time.ParseDuration(timeout)
+		if err != nil {
+			fmt.Println("bad IDEMPOTENCY_TIMEOUT:", err)
+			return
+		}
+	}
+	if ttl := os.Getenv("IDEMPOTENCY_TTL"); ttl != "" {
+		repo.IdempotencyTTL, err = time.ParseDuration(ttl)
+		if err != nil {
+			fmt.Println("bad IDEMPOTENCY_TTL:", err)
+			return
+		}
+	}
+
+	repo.ArchiveDir = os.Getenv("ARCHIVE_DIR")
+	retention := 0
+	if months := os.Getenv("ARCHIVE_AFTER_MONTHS"); months != "" {
+		retention, err = strconv.Atoi(months)
+		if err != nil || retention < 0 || repo.ArchiveDir == "" {
+			fmt.Println("bad ARCHIVE_AFTER_MONTHS, a number of months and ARCHIVE_DIR are expected")
+			return
+		}
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "reconcile" {
+		code := reconcile(repo, os.Args[2:])
+		//nolint:errcheck
+		zapLogger.Sync()
+		os.Exit(code)
+	}
+
+	rateLimit := handlers.RateLimitHandler{Limiter: transaction.NewMemoryRateLimiter(), Logger: logger}
+	rateLimit.Rules, err = handlers.ParseRateRules(os.Getenv("RATE_LIMITS"))
+	if err != nil {
+		fmt.Println("bad RATE_LIMITS:", err)
+		return
+	}
+	if os.Getenv("RATE_LIMIT_SHARED") == "true" {
+		rateLimit.Limiter = repo
+
+		sweeper := &scheduler.RateLimitSweepWorker{
+			Repo:     repo,
+			Interval: time.Minute,
+			Logger:   logger,
+		}
+		go sweeper.Run(context.Background())
+	}
+
+	auth := handlers.AuthHandler{Logger: logger}
+	auth.Keys, err = handlers.ParseAPIKeys(os.Getenv("API_KEYS"))
+	if err != nil {
+		fmt.Println("bad API_KEYS:", err)
+		return
+	}
+
+	r := newRouter(repo, auth, rateLimit, logger)
+	reconciliation := handlers.ReconcileHandler{ReconcileRepo: repo, Logger: logger}
+	expvar.Publish("reconciliation", expvar.Func(reconciliation.Metric))
+
+	hostname, _ := os.Hostname()
+	worker := &scheduler.Worker{
+		Repo:     repo,
+		ID:       fmt.Sprintf("%s-%d", hostname, os.Getpid()),
+		Interval: 10 * time.Second,
+		Lease:    5 * time.Minute,
+		Batch:    100,
+		Logger:   logger,
+	}
+	go worker.Run(context.Background())
+
+	checkpoints := &scheduler.CheckpointWorker{
+		Repo:     repo,
+		Interval: time.Hour,
+		Delay:    5 * time.Minute,
+		Logger:   logger,
+	}
+	go checkpoints.Run(context.Background())
+
+	reconciler := &scheduler.ReconcileWorker{
+		Repo:     repo,
+		Interval: 10 * time.Minute,
+		Delay:    time.Hour,
+		Fix:      os.Getenv("RECONCILE_FIX") == "true",
+		Logger:   logger,
+	}
+	go reconciler.Run(context.Background())
+
+	archiver := &scheduler.ArchiveWorker{
+		Repo:      repo,
+		Interval:  time.Hour,
+		Retention: retention,
+		Logger:    logger,
+	}
+	go archiver.Run(context.Background())
+
+	expiry := &scheduler.ExpiryWorker{
+		Repo:     repo,
+		Interval: time.Minute,
+		Logger:   logger,
+	}
+	go expiry.Run(context.Background())
+
+	bonusExpiry := &scheduler.BonusExpiryWorker{
+		Repo:     repo,
+		Interval: time.Minute,
+		Logger:   logger,
+	}
+	go bonusExpiry.Run(context.Background())
+
+	idempotencyExpiry := &scheduler.IdempotencyExpiryWorker{
+		Repo:     repo,
+		Interval: 10 * time.Minute,
+		Logger:   logger,
+	}
+	go idempotencyExpiry.Run(context.Background())
+
+	// the gRPC API is served next to the HTTP one, see api/balance/v1/balance.proto
+	grpcAddr := os.Getenv("GRPC_ADDR")
+	if grpcAddr == "" {
+		grpcAddr = ":9000"
+	}
+	lis, err := net.Listen("tcp", grpcAddr)
+	if err != nil {
+		fmt.Println("bad GRPC_ADDR:", err)
+		return
+	}
+	grpcServer := grpc.NewServer(
+		grpc.UnaryInterceptor(auth.UnaryInterceptor),
+		grpc.StreamInterceptor(auth.StreamInterceptor),
+	)
+	balancev1.RegisterBalanceServer(grpcServer, handlers.BalanceServer{ItemRepo: repo, Logger: logger})
+	go func() {
+		err := grpcServer.Serve(lis)
+		if err != nil {
+			log.Println(err)
+		}
+	}()
 
 	addr := ":8000"
 
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"autumn-2021-intern-assignment/pkg/transaction"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+)
+
+// reconcile is the "reconcile" subcommand: it runs one reconciliation, prints
+// the result and exits with 1 when mismatches are found.
+func reconcile(repo *transaction.RepositoryItem, args []string) int {
+	fs := flag.NewFlagSet("reconcile", flag.ContinueOnError)
+	fix := fs.Bool("fix", false, "write correcting entries for the mismatches")
+	err := fs.Parse(args)
+	if err != nil {
+		return 2
+	}
+
+	run, err := repo.Reconcile(context.Background(), *fix)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "reconciliation failed:", err)
+		return 2
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	//nolint:errcheck
+	enc.Encode(run)
+
+	if run.Mismatches > run.Corrected {
+		return 1
+	}
+
+	return 0
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"autumn-2021-intern-assignment/pkg/handlers"
+	"autumn-2021-intern-assignment/pkg/transaction"
+	"expvar"
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+	"net/http"
+)
+
+// newRouter registers the routes of the service, each of them has to be
+// described in api/openapi.json.
+func newRouter(repo *transaction.RepositoryItem, auth handlers.AuthHandler, rateLimit handlers.RateLimitHandler,
+	logger *zap.SugaredLogger) *mux.Router {
+	handler := handlers.ItemsHandler{ItemRepo: repo, Logger: logger}
+	r := mux.NewRouter()
+	r.HandleFunc("/user", handler.GetBalanceFromUser)
+	r.HandleFunc("/balance/add", handler.IncreaseBalance)
+	r.HandleFunc("/balance/reduce", handler.DecreaseBalance)
+	r.HandleFunc("/balance/transfer", handler.TransferBalance)
+	r.HandleFunc("/balance/refund", handler.RefundBalance)
+	r.HandleFunc("/info", handler.ListTransaction)
+	r.HandleFunc("/transactions/{id:[0-9]+}", handler.GetTransactionInfo).Methods(http.MethodGet)
+
+	batch := handlers.BatchHandler{BatchRepo: repo, Logger: logger}
+	r.HandleFunc("/balance/batch", batch.ApplyBatch).Methods(http.MethodPost)
+
+	splits := handlers.SplitsHandler{SplitRepo: repo, Logger: logger}
+	r.HandleFunc("/balance/split", splits.SplitBalance).Methods(http.MethodPost)
+
+	accounts := handlers.AccountsHandler{AccountRepo: repo, Logger: logger}
+	r.HandleFunc("/accounts", accounts.CreateAccount).Methods(http.MethodPost)
+	r.HandleFunc("/accounts/{id:[0-9]+}", accounts.GetAccount).Methods(http.MethodGet)
+	r.HandleFunc("/accounts/{id:[0-9]+}/freeze", accounts.FreezeAccount).Methods(http.MethodPost)
+	r.HandleFunc("/accounts/{id:[0-9]+}/unfreeze", accounts.UnfreezeAccount).Methods(http.MethodPost)
+	r.HandleFunc("/accounts/{id:[0-9]+}/close", accounts.CloseAccount).Methods(http.MethodPost)
+	r.HandleFunc("/accounts/{id:[0-9]+}/credit-limit", accounts.SetCreditLimit).Methods(http.MethodPost)
+	r.HandleFunc("/accounts/{id:[0-9]+}/overdraft", accounts.GetOverdraftHistory).Methods(http.MethodGet)
+
+	limits := handlers.LimitsHandler{LimitRepo: repo, Logger: logger}
+	r.HandleFunc("/admin/limits/{id:[0-9]+}", limits.GetLimits).Methods(http.MethodGet)
+	r.HandleFunc("/admin/limits/{id:[0-9]+}", limits.SetLimits).Methods(http.MethodPut)
+	r.HandleFunc("/admin/limits/{id:[0-9]+}", limits.DeleteLimits).Methods(http.MethodDelete)
+
+	fees := handlers.FeesHandler{FeeRepo: repo, Logger: logger}
+	r.HandleFunc("/fees/preview", fees.PreviewFee).Methods(http.MethodPost)
+	r.HandleFunc("/admin/fees", fees.GetFeeRules).Methods(http.MethodGet)
+	r.HandleFunc("/admin/fees", fees.CreateFeeRule).Methods(http.MethodPost)
+	r.HandleFunc("/admin/fees/{id:[0-9]+}", fees.DeleteFeeRule).Methods(http.MethodDelete)
+
+	schedules := handlers.SchedulesHandler{ScheduleRepo: repo, Logger: logger}
+	r.HandleFunc("/schedules", schedules.CreateSchedule).Methods(http.MethodPost)
+	r.HandleFunc("/accounts/{id:[0-9]+}/schedules", schedules.GetSchedules).Methods(http.MethodGet)
+	r.HandleFunc("/schedules/{id:[0-9]+}/pause", schedules.PauseSchedule).Methods(http.MethodPost)
+	r.HandleFunc("/schedules/{id:[0-9]+}/resume", schedules.ResumeSchedule).Methods(http.MethodPost)
+	r.HandleFunc("/schedules/{id:[0-9]+}/cancel", schedules.CancelSchedule).Methods(http.MethodPost)
+
+	reports := handlers.ReportsHandler{ReportRepo: repo, Logger: logger}
+	r.HandleFunc("/accounts/{id:[0-9]+}/balance", reports.GetBalanceAt).Methods(http.MethodGet)
+	r.HandleFunc("/admin/reports/balances", reports.CreateBalanceReport).Methods(http.MethodPost)
+	r.HandleFunc("/admin/reports/balances/{id:[0-9]+}", reports.GetBalanceReport).Methods(http.MethodGet)
+
+	reconciliation := handlers.ReconcileHandler{ReconcileRepo: repo, Logger: logger}
+	r.HandleFunc("/admin/reconciliation", reconciliation.RunReconciliation).Methods(http.MethodPost)
+	r.HandleFunc("/admin/reconciliation/last", reconciliation.GetLastReconciliation).Methods(http.MethodGet)
+	r.Handle("/debug/vars", expvar.Handler())
+
+	adjustments := handlers.AdjustmentsHandler{AdjustmentRepo: repo, Logger: logger}
+	r.HandleFunc("/admin/adjustments", adjustments.Adjust).Methods(http.MethodPost)
+
+	risk := handlers.RiskHandler{RiskRepo: repo, Logger: logger}
+	r.HandleFunc("/admin/reviews", risk.GetRiskReviews).Methods(http.MethodGet)
+	r.HandleFunc("/admin/reviews/{id:[0-9]+}", risk.GetRiskReview).Methods(http.MethodGet)
+	r.HandleFunc("/admin/reviews/{id:[0-9]+}/approve", risk.ApproveRiskReview).Methods(http.MethodPost)
+	r.HandleFunc("/admin/reviews/{id:[0-9]+}/reject", risk.RejectRiskReview).Methods(http.MethodPost)
+	r.HandleFunc("/accounts/{id:[0-9]+}/risk-decisions", risk.GetRiskDecisions).Methods(http.MethodGet)
+
+	pending := handlers.PendingHandler{PendingRepo: repo, Logger: logger}
+	r.HandleFunc("/admin/pending", pending.GetPendingOperations).Methods(http.MethodGet)
+	r.HandleFunc("/admin/pending/{id:[0-9]+}", pending.GetPendingOperation).Methods(http.MethodGet)
+	r.HandleFunc("/admin/pending/{id:[0-9]+}/approve", pending.ApprovePendingOperation).Methods(http.MethodPost)
+	r.HandleFunc("/admin/pending/{id:[0-9]+}/reject", pending.RejectPendingOperation).Methods(http.MethodPost)
+
+	bonuses := handlers.BonusHandler{BonusRepo: repo, Logger: logger}
+	r.HandleFunc("/admin/bonuses", bonuses.GrantBonus).Methods(http.MethodPost)
+	r.HandleFunc("/accounts/{id:[0-9]+}/bonuses", bonuses.GetBonuses).Methods(http.MethodGet)
+
+	rates := handlers.RatesHandler{RatesRepo: repo, Logger: logger}
+	r.HandleFunc("/admin/rates", rates.SaveRates).Methods(http.MethodPost)
+
+	r.HandleFunc("/openapi.json", handlers.ServeOpenAPI).Methods(http.MethodGet)
+	r.HandleFunc("/docs", handlers.ServeDocs).Methods(http.MethodGet)
+
+	// refused requests don't reach the other middlewares, the client is known
+	// to all of them
+	r.Use(auth.Middleware)
+	r.Use(rateLimit.Middleware)
+	idempotency := handlers.IdempotencyHandler{IdempotencyRepo: repo, Logger: logger}
+	r.Use(idempotency.Middleware)
+	consistency := handlers.ReadConsistencyHandler{Logger: logger}
+	r.Use(consistency.Middleware)
+
+	return r
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"autumn-2021-intern-assignment/api"
+	"autumn-2021-intern-assignment/pkg/handlers"
+	"autumn-2021-intern-assignment/pkg/transaction"
+	"encoding/json"
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+var pathVar = regexp.MustCompile(`\{(\w+):[^}]+\}`)
+
+// TestRoutesDocumented checks that api/openapi.json describes every route of
+// the router and nothing else.
+func TestRoutesDocumented(t *testing.T) {
+	spec := &struct {
+		Paths map[string]map[string]interface{} `json:"paths"`
+	}{}
+	err := json.Unmarshal(api.OpenAPI, spec)
+	if err != nil {
+		t.Fatalf("bad openapi.json: %s", err)
+	}
+
+	r := newRouter(transaction.NewRepository(nil), handlers.AuthHandler{}, handlers.RateLimitHandler{}, zap.NewNop().Sugar())
+
+	registered := map[string]bool{}
+	err = r.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		tpl, err := route.GetPathTemplate()
+		if err != nil {
+			return err
+		}
+		path := pathVar.ReplaceAllString(tpl, "{$1}")
+		registered[path] = true
+
+		ops, ok := spec.Paths[path]
+		if !ok {
+			t.Errorf("route %s is not in openapi.json", path)
+			return nil
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			// any method is accepted
+			return nil
+		}
+		for _, method := range methods {
+			if _, ok := ops[strings.ToLower(method)]; !ok {
+				t.Errorf("%s %s is not in openapi.json", method, path)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("cant walk the routes: %s", err)
+	}
+
+	for path, ops := range spec.Paths {
+		if !registered[path] {
+			t.Errorf("%s is in openapi.json but not routed", path)
+			continue
+		}
+		for method := range ops {
+			req := httptest.NewRequest(strings.ToUpper(method), strings.ReplaceAll(path, "{id}", "1"), nil)
+			if !r.Match(req, &mux.RouteMatch{}) {
+				t.Errorf("%s %s is in openapi.json but not routed", strings.ToUpper(method), path)
+			}
+		}
+	}
+}
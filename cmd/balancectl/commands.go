@@ -0,0 +1,371 @@
+package main
+
+import (
+	"autumn-2021-intern-assignment/pkg/transaction"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+)
+
+// parseArgs allows flags after the positional arguments, the flag package
+// stops at the first of them.
+func parseArgs(fs *flag.FlagSet, args []string, positional int) ([]string, error) {
+	var pos []string
+	for {
+		err := fs.Parse(args)
+		if err != nil {
+			return nil, err
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			break
+		}
+		pos = append(pos, args[0])
+		args = args[1:]
+	}
+
+	if len(pos) != positional {
+		fs.Usage()
+		return nil, fmt.Errorf("%s needs %d arguments, got %d", fs.Name(), positional, len(pos))
+	}
+
+	return pos, nil
+}
+
+func parseID(s string) (int, error) {
+	id, err := strconv.Atoi(s)
+	if err != nil || id < 0 {
+		return 0, fmt.Errorf("bad account id %q", s)
+	}
+	return id, nil
+}
+
+func parseTime(name, s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("bad -%s, RFC 3339 time expected: %v", name, err)
+	}
+	return t, nil
+}
+
+func balanceCmd(c *client, out *printer, args []string) error {
+	fs := flag.NewFlagSet("balance", flag.ContinueOnError)
+	at := fs.String("at", "", "balance at this time (RFC 3339)")
+	pos, err := parseArgs(fs, args, 1)
+	if err != nil {
+		return err
+	}
+	userID, err := parseID(pos[0])
+	if err != nil {
+		return err
+	}
+
+	if *at != "" {
+		t, err := parseTime("at", *at)
+		if err != nil {
+			return err
+		}
+		snapshot := &transaction.BalanceSnapshot{}
+		err = c.do(http.MethodGet, fmt.Sprintf("/accounts/%d/balance?at=%s", userID,
+			url.QueryEscape(t.Format(time.RFC3339))), nil, snapshot)
+		if err != nil {
+			return err
+		}
+		return out.snapshot(snapshot)
+	}
+
+	acc := &transaction.Account{}
+	err = c.do(http.MethodGet, fmt.Sprintf("/accounts/%d", userID), nil, acc)
+	if err != nil {
+		return err
+	}
+	return out.account(acc)
+}
+
+// historyFilter keeps the operations the flags of history and statement ask for.
+type historyFilter struct {
+	from, to time.Time
+	kind     string
+	min, max float64
+}
+
+func (f *historyFilter) register(fs *flag.FlagSet) (from, to *string) {
+	from = fs.String("from", "", "operations after this time (RFC 3339)")
+	to = fs.String("to", "", "operations up to this time (RFC 3339)")
+	fs.StringVar(&f.kind, "type", "", "deposit, withdraw, transfer, split, refund or fee")
+	fs.Float64Var(&f.min, "min", 0, "smallest amount")
+	fs.Float64Var(&f.max, "max", 0, "largest amount, 0 is no limit")
+	return from, to
+}
+
+func (f *historyFilter) match(tr *transaction.Transaction, userID int) bool {
+	if !f.from.IsZero() && !tr.Created.After(f.from) {
+		return false
+	}
+	if !f.to.IsZero() && tr.Created.After(f.to) {
+		return false
+	}
+	if f.kind != "" && kind(tr) != f.kind {
+		return false
+	}
+	amount := effect(tr, userID)
+	if amount < 0 {
+		amount = -amount
+	}
+	if amount < f.min || (f.max > 0 && amount > f.max) {
+		return false
+	}
+	return true
+}
+
+func getHistory(c *client, userID int, sort string, filter *historyFilter) ([]*transaction.Transaction, error) {
+	history := make([]*transaction.Transaction, 0)
+	err := c.do(http.MethodGet, "/info", &transaction.User{UserID: userID, Field: sort}, &history)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]*transaction.Transaction, 0, len(history))
+	for _, tr := range history {
+		if filter.match(tr, userID) {
+			res = append(res, tr)
+		}
+	}
+
+	return res, nil
+}
+
+func historyCmd(c *client, out *printer, args []string) error {
+	fs := flag.NewFlagSet("history", flag.ContinueOnError)
+	sort := fs.String("sort", "", "order: date or money, by id when empty")
+	filter := &historyFilter{}
+	from, to := filter.register(fs)
+	pos, err := parseArgs(fs, args, 1)
+	if err != nil {
+		return err
+	}
+	userID, err := parseID(pos[0])
+	if err != nil {
+		return err
+	}
+	if filter.from, err = parseTime("from", *from); err != nil {
+		return err
+	}
+	if filter.to, err = parseTime("to", *to); err != nil {
+		return err
+	}
+	if filter.kind != "" && !kinds[filter.kind] {
+		return fmt.Errorf("unknown operation type %q", filter.kind)
+	}
+
+	history, err := getHistory(c, userID, *sort, filter)
+	if err != nil {
+		return err
+	}
+
+	return out.history(history, userID)
+}
+
+func adjust(c *client, out *printer, name string, sign float64, args []string) error {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	reason := fs.String("reason", "", "why the balance is changed, required")
+	pos, err := parseArgs(fs, args, 2)
+	if err != nil {
+		return err
+	}
+	userID, err := parseID(pos[0])
+	if err != nil {
+		return err
+	}
+	money, err := strconv.ParseFloat(pos[1], 64)
+	if err != nil || money <= 0 {
+		return fmt.Errorf("bad amount %q", pos[1])
+	}
+	if *reason == "" {
+		return fmt.Errorf("-reason is required")
+	}
+
+	resp := &struct {
+		Transaction *transaction.Transaction `json:"transaction"`
+	}{}
+	err = c.do(http.MethodPost, "/admin/adjustments", &transaction.Adjustment{
+		UserID: userID,
+		Money:  sign * money,
+		Reason: *reason,
+	}, resp)
+	if err != nil {
+		return err
+	}
+
+	return out.history([]*transaction.Transaction{resp.Transaction}, userID)
+}
+
+func creditCmd(c *client, out *printer, args []string) error {
+	return adjust(c, out, "credit", 1, args)
+}
+
+func debitCmd(c *client, out *printer, args []string) error {
+	return adjust(c, out, "debit", -1, args)
+}
+
+func changeBlocks(c *client, out *printer, name string, args []string) error {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	debit := fs.Bool("debit", false, "debits only")
+	credit := fs.Bool("credit", false, "credits only")
+	pos, err := parseArgs(fs, args, 1)
+	if err != nil {
+		return err
+	}
+	userID, err := parseID(pos[0])
+	if err != nil {
+		return err
+	}
+
+	acc := &transaction.Account{}
+	err = c.do(http.MethodPost, fmt.Sprintf("/accounts/%d/%s", userID, name),
+		&transaction.AccountRequest{Debit: *debit, Credit: *credit}, acc)
+	if err != nil {
+		return err
+	}
+
+	return out.account(acc)
+}
+
+func freezeCmd(c *client, out *printer, args []string) error {
+	return changeBlocks(c, out, "freeze", args)
+}
+
+func unfreezeCmd(c *client, out *printer, args []string) error {
+	return changeBlocks(c, out, "unfreeze", args)
+}
+
+func reconcileCmd(c *client, out *printer, args []string) error {
+	fs := flag.NewFlagSet("reconcile", flag.ContinueOnError)
+	fix := fs.Bool("fix", false, "write correcting entries for the mismatches")
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	run := &transaction.ReconciliationRun{}
+	switch {
+	case fs.NArg() == 1 && fs.Arg(0) == "last":
+		err = c.do(http.MethodGet, "/admin/reconciliation/last", nil, run)
+	case fs.NArg() == 0:
+		path := "/admin/reconciliation"
+		if *fix {
+			path += "?fix=true"
+		}
+		err = c.do(http.MethodPost, path, nil, run)
+	default:
+		return fmt.Errorf("unexpected arguments %v", fs.Args())
+	}
+	if err != nil {
+		return err
+	}
+
+	return out.reconciliation(run)
+}
+
+func statementCmd(c *client, out *printer, args []string) error {
+	fs := flag.NewFlagSet("statement", flag.ContinueOnError)
+	from := fs.String("from", "", "start of the period (RFC 3339), required")
+	to := fs.String("to", "", "end of the period (RFC 3339), now by default")
+	format := fs.String("format", "csv", "csv or json")
+	file := fs.String("out", "", "write to the file instead of stdout")
+	pos, err := parseArgs(fs, args, 1)
+	if err != nil {
+		return err
+	}
+	userID, err := parseID(pos[0])
+	if err != nil {
+		return err
+	}
+	if *format != "csv" && *format != "json" {
+		return fmt.Errorf("unknown statement format %q", *format)
+	}
+
+	filter := &historyFilter{}
+	if filter.from, err = parseTime("from", *from); err != nil {
+		return err
+	}
+	if filter.from.IsZero() {
+		return fmt.Errorf("-from is required")
+	}
+	if filter.to, err = parseTime("to", *to); err != nil {
+		return err
+	}
+	if filter.to.IsZero() {
+		filter.to = time.Now().UTC().Truncate(time.Second)
+	}
+
+	st := &statement{UserID: userID, From: filter.from.UTC(), To: filter.to.UTC()}
+	for _, b := range []struct {
+		at     time.Time
+		result *float64
+	}{{st.From, &st.Opening}, {st.To, &st.Closing}} {
+		snapshot := &transaction.BalanceSnapshot{}
+		err = c.do(http.MethodGet, fmt.Sprintf("/accounts/%d/balance?at=%s", userID,
+			url.QueryEscape(b.at.Format(time.RFC3339Nano))), nil, snapshot)
+		if err != nil {
+			return err
+		}
+		*b.result = snapshot.Balance
+	}
+
+	st.Operations, err = getHistory(c, userID, "date", filter)
+	if err != nil {
+		return err
+	}
+
+	w := out.w
+	if *file != "" {
+		f, err := os.Create(*file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+
+	if *format == "json" {
+		return writeJSON(w, st)
+	}
+	return st.writeCSV(w)
+}
+
+func ratesCmd(c *client, out *printer, args []string) error {
+	fs := flag.NewFlagSet("rates", flag.ContinueOnError)
+	pos, err := parseArgs(fs, args, 2)
+	if err != nil {
+		return err
+	}
+	if pos[0] != "import" {
+		return fmt.Errorf("unknown rates command %q", pos[0])
+	}
+
+	f, err := os.Open(pos[1])
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	rates, err := transaction.ParseRates(f)
+	if err != nil {
+		return fmt.Errorf("%s: %v", pos[1], err)
+	}
+
+	res := &transaction.RateImport{}
+	err = c.do(http.MethodPost, "/admin/rates", rates, res)
+	if err != nil {
+		return err
+	}
+	return out.rateImport(res)
+}
@@ -0,0 +1,93 @@
+// balancectl is the support tool for the balance service. It works through the
+// service API, so every change goes through the same checks as client calls.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+)
+
+const usage = `usage: balancectl [-addr URL] [-key KEY] [-o table|json] <command> [flags] [args]
+
+commands:
+  balance <id> [-at TIME]          current balance or the balance at TIME (RFC 3339)
+  history <id> [filters]           operations of the account
+  credit <id> <money> -reason R    credit the account by hand
+  debit <id> <money> -reason R     debit the account by hand
+  freeze <id> [-debit] [-credit]   block debits and/or credits, both by default
+  unfreeze <id> [-debit] [-credit] remove the blocks
+  reconcile [-fix]                 run the reconciliation
+  reconcile last                   show the last reconciliation
+  statement <id> -from T -to T     statement for the period, -format csv|json
+  rates import <file>              store the daily currency rates of a CSV file
+
+Run "balancectl <command> -h" for the flags of a command.
+`
+
+type command func(c *client, out *printer, args []string) error
+
+var commands = map[string]command{
+	"balance":   balanceCmd,
+	"history":   historyCmd,
+	"credit":    creditCmd,
+	"debit":     debitCmd,
+	"freeze":    freezeCmd,
+	"unfreeze":  unfreezeCmd,
+	"reconcile": reconcileCmd,
+	"statement": statementCmd,
+	"rates":     ratesCmd,
+}
+
+func run(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("balancectl", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprint(stderr, usage)
+	}
+
+	addr := os.Getenv("BALANCE_ADDR")
+	if addr == "" {
+		addr = "http://localhost:8000"
+	}
+	fs.StringVar(&addr, "addr", addr, "address of the service, $BALANCE_ADDR")
+	key := fs.String("key", os.Getenv("BALANCE_KEY"), "api key, an admin one for reconcile and rates, $BALANCE_KEY")
+	output := fs.String("o", "table", "output format: table or json")
+
+	err := fs.Parse(args)
+	if err != nil {
+		return 2
+	}
+	if *output != "table" && *output != "json" {
+		fmt.Fprintf(stderr, "unknown output format %q\n", *output)
+		return 2
+	}
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+	cmd, ok := commands[fs.Arg(0)]
+	if !ok {
+		fmt.Fprintf(stderr, "unknown command %q\n", fs.Arg(0))
+		fs.Usage()
+		return 2
+	}
+
+	out := &printer{w: stdout, json: *output == "json"}
+	err = cmd(newClient(addr, *key), out, fs.Args()[1:])
+	if err == flag.ErrHelp {
+		return 0
+	}
+	if err != nil {
+		fmt.Fprintln(stderr, "error:", err)
+		return 1
+	}
+
+	return 0
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func testServer(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Header.Get("Authorization") != "Bearer admin-key":
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"error": "request needs a valid api key"}`))
+		case r.Method == http.MethodGet && r.URL.Path == "/accounts/1":
+			w.Write([]byte(`{"id": 1, "status": "active", "balance": 120.5, "credit_limit": 0}`))
+		case r.Method == http.MethodGet && r.URL.Path == "/accounts/1/balance":
+			balance := "100"
+			if strings.HasPrefix(r.FormValue("at"), "2021-12") {
+				balance = "130"
+			}
+			w.Write([]byte(`{"id": 1, "at": "` + r.FormValue("at") + `", "balance": ` + balance + `}`))
+		case r.URL.Path == "/info":
+			w.Write([]byte(`[
+				{"id": 1, "to_id": 1, "from_id": null, "money": 100, "created": "2021-10-01T10:00:00Z"},
+				{"id": 2, "to_id": 2, "from_id": 1, "money": 20, "created": "2021-11-02T10:00:00Z"},
+				{"id": 3, "to_id": 1, "from_id": null, "money": 50, "created": "2021-11-03T10:00:00Z"}
+			]`))
+		case r.Method == http.MethodPost && r.URL.Path == "/admin/adjustments":
+			body, _ := ioutil.ReadAll(r.Body)
+			req := map[string]interface{}{}
+			json.Unmarshal(body, &req)
+			if req["reason"] != "compensation" || req["money"] != -15.0 {
+				t.Errorf("unexpected adjustment %s", body)
+			}
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error": "account is debit blocked"}`))
+		case r.Method == http.MethodPost && r.URL.Path == "/admin/rates":
+			body, _ := ioutil.ReadAll(r.Body)
+			if string(body) != `[{"day":"2021-10-29T00:00:00Z","currency":"USD","rate":72.5},`+
+				`{"day":"2021-10-29T00:00:00Z","currency":"EUR","rate":84.1}]` {
+				t.Errorf("unexpected rates %s", body)
+			}
+			w.Write([]byte(`{"imported": 2}`))
+		default:
+			t.Errorf("unexpected request %s %s", r.Method, r.URL)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+}
+
+func TestBalancectl(t *testing.T) {
+	srv := testServer(t)
+	defer srv.Close()
+
+	rates := filepath.Join(t.TempDir(), "rates.csv")
+	err := ioutil.WriteFile(rates, []byte("date,currency,rate\n2021-10-29,USD,72.5\n2021-10-29,EUR,84.1\n"), 0600)
+	if err != nil {
+		t.Fatalf("cant write rates: %s", err)
+	}
+
+	cases := []struct {
+		args   []string
+		code   int
+		stdout string
+		stderr string
+	}{
+		{
+			args:   []string{"balance", "1"},
+			stdout: "ID  STATUS  BALANCE  CREDIT LIMIT  DEBIT BLOCKED  CREDIT BLOCKED\n1   active  120.50   0.00          false          false\n",
+		},
+		{
+			args:   []string{"-o", "json", "balance", "1", "-at", "2021-11-01T00:00:00Z"},
+			stdout: "{\n  \"id\": 1,\n  \"at\": \"2021-11-01T00:00:00Z\",\n  \"balance\": 100\n}\n",
+		},
+		{
+			args: []string{"history", "1", "-type", "transfer"},
+			stdout: "ID  CREATED               TYPE      FROM  TO  MONEY  EFFECT\n" +
+				"2   2021-11-02T10:00:00Z  transfer  1     2   20.00  -20.00\n",
+		},
+		{
+			args: []string{"statement", "1", "-from", "2021-11-01T00:00:00Z", "-to", "2021-12-01T00:00:00Z"},
+			stdout: "id,created,type,amount,balance\n" +
+				",2021-11-01T00:00:00Z,opening,,100.00\n" +
+				"2,2021-11-02T10:00:00Z,transfer,-20.00,80.00\n" +
+				"3,2021-11-03T10:00:00Z,deposit,50.00,130.00\n" +
+				",2021-12-01T00:00:00Z,closing,,130.00\n",
+		},
+		{
+			args:   []string{"debit", "1", "15", "-reason", "compensation"},
+			code:   1,
+			stderr: "error: account is debit blocked (400)\n",
+		},
+		{
+			args:   []string{"credit", "1", "15"},
+			code:   1,
+			stderr: "error: -reason is required\n",
+		},
+		{
+			args:   []string{"rates", "import", rates},
+			stdout: "IMPORTED\n2\n",
+		},
+		{
+			args:   []string{"rates", "import", "missing.csv"},
+			code:   1,
+			stderr: "error: open missing.csv: no such file or directory\n",
+		},
+		{
+			args:   []string{"history", "1", "-type", "bonus"},
+			code:   1,
+			stderr: "error: unknown operation type \"bonus\"\n",
+		},
+	}
+
+	for _, c := range cases {
+		stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+		code := run(append([]string{"-addr", srv.URL, "-key", "admin-key"}, c.args...), stdout, stderr)
+		if code != c.code || stdout.String() != c.stdout || stderr.String() != c.stderr {
+			t.Errorf("%v: want %d %q %q, have %d %q %q", c.args, c.code, c.stdout, c.stderr,
+				code, stdout.String(), stderr.String())
+		}
+	}
+
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	if code := run([]string{"-addr", srv.URL, "balance", "1"}, stdout, stderr); code != 1 ||
+		stderr.String() != "error: request needs a valid api key (401)\n" {
+		t.Errorf("expected the error of the service without a key, got %d %q", code, stderr.String())
+	}
+
+	stdout, stderr = &bytes.Buffer{}, &bytes.Buffer{}
+	if code := run([]string{"transfer"}, stdout, stderr); code != 2 {
+		t.Errorf("expected exit code 2 for unknown command, got %d", code)
+	}
+}
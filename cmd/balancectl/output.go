@@ -0,0 +1,178 @@
+package main
+
+import (
+	"autumn-2021-intern-assignment/pkg/transaction"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"text/tabwriter"
+	"time"
+)
+
+// kinds are the operation types accepted by -type.
+var kinds = map[string]bool{
+	"deposit":  true,
+	"withdraw": true,
+	"transfer": true,
+	"split":    true,
+	"refund":   true,
+	"fee":      true,
+}
+
+// kind tells the type of an operation from its history row.
+func kind(tr *transaction.Transaction) string {
+	switch {
+	case tr.RefundOf != nil:
+		return "refund"
+	case tr.FeeOf != nil:
+		return "fee"
+	case tr.ToID == nil && tr.FromID != nil && tr.Money > 0:
+		return "split"
+	case tr.FromID == nil:
+		return "deposit"
+	case tr.ToID == nil:
+		return "withdraw"
+	default:
+		return "transfer"
+	}
+}
+
+// effect is the change of the balance of userID made by the operation, the
+// same rule the service uses to replay the history.
+func effect(tr *transaction.Transaction, userID int) float64 {
+	switch {
+	case tr.ToID != nil && *tr.ToID == userID:
+		return tr.Money
+	case tr.ToID == nil:
+		return math.Min(tr.Money, 0)
+	default:
+		return -tr.Money
+	}
+}
+
+func writeJSON(w io.Writer, v interface{}) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+func formatMoney(money float64) string {
+	return strconv.FormatFloat(money, 'f', 2, 64)
+}
+
+func formatID(id *int) string {
+	if id == nil {
+		return "-"
+	}
+	return strconv.Itoa(*id)
+}
+
+// printer writes the results of the commands as a table or as JSON.
+type printer struct {
+	w    io.Writer
+	json bool
+}
+
+func (p *printer) table(write func(w io.Writer)) error {
+	tw := tabwriter.NewWriter(p.w, 0, 0, 2, ' ', 0)
+	write(tw)
+	return tw.Flush()
+}
+
+func (p *printer) account(acc *transaction.Account) error {
+	if p.json {
+		return writeJSON(p.w, acc)
+	}
+	return p.table(func(w io.Writer) {
+		fmt.Fprintln(w, "ID\tSTATUS\tBALANCE\tCREDIT LIMIT\tDEBIT BLOCKED\tCREDIT BLOCKED")
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%t\t%t\n", acc.ID, acc.Status, formatMoney(acc.Balance),
+			formatMoney(acc.CreditLimit), acc.DebitBlocked, acc.CreditBlocked)
+	})
+}
+
+func (p *printer) snapshot(s *transaction.BalanceSnapshot) error {
+	if p.json {
+		return writeJSON(p.w, s)
+	}
+	return p.table(func(w io.Writer) {
+		fmt.Fprintln(w, "ID\tAT\tBALANCE")
+		fmt.Fprintf(w, "%d\t%s\t%s\n", s.UserID, s.At.Format(time.RFC3339), formatMoney(s.Balance))
+	})
+}
+
+func (p *printer) history(trs []*transaction.Transaction, userID int) error {
+	if p.json {
+		return writeJSON(p.w, trs)
+	}
+	return p.table(func(w io.Writer) {
+		fmt.Fprintln(w, "ID\tCREATED\tTYPE\tFROM\tTO\tMONEY\tEFFECT")
+		for _, tr := range trs {
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\t%s\n", tr.ID, tr.Created.Format(time.RFC3339), kind(tr),
+				formatID(tr.FromID), formatID(tr.ToID), formatMoney(tr.Money), formatMoney(effect(tr, userID)))
+		}
+	})
+}
+
+func (p *printer) rateImport(res *transaction.RateImport) error {
+	if p.json {
+		return writeJSON(p.w, res)
+	}
+	return p.table(func(w io.Writer) {
+		fmt.Fprintln(w, "IMPORTED")
+		fmt.Fprintf(w, "%d\n", res.Imported)
+	})
+}
+
+func (p *printer) reconciliation(run *transaction.ReconciliationRun) error {
+	if p.json {
+		return writeJSON(p.w, run)
+	}
+	return p.table(func(w io.Writer) {
+		fmt.Fprintf(w, "run %d, %s - %s, fix %t\n", run.ID, run.StartedAt.Format(time.RFC3339),
+			run.FinishedAt.Format(time.RFC3339), run.Fix)
+		fmt.Fprintf(w, "accounts %d, mismatches %d, corrected %d\n", run.Accounts, run.Mismatches, run.Corrected)
+		if len(run.Items) == 0 {
+			return
+		}
+		fmt.Fprintln(w, "ID\tBALANCE\tEXPECTED\tDIFFERENCE\tCORRECTION")
+		for _, m := range run.Items {
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\n", m.UserID, formatMoney(m.Balance), formatMoney(m.Expected),
+				formatMoney(m.Difference), formatID(m.CorrectionID))
+		}
+	})
+}
+
+// statement is the account statement for a period: the balances at both ends
+// and the operations in between.
+type statement struct {
+	UserID     int                        `json:"id"`
+	From       time.Time                  `json:"from"`
+	To         time.Time                  `json:"to"`
+	Opening    float64                    `json:"opening_balance"`
+	Closing    float64                    `json:"closing_balance"`
+	Operations []*transaction.Transaction `json:"operations"`
+}
+
+// writeCSV writes one row per operation with the balance after it, the
+// opening and closing balances go to the first and the last rows.
+func (s *statement) writeCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	records := [][]string{
+		{"id", "created", "type", "amount", "balance"},
+		{"", s.From.Format(time.RFC3339), "opening", "", formatMoney(s.Opening)},
+	}
+
+	balance := s.Opening
+	for _, tr := range s.Operations {
+		amount := effect(tr, s.UserID)
+		balance += amount
+		records = append(records, []string{strconv.Itoa(tr.ID), tr.Created.Format(time.RFC3339), kind(tr),
+			formatMoney(amount), formatMoney(balance)})
+	}
+	records = append(records, []string{"", s.To.Format(time.RFC3339), "closing", "", formatMoney(s.Closing)})
+
+	return cw.WriteAll(records)
+}
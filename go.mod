@@ -3,9 +3,13 @@ module autumn-2021-intern-assignment
 go 1.16
 
 require (
-	github.com/golang/mock v1.6.0 // indirect
-	github.com/gorilla/mux v1.8.0 // indirect
+	github.com/golang/mock v1.6.0
+	github.com/gorilla/mux v1.8.0
 	github.com/lib/pq v1.10.3
-	go.uber.org/zap v1.19.0 // indirect
+	github.com/mattn/go-sqlite3 v1.14.17
+	go.uber.org/zap v1.19.0
+	google.golang.org/genproto v0.0.0-20200526211855-cb27e3aa2013
+	google.golang.org/grpc v1.46.2
+	google.golang.org/protobuf v1.28.0
 	gopkg.in/DATA-DOG/go-sqlmock.v1 v1.3.0
 )
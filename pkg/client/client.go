@@ -0,0 +1,289 @@
+// Package client is the Go client of the balance service. Requests and answers
+// are the structs of the transaction package the handlers use.
+package client
+
+import (
+	"autumn-2021-intern-assignment/pkg/transaction"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// IdempotencyKeyHeader is the header the service reads the idempotency key from.
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// ReadConsistencyHeader is the header that sends a read to the primary.
+const ReadConsistencyHeader = "Read-Consistency"
+
+type Client struct {
+	Addr string
+	// Key is the API key of the client, sent as a bearer token when set.
+	Key  string
+	HTTP *http.Client
+	// Retries is how many times a call is repeated after a network error or a
+	// 5xx or 429 answer, a 429 one not sooner than its Retry-After. Operations
+	// are repeated with the same idempotency key, so they are applied once.
+	Retries int
+	// Backoff is the pause before the first repeat, it doubles with each next.
+	Backoff time.Duration
+}
+
+func New(addr string) *Client {
+	return &Client{
+		Addr:    strings.TrimRight(addr, "/"),
+		HTTP:    &http.Client{Timeout: 30 * time.Second},
+		Retries: 3,
+		Backoff: 100 * time.Millisecond,
+	}
+}
+
+type keyCtx struct{}
+
+// WithIdempotencyKey sets the key of the operation called with ctx, by
+// default every call gets a new random key. A key of your own lets an
+// operation be repeated safely after a restart of the caller.
+func WithIdempotencyKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, keyCtx{}, key)
+}
+
+type strongCtx struct{}
+
+// WithReadYourWrites makes the reads called with ctx see the operations made
+// before them. By default the balance and the history may come from a replica
+// a few seconds behind.
+func WithReadYourWrites(ctx context.Context) context.Context {
+	return context.WithValue(ctx, strongCtx{}, true)
+}
+
+func newIdempotencyKey() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Balance returns the balance of the user, converted to currency when it is
+// not empty at the rates of the day given to ctx by transaction.WithRateDate,
+// of today by default.
+func (c *Client) Balance(ctx context.Context, userID int, currency string) (*transaction.User, error) {
+	path := "/user"
+	if currency != "" {
+		query := url.Values{"currency": {currency}}
+		if day, ok := transaction.RateDate(ctx); ok {
+			query.Set("date", day.Format(transaction.RateDateLayout))
+		}
+		path += "?" + query.Encode()
+	}
+	user := &transaction.User{}
+	err := c.do(ctx, http.MethodGet, path, &transaction.User{UserID: userID}, user)
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+func (c *Client) Deposit(ctx context.Context, userID int, money float64) (*transaction.Transaction, error) {
+	return c.operation(ctx, "/balance/add", &transaction.User{UserID: userID, Balance: money})
+}
+
+// Withdraw pays with the bonuses of the service given to ctx by
+// transaction.WithService first.
+func (c *Client) Withdraw(ctx context.Context, userID int, money float64) (*transaction.Transaction, error) {
+	return c.operation(ctx, "/balance/reduce", &transaction.User{
+		UserID:  userID,
+		Balance: money,
+		Service: transaction.Service(ctx),
+	})
+}
+
+func (c *Client) Transfer(ctx context.Context, fromUserID, toUserID int, money float64) (*transaction.Transaction, error) {
+	return c.operation(ctx, "/balance/transfer", &transaction.User{
+		UserID:   fromUserID,
+		ToUserID: toUserID,
+		Balance:  money,
+	})
+}
+
+// Refund returns money of the transaction, all of it when money is 0.
+func (c *Client) Refund(ctx context.Context, transactionID int, money float64) (*transaction.Transaction, error) {
+	return c.operation(ctx, "/balance/refund", &transaction.User{TransactionID: transactionID, Balance: money})
+}
+
+// History returns the operations of the user ordered by orderBy: "date",
+// "money" or by id when empty.
+func (c *Client) History(ctx context.Context, userID int, orderBy string) ([]*transaction.Transaction, error) {
+	history := make([]*transaction.Transaction, 0)
+	err := c.do(ctx, http.MethodGet, "/info", &transaction.User{UserID: userID, Field: orderBy}, &history)
+	if err != nil {
+		return nil, err
+	}
+	return history, nil
+}
+
+func (c *Client) Transaction(ctx context.Context, transactionID int) (*transaction.Transaction, error) {
+	tr := &transaction.Transaction{}
+	err := c.do(ctx, http.MethodGet, fmt.Sprintf("/transactions/%d", transactionID), nil, tr)
+	if err != nil {
+		return nil, err
+	}
+	return tr, nil
+}
+
+func (c *Client) Account(ctx context.Context, userID int) (*transaction.Account, error) {
+	acc := &transaction.Account{}
+	err := c.do(ctx, http.MethodGet, fmt.Sprintf("/accounts/%d", userID), nil, acc)
+	if err != nil {
+		return nil, err
+	}
+	return acc, nil
+}
+
+func (c *Client) operation(ctx context.Context, path string, req *transaction.User) (*transaction.Transaction, error) {
+	status := &transaction.OperationStatus{}
+	err := c.do(ctx, http.MethodPost, path, req, status)
+	if err != nil {
+		return nil, err
+	}
+	return status.Transaction, nil
+}
+
+// do sends the call and repeats it while the error is temporary.
+func (c *Client) do(ctx context.Context, method, path string, body, result interface{}) error {
+	var data []byte
+	if body != nil {
+		var err error
+		data, err = json.Marshal(body)
+		if err != nil {
+			return err
+		}
+	}
+
+	var key string
+	if method == http.MethodPost {
+		key, _ = ctx.Value(keyCtx{}).(string)
+		if key == "" {
+			var err error
+			key, err = newIdempotencyKey()
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	backoff := c.Backoff
+	for attempt := 0; ; attempt++ {
+		err := c.send(ctx, method, path, key, data, result)
+		if err == nil || attempt >= c.Retries || !temporary(err) {
+			return err
+		}
+
+		wait := backoff
+		var apiErr *Error
+		if errors.As(err, &apiErr) && apiErr.RetryAfter > wait {
+			wait = apiErr.RetryAfter
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+		backoff *= 2
+	}
+}
+
+func (c *Client) send(ctx context.Context, method, path, key string, data []byte, result interface{}) error {
+	var body io.Reader
+	if data != nil {
+		body = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.Addr+path, body)
+	if err != nil {
+		return err
+	}
+	if data != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if key != "" {
+		req.Header.Set(IdempotencyKeyHeader, key)
+	}
+	if c.Key != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Key)
+	}
+	if strong, _ := ctx.Value(strongCtx{}).(bool); strong {
+		req.Header.Set(ReadConsistencyHeader, "strong")
+	}
+
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return &netError{err: err}
+	}
+	defer resp.Body.Close()
+
+	respData, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return &netError{err: err}
+	}
+
+	// 202 is an operation held for review or approval, it is not done
+	if resp.StatusCode >= http.StatusBadRequest || resp.StatusCode == http.StatusAccepted {
+		apiErr := newError(resp.StatusCode, respData)
+		if wait, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && wait > 0 {
+			apiErr.RetryAfter = time.Duration(wait) * time.Second
+		}
+		return apiErr
+	}
+
+	if result == nil {
+		return nil
+	}
+
+	return json.Unmarshal(respData, result)
+}
+
+// netError is a failed exchange with the service, the call may be repeated.
+type netError struct {
+	err error
+}
+
+func (e *netError) Error() string {
+	return e.err.Error()
+}
+
+func (e *netError) Unwrap() error {
+	return e.err
+}
+
+func temporary(err error) bool {
+	var netErr *netError
+	if errors.As(err, &netErr) {
+		return true
+	}
+
+	var apiErr *Error
+	if errors.As(err, &apiErr) {
+		return apiErr.Status >= http.StatusInternalServerError || apiErr.Status == http.StatusTooManyRequests ||
+			apiErr.Code == transaction.ErrorCode(transaction.ErrRequestInProgress)
+	}
+
+	return false
+}
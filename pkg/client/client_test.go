@@ -0,0 +1,279 @@
+package client
+
+import (
+	"autumn-2021-intern-assignment/pkg/handlers"
+	"autumn-2021-intern-assignment/pkg/transaction"
+	"context"
+	"errors"
+	"github.com/golang/mock/gomock"
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// keys keeps idempotency keys in memory instead of Postgres.
+type keys struct {
+	mu      sync.Mutex
+	stored  map[string]*transaction.IdempotentResponse
+	running map[string]bool
+}
+
+func (k *keys) BeginIdempotent(ctx context.Context, client, key, fingerprint string) (*transaction.IdempotentResponse, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if resp, ok := k.stored[key]; ok {
+		return resp, nil
+	}
+	if k.running[key] {
+		return nil, transaction.ErrRequestInProgress
+	}
+	k.running[key] = true
+	return nil, nil
+}
+
+func (k *keys) FinishIdempotent(ctx context.Context, client, key string, resp *transaction.IdempotentResponse) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	delete(k.running, key)
+	k.stored[key] = resp
+	return nil
+}
+
+func (k *keys) ReleaseIdempotent(ctx context.Context, client, key string) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	delete(k.running, key)
+	return nil
+}
+
+// testService runs the handlers of the service, the first failures requests
+// get 503 before reaching them.
+func testService(repo handlers.ItemsRepositoryInterface, failures int) (*httptest.Server, *[]string) {
+	logger := zap.NewNop().Sugar()
+	handler := handlers.ItemsHandler{ItemRepo: repo, Logger: logger}
+	idempotency := handlers.IdempotencyHandler{
+		IdempotencyRepo: &keys{stored: map[string]*transaction.IdempotentResponse{}, running: map[string]bool{}},
+		Logger:          logger,
+	}
+
+	r := mux.NewRouter()
+	r.HandleFunc("/user", handler.GetBalanceFromUser)
+	r.HandleFunc("/balance/add", handler.IncreaseBalance)
+	r.HandleFunc("/balance/reduce", handler.DecreaseBalance)
+	r.HandleFunc("/balance/transfer", handler.TransferBalance)
+	r.HandleFunc("/info", handler.ListTransaction)
+	r.Use(handlers.AuthHandler{Keys: []handlers.APIKey{{Name: "shop", Role: handlers.RoleClient, Key: "shop-key"}},
+		Logger: logger}.Middleware)
+	r.Use(idempotency.Middleware)
+	r.Use(handlers.ReadConsistencyHandler{Logger: logger}.Middleware)
+
+	var seen []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		seen = append(seen, req.Header.Get(IdempotencyKeyHeader))
+		if failures > 0 {
+			failures--
+			http.Error(w, "upstream is restarting", http.StatusServiceUnavailable)
+			return
+		}
+		r.ServeHTTP(w, req)
+	}))
+
+	return srv, &seen
+}
+
+func TestClient(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	// Finish сравнит последовательность вызовов и выведет ошибку если последовательность другая
+	defer ctrl.Finish()
+
+	st := handlers.NewMockItemsRepositoryInterface(ctrl)
+	srv, seen := testService(st, 2)
+	defer srv.Close()
+
+	c := New(srv.URL)
+	c.Key = "shop-key"
+	c.Backoff = time.Millisecond
+	ctx := context.Background()
+
+	// retried with the same key, applied once
+	userID := 1
+	created := time.Date(2021, 11, 27, 10, 0, 0, 0, time.UTC)
+	deposit := &transaction.Transaction{ID: 5, ToID: &userID, Money: 100, Created: created}
+	st.EXPECT().AddMoney(gomock.Any(), 1, 100.0).Return(deposit, nil)
+
+	tr, err := c.Deposit(ctx, 1, 100)
+	if err != nil {
+		t.Errorf("unexpected err: %s", err)
+		return
+	}
+	if !reflect.DeepEqual(tr, deposit) {
+		t.Errorf("results not match, want %v, have %v", deposit, tr)
+		return
+	}
+	if len(*seen) != 3 || (*seen)[0] == "" || (*seen)[0] != (*seen)[1] || (*seen)[1] != (*seen)[2] {
+		t.Errorf("expected 3 attempts with one key, have %v", *seen)
+		return
+	}
+
+	// the same key is not applied twice
+	keyCtx := WithIdempotencyKey(ctx, "order-42")
+	st.EXPECT().AddMoney(gomock.Any(), 1, 50.0).Return(deposit, nil)
+	for i := 0; i < 2; i++ {
+		_, err = c.Deposit(keyCtx, 1, 50)
+		if err != nil {
+			t.Errorf("unexpected err: %s", err)
+			return
+		}
+	}
+
+	// typed errors are not retried
+	*seen = nil
+	st.EXPECT().WithdrawMoney(gomock.Any(), 1, 500.0).Return(nil, transaction.ErrNotEnoughMoney)
+
+	_, err = c.Withdraw(ctx, 1, 500)
+	apiErr := &Error{}
+	if !errors.Is(err, transaction.ErrNotEnoughMoney) || !errors.As(err, &apiErr) ||
+		apiErr.Status != http.StatusBadRequest || apiErr.Code != "not_enough_money" || len(*seen) != 1 {
+		t.Errorf("expected ErrNotEnoughMoney once, got %v after %d calls", err, len(*seen))
+		return
+	}
+
+	st.EXPECT().TransferMoney(gomock.Any(), 1, 2, 10.0).Return(nil, &transaction.LimitError{Limit: transaction.LimitHourlyTransfers})
+
+	_, err = c.Transfer(ctx, 1, 2, 10)
+	limitErr := &transaction.LimitError{}
+	if !errors.Is(err, transaction.ErrLimitExceeded) || !errors.As(err, &limitErr) ||
+		limitErr.Limit != transaction.LimitHourlyTransfers {
+		t.Errorf("expected LimitError, got %v", err)
+		return
+	}
+
+	st.EXPECT().TransferMoney(gomock.Any(), 1, 2, 20000.0).
+		Return(nil, &transaction.RiskError{Decision: transaction.RiskHold, ReviewID: 3})
+
+	_, err = c.Transfer(ctx, 1, 2, 20000)
+	riskErr := &transaction.RiskError{}
+	if !errors.Is(err, transaction.ErrRiskReview) || !errors.As(err, &riskErr) || riskErr.ReviewID != 3 {
+		t.Errorf("expected RiskError, got %v", err)
+		return
+	}
+
+	st.EXPECT().WithdrawMoney(gomock.Any(), 1, 50000.0).DoAndReturn(
+		func(ctx context.Context, userID int, money float64) (*transaction.Transaction, error) {
+			if transaction.Requester(ctx) != "shop" {
+				t.Errorf("expected the requester of the key, have %q", transaction.Requester(ctx))
+			}
+			return nil, &transaction.PendingError{Pending: &transaction.PendingOperation{ID: 4}}
+		})
+
+	_, err = c.Withdraw(ctx, 1, 50000)
+	pendingErr := &transaction.PendingError{}
+	if !errors.Is(err, transaction.ErrApprovalRequired) || !errors.As(err, &pendingErr) || pendingErr.Pending.ID != 4 {
+		t.Errorf("expected PendingError, got %v", err)
+		return
+	}
+
+	// a wrong key is refused
+	*seen = nil
+	anonymous := New(srv.URL)
+	anonymous.Key = "wrong-key"
+	_, err = anonymous.Withdraw(ctx, 1, 50)
+	if !errors.Is(err, transaction.ErrUnauthorized) || len(*seen) != 1 {
+		t.Errorf("expected ErrUnauthorized once, got %v after %d calls", err, len(*seen))
+		return
+	}
+
+	// reads
+	st.EXPECT().GetTransaction(gomock.Any(), 1, "date").Return([]*transaction.Transaction{deposit}, nil)
+
+	history, err := c.History(ctx, 1, "date")
+	if err != nil || len(history) != 1 || history[0].ID != 5 {
+		t.Errorf("unexpected history %v, %v", history, err)
+		return
+	}
+
+	st.EXPECT().GetUsersBalance(gomock.Any(), 1, "USD").Return(&transaction.User{UserID: 1, Balance: 1.35}, nil)
+
+	user, err := c.Balance(ctx, 1, "USD")
+	if err != nil || user.Balance != 1.35 {
+		t.Errorf("unexpected balance %v, %v", user, err)
+		return
+	}
+
+	// converted at the rates of another day
+	day := time.Date(2021, 10, 29, 0, 0, 0, 0, time.UTC)
+	st.EXPECT().GetUsersBalance(gomock.Any(), 1, "USD").DoAndReturn(
+		func(ctx context.Context, userID int, currency string) (*transaction.User, error) {
+			if have, ok := transaction.RateDate(ctx); !ok || !have.Equal(day) {
+				t.Errorf("expected the rates of %v, have %v", day, have)
+			}
+			return &transaction.User{UserID: 1, Balance: 1.4}, nil
+		})
+
+	_, err = c.Balance(transaction.WithRateDate(ctx, day), 1, "USD")
+	if err != nil {
+		t.Errorf("unexpected err: %s", err)
+		return
+	}
+
+	// read-your-writes goes to the primary
+	st.EXPECT().GetUsersBalance(gomock.Any(), 1, "").DoAndReturn(
+		func(ctx context.Context, userID int, currency string) (*transaction.User, error) {
+			if !transaction.PrimaryRead(ctx) {
+				t.Errorf("expected a read of the primary")
+			}
+			return &transaction.User{UserID: 1, Balance: 100}, nil
+		})
+
+	_, err = c.Balance(WithReadYourWrites(ctx), 1, "")
+	if err != nil {
+		t.Errorf("unexpected err: %s", err)
+		return
+	}
+
+	// canceled calls are not sent
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+
+	_, err = c.Deposit(canceled, 1, 100)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+		return
+	}
+}
+
+func TestClientRateLimited(t *testing.T) {
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		calls++
+		w.Header().Set("Retry-After", "3")
+		http.Error(w, `{"error":"too many requests","code":"rate_limited"}`, http.StatusTooManyRequests)
+	}))
+	defer srv.Close()
+
+	c := New(srv.URL)
+	c.Retries = 0
+
+	_, err := c.Balance(context.Background(), 1, "")
+	apiErr := &Error{}
+	if !errors.Is(err, transaction.ErrRateLimited) || !errors.As(err, &apiErr) || apiErr.RetryAfter != 3*time.Second {
+		t.Errorf("expected ErrRateLimited with RetryAfter, got %v", err)
+		return
+	}
+
+	// the wait of Retry-After is longer than the deadline of the call
+	c.Retries = 1
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	_, err = c.Balance(ctx, 1, "")
+	if !errors.Is(err, context.DeadlineExceeded) || calls != 2 {
+		t.Errorf("expected a wait for Retry-After, got %v after %d calls", err, calls)
+	}
+}
@@ -0,0 +1,59 @@
+package client
+
+import (
+	"autumn-2021-intern-assignment/pkg/transaction"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Error is an error answer of the service. It unwraps to the error of the
+// transaction package with the same code, so the callers check it with
+// errors.Is(err, transaction.ErrNotEnoughMoney) and errors.As for
+// *transaction.LimitError, *transaction.RiskError and *transaction.PendingError
+// like inside the service.
+type Error struct {
+	Status  int
+	Code    string
+	Message string
+	Limit   string
+	// ReviewID is the review holding an operation, for risk_review.
+	ReviewID int
+	// PendingID is the operation waiting for approval, for approval_required.
+	PendingID int
+	// RetryAfter is how long a refused call should wait, from the Retry-After
+	// header of a 429 answer.
+	RetryAfter time.Duration
+}
+
+func newError(status int, data []byte) *Error {
+	resp := &transaction.ErrorResponse{}
+	if json.Unmarshal(data, resp) != nil || resp.Error == "" {
+		resp.Error = strings.TrimSpace(string(data))
+		if resp.Error == "" {
+			resp.Error = http.StatusText(status)
+		}
+	}
+
+	return &Error{Status: status, Code: resp.Code, Message: resp.Error, Limit: resp.Limit, ReviewID: resp.ReviewID,
+		PendingID: resp.PendingID}
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s (%d)", e.Message, e.Status)
+}
+
+func (e *Error) Unwrap() error {
+	if e.Limit != "" {
+		return &transaction.LimitError{Limit: e.Limit}
+	}
+	if e.ReviewID != 0 {
+		return &transaction.RiskError{Decision: transaction.RiskHold, ReviewID: e.ReviewID}
+	}
+	if e.PendingID != 0 {
+		return &transaction.PendingError{Pending: &transaction.PendingOperation{ID: e.PendingID}}
+	}
+	return transaction.ErrorByCode(e.Code)
+}
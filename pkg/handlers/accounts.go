@@ -0,0 +1,161 @@
+package handlers
+
+import (
+	"autumn-2021-intern-assignment/pkg/transaction"
+	"context"
+	"fmt"
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+	"net/http"
+	"strconv"
+)
+
+type AccountsRepositoryInterface interface {
+	CreateAccount(ctx context.Context, userID int, externalRef string) (*transaction.Account, error)
+	GetAccount(ctx context.Context, userID int) (*transaction.Account, error)
+	FreezeAccount(ctx context.Context, userID int, debit, credit bool) (*transaction.Account, error)
+	UnfreezeAccount(ctx context.Context, userID int, debit, credit bool) (*transaction.Account, error)
+	CloseAccount(ctx context.Context, userID int) (*transaction.Account, error)
+	SetCreditLimit(ctx context.Context, userID int, limit float64) (*transaction.Account, error)
+	GetOverdraftHistory(ctx context.Context, userID int) ([]*transaction.OverdraftEvent, error)
+}
+
+type AccountsHandler struct {
+	AccountRepo AccountsRepositoryInterface
+	Logger      *zap.SugaredLogger
+}
+
+// mockgen -source=accounts.go -destination=accounts_mock.go -package=handlers AccountsRepositoryInterface
+
+func accountID(r *http.Request) (int, error) {
+	userID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		return 0, fmt.Errorf("bad account id")
+	}
+
+	return userID, nil
+}
+
+func (h AccountsHandler) CreateAccount(w http.ResponseWriter, r *http.Request) {
+	req := &transaction.AccountRequest{}
+	status, err := decodeBody(r, req)
+	if err != nil {
+		sendError(w, r, h.Logger, err, status)
+		return
+	}
+
+	acc, err := h.AccountRepo.CreateAccount(r.Context(), req.UserID, req.ExternalRef)
+	if err != nil {
+		sendError(w, r, h.Logger, err, errorStatus(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	sendData(w, r, h.Logger, acc)
+}
+
+func (h AccountsHandler) GetAccount(w http.ResponseWriter, r *http.Request) {
+	userID, err := accountID(r)
+	if err != nil {
+		sendError(w, r, h.Logger, err, http.StatusBadRequest)
+		return
+	}
+
+	acc, err := h.AccountRepo.GetAccount(r.Context(), userID)
+	if err != nil {
+		sendError(w, r, h.Logger, err, errorStatus(err))
+		return
+	}
+
+	sendData(w, r, h.Logger, acc)
+}
+
+func (h AccountsHandler) FreezeAccount(w http.ResponseWriter, r *http.Request) {
+	h.changeBlocks(w, r, h.AccountRepo.FreezeAccount)
+}
+
+func (h AccountsHandler) UnfreezeAccount(w http.ResponseWriter, r *http.Request) {
+	h.changeBlocks(w, r, h.AccountRepo.UnfreezeAccount)
+}
+
+func (h AccountsHandler) changeBlocks(w http.ResponseWriter, r *http.Request,
+	change func(ctx context.Context, userID int, debit, credit bool) (*transaction.Account, error)) {
+	userID, err := accountID(r)
+	if err != nil {
+		sendError(w, r, h.Logger, err, http.StatusBadRequest)
+		return
+	}
+
+	// an empty body changes both directions
+	req := &transaction.AccountRequest{}
+	if r.ContentLength != 0 {
+		status, err := decodeBody(r, req)
+		if err != nil {
+			sendError(w, r, h.Logger, err, status)
+			return
+		}
+	}
+
+	acc, err := change(r.Context(), userID, req.Debit, req.Credit)
+	if err != nil {
+		sendError(w, r, h.Logger, err, errorStatus(err))
+		return
+	}
+
+	sendData(w, r, h.Logger, acc)
+}
+
+func (h AccountsHandler) CloseAccount(w http.ResponseWriter, r *http.Request) {
+	userID, err := accountID(r)
+	if err != nil {
+		sendError(w, r, h.Logger, err, http.StatusBadRequest)
+		return
+	}
+
+	acc, err := h.AccountRepo.CloseAccount(r.Context(), userID)
+	if err != nil {
+		sendError(w, r, h.Logger, err, errorStatus(err))
+		return
+	}
+
+	sendData(w, r, h.Logger, acc)
+}
+
+func (h AccountsHandler) SetCreditLimit(w http.ResponseWriter, r *http.Request) {
+	userID, err := accountID(r)
+	if err != nil {
+		sendError(w, r, h.Logger, err, http.StatusBadRequest)
+		return
+	}
+
+	req := &transaction.AccountRequest{}
+	status, err := decodeBody(r, req)
+	if err != nil {
+		sendError(w, r, h.Logger, err, status)
+		return
+	}
+
+	acc, err := h.AccountRepo.SetCreditLimit(r.Context(), userID, req.CreditLimit)
+	if err != nil {
+		sendError(w, r, h.Logger, err, errorStatus(err))
+		return
+	}
+
+	sendData(w, r, h.Logger, acc)
+}
+
+func (h AccountsHandler) GetOverdraftHistory(w http.ResponseWriter, r *http.Request) {
+	userID, err := accountID(r)
+	if err != nil {
+		sendError(w, r, h.Logger, err, http.StatusBadRequest)
+		return
+	}
+
+	events, err := h.AccountRepo.GetOverdraftHistory(r.Context(), userID)
+	if err != nil {
+		sendError(w, r, h.Logger, err, errorStatus(err))
+		return
+	}
+
+	sendData(w, r, h.Logger, events)
+}
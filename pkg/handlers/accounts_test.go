@@ -0,0 +1,432 @@
+package handlers
+
+import (
+	"autumn-2021-intern-assignment/pkg/transaction"
+	"encoding/json"
+	"fmt"
+	"github.com/golang/mock/gomock"
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCreateAccount(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	// Finish сравнит последовательность вызовов и выведет ошибку если последовательность другая
+	defer ctrl.Finish()
+
+	st := NewMockAccountsRepositoryInterface(ctrl)
+
+	service := &AccountsHandler{
+		AccountRepo: st,
+		Logger:      zap.NewNop().Sugar(), // не пишет логи
+	}
+
+	ref := "contract-1"
+	resultItem := &transaction.Account{
+		ID:          1,
+		ExternalRef: &ref,
+		Status:      transaction.AccountActive,
+		Currencies:  []string{transaction.BaseCurrency},
+		CreatedAt:   time.Now().UTC().Truncate(time.Second),
+	}
+
+	st.EXPECT().CreateAccount(gomock.Any(), 1, ref).Return(resultItem, nil)
+
+	req := httptest.NewRequest("POST", "/accounts", strings.NewReader(`{"id": 1, "external_ref": "contract-1"}`))
+	w := httptest.NewRecorder()
+	service.CreateAccount(w, req)
+
+	resp := w.Result()
+	//nolint:errcheck
+	defer resp.Body.Close()
+
+	//nolint:errcheck
+	body, _ := ioutil.ReadAll(resp.Body)
+
+	if resp.StatusCode != http.StatusCreated {
+		t.Errorf("expected resp status 201, got %d", resp.StatusCode)
+		return
+	}
+
+	acc := &transaction.Account{}
+	err := json.Unmarshal(body, acc)
+	if err != nil || !reflect.DeepEqual(acc, resultItem) {
+		t.Errorf("results not match, want %v, have %v", resultItem, acc)
+		return
+	}
+
+	// marshaling error
+
+	req = httptest.NewRequest("POST", "/accounts", strings.NewReader("mess1111ag11e:1qq11111powei"))
+	w = httptest.NewRecorder()
+	service.CreateAccount(w, req)
+
+	resp = w.Result()
+	//nolint:errcheck
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected resp status 400, got %d", resp.StatusCode)
+		return
+	}
+
+	// already exists
+
+	st.EXPECT().CreateAccount(gomock.Any(), 1, "").Return(nil, transaction.ErrAccountExists)
+	req = httptest.NewRequest("POST", "/accounts", strings.NewReader(`{"id": 1}`))
+	w = httptest.NewRecorder()
+	service.CreateAccount(w, req)
+
+	resp = w.Result()
+	//nolint:errcheck
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusConflict {
+		t.Errorf("expected resp status 409, got %d", resp.StatusCode)
+		return
+	}
+}
+
+func TestGetAccount(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	// Finish сравнит последовательность вызовов и выведет ошибку если последовательность другая
+	defer ctrl.Finish()
+
+	st := NewMockAccountsRepositoryInterface(ctrl)
+
+	service := &AccountsHandler{
+		AccountRepo: st,
+		Logger:      zap.NewNop().Sugar(), // не пишет логи
+	}
+
+	resultItem := &transaction.Account{
+		ID:         1,
+		Status:     transaction.AccountActive,
+		Balance:    50,
+		Currencies: []string{transaction.BaseCurrency},
+		CreatedAt:  time.Now().UTC().Truncate(time.Second),
+	}
+
+	st.EXPECT().GetAccount(gomock.Any(), 1).Return(resultItem, nil)
+
+	req := httptest.NewRequest("GET", "/accounts/1", nil)
+	req = mux.SetURLVars(req, map[string]string{"id": "1"})
+	w := httptest.NewRecorder()
+	service.GetAccount(w, req)
+
+	resp := w.Result()
+	//nolint:errcheck
+	defer resp.Body.Close()
+
+	//nolint:errcheck
+	body, _ := ioutil.ReadAll(resp.Body)
+
+	acc := &transaction.Account{}
+	err := json.Unmarshal(body, acc)
+	if err != nil || !reflect.DeepEqual(acc, resultItem) {
+		t.Errorf("results not match, want %v, have %v", resultItem, acc)
+		return
+	}
+
+	// not found
+
+	st.EXPECT().GetAccount(gomock.Any(), 2).Return(nil, transaction.ErrAccountNotFound)
+	req = httptest.NewRequest("GET", "/accounts/2", nil)
+	req = mux.SetURLVars(req, map[string]string{"id": "2"})
+	w = httptest.NewRecorder()
+	service.GetAccount(w, req)
+
+	resp = w.Result()
+	//nolint:errcheck
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expected resp status 404, got %d", resp.StatusCode)
+		return
+	}
+
+	// bad id
+
+	req = httptest.NewRequest("GET", "/accounts/abc", nil)
+	req = mux.SetURLVars(req, map[string]string{"id": "abc"})
+	w = httptest.NewRecorder()
+	service.GetAccount(w, req)
+
+	resp = w.Result()
+	//nolint:errcheck
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected resp status 400, got %d", resp.StatusCode)
+		return
+	}
+}
+
+func TestFreezeAccount(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	// Finish сравнит последовательность вызовов и выведет ошибку если последовательность другая
+	defer ctrl.Finish()
+
+	st := NewMockAccountsRepositoryInterface(ctrl)
+
+	service := &AccountsHandler{
+		AccountRepo: st,
+		Logger:      zap.NewNop().Sugar(), // не пишет логи
+	}
+
+	resultItem := &transaction.Account{
+		ID:           1,
+		Status:       transaction.AccountFrozen,
+		DebitBlocked: true,
+	}
+
+	// only debits
+	st.EXPECT().FreezeAccount(gomock.Any(), 1, true, false).Return(resultItem, nil)
+
+	req := httptest.NewRequest("POST", "/accounts/1/freeze", strings.NewReader(`{"debit": true}`))
+	req = mux.SetURLVars(req, map[string]string{"id": "1"})
+	w := httptest.NewRecorder()
+	service.FreezeAccount(w, req)
+
+	resp := w.Result()
+	//nolint:errcheck
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("expected resp status 200, got %d", resp.StatusCode)
+		return
+	}
+
+	// empty body
+	st.EXPECT().UnfreezeAccount(gomock.Any(), 1, false, false).Return(resultItem, nil)
+
+	req = httptest.NewRequest("POST", "/accounts/1/unfreeze", nil)
+	req = mux.SetURLVars(req, map[string]string{"id": "1"})
+	w = httptest.NewRecorder()
+	service.UnfreezeAccount(w, req)
+
+	resp = w.Result()
+	//nolint:errcheck
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("expected resp status 200, got %d", resp.StatusCode)
+		return
+	}
+
+	// closed account
+	st.EXPECT().FreezeAccount(gomock.Any(), 1, false, false).Return(nil, transaction.ErrAccountClosed)
+
+	req = httptest.NewRequest("POST", "/accounts/1/freeze", nil)
+	req = mux.SetURLVars(req, map[string]string{"id": "1"})
+	w = httptest.NewRecorder()
+	service.FreezeAccount(w, req)
+
+	resp = w.Result()
+	//nolint:errcheck
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusConflict {
+		t.Errorf("expected resp status 409, got %d", resp.StatusCode)
+		return
+	}
+}
+
+func TestCloseAccount(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	// Finish сравнит последовательность вызовов и выведет ошибку если последовательность другая
+	defer ctrl.Finish()
+
+	st := NewMockAccountsRepositoryInterface(ctrl)
+
+	service := &AccountsHandler{
+		AccountRepo: st,
+		Logger:      zap.NewNop().Sugar(), // не пишет логи
+	}
+
+	st.EXPECT().CloseAccount(gomock.Any(), 1).Return(&transaction.Account{ID: 1, Status: transaction.AccountClosed}, nil)
+
+	req := httptest.NewRequest("POST", "/accounts/1/close", nil)
+	req = mux.SetURLVars(req, map[string]string{"id": "1"})
+	w := httptest.NewRecorder()
+	service.CloseAccount(w, req)
+
+	resp := w.Result()
+	//nolint:errcheck
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("expected resp status 200, got %d", resp.StatusCode)
+		return
+	}
+
+	// money left
+	st.EXPECT().CloseAccount(gomock.Any(), 1).Return(nil, transaction.ErrAccountNotEmpty)
+
+	req = httptest.NewRequest("POST", "/accounts/1/close", nil)
+	req = mux.SetURLVars(req, map[string]string{"id": "1"})
+	w = httptest.NewRecorder()
+	service.CloseAccount(w, req)
+
+	resp = w.Result()
+	//nolint:errcheck
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusConflict {
+		t.Errorf("expected resp status 409, got %d", resp.StatusCode)
+		return
+	}
+
+	// result error
+	st.EXPECT().CloseAccount(gomock.Any(), 1).Return(nil, fmt.Errorf("bad result"))
+
+	req = httptest.NewRequest("POST", "/accounts/1/close", nil)
+	req = mux.SetURLVars(req, map[string]string{"id": "1"})
+	w = httptest.NewRecorder()
+	service.CloseAccount(w, req)
+
+	resp = w.Result()
+	//nolint:errcheck
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 500 {
+		t.Errorf("expected resp status 500, got %d", resp.StatusCode)
+		return
+	}
+}
+
+func TestSetCreditLimit(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	// Finish сравнит последовательность вызовов и выведет ошибку если последовательность другая
+	defer ctrl.Finish()
+
+	st := NewMockAccountsRepositoryInterface(ctrl)
+
+	service := &AccountsHandler{
+		AccountRepo: st,
+		Logger:      zap.NewNop().Sugar(), // не пишет логи
+	}
+
+	resultItem := &transaction.Account{ID: 1, Status: transaction.AccountActive, CreditLimit: 500}
+	st.EXPECT().SetCreditLimit(gomock.Any(), 1, 500.0).Return(resultItem, nil)
+
+	req := httptest.NewRequest("POST", "/accounts/1/credit-limit", strings.NewReader(`{"credit_limit": 500}`))
+	req = mux.SetURLVars(req, map[string]string{"id": "1"})
+	w := httptest.NewRecorder()
+	service.SetCreditLimit(w, req)
+
+	resp := w.Result()
+	//nolint:errcheck
+	defer resp.Body.Close()
+
+	//nolint:errcheck
+	body, _ := ioutil.ReadAll(resp.Body)
+
+	acc := &transaction.Account{}
+	err := json.Unmarshal(body, acc)
+	if err != nil || !reflect.DeepEqual(acc, resultItem) {
+		t.Errorf("results not match, want %v, have %v", resultItem, acc)
+		return
+	}
+
+	// negative limit
+	st.EXPECT().SetCreditLimit(gomock.Any(), 1, -1.0).Return(nil, transaction.ErrNegativeAmount)
+
+	req = httptest.NewRequest("POST", "/accounts/1/credit-limit", strings.NewReader(`{"credit_limit": -1}`))
+	req = mux.SetURLVars(req, map[string]string{"id": "1"})
+	w = httptest.NewRecorder()
+	service.SetCreditLimit(w, req)
+
+	resp = w.Result()
+	//nolint:errcheck
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected resp status 400, got %d", resp.StatusCode)
+		return
+	}
+
+	// marshaling error
+
+	req = httptest.NewRequest("POST", "/accounts/1/credit-limit", strings.NewReader("mess1111ag11e:1qq11111powei"))
+	req = mux.SetURLVars(req, map[string]string{"id": "1"})
+	w = httptest.NewRecorder()
+	service.SetCreditLimit(w, req)
+
+	resp = w.Result()
+	//nolint:errcheck
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected resp status 400, got %d", resp.StatusCode)
+		return
+	}
+}
+
+func TestGetOverdraftHistory(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	// Finish сравнит последовательность вызовов и выведет ошибку если последовательность другая
+	defer ctrl.Finish()
+
+	st := NewMockAccountsRepositoryInterface(ctrl)
+
+	service := &AccountsHandler{
+		AccountRepo: st,
+		Logger:      zap.NewNop().Sugar(), // не пишет логи
+	}
+
+	resultItems := []*transaction.OverdraftEvent{
+		{ID: 1, UserID: 1, Event: transaction.OverdraftEntered, Balance: -40,
+			Created: time.Now().UTC().Truncate(time.Second)},
+	}
+	st.EXPECT().GetOverdraftHistory(gomock.Any(), 1).Return(resultItems, nil)
+
+	req := httptest.NewRequest("GET", "/accounts/1/overdraft", nil)
+	req = mux.SetURLVars(req, map[string]string{"id": "1"})
+	w := httptest.NewRecorder()
+	service.GetOverdraftHistory(w, req)
+
+	resp := w.Result()
+	//nolint:errcheck
+	defer resp.Body.Close()
+
+	//nolint:errcheck
+	body, _ := ioutil.ReadAll(resp.Body)
+
+	events := []*transaction.OverdraftEvent{}
+	err := json.Unmarshal(body, &events)
+	if err != nil || !reflect.DeepEqual(events, resultItems) {
+		t.Errorf("results not match, want %v, have %v", resultItems, events)
+		return
+	}
+
+	// result error
+	st.EXPECT().GetOverdraftHistory(gomock.Any(), 1).Return(nil, fmt.Errorf("bad result"))
+
+	req = httptest.NewRequest("GET", "/accounts/1/overdraft", nil)
+	req = mux.SetURLVars(req, map[string]string{"id": "1"})
+	w = httptest.NewRecorder()
+	service.GetOverdraftHistory(w, req)
+
+	resp = w.Result()
+	//nolint:errcheck
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 500 {
+		t.Errorf("expected resp status 500, got %d", resp.StatusCode)
+		return
+	}
+}
@@ -0,0 +1,45 @@
+package handlers
+
+import (
+	"autumn-2021-intern-assignment/pkg/transaction"
+	"context"
+	"go.uber.org/zap"
+	"net/http"
+)
+
+type AdjustmentsRepositoryInterface interface {
+	Adjust(ctx context.Context, req *transaction.Adjustment) (*transaction.Transaction, error)
+}
+
+type AdjustmentsHandler struct {
+	AdjustmentRepo AdjustmentsRepositoryInterface
+	Logger         *zap.SugaredLogger
+}
+
+// mockgen -source=adjustments.go -destination=adjustments_mock.go -package=handlers AdjustmentsRepositoryInterface
+
+func (h AdjustmentsHandler) Adjust(w http.ResponseWriter, r *http.Request) {
+	req := &transaction.Adjustment{}
+	status, err := decodeBody(r, req)
+	if err != nil {
+		sendError(w, r, h.Logger, err, status)
+		return
+	}
+	// the author is the admin key set by AuthHandler, not the body
+	req.Author = transaction.Requester(r.Context())
+
+	tr, err := h.AdjustmentRepo.Adjust(r.Context(), req)
+	if err != nil {
+		sendError(w, r, h.Logger, err, errorStatus(err))
+		return
+	}
+
+	h.Logger.Infow("Manual adjustment",
+		"account", req.UserID,
+		"money", req.Money,
+		"author", req.Author,
+		"reason", req.Reason,
+		"transaction", tr.ID,
+	)
+	sendSuccessStatus(w, r, h.Logger, tr)
+}
@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"autumn-2021-intern-assignment/pkg/transaction"
+	"encoding/json"
+	"github.com/golang/mock/gomock"
+	"go.uber.org/zap"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAdjust(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	// Finish сравнит последовательность вызовов и выведет ошибку если последовательность другая
+	defer ctrl.Finish()
+
+	st := NewMockAdjustmentsRepositoryInterface(ctrl)
+
+	service := &AdjustmentsHandler{
+		AdjustmentRepo: st,
+		Logger:         zap.NewNop().Sugar(), // не пишет логи
+	}
+
+	elemID := 1
+	balance := 70.0
+	resultItem := &transaction.Transaction{ID: 6, ToID: &elemID, Money: -30, Balance: &balance}
+
+	// the author is the name of the admin key, the body can't set it
+	st.EXPECT().Adjust(gomock.Any(), &transaction.Adjustment{UserID: 1, Money: -30, Reason: "duplicate credit", Author: "alice"}).
+		Return(resultItem, nil)
+
+	req := httptest.NewRequest("POST", "/admin/adjustments",
+		strings.NewReader(`{"id": 1, "money": -30, "reason": "duplicate credit", "author": "support"}`))
+	req = req.WithContext(transaction.WithRequester(req.Context(), "alice"))
+	w := httptest.NewRecorder()
+	service.Adjust(w, req)
+
+	resp := w.Result()
+	//nolint:errcheck
+	defer resp.Body.Close()
+
+	//nolint:errcheck
+	body, _ := ioutil.ReadAll(resp.Body)
+
+	data := &struct {
+		Status      string                   `json:"status"`
+		Transaction *transaction.Transaction `json:"transaction"`
+	}{}
+	err := json.Unmarshal(body, data)
+	if err != nil || data.Status != "success" || data.Transaction.ID != 6 {
+		t.Errorf("unexpected response: %s", body)
+		return
+	}
+
+	// no reason
+
+	st.EXPECT().Adjust(gomock.Any(), gomock.Any()).Return(nil, transaction.ErrBadAdjustment)
+	req = httptest.NewRequest("POST", "/admin/adjustments", strings.NewReader(`{"id": 1, "money": -30}`))
+	w = httptest.NewRecorder()
+	service.Adjust(w, req)
+
+	resp = w.Result()
+	//nolint:errcheck
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected resp status 400, got %d", resp.StatusCode)
+		return
+	}
+}
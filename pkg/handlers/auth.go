@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"autumn-2021-intern-assignment/pkg/transaction"
+	"crypto/subtle"
+	"fmt"
+	"go.uber.org/zap"
+	"net/http"
+	"strings"
+)
+
+// Roles of the API keys, an admin key opens the /admin routes too.
+const (
+	RoleClient = "client"
+	RoleAdmin  = "admin"
+)
+
+// APIKey is the secret Key of the API client Name.
+type APIKey struct {
+	Name string
+	Role string
+	Key  string
+}
+
+type AuthHandler struct {
+	Keys   []APIKey
+	Logger *zap.SugaredLogger
+}
+
+// publicRoutes are open without a key.
+var publicRoutes = map[string]bool{
+	"/openapi.json": true,
+	"/docs":         true,
+}
+
+// ParseAPIKeys reads the keys separated by ";", each of them is
+// "<name> <client|admin> <key>", like "shop client s3cret; alice admin t0ps3cret".
+func ParseAPIKeys(s string) ([]APIKey, error) {
+	keys := make([]APIKey, 0)
+	names := make(map[string]bool)
+	for _, item := range strings.Split(s, ";") {
+		fields := strings.Fields(item)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 3 || (fields[1] != RoleClient && fields[1] != RoleAdmin) || names[fields[0]] {
+			// the key itself is not shown
+			return nil, fmt.Errorf("bad api key of %q", fields[0])
+		}
+		names[fields[0]] = true
+
+		keys = append(keys, APIKey{Name: fields[0], Role: fields[1], Key: fields[2]})
+	}
+
+	return keys, nil
+}
+
+// authenticate finds the key of the "Authorization: Bearer <key>" header.
+func (h AuthHandler) authenticate(r *http.Request) (*APIKey, bool) {
+	return h.findKey(r.Header.Get("Authorization"))
+}
+
+// findKey finds the key of the "Bearer <key>" authorization header.
+func (h AuthHandler) findKey(header string) (*APIKey, bool) {
+	if !strings.HasPrefix(header, "Bearer ") {
+		return nil, false
+	}
+	secret := []byte(strings.TrimPrefix(header, "Bearer "))
+
+	var found *APIKey
+	for i := range h.Keys {
+		// every key is compared, the time doesn't tell which one matched
+		if subtle.ConstantTimeCompare(secret, []byte(h.Keys[i].Key)) == 1 {
+			found = &h.Keys[i]
+		}
+	}
+	return found, found != nil
+}
+
+// Middleware names the client of the request by its API key, the operations
+// it asks for are requested by that name and the pending ones it decides on
+// are decided by it. The /admin routes need an admin key and are closed
+// without configured keys, the other routes need a key once any is configured.
+// A wrong key is refused everywhere.
+func (h AuthHandler) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key, ok := h.authenticate(r)
+		admin := r.URL.Path == "/admin" || strings.HasPrefix(r.URL.Path, "/admin/")
+
+		var err error
+		switch {
+		case !ok && (r.Header.Get("Authorization") != "" || admin || (len(h.Keys) > 0 && !publicRoutes[r.URL.Path])):
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			err = transaction.ErrUnauthorized
+		case admin && key.Role != RoleAdmin:
+			err = transaction.ErrForbidden
+		}
+		if err != nil {
+			sendError(w, r, h.Logger, err, errorStatus(err))
+			return
+		}
+
+		if ok {
+			r = r.WithContext(transaction.WithRequester(r.Context(), key.Name))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,83 @@
+package handlers
+
+import (
+	"autumn-2021-intern-assignment/pkg/transaction"
+	"go.uber.org/zap"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestParseAPIKeys(t *testing.T) {
+	keys, err := ParseAPIKeys("shop client s3cret; alice admin t0ps3cret;")
+	if err != nil {
+		t.Errorf("unexpected err: %s", err)
+		return
+	}
+	expect := []APIKey{
+		{Name: "shop", Role: RoleClient, Key: "s3cret"},
+		{Name: "alice", Role: RoleAdmin, Key: "t0ps3cret"},
+	}
+	if !reflect.DeepEqual(keys, expect) {
+		t.Errorf("results not match, want %v, have %v", expect, keys)
+		return
+	}
+
+	for _, bad := range []string{"shop client", "shop root s3cret", "shop client a; shop admin b"} {
+		_, err = ParseAPIKeys(bad)
+		if err == nil {
+			t.Errorf("%q: expected error, got nil", bad)
+		}
+	}
+}
+
+func TestAuthMiddleware(t *testing.T) {
+	keys := []APIKey{
+		{Name: "shop", Role: RoleClient, Key: "shop-key"},
+		{Name: "alice", Role: RoleAdmin, Key: "alice-key"},
+	}
+
+	for _, c := range []struct {
+		keys      []APIKey
+		path      string
+		key       string
+		status    int
+		requester string
+	}{
+		{keys, "/balance/reduce", "shop-key", http.StatusOK, "shop"},
+		{keys, "/balance/reduce", "alice-key", http.StatusOK, "alice"},
+		{keys, "/balance/reduce", "", http.StatusUnauthorized, ""},
+		{keys, "/balance/reduce", "wrong", http.StatusUnauthorized, ""},
+		{keys, "/admin/pending", "alice-key", http.StatusOK, "alice"},
+		{keys, "/admin/pending", "shop-key", http.StatusForbidden, ""},
+		{keys, "/admin/pending", "", http.StatusUnauthorized, ""},
+		{keys, "/openapi.json", "", http.StatusOK, ""},
+		// without keys only the admin routes are closed
+		{nil, "/balance/reduce", "", http.StatusOK, ""},
+		{nil, "/admin/pending", "", http.StatusUnauthorized, ""},
+		{nil, "/balance/reduce", "any", http.StatusUnauthorized, ""},
+	} {
+		auth := AuthHandler{Keys: c.keys, Logger: zap.NewNop().Sugar()}
+		requester := ""
+		next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requester = transaction.Requester(r.Context())
+		})
+
+		req := httptest.NewRequest(http.MethodGet, c.path, nil)
+		if c.key != "" {
+			req.Header.Set("Authorization", "Bearer "+c.key)
+		}
+		// a claimed client id names nobody
+		req.Header.Set("X-Client-ID", "alice")
+		w := httptest.NewRecorder()
+		auth.Middleware(next).ServeHTTP(w, req)
+
+		if w.Code != c.status || requester != c.requester {
+			t.Errorf("%s with %q: unexpected answer %d, requester %q", c.path, c.key, w.Code, requester)
+		}
+		if w.Code == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") != "Bearer" {
+			t.Errorf("%s with %q: no WWW-Authenticate header", c.path, c.key)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"autumn-2021-intern-assignment/pkg/transaction"
+	"context"
+	"encoding/json"
+	"errors"
+	"go.uber.org/zap"
+	"io"
+	"mime"
+	"net/http"
+)
+
+// MaxBatchBodySize limits the body of a batch request.
+const MaxBatchBodySize = 16 << 20
+
+type BatchRepositoryInterface interface {
+	ApplyBatch(ctx context.Context, items []*transaction.BatchItem, atomic bool) ([]*transaction.BatchResult, error)
+}
+
+type BatchHandler struct {
+	BatchRepo BatchRepositoryInterface
+	Logger    *zap.SugaredLogger
+}
+
+// mockgen -source=batch.go -destination=batch_mock.go -package=handlers BatchRepositoryInterface
+
+// bodyLimiter reads at most left bytes of a body, reading more fails with
+// ErrBatchTooLarge.
+type bodyLimiter struct {
+	io.Closer
+	body io.Reader
+	left int64
+}
+
+func limitBody(body io.ReadCloser, limit int64) io.ReadCloser {
+	return &bodyLimiter{Closer: body, body: io.LimitReader(body, limit+1), left: limit}
+}
+
+func (l *bodyLimiter) Read(p []byte) (int, error) {
+	n, err := l.body.Read(p)
+	l.left -= int64(n)
+	if l.left < 0 {
+		return n, transaction.ErrBatchTooLarge
+	}
+	return n, err
+}
+
+// decodeLines reads one operation per line, stopping as soon as the batch is too large.
+func decodeLines(body io.Reader) ([]*transaction.BatchItem, error) {
+	items := make([]*transaction.BatchItem, 0)
+	dec := json.NewDecoder(body)
+	for {
+		item := &transaction.BatchItem{}
+		err := dec.Decode(item)
+		if err == io.EOF {
+			return items, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		items = append(items, item)
+		if len(items) > transaction.MaxBatchSize {
+			return nil, transaction.ErrBatchTooLarge
+		}
+	}
+}
+
+func (h BatchHandler) ApplyBatch(w http.ResponseWriter, r *http.Request) {
+	r.Body = limitBody(r.Body, MaxBatchBodySize)
+
+	req := &transaction.BatchRequest{}
+	var err error
+	status := http.StatusBadRequest
+
+	contentType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch contentType {
+	case "application/x-ndjson", "application/jsonl":
+		defer r.Body.Close()
+		req.Atomic = r.URL.Query().Get("atomic") == "true"
+		req.Items, err = decodeLines(r.Body)
+	default:
+		status, err = decodeBody(r, req)
+	}
+	if errors.Is(err, transaction.ErrBatchTooLarge) {
+		sendError(w, r, h.Logger, transaction.ErrBatchTooLarge, http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		sendError(w, r, h.Logger, err, status)
+		return
+	}
+
+	results, err := h.BatchRepo.ApplyBatch(r.Context(), req.Items, req.Atomic)
+	if err != nil && !errors.Is(err, transaction.ErrBatchFailed) {
+		sendError(w, r, h.Logger, err, errorStatus(err))
+		return
+	}
+
+	resp := map[string]interface{}{"status": "success", "results": results}
+	if err != nil {
+		resp["status"] = "failed"
+		resp["error"] = err.Error()
+		w.WriteHeader(http.StatusUnprocessableEntity)
+	} else {
+		for _, res := range results {
+			if res.Status != transaction.BatchItemSuccess {
+				resp["status"] = "partial"
+				break
+			}
+		}
+	}
+
+	sendData(w, r, h.Logger, resp)
+}
@@ -0,0 +1,185 @@
+package handlers
+
+import (
+	"autumn-2021-intern-assignment/pkg/transaction"
+	"encoding/json"
+	"fmt"
+	"github.com/golang/mock/gomock"
+	"go.uber.org/zap"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type batchResponse struct {
+	Status  string                     `json:"status"`
+	Results []*transaction.BatchResult `json:"results"`
+}
+
+func TestApplyBatch(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	// Finish сравнит последовательность вызовов и выведет ошибку если последовательность другая
+	defer ctrl.Finish()
+
+	st := NewMockBatchRepositoryInterface(ctrl)
+
+	service := &BatchHandler{
+		BatchRepo: st,
+		Logger:    zap.NewNop().Sugar(), // не пишет логи
+	}
+
+	items := []*transaction.BatchItem{
+		{Operation: transaction.OperationDeposit, UserID: 1, Money: 100},
+		{Operation: transaction.OperationTransfer, UserID: 1, ToUserID: 2, Money: 50},
+	}
+	results := []*transaction.BatchResult{
+		{Index: 0, Status: transaction.BatchItemSuccess, Transaction: &transaction.Transaction{ID: 1}},
+		{Index: 1, Status: transaction.BatchItemSuccess, Transaction: &transaction.Transaction{ID: 2}},
+	}
+
+	st.EXPECT().ApplyBatch(gomock.Any(), items, true).Return(results, nil)
+
+	req := httptest.NewRequest("POST", "/balance/batch", strings.NewReader(`{"atomic": true, "items": [`+
+		`{"operation": "deposit", "id": 1, "money": 100}, {"operation": "transfer", "id": 1, "id_to": 2, "money": 50}]}`))
+	w := httptest.NewRecorder()
+	service.ApplyBatch(w, req)
+
+	resp := w.Result()
+	//nolint:errcheck
+	defer resp.Body.Close()
+
+	//nolint:errcheck
+	body, _ := ioutil.ReadAll(resp.Body)
+
+	data := &batchResponse{}
+	err := json.Unmarshal(body, data)
+	if err != nil || resp.StatusCode != http.StatusOK || data.Status != "success" || len(data.Results) != 2 {
+		t.Errorf("unexpected response %d: %s", resp.StatusCode, body)
+		return
+	}
+
+	// json lines, best effort
+
+	results = []*transaction.BatchResult{
+		{Index: 0, Status: transaction.BatchItemSuccess, Transaction: &transaction.Transaction{ID: 1}},
+		{Index: 1, Status: transaction.BatchItemError, Error: transaction.ErrNotEnoughMoney.Error()},
+	}
+	st.EXPECT().ApplyBatch(gomock.Any(), items, false).Return(results, nil)
+
+	req = httptest.NewRequest("POST", "/balance/batch", strings.NewReader(
+		`{"operation": "deposit", "id": 1, "money": 100}`+"\n"+`{"operation": "transfer", "id": 1, "id_to": 2, "money": 50}`+"\n"))
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	w = httptest.NewRecorder()
+	service.ApplyBatch(w, req)
+
+	resp = w.Result()
+	//nolint:errcheck
+	defer resp.Body.Close()
+
+	//nolint:errcheck
+	body, _ = ioutil.ReadAll(resp.Body)
+
+	data = &batchResponse{}
+	err = json.Unmarshal(body, data)
+	if err != nil || resp.StatusCode != http.StatusOK || data.Status != "partial" {
+		t.Errorf("unexpected response %d: %s", resp.StatusCode, body)
+		return
+	}
+
+	// atomic batch failed
+
+	results[0] = &transaction.BatchResult{Index: 0, Status: transaction.BatchItemNotApplied}
+	st.EXPECT().ApplyBatch(gomock.Any(), items, true).Return(results, transaction.ErrBatchFailed)
+
+	req = httptest.NewRequest("POST", "/balance/batch?atomic=true", strings.NewReader(
+		`{"operation": "deposit", "id": 1, "money": 100}`+"\n"+`{"operation": "transfer", "id": 1, "id_to": 2, "money": 50}`))
+	req.Header.Set("Content-Type", "application/jsonl")
+	w = httptest.NewRecorder()
+	service.ApplyBatch(w, req)
+
+	resp = w.Result()
+	//nolint:errcheck
+	defer resp.Body.Close()
+
+	//nolint:errcheck
+	body, _ = ioutil.ReadAll(resp.Body)
+
+	data = &batchResponse{}
+	err = json.Unmarshal(body, data)
+	if err != nil || resp.StatusCode != http.StatusUnprocessableEntity || data.Status != "failed" || len(data.Results) != 2 {
+		t.Errorf("unexpected response %d: %s", resp.StatusCode, body)
+		return
+	}
+
+	// too many lines
+
+	lines := strings.Repeat(`{"operation": "deposit", "id": 1, "money": 1}`+"\n", transaction.MaxBatchSize+1)
+	req = httptest.NewRequest("POST", "/balance/batch", strings.NewReader(lines))
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	w = httptest.NewRecorder()
+	service.ApplyBatch(w, req)
+
+	resp = w.Result()
+	//nolint:errcheck
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected resp status 413, got %d", resp.StatusCode)
+		return
+	}
+
+	// too many bytes
+
+	for _, contentType := range []string{"application/json", "application/x-ndjson"} {
+		req = httptest.NewRequest("POST", "/balance/batch", strings.NewReader(`{"items": [`+
+			strings.Repeat(" ", MaxBatchBodySize)+`]}`))
+		req.Header.Set("Content-Type", contentType)
+		w = httptest.NewRecorder()
+		service.ApplyBatch(w, req)
+
+		resp = w.Result()
+		//nolint:errcheck
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusRequestEntityTooLarge {
+			t.Errorf("%s: expected resp status 413, got %d", contentType, resp.StatusCode)
+			return
+		}
+	}
+
+	// bad json
+
+	req = httptest.NewRequest("POST", "/balance/batch", strings.NewReader(`{"items": `))
+	w = httptest.NewRecorder()
+	service.ApplyBatch(w, req)
+
+	resp = w.Result()
+	//nolint:errcheck
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected resp status 400, got %d", resp.StatusCode)
+		return
+	}
+
+	// database error
+
+	st.EXPECT().ApplyBatch(gomock.Any(), items, true).Return(nil, fmt.Errorf("db error"))
+
+	req = httptest.NewRequest("POST", "/balance/batch", strings.NewReader(`{"atomic": true, "items": [`+
+		`{"operation": "deposit", "id": 1, "money": 100}, {"operation": "transfer", "id": 1, "id_to": 2, "money": 50}]}`))
+	w = httptest.NewRecorder()
+	service.ApplyBatch(w, req)
+
+	resp = w.Result()
+	//nolint:errcheck
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusInternalServerError {
+		t.Errorf("expected resp status 500, got %d", resp.StatusCode)
+		return
+	}
+}
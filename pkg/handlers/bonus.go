@@ -0,0 +1,53 @@
+package handlers
+
+import (
+	"autumn-2021-intern-assignment/pkg/transaction"
+	"context"
+	"go.uber.org/zap"
+	"net/http"
+)
+
+type BonusRepositoryInterface interface {
+	GrantBonus(ctx context.Context, req *transaction.BonusRequest) (*transaction.BonusGrant, error)
+	GetBonuses(ctx context.Context, userID int) ([]*transaction.BonusGrant, error)
+}
+
+type BonusHandler struct {
+	BonusRepo BonusRepositoryInterface
+	Logger    *zap.SugaredLogger
+}
+
+// mockgen -source=bonus.go -destination=bonus_mock.go -package=handlers BonusRepositoryInterface
+
+func (h BonusHandler) GrantBonus(w http.ResponseWriter, r *http.Request) {
+	req := &transaction.BonusRequest{}
+	status, err := decodeBody(r, req)
+	if err != nil {
+		sendError(w, r, h.Logger, err, status)
+		return
+	}
+
+	g, err := h.BonusRepo.GrantBonus(r.Context(), req)
+	if err != nil {
+		sendError(w, r, h.Logger, err, errorStatus(err))
+		return
+	}
+
+	sendData(w, r, h.Logger, g)
+}
+
+func (h BonusHandler) GetBonuses(w http.ResponseWriter, r *http.Request) {
+	userID, err := accountID(r)
+	if err != nil {
+		sendError(w, r, h.Logger, err, http.StatusBadRequest)
+		return
+	}
+
+	grants, err := h.BonusRepo.GetBonuses(r.Context(), userID)
+	if err != nil {
+		sendError(w, r, h.Logger, err, errorStatus(err))
+		return
+	}
+
+	sendData(w, r, h.Logger, grants)
+}
@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"autumn-2021-intern-assignment/pkg/transaction"
+	"context"
+	"encoding/json"
+	"github.com/golang/mock/gomock"
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBonuses(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	// Finish сравнит последовательность вызовов и выведет ошибку если последовательность другая
+	defer ctrl.Finish()
+
+	st := NewMockBonusRepositoryInterface(ctrl)
+
+	service := &BonusHandler{
+		BonusRepo: st,
+		Logger:    zap.NewNop().Sugar(), // не пишет логи
+	}
+	r := mux.NewRouter()
+	r.HandleFunc("/admin/bonuses", service.GrantBonus)
+	r.HandleFunc("/accounts/{id:[0-9]+}/bonuses", service.GetBonuses)
+
+	send := func(method, path, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return w
+	}
+
+	created := time.Date(2021, 11, 27, 10, 0, 0, 0, time.UTC)
+	grant := &transaction.BonusGrant{
+		ID:        5,
+		UserID:    1,
+		Amount:    100,
+		Remaining: 100,
+		Services:  []string{"music"},
+		Status:    transaction.BonusActive,
+		Created:   created,
+		ExpiresAt: created.AddDate(0, 0, 30),
+	}
+
+	st.EXPECT().GrantBonus(gomock.Any(), &transaction.BonusRequest{UserID: 1, Amount: 100, Services: []string{"music"}, Days: 30}).
+		Return(grant, nil)
+
+	w := send(http.MethodPost, "/admin/bonuses", `{"id": 1, "amount": 100, "services": ["music"], "days": 30}`)
+	have := &transaction.BonusGrant{}
+	err := json.Unmarshal(w.Body.Bytes(), have)
+	if w.Code != http.StatusOK || err != nil || !reflect.DeepEqual(have, grant) {
+		t.Errorf("unexpected answer %d %s", w.Code, w.Body.String())
+		return
+	}
+
+	st.EXPECT().GrantBonus(gomock.Any(), gomock.Any()).Return(nil, transaction.ErrBadBonus)
+
+	w = send(http.MethodPost, "/admin/bonuses", `{"id": 1, "amount": 100}`)
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), `"code":"bad_bonus"`) {
+		t.Errorf("unexpected answer %d %s", w.Code, w.Body.String())
+		return
+	}
+
+	st.EXPECT().GetBonuses(gomock.Any(), 1).Return([]*transaction.BonusGrant{grant}, nil)
+
+	w = send(http.MethodGet, "/accounts/1/bonuses", "")
+	list := make([]*transaction.BonusGrant, 0)
+	err = json.Unmarshal(w.Body.Bytes(), &list)
+	if w.Code != http.StatusOK || err != nil || !reflect.DeepEqual(list, []*transaction.BonusGrant{grant}) {
+		t.Errorf("unexpected answer %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestWithdrawPaysForService(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	// Finish сравнит последовательность вызовов и выведет ошибку если последовательность другая
+	defer ctrl.Finish()
+
+	st := NewMockItemsRepositoryInterface(ctrl)
+
+	service := &ItemsHandler{
+		ItemRepo: st,
+		Logger:   zap.NewNop().Sugar(), // не пишет логи
+	}
+
+	st.EXPECT().WithdrawMoney(gomock.Any(), 1, 70.0).DoAndReturn(
+		func(ctx context.Context, userID int, money float64) (*transaction.Transaction, error) {
+			if transaction.Service(ctx) != "music" {
+				t.Errorf("expected the service music, have %q", transaction.Service(ctx))
+			}
+			return &transaction.Transaction{ID: 9, Money: -20, Bonus: 50}, nil
+		})
+
+	req := httptest.NewRequest(http.MethodPost, "/balance/reduce", strings.NewReader(`{"id": 1, "balance": 70, "service": "music"}`))
+	w := httptest.NewRecorder()
+	service.DecreaseBalance(w, req)
+
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"bonus":50`) {
+		t.Errorf("unexpected answer %d %s", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,33 @@
+package handlers
+
+import (
+	"autumn-2021-intern-assignment/pkg/transaction"
+	"fmt"
+	"go.uber.org/zap"
+	"net/http"
+)
+
+// ReadConsistencyHeader chooses where the balance and the history are read
+// from: "strong" reads the primary and sees every operation made before,
+// "eventual" (the default) may read a replica a few seconds behind.
+const ReadConsistencyHeader = "Read-Consistency"
+
+type ReadConsistencyHandler struct {
+	Logger *zap.SugaredLogger
+}
+
+// Middleware sends the reads of a request with "Read-Consistency: strong" to
+// the primary.
+func (h ReadConsistencyHandler) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch consistency := r.Header.Get(ReadConsistencyHeader); consistency {
+		case "", "eventual":
+		case "strong":
+			r = r.WithContext(transaction.WithPrimaryRead(r.Context()))
+		default:
+			sendError(w, r, h.Logger, fmt.Errorf("unknown read consistency %q", consistency), http.StatusBadRequest)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,47 @@
+package handlers
+
+import (
+	"autumn-2021-intern-assignment/pkg/transaction"
+	"go.uber.org/zap"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestReadConsistencyMiddleware(t *testing.T) {
+	service := &ReadConsistencyHandler{
+		Logger: zap.NewNop().Sugar(), // не пишет логи
+	}
+
+	var primary, called bool
+	handler := service.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+		primary = transaction.PrimaryRead(r.Context())
+	}))
+
+	cases := []struct {
+		value   string
+		status  int
+		primary bool
+	}{
+		{"", http.StatusOK, false},
+		{"eventual", http.StatusOK, false},
+		{"strong", http.StatusOK, true},
+		{"linearizable", http.StatusBadRequest, false},
+	}
+	for _, c := range cases {
+		called, primary = false, false
+		req := httptest.NewRequest(http.MethodGet, "/user", nil)
+		if c.value != "" {
+			req.Header.Set(ReadConsistencyHeader, c.value)
+		}
+		w := httptest.NewRecorder()
+
+		handler.ServeHTTP(w, req)
+
+		if w.Code != c.status || called != (c.status == http.StatusOK) || primary != c.primary {
+			t.Errorf("%q: expected status %d and primary %v, have %d, %v (called %v)",
+				c.value, c.status, c.primary, w.Code, primary, called)
+		}
+	}
+}
@@ -0,0 +1,20 @@
+package handlers
+
+import (
+	"autumn-2021-intern-assignment/api"
+	"net/http"
+)
+
+// ServeOpenAPI serves the OpenAPI document of the service.
+func ServeOpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	//nolint:errcheck
+	w.Write(api.OpenAPI)
+}
+
+// ServeDocs serves the page rendering the OpenAPI document.
+func ServeDocs(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	//nolint:errcheck
+	w.Write(api.Docs)
+}
@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"autumn-2021-intern-assignment/pkg/transaction"
+	"context"
+	"fmt"
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+	"net/http"
+	"strconv"
+)
+
+type FeesRepositoryInterface interface {
+	PreviewFee(ctx context.Context, req *transaction.FeeRequest) (*transaction.FeePreview, error)
+	GetFeeRules(ctx context.Context) ([]*transaction.FeeRule, error)
+	CreateFeeRule(ctx context.Context, rule *transaction.FeeRule) (*transaction.FeeRule, error)
+	DeleteFeeRule(ctx context.Context, ruleID int) error
+}
+
+type FeesHandler struct {
+	FeeRepo FeesRepositoryInterface
+	Logger  *zap.SugaredLogger
+}
+
+// mockgen -source=fees.go -destination=fees_mock.go -package=handlers FeesRepositoryInterface
+
+func (h FeesHandler) PreviewFee(w http.ResponseWriter, r *http.Request) {
+	req := &transaction.FeeRequest{}
+	status, err := decodeBody(r, req)
+	if err != nil {
+		sendError(w, r, h.Logger, err, status)
+		return
+	}
+
+	preview, err := h.FeeRepo.PreviewFee(r.Context(), req)
+	if err != nil {
+		sendError(w, r, h.Logger, err, errorStatus(err))
+		return
+	}
+
+	sendData(w, r, h.Logger, preview)
+}
+
+func (h FeesHandler) GetFeeRules(w http.ResponseWriter, r *http.Request) {
+	rules, err := h.FeeRepo.GetFeeRules(r.Context())
+	if err != nil {
+		sendError(w, r, h.Logger, err, errorStatus(err))
+		return
+	}
+
+	sendData(w, r, h.Logger, rules)
+}
+
+func (h FeesHandler) CreateFeeRule(w http.ResponseWriter, r *http.Request) {
+	req := &transaction.FeeRule{}
+	status, err := decodeBody(r, req)
+	if err != nil {
+		sendError(w, r, h.Logger, err, status)
+		return
+	}
+
+	rule, err := h.FeeRepo.CreateFeeRule(r.Context(), req)
+	if err != nil {
+		sendError(w, r, h.Logger, err, errorStatus(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	sendData(w, r, h.Logger, rule)
+}
+
+func (h FeesHandler) DeleteFeeRule(w http.ResponseWriter, r *http.Request) {
+	ruleID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		sendError(w, r, h.Logger, fmt.Errorf("bad fee rule id"), http.StatusBadRequest)
+		return
+	}
+
+	err = h.FeeRepo.DeleteFeeRule(r.Context(), ruleID)
+	if err != nil {
+		sendError(w, r, h.Logger, err, errorStatus(err))
+		return
+	}
+
+	sendData(w, r, h.Logger, map[string]string{"status": "success"})
+}
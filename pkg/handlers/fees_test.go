@@ -0,0 +1,207 @@
+package handlers
+
+import (
+	"autumn-2021-intern-assignment/pkg/transaction"
+	"encoding/json"
+	"fmt"
+	"github.com/golang/mock/gomock"
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPreviewFee(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	// Finish сравнит последовательность вызовов и выведет ошибку если последовательность другая
+	defer ctrl.Finish()
+
+	st := NewMockFeesRepositoryInterface(ctrl)
+
+	service := &FeesHandler{
+		FeeRepo: st,
+		Logger:  zap.NewNop().Sugar(), // не пишет логи
+	}
+
+	ruleID := 1
+	resultItem := &transaction.FeePreview{
+		Operation: transaction.OperationTransfer,
+		UserID:    1,
+		Currency:  transaction.BaseCurrency,
+		Money:     100,
+		Fee:       2,
+		Total:     102,
+		RuleID:    &ruleID,
+	}
+
+	st.EXPECT().PreviewFee(gomock.Any(), &transaction.FeeRequest{Operation: transaction.OperationTransfer, UserID: 1, Money: 100}).
+		Return(resultItem, nil)
+
+	req := httptest.NewRequest("POST", "/fees/preview", strings.NewReader(`{"operation": "transfer", "id": 1, "money": 100}`))
+	w := httptest.NewRecorder()
+	service.PreviewFee(w, req)
+
+	resp := w.Result()
+	//nolint:errcheck
+	defer resp.Body.Close()
+
+	//nolint:errcheck
+	body, _ := ioutil.ReadAll(resp.Body)
+
+	preview := &transaction.FeePreview{}
+	err := json.Unmarshal(body, preview)
+	if err != nil || !reflect.DeepEqual(preview, resultItem) {
+		t.Errorf("results not match, want %v, have %v", resultItem, preview)
+		return
+	}
+
+	// bad operation
+
+	st.EXPECT().PreviewFee(gomock.Any(), gomock.Any()).Return(nil, transaction.ErrBadOperation)
+	req = httptest.NewRequest("POST", "/fees/preview", strings.NewReader(`{"operation": "deposit", "id": 1, "money": 100}`))
+	w = httptest.NewRecorder()
+	service.PreviewFee(w, req)
+
+	resp = w.Result()
+	//nolint:errcheck
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected resp status 400, got %d", resp.StatusCode)
+		return
+	}
+
+	// marshaling error
+
+	req = httptest.NewRequest("POST", "/fees/preview", strings.NewReader("mess1111ag11e:1qq11111powei"))
+	w = httptest.NewRecorder()
+	service.PreviewFee(w, req)
+
+	resp = w.Result()
+	//nolint:errcheck
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected resp status 400, got %d", resp.StatusCode)
+		return
+	}
+}
+
+func TestFeeRules(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	// Finish сравнит последовательность вызовов и выведет ошибку если последовательность другая
+	defer ctrl.Finish()
+
+	st := NewMockFeesRepositoryInterface(ctrl)
+
+	service := &FeesHandler{
+		FeeRepo: st,
+		Logger:  zap.NewNop().Sugar(), // не пишет логи
+	}
+
+	// create
+	resultItem := &transaction.FeeRule{
+		ID:        1,
+		Operation: transaction.OperationWithdraw,
+		Currency:  transaction.BaseCurrency,
+		Fixed:     5,
+	}
+	st.EXPECT().CreateFeeRule(gomock.Any(), &transaction.FeeRule{Operation: transaction.OperationWithdraw, Fixed: 5}).
+		Return(resultItem, nil)
+
+	req := httptest.NewRequest("POST", "/admin/fees", strings.NewReader(`{"operation": "withdraw", "fixed": 5}`))
+	w := httptest.NewRecorder()
+	service.CreateFeeRule(w, req)
+
+	resp := w.Result()
+	//nolint:errcheck
+	defer resp.Body.Close()
+
+	//nolint:errcheck
+	body, _ := ioutil.ReadAll(resp.Body)
+
+	if resp.StatusCode != http.StatusCreated {
+		t.Errorf("expected resp status 201, got %d", resp.StatusCode)
+		return
+	}
+
+	rule := &transaction.FeeRule{}
+	err := json.Unmarshal(body, rule)
+	if err != nil || !reflect.DeepEqual(rule, resultItem) {
+		t.Errorf("results not match, want %v, have %v", resultItem, rule)
+		return
+	}
+
+	// list
+	st.EXPECT().GetFeeRules(gomock.Any()).Return([]*transaction.FeeRule{resultItem}, nil)
+
+	req = httptest.NewRequest("GET", "/admin/fees", nil)
+	w = httptest.NewRecorder()
+	service.GetFeeRules(w, req)
+
+	resp = w.Result()
+	//nolint:errcheck
+	defer resp.Body.Close()
+
+	//nolint:errcheck
+	body, _ = ioutil.ReadAll(resp.Body)
+
+	rules := []*transaction.FeeRule{}
+	err = json.Unmarshal(body, &rules)
+	if err != nil || !reflect.DeepEqual(rules, []*transaction.FeeRule{resultItem}) {
+		t.Errorf("results not match, want %v, have %v", resultItem, rules)
+		return
+	}
+
+	st.EXPECT().GetFeeRules(gomock.Any()).Return(nil, fmt.Errorf("bad result"))
+
+	req = httptest.NewRequest("GET", "/admin/fees", nil)
+	w = httptest.NewRecorder()
+	service.GetFeeRules(w, req)
+
+	resp = w.Result()
+	//nolint:errcheck
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 500 {
+		t.Errorf("expected resp status 500, got %d", resp.StatusCode)
+		return
+	}
+
+	// delete
+	st.EXPECT().DeleteFeeRule(gomock.Any(), 2).Return(transaction.ErrFeeRuleNotFound)
+
+	req = httptest.NewRequest("DELETE", "/admin/fees/2", nil)
+	req = mux.SetURLVars(req, map[string]string{"id": "2"})
+	w = httptest.NewRecorder()
+	service.DeleteFeeRule(w, req)
+
+	resp = w.Result()
+	//nolint:errcheck
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expected resp status 404, got %d", resp.StatusCode)
+		return
+	}
+
+	req = httptest.NewRequest("DELETE", "/admin/fees/abc", nil)
+	req = mux.SetURLVars(req, map[string]string{"id": "abc"})
+	w = httptest.NewRecorder()
+	service.DeleteFeeRule(w, req)
+
+	resp = w.Result()
+	//nolint:errcheck
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected resp status 400, got %d", resp.StatusCode)
+		return
+	}
+}
@@ -0,0 +1,288 @@
+package handlers
+
+import (
+	"autumn-2021-intern-assignment/pkg/grpcapi/balancev1"
+	"autumn-2021-intern-assignment/pkg/transaction"
+	"context"
+	"errors"
+	"go.uber.org/zap"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"net/http"
+)
+
+// ErrorDomain is the domain of the ErrorInfo details of the gRPC errors.
+const ErrorDomain = "balance"
+
+// historyPage is the number of operations History reads at once.
+const historyPage = 100
+
+// BalanceServer is the gRPC form of ItemsHandler, see api/balance/v1/balance.proto.
+type BalanceServer struct {
+	balancev1.UnimplementedBalanceServer
+	ItemRepo ItemsRepositoryInterface
+	Logger   *zap.SugaredLogger
+}
+
+// grpcCodes are the gRPC codes of the statuses given by errorStatus.
+var grpcCodes = map[int]codes.Code{
+	http.StatusNotFound:              codes.NotFound,
+	http.StatusConflict:              codes.FailedPrecondition,
+	http.StatusBadRequest:            codes.InvalidArgument,
+	http.StatusUnauthorized:          codes.Unauthenticated,
+	http.StatusForbidden:             codes.PermissionDenied,
+	http.StatusUnprocessableEntity:   codes.ResourceExhausted,
+	http.StatusTooManyRequests:       codes.ResourceExhausted,
+	http.StatusRequestEntityTooLarge: codes.InvalidArgument,
+	http.StatusGatewayTimeout:        codes.DeadlineExceeded,
+}
+
+// grpcError is sendError for the gRPC calls: the code follows errorStatus and
+// the ErrorInfo details carry transaction.ErrorCode as the reason, with the
+// limit like the field of ErrorResponse.
+func (s BalanceServer) grpcError(method string, err error) error {
+	code, ok := grpcCodes[errorStatus(err)]
+	if !ok {
+		code = codes.Internal
+	}
+	if errors.Is(err, context.Canceled) {
+		code = codes.Canceled
+	}
+	s.Logger.Errorf("gRPC error",
+		"method", method,
+		"code", code.String(),
+		"error", err.Error(),
+	)
+
+	st := status.New(code, err.Error())
+	reason := transaction.ErrorCode(err)
+	if reason == "" {
+		return st.Err()
+	}
+
+	info := &errdetails.ErrorInfo{Reason: reason, Domain: ErrorDomain, Metadata: map[string]string{}}
+	limitErr := &transaction.LimitError{}
+	if errors.As(err, &limitErr) {
+		info.Metadata["limit"] = limitErr.Limit
+	}
+
+	withInfo, detailsErr := st.WithDetails(info)
+	if detailsErr != nil {
+		return st.Err()
+	}
+	return withInfo.Err()
+}
+
+// operationResponse answers a money operation. The one held for an approval
+// or a review is accepted like the 202 of the HTTP API, so it is answered
+// with the id to follow it by and not with an error a client would retry.
+func (s BalanceServer) operationResponse(method string, tr *transaction.Transaction, err error) (*balancev1.OperationResponse, error) {
+	pendingErr := &transaction.PendingError{}
+	if errors.As(err, &pendingErr) {
+		id := int64(pendingErr.Pending.ID)
+		return &balancev1.OperationResponse{PendingId: &id}, nil
+	}
+	riskErr := &transaction.RiskError{}
+	if errors.Is(err, transaction.ErrRiskReview) && errors.As(err, &riskErr) && riskErr.ReviewID != 0 {
+		id := int64(riskErr.ReviewID)
+		return &balancev1.OperationResponse{ReviewId: &id}, nil
+	}
+	if err != nil {
+		return nil, s.grpcError(method, err)
+	}
+
+	return &balancev1.OperationResponse{Transaction: transactionMessage(tr)}, nil
+}
+
+func optionalInt(id *int) *int64 {
+	if id == nil {
+		return nil
+	}
+	v := int64(*id)
+	return &v
+}
+
+func transactionMessage(tr *transaction.Transaction) *balancev1.Transaction {
+	if tr == nil {
+		return nil
+	}
+	msg := &balancev1.Transaction{
+		Id:            int64(tr.ID),
+		ToId:          optionalInt(tr.ToID),
+		FromId:        optionalInt(tr.FromID),
+		Money:         tr.Money,
+		Created:       timestamppb.New(tr.Created),
+		RefundOf:      optionalInt(tr.RefundOf),
+		FeeOf:         optionalInt(tr.FeeOf),
+		SplitOf:       optionalInt(tr.SplitOf),
+		Balance:       tr.Balance,
+		Fee:           transactionMessage(tr.Fee),
+		Bonus:         tr.Bonus,
+		BonusExpired:  tr.BonusExpired,
+		BonusGranted:  tr.BonusGranted,
+		PendingId:     optionalInt(tr.PendingID),
+		PendingStatus: tr.PendingStatus,
+		Held:          tr.Held,
+	}
+	for _, leg := range tr.Legs {
+		msg.Legs = append(msg.Legs, transactionMessage(leg))
+	}
+	return msg
+}
+
+func bonusMessage(g *transaction.BonusGrant) *balancev1.BonusGrant {
+	return &balancev1.BonusGrant{
+		Id:        int64(g.ID),
+		UserId:    int64(g.UserID),
+		Amount:    g.Amount,
+		Remaining: g.Remaining,
+		Services:  g.Services,
+		Status:    g.Status,
+		Created:   timestamppb.New(g.Created),
+		ExpiresAt: timestamppb.New(g.ExpiresAt),
+	}
+}
+
+// rateDate adds the date of the currency rates to ctx like the date parameter
+// of the HTTP API, a bad one is INVALID_ARGUMENT.
+func (s BalanceServer) rateDate(ctx context.Context, method, date string) (context.Context, error) {
+	ctx, err := withRateDate(ctx, date)
+	if err != nil {
+		s.Logger.Errorf("gRPC error",
+			"method", method,
+			"code", codes.InvalidArgument.String(),
+			"error", err.Error(),
+		)
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	return ctx, nil
+}
+
+func (s BalanceServer) GetBalance(ctx context.Context, req *balancev1.GetBalanceRequest) (*balancev1.GetBalanceResponse, error) {
+	ctx, err := s.rateDate(ctx, "GetBalance", req.GetDate())
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := s.ItemRepo.GetUsersBalance(ctx, int(req.GetId()), req.GetCurrency())
+	if err != nil {
+		return nil, s.grpcError("GetBalance", err)
+	}
+
+	resp := &balancev1.GetBalanceResponse{Id: int64(user.UserID), Balance: user.Balance, Available: user.Available,
+		Bonus: user.Bonus}
+	for _, g := range user.Bonuses {
+		resp.Bonuses = append(resp.Bonuses, bonusMessage(g))
+	}
+	return resp, nil
+}
+
+func (s BalanceServer) Deposit(ctx context.Context, req *balancev1.DepositRequest) (*balancev1.OperationResponse, error) {
+	tr, err := s.ItemRepo.AddMoney(ctx, int(req.GetId()), req.GetMoney())
+	return s.operationResponse("Deposit", tr, err)
+}
+
+func (s BalanceServer) Withdraw(ctx context.Context, req *balancev1.WithdrawRequest) (*balancev1.OperationResponse, error) {
+	// the service decides which bonuses pay for it like in DecreaseBalance
+	ctx = transaction.WithService(ctx, req.GetService())
+	ctx = transaction.WithOperationCurrency(ctx, req.GetCurrency())
+	tr, err := s.ItemRepo.WithdrawMoney(ctx, int(req.GetId()), req.GetMoney())
+	return s.operationResponse("Withdraw", tr, err)
+}
+
+func (s BalanceServer) Transfer(ctx context.Context, req *balancev1.TransferRequest) (*balancev1.OperationResponse, error) {
+	ctx = transaction.WithOperationCurrency(ctx, req.GetCurrency())
+	tr, err := s.ItemRepo.TransferMoney(ctx, int(req.GetId()), int(req.GetIdTo()), req.GetMoney())
+	return s.operationResponse("Transfer", tr, err)
+}
+
+func (s BalanceServer) History(req *balancev1.HistoryRequest, stream balancev1.Balance_HistoryServer) error {
+	ctx, err := s.rateDate(stream.Context(), "History", req.GetDate())
+	if err != nil {
+		return err
+	}
+
+	// the history is read page by page, the next one starts after the last
+	// operation sent
+	var after *transaction.Transaction
+	for {
+		page, err := s.ItemRepo.GetTransactionPage(ctx, int(req.GetId()), req.GetField(), after, historyPage)
+		if err != nil {
+			return s.grpcError("History", err)
+		}
+		if len(page) == 0 {
+			return nil
+		}
+		last := page[len(page)-1]
+		after = &transaction.Transaction{ID: last.ID, Money: last.Money, Created: last.Created}
+
+		if req.GetCurrency() != "" {
+			err = s.ItemRepo.ConvertTransactions(ctx, page, req.GetCurrency())
+			if err != nil {
+				return s.grpcError("History", err)
+			}
+		}
+		for _, tr := range page {
+			err = stream.Send(transactionMessage(tr))
+			if err != nil {
+				return err
+			}
+		}
+		if len(page) < historyPage {
+			return nil
+		}
+	}
+}
+
+// authorize is Middleware for the gRPC calls, the key is sent in the
+// "authorization" metadata. There are no admin calls, so a key is only needed
+// once any is configured.
+func (h AuthHandler) authorize(ctx context.Context) (context.Context, error) {
+	var header string
+	if md, ok := metadata.FromIncomingContext(ctx); ok && len(md.Get("authorization")) > 0 {
+		header = md.Get("authorization")[0]
+	}
+
+	key, ok := h.findKey(header)
+	if !ok {
+		if header != "" || len(h.Keys) > 0 {
+			return nil, status.Error(codes.Unauthenticated, transaction.ErrUnauthorized.Error())
+		}
+		return ctx, nil
+	}
+	return transaction.WithRequester(ctx, key.Name), nil
+}
+
+// UnaryInterceptor checks the API key of the unary gRPC calls.
+func (h AuthHandler) UnaryInterceptor(ctx context.Context, req interface{}, _ *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler) (interface{}, error) {
+	ctx, err := h.authorize(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+// authorizedStream is a stream with the context given by authorize.
+type authorizedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s authorizedStream) Context() context.Context {
+	return s.ctx
+}
+
+// StreamInterceptor checks the API key of the streaming gRPC calls.
+func (h AuthHandler) StreamInterceptor(srv interface{}, ss grpc.ServerStream, _ *grpc.StreamServerInfo,
+	handler grpc.StreamHandler) error {
+	ctx, err := h.authorize(ss.Context())
+	if err != nil {
+		return err
+	}
+	return handler(srv, authorizedStream{ServerStream: ss, ctx: ctx})
+}
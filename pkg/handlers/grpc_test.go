@@ -0,0 +1,308 @@
+package handlers
+
+import (
+	"autumn-2021-intern-assignment/pkg/grpcapi/balancev1"
+	"autumn-2021-intern-assignment/pkg/transaction"
+	"context"
+	"github.com/golang/mock/gomock"
+	"go.uber.org/zap"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// startBalanceServer serves BalanceServer over an in-memory connection.
+func startBalanceServer(t *testing.T, repo ItemsRepositoryInterface, auth AuthHandler) balancev1.BalanceClient {
+	lis := bufconn.Listen(1 << 20)
+	server := grpc.NewServer(
+		grpc.UnaryInterceptor(auth.UnaryInterceptor),
+		grpc.StreamInterceptor(auth.StreamInterceptor),
+	)
+	balancev1.RegisterBalanceServer(server, BalanceServer{ItemRepo: repo, Logger: zap.NewNop().Sugar()})
+	//nolint:errcheck
+	go server.Serve(lis)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.Dial() }),
+		grpc.WithInsecure())
+	if err != nil {
+		t.Fatalf("cant dial: %s", err)
+	}
+	t.Cleanup(func() {
+		//nolint:errcheck
+		conn.Close()
+	})
+	return balancev1.NewBalanceClient(conn)
+}
+
+func errorInfo(err error) *errdetails.ErrorInfo {
+	for _, d := range status.Convert(err).Details() {
+		if info, ok := d.(*errdetails.ErrorInfo); ok {
+			return info
+		}
+	}
+	return nil
+}
+
+func TestBalanceServer(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	st := NewMockItemsRepositoryInterface(ctrl)
+	client := startBalanceServer(t, st, AuthHandler{Logger: zap.NewNop().Sugar()})
+	ctx := context.Background()
+
+	available, bonus := 150.0, 30.0
+	expires := time.Date(2021, 12, 27, 10, 0, 0, 0, time.UTC)
+	st.EXPECT().GetUsersBalance(gomock.Any(), 1, "USD").DoAndReturn(
+		func(ctx context.Context, userID int, currency string) (*transaction.User, error) {
+			day, fixed := transaction.RateDate(ctx)
+			if !fixed || !day.Equal(time.Date(2021, 11, 27, 0, 0, 0, 0, time.UTC)) {
+				t.Errorf("unexpected rate date %v", day)
+			}
+			return &transaction.User{UserID: 1, Balance: 2, Available: &available, Bonus: &bonus, Bonuses: []*transaction.BonusGrant{
+				{ID: 6, UserID: 1, Amount: 50, Remaining: 30, Services: []string{"music"}, Status: transaction.BonusActive,
+					ExpiresAt: expires},
+			}}, nil
+		})
+
+	balance, err := client.GetBalance(ctx, &balancev1.GetBalanceRequest{Id: 1, Currency: "USD", Date: "2021-11-27"})
+	if err != nil || balance.Id != 1 || balance.Balance != 2 || balance.Available == nil || *balance.Available != 150 ||
+		balance.GetBonus() != 30 || len(balance.Bonuses) != 1 || balance.Bonuses[0].Id != 6 || balance.Bonuses[0].Remaining != 30 ||
+		len(balance.Bonuses[0].Services) != 1 || !balance.Bonuses[0].ExpiresAt.AsTime().Equal(expires) {
+		t.Errorf("unexpected balance %v, %v", balance, err)
+		return
+	}
+
+	_, err = client.GetBalance(ctx, &balancev1.GetBalanceRequest{Id: 1, Date: "27.11.2021"})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("expected InvalidArgument, got %v", err)
+		return
+	}
+
+	// the fee comes with the operation
+	toID, feeOf, after := 1, 10, 95.0
+	created := time.Date(2021, 11, 27, 10, 0, 0, 0, time.UTC)
+	st.EXPECT().AddMoney(gomock.Any(), 1, 100.0).Return(&transaction.Transaction{ID: 10, ToID: &toID, Money: 100, Created: created,
+		Balance: &after, Fee: &transaction.Transaction{ID: 11, FromID: &toID, Money: 5, Created: created, FeeOf: &feeOf}}, nil)
+
+	op, err := client.Deposit(ctx, &balancev1.DepositRequest{Id: 1, Money: 100})
+	if err != nil {
+		t.Errorf("unexpected err: %s", err)
+		return
+	}
+	tr := op.Transaction
+	if tr.Id != 10 || tr.GetToId() != 1 || tr.FromId != nil || tr.Money != 100 || !tr.Created.AsTime().Equal(created) ||
+		tr.GetBalance() != 95 || tr.Fee.GetId() != 11 || tr.Fee.GetFeeOf() != 10 {
+		t.Errorf("unexpected transaction %v", tr)
+		return
+	}
+
+	// the errors keep the code of the JSON errors
+	st.EXPECT().WithdrawMoney(gomock.Any(), 2, 10.0).Return(nil, transaction.ErrAccountNotFound)
+
+	_, err = client.Withdraw(ctx, &balancev1.WithdrawRequest{Id: 2, Money: 10})
+	if status.Code(err) != codes.NotFound || errorInfo(err).GetReason() != "account_not_found" {
+		t.Errorf("expected NotFound, got %v", err)
+		return
+	}
+
+	st.EXPECT().TransferMoney(gomock.Any(), 1, 2, 500.0).Return(nil, &transaction.LimitError{Limit: "daily"})
+
+	_, err = client.Transfer(ctx, &balancev1.TransferRequest{Id: 1, IdTo: 2, Money: 500})
+	info := errorInfo(err)
+	if status.Code(err) != codes.ResourceExhausted || info.GetReason() != "limit_exceeded" || info.GetMetadata()["limit"] != "daily" {
+		t.Errorf("expected ResourceExhausted, got %v, %v", err, info)
+		return
+	}
+
+	st.EXPECT().WithdrawMoney(gomock.Any(), 1, 10.0).Return(nil, transaction.ErrNotEnoughMoney)
+
+	_, err = client.Withdraw(ctx, &balancev1.WithdrawRequest{Id: 1, Money: 10})
+	if status.Code(err) != codes.InvalidArgument || errorInfo(err).GetReason() != "not_enough_money" {
+		t.Errorf("expected InvalidArgument, got %v", err)
+		return
+	}
+
+	// the service and the currency are passed like in the HTTP API
+	fromID := 1
+	st.EXPECT().WithdrawMoney(gomock.Any(), 1, 10.0).DoAndReturn(
+		func(ctx context.Context, userID int, money float64) (*transaction.Transaction, error) {
+			if transaction.Service(ctx) != "music" || transaction.OperationCurrency(ctx) != "USD" {
+				t.Errorf("unexpected service %q, currency %q", transaction.Service(ctx), transaction.OperationCurrency(ctx))
+			}
+			return &transaction.Transaction{ID: 12, FromID: &fromID, Money: -4, Bonus: 6}, nil
+		})
+
+	op, err = client.Withdraw(ctx, &balancev1.WithdrawRequest{Id: 1, Money: 10, Service: "music", Currency: "USD"})
+	if err != nil || op.Transaction.Money != -4 || op.Transaction.Bonus != 6 {
+		t.Errorf("unexpected withdrawal %v, %v", op, err)
+		return
+	}
+
+	st.EXPECT().TransferMoney(gomock.Any(), 1, 2, 10.0).DoAndReturn(
+		func(ctx context.Context, fromUserID, toUserID int, money float64) (*transaction.Transaction, error) {
+			if transaction.OperationCurrency(ctx) != "EUR" {
+				t.Errorf("unexpected currency %q", transaction.OperationCurrency(ctx))
+			}
+			return &transaction.Transaction{ID: 13, FromID: &fromUserID, ToID: &toUserID, Money: 10}, nil
+		})
+
+	_, err = client.Transfer(ctx, &balancev1.TransferRequest{Id: 1, IdTo: 2, Money: 10, Currency: "EUR"})
+	if err != nil {
+		t.Errorf("unexpected err: %s", err)
+		return
+	}
+
+	// the held operations are accepted, not failed
+	st.EXPECT().WithdrawMoney(gomock.Any(), 1, 5000.0).Return(nil,
+		&transaction.PendingError{Pending: &transaction.PendingOperation{ID: 4}})
+
+	op, err = client.Withdraw(ctx, &balancev1.WithdrawRequest{Id: 1, Money: 5000})
+	if err != nil || op.Transaction != nil || op.GetPendingId() != 4 || op.ReviewId != nil {
+		t.Errorf("unexpected held operation %v, %v", op, err)
+		return
+	}
+
+	st.EXPECT().TransferMoney(gomock.Any(), 1, 2, 300.0).Return(nil,
+		&transaction.RiskError{Decision: transaction.RiskHold, ReviewID: 3})
+
+	op, err = client.Transfer(ctx, &balancev1.TransferRequest{Id: 1, IdTo: 2, Money: 300})
+	if err != nil || op.Transaction != nil || op.GetReviewId() != 3 || op.PendingId != nil {
+		t.Errorf("unexpected reviewed operation %v, %v", op, err)
+		return
+	}
+
+	st.EXPECT().TransferMoney(gomock.Any(), 1, 2, 300.0).Return(nil, &transaction.RiskError{Decision: transaction.RiskDeny})
+
+	_, err = client.Transfer(ctx, &balancev1.TransferRequest{Id: 1, IdTo: 2, Money: 300})
+	if status.Code(err) != codes.PermissionDenied || errorInfo(err).GetReason() != "risk_denied" {
+		t.Errorf("expected PermissionDenied, got %v", err)
+		return
+	}
+}
+
+func TestBalanceServerHistory(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	st := NewMockItemsRepositoryInterface(ctrl)
+	client := startBalanceServer(t, st, AuthHandler{Logger: zap.NewNop().Sugar()})
+	ctx := context.Background()
+
+	userID, pendingID := 1, 7
+	first := make([]*transaction.Transaction, 0, historyPage)
+	for i := 1; i <= historyPage; i++ {
+		first = append(first, &transaction.Transaction{ID: i, ToID: &userID, Money: float64(i)})
+	}
+	second := []*transaction.Transaction{
+		{ID: historyPage + 1, FromID: &userID, Money: 500},
+		{ID: historyPage + 2, ToID: &userID, PendingID: &pendingID, PendingStatus: transaction.PendingWaiting, Held: 50},
+	}
+	// the history is read by pages, the next one after the last operation
+	// sent as it was before the conversion
+	convert := func(ctx context.Context, info []*transaction.Transaction, currency string) error {
+		for _, tr := range info {
+			tr.Money /= 2
+		}
+		return nil
+	}
+	gomock.InOrder(
+		st.EXPECT().GetTransactionPage(gomock.Any(), 1, "money", nil, historyPage).Return(first, nil),
+		st.EXPECT().ConvertTransactions(gomock.Any(), first, "USD").DoAndReturn(convert),
+		st.EXPECT().GetTransactionPage(gomock.Any(), 1, "money", gomock.Any(), historyPage).DoAndReturn(
+			func(ctx context.Context, userID int, orderBy string, after *transaction.Transaction,
+				limit int) ([]*transaction.Transaction, error) {
+				if after.ID != historyPage || after.Money != historyPage {
+					t.Errorf("unexpected page start %v", after)
+				}
+				return second, nil
+			}),
+		st.EXPECT().ConvertTransactions(gomock.Any(), second, "USD").DoAndReturn(convert),
+	)
+
+	stream, err := client.History(ctx, &balancev1.HistoryRequest{Id: 1, Field: "money", Currency: "USD"})
+	if err != nil {
+		t.Errorf("unexpected err: %s", err)
+		return
+	}
+	ids := make([]int64, 0)
+	var hold *balancev1.Transaction
+	for {
+		tr, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Errorf("unexpected err: %s", err)
+			return
+		}
+		ids = append(ids, tr.Id)
+		hold = tr
+	}
+	if len(ids) != historyPage+2 || ids[0] != 1 || ids[historyPage] != historyPage+1 || hold.GetPendingId() != 7 ||
+		hold.PendingStatus != "pending" || hold.Held != 50 {
+		t.Errorf("unexpected history %v, %v", ids, hold)
+		return
+	}
+
+	st.EXPECT().GetTransactionPage(gomock.Any(), 1, "bad", nil, historyPage).Return(nil, transaction.ErrBadOperation)
+
+	stream, err = client.History(ctx, &balancev1.HistoryRequest{Id: 1, Field: "bad"})
+	if err == nil {
+		_, err = stream.Recv()
+	}
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("expected InvalidArgument, got %v", err)
+	}
+}
+
+func TestBalanceServerAuth(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	st := NewMockItemsRepositoryInterface(ctrl)
+	auth := AuthHandler{Keys: []APIKey{{Name: "shop", Role: RoleClient, Key: "s3cret"}}, Logger: zap.NewNop().Sugar()}
+	client := startBalanceServer(t, st, auth)
+
+	// no key
+	_, err := client.GetBalance(context.Background(), &balancev1.GetBalanceRequest{Id: 1})
+	if status.Code(err) != codes.Unauthenticated {
+		t.Errorf("expected Unauthenticated, got %v", err)
+		return
+	}
+
+	// a wrong key
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer wrong")
+	stream, err := client.History(ctx, &balancev1.HistoryRequest{Id: 1})
+	if err == nil {
+		_, err = stream.Recv()
+	}
+	if status.Code(err) != codes.Unauthenticated {
+		t.Errorf("expected Unauthenticated, got %v", err)
+		return
+	}
+
+	// the operations are requested by the name of the key
+	st.EXPECT().AddMoney(gomock.Any(), 1, 10.0).DoAndReturn(
+		func(ctx context.Context, userID int, money float64) (*transaction.Transaction, error) {
+			if transaction.Requester(ctx) != "shop" {
+				t.Errorf("unexpected requester %q", transaction.Requester(ctx))
+			}
+			return &transaction.Transaction{ID: 1, ToID: &userID, Money: money}, nil
+		})
+
+	ctx = metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer s3cret")
+	_, err = client.Deposit(ctx, &balancev1.DepositRequest{Id: 1, Money: 10})
+	if err != nil {
+		t.Errorf("unexpected err: %s", err)
+	}
+}
@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"autumn-2021-intern-assignment/pkg/transaction"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"go.uber.org/zap"
+	"io/ioutil"
+	"net/http"
+)
+
+// IdempotencyKeyHeader holds the key of a POST request that may be repeated.
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// MaxIdempotencyKeyLen is the longest accepted idempotency key.
+const MaxIdempotencyKeyLen = 255
+
+// MaxIdempotentBodySize limits the body of a request with an idempotency key,
+// the largest bodies are the batches.
+const MaxIdempotentBodySize = MaxBatchBodySize
+
+type IdempotencyRepositoryInterface interface {
+	BeginIdempotent(ctx context.Context, client, key, fingerprint string) (*transaction.IdempotentResponse, error)
+	FinishIdempotent(ctx context.Context, client, key string, resp *transaction.IdempotentResponse) error
+	ReleaseIdempotent(ctx context.Context, client, key string) error
+}
+
+type IdempotencyHandler struct {
+	IdempotencyRepo IdempotencyRepositoryInterface
+	Logger          *zap.SugaredLogger
+}
+
+// mockgen -source=idempotency.go -destination=idempotency_mock.go -package=handlers IdempotencyRepositoryInterface
+
+// recorder keeps a copy of the answer to store it.
+type recorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (rec *recorder) WriteHeader(status int) {
+	rec.status = status
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *recorder) Write(data []byte) (int, error) {
+	rec.body.Write(data)
+	return rec.ResponseWriter.Write(data)
+}
+
+// Middleware executes a POST request with an idempotency key once, repeats of
+// it get the stored answer. The keys of every API client are apart, the same
+// key of two clients names two requests. Answers with 5xx statuses are not
+// stored, the request can be repeated with the same key.
+func (h IdempotencyHandler) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(IdempotencyKeyHeader)
+		if key == "" || r.Method != http.MethodPost {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if len(key) > MaxIdempotencyKeyLen {
+			sendError(w, r, h.Logger, fmt.Errorf("idempotency key is longer than %d", MaxIdempotencyKeyLen),
+				http.StatusBadRequest)
+			return
+		}
+
+		body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, MaxIdempotentBodySize))
+		if err != nil && len(body) >= MaxIdempotentBodySize {
+			sendError(w, r, h.Logger, transaction.ErrBodyTooLarge, http.StatusRequestEntityTooLarge)
+			return
+		}
+		if err != nil {
+			sendError(w, r, h.Logger, err, http.StatusBadRequest)
+			return
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+		sum := sha256.New()
+		fmt.Fprintf(sum, "%s %s\n", r.Method, r.URL.RequestURI())
+		sum.Write(body)
+
+		client := clientID(r)
+		stored, err := h.IdempotencyRepo.BeginIdempotent(r.Context(), client, key, hex.EncodeToString(sum.Sum(nil)))
+		if err != nil {
+			sendError(w, r, h.Logger, err, errorStatus(err))
+			return
+		}
+		if stored != nil {
+			w.Header().Set("Idempotent-Replayed", "true")
+			w.WriteHeader(stored.Status)
+			//nolint:errcheck
+			w.Write(stored.Body)
+			return
+		}
+
+		rec := &recorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+
+		if rec.status >= http.StatusInternalServerError {
+			err = h.IdempotencyRepo.ReleaseIdempotent(r.Context(), client, key)
+		} else {
+			err = h.IdempotencyRepo.FinishIdempotent(r.Context(), client, key, &transaction.IdempotentResponse{
+				Status: rec.status,
+				Body:   rec.body.Bytes(),
+			})
+		}
+		if err != nil {
+			h.Logger.Errorw("Idempotency key not saved",
+				"client", client,
+				"key", key,
+				"url", r.URL.Path,
+				"error", err.Error(),
+			)
+		}
+	})
+}
@@ -0,0 +1,125 @@
+package handlers
+
+import (
+	"autumn-2021-intern-assignment/pkg/transaction"
+	"github.com/golang/mock/gomock"
+	"go.uber.org/zap"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestIdempotencyMiddleware(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	// Finish сравнит последовательность вызовов и выведет ошибку если последовательность другая
+	defer ctrl.Finish()
+
+	st := NewMockIdempotencyRepositoryInterface(ctrl)
+
+	service := &IdempotencyHandler{
+		IdempotencyRepo: st,
+		Logger:          zap.NewNop().Sugar(), // не пишет логи
+	}
+
+	calls := 0
+	status := http.StatusOK
+	handler := service.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		body, _ := ioutil.ReadAll(r.Body)
+		if string(body) != `{"id": 1, "balance": 100}` {
+			t.Errorf("body is lost: %s", body)
+		}
+		w.WriteHeader(status)
+		//nolint:errcheck
+		w.Write([]byte(`{"status":"success"}`))
+	}))
+
+	send := func(key string) *http.Response {
+		req := httptest.NewRequest("POST", "/balance/add", strings.NewReader(`{"id": 1, "balance": 100}`))
+		if key != "" {
+			req.Header.Set(IdempotencyKeyHeader, key)
+		}
+		req = req.WithContext(transaction.WithRequester(req.Context(), "shop"))
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w.Result()
+	}
+
+	// first request
+	st.EXPECT().BeginIdempotent(gomock.Any(), "shop", "key-1", gomock.Any()).Return(nil, nil)
+	st.EXPECT().FinishIdempotent(gomock.Any(), "shop", "key-1", &transaction.IdempotentResponse{
+		Status: http.StatusOK,
+		Body:   []byte(`{"status":"success"}`),
+	}).Return(nil)
+
+	resp := send("key-1")
+	if resp.StatusCode != http.StatusOK || calls != 1 {
+		t.Errorf("expected the request to run, status %d, calls %d", resp.StatusCode, calls)
+		return
+	}
+
+	// repeat
+	st.EXPECT().BeginIdempotent(gomock.Any(), "shop", "key-1", gomock.Any()).
+		Return(&transaction.IdempotentResponse{Status: http.StatusOK, Body: []byte(`{"status":"success"}`)}, nil)
+
+	resp = send("key-1")
+	body, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || calls != 1 || string(body) != `{"status":"success"}` ||
+		resp.Header.Get("Idempotent-Replayed") != "true" {
+		t.Errorf("expected the stored answer, status %d, calls %d, body %s", resp.StatusCode, calls, body)
+		return
+	}
+
+	// still running
+	st.EXPECT().BeginIdempotent(gomock.Any(), "shop", "key-2", gomock.Any()).Return(nil, transaction.ErrRequestInProgress)
+
+	resp = send("key-2")
+	if resp.StatusCode != http.StatusConflict || calls != 1 {
+		t.Errorf("expected status %d, got %d", http.StatusConflict, resp.StatusCode)
+		return
+	}
+
+	// failed requests free the key
+	status = http.StatusInternalServerError
+	st.EXPECT().BeginIdempotent(gomock.Any(), "shop", "key-3", gomock.Any()).Return(nil, nil)
+	st.EXPECT().ReleaseIdempotent(gomock.Any(), "shop", "key-3").Return(nil)
+
+	resp = send("key-3")
+	if resp.StatusCode != http.StatusInternalServerError || calls != 2 {
+		t.Errorf("expected status %d, got %d", http.StatusInternalServerError, resp.StatusCode)
+		return
+	}
+
+	// without a key
+	resp = send("")
+	if resp.StatusCode != http.StatusInternalServerError || calls != 3 {
+		t.Errorf("expected the request to run, calls %d", calls)
+		return
+	}
+
+	// the key of another client names another request
+	st.EXPECT().BeginIdempotent(gomock.Any(), "192.0.2.1", "key-1", gomock.Any()).Return(nil, nil)
+	st.EXPECT().ReleaseIdempotent(gomock.Any(), "192.0.2.1", "key-1").Return(nil)
+
+	req := httptest.NewRequest("POST", "/balance/add", strings.NewReader(`{"id": 1, "balance": 100}`))
+	req.Header.Set(IdempotencyKeyHeader, "key-1")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusInternalServerError || calls != 4 {
+		t.Errorf("expected the request to run, status %d, calls %d", w.Code, calls)
+		return
+	}
+
+	// a body too large isn't read to the end
+	req = httptest.NewRequest("POST", "/balance/add", strings.NewReader(strings.Repeat(" ", MaxIdempotentBodySize+1)))
+	req.Header.Set(IdempotencyKeyHeader, "key-4")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusRequestEntityTooLarge || calls != 4 {
+		t.Errorf("expected status %d, got %d", http.StatusRequestEntityTooLarge, w.Code)
+		return
+	}
+}
@@ -2,20 +2,29 @@ package handlers
 
 import (
 	"autumn-2021-intern-assignment/pkg/transaction"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"github.com/gorilla/mux"
 	"go.uber.org/zap"
 	"io/ioutil"
 	"net/http"
+	"strconv"
 	"time"
 )
 
 type ItemsRepositoryInterface interface {
-	GetUsersBalance(userID int, currency string) (*transaction.User, error)
-	AddMoney(userID int, money float64) error
-	WithdrawMoney(userID int, money float64) error
-	TransferMoney(fromUserID int, toUserID int, money float64) error
-	GetTransaction(userID int, orderBy string) ([]*transaction.Transaction, error)
+	GetUsersBalance(ctx context.Context, userID int, currency string) (*transaction.User, error)
+	AddMoney(ctx context.Context, userID int, money float64) (*transaction.Transaction, error)
+	WithdrawMoney(ctx context.Context, userID int, money float64) (*transaction.Transaction, error)
+	TransferMoney(ctx context.Context, fromUserID int, toUserID int, money float64) (*transaction.Transaction, error)
+	RefundMoney(ctx context.Context, transactionID int, money float64) (*transaction.Transaction, error)
+	GetTransaction(ctx context.Context, userID int, orderBy string) ([]*transaction.Transaction, error)
+	GetTransactionPage(ctx context.Context, userID int, orderBy string, after *transaction.Transaction,
+		limit int) ([]*transaction.Transaction, error)
+	GetTransactionByID(ctx context.Context, transactionID int) (*transaction.Transaction, error)
+	ConvertTransactions(ctx context.Context, info []*transaction.Transaction, currency string) error
 }
 
 type ItemsHandler struct {
@@ -43,30 +52,69 @@ func sendData(w http.ResponseWriter, r *http.Request, logger *zap.SugaredLogger,
 }
 
 func receiveData(r *http.Request) (*transaction.User, int, error) {
+	userCurr := &transaction.User{}
+	status, err := decodeBody(r, userCurr)
+	if err != nil {
+		return nil, status, err
+	}
+
+	return userCurr, http.StatusOK, nil
+}
+
+func decodeBody(r *http.Request, data interface{}) (int, error) {
 	b, err := ioutil.ReadAll(r.Body)
 	if err != nil {
-		return nil, http.StatusBadRequest, err
+		return http.StatusBadRequest, err
 	}
 	defer r.Body.Close()
 
-	userCurr := &transaction.User{}
-	err = json.Unmarshal(b, &userCurr)
+	err = json.Unmarshal(b, data)
 	if err != nil {
-		return nil, http.StatusBadRequest, err
+		return http.StatusBadRequest, err
 	}
 
-	return userCurr, http.StatusOK, nil
+	return http.StatusOK, nil
+}
+
+// rateDate adds the date of the currency rates asked for in the date
+// parameter to the context of r.
+func rateDate(r *http.Request) (context.Context, error) {
+	return withRateDate(r.Context(), r.FormValue("date"))
+}
+
+// withRateDate adds the date of the currency rates, like 2021-11-27, to ctx
+// unless it is empty.
+func withRateDate(ctx context.Context, date string) (context.Context, error) {
+	if date == "" {
+		return ctx, nil
+	}
+
+	day, err := time.Parse(transaction.RateDateLayout, date)
+	if err != nil {
+		return nil, fmt.Errorf("bad date %q, like 2021-11-27 expected", date)
+	}
+	return transaction.WithRateDate(ctx, day), nil
 }
 
-func sendSuccessStatus(w http.ResponseWriter, r *http.Request, logger *zap.SugaredLogger) {
-	status := make(map[string]string, 1)
-	status["status"] = "success"
-	sendData(w, r, logger, status)
+func sendSuccessStatus(w http.ResponseWriter, r *http.Request, logger *zap.SugaredLogger, tr *transaction.Transaction) {
+	sendData(w, r, logger, &transaction.OperationStatus{Status: "success", Transaction: tr})
 }
 
 func sendError(w http.ResponseWriter, r *http.Request, logger *zap.SugaredLogger, errCurr error, status int) {
-	data := make(map[string]string, 1)
-	data["error"] = errCurr.Error()
+	data := &transaction.ErrorResponse{Error: errCurr.Error(), Code: transaction.ErrorCode(errCurr)}
+
+	limitErr := &transaction.LimitError{}
+	if errors.As(errCurr, &limitErr) {
+		data.Limit = limitErr.Limit
+	}
+	riskErr := &transaction.RiskError{}
+	if errors.As(errCurr, &riskErr) {
+		data.ReviewID = riskErr.ReviewID
+	}
+	pendingErr := &transaction.PendingError{}
+	if errors.As(errCurr, &pendingErr) {
+		data.PendingID = pendingErr.Pending.ID
+	}
 
 	dataJSON, err := json.Marshal(data)
 	if err != nil {
@@ -91,6 +139,71 @@ func sendError(w http.ResponseWriter, r *http.Request, logger *zap.SugaredLogger
 	)
 }
 
+func errorStatus(err error) int {
+	switch {
+	case errors.Is(err, transaction.ErrTransactionNotFound),
+		errors.Is(err, transaction.ErrAccountNotFound),
+		errors.Is(err, transaction.ErrFeeRuleNotFound),
+		errors.Is(err, transaction.ErrScheduleNotFound),
+		errors.Is(err, transaction.ErrReportNotFound),
+		errors.Is(err, transaction.ErrNoReconciliation),
+		errors.Is(err, transaction.ErrReviewNotFound),
+		errors.Is(err, transaction.ErrPendingNotFound),
+		errors.Is(err, transaction.ErrRateNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, transaction.ErrAccountExists),
+		errors.Is(err, transaction.ErrAccountFrozen),
+		errors.Is(err, transaction.ErrAccountClosed),
+		errors.Is(err, transaction.ErrAccountNotEmpty),
+		errors.Is(err, transaction.ErrScheduleFinished),
+		errors.Is(err, transaction.ErrReconcileRunning),
+		errors.Is(err, transaction.ErrRequestInProgress),
+		errors.Is(err, transaction.ErrReviewDecided),
+		errors.Is(err, transaction.ErrPendingDecided),
+		errors.Is(err, transaction.ErrPendingExpired):
+		return http.StatusConflict
+	case errors.Is(err, transaction.ErrNegativeAmount),
+		errors.Is(err, transaction.ErrNotEnoughMoney),
+		errors.Is(err, transaction.ErrNotRefundable),
+		errors.Is(err, transaction.ErrRefundExceeded),
+		errors.Is(err, transaction.ErrBadAccountID),
+		errors.Is(err, transaction.ErrBadOperation),
+		errors.Is(err, transaction.ErrBadSchedule),
+		errors.Is(err, transaction.ErrBadSplit),
+		errors.Is(err, transaction.ErrBadReportTime),
+		errors.Is(err, transaction.ErrBadAdjustment),
+		errors.Is(err, transaction.ErrHistoryArchived),
+		errors.Is(err, transaction.ErrBadReview),
+		errors.Is(err, transaction.ErrBadApproval),
+		errors.Is(err, transaction.ErrBadBonus),
+		errors.Is(err, transaction.ErrBadRates),
+		errors.Is(err, transaction.ErrBadFeeRule):
+		return http.StatusBadRequest
+	case errors.Is(err, transaction.ErrUnauthorized):
+		return http.StatusUnauthorized
+	case errors.Is(err, transaction.ErrRiskDenied),
+		errors.Is(err, transaction.ErrSameApprover),
+		errors.Is(err, transaction.ErrForbidden):
+		return http.StatusForbidden
+	case errors.Is(err, transaction.ErrRiskReview),
+		errors.Is(err, transaction.ErrApprovalRequired):
+		// the operation is not done yet, it may be approved later
+		return http.StatusAccepted
+	case errors.Is(err, transaction.ErrLimitExceeded),
+		errors.Is(err, transaction.ErrBatchFailed),
+		errors.Is(err, transaction.ErrApprovalInBatch),
+		errors.Is(err, transaction.ErrIdempotencyMismatch):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, transaction.ErrRateLimited):
+		return http.StatusTooManyRequests
+	case errors.Is(err, transaction.ErrBatchTooLarge):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout
+	}
+	return http.StatusInternalServerError
+}
+
 func (h ItemsHandler) GetBalanceFromUser(w http.ResponseWriter, r *http.Request) {
 	userCurr, status, err := receiveData(r)
 	if err != nil {
@@ -99,10 +212,15 @@ func (h ItemsHandler) GetBalanceFromUser(w http.ResponseWriter, r *http.Request)
 	}
 
 	userCurr.Currency = r.FormValue("currency")
+	ctx, err := rateDate(r)
+	if err != nil {
+		sendError(w, r, h.Logger, err, http.StatusBadRequest)
+		return
+	}
 
-	tx, err := h.ItemRepo.GetUsersBalance(userCurr.UserID, userCurr.Currency)
+	tx, err := h.ItemRepo.GetUsersBalance(ctx, userCurr.UserID, userCurr.Currency)
 	if err != nil {
-		sendError(w, r, h.Logger, err, http.StatusInternalServerError)
+		sendError(w, r, h.Logger, err, errorStatus(err))
 		return
 	}
 
@@ -116,13 +234,13 @@ func (h ItemsHandler) IncreaseBalance(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = h.ItemRepo.AddMoney(userCurr.UserID, userCurr.Balance)
+	tr, err := h.ItemRepo.AddMoney(r.Context(), userCurr.UserID, userCurr.Balance)
 	if err != nil {
-		sendError(w, r, h.Logger, err, http.StatusInternalServerError)
+		sendError(w, r, h.Logger, err, errorStatus(err))
 		return
 	}
 
-	sendSuccessStatus(w, r, h.Logger)
+	sendSuccessStatus(w, r, h.Logger, tr)
 }
 
 func (h ItemsHandler) DecreaseBalance(w http.ResponseWriter, r *http.Request) {
@@ -132,13 +250,17 @@ func (h ItemsHandler) DecreaseBalance(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = h.ItemRepo.WithdrawMoney(userCurr.UserID, userCurr.Balance)
+	// the service decides which bonuses pay for it, the requester of a large
+	// one is set by AuthHandler
+	ctx := transaction.WithService(r.Context(), userCurr.Service)
+	ctx = transaction.WithOperationCurrency(ctx, r.FormValue("currency"))
+	tr, err := h.ItemRepo.WithdrawMoney(ctx, userCurr.UserID, userCurr.Balance)
 	if err != nil {
-		sendError(w, r, h.Logger, err, http.StatusInternalServerError)
+		sendError(w, r, h.Logger, err, errorStatus(err))
 		return
 	}
 
-	sendSuccessStatus(w, r, h.Logger)
+	sendSuccessStatus(w, r, h.Logger, tr)
 }
 
 func (h ItemsHandler) TransferBalance(w http.ResponseWriter, r *http.Request) {
@@ -148,13 +270,31 @@ func (h ItemsHandler) TransferBalance(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = h.ItemRepo.TransferMoney(userCurr.UserID, userCurr.ToUserID, userCurr.Balance)
+	// the money and the fee rules are in the currency asked for
+	ctx := transaction.WithOperationCurrency(r.Context(), r.FormValue("currency"))
+	tr, err := h.ItemRepo.TransferMoney(ctx, userCurr.UserID, userCurr.ToUserID, userCurr.Balance)
 	if err != nil {
-		sendError(w, r, h.Logger, err, http.StatusInternalServerError)
+		sendError(w, r, h.Logger, err, errorStatus(err))
 		return
 	}
 
-	sendSuccessStatus(w, r, h.Logger)
+	sendSuccessStatus(w, r, h.Logger, tr)
+}
+
+func (h ItemsHandler) RefundBalance(w http.ResponseWriter, r *http.Request) {
+	userCurr, status, err := receiveData(r)
+	if err != nil {
+		sendError(w, r, h.Logger, err, status)
+		return
+	}
+
+	tr, err := h.ItemRepo.RefundMoney(r.Context(), userCurr.TransactionID, userCurr.Balance)
+	if err != nil {
+		sendError(w, r, h.Logger, err, errorStatus(err))
+		return
+	}
+
+	sendSuccessStatus(w, r, h.Logger, tr)
 }
 
 func (h ItemsHandler) ListTransaction(w http.ResponseWriter, r *http.Request) {
@@ -164,11 +304,42 @@ func (h ItemsHandler) ListTransaction(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	info, err := h.ItemRepo.GetTransaction(userCurr.UserID, userCurr.Field)
+	ctx, err := rateDate(r)
 	if err != nil {
-		sendError(w, r, h.Logger, err, http.StatusInternalServerError)
+		sendError(w, r, h.Logger, err, http.StatusBadRequest)
 		return
 	}
 
+	info, err := h.ItemRepo.GetTransaction(ctx, userCurr.UserID, userCurr.Field)
+	if err != nil {
+		sendError(w, r, h.Logger, err, errorStatus(err))
+		return
+	}
+
+	currency := r.FormValue("currency")
+	if currency != "" {
+		err = h.ItemRepo.ConvertTransactions(ctx, info, currency)
+		if err != nil {
+			sendError(w, r, h.Logger, err, errorStatus(err))
+			return
+		}
+	}
+
 	sendData(w, r, h.Logger, info)
 }
+
+func (h ItemsHandler) GetTransactionInfo(w http.ResponseWriter, r *http.Request) {
+	transactionID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		sendError(w, r, h.Logger, fmt.Errorf("bad transaction id"), http.StatusBadRequest)
+		return
+	}
+
+	tr, err := h.ItemRepo.GetTransactionByID(r.Context(), transactionID)
+	if err != nil {
+		sendError(w, r, h.Logger, err, errorStatus(err))
+		return
+	}
+
+	sendData(w, r, h.Logger, tr)
+}
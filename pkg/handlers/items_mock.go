@@ -6,6 +6,7 @@ package handlers
 
 import (
 	transaction "autumn-2021-intern-assignment/pkg/transaction"
+	context "context"
 	reflect "reflect"
 
 	gomock "github.com/golang/mock/gomock"
@@ -35,73 +36,135 @@ func (m *MockItemsRepositoryInterface) EXPECT() *MockItemsRepositoryInterfaceMoc
 }
 
 // AddMoney mocks base method.
-func (m *MockItemsRepositoryInterface) AddMoney(userID int, money float64) error {
+func (m *MockItemsRepositoryInterface) AddMoney(ctx context.Context, userID int, money float64) (*transaction.Transaction, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "AddMoney", userID, money)
+	ret := m.ctrl.Call(m, "AddMoney", ctx, userID, money)
+	ret0, _ := ret[0].(*transaction.Transaction)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// AddMoney indicates an expected call of AddMoney.
+func (mr *MockItemsRepositoryInterfaceMockRecorder) AddMoney(ctx, userID, money interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AddMoney", reflect.TypeOf((*MockItemsRepositoryInterface)(nil).AddMoney), ctx, userID, money)
+}
+
+// ConvertTransactions mocks base method.
+func (m *MockItemsRepositoryInterface) ConvertTransactions(ctx context.Context, info []*transaction.Transaction, currency string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ConvertTransactions", ctx, info, currency)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
-// AddMoney indicates an expected call of AddMoney.
-func (mr *MockItemsRepositoryInterfaceMockRecorder) AddMoney(userID, money interface{}) *gomock.Call {
+// ConvertTransactions indicates an expected call of ConvertTransactions.
+func (mr *MockItemsRepositoryInterfaceMockRecorder) ConvertTransactions(ctx, info, currency interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AddMoney", reflect.TypeOf((*MockItemsRepositoryInterface)(nil).AddMoney), userID, money)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ConvertTransactions", reflect.TypeOf((*MockItemsRepositoryInterface)(nil).ConvertTransactions), ctx, info, currency)
 }
 
 // GetTransaction mocks base method.
-func (m *MockItemsRepositoryInterface) GetTransaction(userID int, orderBy string) ([]*transaction.Transaction, error) {
+func (m *MockItemsRepositoryInterface) GetTransaction(ctx context.Context, userID int, orderBy string) ([]*transaction.Transaction, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GetTransaction", userID, orderBy)
+	ret := m.ctrl.Call(m, "GetTransaction", ctx, userID, orderBy)
 	ret0, _ := ret[0].([]*transaction.Transaction)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // GetTransaction indicates an expected call of GetTransaction.
-func (mr *MockItemsRepositoryInterfaceMockRecorder) GetTransaction(userID, orderBy interface{}) *gomock.Call {
+func (mr *MockItemsRepositoryInterfaceMockRecorder) GetTransaction(ctx, userID, orderBy interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetTransaction", reflect.TypeOf((*MockItemsRepositoryInterface)(nil).GetTransaction), ctx, userID, orderBy)
+}
+
+// GetTransactionByID mocks base method.
+func (m *MockItemsRepositoryInterface) GetTransactionByID(ctx context.Context, transactionID int) (*transaction.Transaction, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetTransactionByID", ctx, transactionID)
+	ret0, _ := ret[0].(*transaction.Transaction)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetTransactionByID indicates an expected call of GetTransactionByID.
+func (mr *MockItemsRepositoryInterfaceMockRecorder) GetTransactionByID(ctx, transactionID interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetTransaction", reflect.TypeOf((*MockItemsRepositoryInterface)(nil).GetTransaction), userID, orderBy)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetTransactionByID", reflect.TypeOf((*MockItemsRepositoryInterface)(nil).GetTransactionByID), ctx, transactionID)
+}
+
+// GetTransactionPage mocks base method.
+func (m *MockItemsRepositoryInterface) GetTransactionPage(ctx context.Context, userID int, orderBy string, after *transaction.Transaction, limit int) ([]*transaction.Transaction, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetTransactionPage", ctx, userID, orderBy, after, limit)
+	ret0, _ := ret[0].([]*transaction.Transaction)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetTransactionPage indicates an expected call of GetTransactionPage.
+func (mr *MockItemsRepositoryInterfaceMockRecorder) GetTransactionPage(ctx, userID, orderBy, after, limit interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetTransactionPage", reflect.TypeOf((*MockItemsRepositoryInterface)(nil).GetTransactionPage), ctx, userID, orderBy, after, limit)
 }
 
 // GetUsersBalance mocks base method.
-func (m *MockItemsRepositoryInterface) GetUsersBalance(userID int, currency string) (*transaction.User, error) {
+func (m *MockItemsRepositoryInterface) GetUsersBalance(ctx context.Context, userID int, currency string) (*transaction.User, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GetUsersBalance", userID, currency)
+	ret := m.ctrl.Call(m, "GetUsersBalance", ctx, userID, currency)
 	ret0, _ := ret[0].(*transaction.User)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // GetUsersBalance indicates an expected call of GetUsersBalance.
-func (mr *MockItemsRepositoryInterfaceMockRecorder) GetUsersBalance(userID, currency interface{}) *gomock.Call {
+func (mr *MockItemsRepositoryInterfaceMockRecorder) GetUsersBalance(ctx, userID, currency interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetUsersBalance", reflect.TypeOf((*MockItemsRepositoryInterface)(nil).GetUsersBalance), userID, currency)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetUsersBalance", reflect.TypeOf((*MockItemsRepositoryInterface)(nil).GetUsersBalance), ctx, userID, currency)
+}
+
+// RefundMoney mocks base method.
+func (m *MockItemsRepositoryInterface) RefundMoney(ctx context.Context, transactionID int, money float64) (*transaction.Transaction, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RefundMoney", ctx, transactionID, money)
+	ret0, _ := ret[0].(*transaction.Transaction)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// RefundMoney indicates an expected call of RefundMoney.
+func (mr *MockItemsRepositoryInterfaceMockRecorder) RefundMoney(ctx, transactionID, money interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RefundMoney", reflect.TypeOf((*MockItemsRepositoryInterface)(nil).RefundMoney), ctx, transactionID, money)
 }
 
 // TransferMoney mocks base method.
-func (m *MockItemsRepositoryInterface) TransferMoney(fromUserID, toUserID int, money float64) error {
+func (m *MockItemsRepositoryInterface) TransferMoney(ctx context.Context, fromUserID, toUserID int, money float64) (*transaction.Transaction, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "TransferMoney", fromUserID, toUserID, money)
-	ret0, _ := ret[0].(error)
-	return ret0
+	ret := m.ctrl.Call(m, "TransferMoney", ctx, fromUserID, toUserID, money)
+	ret0, _ := ret[0].(*transaction.Transaction)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
 }
 
 // TransferMoney indicates an expected call of TransferMoney.
-func (mr *MockItemsRepositoryInterfaceMockRecorder) TransferMoney(fromUserID, toUserID, money interface{}) *gomock.Call {
+func (mr *MockItemsRepositoryInterfaceMockRecorder) TransferMoney(ctx, fromUserID, toUserID, money interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "TransferMoney", reflect.TypeOf((*MockItemsRepositoryInterface)(nil).TransferMoney), fromUserID, toUserID, money)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "TransferMoney", reflect.TypeOf((*MockItemsRepositoryInterface)(nil).TransferMoney), ctx, fromUserID, toUserID, money)
 }
 
 // WithdrawMoney mocks base method.
-func (m *MockItemsRepositoryInterface) WithdrawMoney(userID int, money float64) error {
+func (m *MockItemsRepositoryInterface) WithdrawMoney(ctx context.Context, userID int, money float64) (*transaction.Transaction, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "WithdrawMoney", userID, money)
-	ret0, _ := ret[0].(error)
-	return ret0
+	ret := m.ctrl.Call(m, "WithdrawMoney", ctx, userID, money)
+	ret0, _ := ret[0].(*transaction.Transaction)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
 }
 
 // WithdrawMoney indicates an expected call of WithdrawMoney.
-func (mr *MockItemsRepositoryInterfaceMockRecorder) WithdrawMoney(userID, money interface{}) *gomock.Call {
+func (mr *MockItemsRepositoryInterfaceMockRecorder) WithdrawMoney(ctx, userID, money interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "WithdrawMoney", reflect.TypeOf((*MockItemsRepositoryInterface)(nil).WithdrawMoney), userID, money)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "WithdrawMoney", reflect.TypeOf((*MockItemsRepositoryInterface)(nil).WithdrawMoney), ctx, userID, money)
 }
@@ -3,9 +3,11 @@ package handlers
 import (
 	"autumn-2021-intern-assignment/pkg/transaction"
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"github.com/golang/mock/gomock"
+	"github.com/gorilla/mux"
 	"go.uber.org/zap"
 	"io/ioutil"
 	"net/http"
@@ -43,7 +45,7 @@ func TestGetBalanceFromUser(t *testing.T) {
 	}
 	bodyReader := strings.NewReader(string(b))
 
-	st.EXPECT().GetUsersBalance(elemID, "").Return(resultItem, nil)
+	st.EXPECT().GetUsersBalance(gomock.Any(), elemID, "").Return(resultItem, nil)
 
 	req := httptest.NewRequest("POST", "/user", bodyReader)
 	w := httptest.NewRecorder()
@@ -88,7 +90,7 @@ func TestGetBalanceFromUser(t *testing.T) {
 
 	// result error
 
-	st.EXPECT().GetUsersBalance(elemID, "").Return(resultItem, fmt.Errorf("bad result"))
+	st.EXPECT().GetUsersBalance(gomock.Any(), elemID, "").Return(resultItem, fmt.Errorf("bad result"))
 	bodyReader = strings.NewReader(string(b))
 	req = httptest.NewRequest("POST", "/create", bodyReader)
 	w = httptest.NewRecorder()
@@ -102,6 +104,27 @@ func TestGetBalanceFromUser(t *testing.T) {
 		t.Errorf("expected resp status 500, got %d", resp.StatusCode)
 		return
 	}
+
+	// timeout, the repository gets the context of the request
+
+	reqCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	st.EXPECT().AddMoney(reqCtx, resultItem.UserID, resultItem.Balance).Return(nil, context.DeadlineExceeded)
+	bodyReader = strings.NewReader(string(b))
+	req = httptest.NewRequest("POST", "/balance/add", bodyReader).WithContext(reqCtx)
+	w = httptest.NewRecorder()
+	service.IncreaseBalance(w, req)
+
+	resp = w.Result()
+	//nolint:errcheck
+	defer resp.Body.Close()
+	//nolint:errcheck
+	body, _ = ioutil.ReadAll(resp.Body)
+
+	if resp.StatusCode != http.StatusGatewayTimeout || !bytes.Contains(body, []byte(`"code":"timeout"`)) {
+		t.Errorf("expected resp status 504 with code timeout, got %d %s", resp.StatusCode, body)
+		return
+	}
 }
 
 //nolint:dupl
@@ -131,7 +154,7 @@ func TestIncreaseBalance(t *testing.T) {
 	}
 	bodyReader := strings.NewReader(string(b))
 
-	st.EXPECT().AddMoney(resultItem.UserID, resultItem.Balance).Return(nil)
+	st.EXPECT().AddMoney(gomock.Any(), resultItem.UserID, resultItem.Balance).Return(&transaction.Transaction{ID: 10}, nil)
 
 	req := httptest.NewRequest("POST", "/balance/add", bodyReader)
 	w := httptest.NewRecorder()
@@ -149,6 +172,11 @@ func TestIncreaseBalance(t *testing.T) {
 		return
 	}
 
+	if !bytes.Contains(body, []byte(`"id":10`)) {
+		t.Errorf("no transaction found")
+		return
+	}
+
 	// marshaling error
 
 	bodyReader = strings.NewReader("mess1111ag11e:1qq11111powei")
@@ -172,7 +200,7 @@ func TestIncreaseBalance(t *testing.T) {
 
 	// result error
 
-	st.EXPECT().AddMoney(resultItem.UserID, resultItem.Balance).Return(fmt.Errorf("bad result"))
+	st.EXPECT().AddMoney(gomock.Any(), resultItem.UserID, resultItem.Balance).Return(nil, fmt.Errorf("bad result"))
 	bodyReader = strings.NewReader(string(b))
 	req = httptest.NewRequest("POST", "/balance/add", bodyReader)
 	w = httptest.NewRecorder()
@@ -215,7 +243,7 @@ func TestDecreaseBalance(t *testing.T) {
 	}
 	bodyReader := strings.NewReader(string(b))
 
-	st.EXPECT().WithdrawMoney(resultItem.UserID, resultItem.Balance).Return(nil)
+	st.EXPECT().WithdrawMoney(gomock.Any(), resultItem.UserID, resultItem.Balance).Return(&transaction.Transaction{ID: 10}, nil)
 
 	req := httptest.NewRequest("POST", "/balance/reduce", bodyReader)
 	w := httptest.NewRecorder()
@@ -233,6 +261,11 @@ func TestDecreaseBalance(t *testing.T) {
 		return
 	}
 
+	if !bytes.Contains(body, []byte(`"id":10`)) {
+		t.Errorf("no transaction found")
+		return
+	}
+
 	// marshaling error
 
 	bodyReader = strings.NewReader("mess1111ag11e:1qq11111powei")
@@ -258,7 +291,7 @@ func TestDecreaseBalance(t *testing.T) {
 
 	// result error
 
-	st.EXPECT().WithdrawMoney(resultItem.UserID, resultItem.Balance).Return(fmt.Errorf("bad result"))
+	st.EXPECT().WithdrawMoney(gomock.Any(), resultItem.UserID, resultItem.Balance).Return(nil, fmt.Errorf("bad result"))
 	bodyReader = strings.NewReader(string(b))
 	req = httptest.NewRequest("POST", "/balance/reduce", bodyReader)
 	w = httptest.NewRecorder()
@@ -272,6 +305,23 @@ func TestDecreaseBalance(t *testing.T) {
 		t.Errorf("expected resp status 500, got %d", resp.StatusCode)
 		return
 	}
+
+	// the money is in the currency asked for
+	st.EXPECT().WithdrawMoney(gomock.Any(), resultItem.UserID, resultItem.Balance).DoAndReturn(
+		func(ctx context.Context, userID int, money float64) (*transaction.Transaction, error) {
+			if transaction.OperationCurrency(ctx) != "USD" {
+				t.Errorf("expected the currency USD, have %q", transaction.OperationCurrency(ctx))
+			}
+			return &transaction.Transaction{ID: 11}, nil
+		})
+	req = httptest.NewRequest("POST", "/balance/reduce?currency=USD", strings.NewReader(string(b)))
+	w = httptest.NewRecorder()
+	service.DecreaseBalance(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("expected resp status 200, got %d", w.Code)
+		return
+	}
 }
 
 func TestTransferBalance(t *testing.T) {
@@ -301,7 +351,7 @@ func TestTransferBalance(t *testing.T) {
 	}
 	bodyReader := strings.NewReader(string(b))
 
-	st.EXPECT().TransferMoney(resultItem.UserID, resultItem.ToUserID, resultItem.Balance).Return(nil)
+	st.EXPECT().TransferMoney(gomock.Any(), resultItem.UserID, resultItem.ToUserID, resultItem.Balance).Return(&transaction.Transaction{ID: 10}, nil)
 
 	req := httptest.NewRequest("POST", "/balance/transfer", bodyReader)
 	w := httptest.NewRecorder()
@@ -319,6 +369,11 @@ func TestTransferBalance(t *testing.T) {
 		return
 	}
 
+	if !bytes.Contains(body, []byte(`"id":10`)) {
+		t.Errorf("no transaction found")
+		return
+	}
+
 	// marshaling error
 
 	bodyReader = strings.NewReader("mess1111ag11e:1qq11111powei")
@@ -342,9 +397,35 @@ func TestTransferBalance(t *testing.T) {
 		return
 	}
 
+	// limit exceeded
+
+	st.EXPECT().TransferMoney(gomock.Any(), resultItem.UserID, resultItem.ToUserID, resultItem.Balance).
+		Return(nil, &transaction.LimitError{Limit: transaction.LimitHourlyTransfers})
+	bodyReader = strings.NewReader(string(b))
+	req = httptest.NewRequest("POST", "/balance/transfer", bodyReader)
+	w = httptest.NewRecorder()
+	service.TransferBalance(w, req)
+
+	resp = w.Result()
+	//nolint:errcheck
+	defer resp.Body.Close()
+
+	//nolint:errcheck
+	body, _ = ioutil.ReadAll(resp.Body)
+
+	if resp.StatusCode != http.StatusUnprocessableEntity {
+		t.Errorf("expected resp status 422, got %d", resp.StatusCode)
+		return
+	}
+
+	if !bytes.Contains(body, []byte(`"code":"limit_exceeded"`)) || !bytes.Contains(body, []byte(`"limit":"hourly_transfers"`)) {
+		t.Errorf("no limit code found: %s", body)
+		return
+	}
+
 	// result error
 
-	st.EXPECT().TransferMoney(resultItem.UserID, resultItem.ToUserID, resultItem.Balance).Return(fmt.Errorf("bad result"))
+	st.EXPECT().TransferMoney(gomock.Any(), resultItem.UserID, resultItem.ToUserID, resultItem.Balance).Return(nil, fmt.Errorf("bad result"))
 	bodyReader = strings.NewReader(string(b))
 	req = httptest.NewRequest("POST", "/balance/transfer", bodyReader)
 	w = httptest.NewRecorder()
@@ -360,6 +441,122 @@ func TestTransferBalance(t *testing.T) {
 	}
 }
 
+func TestRefundBalance(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	// Finish сравнит последовательность вызовов и выведет ошибку если последовательность другая
+	defer ctrl.Finish()
+
+	st := NewMockItemsRepositoryInterface(ctrl)
+
+	service := &ItemsHandler{
+		ItemRepo: st,
+		Logger:   zap.NewNop().Sugar(), // не пишет логи
+	}
+
+	resultItem := &transaction.User{
+		TransactionID: 5,
+		Balance:       20.0,
+	}
+
+	b, err := json.Marshal(resultItem)
+	if err != nil {
+		t.Errorf("internal error")
+		return
+	}
+	bodyReader := strings.NewReader(string(b))
+
+	st.EXPECT().RefundMoney(gomock.Any(), resultItem.TransactionID, resultItem.Balance).Return(&transaction.Transaction{ID: 10}, nil)
+
+	req := httptest.NewRequest("POST", "/balance/refund", bodyReader)
+	w := httptest.NewRecorder()
+	service.RefundBalance(w, req)
+
+	resp := w.Result()
+	//nolint:errcheck
+	defer resp.Body.Close()
+
+	//nolint:errcheck
+	body, _ := ioutil.ReadAll(resp.Body)
+
+	if !bytes.Contains(body, []byte("success")) {
+		t.Errorf("no text found")
+		return
+	}
+
+	if !bytes.Contains(body, []byte(`"id":10`)) {
+		t.Errorf("no transaction found")
+		return
+	}
+
+	// marshaling error
+
+	bodyReader = strings.NewReader("mess1111ag11e:1qq11111powei")
+	req = httptest.NewRequest("POST", "/balance/refund", bodyReader)
+	w = httptest.NewRecorder()
+	service.RefundBalance(w, req)
+
+	resp = w.Result()
+	//nolint:errcheck
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected resp status 400, got %d", resp.StatusCode)
+		return
+	}
+
+	// unknown transaction
+
+	st.EXPECT().RefundMoney(gomock.Any(), resultItem.TransactionID, resultItem.Balance).Return(nil, transaction.ErrTransactionNotFound)
+	bodyReader = strings.NewReader(string(b))
+	req = httptest.NewRequest("POST", "/balance/refund", bodyReader)
+	w = httptest.NewRecorder()
+	service.RefundBalance(w, req)
+
+	resp = w.Result()
+	//nolint:errcheck
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expected resp status 404, got %d", resp.StatusCode)
+		return
+	}
+
+	// refund exceeds charge
+
+	st.EXPECT().RefundMoney(gomock.Any(), resultItem.TransactionID, resultItem.Balance).Return(nil, transaction.ErrRefundExceeded)
+	bodyReader = strings.NewReader(string(b))
+	req = httptest.NewRequest("POST", "/balance/refund", bodyReader)
+	w = httptest.NewRecorder()
+	service.RefundBalance(w, req)
+
+	resp = w.Result()
+	//nolint:errcheck
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected resp status 400, got %d", resp.StatusCode)
+		return
+	}
+
+	// result error
+
+	st.EXPECT().RefundMoney(gomock.Any(), resultItem.TransactionID, resultItem.Balance).Return(nil, fmt.Errorf("bad result"))
+	bodyReader = strings.NewReader(string(b))
+	req = httptest.NewRequest("POST", "/balance/refund", bodyReader)
+	w = httptest.NewRecorder()
+	service.RefundBalance(w, req)
+
+	resp = w.Result()
+	//nolint:errcheck
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 500 {
+		t.Errorf("expected resp status 500, got %d", resp.StatusCode)
+		return
+	}
+}
+
 func TestListTransaction(t *testing.T) {
 	ctrl := gomock.NewController(t)
 
@@ -395,7 +592,7 @@ func TestListTransaction(t *testing.T) {
 	}
 	bodyReader := strings.NewReader(string(b))
 
-	st.EXPECT().GetTransaction(elemID, "").Return(resultItem, nil)
+	st.EXPECT().GetTransaction(gomock.Any(), elemID, "").Return(resultItem, nil)
 
 	req := httptest.NewRequest("POST", "/info", bodyReader)
 	w := httptest.NewRecorder()
@@ -438,7 +635,7 @@ func TestListTransaction(t *testing.T) {
 
 	// result error
 
-	st.EXPECT().GetTransaction(elemID, "").Return(nil, fmt.Errorf("bad result"))
+	st.EXPECT().GetTransaction(gomock.Any(), elemID, "").Return(nil, fmt.Errorf("bad result"))
 	bodyReader = strings.NewReader(string(b))
 	req = httptest.NewRequest("POST", "/info", bodyReader)
 	w = httptest.NewRecorder()
@@ -454,6 +651,82 @@ func TestListTransaction(t *testing.T) {
 	}
 }
 
+func TestGetTransactionInfo(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	// Finish сравнит последовательность вызовов и выведет ошибку если последовательность другая
+	defer ctrl.Finish()
+
+	st := NewMockItemsRepositoryInterface(ctrl)
+
+	service := &ItemsHandler{
+		ItemRepo: st,
+		Logger:   zap.NewNop().Sugar(), // не пишет логи
+	}
+
+	elemID := 1
+	resultItem := &transaction.Transaction{
+		ID:      5,
+		FromID:  &elemID,
+		Money:   -50,
+		Created: time.Now().UTC().Truncate(time.Second),
+	}
+
+	st.EXPECT().GetTransactionByID(gomock.Any(), 5).Return(resultItem, nil)
+
+	req := httptest.NewRequest("GET", "/transactions/5", nil)
+	req = mux.SetURLVars(req, map[string]string{"id": "5"})
+	w := httptest.NewRecorder()
+	service.GetTransactionInfo(w, req)
+
+	resp := w.Result()
+	//nolint:errcheck
+	defer resp.Body.Close()
+
+	//nolint:errcheck
+	body, _ := ioutil.ReadAll(resp.Body)
+
+	tr := &transaction.Transaction{}
+	err := json.Unmarshal(body, tr)
+	if err != nil || !reflect.DeepEqual(tr, resultItem) {
+		t.Errorf("results not match, want %v, have %v", resultItem, tr)
+		return
+	}
+
+	// bad id
+
+	req = httptest.NewRequest("GET", "/transactions/abc", nil)
+	req = mux.SetURLVars(req, map[string]string{"id": "abc"})
+	w = httptest.NewRecorder()
+	service.GetTransactionInfo(w, req)
+
+	resp = w.Result()
+	//nolint:errcheck
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected resp status 400, got %d", resp.StatusCode)
+		return
+	}
+
+	// not found
+
+	st.EXPECT().GetTransactionByID(gomock.Any(), 6).Return(nil, transaction.ErrTransactionNotFound)
+	req = httptest.NewRequest("GET", "/transactions/6", nil)
+	req = mux.SetURLVars(req, map[string]string{"id": "6"})
+	w = httptest.NewRecorder()
+	service.GetTransactionInfo(w, req)
+
+	resp = w.Result()
+	//nolint:errcheck
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expected resp status 404, got %d", resp.StatusCode)
+		return
+	}
+}
+
 func TestSendData(t *testing.T) {
 
 }
@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"autumn-2021-intern-assignment/pkg/transaction"
+	"context"
+	"go.uber.org/zap"
+	"net/http"
+)
+
+type LimitsRepositoryInterface interface {
+	GetLimits(ctx context.Context, userID int) (*transaction.Limits, error)
+	SetLimits(ctx context.Context, l *transaction.Limits) (*transaction.Limits, error)
+	DeleteLimits(ctx context.Context, userID int) error
+}
+
+type LimitsHandler struct {
+	LimitRepo LimitsRepositoryInterface
+	Logger    *zap.SugaredLogger
+}
+
+// mockgen -source=limits.go -destination=limits_mock.go -package=handlers LimitsRepositoryInterface
+
+// limits of account 0 are the defaults
+func (h LimitsHandler) GetLimits(w http.ResponseWriter, r *http.Request) {
+	userID, err := accountID(r)
+	if err != nil {
+		sendError(w, r, h.Logger, err, http.StatusBadRequest)
+		return
+	}
+
+	l, err := h.LimitRepo.GetLimits(r.Context(), userID)
+	if err != nil {
+		sendError(w, r, h.Logger, err, errorStatus(err))
+		return
+	}
+
+	sendData(w, r, h.Logger, l)
+}
+
+func (h LimitsHandler) SetLimits(w http.ResponseWriter, r *http.Request) {
+	userID, err := accountID(r)
+	if err != nil {
+		sendError(w, r, h.Logger, err, http.StatusBadRequest)
+		return
+	}
+
+	req := &transaction.Limits{}
+	status, err := decodeBody(r, req)
+	if err != nil {
+		sendError(w, r, h.Logger, err, status)
+		return
+	}
+	req.UserID = userID
+
+	l, err := h.LimitRepo.SetLimits(r.Context(), req)
+	if err != nil {
+		sendError(w, r, h.Logger, err, errorStatus(err))
+		return
+	}
+
+	sendData(w, r, h.Logger, l)
+}
+
+func (h LimitsHandler) DeleteLimits(w http.ResponseWriter, r *http.Request) {
+	userID, err := accountID(r)
+	if err != nil {
+		sendError(w, r, h.Logger, err, http.StatusBadRequest)
+		return
+	}
+
+	err = h.LimitRepo.DeleteLimits(r.Context(), userID)
+	if err != nil {
+		sendError(w, r, h.Logger, err, errorStatus(err))
+		return
+	}
+
+	sendData(w, r, h.Logger, map[string]string{"status": "success"})
+}
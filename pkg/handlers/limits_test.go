@@ -0,0 +1,189 @@
+package handlers
+
+import (
+	"autumn-2021-intern-assignment/pkg/transaction"
+	"encoding/json"
+	"fmt"
+	"github.com/golang/mock/gomock"
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestGetLimits(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	// Finish сравнит последовательность вызовов и выведет ошибку если последовательность другая
+	defer ctrl.Finish()
+
+	st := NewMockLimitsRepositoryInterface(ctrl)
+
+	service := &LimitsHandler{
+		LimitRepo: st,
+		Logger:    zap.NewNop().Sugar(), // не пишет логи
+	}
+
+	daily := 1000.0
+	resultItem := &transaction.Limits{UserID: transaction.DefaultLimitsID, DailyDebit: &daily}
+
+	st.EXPECT().GetLimits(gomock.Any(), transaction.DefaultLimitsID).Return(resultItem, nil)
+
+	req := httptest.NewRequest("GET", "/admin/limits/0", nil)
+	req = mux.SetURLVars(req, map[string]string{"id": "0"})
+	w := httptest.NewRecorder()
+	service.GetLimits(w, req)
+
+	resp := w.Result()
+	//nolint:errcheck
+	defer resp.Body.Close()
+
+	//nolint:errcheck
+	body, _ := ioutil.ReadAll(resp.Body)
+
+	l := &transaction.Limits{}
+	err := json.Unmarshal(body, l)
+	if err != nil || !reflect.DeepEqual(l, resultItem) {
+		t.Errorf("results not match, want %v, have %v", resultItem, l)
+		return
+	}
+
+	// result error
+
+	st.EXPECT().GetLimits(gomock.Any(), 1).Return(nil, fmt.Errorf("bad result"))
+	req = httptest.NewRequest("GET", "/admin/limits/1", nil)
+	req = mux.SetURLVars(req, map[string]string{"id": "1"})
+	w = httptest.NewRecorder()
+	service.GetLimits(w, req)
+
+	resp = w.Result()
+	//nolint:errcheck
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusInternalServerError {
+		t.Errorf("expected resp status 500, got %d", resp.StatusCode)
+		return
+	}
+}
+
+func TestSetLimits(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	// Finish сравнит последовательность вызовов и выведет ошибку если последовательность другая
+	defer ctrl.Finish()
+
+	st := NewMockLimitsRepositoryInterface(ctrl)
+
+	service := &LimitsHandler{
+		LimitRepo: st,
+		Logger:    zap.NewNop().Sugar(), // не пишет логи
+	}
+
+	// the id is taken from the path
+	transfers := 3
+	resultItem := &transaction.Limits{UserID: 1, HourlyTransfers: &transfers}
+
+	st.EXPECT().SetLimits(gomock.Any(), resultItem).Return(resultItem, nil)
+
+	req := httptest.NewRequest("PUT", "/admin/limits/1", strings.NewReader(`{"id": 5, "hourly_transfers": 3}`))
+	req = mux.SetURLVars(req, map[string]string{"id": "1"})
+	w := httptest.NewRecorder()
+	service.SetLimits(w, req)
+
+	resp := w.Result()
+	//nolint:errcheck
+	defer resp.Body.Close()
+
+	//nolint:errcheck
+	body, _ := ioutil.ReadAll(resp.Body)
+
+	l := &transaction.Limits{}
+	err := json.Unmarshal(body, l)
+	if err != nil || !reflect.DeepEqual(l, resultItem) {
+		t.Errorf("results not match, want %v, have %v", resultItem, l)
+		return
+	}
+
+	// negative limit
+
+	st.EXPECT().SetLimits(gomock.Any(), gomock.Any()).Return(nil, transaction.ErrNegativeAmount)
+	req = httptest.NewRequest("PUT", "/admin/limits/1", strings.NewReader(`{"max_operation": -1}`))
+	req = mux.SetURLVars(req, map[string]string{"id": "1"})
+	w = httptest.NewRecorder()
+	service.SetLimits(w, req)
+
+	resp = w.Result()
+	//nolint:errcheck
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected resp status 400, got %d", resp.StatusCode)
+		return
+	}
+
+	// marshaling error
+
+	req = httptest.NewRequest("PUT", "/admin/limits/1", strings.NewReader("mess1111ag11e:1qq11111powei"))
+	req = mux.SetURLVars(req, map[string]string{"id": "1"})
+	w = httptest.NewRecorder()
+	service.SetLimits(w, req)
+
+	resp = w.Result()
+	//nolint:errcheck
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected resp status 400, got %d", resp.StatusCode)
+		return
+	}
+}
+
+func TestDeleteLimits(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	// Finish сравнит последовательность вызовов и выведет ошибку если последовательность другая
+	defer ctrl.Finish()
+
+	st := NewMockLimitsRepositoryInterface(ctrl)
+
+	service := &LimitsHandler{
+		LimitRepo: st,
+		Logger:    zap.NewNop().Sugar(), // не пишет логи
+	}
+
+	st.EXPECT().DeleteLimits(gomock.Any(), 1).Return(nil)
+
+	req := httptest.NewRequest("DELETE", "/admin/limits/1", nil)
+	req = mux.SetURLVars(req, map[string]string{"id": "1"})
+	w := httptest.NewRecorder()
+	service.DeleteLimits(w, req)
+
+	resp := w.Result()
+	//nolint:errcheck
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("expected resp status 200, got %d", resp.StatusCode)
+		return
+	}
+
+	// bad id
+
+	req = httptest.NewRequest("DELETE", "/admin/limits/abc", nil)
+	req = mux.SetURLVars(req, map[string]string{"id": "abc"})
+	w = httptest.NewRecorder()
+	service.DeleteLimits(w, req)
+
+	resp = w.Result()
+	//nolint:errcheck
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected resp status 400, got %d", resp.StatusCode)
+		return
+	}
+}
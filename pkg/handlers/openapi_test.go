@@ -0,0 +1,369 @@
+package handlers
+
+import (
+	"autumn-2021-intern-assignment/api"
+	"autumn-2021-intern-assignment/pkg/transaction"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/golang/mock/gomock"
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// the parts of OpenAPI the contract test reads
+
+type apiSchema struct {
+	Ref        string                `json:"$ref"`
+	Type       string                `json:"type"`
+	Format     string                `json:"format"`
+	Nullable   bool                  `json:"nullable"`
+	Enum       []interface{}         `json:"enum"`
+	Properties map[string]*apiSchema `json:"properties"`
+	Required   []string              `json:"required"`
+	Items      *apiSchema            `json:"items"`
+}
+
+type apiMedia struct {
+	Schema   *apiSchema `json:"schema"`
+	Examples map[string]*struct {
+		Value json.RawMessage `json:"value"`
+	} `json:"examples"`
+}
+
+type apiOperation struct {
+	OperationID string `json:"operationId"`
+	RequestBody *struct {
+		Content map[string]*apiMedia `json:"content"`
+	} `json:"requestBody"`
+	Responses map[string]*struct {
+		Content map[string]*apiMedia `json:"content"`
+	} `json:"responses"`
+}
+
+type apiSpec struct {
+	Paths      map[string]map[string]*apiOperation `json:"paths"`
+	Components struct {
+		Schemas map[string]*apiSchema `json:"schemas"`
+	} `json:"components"`
+}
+
+func loadSpec(t *testing.T) *apiSpec {
+	spec := &apiSpec{}
+	err := json.Unmarshal(api.OpenAPI, spec)
+	if err != nil {
+		t.Fatalf("bad openapi.json: %s", err)
+	}
+	return spec
+}
+
+// validate returns the differences between v and the schema, undocumented
+// properties included.
+func (spec *apiSpec) validate(s *apiSchema, v interface{}, path string) []string {
+	if s.Ref != "" {
+		return spec.validate(spec.Components.Schemas[strings.TrimPrefix(s.Ref, "#/components/schemas/")], v, path)
+	}
+	if v == nil {
+		if s.Nullable {
+			return nil
+		}
+		return []string{path + " is null"}
+	}
+
+	var errs []string
+	switch s.Type {
+	case "object":
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			return []string{path + " is not an object"}
+		}
+		if s.Properties == nil {
+			return nil
+		}
+		for _, name := range s.Required {
+			if _, ok := obj[name]; !ok {
+				errs = append(errs, path+"."+name+" is missing")
+			}
+		}
+		for name, value := range obj {
+			prop, ok := s.Properties[name]
+			if !ok {
+				errs = append(errs, path+"."+name+" is not documented")
+				continue
+			}
+			errs = append(errs, spec.validate(prop, value, path+"."+name)...)
+		}
+	case "array":
+		items, ok := v.([]interface{})
+		if !ok {
+			return []string{path + " is not an array"}
+		}
+		for i, item := range items {
+			errs = append(errs, spec.validate(s.Items, item, fmt.Sprintf("%s[%d]", path, i))...)
+		}
+	case "string":
+		str, ok := v.(string)
+		if !ok {
+			return []string{path + " is not a string"}
+		}
+		if s.Format == "date-time" {
+			if _, err := time.Parse(time.RFC3339, str); err != nil {
+				errs = append(errs, path+" is not a date-time")
+			}
+		}
+	case "number", "integer":
+		num, ok := v.(float64)
+		if !ok || (s.Type == "integer" && num != math.Trunc(num)) {
+			return []string{path + " is not " + s.Type}
+		}
+	case "boolean":
+		if _, ok := v.(bool); !ok {
+			return []string{path + " is not a boolean"}
+		}
+	}
+
+	if s.Enum != nil {
+		found := false
+		for _, e := range s.Enum {
+			found = found || e == v
+		}
+		if !found {
+			errs = append(errs, fmt.Sprintf("%s: %v is not in %v", path, v, s.Enum))
+		}
+	}
+
+	return errs
+}
+
+// expectItemsCall makes the repository answer so that the handler sends the
+// example: the decoded value for 2xx, the error with the example code for the
+// rest and for 202, an operation held for review.
+func expectItemsCall(st *MockItemsRepositoryInterface, opID string, req *transaction.User, id, status int,
+	example json.RawMessage) {
+	var err error
+	if status >= http.StatusBadRequest || status == http.StatusAccepted {
+		resp := &transaction.ErrorResponse{}
+		//nolint:errcheck
+		json.Unmarshal(example, resp)
+		err = transaction.ErrorByCode(resp.Code)
+		if resp.Limit != "" {
+			err = &transaction.LimitError{Limit: resp.Limit}
+		}
+		if resp.ReviewID != 0 {
+			err = &transaction.RiskError{
+				Decision: transaction.RiskHold,
+				Verdicts: []*transaction.RiskVerdict{{Decision: transaction.RiskHold, Reason: strings.TrimPrefix(resp.Error,
+					transaction.ErrRiskReview.Error()+": ")}},
+				ReviewID: resp.ReviewID,
+			}
+		}
+		if resp.PendingID != 0 {
+			err = &transaction.PendingError{Pending: &transaction.PendingOperation{ID: resp.PendingID}}
+		}
+		if err == nil {
+			err = fmt.Errorf("%s", resp.Error)
+		}
+	}
+
+	var tr *transaction.Transaction
+	if err == nil {
+		op := &transaction.OperationStatus{}
+		//nolint:errcheck
+		json.Unmarshal(example, op)
+		tr = op.Transaction
+	}
+
+	switch opID {
+	case "getBalance":
+		var user *transaction.User
+		if err == nil {
+			user = &transaction.User{}
+			//nolint:errcheck
+			json.Unmarshal(example, user)
+		}
+		st.EXPECT().GetUsersBalance(gomock.Any(), req.UserID, "").Return(user, err)
+	case "deposit":
+		st.EXPECT().AddMoney(gomock.Any(), req.UserID, req.Balance).Return(tr, err)
+	case "withdraw":
+		st.EXPECT().WithdrawMoney(gomock.Any(), req.UserID, req.Balance).Return(tr, err)
+	case "transfer":
+		st.EXPECT().TransferMoney(gomock.Any(), req.UserID, req.ToUserID, req.Balance).Return(tr, err)
+	case "refund":
+		st.EXPECT().RefundMoney(gomock.Any(), req.TransactionID, req.Balance).Return(tr, err)
+	case "listTransactions":
+		var history []*transaction.Transaction
+		if err == nil {
+			//nolint:errcheck
+			json.Unmarshal(example, &history)
+		}
+		st.EXPECT().GetTransaction(gomock.Any(), req.UserID, req.Field).Return(history, err)
+	case "getTransaction":
+		if err == nil {
+			tr = &transaction.Transaction{}
+			//nolint:errcheck
+			json.Unmarshal(example, tr)
+		}
+		st.EXPECT().GetTransactionByID(gomock.Any(), id).Return(tr, err)
+	}
+}
+
+// TestItemsContract replays the examples of openapi.json against ItemsHandler:
+// the answers have to be the documented ones and match their schemas.
+func TestItemsContract(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	// Finish сравнит последовательность вызовов и выведет ошибку если последовательность другая
+	defer ctrl.Finish()
+
+	st := NewMockItemsRepositoryInterface(ctrl)
+
+	handler := ItemsHandler{
+		ItemRepo: st,
+		Logger:   zap.NewNop().Sugar(), // не пишет логи
+	}
+	r := mux.NewRouter()
+	r.HandleFunc("/user", handler.GetBalanceFromUser)
+	r.HandleFunc("/balance/add", handler.IncreaseBalance)
+	r.HandleFunc("/balance/reduce", handler.DecreaseBalance)
+	r.HandleFunc("/balance/transfer", handler.TransferBalance)
+	r.HandleFunc("/balance/refund", handler.RefundBalance)
+	r.HandleFunc("/info", handler.ListTransaction)
+	r.HandleFunc("/transactions/{id:[0-9]+}", handler.GetTransactionInfo)
+
+	spec := loadSpec(t)
+	replayed := 0
+	for path, ops := range spec.Paths {
+		if !r.Match(httptest.NewRequest(http.MethodGet, strings.ReplaceAll(path, "{id}", "1"), nil), &mux.RouteMatch{}) {
+			continue
+		}
+		for method, op := range ops {
+			var reqBody json.RawMessage
+			if op.RequestBody != nil {
+				for _, ex := range op.RequestBody.Content["application/json"].Examples {
+					reqBody = ex.Value
+				}
+			}
+			req := &transaction.User{}
+			if reqBody != nil {
+				err := json.Unmarshal(reqBody, req)
+				if err != nil {
+					t.Errorf("%s: bad request example: %s", op.OperationID, err)
+					continue
+				}
+			}
+
+			for code, resp := range op.Responses {
+				status, _ := strconv.Atoi(code)
+				media := resp.Content["application/json"]
+				for name, ex := range media.Examples {
+					var want interface{}
+					//nolint:errcheck
+					json.Unmarshal(ex.Value, &want)
+					for _, e := range spec.validate(media.Schema, want, "example") {
+						t.Errorf("%s %s: %s", op.OperationID, name, e)
+					}
+
+					expectItemsCall(st, op.OperationID, req, 1, status, ex.Value)
+
+					url := strings.ReplaceAll(path, "{id}", "1")
+					w := httptest.NewRecorder()
+					r.ServeHTTP(w, httptest.NewRequest(strings.ToUpper(method), url, bytes.NewReader(reqBody)))
+
+					body, _ := ioutil.ReadAll(w.Result().Body)
+					var have interface{}
+					err := json.Unmarshal(body, &have)
+					if err != nil {
+						t.Errorf("%s %s: answer is not JSON: %s", op.OperationID, name, body)
+						continue
+					}
+					if w.Code != status || !reflect.DeepEqual(have, want) {
+						t.Errorf("%s %s: want %d %s, have %d %s", op.OperationID, name, status, ex.Value, w.Code, body)
+					}
+					for _, e := range spec.validate(media.Schema, have, "answer") {
+						t.Errorf("%s %s: %s", op.OperationID, name, e)
+					}
+					replayed++
+				}
+			}
+		}
+	}
+
+	if replayed < 7 {
+		t.Errorf("only %d examples replayed", replayed)
+	}
+}
+
+// TestSchemasMatchTypes checks the documented properties against the JSON
+// fields of the Go types.
+func TestSchemasMatchTypes(t *testing.T) {
+	spec := loadSpec(t)
+	types := map[string]interface{}{
+		"User":                   transaction.User{},
+		"Transaction":            transaction.Transaction{},
+		"OperationStatus":        transaction.OperationStatus{},
+		"Error":                  transaction.ErrorResponse{},
+		"Account":                transaction.Account{},
+		"AccountRequest":         transaction.AccountRequest{},
+		"OverdraftEvent":         transaction.OverdraftEvent{},
+		"Limits":                 transaction.Limits{},
+		"FeeRule":                transaction.FeeRule{},
+		"FeeRequest":             transaction.FeeRequest{},
+		"FeePreview":             transaction.FeePreview{},
+		"Schedule":               transaction.Schedule{},
+		"BatchItem":              transaction.BatchItem{},
+		"BatchRequest":           transaction.BatchRequest{},
+		"BatchResult":            transaction.BatchResult{},
+		"SplitLeg":               transaction.SplitLeg{},
+		"SplitRequest":           transaction.SplitRequest{},
+		"BalanceSnapshot":        transaction.BalanceSnapshot{},
+		"ReportRequest":          reportRequest{},
+		"BalanceReport":          transaction.BalanceReport{},
+		"ReconciliationMismatch": transaction.ReconciliationMismatch{},
+		"ReconciliationRun":      transaction.ReconciliationRun{},
+		"Adjustment":             transaction.Adjustment{},
+		"RiskVerdict":            transaction.RiskVerdict{},
+		"RiskDecision":           transaction.RiskDecision{},
+		"RiskReview":             transaction.RiskReview{},
+		"PendingOperation":       transaction.PendingOperation{},
+		"BonusGrant":             transaction.BonusGrant{},
+		"BonusRequest":           transaction.BonusRequest{},
+		"Rate":                   transaction.Rate{},
+		"RateImport":             transaction.RateImport{},
+		"PendingEvent":           transaction.PendingEvent{},
+	}
+
+	for name, v := range types {
+		s, ok := spec.Components.Schemas[name]
+		if !ok {
+			t.Errorf("schema %s is missing", name)
+			continue
+		}
+
+		var fields, props []string
+		typ := reflect.TypeOf(v)
+		for i := 0; i < typ.NumField(); i++ {
+			tag := strings.Split(typ.Field(i).Tag.Get("json"), ",")[0]
+			if tag != "" && tag != "-" {
+				fields = append(fields, tag)
+			}
+		}
+		for prop := range s.Properties {
+			props = append(props, prop)
+		}
+		sort.Strings(fields)
+		sort.Strings(props)
+		if !reflect.DeepEqual(fields, props) {
+			t.Errorf("schema %s has %v, the type has %v", name, props, fields)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"autumn-2021-intern-assignment/pkg/transaction"
+	"context"
+	"fmt"
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+	"net/http"
+	"strconv"
+)
+
+type PendingRepositoryInterface interface {
+	GetPendingOperations(ctx context.Context, status string) ([]*transaction.PendingOperation, error)
+	GetPendingOperation(ctx context.Context, pendingID int) (*transaction.PendingOperation, error)
+	ApprovePendingOperation(ctx context.Context, pendingID int, approver string) (*transaction.PendingOperation, error)
+	RejectPendingOperation(ctx context.Context, pendingID int, approver string) (*transaction.PendingOperation, error)
+}
+
+type PendingHandler struct {
+	PendingRepo PendingRepositoryInterface
+	Logger      *zap.SugaredLogger
+}
+
+// mockgen -source=pending.go -destination=pending_mock.go -package=handlers PendingRepositoryInterface
+
+func (h PendingHandler) GetPendingOperations(w http.ResponseWriter, r *http.Request) {
+	status := r.FormValue("status")
+	switch status {
+	case "", transaction.PendingWaiting, transaction.PendingApproved, transaction.PendingRejected, transaction.PendingExpired:
+	default:
+		sendError(w, r, h.Logger, fmt.Errorf("bad pending operation status %q", status), http.StatusBadRequest)
+		return
+	}
+
+	pending, err := h.PendingRepo.GetPendingOperations(r.Context(), status)
+	if err != nil {
+		sendError(w, r, h.Logger, err, errorStatus(err))
+		return
+	}
+
+	sendData(w, r, h.Logger, pending)
+}
+
+func (h PendingHandler) GetPendingOperation(w http.ResponseWriter, r *http.Request) {
+	pendingID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		sendError(w, r, h.Logger, fmt.Errorf("bad pending operation id"), http.StatusBadRequest)
+		return
+	}
+
+	p, err := h.PendingRepo.GetPendingOperation(r.Context(), pendingID)
+	if err != nil {
+		sendError(w, r, h.Logger, err, errorStatus(err))
+		return
+	}
+
+	sendData(w, r, h.Logger, p)
+}
+
+func (h PendingHandler) ApprovePendingOperation(w http.ResponseWriter, r *http.Request) {
+	h.decide(w, r, h.PendingRepo.ApprovePendingOperation)
+}
+
+func (h PendingHandler) RejectPendingOperation(w http.ResponseWriter, r *http.Request) {
+	h.decide(w, r, h.PendingRepo.RejectPendingOperation)
+}
+
+func (h PendingHandler) decide(w http.ResponseWriter, r *http.Request,
+	decide func(ctx context.Context, pendingID int, approver string) (*transaction.PendingOperation, error)) {
+	pendingID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		sendError(w, r, h.Logger, fmt.Errorf("bad pending operation id"), http.StatusBadRequest)
+		return
+	}
+
+	// the admin is the one of the api key, the requester can't approve
+	// under another name
+	p, err := decide(r.Context(), pendingID, transaction.Requester(r.Context()))
+	if err != nil {
+		sendError(w, r, h.Logger, err, errorStatus(err))
+		return
+	}
+
+	sendData(w, r, h.Logger, p)
+}
@@ -0,0 +1,156 @@
+package handlers
+
+import (
+	"autumn-2021-intern-assignment/pkg/transaction"
+	"context"
+	"encoding/json"
+	"github.com/golang/mock/gomock"
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPendingOperations(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	// Finish сравнит последовательность вызовов и выведет ошибку если последовательность другая
+	defer ctrl.Finish()
+
+	st := NewMockPendingRepositoryInterface(ctrl)
+
+	service := &PendingHandler{
+		PendingRepo: st,
+		Logger:      zap.NewNop().Sugar(), // не пишет логи
+	}
+	auth := AuthHandler{
+		Keys: []APIKey{
+			{Name: "shop", Role: RoleAdmin, Key: "shop-key"},
+			{Name: "alice", Role: RoleAdmin, Key: "alice-key"},
+			{Name: "bot", Role: RoleClient, Key: "bot-key"},
+		},
+		Logger: zap.NewNop().Sugar(),
+	}
+	r := mux.NewRouter()
+	r.Use(auth.Middleware)
+	r.HandleFunc("/admin/pending", service.GetPendingOperations)
+	r.HandleFunc("/admin/pending/{id:[0-9]+}", service.GetPendingOperation)
+	r.HandleFunc("/admin/pending/{id:[0-9]+}/approve", service.ApprovePendingOperation)
+	r.HandleFunc("/admin/pending/{id:[0-9]+}/reject", service.RejectPendingOperation)
+
+	send := func(method, path, key, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if key != "" {
+			req.Header.Set("Authorization", "Bearer "+key)
+		}
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	created := time.Date(2021, 11, 27, 10, 0, 0, 0, time.UTC)
+	pending := &transaction.PendingOperation{
+		ID:          4,
+		Operation:   transaction.OperationWithdraw,
+		UserID:      1,
+		Money:       15000,
+		Status:      transaction.PendingWaiting,
+		RequestedBy: "shop",
+		Created:     created,
+		ExpiresAt:   created.Add(24 * time.Hour),
+	}
+
+	st.EXPECT().GetPendingOperations(gomock.Any(), transaction.PendingWaiting).
+		Return([]*transaction.PendingOperation{pending}, nil)
+
+	w := send(http.MethodGet, "/admin/pending?status=pending", "alice-key", "")
+	list := make([]*transaction.PendingOperation, 0)
+	err := json.Unmarshal(w.Body.Bytes(), &list)
+	if w.Code != http.StatusOK || err != nil || !reflect.DeepEqual(list, []*transaction.PendingOperation{pending}) {
+		t.Errorf("unexpected answer %d %s", w.Code, w.Body.String())
+		return
+	}
+
+	st.EXPECT().GetPendingOperation(gomock.Any(), 5).Return(nil, transaction.ErrPendingNotFound)
+
+	w = send(http.MethodGet, "/admin/pending/5", "alice-key", "")
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected resp status 404, got %d", w.Code)
+		return
+	}
+
+	// four eyes: the approver is the admin of the key, a name in the body
+	// changes nothing
+	st.EXPECT().ApprovePendingOperation(gomock.Any(), 4, "shop").Return(nil, transaction.ErrSameApprover)
+
+	w = send(http.MethodPost, "/admin/pending/4/approve", "shop-key", `{"admin": "alice"}`)
+	if w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), `"code":"same_approver"`) {
+		t.Errorf("unexpected answer %d %s", w.Code, w.Body.String())
+		return
+	}
+
+	st.EXPECT().RejectPendingOperation(gomock.Any(), 4, "alice").Return(pending, nil)
+
+	w = send(http.MethodPost, "/admin/pending/4/reject", "alice-key", "")
+	if w.Code != http.StatusOK {
+		t.Errorf("expected resp status 200, got %d", w.Code)
+		return
+	}
+
+	// only admins decide
+	for _, c := range []struct {
+		key    string
+		status int
+	}{
+		{"", http.StatusUnauthorized},
+		{"wrong-key", http.StatusUnauthorized},
+		{"bot-key", http.StatusForbidden},
+	} {
+		w = send(http.MethodPost, "/admin/pending/4/approve", c.key, "")
+		if w.Code != c.status {
+			t.Errorf("key %q: expected resp status %d, got %d", c.key, c.status, w.Code)
+			return
+		}
+	}
+}
+
+func TestWithdrawWaitsForApproval(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	// Finish сравнит последовательность вызовов и выведет ошибку если последовательность другая
+	defer ctrl.Finish()
+
+	st := NewMockItemsRepositoryInterface(ctrl)
+
+	service := &ItemsHandler{
+		ItemRepo: st,
+		Logger:   zap.NewNop().Sugar(), // не пишет логи
+	}
+
+	st.EXPECT().WithdrawMoney(gomock.Any(), 1, 15000.0).DoAndReturn(
+		func(ctx context.Context, userID int, money float64) (*transaction.Transaction, error) {
+			if transaction.Requester(ctx) != "shop" {
+				t.Errorf("expected the requester shop, have %q", transaction.Requester(ctx))
+			}
+			return nil, &transaction.PendingError{Pending: &transaction.PendingOperation{ID: 4}}
+		})
+
+	auth := AuthHandler{Keys: []APIKey{{Name: "shop", Role: RoleClient, Key: "shop-key"}}, Logger: zap.NewNop().Sugar()}
+	req := httptest.NewRequest(http.MethodPost, "/balance/reduce", strings.NewReader(`{"id": 1, "balance": 15000}`))
+	req.Header.Set("Authorization", "Bearer shop-key")
+	// a claimed client id is not the requester
+	req.Header.Set("X-Client-ID", "alice")
+	w := httptest.NewRecorder()
+	auth.Middleware(http.HandlerFunc(service.DecreaseBalance)).ServeHTTP(w, req)
+
+	resp := &transaction.ErrorResponse{}
+	//nolint:errcheck
+	json.Unmarshal(w.Body.Bytes(), resp)
+	if w.Code != http.StatusAccepted || resp.Code != "approval_required" || resp.PendingID != 4 {
+		t.Errorf("unexpected answer %d %s", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,196 @@
+package handlers
+
+import (
+	"autumn-2021-intern-assignment/pkg/transaction"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+	"io/ioutil"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MaxRateBodySize limits the body read to find the account of a request.
+const MaxRateBodySize = 1 << 20
+
+// Rate limit keys: a bucket per API client or per target account.
+const (
+	RatePerClient = "client"
+	RatePerUser   = "user"
+)
+
+type RateLimiterInterface interface {
+	TakeRateToken(ctx context.Context, key string, limit transaction.RateLimit) (*transaction.RateResult, error)
+}
+
+// RateRule limits the requests to Route, every route for "*", with a bucket
+// per API client or per target account.
+type RateRule struct {
+	Route string
+	Per   string
+	Limit transaction.RateLimit
+}
+
+type RateLimitHandler struct {
+	Limiter RateLimiterInterface
+	Rules   []RateRule
+	Logger  *zap.SugaredLogger
+}
+
+// mockgen -source=ratelimit.go -destination=ratelimit_mock.go -package=handlers RateLimiterInterface
+
+// ParseRateRules reads the rules separated by ";", each of them is
+// "<route> <client|user> <rate>/<s|m|h> <burst>", like
+// "/balance/transfer user 5/s 10; * client 100/s 200".
+func ParseRateRules(s string) ([]RateRule, error) {
+	rules := make([]RateRule, 0)
+	for _, item := range strings.Split(s, ";") {
+		fields := strings.Fields(item)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 4 || (fields[1] != RatePerClient && fields[1] != RatePerUser) {
+			return nil, fmt.Errorf("bad rate limit %q", strings.TrimSpace(item))
+		}
+
+		parts := strings.Split(fields[2], "/")
+		count, err := strconv.ParseFloat(parts[0], 64)
+		units := map[string]float64{"s": 1, "m": 60, "h": 3600}
+		if err != nil || len(parts) != 2 || units[parts[1]] == 0 || count <= 0 {
+			return nil, fmt.Errorf("bad rate %q", fields[2])
+		}
+		burst, err := strconv.Atoi(fields[3])
+		if err != nil || burst < 1 {
+			return nil, fmt.Errorf("bad burst %q", fields[3])
+		}
+
+		rules = append(rules, RateRule{
+			Route: fields[0],
+			Per:   fields[1],
+			Limit: transaction.RateLimit{Rate: count / units[parts[1]], Burst: burst},
+		})
+	}
+
+	return rules, nil
+}
+
+// clientID is the API client of the request: the name of its API key, the
+// address without a key.
+func clientID(r *http.Request) string {
+	if id := transaction.Requester(r.Context()); id != "" {
+		return id
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// targetUser is the account the request is about: the id of the path or the
+// id of the JSON body. A body above MaxRateBodySize gives ErrBodyTooLarge.
+func targetUser(w http.ResponseWriter, r *http.Request) (string, error) {
+	if id, ok := mux.Vars(r)["id"]; ok {
+		return id, nil
+	}
+	if r.Body == nil {
+		return "", nil
+	}
+
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, MaxRateBodySize))
+	if err != nil && len(body) >= MaxRateBodySize {
+		return "", transaction.ErrBodyTooLarge
+	}
+	if err != nil {
+		return "", err
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	req := &struct {
+		ID *int `json:"id"`
+	}{}
+	if json.Unmarshal(body, req) != nil || req.ID == nil {
+		return "", nil
+	}
+	return strconv.Itoa(*req.ID), nil
+}
+
+// Middleware takes a token of every rule matching the request and answers 429
+// when one of the buckets is empty. The RateLimit-* headers describe the
+// bucket closest to the limit. A failing limiter lets the requests through.
+func (h RateLimitHandler) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := r.URL.Path
+		if current := mux.CurrentRoute(r); current != nil {
+			if tpl, err := current.GetPathTemplate(); err == nil {
+				route = tpl
+			}
+		}
+
+		var tightest *transaction.RateResult
+		var limit transaction.RateLimit
+		for _, rule := range h.Rules {
+			if rule.Route != "*" && rule.Route != route {
+				continue
+			}
+
+			id := clientID(r)
+			if rule.Per == RatePerUser {
+				var err error
+				id, err = targetUser(w, r)
+				if errors.Is(err, transaction.ErrBodyTooLarge) {
+					sendError(w, r, h.Logger, err, http.StatusRequestEntityTooLarge)
+					return
+				}
+				if err != nil {
+					sendError(w, r, h.Logger, err, http.StatusBadRequest)
+					return
+				}
+				if id == "" {
+					continue
+				}
+			}
+
+			res, err := h.Limiter.TakeRateToken(r.Context(), rule.Per+":"+id+":"+rule.Route, rule.Limit)
+			if err != nil {
+				h.Logger.Errorw("Rate limiter failed",
+					"url", r.URL.Path,
+					"error", err.Error(),
+				)
+				continue
+			}
+			if tightest == nil || !res.Allowed || (tightest.Allowed && res.Remaining < tightest.Remaining) {
+				tightest, limit = res, rule.Limit
+			}
+			if !res.Allowed {
+				break
+			}
+		}
+
+		if tightest != nil {
+			w.Header().Set("RateLimit-Limit", strconv.Itoa(limit.Burst))
+			w.Header().Set("RateLimit-Remaining", strconv.Itoa(tightest.Remaining))
+			w.Header().Set("RateLimit-Reset", strconv.Itoa(seconds(tightest.Reset)))
+			if !tightest.Allowed {
+				w.Header().Set("Retry-After", strconv.Itoa(seconds(tightest.RetryAfter)))
+				sendError(w, r, h.Logger, transaction.ErrRateLimited, http.StatusTooManyRequests)
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// seconds rounds up, a client waiting that long gets a token.
+func seconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"autumn-2021-intern-assignment/pkg/transaction"
+	"fmt"
+	"github.com/golang/mock/gomock"
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseRateRules(t *testing.T) {
+	rules, err := ParseRateRules("/balance/transfer user 5/s 10; * client 120/m 200;")
+	if err != nil {
+		t.Errorf("unexpected err: %s", err)
+		return
+	}
+	expect := []RateRule{
+		{Route: "/balance/transfer", Per: RatePerUser, Limit: transaction.RateLimit{Rate: 5, Burst: 10}},
+		{Route: "*", Per: RatePerClient, Limit: transaction.RateLimit{Rate: 2, Burst: 200}},
+	}
+	if !reflect.DeepEqual(rules, expect) {
+		t.Errorf("results not match, want %v, have %v", expect, rules)
+		return
+	}
+
+	for _, bad := range []string{"/user client 5/s", "/user ip 5/s 1", "/user user 5/d 1", "/user user 0/s 1", "/user user 5/s 0"} {
+		_, err = ParseRateRules(bad)
+		if err == nil {
+			t.Errorf("%q: expected error, got nil", bad)
+		}
+	}
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	// Finish сравнит последовательность вызовов и выведет ошибку если последовательность другая
+	defer ctrl.Finish()
+
+	st := NewMockRateLimiterInterface(ctrl)
+
+	transfer := transaction.RateLimit{Rate: 1, Burst: 2}
+	global := transaction.RateLimit{Rate: 10, Burst: 100}
+	service := &RateLimitHandler{
+		Limiter: st,
+		Rules: []RateRule{
+			{Route: "/balance/transfer", Per: RatePerUser, Limit: transfer},
+			{Route: "*", Per: RatePerClient, Limit: global},
+		},
+		Logger: zap.NewNop().Sugar(), // не пишет логи
+	}
+
+	r := mux.NewRouter()
+	r.HandleFunc("/balance/transfer", func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if string(body) != `{"id": 1, "id_to": 2, "balance": 10}` {
+			t.Errorf("body is lost: %s", body)
+		}
+	})
+	r.HandleFunc("/accounts/{id:[0-9]+}", func(w http.ResponseWriter, r *http.Request) {})
+	r.Use(service.Middleware)
+
+	send := func(path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		req = req.WithContext(transaction.WithRequester(req.Context(), "shop"))
+		req.Header.Set("X-Client-ID", "other") // not trusted
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	// allowed, the headers describe the bucket with less left
+	st.EXPECT().TakeRateToken(gomock.Any(), "user:1:/balance/transfer", transfer).
+		Return(&transaction.RateResult{Allowed: true, Remaining: 1, Reset: time.Second}, nil)
+	st.EXPECT().TakeRateToken(gomock.Any(), "client:shop:*", global).
+		Return(&transaction.RateResult{Allowed: true, Remaining: 99, Reset: 100 * time.Millisecond}, nil)
+
+	w := send("/balance/transfer", `{"id": 1, "id_to": 2, "balance": 10}`)
+	if w.Code != http.StatusOK || w.Header().Get("RateLimit-Limit") != "2" ||
+		w.Header().Get("RateLimit-Remaining") != "1" || w.Header().Get("RateLimit-Reset") != "1" {
+		t.Errorf("unexpected answer %d %v", w.Code, w.Header())
+		return
+	}
+
+	// refused
+	st.EXPECT().TakeRateToken(gomock.Any(), "user:1:/balance/transfer", transfer).
+		Return(&transaction.RateResult{Remaining: 0, RetryAfter: 1500 * time.Millisecond, Reset: 3 * time.Second}, nil)
+
+	w = send("/balance/transfer", `{"id": 1, "id_to": 2, "balance": 10}`)
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "2" ||
+		w.Header().Get("RateLimit-Remaining") != "0" || !strings.Contains(w.Body.String(), `"code":"rate_limited"`) {
+		t.Errorf("unexpected answer %d %v %s", w.Code, w.Header(), w.Body.String())
+		return
+	}
+
+	// only the rules of the route apply, a failing limiter lets the request in
+	st.EXPECT().TakeRateToken(gomock.Any(), "client:shop:*", global).Return(nil, fmt.Errorf("db_error"))
+
+	w = send("/accounts/5", "")
+	if w.Code != http.StatusOK || w.Header().Get("RateLimit-Limit") != "" {
+		t.Errorf("unexpected answer %d %v", w.Code, w.Header())
+		return
+	}
+
+	// a body too large to look for the account in is refused before the limiter
+	w = send("/balance/transfer", `{"id": 1, "comment": "`+strings.Repeat("a", MaxRateBodySize)+`"}`)
+	if w.Code != http.StatusRequestEntityTooLarge || !strings.Contains(w.Body.String(), `"code":"body_too_large"`) {
+		t.Errorf("unexpected answer %d %s", w.Code, w.Body.String())
+		return
+	}
+
+	// a request without a key is counted by its address
+	st.EXPECT().TakeRateToken(gomock.Any(), "client:192.0.2.1:*", global).
+		Return(&transaction.RateResult{Allowed: true, Remaining: 98, Reset: time.Second}, nil)
+
+	req := httptest.NewRequest(http.MethodGet, "/accounts/5", nil)
+	req.Header.Set("X-Client-ID", "shop")
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || w.Header().Get("RateLimit-Remaining") != "98" {
+		t.Errorf("unexpected answer %d %v", w.Code, w.Header())
+		return
+	}
+}
@@ -0,0 +1,36 @@
+package handlers
+
+import (
+	"autumn-2021-intern-assignment/pkg/transaction"
+	"context"
+	"go.uber.org/zap"
+	"net/http"
+)
+
+type RatesRepositoryInterface interface {
+	SaveRates(ctx context.Context, rates []*transaction.Rate) (*transaction.RateImport, error)
+}
+
+type RatesHandler struct {
+	RatesRepo RatesRepositoryInterface
+	Logger    *zap.SugaredLogger
+}
+
+// mockgen -source=rates.go -destination=rates_mock.go -package=handlers RatesRepositoryInterface
+
+func (h RatesHandler) SaveRates(w http.ResponseWriter, r *http.Request) {
+	rates := make([]*transaction.Rate, 0)
+	status, err := decodeBody(r, &rates)
+	if err != nil {
+		sendError(w, r, h.Logger, err, status)
+		return
+	}
+
+	res, err := h.RatesRepo.SaveRates(r.Context(), rates)
+	if err != nil {
+		sendError(w, r, h.Logger, err, errorStatus(err))
+		return
+	}
+
+	sendData(w, r, h.Logger, res)
+}
@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"autumn-2021-intern-assignment/pkg/transaction"
+	"context"
+	"github.com/golang/mock/gomock"
+	"go.uber.org/zap"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSaveRates(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	// Finish сравнит последовательность вызовов и выведет ошибку если последовательность другая
+	defer ctrl.Finish()
+
+	st := NewMockRatesRepositoryInterface(ctrl)
+
+	service := &RatesHandler{
+		RatesRepo: st,
+		Logger:    zap.NewNop().Sugar(), // не пишет логи
+	}
+
+	day := time.Date(2021, 10, 29, 0, 0, 0, 0, time.UTC)
+	st.EXPECT().SaveRates(gomock.Any(), []*transaction.Rate{{Day: day, Currency: "USD", Rate: 72.5}}).
+		Return(&transaction.RateImport{Imported: 1}, nil)
+
+	req := httptest.NewRequest(http.MethodPost, "/admin/rates",
+		strings.NewReader(`[{"day": "2021-10-29T00:00:00Z", "currency": "USD", "rate": 72.5}]`))
+	w := httptest.NewRecorder()
+	service.SaveRates(w, req)
+	if w.Code != http.StatusOK || w.Body.String() != `{"imported":1}` {
+		t.Errorf("unexpected answer %d %s", w.Code, w.Body.String())
+		return
+	}
+
+	st.EXPECT().SaveRates(gomock.Any(), gomock.Any()).Return(nil, transaction.ErrBadRates)
+
+	req = httptest.NewRequest(http.MethodPost, "/admin/rates", strings.NewReader(`[]`))
+	w = httptest.NewRecorder()
+	service.SaveRates(w, req)
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), `"code":"bad_rates"`) {
+		t.Errorf("unexpected answer %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestListTransactionConverted(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	// Finish сравнит последовательность вызовов и выведет ошибку если последовательность другая
+	defer ctrl.Finish()
+
+	st := NewMockItemsRepositoryInterface(ctrl)
+
+	service := &ItemsHandler{
+		ItemRepo: st,
+		Logger:   zap.NewNop().Sugar(), // не пишет логи
+	}
+
+	day := time.Date(2021, 10, 29, 0, 0, 0, 0, time.UTC)
+	history := []*transaction.Transaction{{ID: 1, Money: 100}}
+	st.EXPECT().GetTransaction(gomock.Any(), 1, "").Return(history, nil)
+	st.EXPECT().ConvertTransactions(gomock.Any(), history, "USD").DoAndReturn(
+		func(ctx context.Context, info []*transaction.Transaction, currency string) error {
+			if have, ok := transaction.RateDate(ctx); !ok || !have.Equal(day) {
+				t.Errorf("expected the rates of %v, have %v", day, have)
+			}
+			info[0].Money = 1.25
+			return nil
+		})
+
+	req := httptest.NewRequest(http.MethodGet, "/info?currency=USD&date=2021-10-29", strings.NewReader(`{"id": 1}`))
+	w := httptest.NewRecorder()
+	service.ListTransaction(w, req)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"money":1.25`) {
+		t.Errorf("unexpected answer %d %s", w.Code, w.Body.String())
+		return
+	}
+
+	// no rate for the date
+	st.EXPECT().GetTransaction(gomock.Any(), 1, "").Return(history, nil)
+	st.EXPECT().ConvertTransactions(gomock.Any(), history, "USD").Return(transaction.ErrRateNotFound)
+
+	req = httptest.NewRequest(http.MethodGet, "/info?currency=USD", strings.NewReader(`{"id": 1}`))
+	w = httptest.NewRecorder()
+	service.ListTransaction(w, req)
+	if w.Code != http.StatusNotFound || !strings.Contains(w.Body.String(), `"code":"rate_not_found"`) {
+		t.Errorf("unexpected answer %d %s", w.Code, w.Body.String())
+		return
+	}
+
+	// a bad date
+	req = httptest.NewRequest(http.MethodGet, "/user?currency=USD&date=29.10.2021", strings.NewReader(`{"id": 1}`))
+	w = httptest.NewRecorder()
+	service.GetBalanceFromUser(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected resp status 400, got %d", w.Code)
+	}
+}
@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"autumn-2021-intern-assignment/pkg/transaction"
+	"context"
+	"go.uber.org/zap"
+	"net/http"
+)
+
+type ReconcileRepositoryInterface interface {
+	Reconcile(ctx context.Context, fix bool) (*transaction.ReconciliationRun, error)
+	GetLastReconciliation(ctx context.Context) (*transaction.ReconciliationRun, error)
+}
+
+type ReconcileHandler struct {
+	ReconcileRepo ReconcileRepositoryInterface
+	Logger        *zap.SugaredLogger
+}
+
+// mockgen -source=reconcile.go -destination=reconcile_mock.go -package=handlers ReconcileRepositoryInterface
+
+// RunReconciliation reconciles right away, ?fix=true writes correcting entries.
+func (h ReconcileHandler) RunReconciliation(w http.ResponseWriter, r *http.Request) {
+	run, err := h.ReconcileRepo.Reconcile(r.Context(), r.FormValue("fix") == "true")
+	if err != nil {
+		sendError(w, r, h.Logger, err, errorStatus(err))
+		return
+	}
+
+	sendData(w, r, h.Logger, run)
+}
+
+func (h ReconcileHandler) GetLastReconciliation(w http.ResponseWriter, r *http.Request) {
+	run, err := h.ReconcileRepo.GetLastReconciliation(r.Context())
+	if err != nil {
+		sendError(w, r, h.Logger, err, errorStatus(err))
+		return
+	}
+
+	sendData(w, r, h.Logger, run)
+}
+
+// Metric is published with expvar: the totals of the last run without the
+// mismatches themselves, nil before the first run.
+func (h ReconcileHandler) Metric() interface{} {
+	run, err := h.ReconcileRepo.GetLastReconciliation(context.Background())
+	if err != nil {
+		return nil
+	}
+
+	return map[string]interface{}{
+		"id":          run.ID,
+		"finished_at": run.FinishedAt,
+		"accounts":    run.Accounts,
+		"mismatches":  run.Mismatches,
+		"corrected":   run.Corrected,
+	}
+}
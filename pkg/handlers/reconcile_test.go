@@ -0,0 +1,125 @@
+package handlers
+
+import (
+	"autumn-2021-intern-assignment/pkg/transaction"
+	"encoding/json"
+	"github.com/golang/mock/gomock"
+	"go.uber.org/zap"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestReconciliation(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	// Finish сравнит последовательность вызовов и выведет ошибку если последовательность другая
+	defer ctrl.Finish()
+
+	st := NewMockReconcileRepositoryInterface(ctrl)
+
+	service := &ReconcileHandler{
+		ReconcileRepo: st,
+		Logger:        zap.NewNop().Sugar(), // не пишет логи
+	}
+
+	finished := time.Date(2021, 11, 27, 1, 0, 0, 0, time.UTC)
+	resultItem := &transaction.ReconciliationRun{
+		ID:         1,
+		StartedAt:  finished,
+		FinishedAt: finished,
+		Accounts:   3,
+		Mismatches: 1,
+		Items:      []*transaction.ReconciliationMismatch{{UserID: 1, Balance: 70, Expected: 100, Difference: -30}},
+	}
+
+	st.EXPECT().Reconcile(gomock.Any(), true).Return(resultItem, nil)
+
+	req := httptest.NewRequest("POST", "/admin/reconciliation?fix=true", nil)
+	w := httptest.NewRecorder()
+	service.RunReconciliation(w, req)
+
+	resp := w.Result()
+	//nolint:errcheck
+	defer resp.Body.Close()
+
+	//nolint:errcheck
+	body, _ := ioutil.ReadAll(resp.Body)
+
+	run := &transaction.ReconciliationRun{}
+	err := json.Unmarshal(body, run)
+	if err != nil || !reflect.DeepEqual(run, resultItem) {
+		t.Errorf("results not match, want %v, have %v", resultItem, run)
+		return
+	}
+
+	// already running
+
+	st.EXPECT().Reconcile(gomock.Any(), false).Return(nil, transaction.ErrReconcileRunning)
+	req = httptest.NewRequest("POST", "/admin/reconciliation", nil)
+	w = httptest.NewRecorder()
+	service.RunReconciliation(w, req)
+
+	resp = w.Result()
+	//nolint:errcheck
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusConflict {
+		t.Errorf("expected resp status 409, got %d", resp.StatusCode)
+		return
+	}
+
+	// last run
+
+	st.EXPECT().GetLastReconciliation(gomock.Any()).Return(resultItem, nil)
+	req = httptest.NewRequest("GET", "/admin/reconciliation/last", nil)
+	w = httptest.NewRecorder()
+	service.GetLastReconciliation(w, req)
+
+	resp = w.Result()
+	//nolint:errcheck
+	defer resp.Body.Close()
+
+	//nolint:errcheck
+	body, _ = ioutil.ReadAll(resp.Body)
+
+	run = &transaction.ReconciliationRun{}
+	err = json.Unmarshal(body, run)
+	if err != nil || !reflect.DeepEqual(run, resultItem) {
+		t.Errorf("results not match, want %v, have %v", resultItem, run)
+		return
+	}
+
+	// nothing has run
+
+	st.EXPECT().GetLastReconciliation(gomock.Any()).Return(nil, transaction.ErrNoReconciliation)
+	req = httptest.NewRequest("GET", "/admin/reconciliation/last", nil)
+	w = httptest.NewRecorder()
+	service.GetLastReconciliation(w, req)
+
+	resp = w.Result()
+	//nolint:errcheck
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expected resp status 404, got %d", resp.StatusCode)
+		return
+	}
+
+	// metric
+
+	st.EXPECT().GetLastReconciliation(gomock.Any()).Return(resultItem, nil)
+	metric, ok := service.Metric().(map[string]interface{})
+	if !ok || metric["mismatches"] != 1 || metric["accounts"] != 3 {
+		t.Errorf("unexpected metric %v", metric)
+		return
+	}
+
+	st.EXPECT().GetLastReconciliation(gomock.Any()).Return(nil, transaction.ErrNoReconciliation)
+	if service.Metric() != nil {
+		t.Errorf("expected no metric before the first run")
+	}
+}
@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"autumn-2021-intern-assignment/pkg/transaction"
+	"context"
+	"fmt"
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+type ReportsRepositoryInterface interface {
+	GetBalanceAt(ctx context.Context, userID int, at time.Time) (*transaction.BalanceSnapshot, error)
+	CreateBalanceReport(ctx context.Context, at time.Time) (*transaction.BalanceReport, error)
+	GetBalanceReport(ctx context.Context, reportID int) (*transaction.BalanceReport, error)
+}
+
+type ReportsHandler struct {
+	ReportRepo ReportsRepositoryInterface
+	Logger     *zap.SugaredLogger
+}
+
+// mockgen -source=reports.go -destination=reports_mock.go -package=handlers ReportsRepositoryInterface
+
+type reportRequest struct {
+	At time.Time `json:"at"`
+}
+
+func (h ReportsHandler) GetBalanceAt(w http.ResponseWriter, r *http.Request) {
+	userID, err := accountID(r)
+	if err != nil {
+		sendError(w, r, h.Logger, err, http.StatusBadRequest)
+		return
+	}
+
+	at, err := time.Parse(time.RFC3339, r.FormValue("at"))
+	if err != nil {
+		sendError(w, r, h.Logger, fmt.Errorf("bad at value, RFC 3339 time expected"), http.StatusBadRequest)
+		return
+	}
+
+	snapshot, err := h.ReportRepo.GetBalanceAt(r.Context(), userID, at.UTC())
+	if err != nil {
+		sendError(w, r, h.Logger, err, errorStatus(err))
+		return
+	}
+
+	sendData(w, r, h.Logger, snapshot)
+}
+
+func (h ReportsHandler) CreateBalanceReport(w http.ResponseWriter, r *http.Request) {
+	req := &reportRequest{}
+	status, err := decodeBody(r, req)
+	if err != nil {
+		sendError(w, r, h.Logger, err, status)
+		return
+	}
+
+	report, err := h.ReportRepo.CreateBalanceReport(r.Context(), req.At)
+	if err != nil {
+		sendError(w, r, h.Logger, err, errorStatus(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	sendData(w, r, h.Logger, report)
+}
+
+func (h ReportsHandler) GetBalanceReport(w http.ResponseWriter, r *http.Request) {
+	reportID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		sendError(w, r, h.Logger, fmt.Errorf("bad report id"), http.StatusBadRequest)
+		return
+	}
+
+	report, err := h.ReportRepo.GetBalanceReport(r.Context(), reportID)
+	if err != nil {
+		sendError(w, r, h.Logger, err, errorStatus(err))
+		return
+	}
+
+	sendData(w, r, h.Logger, report)
+}
@@ -0,0 +1,182 @@
+package handlers
+
+import (
+	"autumn-2021-intern-assignment/pkg/transaction"
+	"encoding/json"
+	"fmt"
+	"github.com/golang/mock/gomock"
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGetBalanceAt(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	// Finish сравнит последовательность вызовов и выведет ошибку если последовательность другая
+	defer ctrl.Finish()
+
+	st := NewMockReportsRepositoryInterface(ctrl)
+
+	service := &ReportsHandler{
+		ReportRepo: st,
+		Logger:     zap.NewNop().Sugar(), // не пишет логи
+	}
+
+	at := time.Date(2021, 11, 1, 0, 0, 0, 0, time.UTC)
+	resultItem := &transaction.BalanceSnapshot{UserID: 1, At: at, Balance: 100}
+
+	st.EXPECT().GetBalanceAt(gomock.Any(), 1, at).Return(resultItem, nil)
+
+	req := httptest.NewRequest("GET", "/accounts/1/balance?at=2021-11-01T00:00:00Z", nil)
+	req = mux.SetURLVars(req, map[string]string{"id": "1"})
+	w := httptest.NewRecorder()
+	service.GetBalanceAt(w, req)
+
+	resp := w.Result()
+	//nolint:errcheck
+	defer resp.Body.Close()
+
+	//nolint:errcheck
+	body, _ := ioutil.ReadAll(resp.Body)
+
+	snapshot := &transaction.BalanceSnapshot{}
+	err := json.Unmarshal(body, snapshot)
+	if err != nil || !reflect.DeepEqual(snapshot, resultItem) {
+		t.Errorf("results not match, want %v, have %v", resultItem, snapshot)
+		return
+	}
+
+	// bad time
+
+	req = httptest.NewRequest("GET", "/accounts/1/balance?at=yesterday", nil)
+	req = mux.SetURLVars(req, map[string]string{"id": "1"})
+	w = httptest.NewRecorder()
+	service.GetBalanceAt(w, req)
+
+	resp = w.Result()
+	//nolint:errcheck
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected resp status 400, got %d", resp.StatusCode)
+		return
+	}
+
+	// not found
+
+	st.EXPECT().GetBalanceAt(gomock.Any(), 2, at).Return(nil, transaction.ErrAccountNotFound)
+	req = httptest.NewRequest("GET", "/accounts/2/balance?at=2021-11-01T03:00:00%2B03:00", nil)
+	req = mux.SetURLVars(req, map[string]string{"id": "2"})
+	w = httptest.NewRecorder()
+	service.GetBalanceAt(w, req)
+
+	resp = w.Result()
+	//nolint:errcheck
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expected resp status 404, got %d", resp.StatusCode)
+		return
+	}
+}
+
+func TestBalanceReports(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	// Finish сравнит последовательность вызовов и выведет ошибку если последовательность другая
+	defer ctrl.Finish()
+
+	st := NewMockReportsRepositoryInterface(ctrl)
+
+	service := &ReportsHandler{
+		ReportRepo: st,
+		Logger:     zap.NewNop().Sugar(), // не пишет логи
+	}
+
+	at := time.Date(2021, 11, 1, 0, 0, 0, 0, time.UTC)
+	resultItem := &transaction.BalanceReport{ID: 1, At: at, CreatedAt: at.Add(time.Hour), Accounts: 2}
+
+	st.EXPECT().CreateBalanceReport(gomock.Any(), at).Return(resultItem, nil)
+
+	req := httptest.NewRequest("POST", "/admin/reports/balances", strings.NewReader(`{"at": "2021-11-01T00:00:00Z"}`))
+	w := httptest.NewRecorder()
+	service.CreateBalanceReport(w, req)
+
+	resp := w.Result()
+	//nolint:errcheck
+	defer resp.Body.Close()
+
+	//nolint:errcheck
+	body, _ := ioutil.ReadAll(resp.Body)
+
+	report := &transaction.BalanceReport{}
+	err := json.Unmarshal(body, report)
+	if err != nil || resp.StatusCode != http.StatusCreated || !reflect.DeepEqual(report, resultItem) {
+		t.Errorf("results not match, want %v, have %v", resultItem, report)
+		return
+	}
+
+	// future
+
+	st.EXPECT().CreateBalanceReport(gomock.Any(), gomock.Any()).Return(nil, transaction.ErrBadReportTime)
+	req = httptest.NewRequest("POST", "/admin/reports/balances", strings.NewReader(`{"at": "2121-11-01T00:00:00Z"}`))
+	w = httptest.NewRecorder()
+	service.CreateBalanceReport(w, req)
+
+	resp = w.Result()
+	//nolint:errcheck
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected resp status 400, got %d", resp.StatusCode)
+		return
+	}
+
+	// read
+
+	resultItem.Items = []*transaction.BalanceSnapshot{{UserID: 1, At: at, Balance: 10}, {UserID: 2, At: at}}
+	st.EXPECT().GetBalanceReport(gomock.Any(), 1).Return(resultItem, nil)
+
+	req = httptest.NewRequest("GET", "/admin/reports/balances/1", nil)
+	req = mux.SetURLVars(req, map[string]string{"id": "1"})
+	w = httptest.NewRecorder()
+	service.GetBalanceReport(w, req)
+
+	resp = w.Result()
+	//nolint:errcheck
+	defer resp.Body.Close()
+
+	//nolint:errcheck
+	body, _ = ioutil.ReadAll(resp.Body)
+
+	report = &transaction.BalanceReport{}
+	err = json.Unmarshal(body, report)
+	if err != nil || !reflect.DeepEqual(report, resultItem) {
+		t.Errorf("results not match, want %v, have %v", resultItem, report)
+		return
+	}
+
+	// db error
+
+	st.EXPECT().GetBalanceReport(gomock.Any(), 2).Return(nil, fmt.Errorf("db error"))
+	req = httptest.NewRequest("GET", "/admin/reports/balances/2", nil)
+	req = mux.SetURLVars(req, map[string]string{"id": "2"})
+	w = httptest.NewRecorder()
+	service.GetBalanceReport(w, req)
+
+	resp = w.Result()
+	//nolint:errcheck
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusInternalServerError {
+		t.Errorf("expected resp status 500, got %d", resp.StatusCode)
+		return
+	}
+}
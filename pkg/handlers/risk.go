@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"autumn-2021-intern-assignment/pkg/transaction"
+	"context"
+	"fmt"
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+	"net/http"
+	"strconv"
+)
+
+type RiskRepositoryInterface interface {
+	GetRiskReviews(ctx context.Context, status string) ([]*transaction.RiskReview, error)
+	GetRiskReview(ctx context.Context, reviewID int) (*transaction.RiskReview, error)
+	ApproveRiskReview(ctx context.Context, reviewID int, reviewer string) (*transaction.RiskReview, error)
+	RejectRiskReview(ctx context.Context, reviewID int, reviewer string) (*transaction.RiskReview, error)
+	GetRiskDecisions(ctx context.Context, userID int) ([]*transaction.RiskDecision, error)
+}
+
+type RiskHandler struct {
+	RiskRepo RiskRepositoryInterface
+	Logger   *zap.SugaredLogger
+}
+
+// mockgen -source=risk.go -destination=risk_mock.go -package=handlers RiskRepositoryInterface
+
+func (h RiskHandler) GetRiskReviews(w http.ResponseWriter, r *http.Request) {
+	status := r.FormValue("status")
+	switch status {
+	case "", transaction.ReviewPending, transaction.ReviewApproved, transaction.ReviewRejected:
+	default:
+		sendError(w, r, h.Logger, fmt.Errorf("bad review status %q", status), http.StatusBadRequest)
+		return
+	}
+
+	reviews, err := h.RiskRepo.GetRiskReviews(r.Context(), status)
+	if err != nil {
+		sendError(w, r, h.Logger, err, errorStatus(err))
+		return
+	}
+
+	sendData(w, r, h.Logger, reviews)
+}
+
+func (h RiskHandler) GetRiskReview(w http.ResponseWriter, r *http.Request) {
+	reviewID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		sendError(w, r, h.Logger, fmt.Errorf("bad review id"), http.StatusBadRequest)
+		return
+	}
+
+	rv, err := h.RiskRepo.GetRiskReview(r.Context(), reviewID)
+	if err != nil {
+		sendError(w, r, h.Logger, err, errorStatus(err))
+		return
+	}
+
+	sendData(w, r, h.Logger, rv)
+}
+
+func (h RiskHandler) ApproveRiskReview(w http.ResponseWriter, r *http.Request) {
+	h.decide(w, r, h.RiskRepo.ApproveRiskReview)
+}
+
+func (h RiskHandler) RejectRiskReview(w http.ResponseWriter, r *http.Request) {
+	h.decide(w, r, h.RiskRepo.RejectRiskReview)
+}
+
+func (h RiskHandler) decide(w http.ResponseWriter, r *http.Request,
+	decide func(ctx context.Context, reviewID int, reviewer string) (*transaction.RiskReview, error)) {
+	reviewID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		sendError(w, r, h.Logger, fmt.Errorf("bad review id"), http.StatusBadRequest)
+		return
+	}
+
+	// the reviewer is the one the api key names, not one the body claims
+	rv, err := decide(r.Context(), reviewID, transaction.Requester(r.Context()))
+	if err != nil {
+		sendError(w, r, h.Logger, err, errorStatus(err))
+		return
+	}
+
+	sendData(w, r, h.Logger, rv)
+}
+
+func (h RiskHandler) GetRiskDecisions(w http.ResponseWriter, r *http.Request) {
+	userID, err := accountID(r)
+	if err != nil {
+		sendError(w, r, h.Logger, err, http.StatusBadRequest)
+		return
+	}
+
+	decisions, err := h.RiskRepo.GetRiskDecisions(r.Context(), userID)
+	if err != nil {
+		sendError(w, r, h.Logger, err, errorStatus(err))
+		return
+	}
+
+	sendData(w, r, h.Logger, decisions)
+}
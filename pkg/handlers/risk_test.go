@@ -0,0 +1,136 @@
+package handlers
+
+import (
+	"autumn-2021-intern-assignment/pkg/transaction"
+	"encoding/json"
+	"github.com/golang/mock/gomock"
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRiskReviews(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	// Finish сравнит последовательность вызовов и выведет ошибку если последовательность другая
+	defer ctrl.Finish()
+
+	st := NewMockRiskRepositoryInterface(ctrl)
+
+	service := &RiskHandler{
+		RiskRepo: st,
+		Logger:   zap.NewNop().Sugar(), // не пишет логи
+	}
+	r := mux.NewRouter()
+	r.HandleFunc("/admin/reviews", service.GetRiskReviews)
+	r.HandleFunc("/admin/reviews/{id:[0-9]+}/approve", service.ApproveRiskReview)
+	r.HandleFunc("/admin/reviews/{id:[0-9]+}/reject", service.RejectRiskReview)
+	r.HandleFunc("/accounts/{id:[0-9]+}/risk-decisions", service.GetRiskDecisions)
+
+	r.Use(AuthHandler{Keys: []APIKey{
+		{Name: "alice", Role: RoleAdmin, Key: "alice-key"},
+		{Name: "bob", Role: RoleAdmin, Key: "bob-key"},
+		{Name: "shop", Role: RoleClient, Key: "shop-key"},
+	}, Logger: zap.NewNop().Sugar()}.Middleware)
+
+	send := func(method, path, key, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if key != "" {
+			req.Header.Set("Authorization", "Bearer "+key)
+		}
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	toID := 2
+	review := &transaction.RiskReview{
+		ID:        3,
+		Operation: transaction.OperationTransfer,
+		UserID:    1,
+		ToUserID:  &toID,
+		Money:     20000,
+		Verdicts:  []*transaction.RiskVerdict{{Rule: "new_account", Decision: transaction.RiskHold, Reason: "new"}},
+		Status:    transaction.ReviewPending,
+		Created:   time.Date(2021, 11, 27, 10, 0, 0, 0, time.UTC),
+	}
+
+	// the queue
+	st.EXPECT().GetRiskReviews(gomock.Any(), transaction.ReviewPending).Return([]*transaction.RiskReview{review}, nil)
+
+	w := send(http.MethodGet, "/admin/reviews?status=pending", "alice-key", "")
+	reviews := make([]*transaction.RiskReview, 0)
+	err := json.Unmarshal(w.Body.Bytes(), &reviews)
+	if w.Code != http.StatusOK || err != nil || !reflect.DeepEqual(reviews, []*transaction.RiskReview{review}) {
+		t.Errorf("unexpected answer %d %s", w.Code, w.Body.String())
+		return
+	}
+
+	w = send(http.MethodGet, "/admin/reviews?status=done", "alice-key", "")
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected resp status 400, got %d", w.Code)
+		return
+	}
+
+	// decisions are made by the admin of the key, a reviewer in the body is ignored
+	st.EXPECT().ApproveRiskReview(gomock.Any(), 3, "alice").Return(review, nil)
+
+	w = send(http.MethodPost, "/admin/reviews/3/approve", "alice-key", `{"reviewer": "bob"}`)
+	if w.Code != http.StatusOK {
+		t.Errorf("expected resp status 200, got %d", w.Code)
+		return
+	}
+
+	st.EXPECT().RejectRiskReview(gomock.Any(), 3, "bob").Return(nil, transaction.ErrReviewDecided)
+
+	w = send(http.MethodPost, "/admin/reviews/3/reject", "bob-key", "")
+	if w.Code != http.StatusConflict || !strings.Contains(w.Body.String(), `"code":"review_decided"`) {
+		t.Errorf("unexpected answer %d %s", w.Code, w.Body.String())
+		return
+	}
+
+	w = send(http.MethodPost, "/admin/reviews/3/approve", "", `{"reviewer": "alice"}`)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("expected resp status 401, got %d", w.Code)
+		return
+	}
+
+	w = send(http.MethodPost, "/admin/reviews/3/approve", "shop-key", "")
+	if w.Code != http.StatusForbidden {
+		t.Errorf("expected resp status 403, got %d", w.Code)
+		return
+	}
+
+	// the record of an account
+	st.EXPECT().GetRiskDecisions(gomock.Any(), 1).Return([]*transaction.RiskDecision{}, nil)
+
+	w = send(http.MethodGet, "/accounts/1/risk-decisions", "alice-key", "")
+	if w.Code != http.StatusOK || strings.TrimSpace(w.Body.String()) != "[]" {
+		t.Errorf("unexpected answer %d %s", w.Code, w.Body.String())
+		return
+	}
+}
+
+func TestRiskErrorStatus(t *testing.T) {
+	w := httptest.NewRecorder()
+	err := &transaction.RiskError{Decision: transaction.RiskHold, ReviewID: 3}
+	sendError(w, httptest.NewRequest(http.MethodPost, "/balance/reduce", nil), zap.NewNop().Sugar(), err, errorStatus(err))
+
+	resp := &transaction.ErrorResponse{}
+	//nolint:errcheck
+	json.Unmarshal(w.Body.Bytes(), resp)
+	if w.Code != http.StatusAccepted || resp.Code != "risk_review" || resp.ReviewID != 3 {
+		t.Errorf("unexpected answer %d %s", w.Code, w.Body.String())
+		return
+	}
+
+	err = &transaction.RiskError{Decision: transaction.RiskDeny}
+	if status := errorStatus(err); status != http.StatusForbidden {
+		t.Errorf("expected status 403, got %d", status)
+	}
+}
@@ -0,0 +1,89 @@
+package handlers
+
+import (
+	"autumn-2021-intern-assignment/pkg/transaction"
+	"context"
+	"fmt"
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+	"net/http"
+	"strconv"
+)
+
+type SchedulesRepositoryInterface interface {
+	CreateSchedule(ctx context.Context, s *transaction.Schedule) (*transaction.Schedule, error)
+	GetSchedules(ctx context.Context, userID int) ([]*transaction.Schedule, error)
+	PauseSchedule(ctx context.Context, scheduleID int) (*transaction.Schedule, error)
+	ResumeSchedule(ctx context.Context, scheduleID int) (*transaction.Schedule, error)
+	CancelSchedule(ctx context.Context, scheduleID int) (*transaction.Schedule, error)
+}
+
+type SchedulesHandler struct {
+	ScheduleRepo SchedulesRepositoryInterface
+	Logger       *zap.SugaredLogger
+}
+
+// mockgen -source=schedules.go -destination=schedules_mock.go -package=handlers SchedulesRepositoryInterface
+
+func (h SchedulesHandler) CreateSchedule(w http.ResponseWriter, r *http.Request) {
+	req := &transaction.Schedule{}
+	status, err := decodeBody(r, req)
+	if err != nil {
+		sendError(w, r, h.Logger, err, status)
+		return
+	}
+
+	s, err := h.ScheduleRepo.CreateSchedule(r.Context(), req)
+	if err != nil {
+		sendError(w, r, h.Logger, err, errorStatus(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	sendData(w, r, h.Logger, s)
+}
+
+func (h SchedulesHandler) GetSchedules(w http.ResponseWriter, r *http.Request) {
+	userID, err := accountID(r)
+	if err != nil {
+		sendError(w, r, h.Logger, err, http.StatusBadRequest)
+		return
+	}
+
+	schedules, err := h.ScheduleRepo.GetSchedules(r.Context(), userID)
+	if err != nil {
+		sendError(w, r, h.Logger, err, errorStatus(err))
+		return
+	}
+
+	sendData(w, r, h.Logger, schedules)
+}
+
+func (h SchedulesHandler) PauseSchedule(w http.ResponseWriter, r *http.Request) {
+	h.changeStatus(w, r, h.ScheduleRepo.PauseSchedule)
+}
+
+func (h SchedulesHandler) ResumeSchedule(w http.ResponseWriter, r *http.Request) {
+	h.changeStatus(w, r, h.ScheduleRepo.ResumeSchedule)
+}
+
+func (h SchedulesHandler) CancelSchedule(w http.ResponseWriter, r *http.Request) {
+	h.changeStatus(w, r, h.ScheduleRepo.CancelSchedule)
+}
+
+func (h SchedulesHandler) changeStatus(w http.ResponseWriter, r *http.Request,
+	change func(ctx context.Context, scheduleID int) (*transaction.Schedule, error)) {
+	scheduleID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		sendError(w, r, h.Logger, fmt.Errorf("bad schedule id"), http.StatusBadRequest)
+		return
+	}
+
+	s, err := change(r.Context(), scheduleID)
+	if err != nil {
+		sendError(w, r, h.Logger, err, errorStatus(err))
+		return
+	}
+
+	sendData(w, r, h.Logger, s)
+}
@@ -0,0 +1,203 @@
+package handlers
+
+import (
+	"autumn-2021-intern-assignment/pkg/transaction"
+	"encoding/json"
+	"fmt"
+	"github.com/golang/mock/gomock"
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCreateSchedule(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	// Finish сравнит последовательность вызовов и выведет ошибку если последовательность другая
+	defer ctrl.Finish()
+
+	st := NewMockSchedulesRepositoryInterface(ctrl)
+
+	service := &SchedulesHandler{
+		ScheduleRepo: st,
+		Logger:       zap.NewNop().Sugar(), // не пишет логи
+	}
+
+	toID := 2
+	resultItem := &transaction.Schedule{
+		ID:          1,
+		FromID:      1,
+		ToID:        &toID,
+		Money:       100,
+		Cron:        "0 9 1 * *",
+		NextRun:     time.Date(2021, 12, 1, 9, 0, 0, 0, time.UTC),
+		Status:      transaction.ScheduleActive,
+		MaxAttempts: transaction.DefaultMaxAttempts,
+		RetryDelay:  transaction.DefaultRetryDelay,
+		CreatedAt:   time.Now().UTC().Truncate(time.Second),
+	}
+
+	st.EXPECT().CreateSchedule(gomock.Any(), &transaction.Schedule{FromID: 1, ToID: &toID, Money: 100, Cron: "0 9 1 * *"}).
+		Return(resultItem, nil)
+
+	req := httptest.NewRequest("POST", "/schedules",
+		strings.NewReader(`{"from_id": 1, "to_id": 2, "money": 100, "cron": "0 9 1 * *"}`))
+	w := httptest.NewRecorder()
+	service.CreateSchedule(w, req)
+
+	resp := w.Result()
+	//nolint:errcheck
+	defer resp.Body.Close()
+
+	//nolint:errcheck
+	body, _ := ioutil.ReadAll(resp.Body)
+
+	if resp.StatusCode != http.StatusCreated {
+		t.Errorf("expected resp status 201, got %d", resp.StatusCode)
+		return
+	}
+
+	s := &transaction.Schedule{}
+	err := json.Unmarshal(body, s)
+	if err != nil || !reflect.DeepEqual(s, resultItem) {
+		t.Errorf("results not match, want %v, have %v", resultItem, s)
+		return
+	}
+
+	// bad schedule
+
+	st.EXPECT().CreateSchedule(gomock.Any(), gomock.Any()).Return(nil, transaction.ErrBadSchedule)
+	req = httptest.NewRequest("POST", "/schedules", strings.NewReader(`{"from_id": 1, "money": 100, "cron": "* *"}`))
+	w = httptest.NewRecorder()
+	service.CreateSchedule(w, req)
+
+	resp = w.Result()
+	//nolint:errcheck
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected resp status 400, got %d", resp.StatusCode)
+		return
+	}
+
+	// marshaling error
+
+	req = httptest.NewRequest("POST", "/schedules", strings.NewReader("mess1111ag11e:1qq11111powei"))
+	w = httptest.NewRecorder()
+	service.CreateSchedule(w, req)
+
+	resp = w.Result()
+	//nolint:errcheck
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected resp status 400, got %d", resp.StatusCode)
+		return
+	}
+}
+
+func TestGetSchedules(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	// Finish сравнит последовательность вызовов и выведет ошибку если последовательность другая
+	defer ctrl.Finish()
+
+	st := NewMockSchedulesRepositoryInterface(ctrl)
+
+	service := &SchedulesHandler{
+		ScheduleRepo: st,
+		Logger:       zap.NewNop().Sugar(), // не пишет логи
+	}
+
+	resultItems := []*transaction.Schedule{{ID: 1, FromID: 1, Money: 100, Status: transaction.ScheduleActive}}
+	st.EXPECT().GetSchedules(gomock.Any(), 1).Return(resultItems, nil)
+
+	req := httptest.NewRequest("GET", "/accounts/1/schedules", nil)
+	req = mux.SetURLVars(req, map[string]string{"id": "1"})
+	w := httptest.NewRecorder()
+	service.GetSchedules(w, req)
+
+	resp := w.Result()
+	//nolint:errcheck
+	defer resp.Body.Close()
+
+	//nolint:errcheck
+	body, _ := ioutil.ReadAll(resp.Body)
+
+	schedules := []*transaction.Schedule{}
+	err := json.Unmarshal(body, &schedules)
+	if err != nil || !reflect.DeepEqual(schedules, resultItems) {
+		t.Errorf("results not match, want %v, have %v", resultItems, schedules)
+		return
+	}
+
+	// result error
+
+	st.EXPECT().GetSchedules(gomock.Any(), 1).Return(nil, fmt.Errorf("bad result"))
+	req = httptest.NewRequest("GET", "/accounts/1/schedules", nil)
+	req = mux.SetURLVars(req, map[string]string{"id": "1"})
+	w = httptest.NewRecorder()
+	service.GetSchedules(w, req)
+
+	resp = w.Result()
+	//nolint:errcheck
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 500 {
+		t.Errorf("expected resp status 500, got %d", resp.StatusCode)
+		return
+	}
+}
+
+func TestChangeScheduleStatus(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	// Finish сравнит последовательность вызовов и выведет ошибку если последовательность другая
+	defer ctrl.Finish()
+
+	st := NewMockSchedulesRepositoryInterface(ctrl)
+
+	service := &SchedulesHandler{
+		ScheduleRepo: st,
+		Logger:       zap.NewNop().Sugar(), // не пишет логи
+	}
+
+	st.EXPECT().PauseSchedule(gomock.Any(), 1).Return(&transaction.Schedule{ID: 1, Status: transaction.SchedulePaused}, nil)
+	st.EXPECT().ResumeSchedule(gomock.Any(), 1).Return(&transaction.Schedule{ID: 1, Status: transaction.ScheduleActive}, nil)
+	st.EXPECT().CancelSchedule(gomock.Any(), 1).Return(nil, transaction.ErrScheduleFinished)
+	st.EXPECT().CancelSchedule(gomock.Any(), 2).Return(nil, transaction.ErrScheduleNotFound)
+
+	cases := []struct {
+		handler http.HandlerFunc
+		id      string
+		status  int
+	}{
+		{service.PauseSchedule, "1", http.StatusOK},
+		{service.ResumeSchedule, "1", http.StatusOK},
+		{service.CancelSchedule, "1", http.StatusConflict},
+		{service.CancelSchedule, "2", http.StatusNotFound},
+		{service.CancelSchedule, "abc", http.StatusBadRequest},
+	}
+
+	for _, c := range cases {
+		req := httptest.NewRequest("POST", "/schedules/"+c.id+"/cancel", nil)
+		req = mux.SetURLVars(req, map[string]string{"id": c.id})
+		w := httptest.NewRecorder()
+		c.handler(w, req)
+
+		resp := w.Result()
+		//nolint:errcheck
+		defer resp.Body.Close()
+
+		if resp.StatusCode != c.status {
+			t.Errorf("expected resp status %d, got %d", c.status, resp.StatusCode)
+			return
+		}
+	}
+}
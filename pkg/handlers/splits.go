@@ -0,0 +1,36 @@
+package handlers
+
+import (
+	"autumn-2021-intern-assignment/pkg/transaction"
+	"context"
+	"go.uber.org/zap"
+	"net/http"
+)
+
+type SplitsRepositoryInterface interface {
+	SplitMoney(ctx context.Context, req *transaction.SplitRequest) (*transaction.Transaction, error)
+}
+
+type SplitsHandler struct {
+	SplitRepo SplitsRepositoryInterface
+	Logger    *zap.SugaredLogger
+}
+
+// mockgen -source=splits.go -destination=splits_mock.go -package=handlers SplitsRepositoryInterface
+
+func (h SplitsHandler) SplitBalance(w http.ResponseWriter, r *http.Request) {
+	req := &transaction.SplitRequest{}
+	status, err := decodeBody(r, req)
+	if err != nil {
+		sendError(w, r, h.Logger, err, status)
+		return
+	}
+
+	tr, err := h.SplitRepo.SplitMoney(r.Context(), req)
+	if err != nil {
+		sendError(w, r, h.Logger, err, errorStatus(err))
+		return
+	}
+
+	sendSuccessStatus(w, r, h.Logger, tr)
+}
@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"autumn-2021-intern-assignment/pkg/transaction"
+	"encoding/json"
+	"fmt"
+	"github.com/golang/mock/gomock"
+	"go.uber.org/zap"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSplitBalance(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	// Finish сравнит последовательность вызовов и выведет ошибку если последовательность другая
+	defer ctrl.Finish()
+
+	st := NewMockSplitsRepositoryInterface(ctrl)
+
+	service := &SplitsHandler{
+		SplitRepo: st,
+		Logger:    zap.NewNop().Sugar(), // не пишет логи
+	}
+
+	fromID, parentID, seller, platform := 1, 10, 2, 3
+	resultItem := &transaction.Transaction{
+		ID:     parentID,
+		FromID: &fromID,
+		Money:  100,
+		Legs: []*transaction.Transaction{
+			{ID: 11, ToID: &seller, FromID: &fromID, Money: 95, SplitOf: &parentID},
+			{ID: 12, ToID: &platform, FromID: &fromID, Money: 5, SplitOf: &parentID},
+		},
+	}
+
+	st.EXPECT().SplitMoney(gomock.Any(), &transaction.SplitRequest{UserID: 1, Money: 100, Legs: []*transaction.SplitLeg{
+		{ToUserID: 2, Percent: 95},
+		{ToUserID: 3, Money: 5},
+	}}).Return(resultItem, nil)
+
+	req := httptest.NewRequest("POST", "/balance/split",
+		strings.NewReader(`{"id": 1, "money": 100, "legs": [{"id_to": 2, "percent": 95}, {"id_to": 3, "money": 5}]}`))
+	w := httptest.NewRecorder()
+	service.SplitBalance(w, req)
+
+	resp := w.Result()
+	//nolint:errcheck
+	defer resp.Body.Close()
+
+	//nolint:errcheck
+	body, _ := ioutil.ReadAll(resp.Body)
+
+	data := &struct {
+		Status      string                   `json:"status"`
+		Transaction *transaction.Transaction `json:"transaction"`
+	}{}
+	err := json.Unmarshal(body, data)
+	if err != nil || data.Status != "success" || len(data.Transaction.Legs) != 2 || *data.Transaction.Legs[1].SplitOf != parentID {
+		t.Errorf("unexpected response: %s", body)
+		return
+	}
+
+	// bad split
+
+	st.EXPECT().SplitMoney(gomock.Any(), gomock.Any()).Return(nil, transaction.ErrBadSplit)
+	req = httptest.NewRequest("POST", "/balance/split",
+		strings.NewReader(`{"id": 1, "money": 100, "legs": [{"id_to": 2, "percent": 50}]}`))
+	w = httptest.NewRecorder()
+	service.SplitBalance(w, req)
+
+	resp = w.Result()
+	//nolint:errcheck
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected resp status 400, got %d", resp.StatusCode)
+		return
+	}
+
+	// database error
+
+	st.EXPECT().SplitMoney(gomock.Any(), gomock.Any()).Return(nil, fmt.Errorf("db error"))
+	req = httptest.NewRequest("POST", "/balance/split",
+		strings.NewReader(`{"id": 1, "legs": [{"id_to": 2, "money": 50}]}`))
+	w = httptest.NewRecorder()
+	service.SplitBalance(w, req)
+
+	resp = w.Result()
+	//nolint:errcheck
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusInternalServerError {
+		t.Errorf("expected resp status 500, got %d", resp.StatusCode)
+		return
+	}
+
+	// bad json
+
+	req = httptest.NewRequest("POST", "/balance/split", strings.NewReader(`{"id": `))
+	w = httptest.NewRecorder()
+	service.SplitBalance(w, req)
+
+	resp = w.Result()
+	//nolint:errcheck
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected resp status 400, got %d", resp.StatusCode)
+		return
+	}
+}
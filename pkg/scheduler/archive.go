@@ -0,0 +1,76 @@
+package scheduler
+
+import (
+	"autumn-2021-intern-assignment/pkg/transaction"
+	"context"
+	"go.uber.org/zap"
+	"time"
+)
+
+type ArchiveRepositoryInterface interface {
+	EnsurePartitions(ctx context.Context, at time.Time) error
+	ArchiveHistory(ctx context.Context, before time.Time) ([]*transaction.HistoryArchive, error)
+}
+
+// ArchiveWorker creates the partitions of the history ahead of time and moves
+// the months older than Retention to the archive. Any number of workers may
+// run, a month is archived once.
+type ArchiveWorker struct {
+	Repo     ArchiveRepositoryInterface
+	Interval time.Duration
+	// Retention is how many whole months stay in the database besides the
+	// current one, 0 turns archiving off.
+	Retention int
+	Logger    *zap.SugaredLogger
+	// Clock is time.Now when not set
+	Clock func() time.Time
+}
+
+// RunOnce creates the partitions of this month and the next one and archives
+// the months before the retention.
+func (w *ArchiveWorker) RunOnce(ctx context.Context) ([]*transaction.HistoryArchive, error) {
+	now := time.Now()
+	if w.Clock != nil {
+		now = w.Clock()
+	}
+	now = now.UTC()
+
+	err := w.Repo.EnsurePartitions(ctx, now)
+	if err != nil {
+		return nil, err
+	}
+	if w.Retention <= 0 {
+		return nil, nil
+	}
+
+	month := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	return w.Repo.ArchiveHistory(ctx, month.AddDate(0, -w.Retention, 0))
+}
+
+// Run partitions and archives every Interval until ctx is done.
+func (w *ArchiveWorker) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.Interval)
+	defer ticker.Stop()
+
+	for {
+		archived, err := w.RunOnce(ctx)
+		if err != nil {
+			w.Logger.Errorw("History archiving failed",
+				"error", err.Error(),
+			)
+		}
+		for _, a := range archived {
+			w.Logger.Infow("History archived",
+				"partition", a.Partition,
+				"rows", a.Rows,
+				"file", a.File,
+			)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
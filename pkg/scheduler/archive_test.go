@@ -0,0 +1,57 @@
+package scheduler
+
+import (
+	"autumn-2021-intern-assignment/pkg/transaction"
+	"context"
+	"go.uber.org/zap"
+	"testing"
+	"time"
+)
+
+type fakeArchive struct {
+	partitions []time.Time
+	before     []time.Time
+}
+
+func (f *fakeArchive) EnsurePartitions(ctx context.Context, at time.Time) error {
+	f.partitions = append(f.partitions, at)
+	return nil
+}
+
+func (f *fakeArchive) ArchiveHistory(ctx context.Context, before time.Time) ([]*transaction.HistoryArchive, error) {
+	f.before = append(f.before, before)
+	return []*transaction.HistoryArchive{}, nil
+}
+
+func TestArchiveRunOnce(t *testing.T) {
+	repo := &fakeArchive{}
+	now := time.Date(2022, 1, 31, 23, 0, 0, 0, time.FixedZone("MSK", 3*60*60))
+	w := &ArchiveWorker{
+		Repo:   repo,
+		Logger: zap.NewNop().Sugar(),
+		Clock:  func() time.Time { return now },
+	}
+
+	// without retention only the partitions are made
+	_, err := w.RunOnce(context.Background())
+	if err != nil {
+		t.Errorf("unexpected err: %s", err)
+		return
+	}
+	if len(repo.partitions) != 1 || !repo.partitions[0].Equal(now) || len(repo.before) != 0 {
+		t.Errorf("unexpected calls %v, %v", repo.partitions, repo.before)
+		return
+	}
+
+	// the current month and the retention stay
+	w.Retention = 3
+	_, err = w.RunOnce(context.Background())
+	if err != nil {
+		t.Errorf("unexpected err: %s", err)
+		return
+	}
+	expect := time.Date(2021, 10, 1, 0, 0, 0, 0, time.UTC)
+	if len(repo.before) != 1 || !repo.before[0].Equal(expect) {
+		t.Errorf("results not match, want %v, have %v", expect, repo.before)
+	}
+}
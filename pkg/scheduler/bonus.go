@@ -0,0 +1,60 @@
+package scheduler
+
+import (
+	"autumn-2021-intern-assignment/pkg/transaction"
+	"context"
+	"go.uber.org/zap"
+	"time"
+)
+
+type BonusRepositoryInterface interface {
+	ExpireBonuses(ctx context.Context) ([]*transaction.BonusGrant, error)
+}
+
+// BonusExpiryWorker writes off the bonuses left unspent past their expiry.
+// Any number of workers may run.
+type BonusExpiryWorker struct {
+	Repo     BonusRepositoryInterface
+	Interval time.Duration
+	Logger   *zap.SugaredLogger
+}
+
+// RunOnce writes off the expired bonuses, it returns how many grants there
+// were.
+func (w *BonusExpiryWorker) RunOnce(ctx context.Context) (int, error) {
+	expired, err := w.Repo.ExpireBonuses(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, g := range expired {
+		w.Logger.Infow("Bonus expired",
+			"id", g.ID,
+			"user_id", g.UserID,
+			"written_off", g.Remaining,
+		)
+	}
+
+	return len(expired), nil
+}
+
+// Run writes off the expired bonuses every Interval until ctx is done.
+func (w *BonusExpiryWorker) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.Interval)
+	defer ticker.Stop()
+
+	for {
+		_, err := w.RunOnce(ctx)
+		if err != nil {
+			w.Logger.Errorw("Bonus expiry failed",
+				"error", err.Error(),
+			)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
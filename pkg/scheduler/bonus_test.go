@@ -0,0 +1,39 @@
+package scheduler
+
+import (
+	"autumn-2021-intern-assignment/pkg/transaction"
+	"context"
+	"fmt"
+	"go.uber.org/zap"
+	"testing"
+)
+
+type fakeBonuses struct {
+	expired []*transaction.BonusGrant
+	err     error
+	calls   int
+}
+
+func (f *fakeBonuses) ExpireBonuses(ctx context.Context) ([]*transaction.BonusGrant, error) {
+	f.calls++
+	return f.expired, f.err
+}
+
+func TestBonusExpiryRunOnce(t *testing.T) {
+	repo := &fakeBonuses{expired: []*transaction.BonusGrant{
+		{ID: 5, UserID: 1, Amount: 100, Remaining: 30, Status: transaction.BonusExpired},
+	}}
+	w := &BonusExpiryWorker{Repo: repo, Logger: zap.NewNop().Sugar()}
+
+	n, err := w.RunOnce(context.Background())
+	if err != nil || n != 1 || repo.calls != 1 {
+		t.Errorf("unexpected result %d, %v after %d calls", n, err, repo.calls)
+		return
+	}
+
+	repo.err = fmt.Errorf("db_error")
+	_, err = w.RunOnce(context.Background())
+	if err == nil {
+		t.Errorf("expected error, got nil")
+	}
+}
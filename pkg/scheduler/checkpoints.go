@@ -0,0 +1,62 @@
+package scheduler
+
+import (
+	"context"
+	"go.uber.org/zap"
+	"time"
+)
+
+type CheckpointRepositoryInterface interface {
+	CreateCheckpoint(ctx context.Context, at time.Time) (int64, error)
+}
+
+// CheckpointWorker stores daily balance checkpoints at midnight UTC, so
+// historical balances are replayed from at most a day of history. Any number
+// of workers may run, a checkpoint is stored once.
+type CheckpointWorker struct {
+	Repo     CheckpointRepositoryInterface
+	Interval time.Duration
+	// Delay leaves time for operations started before midnight to commit
+	Delay  time.Duration
+	Logger *zap.SugaredLogger
+	// Clock is time.Now when not set
+	Clock func() time.Time
+}
+
+// RunOnce stores the checkpoint of the last midnight old enough.
+func (w *CheckpointWorker) RunOnce(ctx context.Context) (int64, error) {
+	now := time.Now()
+	if w.Clock != nil {
+		now = w.Clock()
+	}
+
+	settled := now.UTC().Add(-w.Delay)
+	at := time.Date(settled.Year(), settled.Month(), settled.Day(), 0, 0, 0, 0, time.UTC)
+
+	return w.Repo.CreateCheckpoint(ctx, at)
+}
+
+// Run tries to store a checkpoint every Interval until ctx is done.
+func (w *CheckpointWorker) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.Interval)
+	defer ticker.Stop()
+
+	for {
+		n, err := w.RunOnce(ctx)
+		if err != nil {
+			w.Logger.Errorw("Balance checkpoint failed",
+				"error", err.Error(),
+			)
+		} else if n > 0 {
+			w.Logger.Infow("Balance checkpoint stored",
+				"accounts", n,
+			)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
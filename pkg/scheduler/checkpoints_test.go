@@ -0,0 +1,50 @@
+package scheduler
+
+import (
+	"context"
+	"go.uber.org/zap"
+	"testing"
+	"time"
+)
+
+type fakeCheckpoints struct {
+	at []time.Time
+}
+
+func (f *fakeCheckpoints) CreateCheckpoint(ctx context.Context, at time.Time) (int64, error) {
+	f.at = append(f.at, at)
+	return 1, nil
+}
+
+func TestCheckpointRunOnce(t *testing.T) {
+	repo := &fakeCheckpoints{}
+	now := time.Date(2021, 11, 27, 0, 3, 0, 0, time.UTC)
+	w := &CheckpointWorker{
+		Repo:   repo,
+		Delay:  5 * time.Minute,
+		Logger: zap.NewNop().Sugar(),
+		Clock:  func() time.Time { return now },
+	}
+
+	// right after midnight the previous one is stored
+	_, err := w.RunOnce(context.Background())
+	if err != nil {
+		t.Errorf("unexpected err: %s", err)
+		return
+	}
+
+	now = now.Add(10 * time.Minute).In(time.FixedZone("MSK", 3*60*60))
+	_, err = w.RunOnce(context.Background())
+	if err != nil {
+		t.Errorf("unexpected err: %s", err)
+		return
+	}
+
+	expect := []time.Time{
+		time.Date(2021, 11, 26, 0, 0, 0, 0, time.UTC),
+		time.Date(2021, 11, 27, 0, 0, 0, 0, time.UTC),
+	}
+	if len(repo.at) != 2 || !repo.at[0].Equal(expect[0]) || !repo.at[1].Equal(expect[1]) {
+		t.Errorf("results not match, want %v, have %v", expect, repo.at)
+	}
+}
@@ -0,0 +1,56 @@
+package scheduler
+
+import (
+	"context"
+	"go.uber.org/zap"
+	"time"
+)
+
+type IdempotencyRepositoryInterface interface {
+	ExpireIdempotencyKeys(ctx context.Context) (int64, error)
+}
+
+// IdempotencyExpiryWorker deletes the idempotency keys kept past their TTL.
+// Any number of workers may run.
+type IdempotencyExpiryWorker struct {
+	Repo     IdempotencyRepositoryInterface
+	Interval time.Duration
+	Logger   *zap.SugaredLogger
+}
+
+// RunOnce deletes the expired keys, it returns how many there were.
+func (w *IdempotencyExpiryWorker) RunOnce(ctx context.Context) (int64, error) {
+	n, err := w.Repo.ExpireIdempotencyKeys(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	if n > 0 {
+		w.Logger.Infow("Idempotency keys expired",
+			"count", n,
+		)
+	}
+
+	return n, nil
+}
+
+// Run deletes the expired keys every Interval until ctx is done.
+func (w *IdempotencyExpiryWorker) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.Interval)
+	defer ticker.Stop()
+
+	for {
+		_, err := w.RunOnce(ctx)
+		if err != nil {
+			w.Logger.Errorw("Idempotency key expiry failed",
+				"error", err.Error(),
+			)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"go.uber.org/zap"
+	"testing"
+)
+
+type fakeIdempotencyKeys struct {
+	expired int64
+	err     error
+	calls   int
+}
+
+func (f *fakeIdempotencyKeys) ExpireIdempotencyKeys(ctx context.Context) (int64, error) {
+	f.calls++
+	return f.expired, f.err
+}
+
+func TestIdempotencyExpiryRunOnce(t *testing.T) {
+	repo := &fakeIdempotencyKeys{expired: 4}
+	w := &IdempotencyExpiryWorker{Repo: repo, Logger: zap.NewNop().Sugar()}
+
+	n, err := w.RunOnce(context.Background())
+	if err != nil || n != 4 || repo.calls != 1 {
+		t.Errorf("unexpected result %d, %v after %d calls", n, err, repo.calls)
+		return
+	}
+
+	repo.err = fmt.Errorf("db_error")
+	_, err = w.RunOnce(context.Background())
+	if err == nil {
+		t.Errorf("expected error, got nil")
+	}
+}
@@ -0,0 +1,61 @@
+package scheduler
+
+import (
+	"autumn-2021-intern-assignment/pkg/transaction"
+	"context"
+	"go.uber.org/zap"
+	"time"
+)
+
+type PendingRepositoryInterface interface {
+	ExpirePendingOperations(ctx context.Context) ([]*transaction.PendingOperation, error)
+}
+
+// ExpiryWorker releases the money held by the operations nobody approved in
+// time. Any number of workers may run.
+type ExpiryWorker struct {
+	Repo     PendingRepositoryInterface
+	Interval time.Duration
+	Logger   *zap.SugaredLogger
+}
+
+// RunOnce expires the pending operations past their deadline, it returns how
+// many there were.
+func (w *ExpiryWorker) RunOnce(ctx context.Context) (int, error) {
+	expired, err := w.Repo.ExpirePendingOperations(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, p := range expired {
+		w.Logger.Infow("Pending operation expired",
+			"id", p.ID,
+			"operation", p.Operation,
+			"id_from", p.UserID,
+			"money", p.Money,
+		)
+	}
+
+	return len(expired), nil
+}
+
+// Run expires the pending operations every Interval until ctx is done.
+func (w *ExpiryWorker) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.Interval)
+	defer ticker.Stop()
+
+	for {
+		_, err := w.RunOnce(ctx)
+		if err != nil {
+			w.Logger.Errorw("Pending operations expiry failed",
+				"error", err.Error(),
+			)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
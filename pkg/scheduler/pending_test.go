@@ -0,0 +1,40 @@
+package scheduler
+
+import (
+	"autumn-2021-intern-assignment/pkg/transaction"
+	"context"
+	"fmt"
+	"go.uber.org/zap"
+	"testing"
+)
+
+type fakePending struct {
+	expired []*transaction.PendingOperation
+	err     error
+	calls   int
+}
+
+func (f *fakePending) ExpirePendingOperations(ctx context.Context) ([]*transaction.PendingOperation, error) {
+	f.calls++
+	return f.expired, f.err
+}
+
+func TestExpiryRunOnce(t *testing.T) {
+	repo := &fakePending{expired: []*transaction.PendingOperation{
+		{ID: 1, Operation: transaction.OperationWithdraw, UserID: 1, Money: 50000, Status: transaction.PendingExpired},
+		{ID: 2, Operation: transaction.OperationTransfer, UserID: 2, Money: 70000, Status: transaction.PendingExpired},
+	}}
+	w := &ExpiryWorker{Repo: repo, Logger: zap.NewNop().Sugar()}
+
+	n, err := w.RunOnce(context.Background())
+	if err != nil || n != 2 || repo.calls != 1 {
+		t.Errorf("unexpected result %d, %v after %d calls", n, err, repo.calls)
+		return
+	}
+
+	repo.err = fmt.Errorf("db_error")
+	_, err = w.RunOnce(context.Background())
+	if err == nil {
+		t.Errorf("expected error, got nil")
+	}
+}
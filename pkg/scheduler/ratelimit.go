@@ -0,0 +1,57 @@
+package scheduler
+
+import (
+	"context"
+	"go.uber.org/zap"
+	"time"
+)
+
+type RateLimitRepositoryInterface interface {
+	SweepRateLimits(ctx context.Context) (int64, error)
+}
+
+// RateLimitSweepWorker deletes the shared token buckets that are full again,
+// otherwise every client and account asked about keeps a row forever. Any
+// number of workers may run.
+type RateLimitSweepWorker struct {
+	Repo     RateLimitRepositoryInterface
+	Interval time.Duration
+	Logger   *zap.SugaredLogger
+}
+
+// RunOnce deletes the full buckets, it returns how many there were.
+func (w *RateLimitSweepWorker) RunOnce(ctx context.Context) (int64, error) {
+	n, err := w.Repo.SweepRateLimits(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	if n > 0 {
+		w.Logger.Infow("Rate limit buckets swept",
+			"count", n,
+		)
+	}
+
+	return n, nil
+}
+
+// Run deletes the full buckets every Interval until ctx is done.
+func (w *RateLimitSweepWorker) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.Interval)
+	defer ticker.Stop()
+
+	for {
+		_, err := w.RunOnce(ctx)
+		if err != nil {
+			w.Logger.Errorw("Rate limit sweep failed",
+				"error", err.Error(),
+			)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"go.uber.org/zap"
+	"testing"
+)
+
+type fakeRateLimits struct {
+	swept int64
+	err   error
+	calls int
+}
+
+func (f *fakeRateLimits) SweepRateLimits(ctx context.Context) (int64, error) {
+	f.calls++
+	return f.swept, f.err
+}
+
+func TestRateLimitSweepRunOnce(t *testing.T) {
+	repo := &fakeRateLimits{swept: 2}
+	w := &RateLimitSweepWorker{Repo: repo, Logger: zap.NewNop().Sugar()}
+
+	n, err := w.RunOnce(context.Background())
+	if err != nil || n != 2 || repo.calls != 1 {
+		t.Errorf("unexpected result %d, %v after %d calls", n, err, repo.calls)
+		return
+	}
+
+	repo.err = fmt.Errorf("db_error")
+	_, err = w.RunOnce(context.Background())
+	if err == nil {
+		t.Errorf("expected error, got nil")
+	}
+}
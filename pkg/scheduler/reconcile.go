@@ -0,0 +1,87 @@
+package scheduler
+
+import (
+	"autumn-2021-intern-assignment/pkg/transaction"
+	"context"
+	"errors"
+	"go.uber.org/zap"
+	"time"
+)
+
+type ReconcileRepositoryInterface interface {
+	Reconcile(ctx context.Context, fix bool) (*transaction.ReconciliationRun, error)
+	GetLastReconciliation(ctx context.Context) (*transaction.ReconciliationRun, error)
+}
+
+// ReconcileWorker runs the reconciliation once a day, Delay after midnight UTC.
+// Any number of workers may run, the last run stored in the database tells
+// whether the day is done.
+type ReconcileWorker struct {
+	Repo     ReconcileRepositoryInterface
+	Interval time.Duration
+	Delay    time.Duration
+	// Fix writes correcting entries for the mismatches found
+	Fix    bool
+	Logger *zap.SugaredLogger
+	// Clock is time.Now when not set
+	Clock func() time.Time
+}
+
+// RunOnce reconciles unless today's run is done, nil run means it was skipped.
+func (w *ReconcileWorker) RunOnce(ctx context.Context) (*transaction.ReconciliationRun, error) {
+	now := time.Now()
+	if w.Clock != nil {
+		now = w.Clock()
+	}
+
+	settled := now.UTC().Add(-w.Delay)
+	due := time.Date(settled.Year(), settled.Month(), settled.Day(), 0, 0, 0, 0, time.UTC).Add(w.Delay)
+
+	last, err := w.Repo.GetLastReconciliation(ctx)
+	if err != nil && !errors.Is(err, transaction.ErrNoReconciliation) {
+		return nil, err
+	}
+	if last != nil && !last.StartedAt.Before(due) {
+		return nil, nil
+	}
+
+	run, err := w.Repo.Reconcile(ctx, w.Fix)
+	if errors.Is(err, transaction.ErrReconcileRunning) {
+		return nil, nil
+	}
+
+	return run, err
+}
+
+// Run checks every Interval whether the reconciliation is due until ctx is done.
+func (w *ReconcileWorker) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.Interval)
+	defer ticker.Stop()
+
+	for {
+		run, err := w.RunOnce(ctx)
+		if err != nil {
+			w.Logger.Errorw("Reconciliation failed",
+				"error", err.Error(),
+			)
+		} else if run != nil && run.Mismatches > 0 {
+			w.Logger.Warnw("Reconciliation found mismatches",
+				"run", run.ID,
+				"accounts", run.Accounts,
+				"mismatches", run.Mismatches,
+				"corrected", run.Corrected,
+			)
+		} else if run != nil {
+			w.Logger.Infow("Reconciliation finished",
+				"run", run.ID,
+				"accounts", run.Accounts,
+			)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package scheduler
+
+import (
+	"autumn-2021-intern-assignment/pkg/transaction"
+	"context"
+	"go.uber.org/zap"
+	"testing"
+	"time"
+)
+
+type fakeReconcile struct {
+	last    *transaction.ReconciliationRun
+	runs    int
+	running bool
+}
+
+func (f *fakeReconcile) Reconcile(ctx context.Context, fix bool) (*transaction.ReconciliationRun, error) {
+	if f.running {
+		return nil, transaction.ErrReconcileRunning
+	}
+	f.runs++
+	f.last = &transaction.ReconciliationRun{ID: f.runs, StartedAt: time.Now(), Fix: fix}
+	return f.last, nil
+}
+
+func (f *fakeReconcile) GetLastReconciliation(ctx context.Context) (*transaction.ReconciliationRun, error) {
+	if f.last == nil {
+		return nil, transaction.ErrNoReconciliation
+	}
+	return f.last, nil
+}
+
+func TestReconcileRunOnce(t *testing.T) {
+	repo := &fakeReconcile{}
+	now := time.Date(2021, 11, 27, 0, 30, 0, 0, time.UTC)
+	w := &ReconcileWorker{
+		Repo:   repo,
+		Delay:  time.Hour,
+		Fix:    true,
+		Logger: zap.NewNop().Sugar(),
+		Clock:  func() time.Time { return now },
+	}
+
+	// nothing has run yet
+	run, err := w.RunOnce(context.Background())
+	if err != nil || run == nil || !run.Fix {
+		t.Errorf("unexpected result %v, %v", run, err)
+		return
+	}
+
+	// yesterday's run is done
+	repo.last.StartedAt = now
+	run, err = w.RunOnce(context.Background())
+	if err != nil || run != nil {
+		t.Errorf("unexpected result %v, %v", run, err)
+		return
+	}
+
+	// today's run is due
+	now = now.Add(time.Hour)
+	run, err = w.RunOnce(context.Background())
+	if err != nil || run == nil || repo.runs != 2 {
+		t.Errorf("unexpected result %v, %v", run, err)
+		return
+	}
+
+	// another instance is running it
+	repo.last.StartedAt = now.Add(-48 * time.Hour)
+	repo.running = true
+	run, err = w.RunOnce(context.Background())
+	if err != nil || run != nil {
+		t.Errorf("unexpected result %v, %v", run, err)
+	}
+}
@@ -0,0 +1,78 @@
+package scheduler
+
+import (
+	"autumn-2021-intern-assignment/pkg/transaction"
+	"context"
+	"go.uber.org/zap"
+	"time"
+)
+
+type ScheduleRepositoryInterface interface {
+	ClaimSchedules(ctx context.Context, workerID string, lease time.Duration, limit int) ([]*transaction.Schedule, error)
+	RunSchedule(ctx context.Context, s *transaction.Schedule, workerID string) error
+}
+
+// Worker runs due schedules. Any number of workers may share the database,
+// each needs its own ID.
+type Worker struct {
+	Repo     ScheduleRepositoryInterface
+	ID       string
+	Interval time.Duration
+	// Lease should be well above the time a batch takes to run
+	Lease  time.Duration
+	Batch  int
+	Logger *zap.SugaredLogger
+}
+
+// RunOnce claims one batch of due schedules and runs it.
+func (w *Worker) RunOnce(ctx context.Context) (int, error) {
+	schedules, err := w.Repo.ClaimSchedules(ctx, w.ID, w.Lease, w.Batch)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, s := range schedules {
+		err = w.Repo.RunSchedule(ctx, s, w.ID)
+		if err != nil {
+			w.Logger.Errorw("Schedule run failed",
+				"worker", w.ID,
+				"schedule", s.ID,
+				"error", err.Error(),
+			)
+		}
+	}
+
+	return len(schedules), nil
+}
+
+// Run checks for due schedules every Interval until ctx is done.
+// A full batch is followed by the next one right away.
+func (w *Worker) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.Interval)
+	defer ticker.Stop()
+
+	for {
+		n, err := w.RunOnce(ctx)
+		if err != nil {
+			w.Logger.Errorw("Schedules claim failed",
+				"worker", w.ID,
+				"error", err.Error(),
+			)
+		}
+
+		if err == nil && n == w.Batch {
+			select {
+			case <-ctx.Done():
+				return
+			default:
+				continue
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package scheduler
+
+import (
+	"autumn-2021-intern-assignment/pkg/transaction"
+	"context"
+	"fmt"
+	"go.uber.org/zap"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeRepo struct {
+	mu      sync.Mutex
+	due     []*transaction.Schedule
+	claimed map[int]string
+	runs    []int
+	runErr  error
+}
+
+func (f *fakeRepo) ClaimSchedules(ctx context.Context, workerID string, lease time.Duration, limit int) ([]*transaction.Schedule, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	res := make([]*transaction.Schedule, 0, limit)
+	for _, s := range f.due {
+		if len(res) == limit {
+			break
+		}
+		if _, ok := f.claimed[s.ID]; ok {
+			continue
+		}
+		f.claimed[s.ID] = workerID
+		res = append(res, s)
+	}
+
+	return res, nil
+}
+
+func (f *fakeRepo) RunSchedule(ctx context.Context, s *transaction.Schedule, workerID string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.claimed[s.ID] != workerID {
+		return fmt.Errorf("schedule %d is not leased to %s", s.ID, workerID)
+	}
+	f.runs = append(f.runs, s.ID)
+
+	return f.runErr
+}
+
+func TestRunOnce(t *testing.T) {
+	repo := &fakeRepo{
+		due:     []*transaction.Schedule{{ID: 1}, {ID: 2}, {ID: 3}},
+		claimed: map[int]string{},
+	}
+	w := &Worker{Repo: repo, ID: "worker-1", Lease: time.Minute, Batch: 2, Logger: zap.NewNop().Sugar()}
+
+	n, err := w.RunOnce(context.Background())
+	if err != nil || n != 2 {
+		t.Errorf("expected 2 runs, got %d, %v", n, err)
+		return
+	}
+
+	// run errors are logged, the batch goes on
+	repo.runErr = transaction.ErrNotEnoughMoney
+	n, err = w.RunOnce(context.Background())
+	if err != nil || n != 1 {
+		t.Errorf("expected 1 run, got %d, %v", n, err)
+		return
+	}
+
+	if !reflect.DeepEqual(repo.runs, []int{1, 2, 3}) {
+		t.Errorf("bad runs: %v", repo.runs)
+	}
+}
+
+func TestRunWorkers(t *testing.T) {
+	repo := &fakeRepo{claimed: map[int]string{}}
+	for i := 1; i <= 50; i++ {
+		repo.due = append(repo.due, &transaction.Schedule{ID: i})
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	wg := &sync.WaitGroup{}
+	for i := 0; i < 3; i++ {
+		w := &Worker{Repo: repo, ID: fmt.Sprintf("worker-%d", i), Interval: time.Millisecond, Lease: time.Minute,
+			Batch: 4, Logger: zap.NewNop().Sugar()}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w.Run(ctx)
+		}()
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		repo.mu.Lock()
+		done := len(repo.runs) == len(repo.due)
+		repo.mu.Unlock()
+		if done {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	wg.Wait()
+
+	// every schedule is run once
+	seen := map[int]bool{}
+	for _, id := range repo.runs {
+		if seen[id] {
+			t.Errorf("schedule %d is run twice", id)
+		}
+		seen[id] = true
+	}
+	if len(seen) != len(repo.due) {
+		t.Errorf("expected %d runs, got %d", len(repo.due), len(seen))
+	}
+}
@@ -0,0 +1,267 @@
+package transaction
+
+import (
+	"context"
+	"database/sql"
+	"math"
+)
+
+const BaseCurrency = "RUB"
+
+const (
+	AccountActive = "active"
+	AccountFrozen = "frozen"
+	AccountClosed = "closed"
+)
+
+const (
+	OverdraftEntered = "entered"
+	OverdraftLeft    = "left"
+)
+
+const accountColumns = "id, external_ref, status, debit_blocked, credit_blocked, balance, created_at, closed_at, credit_limit"
+
+func (acc *Account) canDebit() error {
+	if acc.Status == AccountClosed {
+		return ErrAccountClosed
+	}
+	if acc.DebitBlocked {
+		return ErrAccountFrozen
+	}
+	return nil
+}
+
+func (acc *Account) canCredit() error {
+	if acc.Status == AccountClosed {
+		return ErrAccountClosed
+	}
+	if acc.CreditBlocked {
+		return ErrAccountFrozen
+	}
+	return nil
+}
+
+func scanAccount(row *sql.Row) (*Account, error) {
+	acc := &Account{
+		Currencies: []string{BaseCurrency},
+	}
+
+	err := row.Scan(&acc.ID, &acc.ExternalRef, &acc.Status, &acc.DebitBlocked, &acc.CreditBlocked,
+		&acc.Balance, &acc.CreatedAt, &acc.ClosedAt, &acc.CreditLimit)
+	if err == sql.ErrNoRows {
+		return nil, ErrAccountNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	acc.CreatedAt = acc.CreatedAt.UTC()
+	if acc.ClosedAt != nil {
+		closedAt := acc.ClosedAt.UTC()
+		acc.ClosedAt = &closedAt
+	}
+
+	return acc, nil
+}
+
+// lockAccount reads the state money operations depend on and keeps the row
+// locked until the end of the transaction.
+func lockAccount(userID int, db TransactionInterface) (*Account, error) {
+	acc := &Account{ID: userID}
+	err := db.QueryRow("SELECT balance, status, debit_blocked, credit_blocked, credit_limit FROM users WHERE id = $1 FOR UPDATE",
+		userID).Scan(&acc.Balance, &acc.Status, &acc.DebitBlocked, &acc.CreditBlocked, &acc.CreditLimit)
+	if err == sql.ErrNoRows {
+		return nil, ErrAccountNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return acc, nil
+}
+
+func (r *RepositoryItem) CreateAccount(ctx context.Context, userID int, externalRef string) (*Account, error) {
+	if userID <= 0 {
+		return nil, ErrBadAccountID
+	}
+
+	var ref *string
+	if externalRef != "" {
+		ref = &externalRef
+	}
+
+	ctx, cancel := r.operation(ctx)
+	defer cancel()
+
+	// nothing is returned when the id or the external reference is taken
+	acc, err := scanAccount(r.DB.QueryRowContext(ctx, "INSERT INTO users (id, balance, external_ref, status, created_at) "+
+		"VALUES ($1, 0, $2, $3, $4) ON CONFLICT DO NOTHING returning "+accountColumns,
+		userID, ref, AccountActive, r.now()))
+	if err == ErrAccountNotFound {
+		return nil, ErrAccountExists
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return acc, nil
+}
+
+func (r *RepositoryItem) GetAccount(ctx context.Context, userID int) (*Account, error) {
+	ctx, cancel := r.operation(ctx)
+	defer cancel()
+
+	return scanAccount(r.DB.QueryRowContext(ctx, "SELECT "+accountColumns+" FROM users WHERE id = $1", userID))
+}
+
+// FreezeAccount blocks debits and/or credits, both are blocked when none is chosen.
+func (r *RepositoryItem) FreezeAccount(ctx context.Context, userID int, debit, credit bool) (*Account, error) {
+	if !debit && !credit {
+		debit, credit = true, true
+	}
+
+	return r.updateAccount(ctx, userID, func(acc *Account, db TransactionInterface) (*sql.Row, error) {
+		return setAccountBlocks(acc.ID, acc.DebitBlocked || debit, acc.CreditBlocked || credit, db), nil
+	})
+}
+
+// UnfreezeAccount lifts the chosen blocks, both are lifted when none is chosen.
+func (r *RepositoryItem) UnfreezeAccount(ctx context.Context, userID int, debit, credit bool) (*Account, error) {
+	if !debit && !credit {
+		debit, credit = true, true
+	}
+
+	return r.updateAccount(ctx, userID, func(acc *Account, db TransactionInterface) (*sql.Row, error) {
+		return setAccountBlocks(acc.ID, acc.DebitBlocked && !debit, acc.CreditBlocked && !credit, db), nil
+	})
+}
+
+// CloseAccount closes an empty account: no balance, no money held for pending
+// operations and no unexpired bonuses.
+func (r *RepositoryItem) CloseAccount(ctx context.Context, userID int) (*Account, error) {
+	return r.updateAccount(ctx, userID, func(acc *Account, db TransactionInterface) (*sql.Row, error) {
+		// REAL balances keep rounding residue, like in Reconcile
+		if math.Abs(acc.Balance) >= reconcileTolerance {
+			return nil, ErrAccountNotEmpty
+		}
+
+		var held float64
+		var bonuses bool
+		err := db.QueryRow("SELECT held, EXISTS (SELECT 1 FROM bonus_grants WHERE user_id = $1 AND remaining > 0 "+
+			"AND expires_at > $2) FROM users WHERE id = $1", acc.ID, r.now()).Scan(&held, &bonuses)
+		if err != nil {
+			return nil, err
+		}
+		if math.Abs(held) >= reconcileTolerance || bonuses {
+			return nil, ErrAccountNotEmpty
+		}
+
+		return db.QueryRow("UPDATE users SET status = $2, closed_at = $3 WHERE id = $1 returning "+accountColumns,
+			acc.ID, AccountClosed, r.now()), nil
+	})
+}
+
+// SetCreditLimit lets the account be debited down to -limit. A lower limit
+// does not touch a balance that is already below it, only new debits fail.
+func (r *RepositoryItem) SetCreditLimit(ctx context.Context, userID int, limit float64) (*Account, error) {
+	if limit < 0 {
+		return nil, ErrNegativeAmount
+	}
+
+	return r.updateAccount(ctx, userID, func(acc *Account, db TransactionInterface) (*sql.Row, error) {
+		return db.QueryRow("UPDATE users SET credit_limit = $2 WHERE id = $1 returning "+accountColumns,
+			acc.ID, limit), nil
+	})
+}
+
+func (r *RepositoryItem) GetOverdraftHistory(ctx context.Context, userID int) ([]*OverdraftEvent, error) {
+	ctx, cancel := r.operation(ctx)
+	defer cancel()
+
+	rows, err := r.DB.QueryContext(ctx, "SELECT id, user_id, event, balance, created FROM overdraft_history WHERE user_id = $1 ORDER BY id",
+		userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := make([]*OverdraftEvent, 0)
+	for rows.Next() {
+		curr := &OverdraftEvent{}
+		err = rows.Scan(&curr.ID, &curr.UserID, &curr.Event, &curr.Balance, &curr.Created)
+		if err != nil {
+			return nil, err
+		}
+		curr.Created = curr.Created.UTC()
+		events = append(events, curr)
+	}
+
+	return events, rows.Err()
+}
+
+// recordOverdraft writes an event when a balance change crosses zero.
+func (r *RepositoryItem) recordOverdraft(userID int, before, after float64, db TransactionInterface) error {
+	var event string
+	switch {
+	case before >= 0 && after < 0:
+		event = OverdraftEntered
+	case before < 0 && after >= 0:
+		event = OverdraftLeft
+	default:
+		return nil
+	}
+
+	_, err := db.Exec("INSERT INTO overdraft_history (user_id, event, balance, created) VALUES ($1, $2, $3, $4)",
+		userID, event, after, r.now())
+	return err
+}
+
+func setAccountBlocks(userID int, debit, credit bool, db TransactionInterface) *sql.Row {
+	status := AccountActive
+	if debit || credit {
+		status = AccountFrozen
+	}
+
+	return db.QueryRow("UPDATE users SET debit_blocked = $2, credit_blocked = $3, status = $4 WHERE id = $1 returning "+
+		accountColumns, userID, debit, credit, status)
+}
+
+// updateAccount changes a locked open account, closed accounts stay as they are.
+func (r *RepositoryItem) updateAccount(ctx context.Context, userID int,
+	update func(acc *Account, db TransactionInterface) (*sql.Row, error)) (*Account, error) {
+	ctx, cancel := r.operation(ctx)
+	defer cancel()
+
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	db := withContext(ctx, tx)
+
+	acc, err := lockAccount(userID, db)
+	if err == nil && acc.Status == AccountClosed {
+		err = ErrAccountClosed
+	}
+	if err != nil {
+		//nolint:errcheck
+		tx.Rollback()
+		return nil, err
+	}
+
+	row, err := update(acc, db)
+	if err == nil {
+		acc, err = scanAccount(row)
+	}
+	if err != nil {
+		//nolint:errcheck
+		tx.Rollback()
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return acc, nil
+}
@@ -0,0 +1,605 @@
+package transaction
+
+import (
+	"fmt"
+	sqlmock "gopkg.in/DATA-DOG/go-sqlmock.v1"
+	"reflect"
+	"testing"
+)
+
+var accountColumnNames = []string{"id", "external_ref", "status", "debit_blocked", "credit_blocked",
+	"balance", "created_at", "closed_at", "credit_limit"}
+
+func TestCreateAccount(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("cant create mock: %s", err)
+	}
+	defer db.Close()
+	repo := NewRepository(db)
+	repo.Clock = testClock
+
+	ref := "contract-1"
+	expect := &Account{
+		ID:          1,
+		ExternalRef: &ref,
+		Status:      AccountActive,
+		Currencies:  []string{BaseCurrency},
+		CreatedAt:   testTime,
+	}
+
+	mock.
+		ExpectQuery("INSERT INTO users").
+		WithArgs(1, &ref, AccountActive, testTime).
+		WillReturnRows(sqlmock.NewRows(accountColumnNames).
+			AddRow(1, ref, AccountActive, false, false, 0.0, testTime, nil, 0.0))
+
+	acc, err := repo.CreateAccount(ctx, 1, ref)
+	if err != nil {
+		t.Errorf("unexpected err: %s", err)
+		return
+	}
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+		return
+	}
+	if !reflect.DeepEqual(acc, expect) {
+		t.Errorf("results not match, want %v, have %v", expect, acc)
+		return
+	}
+
+	// id or reference is taken
+	mock.
+		ExpectQuery("INSERT INTO users").
+		WithArgs(1, nil, AccountActive, testTime).
+		WillReturnRows(sqlmock.NewRows(accountColumnNames))
+
+	_, err = repo.CreateAccount(ctx, 1, "")
+	if err != ErrAccountExists {
+		t.Errorf("expected ErrAccountExists, got %v", err)
+		return
+	}
+
+	// bad id
+	_, err = repo.CreateAccount(ctx, 0, "")
+	if err != ErrBadAccountID {
+		t.Errorf("expected ErrBadAccountID, got %v", err)
+		return
+	}
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestGetAccount(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("cant create mock: %s", err)
+	}
+	defer db.Close()
+	repo := NewRepository(db)
+
+	closedAt := testTime
+	expect := &Account{
+		ID:         1,
+		Status:     AccountClosed,
+		Currencies: []string{BaseCurrency},
+		CreatedAt:  testTime,
+		ClosedAt:   &closedAt,
+	}
+
+	mock.
+		ExpectQuery("SELECT id, external_ref, status, debit_blocked, credit_blocked, balance, created_at, closed_at, credit_limit FROM users WHERE").
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows(accountColumnNames).
+			AddRow(1, nil, AccountClosed, false, false, 0.0, testTime, testTime, 0.0))
+
+	acc, err := repo.GetAccount(ctx, 1)
+	if err != nil {
+		t.Errorf("unexpected err: %s", err)
+		return
+	}
+	if !reflect.DeepEqual(acc, expect) {
+		t.Errorf("results not match, want %v, have %v", expect, acc)
+		return
+	}
+
+	// not found
+	mock.
+		ExpectQuery("SELECT id, external_ref, status, debit_blocked, credit_blocked, balance, created_at, closed_at, credit_limit FROM users WHERE").
+		WithArgs(2).
+		WillReturnRows(sqlmock.NewRows(accountColumnNames))
+
+	_, err = repo.GetAccount(ctx, 2)
+	if err != ErrAccountNotFound {
+		t.Errorf("expected ErrAccountNotFound, got %v", err)
+		return
+	}
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestFreezeAccount(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("cant create mock: %s", err)
+	}
+	defer db.Close()
+	repo := NewRepository(db)
+
+	// only debits, credit block is kept
+	mock.ExpectBegin()
+	mock.
+		ExpectQuery("SELECT balance, status, debit_blocked, credit_blocked, credit_limit FROM users WHERE").
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"balance", "status", "debit_blocked", "credit_blocked", "credit_limit"}).
+			AddRow(10.0, AccountFrozen, false, true, 0.0))
+	mock.
+		ExpectQuery("UPDATE users SET debit_blocked").
+		WithArgs(1, true, true, AccountFrozen).
+		WillReturnRows(sqlmock.NewRows(accountColumnNames).
+			AddRow(1, nil, AccountFrozen, true, true, 10.0, testTime, nil, 0.0))
+	mock.ExpectCommit()
+
+	acc, err := repo.FreezeAccount(ctx, 1, true, false)
+	if err != nil {
+		t.Errorf("unexpected err: %s", err)
+		return
+	}
+	if acc.Status != AccountFrozen || !acc.DebitBlocked || !acc.CreditBlocked {
+		t.Errorf("account is not frozen: %v", acc)
+		return
+	}
+
+	// unfreeze credits only
+	mock.ExpectBegin()
+	mock.
+		ExpectQuery("SELECT balance, status, debit_blocked, credit_blocked, credit_limit FROM users WHERE").
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"balance", "status", "debit_blocked", "credit_blocked", "credit_limit"}).
+			AddRow(10.0, AccountFrozen, true, true, 0.0))
+	mock.
+		ExpectQuery("UPDATE users SET debit_blocked").
+		WithArgs(1, true, false, AccountFrozen).
+		WillReturnRows(sqlmock.NewRows(accountColumnNames).
+			AddRow(1, nil, AccountFrozen, true, false, 10.0, testTime, nil, 0.0))
+	mock.ExpectCommit()
+
+	_, err = repo.UnfreezeAccount(ctx, 1, false, true)
+	if err != nil {
+		t.Errorf("unexpected err: %s", err)
+		return
+	}
+
+	// unfreeze everything
+	mock.ExpectBegin()
+	mock.
+		ExpectQuery("SELECT balance, status, debit_blocked, credit_blocked, credit_limit FROM users WHERE").
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"balance", "status", "debit_blocked", "credit_blocked", "credit_limit"}).
+			AddRow(10.0, AccountFrozen, true, false, 0.0))
+	mock.
+		ExpectQuery("UPDATE users SET debit_blocked").
+		WithArgs(1, false, false, AccountActive).
+		WillReturnRows(sqlmock.NewRows(accountColumnNames).
+			AddRow(1, nil, AccountActive, false, false, 10.0, testTime, nil, 0.0))
+	mock.ExpectCommit()
+
+	acc, err = repo.UnfreezeAccount(ctx, 1, false, false)
+	if err != nil {
+		t.Errorf("unexpected err: %s", err)
+		return
+	}
+	if acc.Status != AccountActive {
+		t.Errorf("account is not active: %v", acc)
+		return
+	}
+
+	// closed account
+	mock.ExpectBegin()
+	mock.
+		ExpectQuery("SELECT balance, status, debit_blocked, credit_blocked, credit_limit FROM users WHERE").
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"balance", "status", "debit_blocked", "credit_blocked", "credit_limit"}).
+			AddRow(0.0, AccountClosed, false, false, 0.0))
+	mock.ExpectRollback()
+
+	_, err = repo.FreezeAccount(ctx, 1, false, false)
+	if err != ErrAccountClosed {
+		t.Errorf("expected ErrAccountClosed, got %v", err)
+		return
+	}
+
+	// not found
+	mock.ExpectBegin()
+	mock.
+		ExpectQuery("SELECT balance, status, debit_blocked, credit_blocked, credit_limit FROM users WHERE").
+		WithArgs(2).
+		WillReturnRows(sqlmock.NewRows([]string{"balance", "status", "debit_blocked", "credit_blocked", "credit_limit"}))
+	mock.ExpectRollback()
+
+	_, err = repo.FreezeAccount(ctx, 2, false, false)
+	if err != ErrAccountNotFound {
+		t.Errorf("expected ErrAccountNotFound, got %v", err)
+		return
+	}
+
+	// expect begin
+	mock.ExpectBegin().WillReturnError(fmt.Errorf("error"))
+
+	_, err = repo.FreezeAccount(ctx, 1, false, false)
+	if err == nil {
+		t.Errorf("expected error, got nil")
+		return
+	}
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func expectCloseable(mock sqlmock.Sqlmock, held float64, bonuses bool) {
+	mock.
+		ExpectQuery("SELECT held, EXISTS \\(SELECT 1 FROM bonus_grants WHERE user_id = \\$1 AND remaining > 0 "+
+			"AND expires_at > \\$2\\) FROM users WHERE id = \\$1").
+		WithArgs(1, testTime).
+		WillReturnRows(sqlmock.NewRows([]string{"held", "exists"}).AddRow(held, bonuses))
+}
+
+func TestCloseAccount(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("cant create mock: %s", err)
+	}
+	defer db.Close()
+	repo := NewRepository(db)
+	repo.Clock = testClock
+
+	mock.ExpectBegin()
+	mock.
+		ExpectQuery("SELECT balance, status, debit_blocked, credit_blocked, credit_limit FROM users WHERE").
+		WithArgs(1).
+		WillReturnRows(accountRows(0))
+	expectCloseable(mock, 0, false)
+	mock.
+		ExpectQuery("UPDATE users SET status").
+		WithArgs(1, AccountClosed, testTime).
+		WillReturnRows(sqlmock.NewRows(accountColumnNames).
+			AddRow(1, nil, AccountClosed, false, false, 0.0, testTime, testTime, 0.0))
+	mock.ExpectCommit()
+
+	acc, err := repo.CloseAccount(ctx, 1)
+	if err != nil {
+		t.Errorf("unexpected err: %s", err)
+		return
+	}
+	if acc.Status != AccountClosed || acc.ClosedAt == nil {
+		t.Errorf("account is not closed: %v", acc)
+		return
+	}
+
+	// money left
+	mock.ExpectBegin()
+	mock.
+		ExpectQuery("SELECT balance, status, debit_blocked, credit_blocked, credit_limit FROM users WHERE").
+		WithArgs(1).
+		WillReturnRows(accountRows(10))
+	mock.ExpectRollback()
+
+	_, err = repo.CloseAccount(ctx, 1)
+	if err != ErrAccountNotEmpty {
+		t.Errorf("expected ErrAccountNotEmpty, got %v", err)
+		return
+	}
+
+	// money held for a pending operation
+	mock.ExpectBegin()
+	mock.
+		ExpectQuery("SELECT balance, status, debit_blocked, credit_blocked, credit_limit FROM users WHERE").
+		WithArgs(1).
+		WillReturnRows(accountRows(0))
+	expectCloseable(mock, 500, false)
+	mock.ExpectRollback()
+
+	_, err = repo.CloseAccount(ctx, 1)
+	if err != ErrAccountNotEmpty {
+		t.Errorf("expected ErrAccountNotEmpty for held money, got %v", err)
+		return
+	}
+
+	// unexpired bonuses
+	mock.ExpectBegin()
+	mock.
+		ExpectQuery("SELECT balance, status, debit_blocked, credit_blocked, credit_limit FROM users WHERE").
+		WithArgs(1).
+		WillReturnRows(accountRows(0))
+	expectCloseable(mock, 0, true)
+	mock.ExpectRollback()
+
+	_, err = repo.CloseAccount(ctx, 1)
+	if err != ErrAccountNotEmpty {
+		t.Errorf("expected ErrAccountNotEmpty for bonuses, got %v", err)
+		return
+	}
+
+	// rounding residue of conversions and splits is not money
+	mock.ExpectBegin()
+	mock.
+		ExpectQuery("SELECT balance, status, debit_blocked, credit_blocked, credit_limit FROM users WHERE").
+		WithArgs(1).
+		WillReturnRows(accountRows(1e-15))
+	expectCloseable(mock, -1e-12, false)
+	mock.
+		ExpectQuery("UPDATE users SET status").
+		WithArgs(1, AccountClosed, testTime).
+		WillReturnRows(sqlmock.NewRows(accountColumnNames).
+			AddRow(1, nil, AccountClosed, false, false, 0.0, testTime, testTime, 1e-15))
+	mock.ExpectCommit()
+
+	_, err = repo.CloseAccount(ctx, 1)
+	if err != nil {
+		t.Errorf("unexpected err: %s", err)
+		return
+	}
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestAccountStatusHonored(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("cant create mock: %s", err)
+	}
+	defer db.Close()
+	repo := NewRepository(db)
+
+	// debits are blocked
+	mock.ExpectBegin()
+	expectNoBonuses(mock)
+	mock.
+		ExpectQuery("SELECT balance, status, debit_blocked, credit_blocked, credit_limit FROM users WHERE").
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"balance", "status", "debit_blocked", "credit_blocked", "credit_limit"}).
+			AddRow(100.0, AccountFrozen, true, false, 0.0))
+	mock.ExpectRollback()
+
+	_, err = repo.WithdrawMoney(ctx, 1, 10)
+	if err != ErrAccountFrozen {
+		t.Errorf("expected ErrAccountFrozen, got %v", err)
+		return
+	}
+
+	// credits are blocked for the receiver
+	mock.ExpectBegin()
+	mock.
+		ExpectQuery("SELECT balance, status, debit_blocked, credit_blocked, credit_limit FROM users WHERE").
+		WithArgs(1).
+		WillReturnRows(accountRows(100))
+	mock.
+		ExpectQuery("UPDATE users SET").
+		WithArgs(10.0, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"balance"}).AddRow(90.0))
+	expectNoLimits(mock)
+	mock.
+		ExpectQuery("SELECT balance, status, debit_blocked, credit_blocked, credit_limit FROM users WHERE").
+		WithArgs(2).
+		WillReturnRows(sqlmock.NewRows([]string{"balance", "status", "debit_blocked", "credit_blocked", "credit_limit"}).
+			AddRow(0.0, AccountFrozen, false, true, 0.0))
+	mock.ExpectRollback()
+
+	_, err = repo.TransferMoney(ctx, 1, 2, 10)
+	if err != ErrAccountFrozen {
+		t.Errorf("expected ErrAccountFrozen, got %v", err)
+		return
+	}
+
+	// closed account gets no deposits
+	mock.ExpectBegin()
+	mock.
+		ExpectQuery("SELECT balance, status, debit_blocked, credit_blocked, credit_limit FROM users WHERE").
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"balance", "status", "debit_blocked", "credit_blocked", "credit_limit"}).
+			AddRow(0.0, AccountClosed, false, false, 0.0))
+	mock.ExpectRollback()
+
+	_, err = repo.AddMoney(ctx, 1, 10)
+	if err != ErrAccountClosed {
+		t.Errorf("expected ErrAccountClosed, got %v", err)
+		return
+	}
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestSetCreditLimit(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("cant create mock: %s", err)
+	}
+	defer db.Close()
+	repo := NewRepository(db)
+
+	mock.ExpectBegin()
+	mock.
+		ExpectQuery("SELECT balance, status, debit_blocked, credit_blocked, credit_limit FROM users WHERE").
+		WithArgs(1).
+		WillReturnRows(accountRows(10))
+	mock.
+		ExpectQuery("UPDATE users SET credit_limit").
+		WithArgs(1, 500.0).
+		WillReturnRows(sqlmock.NewRows(accountColumnNames).
+			AddRow(1, nil, AccountActive, false, false, 10.0, testTime, nil, 500.0))
+	mock.ExpectCommit()
+
+	acc, err := repo.SetCreditLimit(ctx, 1, 500)
+	if err != nil {
+		t.Errorf("unexpected err: %s", err)
+		return
+	}
+	if acc.CreditLimit != 500 {
+		t.Errorf("expected credit limit 500, got %v", acc.CreditLimit)
+		return
+	}
+
+	// negative limit
+	_, err = repo.SetCreditLimit(ctx, 1, -1)
+	if err != ErrNegativeAmount {
+		t.Errorf("expected ErrNegativeAmount, got %v", err)
+		return
+	}
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestOverdraft(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("cant create mock: %s", err)
+	}
+	defer db.Close()
+	repo := NewRepository(db)
+	repo.Clock = testClock
+
+	elemID := 1
+	rows := func(balance, creditLimit float64) *sqlmock.Rows {
+		return sqlmock.NewRows([]string{"balance", "status", "debit_blocked", "credit_blocked", "credit_limit"}).
+			AddRow(balance, AccountActive, false, false, creditLimit)
+	}
+
+	// the debit goes below zero within the credit limit
+	mock.ExpectBegin()
+	expectNoBonuses(mock)
+	mock.
+		ExpectQuery("SELECT balance, status, debit_blocked, credit_blocked, credit_limit FROM users WHERE").
+		WithArgs(1).
+		WillReturnRows(rows(10, 100))
+	mock.
+		ExpectQuery("UPDATE users SET").
+		WithArgs(50.0, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"balance"}).AddRow(-40.0))
+	mock.
+		ExpectExec("INSERT INTO overdraft_history").
+		WithArgs(1, OverdraftEntered, -40.0, testTime).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	expectNoLimits(mock)
+	mock.
+		ExpectQuery("INSERT INTO transaction").
+		WithArgs(nil, &elemID, -50.0, testTime, nil).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created"}).AddRow(1, testTime))
+	expectNoFee(mock, OperationWithdraw)
+	mock.ExpectCommit()
+
+	tr, err := repo.WithdrawMoney(ctx, 1, 50)
+	if err != nil {
+		t.Errorf("unexpected err: %s", err)
+		return
+	}
+	if *tr.Balance != -40 {
+		t.Errorf("expected balance -40, got %v", *tr.Balance)
+		return
+	}
+
+	// the credit limit is used up
+	mock.ExpectBegin()
+	expectNoBonuses(mock)
+	mock.
+		ExpectQuery("SELECT balance, status, debit_blocked, credit_blocked, credit_limit FROM users WHERE").
+		WithArgs(1).
+		WillReturnRows(rows(-40, 100))
+	mock.ExpectRollback()
+
+	_, err = repo.WithdrawMoney(ctx, 1, 61)
+	if err != ErrNotEnoughMoney {
+		t.Errorf("expected ErrNotEnoughMoney, got %v", err)
+		return
+	}
+
+	// a deposit brings the account back
+	mock.ExpectBegin()
+	mock.
+		ExpectQuery("SELECT balance, status, debit_blocked, credit_blocked, credit_limit FROM users WHERE").
+		WithArgs(1).
+		WillReturnRows(rows(-40, 100))
+	mock.
+		ExpectQuery("UPDATE users SET").
+		WithArgs(40.0, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"balance"}).AddRow(0.0))
+	mock.
+		ExpectExec("INSERT INTO overdraft_history").
+		WithArgs(1, OverdraftLeft, 0.0, testTime).
+		WillReturnResult(sqlmock.NewResult(2, 1))
+	mock.
+		ExpectQuery("INSERT INTO transaction").
+		WithArgs(&elemID, nil, 40.0, testTime, nil).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created"}).AddRow(2, testTime))
+	mock.ExpectCommit()
+
+	_, err = repo.AddMoney(ctx, 1, 40)
+	if err != nil {
+		t.Errorf("unexpected err: %s", err)
+		return
+	}
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestGetOverdraftHistory(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("cant create mock: %s", err)
+	}
+	defer db.Close()
+	repo := NewRepository(db)
+
+	expect := []*OverdraftEvent{
+		{ID: 1, UserID: 1, Event: OverdraftEntered, Balance: -40, Created: testTime},
+		{ID: 2, UserID: 1, Event: OverdraftLeft, Balance: 0, Created: testTime},
+	}
+
+	rows := sqlmock.NewRows([]string{"id", "user_id", "event", "balance", "created"})
+	for _, item := range expect {
+		rows = rows.AddRow(item.ID, item.UserID, item.Event, item.Balance, item.Created)
+	}
+
+	mock.
+		ExpectQuery("SELECT id, user_id, event, balance, created FROM overdraft_history WHERE").
+		WithArgs(1).
+		WillReturnRows(rows)
+
+	events, err := repo.GetOverdraftHistory(ctx, 1)
+	if err != nil {
+		t.Errorf("unexpected err: %s", err)
+		return
+	}
+	if !reflect.DeepEqual(events, expect) {
+		t.Errorf("results not match, want %v, have %v", expect, events)
+		return
+	}
+
+	// query error
+	mock.
+		ExpectQuery("SELECT id, user_id, event, balance, created FROM overdraft_history WHERE").
+		WithArgs(1).
+		WillReturnError(fmt.Errorf("db_error"))
+
+	_, err = repo.GetOverdraftHistory(ctx, 1)
+	if err == nil {
+		t.Errorf("expected error, got nil")
+		return
+	}
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
@@ -0,0 +1,73 @@
+package transaction
+
+import (
+	"context"
+	"strings"
+)
+
+// Adjust credits (positive money) or debits (negative money) an account by
+// hand, e.g. when support settles a complaint. The reason and the author are
+// stored with the operation. Adjustments bypass limits and fees, but not the
+// balance check and account blocks.
+func (r *RepositoryItem) Adjust(ctx context.Context, req *Adjustment) (*Transaction, error) {
+	if req.UserID <= 0 {
+		return nil, ErrBadAccountID
+	}
+	if req.Money == 0 || strings.TrimSpace(req.Reason) == "" || strings.TrimSpace(req.Author) == "" {
+		return nil, ErrBadAdjustment
+	}
+
+	ctx, cancel := r.operation(ctx)
+	defer cancel()
+
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	tr, err := r.adjust(req, withContext(ctx, tx))
+	if err != nil {
+		//nolint:errcheck
+		tx.Rollback()
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return tr, nil
+}
+
+func (r *RepositoryItem) adjust(req *Adjustment, db TransactionInterface) (*Transaction, error) {
+	var tr *Transaction
+	if req.Money > 0 {
+		var err error
+		tr, err = r.deposit(req.UserID, req.Money, db)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		balance, err := r.getMoneyFromDB(req.UserID, -req.Money, db)
+		if err != nil {
+			return nil, err
+		}
+
+		// stored like a negative deposit, so it is not counted by limits
+		// and can't be refunded
+		tr, err = r.writeTransaction(&req.UserID, nil, req.Money, nil, db)
+		if err != nil {
+			return nil, err
+		}
+		tr.Balance = &balance
+	}
+
+	_, err := db.Exec("INSERT INTO adjustments (transaction_id, reason, author, created) VALUES ($1, $2, $3, $4)",
+		tr.ID, strings.TrimSpace(req.Reason), strings.TrimSpace(req.Author), tr.Created)
+	if err != nil {
+		return nil, err
+	}
+
+	return tr, nil
+}
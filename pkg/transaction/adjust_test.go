@@ -0,0 +1,104 @@
+package transaction
+
+import (
+	"fmt"
+	sqlmock "gopkg.in/DATA-DOG/go-sqlmock.v1"
+	"testing"
+)
+
+func TestAdjust(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("cant create mock: %s", err)
+	}
+	defer db.Close()
+	repo := NewRepository(db)
+	repo.Clock = testClock
+
+	elemID := 1
+
+	// credit
+	mock.ExpectBegin()
+	expectDeposit(mock, elemID, 100, 5)
+	mock.
+		ExpectExec("INSERT INTO adjustments").
+		WithArgs(5, "complaint 42", "support", testTime).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	tr, err := repo.Adjust(ctx, &Adjustment{UserID: elemID, Money: 100, Reason: " complaint 42 ", Author: "support"})
+	if err != nil || tr.ID != 5 || *tr.Balance != 100 {
+		t.Errorf("unexpected result %v, %v", tr, err)
+		return
+	}
+
+	// debit
+	mock.ExpectBegin()
+	mock.
+		ExpectQuery("SELECT balance, status, debit_blocked, credit_blocked, credit_limit FROM users WHERE").
+		WithArgs(elemID).
+		WillReturnRows(accountRows(100))
+	mock.
+		ExpectQuery("UPDATE users SET").
+		WithArgs(30.0, elemID).
+		WillReturnRows(sqlmock.NewRows([]string{"balance"}).AddRow(70.0))
+	mock.
+		ExpectQuery("INSERT INTO transaction").
+		WithArgs(&elemID, nil, -30.0, testTime, nil).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created"}).AddRow(6, testTime))
+	mock.
+		ExpectExec("INSERT INTO adjustments").
+		WithArgs(6, "duplicate credit", "support", testTime).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	tr, err = repo.Adjust(ctx, &Adjustment{UserID: elemID, Money: -30, Reason: "duplicate credit", Author: "support"})
+	if err != nil || tr.ID != 6 || *tr.Balance != 70 || tr.Money != -30 {
+		t.Errorf("unexpected result %v, %v", tr, err)
+		return
+	}
+
+	// not enough money
+	mock.ExpectBegin()
+	mock.
+		ExpectQuery("SELECT balance, status, debit_blocked, credit_blocked, credit_limit FROM users WHERE").
+		WithArgs(elemID).
+		WillReturnRows(accountRows(10))
+	mock.ExpectRollback()
+
+	_, err = repo.Adjust(ctx, &Adjustment{UserID: elemID, Money: -30, Reason: "duplicate credit", Author: "support"})
+	if err != ErrNotEnoughMoney {
+		t.Errorf("expected ErrNotEnoughMoney, got %v", err)
+		return
+	}
+
+	// the note is written with the operation
+	mock.ExpectBegin()
+	expectDeposit(mock, elemID, 100, 7)
+	mock.
+		ExpectExec("INSERT INTO adjustments").
+		WillReturnError(fmt.Errorf("db_error"))
+	mock.ExpectRollback()
+
+	_, err = repo.Adjust(ctx, &Adjustment{UserID: elemID, Money: 100, Reason: "complaint 42", Author: "support"})
+	if err == nil {
+		t.Errorf("expected db error, got nil")
+		return
+	}
+
+	for _, req := range []*Adjustment{
+		{UserID: elemID, Reason: "complaint 42", Author: "support"},
+		{UserID: elemID, Money: 10, Reason: " ", Author: "support"},
+		{UserID: elemID, Money: 10, Reason: "complaint 42"},
+	} {
+		_, err = repo.Adjust(ctx, req)
+		if err != ErrBadAdjustment {
+			t.Errorf("expected ErrBadAdjustment, got %v", err)
+			return
+		}
+	}
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
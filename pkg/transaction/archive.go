@@ -0,0 +1,451 @@
+package transaction
+
+import (
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// ManifestFile is the name of the manifest in the archive directory.
+const ManifestFile = "manifest.json"
+
+// archiveLockID is the advisory lock held by a running archiving.
+const archiveLockID = 38
+
+// partitionName matches the monthly partitions made by
+// create_transaction_partition, the default partition is never archived.
+var partitionName = regexp.MustCompile(`^transaction_(\d{4})_(\d{2})$`)
+
+func monthStart(at time.Time) time.Time {
+	at = at.UTC()
+	return time.Date(at.Year(), at.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+// EnsurePartitions creates the partitions of the month of at and of the next
+// one, so operations never land in the default partition.
+func (r *RepositoryItem) EnsurePartitions(ctx context.Context, at time.Time) error {
+	ctx, cancel := r.operation(ctx)
+	defer cancel()
+
+	month := monthStart(at)
+	for _, m := range []time.Time{month, month.AddDate(0, 1, 0)} {
+		_, err := r.DB.ExecContext(ctx, "SELECT create_transaction_partition($1)", m)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ArchiveHistory moves the monthly partitions that end before before to files
+// in ArchiveDir, oldest first, and rewrites the manifest. The archived
+// operations are still returned by the history, while balances at times
+// before the archived months can no longer be replayed. It reads whole
+// months, so only ctx bounds it.
+func (r *RepositoryItem) ArchiveHistory(ctx context.Context, before time.Time) ([]*HistoryArchive, error) {
+	if r.ArchiveDir == "" {
+		return nil, ErrArchiveDisabled
+	}
+
+	// the lock of the session keeps other workers out until the run is over
+	conn, err := r.DB.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	var locked bool
+	err = conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1)", archiveLockID).Scan(&locked)
+	if err != nil {
+		return nil, err
+	}
+	if !locked {
+		return []*HistoryArchive{}, nil
+	}
+	//nolint:errcheck
+	defer conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", archiveLockID)
+
+	rows, err := r.DB.QueryContext(ctx, "SELECT c.relname FROM pg_inherits i JOIN pg_class c ON c.oid = i.inhrelid "+
+		"WHERE i.inhparent = 'transaction'::regclass")
+	if err != nil {
+		return nil, err
+	}
+
+	months := map[string]time.Time{}
+	names := make([]string, 0)
+	for rows.Next() {
+		var name string
+		err = rows.Scan(&name)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		match := partitionName.FindStringSubmatch(name)
+		if match == nil {
+			continue
+		}
+		year, _ := strconv.Atoi(match[1])
+		month, _ := strconv.Atoi(match[2])
+		from := time.Date(year, time.Month(month), 1, 0, 0, 0, 0, time.UTC)
+		if from.AddDate(0, 1, 0).After(before) {
+			continue
+		}
+		months[name] = from
+		names = append(names, name)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+
+	archived := make([]*HistoryArchive, 0, len(names))
+	for _, name := range names {
+		archive, err := r.archivePartition(ctx, name, months[name])
+		if err != nil {
+			return archived, fmt.Errorf("archive %s: %w", name, err)
+		}
+		archived = append(archived, archive)
+	}
+
+	if len(archived) > 0 {
+		err = r.writeManifest(ctx)
+		if err != nil {
+			return archived, err
+		}
+	}
+
+	return archived, nil
+}
+
+// archivePartition writes the file first and then drops the partition in one
+// transaction with the records of it, a failure in between leaves a file that
+// the next run writes again.
+func (r *RepositoryItem) archivePartition(ctx context.Context, name string, from time.Time) (*HistoryArchive, error) {
+	archive := &HistoryArchive{
+		Partition: name,
+		From:      from,
+		To:        from.AddDate(0, 1, 0),
+		File:      name + ".jsonl.gz",
+	}
+
+	err := r.writeArchive(ctx, archive)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	err = r.dropPartition(archive, withContext(ctx, tx))
+	if err != nil {
+		//nolint:errcheck
+		tx.Rollback()
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return archive, nil
+}
+
+func (r *RepositoryItem) writeArchive(ctx context.Context, archive *HistoryArchive) error {
+	f, err := os.CreateTemp(r.ArchiveDir, archive.File+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	sum := sha256.New()
+	zw := gzip.NewWriter(io.MultiWriter(f, sum))
+	enc := json.NewEncoder(zw)
+
+	// the name is checked by partitionName
+	rows, err := r.DB.QueryContext(ctx, "SELECT id, to_id, from_id, money, created, refund_of, fee_of, split_of FROM "+
+		archive.Partition+" ORDER BY id")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		tr := &Transaction{}
+		err = rows.Scan(&tr.ID, &tr.ToID, &tr.FromID, &tr.Money, &tr.Created, &tr.RefundOf, &tr.FeeOf, &tr.SplitOf)
+		if err != nil {
+			return err
+		}
+		tr.Created = tr.Created.UTC()
+
+		err = enc.Encode(tr)
+		if err != nil {
+			return err
+		}
+
+		archive.Rows++
+		id := tr.ID
+		if archive.MinID == nil {
+			archive.MinID = &id
+		}
+		archive.MaxID = &id
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	err = zw.Close()
+	if err != nil {
+		return err
+	}
+	err = f.Sync()
+	if err != nil {
+		return err
+	}
+	err = f.Close()
+	if err != nil {
+		return err
+	}
+	archive.SHA256 = hex.EncodeToString(sum.Sum(nil))
+
+	return os.Rename(f.Name(), filepath.Join(r.ArchiveDir, archive.File))
+}
+
+// dropPartition detaches the partition, checks it still holds what was
+// written, adds its effect to archived_balances and drops it.
+func (r *RepositoryItem) dropPartition(archive *HistoryArchive, db TransactionInterface) error {
+	_, err := db.Exec("ALTER TABLE transaction DETACH PARTITION " + archive.Partition)
+	if err != nil {
+		return err
+	}
+
+	var rows int64
+	err = db.QueryRow("SELECT COUNT(*) FROM " + archive.Partition).Scan(&rows)
+	if err != nil {
+		return err
+	}
+	if rows != archive.Rows {
+		return fmt.Errorf("partition has %d operations, %d are written", rows, archive.Rows)
+	}
+
+	_, err = db.Exec("INSERT INTO archived_balances (user_id, balance) SELECT u.id, SUM((" + balanceEffect("u.id") + ")::float8) " +
+		"FROM " + archive.Partition + " t JOIN users u ON u.id IN (t.to_id, t.from_id) GROUP BY u.id " +
+		"ON CONFLICT (user_id) DO UPDATE SET balance = archived_balances.balance + EXCLUDED.balance")
+	if err != nil {
+		return err
+	}
+
+	archive.ArchivedAt = r.now()
+	_, err = db.Exec("INSERT INTO history_archives (partition, from_ts, to_ts, rows, min_id, max_id, file, sha256, archived_at, "+
+		"users_indexed) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, true)", archive.Partition, archive.From, archive.To, archive.Rows,
+		archive.MinID, archive.MaxID, archive.File, archive.SHA256, archive.ArchivedAt)
+	if err != nil {
+		return err
+	}
+
+	// the history of a user opens only the months the user is in
+	_, err = db.Exec("INSERT INTO history_archive_users (partition, user_id) SELECT DISTINCT $1::text, u.id FROM "+
+		archive.Partition+" t, unnest(ARRAY[t.to_id, t.from_id]) AS u(id) WHERE u.id IS NOT NULL", archive.Partition)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec("DROP TABLE " + archive.Partition)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// historyArchives lists the archives matching the condition, all of them for
+// an empty one.
+func (r *RepositoryItem) historyArchives(ctx context.Context, where string, args ...interface{}) ([]*HistoryArchive, error) {
+	if where != "" {
+		where = "WHERE " + where + " "
+	}
+	rows, err := r.DB.QueryContext(ctx, "SELECT partition, from_ts, to_ts, rows, min_id, max_id, file, sha256, archived_at "+
+		"FROM history_archives a "+where+"ORDER BY from_ts", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	archives := make([]*HistoryArchive, 0)
+	for rows.Next() {
+		a := &HistoryArchive{}
+		err = rows.Scan(&a.Partition, &a.From, &a.To, &a.Rows, &a.MinID, &a.MaxID, &a.File, &a.SHA256, &a.ArchivedAt)
+		if err != nil {
+			return nil, err
+		}
+		a.From, a.To, a.ArchivedAt = a.From.UTC(), a.To.UTC(), a.ArchivedAt.UTC()
+		archives = append(archives, a)
+	}
+
+	return archives, rows.Err()
+}
+
+// writeManifest replaces the manifest with the list of all archives.
+func (r *RepositoryItem) writeManifest(ctx context.Context) error {
+	archives, err := r.historyArchives(ctx, "")
+	if err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(&ArchiveManifest{Archives: archives}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(r.ArchiveDir, ManifestFile+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	_, err = f.Write(append(data, '\n'))
+	if err != nil {
+		return err
+	}
+	err = f.Close()
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), filepath.Join(r.ArchiveDir, ManifestFile))
+}
+
+// readArchive calls fn for every operation of the file and checks its
+// checksum at the end.
+func (r *RepositoryItem) readArchive(ctx context.Context, archive *HistoryArchive, fn func(tr *Transaction)) error {
+	f, err := os.Open(filepath.Join(r.ArchiveDir, archive.File))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	sum := sha256.New()
+	zr, err := gzip.NewReader(io.TeeReader(f, sum))
+	if err != nil {
+		return fmt.Errorf("archive %s: %w", archive.File, err)
+	}
+
+	dec := json.NewDecoder(zr)
+	for {
+		if err = ctx.Err(); err != nil {
+			return err
+		}
+
+		tr := &Transaction{}
+		err = dec.Decode(tr)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("archive %s: %w", archive.File, err)
+		}
+		fn(tr)
+	}
+
+	// the gzip reader may stop before the end of the file, the rest is hashed too
+	_, err = io.Copy(sum, f)
+	if err != nil {
+		return err
+	}
+	if hex.EncodeToString(sum.Sum(nil)) != archive.SHA256 {
+		return fmt.Errorf("archive %s: checksum mismatch", archive.File)
+	}
+
+	return nil
+}
+
+// archivedHistory returns the archived operations of the user, nothing when
+// archiving is off. Only the months with operations of the user are read, and
+// the ones archived before the months were indexed.
+func (r *RepositoryItem) archivedHistory(ctx context.Context, userID int) ([]*Transaction, error) {
+	if r.ArchiveDir == "" {
+		return nil, nil
+	}
+
+	archives, err := r.historyArchives(ctx, "NOT a.users_indexed OR EXISTS "+
+		"(SELECT 1 FROM history_archive_users u WHERE u.partition = a.partition AND u.user_id = $1)", userID)
+	if err != nil {
+		return nil, err
+	}
+
+	info := make([]*Transaction, 0)
+	for _, archive := range archives {
+		err = r.readArchive(ctx, archive, func(tr *Transaction) {
+			if (tr.ToID != nil && *tr.ToID == userID) || (tr.FromID != nil && *tr.FromID == userID) {
+				info = append(info, tr)
+			}
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return info, nil
+}
+
+// archivedTransaction looks for the operation in the archive whose ids cover
+// it, the legs of a split payment are archived with it.
+func (r *RepositoryItem) archivedTransaction(ctx context.Context, transactionID int) (*Transaction, error) {
+	if r.ArchiveDir == "" {
+		return nil, ErrTransactionNotFound
+	}
+
+	archives, err := r.historyArchives(ctx, "a.min_id <= $1 AND a.max_id >= $1", transactionID)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, archive := range archives {
+		var found *Transaction
+		legs := make([]*Transaction, 0)
+		err = r.readArchive(ctx, archive, func(tr *Transaction) {
+			switch {
+			case tr.ID == transactionID:
+				found = tr
+			case tr.SplitOf != nil && *tr.SplitOf == transactionID:
+				legs = append(legs, tr)
+			}
+		})
+		if err != nil {
+			return nil, err
+		}
+		if found != nil {
+			if found.isSplit() {
+				found.Legs = legs
+			}
+			return found, nil
+		}
+	}
+
+	return nil, ErrTransactionNotFound
+}
+
+// archiveHorizon is the end of the archived history, balances before it can't
+// be replayed.
+func archiveHorizon(db TransactionInterface) (sql.NullTime, error) {
+	var horizon sql.NullTime
+	err := db.QueryRow("SELECT MAX(to_ts) FROM history_archives").Scan(&horizon)
+	return horizon, err
+}
@@ -0,0 +1,246 @@
+package transaction
+
+import (
+	"encoding/json"
+	sqlmock "gopkg.in/DATA-DOG/go-sqlmock.v1"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+var archiveColumns = []string{"partition", "from_ts", "to_ts", "rows", "min_id", "max_id", "file", "sha256", "archived_at"}
+
+func TestArchiveHistory(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("cant create mock: %s", err)
+	}
+	defer db.Close()
+
+	repo := NewRepository(db)
+	repo.Clock = testClock
+
+	// archiving is off without a directory
+	_, err = repo.ArchiveHistory(ctx, testTime)
+	if err != ErrArchiveDisabled {
+		t.Errorf("expected ErrArchiveDisabled, got %v", err)
+		return
+	}
+
+	repo.ArchiveDir = t.TempDir()
+	from := time.Date(2021, 9, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2021, 10, 1, 0, 0, 0, 0, time.UTC)
+	created := time.Date(2021, 9, 15, 10, 0, 0, 0, time.UTC)
+
+	mock.
+		ExpectQuery("SELECT pg_try_advisory_lock").
+		WithArgs(archiveLockID).
+		WillReturnRows(sqlmock.NewRows([]string{"locked"}).AddRow(true))
+	mock.
+		ExpectQuery("SELECT c.relname FROM pg_inherits").
+		WillReturnRows(sqlmock.NewRows([]string{"relname"}).
+			AddRow("transaction_2021_10").AddRow("transaction_default").AddRow("transaction_2021_09"))
+	mock.
+		ExpectQuery("SELECT id, to_id, from_id, money, created, refund_of, fee_of, split_of FROM transaction_2021_09 ORDER BY id").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "to_id", "from_id", "money", "created", "refund_of", "fee_of", "split_of"}).
+			AddRow(1, 1, nil, 100.0, created, nil, nil, nil).
+			AddRow(2, nil, 1, 30.0, created, nil, nil, nil).
+			AddRow(3, 2, 1, 10.0, created, nil, nil, 2).
+			AddRow(4, 3, 1, 20.0, created, nil, nil, 2))
+	mock.ExpectBegin()
+	mock.
+		ExpectExec("ALTER TABLE transaction DETACH PARTITION transaction_2021_09").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.
+		ExpectQuery("SELECT COUNT\\(\\*\\) FROM transaction_2021_09").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(4))
+	mock.
+		ExpectExec("INSERT INTO archived_balances .* FROM transaction_2021_09 t .* ON CONFLICT").
+		WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.
+		ExpectExec("INSERT INTO history_archives").
+		WithArgs("transaction_2021_09", from, to, int64(4), 1, 4, "transaction_2021_09.jsonl.gz", sqlmock.AnyArg(), testTime).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.
+		ExpectExec("INSERT INTO history_archive_users \\(partition, user_id\\) SELECT DISTINCT .* FROM transaction_2021_09 t").
+		WithArgs("transaction_2021_09").
+		WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.
+		ExpectExec("DROP TABLE transaction_2021_09").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+
+	mock.
+		ExpectQuery("SELECT partition, from_ts, to_ts, rows, min_id, max_id, file, sha256, archived_at FROM history_archives").
+		WillReturnRows(sqlmock.NewRows(archiveColumns).
+			AddRow("transaction_2021_09", from, to, 4, 1, 4, "transaction_2021_09.jsonl.gz", "checksum", testTime))
+	mock.
+		ExpectExec("SELECT pg_advisory_unlock").
+		WithArgs(archiveLockID).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	archived, err := repo.ArchiveHistory(ctx, to)
+	if err != nil {
+		t.Errorf("unexpected err: %s", err)
+		return
+	}
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+		return
+	}
+	minID, maxID := 1, 4
+	expect := &HistoryArchive{Partition: "transaction_2021_09", From: from, To: to, Rows: 4, MinID: &minID, MaxID: &maxID,
+		File: "transaction_2021_09.jsonl.gz", ArchivedAt: testTime}
+	if len(archived) != 1 || archived[0].SHA256 == "" {
+		t.Errorf("unexpected archives %v", archived)
+		return
+	}
+	expect.SHA256 = archived[0].SHA256
+	if !reflect.DeepEqual(archived[0], expect) {
+		t.Errorf("results not match, want %v, have %v", expect, archived[0])
+		return
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(repo.ArchiveDir, ManifestFile))
+	if err != nil {
+		t.Errorf("no manifest: %s", err)
+		return
+	}
+	manifest := &ArchiveManifest{}
+	err = json.Unmarshal(data, manifest)
+	if err != nil || len(manifest.Archives) != 1 || manifest.Archives[0].Partition != "transaction_2021_09" {
+		t.Errorf("unexpected manifest %s, %v", data, err)
+		return
+	}
+
+	// the history reads the months of the user in the archive and the table
+	expectArchives := func(where string, arg int) {
+		mock.
+			ExpectQuery("SELECT partition, from_ts, to_ts, rows, min_id, max_id, file, sha256, archived_at FROM history_archives a " +
+				"WHERE " + where).
+			WithArgs(arg).
+			WillReturnRows(sqlmock.NewRows(archiveColumns).
+				AddRow(expect.Partition, from, to, 4, 1, 4, expect.File, expect.SHA256, testTime))
+	}
+	mock.
+		ExpectQuery("SELECT id, to_id, from_id, money, created, refund_of, fee_of, split_of FROM transaction where").
+		WithArgs(2).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "to_id", "from_id", "money", "created", "refund_of", "fee_of", "split_of"}).
+			AddRow(7, nil, 2, -5.0, testTime, nil, nil, nil))
+	expectNoBonusSpent(mock, 2)
+	expectNoPendingHistory(mock, 2)
+	expectArchives("NOT a.users_indexed OR EXISTS \\(SELECT 1 FROM history_archive_users u", 2)
+
+	history, err := repo.GetTransaction(ctx, 2, "")
+	if err != nil {
+		t.Errorf("unexpected err: %s", err)
+		return
+	}
+	if len(history) != 2 || history[0].ID != 3 || !history[0].Created.Equal(created) || history[1].ID != 7 {
+		t.Errorf("unexpected history %v", history)
+		return
+	}
+
+	// an archived split payment comes with its legs
+	mock.
+		ExpectQuery("SELECT id, to_id, from_id, money, created, refund_of, fee_of, split_of FROM transaction WHERE id").
+		WithArgs(2).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	expectArchives("a.min_id <= \\$1 AND a.max_id >= \\$1", 2)
+
+	tr, err := repo.GetTransactionByID(ctx, 2)
+	if err != nil {
+		t.Errorf("unexpected err: %s", err)
+		return
+	}
+	if tr.ID != 2 || tr.Money != 30 || len(tr.Legs) != 2 || tr.Legs[0].ID != 3 || tr.Legs[1].ID != 4 {
+		t.Errorf("unexpected transaction %v", tr)
+		return
+	}
+
+	// ids outside of the archives are not read
+	mock.
+		ExpectQuery("SELECT id, to_id, from_id, money, created, refund_of, fee_of, split_of FROM transaction WHERE id").
+		WithArgs(9).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	mock.
+		ExpectQuery("FROM history_archives a WHERE a.min_id <= \\$1 AND a.max_id >= \\$1").
+		WithArgs(9).
+		WillReturnRows(sqlmock.NewRows(archiveColumns))
+
+	_, err = repo.GetTransactionByID(ctx, 9)
+	if err != ErrTransactionNotFound {
+		t.Errorf("expected ErrTransactionNotFound, got %v", err)
+		return
+	}
+
+	// nor the months without the user
+	mock.
+		ExpectQuery("SELECT id, to_id, from_id, money, created, refund_of, fee_of, split_of FROM transaction where").
+		WithArgs(5).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "to_id", "from_id", "money", "created", "refund_of", "fee_of", "split_of"}))
+	expectNoBonusSpent(mock, 5)
+	expectNoPendingHistory(mock, 5)
+	mock.
+		ExpectQuery("FROM history_archives a WHERE NOT a.users_indexed OR EXISTS").
+		WithArgs(5).
+		WillReturnRows(sqlmock.NewRows(archiveColumns))
+
+	history, err = repo.GetTransaction(ctx, 5, "")
+	if err != nil || len(history) != 0 {
+		t.Errorf("unexpected history %v, %v", history, err)
+		return
+	}
+
+	// a damaged file is not trusted
+	mock.
+		ExpectQuery("SELECT id, to_id, from_id, money, created, refund_of, fee_of, split_of FROM transaction where").
+		WithArgs(2).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "to_id", "from_id", "money", "created", "refund_of", "fee_of", "split_of"}))
+	expectNoBonusSpent(mock, 2)
+	expectNoPendingHistory(mock, 2)
+	mock.
+		ExpectQuery("SELECT partition, from_ts, to_ts, rows, min_id, max_id, file, sha256, archived_at FROM history_archives").
+		WithArgs(2).
+		WillReturnRows(sqlmock.NewRows(archiveColumns).
+			AddRow(expect.Partition, from, to, 4, 1, 4, expect.File, "0000", testTime))
+
+	_, err = repo.GetTransaction(ctx, 2, "")
+	if err == nil {
+		t.Errorf("expected checksum error, got nil")
+		return
+	}
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestArchiveHistoryLocked(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("cant create mock: %s", err)
+	}
+	defer db.Close()
+
+	repo := NewRepository(db)
+	repo.ArchiveDir = t.TempDir()
+
+	// another worker is archiving
+	mock.
+		ExpectQuery("SELECT pg_try_advisory_lock").
+		WithArgs(archiveLockID).
+		WillReturnRows(sqlmock.NewRows([]string{"locked"}).AddRow(false))
+
+	archived, err := repo.ArchiveHistory(ctx, testTime)
+	if err != nil || len(archived) != 0 {
+		t.Errorf("unexpected result %v, %v", archived, err)
+		return
+	}
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
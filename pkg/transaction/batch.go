@@ -0,0 +1,172 @@
+package transaction
+
+import (
+	"context"
+	"errors"
+)
+
+// MaxBatchSize is the most operations one batch may hold.
+const MaxBatchSize = 10000
+
+const (
+	BatchItemSuccess    = "success"
+	BatchItemError      = "error"
+	BatchItemNotApplied = "not_applied"
+	BatchItemHeld       = "held"
+)
+
+func (item *BatchItem) validate() error {
+	switch item.Operation {
+	case OperationDeposit, OperationWithdraw:
+	case OperationTransfer:
+		if item.ToUserID <= 0 {
+			return ErrBadAccountID
+		}
+	default:
+		return ErrBadOperation
+	}
+
+	if item.UserID <= 0 {
+		return ErrBadAccountID
+	}
+	if item.Money < 0 {
+		return ErrNegativeAmount
+	}
+
+	return nil
+}
+
+func (r *RepositoryItem) applyBatchItem(item *BatchItem, requester string, db TransactionInterface) (*Transaction, error) {
+	switch item.Operation {
+	case OperationDeposit:
+		return r.deposit(item.UserID, item.Money, db)
+	case OperationWithdraw:
+		return r.debit(&payment{Operation: OperationWithdraw, UserID: item.UserID, Money: item.Money, Service: item.Service,
+			RequestedBy: requester}, db)
+	default:
+		return r.debit(&payment{Operation: OperationTransfer, UserID: item.UserID, ToUserID: &item.ToUserID, Money: item.Money,
+			RequestedBy: requester}, db)
+	}
+}
+
+// ApplyBatch runs the operations in order. An atomic batch is applied in one
+// transaction: the first refused operation rolls everything back and
+// ErrBatchFailed is returned with the results. Otherwise every operation is
+// applied on its own and the results tell which ones failed. A debit above the
+// approval threshold or stopped for a risk review is held then. An atomic batch
+// fails with it instead: it can't wait for the approval, and a debit the risk
+// rules hold is denied in it. A batch may take longer than Timeout, only ctx
+// bounds it.
+func (r *RepositoryItem) ApplyBatch(ctx context.Context, items []*BatchItem, atomic bool) ([]*BatchResult, error) {
+	if len(items) > MaxBatchSize {
+		return nil, ErrBatchTooLarge
+	}
+
+	results := make([]*BatchResult, len(items))
+	valid := true
+	for i, item := range items {
+		results[i] = &BatchResult{Index: i, Status: BatchItemNotApplied}
+		err := item.validate()
+		if err == nil && atomic && item.Operation != OperationDeposit && r.needsApproval(item.Money) {
+			err = ErrApprovalInBatch
+		}
+		if err != nil {
+			results[i].Status = BatchItemError
+			results[i].Error = err.Error()
+			valid = false
+		}
+	}
+
+	if !atomic {
+		for i, item := range items {
+			if results[i].Status == BatchItemError {
+				continue
+			}
+
+			tx, err := r.DB.BeginTx(ctx, nil)
+			if err == nil {
+				results[i].Transaction, err = r.applyBatchItem(item, Requester(ctx), withContext(ctx, tx))
+				if err != nil && !isHeld(err) {
+					//nolint:errcheck
+					tx.Rollback()
+					err = r.recordRisk(ctx, err)
+				} else if commitErr := tx.Commit(); commitErr != nil {
+					err = commitErr
+				}
+			}
+
+			pendingErr := &PendingError{}
+			if errors.As(err, &pendingErr) {
+				results[i].Status = BatchItemHeld
+				results[i].PendingID = pendingErr.Pending.ID
+				continue
+			}
+			riskErr := &RiskError{}
+			if errors.As(err, &riskErr) && riskErr.Decision == RiskHold {
+				results[i].Status = BatchItemHeld
+				results[i].ReviewID = riskErr.ReviewID
+				results[i].Transaction = nil
+				continue
+			}
+			if err != nil {
+				results[i].Status = BatchItemError
+				results[i].Error = err.Error()
+				results[i].Transaction = nil
+				continue
+			}
+			results[i].Status = BatchItemSuccess
+		}
+
+		return results, nil
+	}
+
+	if !valid {
+		return results, ErrBatchFailed
+	}
+
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	db := withContext(ctx, tx)
+
+	for i, item := range items {
+		tr, err := r.applyBatchItem(item, Requester(ctx), db)
+		if err != nil {
+			//nolint:errcheck
+			tx.Rollback()
+			riskErr := &RiskError{}
+			if errors.As(err, &riskErr) {
+				if riskErr.Decision == RiskHold {
+					riskErr.Decision = RiskDeny
+					riskErr.Verdicts = append(riskErr.Verdicts, &RiskVerdict{Rule: "atomic_batch", Decision: RiskDeny,
+						Reason: "an atomic batch can't wait for a review"})
+				}
+				err = r.recordRisk(ctx, err)
+			}
+			if !isPaymentError(err) {
+				return nil, err
+			}
+
+			// nothing is applied, including the operations before the failed one
+			for _, res := range results[:i] {
+				res.Status = BatchItemNotApplied
+				res.Transaction = nil
+			}
+			results[i].Status = BatchItemError
+			results[i].Error = err.Error()
+
+			return results, ErrBatchFailed
+		}
+
+		results[i].Status = BatchItemSuccess
+		results[i].Transaction = tr
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
@@ -0,0 +1,303 @@
+package transaction
+
+import (
+	sqlmock "gopkg.in/DATA-DOG/go-sqlmock.v1"
+	"strings"
+	"testing"
+	"time"
+)
+
+func expectDeposit(mock sqlmock.Sqlmock, userID int, money float64, trID int) {
+	mock.
+		ExpectQuery("SELECT balance, status, debit_blocked, credit_blocked, credit_limit FROM users WHERE").
+		WithArgs(userID).
+		WillReturnRows(accountRows(0))
+	mock.
+		ExpectQuery("UPDATE users SET").
+		WithArgs(money, userID).
+		WillReturnRows(sqlmock.NewRows([]string{"balance"}).AddRow(money))
+	mock.
+		ExpectQuery("INSERT INTO transaction").
+		WithArgs(&userID, nil, money, testTime, nil).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created"}).AddRow(trID, testTime))
+}
+
+func TestApplyBatchAtomic(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("cant create mock: %s", err)
+	}
+	defer db.Close()
+	repo := NewRepository(db)
+	repo.Clock = testClock
+
+	items := []*BatchItem{
+		{Operation: OperationDeposit, UserID: 2, Money: 100},
+		{Operation: OperationDeposit, UserID: 3, Money: 200},
+	}
+
+	mock.ExpectBegin()
+	expectDeposit(mock, 2, 100, 1)
+	expectDeposit(mock, 3, 200, 2)
+	mock.ExpectCommit()
+
+	results, err := repo.ApplyBatch(ctx, items, true)
+	if err != nil {
+		t.Errorf("unexpected err: %s", err)
+		return
+	}
+	for i, res := range results {
+		if res.Index != i || res.Status != BatchItemSuccess || res.Transaction.ID != i+1 {
+			t.Errorf("bad result %d: %v", i, res)
+			return
+		}
+	}
+
+	// the withdrawal is refused, the deposit before it is rolled back
+	items = []*BatchItem{
+		{Operation: OperationDeposit, UserID: 2, Money: 100},
+		{Operation: OperationWithdraw, UserID: 1, Money: 50},
+		{Operation: OperationDeposit, UserID: 3, Money: 200},
+	}
+
+	mock.ExpectBegin()
+	expectDeposit(mock, 2, 100, 3)
+	expectNoBonuses(mock)
+	mock.
+		ExpectQuery("SELECT balance, status, debit_blocked, credit_blocked, credit_limit FROM users WHERE").
+		WithArgs(1).
+		WillReturnRows(accountRows(10))
+	mock.ExpectRollback()
+
+	results, err = repo.ApplyBatch(ctx, items, true)
+	if err != ErrBatchFailed {
+		t.Errorf("expected ErrBatchFailed, got %v", err)
+		return
+	}
+	expect := []string{BatchItemNotApplied, BatchItemError, BatchItemNotApplied}
+	for i, res := range results {
+		if res.Status != expect[i] || res.Transaction != nil {
+			t.Errorf("bad result %d: %v", i, res)
+			return
+		}
+	}
+	if results[1].Error != ErrNotEnoughMoney.Error() {
+		t.Errorf("expected %q, got %q", ErrNotEnoughMoney.Error(), results[1].Error)
+		return
+	}
+
+	// invalid operations are found before the database is touched
+	items = []*BatchItem{
+		{Operation: OperationTransfer, UserID: 1, Money: 50},
+		{Operation: "refund", UserID: 1, Money: 50},
+		{Operation: OperationDeposit, UserID: 1, Money: -1},
+		{Operation: OperationDeposit, UserID: 1, Money: 1},
+	}
+
+	results, err = repo.ApplyBatch(ctx, items, true)
+	if err != ErrBatchFailed {
+		t.Errorf("expected ErrBatchFailed, got %v", err)
+		return
+	}
+	errs := []string{ErrBadAccountID.Error(), ErrBadOperation.Error(), ErrNegativeAmount.Error(), ""}
+	for i, res := range results {
+		if res.Error != errs[i] {
+			t.Errorf("bad result %d: %v", i, res)
+			return
+		}
+	}
+
+	// too large
+	_, err = repo.ApplyBatch(ctx, make([]*BatchItem, MaxBatchSize+1), true)
+	if err != ErrBatchTooLarge {
+		t.Errorf("expected ErrBatchTooLarge, got %v", err)
+		return
+	}
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestApplyBatchBestEffort(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("cant create mock: %s", err)
+	}
+	defer db.Close()
+	repo := NewRepository(db)
+	repo.Clock = testClock
+
+	items := []*BatchItem{
+		{Operation: OperationDeposit, UserID: 2, Money: 100},
+		{Operation: OperationWithdraw, UserID: 1, Money: 50, Service: "music"},
+		{Operation: OperationDeposit, UserID: 0, Money: 1},
+		{Operation: OperationDeposit, UserID: 3, Money: 200},
+	}
+
+	mock.ExpectBegin()
+	expectDeposit(mock, 2, 100, 1)
+	mock.ExpectCommit()
+	// the withdrawal spends the bonuses of its service first
+	mock.ExpectBegin()
+	mock.
+		ExpectQuery("SELECT id, remaining FROM bonus_grants WHERE").
+		WithArgs(1, testTime, "music").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "remaining"}))
+	mock.
+		ExpectQuery("SELECT balance, status, debit_blocked, credit_blocked, credit_limit FROM users WHERE").
+		WithArgs(1).
+		WillReturnRows(accountRows(10))
+	mock.ExpectRollback()
+	mock.ExpectBegin()
+	expectDeposit(mock, 3, 200, 2)
+	mock.ExpectCommit()
+
+	results, err := repo.ApplyBatch(ctx, items, false)
+	if err != nil {
+		t.Errorf("unexpected err: %s", err)
+		return
+	}
+
+	expect := []string{BatchItemSuccess, BatchItemError, BatchItemError, BatchItemSuccess}
+	for i, res := range results {
+		if res.Status != expect[i] || (res.Status == BatchItemSuccess) != (res.Transaction != nil) {
+			t.Errorf("bad result %d: %v", i, res)
+			return
+		}
+	}
+	if results[1].Error != ErrNotEnoughMoney.Error() || results[2].Error != ErrBadAccountID.Error() {
+		t.Errorf("bad errors: %q, %q", results[1].Error, results[2].Error)
+		return
+	}
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestApplyBatchApproval(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("cant create mock: %s", err)
+	}
+	defer db.Close()
+	repo := NewRepository(db)
+	repo.Clock = testClock
+	repo.ApprovalThreshold = 1000
+	repo.ApprovalTTL = time.Hour
+
+	items := []*BatchItem{
+		{Operation: OperationDeposit, UserID: 2, Money: 100},
+		{Operation: OperationWithdraw, UserID: 1, Money: 5000},
+	}
+
+	// the large withdrawal is held for approval in the name of the client
+	mock.ExpectBegin()
+	expectDeposit(mock, 2, 100, 1)
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.
+		ExpectQuery("SELECT balance, status, debit_blocked, credit_blocked, credit_limit FROM users WHERE").
+		WithArgs(1).
+		WillReturnRows(accountRows(10000))
+	expectNoBonuses(mock)
+	mock.
+		ExpectQuery("UPDATE users SET held = held").
+		WithArgs(5000.0, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"held"}).AddRow(5000.0))
+	mock.
+		ExpectQuery("INSERT INTO pending_operations").
+		WithArgs(OperationWithdraw, 1, nil, 5000.0, PendingWaiting, "shop", testTime, testTime.Add(time.Hour), "", nil, "", 0.0, 5000.0).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(4))
+	expectPendingHistory(mock, 1, 20)
+	mock.
+		ExpectExec("INSERT INTO pending_events").
+		WithArgs(4, PendingWaiting, "shop", nil, testTime, 20).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	results, err := repo.ApplyBatch(WithRequester(ctx, "shop"), items, false)
+	if err != nil {
+		t.Errorf("unexpected err: %s", err)
+		return
+	}
+	if results[0].Status != BatchItemSuccess || results[1].Status != BatchItemHeld || results[1].PendingID != 4 ||
+		results[1].Transaction != nil {
+		t.Errorf("unexpected results %v %v", results[0], results[1])
+		return
+	}
+
+	// an atomic batch can't wait, it is refused before the database is touched
+	results, err = repo.ApplyBatch(WithRequester(ctx, "shop"), items, true)
+	if err != ErrBatchFailed || results[0].Status != BatchItemNotApplied || results[1].Status != BatchItemError ||
+		results[1].Error != ErrApprovalInBatch.Error() {
+		t.Errorf("unexpected result %v, %v", results, err)
+		return
+	}
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestApplyBatchRisk(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("cant create mock: %s", err)
+	}
+	defer db.Close()
+	repo := NewRepository(db)
+	repo.Clock = testClock
+	repo.RiskRules = []RiskRule{&NewAccountRule{MaxAge: 24 * time.Hour, MaxMoney: 10000, Decision: RiskHold}}
+
+	items := []*BatchItem{{Operation: OperationWithdraw, UserID: 1, Money: 15000}}
+
+	// the debit of a new account is held for review
+	mock.ExpectBegin()
+	expectRiskWithdraw(mock, testTime.Add(-2*time.Hour))
+	mock.ExpectRollback()
+	mock.ExpectBegin()
+	expectNoBonuses(mock)
+	mock.
+		ExpectQuery("UPDATE users SET held = held").
+		WithArgs(15000.0, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"held"}).AddRow(15000.0))
+	mock.
+		ExpectQuery("INSERT INTO risk_reviews").
+		WithArgs(OperationWithdraw, 1, nil, 15000.0, sqlmock.AnyArg(), ReviewPending, testTime, "", nil, "", 0.0, nil, 15000.0).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(3))
+	mock.
+		ExpectExec("INSERT INTO risk_decisions").
+		WithArgs(OperationWithdraw, 1, nil, 15000.0, RiskHold, sqlmock.AnyArg(), nil, 3, testTime).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	results, err := repo.ApplyBatch(ctx, items, false)
+	if err != nil || results[0].Status != BatchItemHeld || results[0].ReviewID != 3 || results[0].Transaction != nil {
+		t.Errorf("unexpected result %v, %v", results, err)
+		return
+	}
+
+	// an atomic batch can't wait for the review, the debit is denied
+	mock.ExpectBegin()
+	expectRiskWithdraw(mock, testTime.Add(-2*time.Hour))
+	mock.ExpectRollback()
+	mock.ExpectBegin()
+	mock.
+		ExpectExec("INSERT INTO risk_decisions").
+		WithArgs(OperationWithdraw, 1, nil, 15000.0, RiskDeny, sqlmock.AnyArg(), nil, nil, testTime).
+		WillReturnResult(sqlmock.NewResult(2, 1))
+	mock.ExpectCommit()
+
+	results, err = repo.ApplyBatch(ctx, items, true)
+	if err != ErrBatchFailed || results[0].Status != BatchItemError ||
+		!strings.Contains(results[0].Error, "an atomic batch can't wait for a review") {
+		t.Errorf("unexpected result %v, %v", results, err)
+		return
+	}
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
@@ -0,0 +1,348 @@
+package transaction
+
+import (
+	"context"
+	"database/sql"
+	"github.com/lib/pq"
+	"math"
+	"time"
+)
+
+// Statuses of a bonus grant.
+const (
+	BonusActive  = "active"
+	BonusSpent   = "spent"
+	BonusExpired = "expired"
+)
+
+// Kinds of the bonus movements.
+const (
+	BonusGranted  = "grant"
+	BonusSpend    = "spend"
+	BonusWriteOff = "expire"
+	BonusRefund   = "refund"
+)
+
+type serviceCtx struct{}
+
+// WithService names the service the withdrawals made with ctx pay for, only
+// the bonuses granted for it or for any service are spent on them.
+func WithService(ctx context.Context, service string) context.Context {
+	return context.WithValue(ctx, serviceCtx{}, service)
+}
+
+// Service is the name given to ctx by WithService.
+func Service(ctx context.Context) string {
+	service, _ := ctx.Value(serviceCtx{}).(string)
+	return service
+}
+
+// GrantBonus gives the account bonus money spendable on req.Services for
+// req.Days days.
+func (r *RepositoryItem) GrantBonus(ctx context.Context, req *BonusRequest) (*BonusGrant, error) {
+	if req.Amount <= 0 || req.Days <= 0 {
+		return nil, ErrBadBonus
+	}
+
+	ctx, cancel := r.operation(ctx)
+	defer cancel()
+
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	db := withContext(ctx, tx)
+
+	acc, err := lockAccount(req.UserID, db)
+	if err == nil {
+		err = acc.canCredit()
+	}
+	if err != nil {
+		//nolint:errcheck
+		tx.Rollback()
+		return nil, err
+	}
+
+	services := req.Services
+	if services == nil {
+		services = []string{}
+	}
+	g := &BonusGrant{
+		UserID:    req.UserID,
+		Amount:    req.Amount,
+		Remaining: req.Amount,
+		Services:  services,
+		Status:    BonusActive,
+		Created:   r.now(),
+	}
+	g.ExpiresAt = g.Created.AddDate(0, 0, req.Days)
+
+	err = db.QueryRow("INSERT INTO bonus_grants (user_id, amount, remaining, services, created, expires_at) "+
+		"VALUES ($1, $2, $3, $4, $5, $6) returning id", g.UserID, g.Amount, g.Remaining, pq.Array(g.Services), g.Created,
+		g.ExpiresAt).Scan(&g.ID)
+	// the grant shows in the history like the expiry, as a row without money
+	var tr *Transaction
+	if err == nil {
+		tr, err = r.writeTransaction(&g.UserID, nil, 0, nil, db)
+	}
+	if err == nil {
+		err = r.writeBonusMovement(g, BonusGranted, g.Amount, &tr.ID, db)
+	}
+	if err != nil {
+		//nolint:errcheck
+		tx.Rollback()
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return g, nil
+}
+
+func (r *RepositoryItem) writeBonusMovement(g *BonusGrant, kind string, amount float64, transactionID *int,
+	db TransactionInterface) error {
+	_, err := db.Exec("INSERT INTO bonus_movements (grant_id, user_id, kind, amount, transaction_id, created) "+
+		"VALUES ($1, $2, $3, $4, $5, $6)", g.ID, g.UserID, kind, amount, transactionID, r.now())
+	return err
+}
+
+// withdrawBonusFirst pays the withdrawal with the bonuses usable for the
+// service, the ones expiring first first, and the rest with real money. The
+// operation in the history holds the real money, Bonus the rest.
+func (r *RepositoryItem) withdrawBonusFirst(userID int, money float64, service string, cur opCurrency,
+	db TransactionInterface) (*Transaction, error) {
+	if money <= 0 {
+		return r.withdraw(userID, money, 0, cur, db)
+	}
+
+	grants, err := r.usableBonuses(userID, service, db)
+	if err != nil {
+		return nil, err
+	}
+	spent, bonus := bonusShare(grants, money)
+
+	tr, err := r.withdraw(userID, math.Round((money-bonus)*100)/100, bonus, cur, db)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, g := range grants {
+		if spent[i] <= 0 {
+			continue
+		}
+		_, err = db.Exec("UPDATE bonus_grants SET remaining = remaining - $1 WHERE id = $2", spent[i], g.ID)
+		if err != nil {
+			return nil, err
+		}
+		err = r.writeBonusMovement(g, BonusSpend, -spent[i], &tr.ID, db)
+		if err != nil {
+			return nil, err
+		}
+	}
+	tr.Bonus = bonus
+
+	return tr, nil
+}
+
+// usableBonuses locks the unspent bonuses of the account the service can
+// spend, the ones expiring first first.
+func (r *RepositoryItem) usableBonuses(userID int, service string, db TransactionInterface) ([]*BonusGrant, error) {
+	rows, err := db.Query("SELECT id, remaining FROM bonus_grants WHERE user_id = $1 AND remaining > 0 AND expires_at > $2 "+
+		"AND (cardinality(services) = 0 OR $3 = ANY(services)) ORDER BY expires_at, id FOR UPDATE", userID, r.now(), service)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	grants := make([]*BonusGrant, 0)
+	for rows.Next() {
+		g := &BonusGrant{UserID: userID}
+		err = rows.Scan(&g.ID, &g.Remaining)
+		if err != nil {
+			return nil, err
+		}
+		grants = append(grants, g)
+	}
+
+	return grants, rows.Err()
+}
+
+// bonusShare is what each of the grants pays of money and their sum, in whole
+// kopecks, so the real part is not a float remainder.
+func bonusShare(grants []*BonusGrant, money float64) ([]float64, float64) {
+	bonus := 0.0
+	spent := make([]float64, len(grants))
+	for i, g := range grants {
+		spent[i] = math.Min(g.Remaining, math.Round((money-bonus)*100)/100)
+		bonus += spent[i]
+		if bonus >= money {
+			break
+		}
+	}
+	return spent, bonus
+}
+
+// realShare is the part of the debit paid with real money, the one to hold
+// while it waits: a withdrawal spends the bonuses of its service first.
+func (r *RepositoryItem) realShare(operation string, userID int, money float64, service string,
+	db TransactionInterface) (float64, error) {
+	if operation != OperationWithdraw || money <= 0 {
+		return money, nil
+	}
+
+	grants, err := r.usableBonuses(userID, service, db)
+	if err != nil {
+		return 0, err
+	}
+	_, bonus := bonusShare(grants, money)
+
+	return math.Round((money-bonus)*100) / 100, nil
+}
+
+// activeBonuses returns the unspent bonuses of the account, the ones expiring
+// first first.
+func (r *RepositoryItem) activeBonuses(ctx context.Context, userID int, db *sql.DB) ([]*BonusGrant, error) {
+	rows, err := db.QueryContext(ctx, "SELECT "+bonusColumns+" FROM bonus_grants WHERE user_id = $1 AND remaining > 0 "+
+		"AND expires_at > $2 ORDER BY expires_at, id", userID, r.now())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanBonuses(rows, r.now())
+}
+
+// bonusSpent returns the bonus money of the operations of the account by
+// operation and kind of movement: how much paid for them, how much they wrote
+// off, granted or refunded, all of it positive.
+func bonusSpent(ctx context.Context, userID int, db *sql.DB) (map[string]map[int]float64, error) {
+	rows, err := db.QueryContext(ctx, "SELECT transaction_id, kind, ABS(SUM(amount)) FROM bonus_movements WHERE user_id = $1 "+
+		"AND transaction_id IS NOT NULL GROUP BY transaction_id, kind", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	bonus := map[string]map[int]float64{BonusSpend: {}, BonusWriteOff: {}, BonusGranted: {}, BonusRefund: {}}
+	for rows.Next() {
+		var id int
+		var kind string
+		var amount float64
+		err = rows.Scan(&id, &kind, &amount)
+		if err != nil {
+			return nil, err
+		}
+		if bonus[kind] != nil {
+			bonus[kind][id] = amount
+		}
+	}
+
+	return bonus, rows.Err()
+}
+
+var bonusColumns = "id, user_id, amount, remaining, services, created, expires_at"
+
+func scanBonuses(rows *sql.Rows, now time.Time) ([]*BonusGrant, error) {
+	grants := make([]*BonusGrant, 0)
+	for rows.Next() {
+		g := &BonusGrant{}
+		err := rows.Scan(&g.ID, &g.UserID, &g.Amount, &g.Remaining, pq.Array(&g.Services), &g.Created, &g.ExpiresAt)
+		if err != nil {
+			return nil, err
+		}
+		g.Created = g.Created.UTC()
+		g.ExpiresAt = g.ExpiresAt.UTC()
+		if g.Services == nil {
+			g.Services = []string{}
+		}
+		switch {
+		case g.Remaining <= 0 && !now.Before(g.ExpiresAt):
+			g.Status = BonusExpired
+		case g.Remaining <= 0:
+			g.Status = BonusSpent
+		case !now.Before(g.ExpiresAt):
+			g.Status = BonusExpired
+		default:
+			g.Status = BonusActive
+		}
+		grants = append(grants, g)
+	}
+
+	return grants, rows.Err()
+}
+
+// GetBonuses returns all the bonus grants of the account, the last first.
+func (r *RepositoryItem) GetBonuses(ctx context.Context, userID int) ([]*BonusGrant, error) {
+	ctx, cancel := r.operation(ctx)
+	defer cancel()
+
+	rows, err := r.DB.QueryContext(ctx, "SELECT "+bonusColumns+" FROM bonus_grants WHERE user_id = $1 ORDER BY id DESC", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanBonuses(rows, r.now())
+}
+
+// ExpireBonuses writes off what is left of the expired bonuses, it returns the
+// grants written off. Every write-off is an operation of the history without
+// real money. Any number of instances may run it at once.
+func (r *RepositoryItem) ExpireBonuses(ctx context.Context) ([]*BonusGrant, error) {
+	ctx, cancel := r.operation(ctx)
+	defer cancel()
+
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	db := withContext(ctx, tx)
+
+	expired, err := r.expireBonuses(db)
+	if err != nil {
+		//nolint:errcheck
+		tx.Rollback()
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return expired, nil
+}
+
+func (r *RepositoryItem) expireBonuses(db TransactionInterface) ([]*BonusGrant, error) {
+	rows, err := db.Query("SELECT "+bonusColumns+" FROM bonus_grants WHERE remaining > 0 AND expires_at <= $1 "+
+		"ORDER BY id FOR UPDATE SKIP LOCKED", r.now())
+	if err != nil {
+		return nil, err
+	}
+	expired, err := scanBonuses(rows, r.now())
+	//nolint:errcheck
+	rows.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, g := range expired {
+		_, err = db.Exec("UPDATE bonus_grants SET remaining = 0 WHERE id = $1", g.ID)
+		if err != nil {
+			return nil, err
+		}
+		tr, err := r.writeTransaction(nil, &g.UserID, 0, nil, db)
+		if err != nil {
+			return nil, err
+		}
+		err = r.writeBonusMovement(g, BonusWriteOff, -g.Remaining, &tr.ID, db)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return expired, nil
+}
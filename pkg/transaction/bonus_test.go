@@ -0,0 +1,349 @@
+package transaction
+
+import (
+	"context"
+	"github.com/lib/pq"
+	sqlmock "gopkg.in/DATA-DOG/go-sqlmock.v1"
+	"reflect"
+	"testing"
+	"time"
+)
+
+var bonusColumnNames = []string{"id", "user_id", "amount", "remaining", "services", "created", "expires_at"}
+
+func TestGrantBonus(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("cant create mock: %s", err)
+	}
+	defer db.Close()
+
+	repo := NewRepository(db)
+	repo.Clock = testClock
+
+	_, err = repo.GrantBonus(ctx, &BonusRequest{UserID: 1, Amount: 100, Days: 0})
+	if err != ErrBadBonus {
+		t.Errorf("expected ErrBadBonus, got %v", err)
+		return
+	}
+
+	expires := testTime.AddDate(0, 0, 30)
+	mock.ExpectBegin()
+	mock.
+		ExpectQuery("SELECT balance, status, debit_blocked, credit_blocked, credit_limit FROM users WHERE").
+		WithArgs(1).
+		WillReturnRows(accountRows(0))
+	mock.
+		ExpectQuery("INSERT INTO bonus_grants \\(user_id, amount, remaining, services, created, expires_at\\)").
+		WithArgs(1, 100.0, 100.0, pq.Array([]string{"music"}), testTime, expires).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(5))
+	elemID := 1
+	mock.
+		ExpectQuery("INSERT INTO transaction").
+		WithArgs(&elemID, nil, 0.0, testTime, nil).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created"}).AddRow(12, testTime))
+	mock.
+		ExpectExec("INSERT INTO bonus_movements \\(grant_id, user_id, kind, amount, transaction_id, created\\)").
+		WithArgs(5, 1, BonusGranted, 100.0, 12, testTime).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	g, err := repo.GrantBonus(ctx, &BonusRequest{UserID: 1, Amount: 100, Services: []string{"music"}, Days: 30})
+	if err != nil {
+		t.Errorf("unexpected err: %s", err)
+		return
+	}
+	expect := &BonusGrant{ID: 5, UserID: 1, Amount: 100, Remaining: 100, Services: []string{"music"}, Status: BonusActive,
+		Created: testTime, ExpiresAt: expires}
+	if !reflect.DeepEqual(g, expect) {
+		t.Errorf("results not match, want %v, have %v", expect, g)
+		return
+	}
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestWithdrawMoneyBonusFirst(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("cant create mock: %s", err)
+	}
+	defer db.Close()
+
+	repo := NewRepository(db)
+	repo.Clock = testClock
+
+	// the bonus expiring first is spent first, the rest is real money
+	elemID := 1
+	mock.ExpectBegin()
+	mock.
+		ExpectQuery("SELECT id, remaining FROM bonus_grants WHERE user_id = \\$1 AND remaining > 0 AND expires_at > \\$2 "+
+			"AND \\(cardinality\\(services\\) = 0 OR \\$3 = ANY\\(services\\)\\) ORDER BY expires_at, id FOR UPDATE").
+		WithArgs(1, testTime, "music").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "remaining"}).AddRow(5, 30.0).AddRow(6, 20.0))
+	mock.
+		ExpectQuery("SELECT balance, status, debit_blocked, credit_blocked, credit_limit FROM users WHERE").
+		WithArgs(1).
+		WillReturnRows(accountRows(100))
+	mock.
+		ExpectQuery("UPDATE users SET balance = balance -").
+		WithArgs(20.0, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"balance"}).AddRow(80.0))
+	expectNoLimits(mock)
+	mock.
+		ExpectQuery("INSERT INTO transaction").
+		WithArgs(nil, &elemID, -20.0, testTime, nil).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created"}).AddRow(9, testTime))
+	expectNoFee(mock, OperationWithdraw)
+	mock.
+		ExpectExec("UPDATE bonus_grants SET remaining = remaining - \\$1 WHERE id = \\$2").
+		WithArgs(30.0, 5).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.
+		ExpectExec("INSERT INTO bonus_movements").
+		WithArgs(5, 1, BonusSpend, -30.0, 9, testTime).
+		WillReturnResult(sqlmock.NewResult(2, 1))
+	mock.
+		ExpectExec("UPDATE bonus_grants SET remaining = remaining - \\$1 WHERE id = \\$2").
+		WithArgs(20.0, 6).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.
+		ExpectExec("INSERT INTO bonus_movements").
+		WithArgs(6, 1, BonusSpend, -20.0, 9, testTime).
+		WillReturnResult(sqlmock.NewResult(3, 1))
+	mock.ExpectCommit()
+
+	tr, err := repo.WithdrawMoney(WithService(ctx, "music"), 1, 70)
+	if err != nil {
+		t.Errorf("unexpected err: %s", err)
+		return
+	}
+	if tr.ID != 9 || tr.Money != -20 || tr.Bonus != 50 || *tr.Balance != 80 {
+		t.Errorf("unexpected transaction %v", tr)
+		return
+	}
+
+	// the bonuses are rolled back with a refused withdrawal
+	mock.ExpectBegin()
+	mock.
+		ExpectQuery("SELECT id, remaining FROM bonus_grants WHERE").
+		WithArgs(1, testTime, "").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "remaining"}).AddRow(6, 80.0))
+	mock.
+		ExpectQuery("SELECT balance, status, debit_blocked, credit_blocked, credit_limit FROM users WHERE").
+		WithArgs(1).
+		WillReturnRows(accountRows(10))
+	mock.ExpectRollback()
+
+	_, err = repo.WithdrawMoney(ctx, 1, 100)
+	if err != ErrNotEnoughMoney {
+		t.Errorf("expected ErrNotEnoughMoney, got %v", err)
+		return
+	}
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestWithdrawMoneyBonusFee(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("cant create mock: %s", err)
+	}
+	defer db.Close()
+
+	repo := NewRepository(db)
+	repo.Clock = testClock
+
+	// the fee is on the whole withdrawal, even one paid with bonuses only
+	elemID, revenueID, mainID := 1, RevenueAccountID, 9
+	mock.ExpectBegin()
+	mock.
+		ExpectQuery("SELECT id, remaining FROM bonus_grants WHERE").
+		WithArgs(1, testTime, "").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "remaining"}).AddRow(5, 80.0))
+	mock.
+		ExpectQuery("SELECT balance, status, debit_blocked, credit_blocked, credit_limit FROM users WHERE").
+		WithArgs(1).
+		WillReturnRows(accountRows(10))
+	mock.
+		ExpectQuery("UPDATE users SET balance = balance -").
+		WithArgs(0.0, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"balance"}).AddRow(10.0))
+	expectNoLimits(mock)
+	mock.
+		ExpectQuery("INSERT INTO transaction").
+		WithArgs(nil, &elemID, 0.0, testTime, nil).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created"}).AddRow(mainID, testTime))
+	mock.
+		ExpectQuery("SELECT id, operation, currency, user_id, fixed, percent, min_fee, max_fee FROM fee_rules WHERE").
+		WithArgs(OperationWithdraw, BaseCurrency, 1).
+		WillReturnRows(sqlmock.NewRows(feeRuleColumnNames).
+			AddRow(1, OperationWithdraw, BaseCurrency, nil, 0.0, 2.0, nil, nil))
+	mock.
+		ExpectQuery("SELECT balance, status, debit_blocked, credit_blocked, credit_limit FROM users WHERE").
+		WithArgs(1).
+		WillReturnRows(accountRows(10))
+	mock.
+		ExpectQuery("UPDATE users SET balance = balance -").
+		WithArgs(1.0, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"balance"}).AddRow(9.0))
+	mock.
+		ExpectQuery("SELECT balance, status, debit_blocked, credit_blocked, credit_limit FROM users WHERE").
+		WithArgs(revenueID).
+		WillReturnRows(accountRows(0))
+	mock.
+		ExpectQuery("UPDATE users SET balance = balance \\+").
+		WithArgs(1.0, revenueID).
+		WillReturnRows(sqlmock.NewRows([]string{"balance"}).AddRow(1.0))
+	mock.
+		ExpectQuery("INSERT INTO transaction \\(to_id, from_id, money, created, fee_of\\)").
+		WithArgs(&revenueID, &elemID, 1.0, testTime, &mainID).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created"}).AddRow(mainID+1, testTime))
+	mock.
+		ExpectExec("UPDATE bonus_grants SET remaining = remaining - \\$1 WHERE id = \\$2").
+		WithArgs(50.0, 5).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.
+		ExpectExec("INSERT INTO bonus_movements").
+		WithArgs(5, 1, BonusSpend, -50.0, mainID, testTime).
+		WillReturnResult(sqlmock.NewResult(2, 1))
+	mock.ExpectCommit()
+
+	tr, err := repo.WithdrawMoney(ctx, 1, 50)
+	if err != nil {
+		t.Errorf("unexpected err: %s", err)
+		return
+	}
+	if tr.Money != 0 || tr.Bonus != 50 || tr.Fee == nil || tr.Fee.Money != 1 || *tr.Balance != 9 {
+		t.Errorf("unexpected transaction %v, fee %v", tr, tr.Fee)
+		return
+	}
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestBonusBreakdown(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("cant create mock: %s", err)
+	}
+	defer db.Close()
+
+	repo := NewRepository(db)
+	repo.Clock = testClock
+
+	expires := testTime.Add(time.Hour)
+	mock.
+		ExpectQuery("SELECT balance, credit_limit - held FROM users WHERE").
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"balance", "credit_limit"}).AddRow(80.0, 0.0))
+	mock.
+		ExpectQuery("SELECT id, user_id, amount, remaining, services, created, expires_at FROM bonus_grants "+
+			"WHERE user_id = \\$1 AND remaining > 0 AND expires_at > \\$2 ORDER BY expires_at, id").
+		WithArgs(1, testTime).
+		WillReturnRows(sqlmock.NewRows(bonusColumnNames).
+			AddRow(6, 1, 100.0, 30.0, "{music,video}", testTime, expires).
+			AddRow(7, 1, 10.0, 10.0, "{}", testTime, expires))
+
+	user, err := repo.GetUsersBalance(ctx, 1, "")
+	if err != nil {
+		t.Errorf("unexpected err: %s", err)
+		return
+	}
+	available, bonus := 80.0, 40.0
+	expect := &User{
+		UserID:    1,
+		Balance:   80,
+		Available: &available,
+		Bonus:     &bonus,
+		Bonuses: []*BonusGrant{
+			{ID: 6, UserID: 1, Amount: 100, Remaining: 30, Services: []string{"music", "video"}, Status: BonusActive,
+				Created: testTime, ExpiresAt: expires},
+			{ID: 7, UserID: 1, Amount: 10, Remaining: 10, Services: []string{}, Status: BonusActive,
+				Created: testTime, ExpiresAt: expires},
+		},
+	}
+	if !reflect.DeepEqual(user, expect) {
+		t.Errorf("results not match, want %v, have %v", expect, user)
+		return
+	}
+
+	// the history shows the bonus part of the withdrawals
+	mock.
+		ExpectQuery("SELECT id, to_id, from_id, money, created, refund_of, fee_of, split_of FROM transaction where").
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "to_id", "from_id", "money", "created", "refund_of", "fee_of", "split_of"}).
+			AddRow(8, 1, nil, 100.0, testTime, nil, nil, nil).
+			AddRow(9, nil, 1, -20.0, testTime, nil, nil, nil).
+			AddRow(10, nil, 1, 0.0, testTime, nil, nil, nil).
+			AddRow(11, 1, nil, 0.0, testTime, nil, nil, nil))
+	mock.
+		ExpectQuery("SELECT transaction_id, kind, ABS\\(SUM\\(amount\\)\\) FROM bonus_movements WHERE user_id = \\$1 " +
+			"AND transaction_id IS NOT NULL GROUP BY transaction_id, kind").
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"transaction_id", "kind", "bonus"}).
+			AddRow(9, BonusSpend, 50.0).
+			AddRow(10, BonusWriteOff, 30.0).
+			AddRow(11, BonusGranted, 100.0))
+	expectNoPendingHistory(mock, 1)
+
+	// and the write-offs of the expired bonuses and the grants
+	history, err := repo.GetTransaction(ctx, 1, "")
+	if err != nil || len(history) != 4 || history[0].Bonus != 0 || history[1].Bonus != 50 || history[1].BonusExpired != 0 ||
+		history[2].BonusExpired != 30 || history[3].BonusGranted != 100 {
+		t.Errorf("unexpected history %v, %v", history, err)
+		return
+	}
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestExpireBonuses(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("cant create mock: %s", err)
+	}
+	defer db.Close()
+
+	repo := NewRepository(db)
+	repo.Clock = testClock
+
+	mock.ExpectBegin()
+	mock.
+		ExpectQuery("FROM bonus_grants WHERE remaining > 0 AND expires_at <= \\$1 ORDER BY id FOR UPDATE SKIP LOCKED").
+		WithArgs(testTime).
+		WillReturnRows(sqlmock.NewRows(bonusColumnNames).
+			AddRow(5, 1, 100.0, 30.0, "{}", testTime.AddDate(0, 0, -30), testTime.Add(-time.Hour)))
+	mock.
+		ExpectExec("UPDATE bonus_grants SET remaining = 0 WHERE id = \\$1").
+		WithArgs(5).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.
+		ExpectQuery("INSERT INTO transaction").
+		WithArgs(nil, 1, 0.0, testTime, nil).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created"}).AddRow(12, testTime))
+	mock.
+		ExpectExec("INSERT INTO bonus_movements").
+		WithArgs(5, 1, BonusWriteOff, -30.0, 12, testTime).
+		WillReturnResult(sqlmock.NewResult(4, 1))
+	mock.ExpectCommit()
+
+	expired, err := repo.ExpireBonuses(context.Background())
+	if err != nil || len(expired) != 1 || expired[0].ID != 5 || expired[0].Status != BonusExpired ||
+		expired[0].Remaining != 30 {
+		t.Errorf("unexpected result %v, %v", expired, err)
+		return
+	}
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
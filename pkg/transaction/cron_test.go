@@ -0,0 +1,50 @@
+package transaction
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCronNext(t *testing.T) {
+	// Saturday
+	from := time.Date(2021, 11, 27, 15, 4, 5, 0, time.UTC)
+
+	cases := []struct {
+		expr string
+		next time.Time
+	}{
+		{"* * * * *", time.Date(2021, 11, 27, 15, 5, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2021, 11, 27, 15, 15, 0, 0, time.UTC)},
+		{"0 9 * * *", time.Date(2021, 11, 28, 9, 0, 0, 0, time.UTC)},
+		{"30 10 1 * *", time.Date(2021, 12, 1, 10, 30, 0, 0, time.UTC)},
+		{"0 0 * * 1-5", time.Date(2021, 11, 29, 0, 0, 0, 0, time.UTC)},
+		{"0 12 1,15 2 *", time.Date(2022, 2, 1, 12, 0, 0, 0, time.UTC)},
+		// either day field matches when both are restricted
+		{"0 0 1 * 0", time.Date(2021, 11, 28, 0, 0, 0, 0, time.UTC)},
+		{"5/20 16 * * *", time.Date(2021, 11, 27, 16, 5, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"0 0 30 2 *", time.Time{}},
+	}
+
+	for _, c := range cases {
+		cron, err := parseCron(c.expr)
+		if err != nil {
+			t.Errorf("unexpected err for %q: %s", c.expr, err)
+			continue
+		}
+		next := cron.next(from)
+		if !next.Equal(c.next) {
+			t.Errorf("bad next run for %q: want %v, have %v", c.expr, c.next, next)
+		}
+	}
+}
+
+func TestCronErrors(t *testing.T) {
+	for _, expr := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "* * * 13 *",
+		"* * * * 7", "5-1 * * * *", "*/0 * * * *", "a * * * *", "1-b * * * *"} {
+		_, err := parseCron(expr)
+		if err != ErrBadSchedule {
+			t.Errorf("expected ErrBadSchedule for %q, got %v", expr, err)
+		}
+	}
+}
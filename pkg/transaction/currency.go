@@ -1,10 +1,10 @@
 package transaction
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
-	"log"
 	"net/http"
 	"net/url"
 )
@@ -15,14 +15,28 @@ type Currency struct {
 	Rates   map[string]float64 `json:"quotes"`
 }
 
-func getCurrencyFromRub(needCurrency string) (float64, error) {
+// CurrencyLayerRate takes today's rates from currencylayer.com with the access key.
+func CurrencyLayerRate(accessKey string) RateFunc {
+	return func(ctx context.Context, currency string) (float64, error) {
+		return getCurrencyFromRub(ctx, accessKey, currency)
+	}
+}
+
+func getCurrencyFromRub(ctx context.Context, accessKey, needCurrency string) (float64, error) {
 	searcherParams := url.Values{}
-	searcherParams.Add("access_key", "b68d56ac99f42a02e979d0d708e2c3a5")
+	searcherParams.Add("access_key", accessKey)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet,
+		"http://api.currencylayer.com/live"+"?"+searcherParams.Encode(), nil)
+	if err != nil {
+		return 0, err
+	}
 
-	resp, err := http.Get("http://api.currencylayer.com/live" + "?" + searcherParams.Encode())
+	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
 		return 0, err
 	}
+	defer resp.Body.Close()
 
 	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
@@ -41,7 +55,6 @@ func getCurrencyFromRub(needCurrency string) (float64, error) {
 	}
 
 	base := curr.Base
-	log.Println()
 	need, ok := curr.Rates[base+needCurrency]
 	if !ok {
 		return 0, fmt.Errorf("no currency")
@@ -1,11 +1,41 @@
 package transaction
 
 import (
-	"fmt"
+	"context"
+	"errors"
 	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+	"reflect"
 	"testing"
+	"time"
 )
 
+var (
+	today   = time.Date(2021, 11, 27, 0, 0, 0, 0, time.UTC)
+	october = time.Date(2021, 10, 29, 0, 0, 0, 0, time.UTC)
+)
+
+func expectRate(mock sqlmock.Sqlmock, currency string, day, stored time.Time, rate float64) {
+	mock.
+		ExpectQuery("SELECT day, rate FROM currency_rates WHERE currency = \\$1 AND day <= \\$2 ORDER BY day DESC LIMIT 1").
+		WithArgs(currency, day).
+		WillReturnRows(sqlmock.NewRows([]string{"day", "rate"}).AddRow(stored, rate))
+}
+
+func expectNoRate(mock sqlmock.Sqlmock, currency string, day time.Time) {
+	mock.
+		ExpectQuery("SELECT day, rate FROM currency_rates WHERE").
+		WithArgs(currency, day).
+		WillReturnRows(sqlmock.NewRows([]string{"day", "rate"}))
+}
+
+func expectUserBalance(mock sqlmock.Sqlmock, balance float64) {
+	mock.
+		ExpectQuery("SELECT balance, credit_limit - held FROM users WHERE").
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"balance", "credit_limit"}).AddRow(balance, 0.0))
+	expectNoBonusBalance(mock, 1)
+}
+
 func TestCurrency(t *testing.T) {
 	db, mock, err := sqlmock.New()
 	if err != nil {
@@ -13,44 +43,39 @@ func TestCurrency(t *testing.T) {
 	}
 	defer db.Close()
 
-	// good query
-	elemID := 1
-	rows := sqlmock.NewRows([]string{"balance"})
-	expect := []*User{&User{
-		UserID:  elemID,
-		Balance: 60,
-	}}
-
-	for _, item := range expect {
-		rows = rows.AddRow(item.Balance)
-	}
-
-	mock.
-		ExpectQuery("SELECT balance FROM users WHERE").
-		WithArgs(elemID).
-		WillReturnRows(rows)
-
 	repo := NewRepository(db)
+	repo.Clock = testClock
+	repo.LiveRate = nil
+
+	// today's stored rate
+	expectUserBalance(mock, 60)
+	expectRate(mock, "GBP", today, today, 100)
 
-	tr, err := repo.GetUsersBalance(elemID, "GBP")
+	tr, err := repo.GetUsersBalance(ctx, 1, "GBP")
 	if err != nil {
 		t.Errorf("unexpected err: %s", err)
 		return
 	}
+	available := 0.6
+	expect := &User{UserID: 1, Balance: 0.6, Available: &available}
+	if !reflect.DeepEqual(tr, expect) {
+		t.Errorf("results not match, want %v, have %v", expect, tr)
+		return
+	}
 
-	fmt.Println(tr.Balance)
+	// the last rate known on the date asked for
+	expectUserBalance(mock, 60)
+	expectRate(mock, "GBP", october.AddDate(0, 0, 3), october, 120)
 
-	if err = mock.ExpectationsWereMet(); err != nil {
-		t.Errorf("there were unfulfilled expectations: %s", err)
+	tr, err = repo.GetUsersBalance(WithRateDate(ctx, october.Add(75*time.Hour)), 1, "GBP")
+	if err != nil || tr.Balance != 0.5 {
+		t.Errorf("unexpected result %v, %v", tr, err)
 		return
 	}
 
-	/*	if !reflect.DeepEqual(tr, expect[0]) {
-			t.Errorf("results not match, want %v, have %v", expect[0], tr)
-			return
-		}
-	*/
-
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
 }
 
 func TestCurrencyErrors(t *testing.T) {
@@ -60,29 +85,61 @@ func TestCurrencyErrors(t *testing.T) {
 	}
 	defer db.Close()
 
-	// good query
-	elemID := 1
-	rows := sqlmock.NewRows([]string{"balance"})
-	expect := []*User{&User{
-		UserID:  elemID,
-		Balance: 67.4,
-	}}
+	repo := NewRepository(db)
+	repo.Clock = testClock
+	repo.LiveRate = nil
+
+	// no rate stored
+	expectUserBalance(mock, 60)
+	expectNoRate(mock, "GBP", today)
 
-	for _, item := range expect {
-		rows = rows.AddRow(item.Balance)
+	_, err = repo.GetUsersBalance(ctx, 1, "GBP")
+	if err != ErrRateNotFound {
+		t.Errorf("expected ErrRateNotFound, got %v", err)
+		return
+	}
+
+	// the last rate is too old for the date
+	expectUserBalance(mock, 60)
+	expectRate(mock, "GBP", october.AddDate(0, 0, 20), october, 120)
+
+	_, err = repo.GetUsersBalance(WithRateDate(ctx, october.AddDate(0, 0, 20)), 1, "GBP")
+	if err != ErrRateNotFound {
+		t.Errorf("expected ErrRateNotFound, got %v", err)
+		return
 	}
 
+	// today's live rate is stored for the next conversions
+	repo.LiveRate = func(ctx context.Context, currency string) (float64, error) {
+		if currency != "USD" {
+			return 0, errors.New("no currency")
+		}
+		return 80, nil
+	}
+	expectUserBalance(mock, 60)
+	expectRate(mock, "USD", today, today.AddDate(0, 0, -1), 75)
 	mock.
-		ExpectQuery("SELECT balance FROM users WHERE").
-		WithArgs(elemID).
-		WillReturnRows(rows)
+		ExpectExec("INSERT INTO currency_rates \\(day, currency, rate\\) VALUES \\(\\$1, \\$2, \\$3\\) ON CONFLICT \\(currency, day\\) DO NOTHING").
+		WithArgs(today, "USD", 80.0).
+		WillReturnResult(sqlmock.NewResult(1, 1))
 
-	repo := NewRepository(db)
-	s, err := repo.GetUsersBalance(elemID, "mock11111111")
-	fmt.Println(s)
+	tr, err := repo.GetUsersBalance(ctx, 1, "USD")
+	if err != nil || tr.Balance != 0.75 {
+		t.Errorf("unexpected result %v, %v", tr, err)
+		return
+	}
+
+	// an unknown currency
+	expectUserBalance(mock, 60)
+	expectNoRate(mock, "mock11111111", today)
+
+	_, err = repo.GetUsersBalance(ctx, 1, "mock11111111")
 	if err == nil {
 		t.Errorf("expected error, got nil")
 		return
 	}
 
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
 }
@@ -0,0 +1,150 @@
+package transaction
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrNegativeAmount      = errors.New("negative amount")
+	ErrNotEnoughMoney      = errors.New("not enough money")
+	ErrTransactionNotFound = errors.New("no such transaction")
+	ErrNotRefundable       = errors.New("transaction can`t be refunded")
+	ErrRefundExceeded      = errors.New("refund exceeds charged amount")
+	ErrBadAccountID        = errors.New("bad account id")
+	ErrAccountNotFound     = errors.New("no such account")
+	ErrAccountExists       = errors.New("account already exists")
+	ErrAccountFrozen       = errors.New("account is frozen")
+	ErrAccountClosed       = errors.New("account is closed")
+	ErrAccountNotEmpty     = errors.New("account balance is not zero")
+	ErrLimitExceeded       = errors.New("limit exceeded")
+	ErrBadOperation        = errors.New("unknown operation")
+	ErrFeeRuleNotFound     = errors.New("no such fee rule")
+	ErrBadSchedule         = errors.New("bad schedule")
+	ErrScheduleNotFound    = errors.New("no such schedule")
+	ErrScheduleFinished    = errors.New("schedule is finished")
+	ErrBatchTooLarge       = errors.New("batch is too large")
+	ErrBodyTooLarge        = errors.New("request body is too large")
+	ErrBatchFailed         = errors.New("batch is not applied")
+	ErrBadSplit            = errors.New("bad split payment")
+	ErrBadReportTime       = errors.New("report time is in the future")
+	ErrReportNotFound      = errors.New("no such report")
+	ErrReconcileRunning    = errors.New("reconciliation is already running")
+	ErrNoReconciliation    = errors.New("no reconciliation has run yet")
+	ErrBadAdjustment       = errors.New("adjustment needs money, a reason and an author")
+	ErrIdempotencyMismatch = errors.New("idempotency key is used by another request")
+	ErrRequestInProgress   = errors.New("request with this idempotency key is in progress")
+	ErrHistoryArchived     = errors.New("history of this period is archived")
+	ErrArchiveDisabled     = errors.New("history archive directory is not set")
+	ErrRateLimited         = errors.New("too many requests")
+	ErrRiskDenied          = errors.New("operation is denied by the risk checks")
+	ErrRiskReview          = errors.New("operation is held for review")
+	ErrReviewNotFound      = errors.New("no such review")
+	ErrReviewDecided       = errors.New("review is already decided")
+	ErrBadReview           = errors.New("review decision needs a reviewer")
+	ErrApprovalRequired    = errors.New("operation is waiting for approval")
+	ErrPendingNotFound     = errors.New("no such pending operation")
+	ErrPendingDecided      = errors.New("pending operation is already decided")
+	ErrPendingExpired      = errors.New("pending operation is expired")
+	ErrBadApproval         = errors.New("approval needs an admin")
+	ErrSameApprover        = errors.New("operation can't be approved by its requester")
+	ErrApprovalInBatch     = errors.New("operation needs approval, an atomic batch can't wait for it")
+	ErrBadBonus            = errors.New("bonus needs a positive amount and days")
+	ErrBadRates            = errors.New("rates need a date, a currency code and a positive rate")
+	ErrRateNotFound        = errors.New("no rate of the currency for the date")
+	ErrUnauthorized        = errors.New("request needs a valid api key")
+	ErrForbidden           = errors.New("api key is not allowed to do this")
+	ErrBadFeeRule          = errors.New("min_fee of a fee rule is above its max_fee")
+)
+
+// errorCodes are the stable codes of the errors sent to clients, the messages
+// may change.
+var errorCodes = []struct {
+	err  error
+	code string
+}{
+	{ErrNegativeAmount, "negative_amount"},
+	{ErrNotEnoughMoney, "not_enough_money"},
+	{ErrTransactionNotFound, "transaction_not_found"},
+	{ErrNotRefundable, "not_refundable"},
+	{ErrRefundExceeded, "refund_exceeded"},
+	{ErrBadAccountID, "bad_account_id"},
+	{ErrAccountNotFound, "account_not_found"},
+	{ErrAccountExists, "account_exists"},
+	{ErrAccountFrozen, "account_frozen"},
+	{ErrAccountClosed, "account_closed"},
+	{ErrAccountNotEmpty, "account_not_empty"},
+	{ErrLimitExceeded, "limit_exceeded"},
+	{ErrBadOperation, "bad_operation"},
+	{ErrFeeRuleNotFound, "fee_rule_not_found"},
+	{ErrBadSchedule, "bad_schedule"},
+	{ErrScheduleNotFound, "schedule_not_found"},
+	{ErrScheduleFinished, "schedule_finished"},
+	{ErrBatchTooLarge, "batch_too_large"},
+	{ErrBodyTooLarge, "body_too_large"},
+	{ErrBatchFailed, "batch_failed"},
+	{ErrBadSplit, "bad_split"},
+	{ErrBadReportTime, "bad_report_time"},
+	{ErrReportNotFound, "report_not_found"},
+	{ErrReconcileRunning, "reconcile_running"},
+	{ErrNoReconciliation, "no_reconciliation"},
+	{ErrBadAdjustment, "bad_adjustment"},
+	{ErrIdempotencyMismatch, "idempotency_mismatch"},
+	{ErrRequestInProgress, "request_in_progress"},
+	{ErrHistoryArchived, "history_archived"},
+	{ErrArchiveDisabled, "archive_disabled"},
+	{ErrRateLimited, "rate_limited"},
+	{ErrRiskDenied, "risk_denied"},
+	{ErrRiskReview, "risk_review"},
+	{ErrReviewNotFound, "review_not_found"},
+	{ErrReviewDecided, "review_decided"},
+	{ErrBadReview, "bad_review"},
+	{ErrApprovalRequired, "approval_required"},
+	{ErrPendingNotFound, "pending_not_found"},
+	{ErrPendingDecided, "pending_decided"},
+	{ErrPendingExpired, "pending_expired"},
+	{ErrBadApproval, "bad_approval"},
+	{ErrSameApprover, "same_approver"},
+	{ErrApprovalInBatch, "approval_in_batch"},
+	{ErrBadBonus, "bad_bonus"},
+	{ErrBadRates, "bad_rates"},
+	{ErrRateNotFound, "rate_not_found"},
+	{ErrUnauthorized, "unauthorized"},
+	{ErrForbidden, "forbidden"},
+	{ErrBadFeeRule, "bad_fee_rule"},
+	{context.DeadlineExceeded, "timeout"},
+}
+
+// ErrorCode returns the code of a known error, empty for the others.
+func ErrorCode(err error) string {
+	for _, c := range errorCodes {
+		if errors.Is(err, c.err) {
+			return c.code
+		}
+	}
+	return ""
+}
+
+// ErrorByCode is the reverse of ErrorCode, nil for unknown codes.
+func ErrorByCode(code string) error {
+	for _, c := range errorCodes {
+		if c.code == code {
+			return c.err
+		}
+	}
+	return nil
+}
+
+// LimitError names the spending limit an operation ran into.
+type LimitError struct {
+	Limit string
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("%s limit exceeded", e.Limit)
+}
+
+func (e *LimitError) Is(target error) bool {
+	return target == ErrLimitExceeded
+}
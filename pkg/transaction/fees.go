@@ -0,0 +1,263 @@
+package transaction
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// RevenueAccountID is the system account fees are booked to. Client accounts
+// start from 1, so it never clashes with them.
+const RevenueAccountID = 0
+
+const (
+	OperationDeposit  = "deposit"
+	OperationWithdraw = "withdraw"
+	OperationTransfer = "transfer"
+)
+
+const feeRuleColumns = "id, operation, currency, user_id, fixed, percent, min_fee, max_fee"
+
+// opCurrency is the currency a withdrawal or a transfer was asked in and its
+// Rate, the price of a unit in RUB. The fee rules of the currency apply to it,
+// the zero one is RUB.
+type opCurrency struct {
+	Code string
+	Rate float64
+}
+
+type currencyCtx struct{}
+
+// WithOperationCurrency makes the money of the withdrawals and the transfers
+// asked with ctx be in the currency: it is converted to RUB at today's rate,
+// and the fee rules of the currency apply.
+func WithOperationCurrency(ctx context.Context, currency string) context.Context {
+	return context.WithValue(ctx, currencyCtx{}, currency)
+}
+
+// OperationCurrency is the currency given to ctx by WithOperationCurrency.
+func OperationCurrency(ctx context.Context) string {
+	currency, _ := ctx.Value(currencyCtx{}).(string)
+	return currency
+}
+
+// inRoubles converts money asked in the currency of ctx to RUB at today's rate,
+// rounded to kopecks.
+func (r *RepositoryItem) inRoubles(ctx context.Context, money float64) (float64, opCurrency, error) {
+	code := strings.ToUpper(OperationCurrency(ctx))
+	if code == "" || code == BaseCurrency {
+		return money, opCurrency{}, nil
+	}
+
+	rate, err := r.rateAt(ctx, code, rateDay(r.now()))
+	if err != nil {
+		return 0, opCurrency{}, err
+	}
+
+	return math.Round(money*rate*100) / 100, opCurrency{Code: code, Rate: rate}, nil
+}
+
+// checkOperation accepts the operations fees are charged for.
+func checkOperation(operation string) error {
+	if operation != OperationWithdraw && operation != OperationTransfer {
+		return ErrBadOperation
+	}
+	return nil
+}
+
+// compute applies the percent on top of the fixed part, then the bounds.
+// Fees are rounded to kopecks.
+func (rule *FeeRule) compute(money float64) float64 {
+	fee := rule.Fixed + money*rule.Percent/100
+	if rule.MinFee != nil && fee < *rule.MinFee {
+		fee = *rule.MinFee
+	}
+	if rule.MaxFee != nil && fee > *rule.MaxFee {
+		fee = *rule.MaxFee
+	}
+
+	return math.Round(fee*100) / 100
+}
+
+// scanner is either *sql.Row or *sql.Rows.
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanFeeRule(row scanner) (*FeeRule, error) {
+	rule := &FeeRule{}
+	err := row.Scan(&rule.ID, &rule.Operation, &rule.Currency, &rule.UserID, &rule.Fixed, &rule.Percent,
+		&rule.MinFee, &rule.MaxFee)
+	if err != nil {
+		return nil, err
+	}
+
+	return rule, nil
+}
+
+func (r *RepositoryItem) GetFeeRules(ctx context.Context) ([]*FeeRule, error) {
+	ctx, cancel := r.operation(ctx)
+	defer cancel()
+
+	rows, err := r.DB.QueryContext(ctx, "SELECT "+feeRuleColumns+" FROM fee_rules ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rules := make([]*FeeRule, 0)
+	for rows.Next() {
+		rule, err := scanFeeRule(rows)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+
+	return rules, rows.Err()
+}
+
+func (r *RepositoryItem) CreateFeeRule(ctx context.Context, rule *FeeRule) (*FeeRule, error) {
+	ctx, cancel := r.operation(ctx)
+	defer cancel()
+
+	err := checkOperation(rule.Operation)
+	if err != nil {
+		return nil, err
+	}
+
+	if rule.Fixed < 0 || rule.Percent < 0 ||
+		(rule.MinFee != nil && *rule.MinFee < 0) || (rule.MaxFee != nil && *rule.MaxFee < 0) {
+		return nil, ErrNegativeAmount
+	}
+	if rule.MinFee != nil && rule.MaxFee != nil && *rule.MinFee > *rule.MaxFee {
+		return nil, ErrBadFeeRule
+	}
+	// currencies are stored upper-cased like the rates, so "usd" matches USD
+	rule.Currency = strings.ToUpper(rule.Currency)
+	if rule.Currency == "" {
+		rule.Currency = BaseCurrency
+	}
+
+	return scanFeeRule(r.DB.QueryRowContext(ctx, "INSERT INTO fee_rules (operation, currency, user_id, fixed, percent, min_fee, max_fee) "+
+		"VALUES ($1, $2, $3, $4, $5, $6, $7) returning "+feeRuleColumns,
+		rule.Operation, rule.Currency, rule.UserID, rule.Fixed, rule.Percent, rule.MinFee, rule.MaxFee))
+}
+
+func (r *RepositoryItem) DeleteFeeRule(ctx context.Context, ruleID int) error {
+	ctx, cancel := r.operation(ctx)
+	defer cancel()
+
+	res, err := r.DB.ExecContext(ctx, "DELETE FROM fee_rules WHERE id = $1", ruleID)
+	if err != nil {
+		return err
+	}
+
+	deleted, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return ErrFeeRuleNotFound
+	}
+
+	return nil
+}
+
+// findFeeRule prefers a rule of the client over the common one.
+// No rule means no fee.
+func findFeeRule(operation, currency string, userID int, db TransactionInterface) (*FeeRule, error) {
+	rule, err := scanFeeRule(db.QueryRow("SELECT "+feeRuleColumns+" FROM fee_rules "+
+		"WHERE operation = $1 AND currency = $2 AND (user_id = $3 OR user_id IS NULL) "+
+		"ORDER BY user_id NULLS LAST, id LIMIT 1", operation, currency, userID))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+
+	return rule, err
+}
+
+func (r *RepositoryItem) PreviewFee(ctx context.Context, req *FeeRequest) (*FeePreview, error) {
+	ctx, cancel := r.operation(ctx)
+	defer cancel()
+
+	err := checkOperation(req.Operation)
+	if err != nil {
+		return nil, err
+	}
+	if req.Money < 0 {
+		return nil, ErrNegativeAmount
+	}
+
+	preview := &FeePreview{
+		Operation: req.Operation,
+		UserID:    req.UserID,
+		Currency:  strings.ToUpper(req.Currency),
+		Money:     req.Money,
+		Total:     req.Money,
+	}
+	if preview.Currency == "" {
+		preview.Currency = BaseCurrency
+	}
+
+	rule, err := findFeeRule(preview.Operation, preview.Currency, preview.UserID, withContext(ctx, r.DB))
+	if err != nil {
+		return nil, err
+	}
+	if rule != nil {
+		preview.RuleID = &rule.ID
+		preview.Fee = rule.compute(req.Money)
+		preview.Total += preview.Fee
+	}
+
+	return preview, nil
+}
+
+// chargeFee books the fee of an operation to the revenue account. The rule of
+// the currency the operation was asked in applies to its money in that
+// currency, the fee is converted back to RUB at the same rate.
+func (r *RepositoryItem) chargeFee(operation string, userID int, money float64, cur opCurrency, operationID int,
+	db TransactionInterface) (*Transaction, error) {
+	code, rate := cur.Code, cur.Rate
+	if code == "" {
+		code, rate = BaseCurrency, 1
+	}
+	rule, err := findFeeRule(operation, code, userID, db)
+	if err != nil || rule == nil {
+		return nil, err
+	}
+
+	fee := math.Round(rule.compute(money/rate)*rate*100) / 100
+	if fee <= 0 {
+		return nil, nil
+	}
+
+	balance, err := r.getMoneyFromDB(userID, fee, db)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = r.appendMoneyToUser(RevenueAccountID, fee, db)
+	if err != nil {
+		return nil, err
+	}
+
+	revenueID := RevenueAccountID
+	tr := &Transaction{
+		ToID:   &revenueID,
+		FromID: &userID,
+		Money:  fee,
+		FeeOf:  &operationID,
+	}
+	err = db.QueryRow("INSERT INTO transaction (to_id, from_id, money, created, fee_of) VALUES ($1, $2, $3, $4, $5) returning id, created",
+		tr.ToID, tr.FromID, tr.Money, r.now(), tr.FeeOf).Scan(&tr.ID, &tr.Created)
+	if err != nil {
+		return nil, fmt.Errorf("dont create transaction: %v", err)
+	}
+	tr.Created = tr.Created.UTC()
+	tr.Balance = &balance
+
+	return tr, nil
+}
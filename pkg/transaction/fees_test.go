@@ -0,0 +1,441 @@
+package transaction
+
+import (
+	"fmt"
+	sqlmock "gopkg.in/DATA-DOG/go-sqlmock.v1"
+	"reflect"
+	"testing"
+)
+
+var feeRuleColumnNames = []string{"id", "operation", "currency", "user_id", "fixed", "percent", "min_fee", "max_fee"}
+
+func TestFeeRuleCompute(t *testing.T) {
+	minFee, maxFee := 10.0, 100.0
+	cases := []struct {
+		rule  *FeeRule
+		money float64
+		fee   float64
+	}{
+		{&FeeRule{Fixed: 5}, 1000, 5},
+		{&FeeRule{Percent: 1.5}, 1000, 15},
+		{&FeeRule{Fixed: 1, Percent: 1}, 1000, 11},
+		{&FeeRule{Percent: 1, MinFee: &minFee}, 100, 10},
+		{&FeeRule{Percent: 1, MaxFee: &maxFee}, 100000, 100},
+		{&FeeRule{Percent: 0.333}, 100, 0.33},
+	}
+
+	for _, c := range cases {
+		fee := c.rule.compute(c.money)
+		if fee != c.fee {
+			t.Errorf("bad fee of %v for %v: want %v, have %v", c.rule, c.money, c.fee, fee)
+		}
+	}
+}
+
+func TestPreviewFee(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("cant create mock: %s", err)
+	}
+	defer db.Close()
+	repo := NewRepository(db)
+
+	ruleID := 3
+	expect := &FeePreview{
+		Operation: OperationTransfer,
+		UserID:    1,
+		Currency:  BaseCurrency,
+		Money:     200,
+		Fee:       3,
+		Total:     203,
+		RuleID:    &ruleID,
+	}
+
+	mock.
+		ExpectQuery("SELECT id, operation, currency, user_id, fixed, percent, min_fee, max_fee FROM fee_rules WHERE").
+		WithArgs(OperationTransfer, BaseCurrency, 1).
+		WillReturnRows(sqlmock.NewRows(feeRuleColumnNames).
+			AddRow(ruleID, OperationTransfer, BaseCurrency, 1, 1.0, 1.0, nil, nil))
+
+	preview, err := repo.PreviewFee(ctx, &FeeRequest{Operation: OperationTransfer, UserID: 1, Money: 200})
+	if err != nil {
+		t.Errorf("unexpected err: %s", err)
+		return
+	}
+	if !reflect.DeepEqual(preview, expect) {
+		t.Errorf("results not match, want %v, have %v", expect, preview)
+		return
+	}
+
+	// no rule, no fee
+	mock.
+		ExpectQuery("SELECT id, operation, currency, user_id, fixed, percent, min_fee, max_fee FROM fee_rules WHERE").
+		WithArgs(OperationWithdraw, "USD", 1).
+		WillReturnRows(sqlmock.NewRows(feeRuleColumnNames))
+
+	preview, err = repo.PreviewFee(ctx, &FeeRequest{Operation: OperationWithdraw, UserID: 1, Money: 200, Currency: "USD"})
+	if err != nil {
+		t.Errorf("unexpected err: %s", err)
+		return
+	}
+	if preview.Fee != 0 || preview.Total != 200 || preview.RuleID != nil {
+		t.Errorf("expected no fee, got %v", preview)
+		return
+	}
+
+	// bad operation
+	_, err = repo.PreviewFee(ctx, &FeeRequest{Operation: "deposit", UserID: 1, Money: 200})
+	if err != ErrBadOperation {
+		t.Errorf("expected ErrBadOperation, got %v", err)
+		return
+	}
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestFeeRules(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("cant create mock: %s", err)
+	}
+	defer db.Close()
+	repo := NewRepository(db)
+
+	maxFee := 50.0
+	expect := &FeeRule{
+		ID:        1,
+		Operation: OperationWithdraw,
+		Currency:  BaseCurrency,
+		Percent:   1,
+		MaxFee:    &maxFee,
+	}
+
+	mock.
+		ExpectQuery("INSERT INTO fee_rules").
+		WithArgs(OperationWithdraw, BaseCurrency, nil, 0.0, 1.0, nil, &maxFee).
+		WillReturnRows(sqlmock.NewRows(feeRuleColumnNames).
+			AddRow(1, OperationWithdraw, BaseCurrency, nil, 0.0, 1.0, nil, maxFee))
+
+	rule, err := repo.CreateFeeRule(ctx, &FeeRule{Operation: OperationWithdraw, Percent: 1, MaxFee: &maxFee})
+	if err != nil {
+		t.Errorf("unexpected err: %s", err)
+		return
+	}
+	if !reflect.DeepEqual(rule, expect) {
+		t.Errorf("results not match, want %v, have %v", expect, rule)
+		return
+	}
+
+	// the currency is stored like the operation currencies are looked up
+	mock.
+		ExpectQuery("INSERT INTO fee_rules").
+		WithArgs(OperationWithdraw, "USD", nil, 1.0, 0.0, nil, nil).
+		WillReturnRows(sqlmock.NewRows(feeRuleColumnNames).
+			AddRow(2, OperationWithdraw, "USD", nil, 1.0, 0.0, nil, nil))
+
+	rule, err = repo.CreateFeeRule(ctx, &FeeRule{Operation: OperationWithdraw, Currency: "usd", Fixed: 1})
+	if err != nil || rule.Currency != "USD" {
+		t.Errorf("unexpected result %v, %v", rule, err)
+		return
+	}
+
+	// bad rules
+	_, err = repo.CreateFeeRule(ctx, &FeeRule{Operation: OperationWithdraw, Fixed: -1})
+	if err != ErrNegativeAmount {
+		t.Errorf("expected ErrNegativeAmount, got %v", err)
+		return
+	}
+	minFee := 60.0
+	_, err = repo.CreateFeeRule(ctx, &FeeRule{Operation: OperationWithdraw, Percent: 1, MinFee: &minFee, MaxFee: &maxFee})
+	if err != ErrBadFeeRule {
+		t.Errorf("expected ErrBadFeeRule, got %v", err)
+		return
+	}
+	_, err = repo.CreateFeeRule(ctx, &FeeRule{Operation: "refund"})
+	if err != ErrBadOperation {
+		t.Errorf("expected ErrBadOperation, got %v", err)
+		return
+	}
+
+	// list
+	mock.
+		ExpectQuery("SELECT id, operation, currency, user_id, fixed, percent, min_fee, max_fee FROM fee_rules ORDER BY id").
+		WillReturnRows(sqlmock.NewRows(feeRuleColumnNames).
+			AddRow(1, OperationWithdraw, BaseCurrency, nil, 0.0, 1.0, nil, maxFee))
+
+	rules, err := repo.GetFeeRules(ctx)
+	if err != nil {
+		t.Errorf("unexpected err: %s", err)
+		return
+	}
+	if !reflect.DeepEqual(rules, []*FeeRule{expect}) {
+		t.Errorf("results not match, want %v, have %v", expect, rules)
+		return
+	}
+
+	// delete
+	mock.
+		ExpectExec("DELETE FROM fee_rules WHERE").
+		WithArgs(1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.
+		ExpectExec("DELETE FROM fee_rules WHERE").
+		WithArgs(2).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	err = repo.DeleteFeeRule(ctx, 1)
+	if err != nil {
+		t.Errorf("unexpected err: %s", err)
+		return
+	}
+	err = repo.DeleteFeeRule(ctx, 2)
+	if err != ErrFeeRuleNotFound {
+		t.Errorf("expected ErrFeeRuleNotFound, got %v", err)
+		return
+	}
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestTransferMoneyFee(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("cant create mock: %s", err)
+	}
+	defer db.Close()
+	repo := NewRepository(db)
+	repo.Clock = testClock
+
+	fromID, toID, revenueID, mainID := 1, 2, RevenueAccountID, 10
+
+	mock.ExpectBegin()
+	mock.
+		ExpectQuery("SELECT balance, status, debit_blocked, credit_blocked, credit_limit FROM users WHERE").
+		WithArgs(fromID).
+		WillReturnRows(accountRows(100))
+	mock.
+		ExpectQuery("UPDATE users SET balance = balance -").
+		WithArgs(50.0, fromID).
+		WillReturnRows(sqlmock.NewRows([]string{"balance"}).AddRow(50.0))
+	expectNoLimits(mock)
+	mock.
+		ExpectQuery("SELECT balance, status, debit_blocked, credit_blocked, credit_limit FROM users WHERE").
+		WithArgs(toID).
+		WillReturnRows(accountRows(0))
+	mock.
+		ExpectQuery("UPDATE users SET balance = balance \\+").
+		WithArgs(50.0, toID).
+		WillReturnRows(sqlmock.NewRows([]string{"balance"}).AddRow(50.0))
+	mock.
+		ExpectQuery("INSERT INTO transaction").
+		WithArgs(&toID, &fromID, 50.0, testTime, nil).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created"}).AddRow(mainID, testTime))
+	mock.
+		ExpectQuery("SELECT id, operation, currency, user_id, fixed, percent, min_fee, max_fee FROM fee_rules WHERE").
+		WithArgs(OperationTransfer, BaseCurrency, fromID).
+		WillReturnRows(sqlmock.NewRows(feeRuleColumnNames).
+			AddRow(1, OperationTransfer, BaseCurrency, nil, 1.0, 2.0, nil, nil))
+	mock.
+		ExpectQuery("SELECT balance, status, debit_blocked, credit_blocked, credit_limit FROM users WHERE").
+		WithArgs(fromID).
+		WillReturnRows(accountRows(50))
+	mock.
+		ExpectQuery("UPDATE users SET balance = balance -").
+		WithArgs(2.0, fromID).
+		WillReturnRows(sqlmock.NewRows([]string{"balance"}).AddRow(48.0))
+	mock.
+		ExpectQuery("SELECT balance, status, debit_blocked, credit_blocked, credit_limit FROM users WHERE").
+		WithArgs(revenueID).
+		WillReturnRows(accountRows(0))
+	mock.
+		ExpectQuery("UPDATE users SET balance = balance \\+").
+		WithArgs(2.0, revenueID).
+		WillReturnRows(sqlmock.NewRows([]string{"balance"}).AddRow(2.0))
+	mock.
+		ExpectQuery("INSERT INTO transaction \\(to_id, from_id, money, created, fee_of\\)").
+		WithArgs(&revenueID, &fromID, 2.0, testTime, &mainID).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created"}).AddRow(mainID+1, testTime))
+	mock.ExpectCommit()
+
+	tr, err := repo.TransferMoney(ctx, fromID, toID, 50)
+	if err != nil {
+		t.Errorf("unexpected err: %s", err)
+		return
+	}
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+		return
+	}
+
+	if tr.Fee == nil || tr.Fee.Money != 2 || *tr.Fee.FeeOf != mainID || *tr.Fee.ToID != RevenueAccountID {
+		t.Errorf("bad fee: %v", tr.Fee)
+		return
+	}
+	if *tr.Balance != 48 {
+		t.Errorf("expected balance 48 after the fee, got %v", *tr.Balance)
+		return
+	}
+
+	// the fee does not fit into the balance, nothing is moved
+	mock.ExpectBegin()
+	expectNoBonuses(mock)
+	mock.
+		ExpectQuery("SELECT balance, status, debit_blocked, credit_blocked, credit_limit FROM users WHERE").
+		WithArgs(fromID).
+		WillReturnRows(accountRows(50))
+	mock.
+		ExpectQuery("UPDATE users SET balance = balance -").
+		WithArgs(50.0, fromID).
+		WillReturnRows(sqlmock.NewRows([]string{"balance"}).AddRow(0.0))
+	expectNoLimits(mock)
+	mock.
+		ExpectQuery("INSERT INTO transaction").
+		WithArgs(nil, &fromID, -50.0, testTime, nil).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created"}).AddRow(mainID, testTime))
+	mock.
+		ExpectQuery("SELECT id, operation, currency, user_id, fixed, percent, min_fee, max_fee FROM fee_rules WHERE").
+		WithArgs(OperationWithdraw, BaseCurrency, fromID).
+		WillReturnRows(sqlmock.NewRows(feeRuleColumnNames).
+			AddRow(1, OperationWithdraw, BaseCurrency, nil, 1.0, 0.0, nil, nil))
+	mock.
+		ExpectQuery("SELECT balance, status, debit_blocked, credit_blocked, credit_limit FROM users WHERE").
+		WithArgs(fromID).
+		WillReturnRows(accountRows(0))
+	mock.ExpectRollback()
+
+	_, err = repo.WithdrawMoney(ctx, fromID, 50)
+	if err != ErrNotEnoughMoney {
+		t.Errorf("expected ErrNotEnoughMoney, got %v", err)
+		return
+	}
+
+	// rule lookup error
+	mock.ExpectBegin()
+	expectNoBonuses(mock)
+	mock.
+		ExpectQuery("SELECT balance, status, debit_blocked, credit_blocked, credit_limit FROM users WHERE").
+		WithArgs(fromID).
+		WillReturnRows(accountRows(50))
+	mock.
+		ExpectQuery("UPDATE users SET balance = balance -").
+		WithArgs(50.0, fromID).
+		WillReturnRows(sqlmock.NewRows([]string{"balance"}).AddRow(0.0))
+	expectNoLimits(mock)
+	mock.
+		ExpectQuery("INSERT INTO transaction").
+		WithArgs(nil, &fromID, -50.0, testTime, nil).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created"}).AddRow(mainID, testTime))
+	mock.
+		ExpectQuery("SELECT id, operation, currency, user_id, fixed, percent, min_fee, max_fee FROM fee_rules WHERE").
+		WithArgs(OperationWithdraw, BaseCurrency, fromID).
+		WillReturnError(fmt.Errorf("db_error"))
+	mock.ExpectRollback()
+
+	_, err = repo.WithdrawMoney(ctx, fromID, 50)
+	if err == nil {
+		t.Errorf("expected error, got nil")
+		return
+	}
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestTransferMoneyFeeCurrency(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("cant create mock: %s", err)
+	}
+	defer db.Close()
+	repo := NewRepository(db)
+	repo.Clock = testClock
+	repo.LiveRate = nil
+
+	fromID, toID, revenueID, mainID := 1, 2, RevenueAccountID, 10
+	today := rateDay(testTime)
+
+	// 10 USD at 75 are 750 RUB, the USD rule takes its min_fee of 1 USD
+	mock.
+		ExpectQuery("SELECT day, rate FROM currency_rates WHERE").
+		WithArgs("USD", today).
+		WillReturnRows(sqlmock.NewRows([]string{"day", "rate"}).AddRow(today, 75.0))
+	mock.ExpectBegin()
+	mock.
+		ExpectQuery("SELECT balance, status, debit_blocked, credit_blocked, credit_limit FROM users WHERE").
+		WithArgs(fromID).
+		WillReturnRows(accountRows(1000))
+	mock.
+		ExpectQuery("UPDATE users SET balance = balance -").
+		WithArgs(750.0, fromID).
+		WillReturnRows(sqlmock.NewRows([]string{"balance"}).AddRow(250.0))
+	expectNoLimits(mock)
+	mock.
+		ExpectQuery("SELECT balance, status, debit_blocked, credit_blocked, credit_limit FROM users WHERE").
+		WithArgs(toID).
+		WillReturnRows(accountRows(0))
+	mock.
+		ExpectQuery("UPDATE users SET balance = balance \\+").
+		WithArgs(750.0, toID).
+		WillReturnRows(sqlmock.NewRows([]string{"balance"}).AddRow(750.0))
+	mock.
+		ExpectQuery("INSERT INTO transaction").
+		WithArgs(&toID, &fromID, 750.0, testTime, nil).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created"}).AddRow(mainID, testTime))
+	mock.
+		ExpectQuery("SELECT id, operation, currency, user_id, fixed, percent, min_fee, max_fee FROM fee_rules WHERE").
+		WithArgs(OperationTransfer, "USD", fromID).
+		WillReturnRows(sqlmock.NewRows(feeRuleColumnNames).
+			AddRow(1, OperationTransfer, "USD", nil, 0.0, 1.0, 1.0, nil))
+	mock.
+		ExpectQuery("SELECT balance, status, debit_blocked, credit_blocked, credit_limit FROM users WHERE").
+		WithArgs(fromID).
+		WillReturnRows(accountRows(250))
+	mock.
+		ExpectQuery("UPDATE users SET balance = balance -").
+		WithArgs(75.0, fromID).
+		WillReturnRows(sqlmock.NewRows([]string{"balance"}).AddRow(175.0))
+	mock.
+		ExpectQuery("SELECT balance, status, debit_blocked, credit_blocked, credit_limit FROM users WHERE").
+		WithArgs(revenueID).
+		WillReturnRows(accountRows(0))
+	mock.
+		ExpectQuery("UPDATE users SET balance = balance \\+").
+		WithArgs(75.0, revenueID).
+		WillReturnRows(sqlmock.NewRows([]string{"balance"}).AddRow(75.0))
+	mock.
+		ExpectQuery("INSERT INTO transaction \\(to_id, from_id, money, created, fee_of\\)").
+		WithArgs(&revenueID, &fromID, 75.0, testTime, &mainID).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created"}).AddRow(mainID+1, testTime))
+	mock.ExpectCommit()
+
+	tr, err := repo.TransferMoney(WithOperationCurrency(ctx, "USD"), fromID, toID, 10)
+	if err != nil {
+		t.Errorf("unexpected err: %s", err)
+		return
+	}
+	if tr.Money != 750 || tr.Fee == nil || tr.Fee.Money != 75 || *tr.Balance != 175 {
+		t.Errorf("unexpected transfer %v, fee %v", tr, tr.Fee)
+		return
+	}
+
+	// no rate, nothing is moved
+	mock.
+		ExpectQuery("SELECT day, rate FROM currency_rates WHERE").
+		WithArgs("EUR", today).
+		WillReturnRows(sqlmock.NewRows([]string{"day", "rate"}))
+
+	_, err = repo.TransferMoney(WithOperationCurrency(ctx, "EUR"), fromID, toID, 10)
+	if err != ErrRateNotFound {
+		t.Errorf("expected ErrRateNotFound, got %v", err)
+		return
+	}
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
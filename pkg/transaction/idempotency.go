@@ -0,0 +1,96 @@
+package transaction
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// DefaultIdempotencyTimeout is how long a key stays reserved by a request
+// without an answer. It is longer than an operation may run, a key still
+// reserved after it belongs to a request that died.
+const DefaultIdempotencyTimeout = time.Minute
+
+// DefaultIdempotencyTTL is how long an answer is kept for the repeats.
+const DefaultIdempotencyTTL = 24 * time.Hour
+
+// BeginIdempotent reserves the key of the client for a request. It returns nil
+// when the request has to be executed, the stored answer when it was already
+// executed. The fingerprint tells requests apart, a key can't be reused for
+// another one. A reservation older than IdempotencyTimeout and a key older
+// than IdempotencyTTL are taken over by the new request.
+func (r *RepositoryItem) BeginIdempotent(ctx context.Context, client, key, fingerprint string) (*IdempotentResponse, error) {
+	ctx, cancel := r.operation(ctx)
+	defer cancel()
+
+	now := r.now()
+	res, err := r.DB.ExecContext(ctx, "INSERT INTO idempotency_keys (client, key, fingerprint, created) VALUES ($1, $2, $3, $4) "+
+		"ON CONFLICT (client, key) DO UPDATE SET fingerprint = EXCLUDED.fingerprint, status = NULL, body = NULL, created = EXCLUDED.created "+
+		"WHERE (idempotency_keys.status IS NULL AND idempotency_keys.created < $5) OR idempotency_keys.created < $6",
+		client, key, fingerprint, now, now.Add(-r.IdempotencyTimeout), now.Add(-r.IdempotencyTTL))
+	if err != nil {
+		return nil, err
+	}
+	inserted, err := res.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if inserted == 1 {
+		return nil, nil
+	}
+
+	var stored string
+	var status sql.NullInt64
+	resp := &IdempotentResponse{}
+	err = r.DB.QueryRowContext(ctx, "SELECT fingerprint, status, body FROM idempotency_keys WHERE client = $1 AND key = $2", client, key).
+		Scan(&stored, &status, &resp.Body)
+	if err == sql.ErrNoRows {
+		// released between the two queries, the client will retry
+		return nil, ErrRequestInProgress
+	}
+	if err != nil {
+		return nil, err
+	}
+	if stored != fingerprint {
+		return nil, ErrIdempotencyMismatch
+	}
+	if !status.Valid {
+		return nil, ErrRequestInProgress
+	}
+	resp.Status = int(status.Int64)
+
+	return resp, nil
+}
+
+// FinishIdempotent stores the answer to the request holding the key.
+func (r *RepositoryItem) FinishIdempotent(ctx context.Context, client, key string, resp *IdempotentResponse) error {
+	ctx, cancel := r.operation(ctx)
+	defer cancel()
+
+	_, err := r.DB.ExecContext(ctx, "UPDATE idempotency_keys SET status = $3, body = $4 WHERE client = $1 AND key = $2",
+		client, key, resp.Status, resp.Body)
+	return err
+}
+
+// ReleaseIdempotent frees the key of a request that failed, so it can be
+// repeated with the same key.
+func (r *RepositoryItem) ReleaseIdempotent(ctx context.Context, client, key string) error {
+	ctx, cancel := r.operation(ctx)
+	defer cancel()
+
+	_, err := r.DB.ExecContext(ctx, "DELETE FROM idempotency_keys WHERE client = $1 AND key = $2 AND status IS NULL", client, key)
+	return err
+}
+
+// ExpireIdempotencyKeys deletes the keys older than IdempotencyTTL, it returns
+// how many there were. Any number of instances may run it at once.
+func (r *RepositoryItem) ExpireIdempotencyKeys(ctx context.Context) (int64, error) {
+	ctx, cancel := r.operation(ctx)
+	defer cancel()
+
+	res, err := r.DB.ExecContext(ctx, "DELETE FROM idempotency_keys WHERE created < $1", r.now().Add(-r.IdempotencyTTL))
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
@@ -0,0 +1,131 @@
+package transaction
+
+import (
+	sqlmock "gopkg.in/DATA-DOG/go-sqlmock.v1"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestBeginIdempotent(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("cant create mock: %s", err)
+	}
+	defer db.Close()
+	repo := NewRepository(db)
+	repo.Clock = testClock
+	stale, expired := testTime.Add(-DefaultIdempotencyTimeout), testTime.Add(-DefaultIdempotencyTTL)
+
+	// new key, a stale reservation or an expired key of the client
+	mock.
+		ExpectExec("INSERT INTO idempotency_keys").
+		WithArgs("shop", "key-1", "fp", testTime, stale, expired).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	resp, err := repo.BeginIdempotent(ctx, "shop", "key-1", "fp")
+	if err != nil || resp != nil {
+		t.Errorf("unexpected result %v, %v", resp, err)
+		return
+	}
+
+	// executed before
+	mock.
+		ExpectExec("INSERT INTO idempotency_keys").
+		WithArgs("shop", "key-1", "fp", testTime, stale, expired).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.
+		ExpectQuery("SELECT fingerprint, status, body FROM idempotency_keys").
+		WithArgs("shop", "key-1").
+		WillReturnRows(sqlmock.NewRows([]string{"fingerprint", "status", "body"}).AddRow("fp", 200, []byte(`{}`)))
+
+	resp, err = repo.BeginIdempotent(ctx, "shop", "key-1", "fp")
+	if err != nil {
+		t.Errorf("unexpected err: %s", err)
+		return
+	}
+	if !reflect.DeepEqual(resp, &IdempotentResponse{Status: 200, Body: []byte(`{}`)}) {
+		t.Errorf("unexpected response %v", resp)
+		return
+	}
+
+	// still running
+	mock.
+		ExpectExec("INSERT INTO idempotency_keys").
+		WithArgs("shop", "key-1", "fp", testTime, stale, expired).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.
+		ExpectQuery("SELECT fingerprint, status, body FROM idempotency_keys").
+		WithArgs("shop", "key-1").
+		WillReturnRows(sqlmock.NewRows([]string{"fingerprint", "status", "body"}).AddRow("fp", nil, nil))
+
+	_, err = repo.BeginIdempotent(ctx, "shop", "key-1", "fp")
+	if err != ErrRequestInProgress {
+		t.Errorf("expected ErrRequestInProgress, got %v", err)
+		return
+	}
+
+	// another request
+	mock.
+		ExpectExec("INSERT INTO idempotency_keys").
+		WithArgs("shop", "key-1", "other", testTime, stale, expired).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.
+		ExpectQuery("SELECT fingerprint, status, body FROM idempotency_keys").
+		WithArgs("shop", "key-1").
+		WillReturnRows(sqlmock.NewRows([]string{"fingerprint", "status", "body"}).AddRow("fp", 200, []byte(`{}`)))
+
+	_, err = repo.BeginIdempotent(ctx, "shop", "key-1", "other")
+	if err != ErrIdempotencyMismatch {
+		t.Errorf("expected ErrIdempotencyMismatch, got %v", err)
+		return
+	}
+
+	mock.
+		ExpectExec("UPDATE idempotency_keys SET status").
+		WithArgs("shop", "key-1", 200, []byte(`{}`)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.
+		ExpectExec("DELETE FROM idempotency_keys").
+		WithArgs("shop", "key-2").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	if err = repo.FinishIdempotent(ctx, "shop", "key-1", &IdempotentResponse{Status: 200, Body: []byte(`{}`)}); err != nil {
+		t.Errorf("unexpected err: %s", err)
+		return
+	}
+	if err = repo.ReleaseIdempotent(ctx, "shop", "key-2"); err != nil {
+		t.Errorf("unexpected err: %s", err)
+		return
+	}
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestExpireIdempotencyKeys(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("cant create mock: %s", err)
+	}
+	defer db.Close()
+	repo := NewRepository(db)
+	repo.Clock = testClock
+	repo.IdempotencyTTL = time.Hour
+
+	mock.
+		ExpectExec("DELETE FROM idempotency_keys WHERE created < \\$1").
+		WithArgs(testTime.Add(-time.Hour)).
+		WillReturnResult(sqlmock.NewResult(0, 3))
+
+	n, err := repo.ExpireIdempotencyKeys(ctx)
+	if err != nil || n != 3 {
+		t.Errorf("unexpected result %d, %v", n, err)
+		return
+	}
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
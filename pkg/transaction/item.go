@@ -3,16 +3,382 @@ package transaction
 import "time"
 
 type User struct {
-	UserID   int     `json:"id"`
-	Balance  float64 `json:"balance"`
-	ToUserID int     `json:"id_to,omitempty"`
-	Field    string  `json:"field,omitempty"`
-	Currency string  `json:"-"`
+	UserID        int      `json:"id"`
+	Balance       float64  `json:"balance"`
+	ToUserID      int      `json:"id_to,omitempty"`
+	TransactionID int      `json:"transaction_id,omitempty"`
+	Field         string   `json:"field,omitempty"`
+	Currency      string   `json:"-"`
+	Available     *float64 `json:"available,omitempty"`
+	// Service is what a withdrawal pays for, it decides the usable bonuses.
+	Service string `json:"service,omitempty"`
+	// Bonus is the unspent bonus money, Bonuses are its grants.
+	Bonus   *float64      `json:"bonus,omitempty"`
+	Bonuses []*BonusGrant `json:"bonuses,omitempty"`
 }
 
 type Transaction struct {
-	ToID    *int      `json:"to_id"`
-	FromID  *int      `json:"from_id"`
-	Money   float64   `json:"money"`
+	ID       int       `json:"id"`
+	ToID     *int      `json:"to_id"`
+	FromID   *int      `json:"from_id"`
+	Money    float64   `json:"money"`
+	Created  time.Time `json:"created"`
+	RefundOf *int      `json:"refund_of,omitempty"`
+	FeeOf    *int      `json:"fee_of,omitempty"`
+	SplitOf  *int      `json:"split_of,omitempty"`
+	// Bonus is the part of a withdrawal paid with bonus money or of its
+	// refund returned to the bonuses, Money is the real money.
+	Bonus float64 `json:"bonus,omitempty"`
+	// BonusExpired is the bonus money written off by the row, BonusGranted the
+	// one given by it, its Money is 0.
+	BonusExpired float64 `json:"bonus_expired,omitempty"`
+	BonusGranted float64 `json:"bonus_granted,omitempty"`
+	// PendingID is the pending operation the row holds or releases the money
+	// of, PendingStatus its transition and Held the money held, negative for a
+	// release; its Money is 0.
+	PendingID     *int     `json:"pending_id,omitempty"`
+	PendingStatus string   `json:"pending_status,omitempty"`
+	Held          float64  `json:"held,omitempty"`
+	Balance       *float64 `json:"balance,omitempty"`
+	// Fee is the fee charged with the operation, only set when it is created.
+	Fee *Transaction `json:"fee,omitempty"`
+	// Legs are the transfers of a split payment.
+	Legs []*Transaction `json:"legs,omitempty"`
+}
+
+type Account struct {
+	ID            int        `json:"id"`
+	ExternalRef   *string    `json:"external_ref"`
+	Status        string     `json:"status"`
+	DebitBlocked  bool       `json:"debit_blocked"`
+	CreditBlocked bool       `json:"credit_blocked"`
+	Balance       float64    `json:"balance"`
+	CreditLimit   float64    `json:"credit_limit"`
+	Currencies    []string   `json:"currencies"`
+	CreatedAt     time.Time  `json:"created_at"`
+	ClosedAt      *time.Time `json:"closed_at,omitempty"`
+}
+
+type AccountRequest struct {
+	UserID      int     `json:"id"`
+	ExternalRef string  `json:"external_ref,omitempty"`
+	Debit       bool    `json:"debit,omitempty"`
+	Credit      bool    `json:"credit,omitempty"`
+	CreditLimit float64 `json:"credit_limit,omitempty"`
+}
+
+// OverdraftEvent marks the operation that took the balance below zero or back.
+type OverdraftEvent struct {
+	ID      int       `json:"id"`
+	UserID  int       `json:"user_id"`
+	Event   string    `json:"event"`
+	Balance float64   `json:"balance"`
 	Created time.Time `json:"created"`
 }
+
+// Limits with nil fields are not restricted. UserID 0 holds the defaults.
+type Limits struct {
+	UserID          int      `json:"id"`
+	MaxOperation    *float64 `json:"max_operation"`
+	DailyDebit      *float64 `json:"daily_debit"`
+	MonthlyDebit    *float64 `json:"monthly_debit"`
+	HourlyTransfers *int     `json:"hourly_transfers"`
+}
+
+// FeeRule with UserID set applies to that client only and wins over the common rule.
+type FeeRule struct {
+	ID        int      `json:"id"`
+	Operation string   `json:"operation"`
+	Currency  string   `json:"currency"`
+	UserID    *int     `json:"user_id"`
+	Fixed     float64  `json:"fixed"`
+	Percent   float64  `json:"percent"`
+	MinFee    *float64 `json:"min_fee"`
+	MaxFee    *float64 `json:"max_fee"`
+}
+
+type FeeRequest struct {
+	Operation string  `json:"operation"`
+	UserID    int     `json:"id"`
+	Money     float64 `json:"money"`
+	Currency  string  `json:"currency,omitempty"`
+}
+
+type FeePreview struct {
+	Operation string  `json:"operation"`
+	UserID    int     `json:"id"`
+	Currency  string  `json:"currency"`
+	Money     float64 `json:"money"`
+	Fee       float64 `json:"fee"`
+	Total     float64 `json:"total"`
+	RuleID    *int    `json:"rule_id,omitempty"`
+}
+
+// Schedule without ToID withdraws money, with it transfers money. A schedule
+// with neither Cron nor Interval runs once at NextRun.
+type Schedule struct {
+	ID                int       `json:"id"`
+	FromID            int       `json:"from_id"`
+	ToID              *int      `json:"to_id"`
+	Money             float64   `json:"money"`
+	Cron              string    `json:"cron,omitempty"`
+	Interval          int64     `json:"interval_seconds,omitempty"`
+	NextRun           time.Time `json:"next_run"`
+	Status            string    `json:"status"`
+	Attempts          int       `json:"attempts"`
+	MaxAttempts       int       `json:"max_attempts"`
+	RetryDelay        int64     `json:"retry_delay_seconds"`
+	LastError         *string   `json:"last_error,omitempty"`
+	LastTransactionID *int      `json:"last_transaction_id,omitempty"`
+	CreatedAt         time.Time `json:"created_at"`
+	// Service is what the withdrawals of the schedule pay for.
+	Service string `json:"service,omitempty"`
+	// CreatedBy is the client that made the schedule, the runs held for
+	// approval are its requests.
+	CreatedBy string `json:"created_by,omitempty"`
+}
+
+// BatchItem is a deposit, a withdrawal for Service or a transfer to ToUserID.
+type BatchItem struct {
+	Operation string  `json:"operation"`
+	UserID    int     `json:"id"`
+	ToUserID  int     `json:"id_to,omitempty"`
+	Money     float64 `json:"money"`
+	Service   string  `json:"service,omitempty"`
+}
+
+type BatchRequest struct {
+	Atomic bool         `json:"atomic"`
+	Items  []*BatchItem `json:"items"`
+}
+
+type BatchResult struct {
+	Index       int          `json:"index"`
+	Status      string       `json:"status"`
+	Transaction *Transaction `json:"transaction,omitempty"`
+	Error       string       `json:"error,omitempty"`
+	// PendingID is the operation waiting for approval of a held item,
+	// ReviewID the risk review of one the risk checks held.
+	PendingID int `json:"pending_id,omitempty"`
+	ReviewID  int `json:"review_id,omitempty"`
+}
+
+// SplitLeg takes either a fixed Money or a Percent of the split total.
+type SplitLeg struct {
+	ToUserID int     `json:"id_to"`
+	Money    float64 `json:"money,omitempty"`
+	Percent  float64 `json:"percent,omitempty"`
+}
+
+// SplitRequest pays Money from one account to the legs. Money may be left out
+// when all legs are fixed.
+type SplitRequest struct {
+	UserID int         `json:"id"`
+	Money  float64     `json:"money,omitempty"`
+	Legs   []*SplitLeg `json:"legs"`
+}
+
+type BalanceSnapshot struct {
+	UserID  int       `json:"id"`
+	At      time.Time `json:"at"`
+	Balance float64   `json:"balance"`
+}
+
+// BalanceReport holds the balances of all accounts at one moment. Items are
+// only loaded when the report is read.
+type BalanceReport struct {
+	ID        int                `json:"id"`
+	At        time.Time          `json:"at"`
+	CreatedAt time.Time          `json:"created_at"`
+	Accounts  int64              `json:"accounts"`
+	Items     []*BalanceSnapshot `json:"items,omitempty"`
+}
+
+// ReconciliationMismatch is an account whose stored balance differs from the
+// sum of its history.
+type ReconciliationMismatch struct {
+	UserID       int     `json:"id"`
+	Balance      float64 `json:"balance"`
+	Expected     float64 `json:"expected"`
+	Difference   float64 `json:"difference"`
+	CorrectionID *int    `json:"correction_id,omitempty"`
+}
+
+type ReconciliationRun struct {
+	ID         int                       `json:"id"`
+	StartedAt  time.Time                 `json:"started_at"`
+	FinishedAt time.Time                 `json:"finished_at"`
+	Fix        bool                      `json:"fix"`
+	Accounts   int                       `json:"accounts"`
+	Mismatches int                       `json:"mismatches"`
+	Corrected  int                       `json:"corrected"`
+	Items      []*ReconciliationMismatch `json:"items"`
+}
+
+// Adjustment is a manual credit (positive Money) or debit (negative Money).
+type Adjustment struct {
+	UserID int     `json:"id"`
+	Money  float64 `json:"money"`
+	Reason string  `json:"reason"`
+	// Author is the API client who asked for it, it is not taken from the body.
+	Author string `json:"-"`
+}
+
+// OperationStatus is the answer to a successful balance operation.
+type OperationStatus struct {
+	Status      string       `json:"status"`
+	Transaction *Transaction `json:"transaction"`
+}
+
+// ErrorResponse is the answer to a failed request. Code is set for the known
+// errors, Limit for limit_exceeded, ReviewID for risk_review, PendingID for
+// approval_required.
+type ErrorResponse struct {
+	Error     string `json:"error"`
+	Code      string `json:"code,omitempty"`
+	Limit     string `json:"limit,omitempty"`
+	ReviewID  int    `json:"review_id,omitempty"`
+	PendingID int    `json:"pending_id,omitempty"`
+}
+
+// IdempotentResponse is the stored answer to a request with an idempotency key.
+type IdempotentResponse struct {
+	Status int
+	Body   []byte
+}
+
+// HistoryArchive is a month of the history moved from its partition to a
+// gzip JSON Lines file, one operation per line. File is relative to the
+// archive directory.
+type HistoryArchive struct {
+	Partition  string    `json:"partition"`
+	From       time.Time `json:"from"`
+	To         time.Time `json:"to"`
+	Rows       int64     `json:"rows"`
+	MinID      *int      `json:"min_id,omitempty"`
+	MaxID      *int      `json:"max_id,omitempty"`
+	File       string    `json:"file"`
+	SHA256     string    `json:"sha256"`
+	ArchivedAt time.Time `json:"archived_at"`
+}
+
+// ArchiveManifest lists the archive files, it is written next to them.
+type ArchiveManifest struct {
+	Archives []*HistoryArchive `json:"archives"`
+}
+
+// RiskVerdict is the objection of one risk rule to an operation.
+type RiskVerdict struct {
+	Rule     string `json:"rule"`
+	Decision string `json:"decision"`
+	Reason   string `json:"reason"`
+}
+
+// RiskDecision records how the risk checks decided on a withdrawal or a
+// transfer. TransactionID is set for the allowed ones, ReviewID for the held
+// ones and the decisions of the reviewers.
+type RiskDecision struct {
+	ID            int            `json:"id"`
+	Operation     string         `json:"operation"`
+	UserID        int            `json:"id_from"`
+	ToUserID      *int           `json:"id_to,omitempty"`
+	Money         float64        `json:"money"`
+	Decision      string         `json:"decision"`
+	Verdicts      []*RiskVerdict `json:"verdicts"`
+	TransactionID *int           `json:"transaction_id,omitempty"`
+	ReviewID      *int           `json:"review_id,omitempty"`
+	Created       time.Time      `json:"created"`
+}
+
+// RiskReview is an operation held by the risk checks until a reviewer other
+// than RequestedBy approves or rejects it, Held of its money waits on the
+// account of the payer meanwhile. Currency and Rate are like the ones of a
+// PendingOperation.
+type RiskReview struct {
+	ID            int            `json:"id"`
+	Operation     string         `json:"operation"`
+	UserID        int            `json:"id_from"`
+	ToUserID      *int           `json:"id_to,omitempty"`
+	Money         float64        `json:"money"`
+	Verdicts      []*RiskVerdict `json:"verdicts"`
+	Status        string         `json:"status"`
+	Created       time.Time      `json:"created"`
+	Reviewer      *string        `json:"reviewer,omitempty"`
+	DecidedAt     *time.Time     `json:"decided_at,omitempty"`
+	TransactionID *int           `json:"transaction_id,omitempty"`
+	Service       string         `json:"service,omitempty"`
+	Legs          []*SplitLeg    `json:"legs,omitempty"`
+	Currency      string         `json:"currency,omitempty"`
+	Rate          float64        `json:"rate,omitempty"`
+	RequestedBy   string         `json:"requested_by,omitempty"`
+	Held          float64        `json:"held"`
+}
+
+// PendingOperation is a large withdrawal, transfer or split payment to Legs
+// waiting for another admin to approve it, Held of its money is held on the
+// account of the payer meanwhile: a withdrawal pays with its bonuses first. Money is in RUB, Currency is what it was asked in and
+// Rate its price in RUB, both empty for roubles; the fee rules of Currency
+// apply.
+type PendingOperation struct {
+	ID            int             `json:"id"`
+	Operation     string          `json:"operation"`
+	UserID        int             `json:"id_from"`
+	ToUserID      *int            `json:"id_to,omitempty"`
+	Money         float64         `json:"money"`
+	Status        string          `json:"status"`
+	RequestedBy   string          `json:"requested_by,omitempty"`
+	DecidedBy     *string         `json:"decided_by,omitempty"`
+	Created       time.Time       `json:"created"`
+	ExpiresAt     time.Time       `json:"expires_at"`
+	DecidedAt     *time.Time      `json:"decided_at,omitempty"`
+	TransactionID *int            `json:"transaction_id,omitempty"`
+	Service       string          `json:"service,omitempty"`
+	Legs          []*SplitLeg     `json:"legs,omitempty"`
+	Currency      string          `json:"currency,omitempty"`
+	Rate          float64         `json:"rate,omitempty"`
+	Held          float64         `json:"held"`
+	Events        []*PendingEvent `json:"events,omitempty"`
+}
+
+// PendingEvent is a state transition of a pending operation. Actor is empty
+// for the expiry.
+type PendingEvent struct {
+	Status        string    `json:"status"`
+	Actor         *string   `json:"actor,omitempty"`
+	TransactionID *int      `json:"transaction_id,omitempty"`
+	Created       time.Time `json:"created"`
+}
+
+// BonusGrant is promotional money of an account. It is spent before the real
+// money on the withdrawals for Services, for any service when there are none,
+// until ExpiresAt.
+type BonusGrant struct {
+	ID        int       `json:"id"`
+	UserID    int       `json:"user_id"`
+	Amount    float64   `json:"amount"`
+	Remaining float64   `json:"remaining"`
+	Services  []string  `json:"services"`
+	Status    string    `json:"status"`
+	Created   time.Time `json:"created"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// BonusRequest grants Amount of bonus money for Days days.
+type BonusRequest struct {
+	UserID   int      `json:"id"`
+	Amount   float64  `json:"amount"`
+	Services []string `json:"services,omitempty"`
+	Days     int      `json:"days"`
+}
+
+// Rate is the price of one unit of Currency in RUB on Day.
+type Rate struct {
+	Day      time.Time `json:"day"`
+	Currency string    `json:"currency"`
+	Rate     float64   `json:"rate"`
+}
+
+// RateImport is the result of storing the rates.
+type RateImport struct {
+	Imported int `json:"imported"`
+}
@@ -0,0 +1,158 @@
+package transaction
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// DefaultLimitsID is the limits row applied to every account without an override.
+const DefaultLimitsID = 0
+
+const (
+	LimitMaxOperation    = "max_operation"
+	LimitDailyDebit      = "daily_debit"
+	LimitMonthlyDebit    = "monthly_debit"
+	LimitHourlyTransfers = "hourly_transfers"
+)
+
+func (r *RepositoryItem) GetLimits(ctx context.Context, userID int) (*Limits, error) {
+	ctx, cancel := r.operation(ctx)
+	defer cancel()
+
+	l := &Limits{UserID: userID}
+	err := r.DB.QueryRowContext(ctx, "SELECT max_operation, daily_debit, monthly_debit, hourly_transfers FROM limits WHERE user_id = $1",
+		userID).Scan(&l.MaxOperation, &l.DailyDebit, &l.MonthlyDebit, &l.HourlyTransfers)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	return l, nil
+}
+
+func (r *RepositoryItem) SetLimits(ctx context.Context, l *Limits) (*Limits, error) {
+	ctx, cancel := r.operation(ctx)
+	defer cancel()
+
+	if l.UserID < 0 {
+		return nil, ErrBadAccountID
+	}
+
+	for _, value := range []*float64{l.MaxOperation, l.DailyDebit, l.MonthlyDebit} {
+		if value != nil && *value < 0 {
+			return nil, ErrNegativeAmount
+		}
+	}
+	if l.HourlyTransfers != nil && *l.HourlyTransfers < 0 {
+		return nil, ErrNegativeAmount
+	}
+
+	_, err := r.DB.ExecContext(ctx, "INSERT INTO limits (user_id, max_operation, daily_debit, monthly_debit, hourly_transfers) "+
+		"VALUES ($1, $2, $3, $4, $5) ON CONFLICT (user_id) DO UPDATE SET max_operation = EXCLUDED.max_operation, "+
+		"daily_debit = EXCLUDED.daily_debit, monthly_debit = EXCLUDED.monthly_debit, hourly_transfers = EXCLUDED.hourly_transfers",
+		l.UserID, l.MaxOperation, l.DailyDebit, l.MonthlyDebit, l.HourlyTransfers)
+	if err != nil {
+		return nil, err
+	}
+
+	return l, nil
+}
+
+func (r *RepositoryItem) DeleteLimits(ctx context.Context, userID int) error {
+	ctx, cancel := r.operation(ctx)
+	defer cancel()
+
+	_, err := r.DB.ExecContext(ctx, "DELETE FROM limits WHERE user_id = $1", userID)
+	return err
+}
+
+// effectiveLimits takes the defaults and replaces the fields set in the account override.
+func effectiveLimits(userID int, db TransactionInterface) (*Limits, error) {
+	rows, err := db.Query("SELECT user_id, max_operation, daily_debit, monthly_debit, hourly_transfers FROM limits "+
+		"WHERE user_id = $1 OR user_id = $2 ORDER BY user_id", DefaultLimitsID, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	l := &Limits{UserID: userID}
+	for rows.Next() {
+		curr := &Limits{}
+		err = rows.Scan(&curr.UserID, &curr.MaxOperation, &curr.DailyDebit, &curr.MonthlyDebit, &curr.HourlyTransfers)
+		if err != nil {
+			return nil, err
+		}
+
+		if curr.MaxOperation != nil {
+			l.MaxOperation = curr.MaxOperation
+		}
+		if curr.DailyDebit != nil {
+			l.DailyDebit = curr.DailyDebit
+		}
+		if curr.MonthlyDebit != nil {
+			l.MonthlyDebit = curr.MonthlyDebit
+		}
+		if curr.HourlyTransfers != nil {
+			l.HourlyTransfers = curr.HourlyTransfers
+		}
+	}
+
+	return l, rows.Err()
+}
+
+// checkLimits runs in the debit transaction after the account row is locked,
+// so concurrent debits of one account are counted one after another. money is
+// the whole debit, the part paid with bonuses included, and the bonuses spent
+// count to the debit limits as well.
+// Days and months are counted in UTC, fees are not counted. A split payment
+// is counted by its legs for the debit limits and as one transfer.
+func (r *RepositoryItem) checkLimits(userID int, money float64, transfer bool, db TransactionInterface) error {
+	l, err := effectiveLimits(userID, db)
+	if err != nil {
+		return err
+	}
+
+	if l.MaxOperation != nil && money > *l.MaxOperation {
+		return &LimitError{Limit: LimitMaxOperation}
+	}
+
+	now := r.now()
+	if l.DailyDebit != nil || l.MonthlyDebit != nil {
+		dayStart := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+		monthStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+
+		var daily, monthly float64
+		err = db.QueryRow("SELECT COALESCE(SUM(money) FILTER (WHERE created >= $2), 0), COALESCE(SUM(money), 0) FROM "+
+			"(SELECT ABS(money) AS money, created FROM transaction WHERE from_id = $1 AND refund_of IS NULL AND fee_of IS NULL "+
+			"AND (to_id IS NOT NULL OR money < 0) AND created >= $3 "+
+			"UNION ALL SELECT -amount, created FROM bonus_movements WHERE user_id = $1 AND kind = $4 AND created >= $3) AS debits",
+			userID, dayStart, monthStart, BonusSpend).Scan(&daily, &monthly)
+		if err != nil {
+			return err
+		}
+
+		if l.DailyDebit != nil && daily+money > *l.DailyDebit {
+			return &LimitError{Limit: LimitDailyDebit}
+		}
+		if l.MonthlyDebit != nil && monthly+money > *l.MonthlyDebit {
+			return &LimitError{Limit: LimitMonthlyDebit}
+		}
+	}
+
+	if transfer && l.HourlyTransfers != nil {
+		var transfers int
+		err = db.QueryRow("SELECT COUNT(*) FROM transaction "+
+			"WHERE from_id = $1 AND (to_id IS NOT NULL OR money > 0) AND refund_of IS NULL AND fee_of IS NULL "+
+			"AND split_of IS NULL AND created > $2",
+			userID, now.Add(-time.Hour)).Scan(&transfers)
+		if err != nil {
+			return err
+		}
+
+		if transfers+1 > *l.HourlyTransfers {
+			return &LimitError{Limit: LimitHourlyTransfers}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,311 @@
+package transaction
+
+import (
+	"errors"
+	"fmt"
+	sqlmock "gopkg.in/DATA-DOG/go-sqlmock.v1"
+	"reflect"
+	"testing"
+	"time"
+)
+
+var limitsColumnNames = []string{"user_id", "max_operation", "daily_debit", "monthly_debit", "hourly_transfers"}
+
+func TestGetLimits(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("cant create mock: %s", err)
+	}
+	defer db.Close()
+	repo := NewRepository(db)
+
+	maxOperation := 100.0
+	transfers := 5
+	expect := &Limits{UserID: 1, MaxOperation: &maxOperation, HourlyTransfers: &transfers}
+
+	mock.
+		ExpectQuery("SELECT max_operation, daily_debit, monthly_debit, hourly_transfers FROM limits WHERE").
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"max_operation", "daily_debit", "monthly_debit", "hourly_transfers"}).
+			AddRow(maxOperation, nil, nil, transfers))
+
+	l, err := repo.GetLimits(ctx, 1)
+	if err != nil {
+		t.Errorf("unexpected err: %s", err)
+		return
+	}
+	if !reflect.DeepEqual(l, expect) {
+		t.Errorf("results not match, want %v, have %v", expect, l)
+		return
+	}
+
+	// no row means no limits
+	mock.
+		ExpectQuery("SELECT max_operation, daily_debit, monthly_debit, hourly_transfers FROM limits WHERE").
+		WithArgs(2).
+		WillReturnRows(sqlmock.NewRows([]string{"max_operation", "daily_debit", "monthly_debit", "hourly_transfers"}))
+
+	l, err = repo.GetLimits(ctx, 2)
+	if err != nil {
+		t.Errorf("unexpected err: %s", err)
+		return
+	}
+	if !reflect.DeepEqual(l, &Limits{UserID: 2}) {
+		t.Errorf("expected empty limits, got %v", l)
+		return
+	}
+
+	// query error
+	mock.
+		ExpectQuery("SELECT max_operation, daily_debit, monthly_debit, hourly_transfers FROM limits WHERE").
+		WithArgs(1).
+		WillReturnError(fmt.Errorf("db_error"))
+
+	_, err = repo.GetLimits(ctx, 1)
+	if err == nil {
+		t.Errorf("expected error, got nil")
+		return
+	}
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestSetLimits(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("cant create mock: %s", err)
+	}
+	defer db.Close()
+	repo := NewRepository(db)
+
+	daily := 1000.0
+	l := &Limits{UserID: DefaultLimitsID, DailyDebit: &daily}
+
+	mock.
+		ExpectExec("INSERT INTO limits").
+		WithArgs(DefaultLimitsID, nil, &daily, nil, nil).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	res, err := repo.SetLimits(ctx, l)
+	if err != nil {
+		t.Errorf("unexpected err: %s", err)
+		return
+	}
+	if !reflect.DeepEqual(res, l) {
+		t.Errorf("results not match, want %v, have %v", l, res)
+		return
+	}
+
+	// negative value
+	negative := -1
+	_, err = repo.SetLimits(ctx, &Limits{UserID: 1, HourlyTransfers: &negative})
+	if err != ErrNegativeAmount {
+		t.Errorf("expected ErrNegativeAmount, got %v", err)
+		return
+	}
+
+	// bad id
+	_, err = repo.SetLimits(ctx, &Limits{UserID: -1})
+	if err != ErrBadAccountID {
+		t.Errorf("expected ErrBadAccountID, got %v", err)
+		return
+	}
+
+	// delete
+	mock.
+		ExpectExec("DELETE FROM limits WHERE").
+		WithArgs(1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	err = repo.DeleteLimits(ctx, 1)
+	if err != nil {
+		t.Errorf("unexpected err: %s", err)
+		return
+	}
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestCheckLimits(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("cant create mock: %s", err)
+	}
+	defer db.Close()
+	repo := NewRepository(db)
+	repo.Clock = testClock
+
+	dayStart := time.Date(2021, 11, 27, 0, 0, 0, 0, time.UTC)
+	monthStart := time.Date(2021, 11, 1, 0, 0, 0, 0, time.UTC)
+
+	// the override replaces the default operation limit only
+	mock.
+		ExpectQuery("SELECT user_id, max_operation, daily_debit, monthly_debit, hourly_transfers FROM limits").
+		WithArgs(DefaultLimitsID, 1).
+		WillReturnRows(sqlmock.NewRows(limitsColumnNames).
+			AddRow(DefaultLimitsID, 10.0, 100.0, nil, nil).
+			AddRow(1, 50.0, nil, nil, nil))
+	mock.
+		ExpectQuery("SELECT COALESCE").
+		WithArgs(1, dayStart, monthStart, BonusSpend).
+		WillReturnRows(sqlmock.NewRows([]string{"daily", "monthly"}).AddRow(30.0, 30.0))
+
+	err = repo.checkLimits(1, 40, false, db)
+	if err != nil {
+		t.Errorf("unexpected err: %s", err)
+		return
+	}
+
+	// operation limit
+	mock.
+		ExpectQuery("SELECT user_id, max_operation, daily_debit, monthly_debit, hourly_transfers FROM limits").
+		WithArgs(DefaultLimitsID, 1).
+		WillReturnRows(sqlmock.NewRows(limitsColumnNames).
+			AddRow(DefaultLimitsID, 10.0, nil, nil, nil))
+
+	err = repo.checkLimits(1, 40, false, db)
+	if !errors.Is(err, ErrLimitExceeded) || err.(*LimitError).Limit != LimitMaxOperation {
+		t.Errorf("expected max_operation limit error, got %v", err)
+		return
+	}
+
+	// daily and monthly limits
+	cases := []struct {
+		daily   interface{}
+		monthly interface{}
+		limit   string
+	}{
+		{100.0, nil, LimitDailyDebit},
+		{nil, 100.0, LimitMonthlyDebit},
+	}
+	for _, c := range cases {
+		mock.
+			ExpectQuery("SELECT user_id, max_operation, daily_debit, monthly_debit, hourly_transfers FROM limits").
+			WithArgs(DefaultLimitsID, 1).
+			WillReturnRows(sqlmock.NewRows(limitsColumnNames).
+				AddRow(DefaultLimitsID, nil, c.daily, c.monthly, nil))
+		mock.
+			ExpectQuery("SELECT COALESCE").
+			WithArgs(1, dayStart, monthStart, BonusSpend).
+			WillReturnRows(sqlmock.NewRows([]string{"daily", "monthly"}).AddRow(70.0, 70.0))
+
+		err = repo.checkLimits(1, 40, false, db)
+		if !errors.Is(err, ErrLimitExceeded) || err.(*LimitError).Limit != c.limit {
+			t.Errorf("expected %s limit error, got %v", c.limit, err)
+			return
+		}
+	}
+
+	// transfers per hour are not counted for withdrawals
+	mock.
+		ExpectQuery("SELECT user_id, max_operation, daily_debit, monthly_debit, hourly_transfers FROM limits").
+		WithArgs(DefaultLimitsID, 1).
+		WillReturnRows(sqlmock.NewRows(limitsColumnNames).
+			AddRow(DefaultLimitsID, nil, nil, nil, 2))
+
+	err = repo.checkLimits(1, 40, false, db)
+	if err != nil {
+		t.Errorf("unexpected err: %s", err)
+		return
+	}
+
+	mock.
+		ExpectQuery("SELECT user_id, max_operation, daily_debit, monthly_debit, hourly_transfers FROM limits").
+		WithArgs(DefaultLimitsID, 1).
+		WillReturnRows(sqlmock.NewRows(limitsColumnNames).
+			AddRow(DefaultLimitsID, nil, nil, nil, 2))
+	mock.
+		ExpectQuery("SELECT COUNT").
+		WithArgs(1, testTime.Add(-time.Hour)).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+
+	err = repo.checkLimits(1, 40, true, db)
+	if !errors.Is(err, ErrLimitExceeded) || err.(*LimitError).Limit != LimitHourlyTransfers {
+		t.Errorf("expected hourly_transfers limit error, got %v", err)
+		return
+	}
+
+	// query error
+	mock.
+		ExpectQuery("SELECT user_id, max_operation, daily_debit, monthly_debit, hourly_transfers FROM limits").
+		WithArgs(DefaultLimitsID, 1).
+		WillReturnError(fmt.Errorf("db_error"))
+
+	err = repo.checkLimits(1, 40, true, db)
+	if err == nil {
+		t.Errorf("expected error, got nil")
+		return
+	}
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestWithdrawMoneyLimit(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("cant create mock: %s", err)
+	}
+	defer db.Close()
+	repo := NewRepository(db)
+
+	// the debit is rolled back
+	mock.ExpectBegin()
+	expectNoBonuses(mock)
+	mock.
+		ExpectQuery("SELECT balance, status, debit_blocked, credit_blocked, credit_limit FROM users WHERE").
+		WithArgs(1).
+		WillReturnRows(accountRows(100))
+	mock.
+		ExpectQuery("UPDATE users SET").
+		WithArgs(50.0, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"balance"}).AddRow(50.0))
+	mock.
+		ExpectQuery("SELECT user_id, max_operation, daily_debit, monthly_debit, hourly_transfers FROM limits").
+		WithArgs(DefaultLimitsID, 1).
+		WillReturnRows(sqlmock.NewRows(limitsColumnNames).
+			AddRow(DefaultLimitsID, 10.0, nil, nil, nil))
+	mock.ExpectRollback()
+
+	_, err = repo.WithdrawMoney(ctx, 1, 50)
+	if !errors.Is(err, ErrLimitExceeded) {
+		t.Errorf("expected ErrLimitExceeded, got %v", err)
+		return
+	}
+
+	// paid all with bonuses it still counts in full
+	mock.ExpectBegin()
+	mock.
+		ExpectQuery("SELECT id, remaining FROM bonus_grants WHERE").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "remaining"}).AddRow(7, 50.0))
+	mock.
+		ExpectQuery("SELECT balance, status, debit_blocked, credit_blocked, credit_limit FROM users WHERE").
+		WithArgs(1).
+		WillReturnRows(accountRows(100))
+	mock.
+		ExpectQuery("UPDATE users SET").
+		WithArgs(0.0, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"balance"}).AddRow(100.0))
+	mock.
+		ExpectQuery("SELECT user_id, max_operation, daily_debit, monthly_debit, hourly_transfers FROM limits").
+		WithArgs(DefaultLimitsID, 1).
+		WillReturnRows(sqlmock.NewRows(limitsColumnNames).
+			AddRow(DefaultLimitsID, 10.0, nil, nil, nil))
+	mock.ExpectRollback()
+
+	_, err = repo.WithdrawMoney(ctx, 1, 50)
+	limitErr := &LimitError{}
+	if !errors.As(err, &limitErr) || limitErr.Limit != LimitMaxOperation {
+		t.Errorf("expected max_operation limit error, got %v", err)
+		return
+	}
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
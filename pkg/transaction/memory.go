@@ -0,0 +1,623 @@
+package transaction
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// MemoryStorage keeps the ledger in memory, for tests and local runs. The
+// operations run one at a time and their changes are applied together, like
+// transactions of the Postgres storage.
+type MemoryStorage struct {
+	Clock func() time.Time
+	// MaxRateAge is how much older than the asked day a stored rate may be,
+	// 0 takes the last rate however old.
+	MaxRateAge time.Duration
+
+	mu         sync.Mutex
+	accounts   map[int]*Account
+	history    []*Transaction
+	overdrafts []*OverdraftEvent
+	rates      map[string]map[time.Time]float64
+}
+
+func NewMemoryStorage() *MemoryStorage {
+	revenueRef := "system:revenue"
+	return &MemoryStorage{
+		Clock:      time.Now,
+		MaxRateAge: DefaultMaxRateAge,
+		rates:      map[string]map[time.Time]float64{},
+		accounts: map[int]*Account{
+			RevenueAccountID: {
+				ID:          RevenueAccountID,
+				ExternalRef: &revenueRef,
+				Status:      AccountActive,
+				Currencies:  []string{BaseCurrency},
+				CreatedAt:   time.Now().UTC().Truncate(time.Microsecond),
+			},
+		},
+	}
+}
+
+func (m *MemoryStorage) now() time.Time {
+	return m.Clock().UTC().Truncate(time.Microsecond)
+}
+
+func copyAccount(acc *Account) *Account {
+	c := *acc
+	c.Currencies = append([]string(nil), acc.Currencies...)
+	return &c
+}
+
+func copyTransaction(tr *Transaction) *Transaction {
+	c := *tr
+	return &c
+}
+
+// memoryTx holds the changes of one operation until commit.
+type memoryTx struct {
+	m          *MemoryStorage
+	accounts   map[int]*Account
+	history    []*Transaction
+	overdrafts []*OverdraftEvent
+}
+
+// update runs the operation and applies its changes unless it fails or ctx
+// ends meanwhile.
+func (m *MemoryStorage) update(ctx context.Context, operation func(tx *memoryTx) error) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	err := ctx.Err()
+	if err != nil {
+		return err
+	}
+
+	tx := &memoryTx{m: m, accounts: map[int]*Account{}}
+	err = operation(tx)
+	if err != nil {
+		return err
+	}
+
+	err = ctx.Err()
+	if err != nil {
+		return err
+	}
+
+	for id, acc := range tx.accounts {
+		m.accounts[id] = acc
+	}
+	m.history = append(m.history, tx.history...)
+	m.overdrafts = append(m.overdrafts, tx.overdrafts...)
+
+	return nil
+}
+
+// view runs a read under the lock.
+func (m *MemoryStorage) view(ctx context.Context, read func() error) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	err := ctx.Err()
+	if err != nil {
+		return err
+	}
+
+	return read()
+}
+
+// account returns the copy of the account the operation changes.
+func (tx *memoryTx) account(userID int) (*Account, error) {
+	if acc, ok := tx.accounts[userID]; ok {
+		return acc, nil
+	}
+
+	acc, ok := tx.m.accounts[userID]
+	if !ok {
+		return nil, ErrAccountNotFound
+	}
+
+	acc = copyAccount(acc)
+	tx.accounts[userID] = acc
+	return acc, nil
+}
+
+func (tx *memoryTx) createAccount(userID int, externalRef *string) *Account {
+	acc := &Account{
+		ID:          userID,
+		ExternalRef: externalRef,
+		Status:      AccountActive,
+		Currencies:  []string{BaseCurrency},
+		CreatedAt:   tx.m.now(),
+	}
+	tx.accounts[userID] = acc
+	return acc
+}
+
+func (tx *memoryTx) changeBalance(acc *Account, money float64) {
+	before := acc.Balance
+	acc.Balance += money
+
+	var event string
+	switch {
+	case before >= 0 && acc.Balance < 0:
+		event = OverdraftEntered
+	case before < 0 && acc.Balance >= 0:
+		event = OverdraftLeft
+	default:
+		return
+	}
+
+	tx.overdrafts = append(tx.overdrafts, &OverdraftEvent{
+		ID:      len(tx.m.overdrafts) + len(tx.overdrafts) + 1,
+		UserID:  acc.ID,
+		Event:   event,
+		Balance: acc.Balance,
+		Created: tx.m.now(),
+	})
+}
+
+func (tx *memoryTx) credit(userID int, money float64) (float64, error) {
+	if money < 0 {
+		return 0, ErrNegativeAmount
+	}
+
+	// the first deposit still opens an account implicitly
+	acc, err := tx.account(userID)
+	if err == ErrAccountNotFound {
+		acc, err = tx.createAccount(userID, nil), nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	err = acc.canCredit()
+	if err != nil {
+		return 0, err
+	}
+
+	tx.changeBalance(acc, money)
+	return acc.Balance, nil
+}
+
+func (tx *memoryTx) debit(userID int, money float64) (float64, error) {
+	if money < 0 {
+		return 0, ErrNegativeAmount
+	}
+
+	acc, err := tx.account(userID)
+	if err != nil {
+		return 0, err
+	}
+
+	err = acc.canDebit()
+	if err != nil {
+		return 0, err
+	}
+
+	if acc.Balance+acc.CreditLimit < money {
+		return 0, ErrNotEnoughMoney
+	}
+
+	tx.changeBalance(acc, -money)
+	return acc.Balance, nil
+}
+
+func (tx *memoryTx) write(toID, fromID *int, money float64, refundOf *int, balance float64) *Transaction {
+	tr := &Transaction{
+		ID:       len(tx.m.history) + len(tx.history) + 1,
+		ToID:     toID,
+		FromID:   fromID,
+		Money:    money,
+		Created:  tx.m.now(),
+		RefundOf: refundOf,
+	}
+	tx.history = append(tx.history, tr)
+
+	tr = copyTransaction(tr)
+	tr.Balance = &balance
+	return tr
+}
+
+func (m *MemoryStorage) GetUsersBalance(ctx context.Context, userID int, currency string) (*User, error) {
+	tr := &User{UserID: userID}
+	var creditLimit float64
+	err := m.view(ctx, func() error {
+		acc, ok := m.accounts[userID]
+		if !ok {
+			return ErrAccountNotFound
+		}
+		tr.Balance, creditLimit = acc.Balance, acc.CreditLimit
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// today's rate unless another date is asked for
+	day, ok := RateDate(ctx)
+	if !ok {
+		day = rateDay(m.now())
+	}
+	return convertBalance(ctx, tr, creditLimit, currency, func(ctx context.Context, currency string) (float64, error) {
+		return m.rateAt(ctx, currency, day)
+	})
+}
+
+// SaveRates stores the rates, the ones of a stored day and currency replace
+// it. Nothing is stored when one of them is bad.
+func (m *MemoryStorage) SaveRates(ctx context.Context, rates []*Rate) (*RateImport, error) {
+	if len(rates) == 0 {
+		return nil, ErrBadRates
+	}
+	for _, rate := range rates {
+		err := rate.validate()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err := m.view(ctx, func() error {
+		for _, rate := range rates {
+			if m.rates[rate.Currency] == nil {
+				m.rates[rate.Currency] = map[time.Time]float64{}
+			}
+			m.rates[rate.Currency][rateDay(rate.Day)] = rate.Rate
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &RateImport{Imported: len(rates)}, nil
+}
+
+// rateAt returns the rate of the currency on day or the last one stored before
+// it, at most MaxRateAge older. There are no live rates, only the stored ones.
+func (m *MemoryStorage) rateAt(ctx context.Context, currency string, day time.Time) (float64, error) {
+	var stored time.Time
+	var rate float64
+	err := m.view(ctx, func() error {
+		for d, r := range m.rates[currency] {
+			if !d.After(day) && (rate == 0 || d.After(stored)) {
+				stored, rate = d, r
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	if rate == 0 || tooOld(stored, day, m.MaxRateAge) {
+		return 0, ErrRateNotFound
+	}
+
+	return rate, nil
+}
+
+func (m *MemoryStorage) AddMoney(ctx context.Context, userID int, money float64) (*Transaction, error) {
+	var tr *Transaction
+	err := m.update(ctx, func(tx *memoryTx) error {
+		balance, err := tx.credit(userID, money)
+		if err != nil {
+			return err
+		}
+
+		tr = tx.write(&userID, nil, money, nil, balance)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return tr, nil
+}
+
+// inRoubles converts the money asked in the currency of the operation to RUB
+// at today's stored rate.
+func (m *MemoryStorage) inRoubles(ctx context.Context, money float64) (float64, error) {
+	code := OperationCurrency(ctx)
+	if code == "" || code == BaseCurrency {
+		return money, nil
+	}
+
+	rate, err := m.rateAt(ctx, code, rateDay(m.now()))
+	if err != nil {
+		return 0, err
+	}
+
+	return math.Round(money*rate*100) / 100, nil
+}
+
+func (m *MemoryStorage) WithdrawMoney(ctx context.Context, userID int, money float64) (*Transaction, error) {
+	money, err := m.inRoubles(ctx, money)
+	if err != nil {
+		return nil, err
+	}
+
+	var tr *Transaction
+	err = m.update(ctx, func(tx *memoryTx) error {
+		balance, err := tx.debit(userID, money)
+		if err != nil {
+			return err
+		}
+
+		tr = tx.write(nil, &userID, -money, nil, balance)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return tr, nil
+}
+
+func (m *MemoryStorage) TransferMoney(ctx context.Context, fromUserID int, toUserID int, money float64) (*Transaction, error) {
+	money, err := m.inRoubles(ctx, money)
+	if err != nil {
+		return nil, err
+	}
+
+	var tr *Transaction
+	err = m.update(ctx, func(tx *memoryTx) error {
+		balance, err := tx.debit(fromUserID, money)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.credit(toUserID, money)
+		if err != nil {
+			return err
+		}
+
+		tr = tx.write(&toUserID, &fromUserID, money, nil, balance)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return tr, nil
+}
+
+// RefundMoney returns money of a withdrawal or a transfer back to the payer.
+// Zero money refunds everything that is not refunded yet.
+func (m *MemoryStorage) RefundMoney(ctx context.Context, transactionID int, money float64) (*Transaction, error) {
+	if money < 0 {
+		return nil, ErrNegativeAmount
+	}
+
+	var tr *Transaction
+	err := m.update(ctx, func(tx *memoryTx) error {
+		if transactionID <= 0 || transactionID > len(m.history) {
+			return ErrTransactionNotFound
+		}
+		orig := m.history[transactionID-1]
+
+		// deposits have no payer, refunds are not refunded again
+		// and a split payment is refunded leg by leg
+		if orig.FromID == nil || orig.RefundOf != nil || orig.isSplit() {
+			return ErrNotRefundable
+		}
+
+		var refunded float64
+		for _, curr := range m.history {
+			if curr.RefundOf != nil && *curr.RefundOf == transactionID {
+				refunded += curr.Money
+			}
+		}
+
+		// withdrawals are stored with negative money
+		charged := math.Abs(orig.Money)
+		if money == 0 {
+			money = charged - refunded
+		}
+
+		if money <= 0 || refunded+money > charged {
+			return ErrRefundExceeded
+		}
+
+		if orig.ToID != nil {
+			_, err := tx.debit(*orig.ToID, money)
+			if err != nil {
+				return err
+			}
+		}
+
+		balance, err := tx.credit(*orig.FromID, money)
+		if err != nil {
+			return err
+		}
+
+		tr = tx.write(orig.FromID, orig.ToID, money, &transactionID, balance)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return tr, nil
+}
+
+func (m *MemoryStorage) GetTransaction(ctx context.Context, userID int, orderBy string) ([]*Transaction, error) {
+	info := make([]*Transaction, 0, 10)
+	err := m.view(ctx, func() error {
+		for _, curr := range m.history {
+			if (curr.ToID != nil && *curr.ToID == userID) || (curr.FromID != nil && *curr.FromID == userID) {
+				info = append(info, copyTransaction(curr))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return sortHistory(info, orderBy)
+}
+
+func (m *MemoryStorage) GetTransactionByID(ctx context.Context, transactionID int) (*Transaction, error) {
+	var tr *Transaction
+	err := m.view(ctx, func() error {
+		if transactionID <= 0 || transactionID > len(m.history) {
+			return ErrTransactionNotFound
+		}
+		tr = copyTransaction(m.history[transactionID-1])
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return tr, nil
+}
+
+func (m *MemoryStorage) CreateAccount(ctx context.Context, userID int, externalRef string) (*Account, error) {
+	if userID <= 0 {
+		return nil, ErrBadAccountID
+	}
+
+	var ref *string
+	if externalRef != "" {
+		ref = &externalRef
+	}
+
+	var acc *Account
+	err := m.update(ctx, func(tx *memoryTx) error {
+		for _, curr := range m.accounts {
+			if curr.ID == userID || (ref != nil && curr.ExternalRef != nil && *curr.ExternalRef == *ref) {
+				return ErrAccountExists
+			}
+		}
+
+		acc = copyAccount(tx.createAccount(userID, ref))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return acc, nil
+}
+
+func (m *MemoryStorage) GetAccount(ctx context.Context, userID int) (*Account, error) {
+	var acc *Account
+	err := m.view(ctx, func() error {
+		curr, ok := m.accounts[userID]
+		if !ok {
+			return ErrAccountNotFound
+		}
+		acc = copyAccount(curr)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return acc, nil
+}
+
+// FreezeAccount blocks debits and/or credits, both are blocked when none is chosen.
+func (m *MemoryStorage) FreezeAccount(ctx context.Context, userID int, debit, credit bool) (*Account, error) {
+	if !debit && !credit {
+		debit, credit = true, true
+	}
+
+	return m.updateAccount(ctx, userID, func(acc *Account) error {
+		acc.setBlocks(acc.DebitBlocked || debit, acc.CreditBlocked || credit)
+		return nil
+	})
+}
+
+// UnfreezeAccount lifts the chosen blocks, both are lifted when none is chosen.
+func (m *MemoryStorage) UnfreezeAccount(ctx context.Context, userID int, debit, credit bool) (*Account, error) {
+	if !debit && !credit {
+		debit, credit = true, true
+	}
+
+	return m.updateAccount(ctx, userID, func(acc *Account) error {
+		acc.setBlocks(acc.DebitBlocked && !debit, acc.CreditBlocked && !credit)
+		return nil
+	})
+}
+
+func (m *MemoryStorage) CloseAccount(ctx context.Context, userID int) (*Account, error) {
+	return m.updateAccount(ctx, userID, func(acc *Account) error {
+		if math.Abs(acc.Balance) >= reconcileTolerance {
+			return ErrAccountNotEmpty
+		}
+
+		closedAt := m.now()
+		acc.Status, acc.ClosedAt = AccountClosed, &closedAt
+		return nil
+	})
+}
+
+// SetCreditLimit lets the account be debited down to -limit. A lower limit
+// does not touch a balance that is already below it, only new debits fail.
+func (m *MemoryStorage) SetCreditLimit(ctx context.Context, userID int, limit float64) (*Account, error) {
+	if limit < 0 {
+		return nil, ErrNegativeAmount
+	}
+
+	return m.updateAccount(ctx, userID, func(acc *Account) error {
+		acc.CreditLimit = limit
+		return nil
+	})
+}
+
+func (m *MemoryStorage) GetOverdraftHistory(ctx context.Context, userID int) ([]*OverdraftEvent, error) {
+	events := make([]*OverdraftEvent, 0)
+	err := m.view(ctx, func() error {
+		for _, curr := range m.overdrafts {
+			if curr.UserID == userID {
+				event := *curr
+				events = append(events, &event)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
+
+func (acc *Account) setBlocks(debit, credit bool) {
+	acc.DebitBlocked, acc.CreditBlocked = debit, credit
+	acc.Status = AccountActive
+	if debit || credit {
+		acc.Status = AccountFrozen
+	}
+}
+
+// updateAccount changes an open account, closed accounts stay as they are.
+func (m *MemoryStorage) updateAccount(ctx context.Context, userID int, update func(acc *Account) error) (*Account, error) {
+	var acc *Account
+	err := m.update(ctx, func(tx *memoryTx) error {
+		curr, err := tx.account(userID)
+		if err != nil {
+			return err
+		}
+		if curr.Status == AccountClosed {
+			return ErrAccountClosed
+		}
+
+		err = update(curr)
+		if err != nil {
+			return err
+		}
+
+		acc = copyAccount(curr)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return acc, nil
+}
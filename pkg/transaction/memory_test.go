@@ -0,0 +1,13 @@
+package transaction_test
+
+import (
+	"autumn-2021-intern-assignment/pkg/transaction"
+	"autumn-2021-intern-assignment/pkg/transaction/storagetest"
+	"testing"
+)
+
+func TestMemoryStorage(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) transaction.Storage {
+		return transaction.NewMemoryStorage()
+	})
+}
@@ -0,0 +1,103 @@
+package transaction
+
+import (
+	"errors"
+	"time"
+)
+
+// OperationSplit is a split payment held for approval, its legs are stored
+// with it.
+const OperationSplit = "split"
+
+// payment is a withdrawal, a transfer or a split payment to be made. The API
+// calls, the approved pending operations and reviews, the batches and the
+// schedules all make it with pay, so a debit is checked and paid the same
+// whichever way it comes.
+type payment struct {
+	Operation string
+	UserID    int
+	ToUserID  *int
+	Money     float64
+	// Service is what a withdrawal pays for, it decides the usable bonuses.
+	Service string
+	// Legs are the recipients of a split payment, each with its fixed Money.
+	Legs []*SplitLeg
+	// RequestedBy is who asked for the payment, it can't approve it.
+	RequestedBy string
+	// Currency is what a withdrawal or a transfer was asked in, Money is
+	// already in RUB.
+	Currency opCurrency
+}
+
+// pay makes the payment in db. A withdrawal spends the bonuses of its service
+// first.
+func (r *RepositoryItem) pay(p *payment, db TransactionInterface) (*Transaction, error) {
+	switch p.Operation {
+	case OperationTransfer:
+		return r.transfer(p.UserID, *p.ToUserID, p.Money, p.Currency, db)
+	case OperationSplit:
+		return r.split(p.UserID, p.Legs, p.Money, db)
+	}
+	return r.withdrawBonusFirst(p.UserID, p.Money, p.Service, p.Currency, db)
+}
+
+// debit makes the payment and screens it with the risk rules unless it is too
+// large to run without approval. Then it is screened before it is stored, its
+// money is held in db and a *PendingError is returned, db has to be committed
+// to keep the hold. A payment the rules stopped returns *RiskError, db has to
+// be rolled back and the error recorded with recordRisk: a held one waits for
+// the review instead of the approval.
+func (r *RepositoryItem) debit(p *payment, db TransactionInterface) (*Transaction, error) {
+	if !r.needsApproval(p.Money) {
+		tr, err := r.pay(p, db)
+		if err != nil {
+			return nil, err
+		}
+		err = r.screen(p.risk(tr.Created), tr, db)
+		if err != nil {
+			return nil, err
+		}
+		return tr, nil
+	}
+
+	err := r.screen(p.risk(r.now()), nil, db)
+	if err != nil {
+		return nil, err
+	}
+
+	pending := &PendingOperation{
+		Operation:   p.Operation,
+		UserID:      p.UserID,
+		ToUserID:    p.ToUserID,
+		Money:       p.Money,
+		RequestedBy: p.RequestedBy,
+		Service:     p.Service,
+		Legs:        p.Legs,
+		Currency:    p.Currency.Code,
+		Rate:        p.Currency.Rate,
+	}
+	err = r.hold(pending, db)
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, &PendingError{Pending: pending}
+}
+
+// isHeld tells the debit was held for approval.
+func isHeld(err error) bool {
+	pendingErr := &PendingError{}
+	return errors.As(err, &pendingErr)
+}
+
+// isStopped tells the debit was stopped by the risk rules.
+func isStopped(err error) bool {
+	riskErr := &RiskError{}
+	return errors.As(err, &riskErr)
+}
+
+// risk is the payment made at the given time as the risk rules see it.
+func (p *payment) risk(at time.Time) *RiskOperation {
+	return &RiskOperation{Operation: p.Operation, UserID: p.UserID, ToUserID: p.ToUserID, Money: p.Money, At: at,
+		Service: p.Service, Legs: p.Legs, Currency: p.Currency.Code, Rate: p.Currency.Rate, RequestedBy: p.RequestedBy}
+}
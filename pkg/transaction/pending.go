@@ -0,0 +1,477 @@
+package transaction
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+// Statuses of an operation waiting for approval.
+const (
+	PendingWaiting  = "pending"
+	PendingApproved = "approved"
+	PendingRejected = "rejected"
+	PendingExpired  = "expired"
+)
+
+// DefaultApprovalTTL is how long an operation waits for approval when
+// ApprovalTTL is not set.
+const DefaultApprovalTTL = 24 * time.Hour
+
+type requesterCtx struct{}
+
+// WithRequester names who asks for the operations made with ctx, the same
+// admin can't approve them.
+func WithRequester(ctx context.Context, requester string) context.Context {
+	return context.WithValue(ctx, requesterCtx{}, requester)
+}
+
+// Requester is the name given to ctx by WithRequester.
+func Requester(ctx context.Context) string {
+	name, _ := ctx.Value(requesterCtx{}).(string)
+	return name
+}
+
+// PendingError is a debit stored to wait for approval, its money is held
+// meanwhile.
+type PendingError struct {
+	Pending *PendingOperation
+}
+
+func (e *PendingError) Error() string {
+	return ErrApprovalRequired.Error()
+}
+
+func (e *PendingError) Is(target error) bool {
+	return target == ErrApprovalRequired
+}
+
+// needsApproval tells whether the debit is too large to run without approval.
+func (r *RepositoryItem) needsApproval(money float64) bool {
+	return r.ApprovalThreshold > 0 && money > r.ApprovalThreshold
+}
+
+// hold stores the operation as pending and holds its money on the account of
+// the payer until it is approved, rejected or expires.
+func (r *RepositoryItem) hold(p *PendingOperation, db TransactionInterface) error {
+	acc, err := lockAccount(p.UserID, db)
+	if err != nil {
+		return err
+	}
+	err = acc.canDebit()
+	if err != nil {
+		return err
+	}
+
+	recipients := make([]int, 0, len(p.Legs)+1)
+	if p.ToUserID != nil {
+		recipients = append(recipients, *p.ToUserID)
+	}
+	for _, leg := range p.Legs {
+		recipients = append(recipients, leg.ToUserID)
+	}
+	for _, toUserID := range recipients {
+		to, err := lockAccount(toUserID, db)
+		if err != nil {
+			return err
+		}
+		err = to.canCredit()
+		if err != nil {
+			return err
+		}
+	}
+
+	var legs interface{}
+	if len(p.Legs) > 0 {
+		data, err := json.Marshal(p.Legs)
+		if err != nil {
+			return err
+		}
+		legs = data
+	}
+
+	p.Held, err = r.realShare(p.Operation, p.UserID, p.Money, p.Service, db)
+	if err != nil {
+		return err
+	}
+	err = holdMoney(p.UserID, p.Held, db)
+	if err != nil {
+		return err
+	}
+
+	ttl := r.ApprovalTTL
+	if ttl <= 0 {
+		ttl = DefaultApprovalTTL
+	}
+	p.Status = PendingWaiting
+	p.Created = r.now()
+	p.ExpiresAt = p.Created.Add(ttl)
+	err = db.QueryRow("INSERT INTO pending_operations (operation, user_id, to_id, money, status, requested_by, created, expires_at, "+
+		"service, legs, currency, rate, held) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13) returning id",
+		p.Operation, p.UserID, p.ToUserID, p.Money, p.Status, sql.NullString{String: p.RequestedBy, Valid: p.RequestedBy != ""},
+		p.Created, p.ExpiresAt, p.Service, legs, p.Currency, p.Rate, p.Held).Scan(&p.ID)
+	if err != nil {
+		return err
+	}
+
+	return r.writePendingEvent(p, p.RequestedBy, db)
+}
+
+// holdMoney moves money of the account from available to held, the balance
+// stays the same.
+func holdMoney(userID int, money float64, db TransactionInterface) error {
+	var held float64
+	err := db.QueryRow("UPDATE users SET held = held + $1 WHERE id = $2 AND balance + credit_limit - held >= $1 returning held",
+		money, userID).Scan(&held)
+	if err == sql.ErrNoRows {
+		return ErrNotEnoughMoney
+	}
+	return err
+}
+
+func releaseMoney(userID int, money float64, db TransactionInterface) error {
+	_, err := db.Exec("UPDATE users SET held = GREATEST(held - $1, 0) WHERE id = $2", money, userID)
+	return err
+}
+
+func (r *RepositoryItem) writePendingEvent(p *PendingOperation, actor string, db TransactionInterface) error {
+	at := r.now()
+	var by *string
+	if actor != "" {
+		by = &actor
+	}
+
+	// the hold and the release show in the history of the payer as rows
+	// without money, like the write-offs of the bonuses
+	row, err := r.writeTransaction(nil, &p.UserID, 0, nil, db)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec("INSERT INTO pending_events (pending_id, status, actor, transaction_id, created, history_id) "+
+		"VALUES ($1, $2, $3, $4, $5, $6)", p.ID, p.Status, by, p.TransactionID, at, row.ID)
+	if err != nil {
+		return err
+	}
+
+	p.Events = append(p.Events, &PendingEvent{Status: p.Status, Actor: by, TransactionID: p.TransactionID, Created: at})
+	return nil
+}
+
+// pendingHold is a hold or a release of the money of a pending operation.
+type pendingHold struct {
+	PendingID int
+	Status    string
+	Held      float64
+}
+
+// pendingHistory returns the holds and the releases of the pending operations
+// of the account by their history rows.
+func pendingHistory(ctx context.Context, userID int, db *sql.DB) (map[int]*pendingHold, error) {
+	rows, err := db.QueryContext(ctx, "SELECT e.history_id, e.pending_id, e.status, p.held FROM pending_events e "+
+		"JOIN pending_operations p ON p.id = e.pending_id WHERE p.user_id = $1 AND e.history_id IS NOT NULL", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	holds := make(map[int]*pendingHold)
+	for rows.Next() {
+		var historyID int
+		h := &pendingHold{}
+		err = rows.Scan(&historyID, &h.PendingID, &h.Status, &h.Held)
+		if err != nil {
+			return nil, err
+		}
+		if h.Status != PendingWaiting {
+			h.Held = -h.Held
+		}
+		holds[historyID] = h
+	}
+
+	return holds, rows.Err()
+}
+
+var pendingColumns = "id, operation, user_id, to_id, money, status, requested_by, decided_by, created, expires_at, " +
+	"decided_at, transaction_id, service, legs, currency, rate, held"
+
+func scanPending(row interface{ Scan(...interface{}) error }) (*PendingOperation, error) {
+	p := &PendingOperation{}
+	var requestedBy sql.NullString
+	var legs []byte
+	err := row.Scan(&p.ID, &p.Operation, &p.UserID, &p.ToUserID, &p.Money, &p.Status, &requestedBy, &p.DecidedBy,
+		&p.Created, &p.ExpiresAt, &p.DecidedAt, &p.TransactionID, &p.Service, &legs, &p.Currency, &p.Rate, &p.Held)
+	if err != nil {
+		return nil, err
+	}
+	if len(legs) > 0 {
+		err = json.Unmarshal(legs, &p.Legs)
+		if err != nil {
+			return nil, err
+		}
+	}
+	p.RequestedBy = requestedBy.String
+	p.Created = p.Created.UTC()
+	p.ExpiresAt = p.ExpiresAt.UTC()
+	if p.DecidedAt != nil {
+		decided := p.DecidedAt.UTC()
+		p.DecidedAt = &decided
+	}
+
+	return p, nil
+}
+
+// GetPendingOperations returns the operations with the given status, all of
+// them for the empty one, the oldest first. Events are not loaded.
+func (r *RepositoryItem) GetPendingOperations(ctx context.Context, status string) ([]*PendingOperation, error) {
+	ctx, cancel := r.operation(ctx)
+	defer cancel()
+
+	rows, err := r.DB.QueryContext(ctx, "SELECT "+pendingColumns+" FROM pending_operations WHERE $1 = '' OR status = $1 ORDER BY id",
+		status)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	pending := make([]*PendingOperation, 0)
+	for rows.Next() {
+		p, err := scanPending(rows)
+		if err != nil {
+			return nil, err
+		}
+		pending = append(pending, p)
+	}
+
+	return pending, rows.Err()
+}
+
+// GetPendingOperation returns the operation with its state transitions.
+func (r *RepositoryItem) GetPendingOperation(ctx context.Context, pendingID int) (*PendingOperation, error) {
+	ctx, cancel := r.operation(ctx)
+	defer cancel()
+
+	db := withContext(ctx, r.DB)
+	p, err := scanPending(db.QueryRow("SELECT "+pendingColumns+" FROM pending_operations WHERE id = $1", pendingID))
+	if err == sql.ErrNoRows {
+		return nil, ErrPendingNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	p.Events, err = pendingEvents(pendingID, db)
+	if err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+func pendingEvents(pendingID int, db TransactionInterface) ([]*PendingEvent, error) {
+	rows, err := db.Query("SELECT status, actor, transaction_id, created FROM pending_events WHERE pending_id = $1 ORDER BY id",
+		pendingID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := make([]*PendingEvent, 0)
+	for rows.Next() {
+		e := &PendingEvent{}
+		err = rows.Scan(&e.Status, &e.Actor, &e.TransactionID, &e.Created)
+		if err != nil {
+			return nil, err
+		}
+		e.Created = e.Created.UTC()
+		events = append(events, e)
+	}
+
+	return events, rows.Err()
+}
+
+// ApprovePendingOperation releases the held money and runs the operation as it
+// was requested, with the freezes, the limits and the risk checks of now; a
+// hold of the risk checks denies it. The approver has to be another admin than
+// the requester. When the operation fails the approval is rolled back and it
+// stays pending.
+func (r *RepositoryItem) ApprovePendingOperation(ctx context.Context, pendingID int, approver string) (*PendingOperation, error) {
+	return r.decidePending(ctx, pendingID, approver, PendingApproved)
+}
+
+// RejectPendingOperation releases the held money, the operation is not run.
+func (r *RepositoryItem) RejectPendingOperation(ctx context.Context, pendingID int, approver string) (*PendingOperation, error) {
+	return r.decidePending(ctx, pendingID, approver, PendingRejected)
+}
+
+func (r *RepositoryItem) decidePending(ctx context.Context, pendingID int, approver, status string) (*PendingOperation, error) {
+	if strings.TrimSpace(approver) == "" {
+		return nil, ErrBadApproval
+	}
+
+	ctx, cancel := r.operation(ctx)
+	defer cancel()
+
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	p, err := r.decidePendingTx(pendingID, approver, status, withContext(ctx, tx))
+	if err != nil {
+		//nolint:errcheck
+		tx.Rollback()
+		return nil, r.recordRisk(ctx, err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+func (r *RepositoryItem) decidePendingTx(pendingID int, approver, status string, db TransactionInterface) (*PendingOperation, error) {
+	p, err := scanPending(db.QueryRow("SELECT "+pendingColumns+" FROM pending_operations WHERE id = $1 FOR UPDATE", pendingID))
+	if err == sql.ErrNoRows {
+		return nil, ErrPendingNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if p.Status != PendingWaiting {
+		return nil, ErrPendingDecided
+	}
+	if !r.now().Before(p.ExpiresAt) {
+		return nil, ErrPendingExpired
+	}
+	if p.RequestedBy != "" && approver == p.RequestedBy {
+		return nil, ErrSameApprover
+	}
+
+	err = releaseMoney(p.UserID, p.Held, db)
+	if err != nil {
+		return nil, err
+	}
+
+	if status == PendingApproved {
+		pay := &payment{Operation: p.Operation, UserID: p.UserID, ToUserID: p.ToUserID, Money: p.Money,
+			RequestedBy: p.RequestedBy, Service: p.Service, Legs: p.Legs, Currency: opCurrency{Code: p.Currency, Rate: p.Rate}}
+		tr, err := r.pay(pay, db)
+		if err != nil {
+			return nil, err
+		}
+		// the rules see the operation again as it is paid now, an approved
+		// one can't wait for a review as well
+		err = r.screen(pay.risk(tr.Created), tr, db)
+		riskErr := &RiskError{}
+		if errors.As(err, &riskErr) && riskErr.Decision == RiskHold {
+			riskErr.Decision = RiskDeny
+			riskErr.Verdicts = append(riskErr.Verdicts, &RiskVerdict{Rule: "pending_approval", Decision: RiskDeny,
+				Reason: "an approved operation can't wait for a review"})
+		}
+		if err != nil {
+			return nil, err
+		}
+		p.TransactionID = &tr.ID
+	}
+
+	err = r.finishPending(p, approver, status, db)
+	if err != nil {
+		return nil, err
+	}
+
+	p.Events, err = pendingEvents(p.ID, db)
+	if err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+func (r *RepositoryItem) finishPending(p *PendingOperation, actor, status string, db TransactionInterface) error {
+	decided := r.now()
+	p.Status, p.DecidedAt = status, &decided
+	var by *string
+	if actor != "" {
+		by = &actor
+	}
+	p.DecidedBy = by
+
+	_, err := db.Exec("UPDATE pending_operations SET status = $2, decided_by = $3, decided_at = $4, transaction_id = $5 WHERE id = $1",
+		p.ID, p.Status, by, decided, p.TransactionID)
+	if err != nil {
+		return err
+	}
+
+	return r.writePendingEvent(p, actor, db)
+}
+
+// ExpirePendingOperations releases the money of the operations nobody decided
+// on in time, it returns them. Any number of instances may run it at once.
+func (r *RepositoryItem) ExpirePendingOperations(ctx context.Context) ([]*PendingOperation, error) {
+	ctx, cancel := r.operation(ctx)
+	defer cancel()
+
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	db := withContext(ctx, tx)
+
+	expired, err := r.expirePending(db)
+	if err != nil {
+		//nolint:errcheck
+		tx.Rollback()
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return expired, nil
+}
+
+func (r *RepositoryItem) expirePending(db TransactionInterface) ([]*PendingOperation, error) {
+	rows, err := db.Query("SELECT "+pendingColumns+" FROM pending_operations WHERE status = $1 AND expires_at <= $2 "+
+		"ORDER BY id FOR UPDATE SKIP LOCKED", PendingWaiting, r.now())
+	if err != nil {
+		return nil, err
+	}
+
+	expired := make([]*PendingOperation, 0)
+	for rows.Next() {
+		p, err := scanPending(rows)
+		if err != nil {
+			//nolint:errcheck
+			rows.Close()
+			return nil, err
+		}
+		expired = append(expired, p)
+	}
+	err = rows.Err()
+	//nolint:errcheck
+	rows.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, p := range expired {
+		err = releaseMoney(p.UserID, p.Held, db)
+		if err != nil {
+			return nil, err
+		}
+		err = r.finishPending(p, "", PendingExpired, db)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return expired, nil
+}
@@ -0,0 +1,351 @@
+package transaction
+
+import (
+	"context"
+	"errors"
+	sqlmock "gopkg.in/DATA-DOG/go-sqlmock.v1"
+	"reflect"
+	"testing"
+	"time"
+)
+
+var pendingColumnNames = []string{"id", "operation", "user_id", "to_id", "money", "status", "requested_by", "decided_by",
+	"created", "expires_at", "decided_at", "transaction_id", "service", "legs", "currency", "rate", "held"}
+
+// expectPendingHistory expects the history row of a transition of a pending
+// operation of the account.
+func expectPendingHistory(mock sqlmock.Sqlmock, userID, historyID int) {
+	mock.
+		ExpectQuery("INSERT INTO transaction").
+		WithArgs(nil, &userID, 0.0, testTime, nil).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created"}).AddRow(historyID, testTime))
+}
+
+func TestTransferMoneyApproval(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("cant create mock: %s", err)
+	}
+	defer db.Close()
+
+	repo := NewRepository(db)
+	repo.Clock = testClock
+	repo.ApprovalThreshold = 10000
+	repo.ApprovalTTL = time.Hour
+
+	// above the threshold the money is held and the transfer waits
+	mock.ExpectBegin()
+	mock.
+		ExpectQuery("SELECT balance, status, debit_blocked, credit_blocked, credit_limit FROM users WHERE").
+		WithArgs(1).
+		WillReturnRows(accountRows(20000))
+	mock.
+		ExpectQuery("SELECT balance, status, debit_blocked, credit_blocked, credit_limit FROM users WHERE").
+		WithArgs(2).
+		WillReturnRows(accountRows(0))
+	mock.
+		ExpectQuery("UPDATE users SET held = held \\+ \\$1 WHERE id = \\$2 AND balance \\+ credit_limit - held >= \\$1").
+		WithArgs(15000.0, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"held"}).AddRow(15000.0))
+	mock.
+		ExpectQuery("INSERT INTO pending_operations").
+		WithArgs(OperationTransfer, 1, 2, 15000.0, PendingWaiting, "shop", testTime, testTime.Add(time.Hour), "", nil, "", 0.0, 15000.0).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(4))
+	expectPendingHistory(mock, 1, 20)
+	mock.
+		ExpectExec("INSERT INTO pending_events \\(pending_id, status, actor, transaction_id, created, history_id\\)").
+		WithArgs(4, PendingWaiting, "shop", nil, testTime, 20).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	_, err = repo.TransferMoney(WithRequester(ctx, "shop"), 1, 2, 15000)
+	pendingErr := &PendingError{}
+	if !errors.Is(err, ErrApprovalRequired) || !errors.As(err, &pendingErr) || pendingErr.Pending.ID != 4 ||
+		pendingErr.Pending.Status != PendingWaiting || len(pendingErr.Pending.Events) != 1 {
+		t.Errorf("expected a pending transfer, got %v", err)
+		return
+	}
+
+	// not enough available money
+	mock.ExpectBegin()
+	mock.
+		ExpectQuery("SELECT balance, status, debit_blocked, credit_blocked, credit_limit FROM users WHERE").
+		WithArgs(1).
+		WillReturnRows(accountRows(20000))
+	mock.
+		ExpectQuery("SELECT balance, status, debit_blocked, credit_blocked, credit_limit FROM users WHERE").
+		WithArgs(2).
+		WillReturnRows(accountRows(0))
+	mock.
+		ExpectQuery("UPDATE users SET held = held").
+		WithArgs(15000.0, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"held"}))
+	mock.ExpectRollback()
+
+	_, err = repo.TransferMoney(ctx, 1, 2, 15000)
+	if !errors.Is(err, ErrNotEnoughMoney) {
+		t.Errorf("expected ErrNotEnoughMoney, got %v", err)
+		return
+	}
+
+	// a withdrawal holds only what its bonuses don't pay
+	mock.ExpectBegin()
+	mock.
+		ExpectQuery("SELECT balance, status, debit_blocked, credit_blocked, credit_limit FROM users WHERE").
+		WithArgs(1).
+		WillReturnRows(accountRows(5000))
+	mock.
+		ExpectQuery("SELECT id, remaining FROM bonus_grants WHERE").
+		WithArgs(1, testTime, "music").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "remaining"}).AddRow(7, 12000.0))
+	mock.
+		ExpectQuery("UPDATE users SET held = held").
+		WithArgs(3000.0, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"held"}).AddRow(3000.0))
+	mock.
+		ExpectQuery("INSERT INTO pending_operations").
+		WithArgs(OperationWithdraw, 1, nil, 15000.0, PendingWaiting, "shop", testTime, testTime.Add(time.Hour), "music", nil, "",
+			0.0, 3000.0).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(5))
+	expectPendingHistory(mock, 1, 21)
+	mock.
+		ExpectExec("INSERT INTO pending_events").
+		WithArgs(5, PendingWaiting, "shop", nil, testTime, 21).
+		WillReturnResult(sqlmock.NewResult(2, 1))
+	mock.ExpectCommit()
+
+	_, err = repo.WithdrawMoney(WithService(WithRequester(ctx, "shop"), "music"), 1, 15000)
+	if !errors.As(err, &pendingErr) || pendingErr.Pending.ID != 5 || pendingErr.Pending.Held != 3000 {
+		t.Errorf("expected a pending withdrawal holding 3000, got %v", err)
+		return
+	}
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestApprovePendingOperation(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("cant create mock: %s", err)
+	}
+	defer db.Close()
+
+	repo := NewRepository(db)
+	repo.Clock = testClock
+
+	created := testTime.Add(-time.Hour)
+	pendingRows := func() *sqlmock.Rows {
+		return sqlmock.NewRows(pendingColumnNames).
+			AddRow(4, OperationWithdraw, 1, nil, 15000.0, PendingWaiting, "shop", nil, created, testTime.Add(time.Hour), nil, nil,
+				"music", nil, "", 0.0, 14900.0)
+	}
+
+	// the requester can't approve it
+	mock.ExpectBegin()
+	mock.
+		ExpectQuery("SELECT id, operation, user_id, to_id, money, status, requested_by, decided_by, created, expires_at, " +
+			"decided_at, transaction_id, service, legs, currency, rate, held FROM pending_operations WHERE id = \\$1 FOR UPDATE").
+		WithArgs(4).
+		WillReturnRows(pendingRows())
+	mock.ExpectRollback()
+
+	_, err = repo.ApprovePendingOperation(ctx, 4, "shop")
+	if !errors.Is(err, ErrSameApprover) {
+		t.Errorf("expected ErrSameApprover, got %v", err)
+		return
+	}
+
+	// another admin releases the money and runs the operation, paying with the
+	// bonuses of its service first
+	elemID := 1
+	mock.ExpectBegin()
+	mock.
+		ExpectQuery("FROM pending_operations WHERE id = \\$1 FOR UPDATE").
+		WithArgs(4).
+		WillReturnRows(pendingRows())
+	mock.
+		ExpectExec("UPDATE users SET held = GREATEST\\(held - \\$1, 0\\) WHERE id = \\$2").
+		WithArgs(14900.0, 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.
+		ExpectQuery("SELECT id, remaining FROM bonus_grants WHERE").
+		WithArgs(1, testTime, "music").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "remaining"}).AddRow(7, 100.0))
+	mock.
+		ExpectQuery("SELECT balance, status, debit_blocked, credit_blocked, credit_limit FROM users WHERE").
+		WithArgs(1).
+		WillReturnRows(accountRows(20000))
+	mock.
+		ExpectQuery("UPDATE users SET balance = balance - \\$1 WHERE id = \\$2 AND balance \\+ credit_limit - held >= \\$1").
+		WithArgs(14900.0, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"balance"}).AddRow(5100.0))
+	expectNoLimits(mock)
+	mock.
+		ExpectQuery("INSERT INTO transaction").
+		WithArgs(nil, &elemID, -14900.0, testTime, nil).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created"}).AddRow(9, testTime))
+	expectNoFee(mock, OperationWithdraw)
+	mock.
+		ExpectExec("UPDATE bonus_grants SET remaining = remaining - \\$1 WHERE id = \\$2").
+		WithArgs(100.0, 7).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.
+		ExpectExec("INSERT INTO bonus_movements").
+		WithArgs(7, 1, BonusSpend, -100.0, 9, testTime).
+		WillReturnResult(sqlmock.NewResult(5, 1))
+	mock.
+		ExpectExec("UPDATE pending_operations SET status = \\$2, decided_by = \\$3, decided_at = \\$4, transaction_id = \\$5 WHERE id = \\$1").
+		WithArgs(4, PendingApproved, "alice", testTime, 9).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectPendingHistory(mock, 1, 22)
+	mock.
+		ExpectExec("INSERT INTO pending_events").
+		WithArgs(4, PendingApproved, "alice", 9, testTime, 22).
+		WillReturnResult(sqlmock.NewResult(2, 1))
+	mock.
+		ExpectQuery("SELECT status, actor, transaction_id, created FROM pending_events WHERE pending_id = \\$1 ORDER BY id").
+		WithArgs(4).
+		WillReturnRows(sqlmock.NewRows([]string{"status", "actor", "transaction_id", "created"}).
+			AddRow(PendingWaiting, "shop", nil, created).
+			AddRow(PendingApproved, "alice", 9, testTime))
+	mock.ExpectCommit()
+
+	p, err := repo.ApprovePendingOperation(ctx, 4, "alice")
+	if err != nil {
+		t.Errorf("unexpected err: %s", err)
+		return
+	}
+	shop, alice, transactionID := "shop", "alice", 9
+	expect := &PendingOperation{
+		ID:            4,
+		Operation:     OperationWithdraw,
+		UserID:        1,
+		Money:         15000,
+		Status:        PendingApproved,
+		RequestedBy:   "shop",
+		DecidedBy:     &alice,
+		Created:       created,
+		ExpiresAt:     testTime.Add(time.Hour),
+		DecidedAt:     &testTime,
+		TransactionID: &transactionID,
+		Service:       "music",
+		Held:          14900,
+		Events: []*PendingEvent{
+			{Status: PendingWaiting, Actor: &shop, Created: created},
+			{Status: PendingApproved, Actor: &alice, TransactionID: &transactionID, Created: testTime},
+		},
+	}
+	if !reflect.DeepEqual(p, expect) {
+		t.Errorf("results not match, want %v, have %v", expect, p)
+		return
+	}
+
+	// an expired one can't be decided
+	mock.ExpectBegin()
+	mock.
+		ExpectQuery("FROM pending_operations WHERE id = \\$1 FOR UPDATE").
+		WithArgs(4).
+		WillReturnRows(sqlmock.NewRows(pendingColumnNames).
+			AddRow(4, OperationWithdraw, 1, nil, 15000.0, PendingWaiting, "shop", nil, created, testTime, nil, nil, "", nil, "", 0.0, 15000.0))
+	mock.ExpectRollback()
+
+	_, err = repo.RejectPendingOperation(ctx, 4, "alice")
+	if !errors.Is(err, ErrPendingExpired) {
+		t.Errorf("expected ErrPendingExpired, got %v", err)
+		return
+	}
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestExpirePendingOperations(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("cant create mock: %s", err)
+	}
+	defer db.Close()
+
+	repo := NewRepository(db)
+	repo.Clock = testClock
+
+	toID := 2
+	mock.ExpectBegin()
+	mock.
+		ExpectQuery("FROM pending_operations WHERE status = \\$1 AND expires_at <= \\$2 ORDER BY id FOR UPDATE SKIP LOCKED").
+		WithArgs(PendingWaiting, testTime).
+		WillReturnRows(sqlmock.NewRows(pendingColumnNames).
+			AddRow(5, OperationTransfer, 1, toID, 30000.0, PendingWaiting, nil, nil, testTime.Add(-25*time.Hour),
+				testTime.Add(-time.Hour), nil, nil, "", nil, "", 0.0, 30000.0))
+	mock.
+		ExpectExec("UPDATE users SET held = GREATEST\\(held - \\$1, 0\\) WHERE id = \\$2").
+		WithArgs(30000.0, 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.
+		ExpectExec("UPDATE pending_operations SET status").
+		WithArgs(5, PendingExpired, nil, testTime, nil).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectPendingHistory(mock, 1, 23)
+	mock.
+		ExpectExec("INSERT INTO pending_events").
+		WithArgs(5, PendingExpired, nil, nil, testTime, 23).
+		WillReturnResult(sqlmock.NewResult(3, 1))
+	mock.ExpectCommit()
+
+	expired, err := repo.ExpirePendingOperations(context.Background())
+	if err != nil || len(expired) != 1 || expired[0].ID != 5 || expired[0].Status != PendingExpired ||
+		*expired[0].ToUserID != toID {
+		t.Errorf("unexpected result %v, %v", expired, err)
+		return
+	}
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestPendingHistory(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("cant create mock: %s", err)
+	}
+	defer db.Close()
+
+	repo := NewRepository(db)
+	repo.Clock = testClock
+
+	mock.
+		ExpectQuery("SELECT id, to_id, from_id, money, created, refund_of, fee_of, split_of FROM transaction where").
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "to_id", "from_id", "money", "created", "refund_of", "fee_of", "split_of"}).
+			AddRow(20, 1, nil, 0.0, testTime, nil, nil, nil).
+			AddRow(21, 1, nil, 0.0, testTime, nil, nil, nil))
+	expectNoBonusSpent(mock, 1)
+	mock.
+		ExpectQuery("SELECT e.history_id, e.pending_id, e.status, p.held FROM pending_events e " +
+			"JOIN pending_operations p ON p.id = e.pending_id WHERE p.user_id = \\$1 AND e.history_id IS NOT NULL").
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"history_id", "pending_id", "status", "held"}).
+			AddRow(20, 7, PendingWaiting, 30.0).
+			AddRow(21, 7, PendingRejected, 30.0))
+
+	// the hold and the release of the rejected operation are in the history
+	history, err := repo.GetTransaction(ctx, 1, "")
+	if err != nil || len(history) != 2 {
+		t.Errorf("unexpected history %v, %v", history, err)
+		return
+	}
+	hold, release := history[0], history[1]
+	if hold.PendingID == nil || *hold.PendingID != 7 || hold.PendingStatus != PendingWaiting || hold.Held != 30 ||
+		release.PendingID == nil || *release.PendingID != 7 || release.PendingStatus != PendingRejected || release.Held != -30 {
+		t.Errorf("unexpected holds %v, %v", hold, release)
+		return
+	}
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
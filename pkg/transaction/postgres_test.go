@@ -0,0 +1,74 @@
+package transaction_test
+
+import (
+	"autumn-2021-intern-assignment/pkg/transaction"
+	"autumn-2021-intern-assignment/pkg/transaction/storagetest"
+	"database/sql"
+	"fmt"
+	"github.com/lib/pq"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestPostgresStorage runs the suite against the database of
+// TEST_DATABASE_URL, every subtest in a schema of its own made by
+// script/script.sql.
+func TestPostgresStorage(t *testing.T) {
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		var err error
+		dsn, err = pq.ParseURL(dsn)
+		if err != nil {
+			t.Fatalf("bad TEST_DATABASE_URL: %s", err)
+		}
+	}
+
+	script, err := ioutil.ReadFile("../../script/script.sql")
+	if err != nil {
+		t.Fatalf("cant read the schema: %s", err)
+	}
+
+	admin, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatalf("cant open the database: %s", err)
+	}
+	defer admin.Close()
+
+	n := 0
+	storagetest.Run(t, func(t *testing.T) transaction.Storage {
+		n++
+		schema := fmt.Sprintf("storagetest_%d_%d", time.Now().UnixNano(), n)
+		_, err := admin.Exec("CREATE SCHEMA " + schema)
+		if err != nil {
+			t.Fatalf("cant create schema: %s", err)
+		}
+		t.Cleanup(func() {
+			//nolint:errcheck
+			admin.Exec("DROP SCHEMA " + schema + " CASCADE")
+		})
+
+		// every connection of the pool works in the schema
+		db, err := sql.Open("postgres", dsn+" search_path="+schema)
+		if err != nil {
+			t.Fatalf("cant open the database: %s", err)
+		}
+		t.Cleanup(func() {
+			db.Close()
+		})
+
+		_, err = db.Exec(string(script))
+		if err != nil {
+			t.Fatalf("cant create the tables: %s", err)
+		}
+
+		repo := transaction.NewRepository(db)
+		repo.LiveRate = nil
+		return repo
+	})
+}
@@ -0,0 +1,174 @@
+package transaction
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// RateLimit is a token bucket: Burst requests at once and Rate more every
+// second.
+type RateLimit struct {
+	Rate  float64 `json:"rate"`
+	Burst int     `json:"burst"`
+}
+
+// RateResult is the answer of a limiter to one request.
+type RateResult struct {
+	Allowed   bool
+	Remaining int
+	// RetryAfter is the wait for the next token of a refused request.
+	RetryAfter time.Duration
+	// Reset is the wait until the bucket is full again.
+	Reset time.Duration
+}
+
+// take refills the bucket holding tokens since updated and takes a token at
+// now, it returns the tokens left.
+func (l RateLimit) take(tokens float64, updated, now time.Time) (float64, *RateResult) {
+	elapsed := now.Sub(updated).Seconds()
+	if elapsed < 0 {
+		elapsed = 0
+	}
+	tokens = math.Min(float64(l.Burst), tokens+elapsed*l.Rate)
+
+	res := &RateResult{}
+	if tokens >= 1 {
+		tokens--
+		res.Allowed = true
+	} else {
+		res.RetryAfter = rateWait(1-tokens, l.Rate)
+	}
+	res.Remaining = int(tokens)
+	res.Reset = rateWait(float64(l.Burst)-tokens, l.Rate)
+
+	return tokens, res
+}
+
+func rateWait(tokens, rate float64) time.Duration {
+	if tokens <= 0 {
+		return 0
+	}
+	return time.Duration(math.Ceil(tokens / rate * float64(time.Second)))
+}
+
+// TakeRateToken takes a token from the bucket of key kept in Postgres, so the
+// instances of the service share it.
+func (r *RepositoryItem) TakeRateToken(ctx context.Context, key string, limit RateLimit) (*RateResult, error) {
+	ctx, cancel := r.operation(ctx)
+	defer cancel()
+
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	db := withContext(ctx, tx)
+
+	now := r.now()
+	_, err = db.Exec("INSERT INTO rate_limits (key, tokens, updated, full_at) VALUES ($1, $2, $3, $3) "+
+		"ON CONFLICT (key) DO NOTHING", key, limit.Burst, now)
+	if err != nil {
+		//nolint:errcheck
+		tx.Rollback()
+		return nil, err
+	}
+
+	var tokens float64
+	var updated time.Time
+	err = db.QueryRow("SELECT tokens, updated FROM rate_limits WHERE key = $1 FOR UPDATE", key).Scan(&tokens, &updated)
+	if err != nil {
+		//nolint:errcheck
+		tx.Rollback()
+		return nil, err
+	}
+
+	tokens, res := limit.take(tokens, updated, now)
+	_, err = db.Exec("UPDATE rate_limits SET tokens = $2, updated = $3, full_at = $4 WHERE key = $1",
+		key, tokens, now, now.Add(res.Reset))
+	if err != nil {
+		//nolint:errcheck
+		tx.Rollback()
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// SweepRateLimits deletes the buckets that are full again, a full bucket is
+// the same as a missing one. It returns how many there were.
+func (r *RepositoryItem) SweepRateLimits(ctx context.Context) (int64, error) {
+	ctx, cancel := r.operation(ctx)
+	defer cancel()
+
+	res, err := r.DB.ExecContext(ctx, "DELETE FROM rate_limits WHERE full_at <= $1", r.now())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// rateSweepInterval is how often MemoryRateLimiter forgets the full buckets.
+const rateSweepInterval = time.Minute
+
+type rateBucket struct {
+	tokens  float64
+	updated time.Time
+	full    time.Time
+}
+
+// MemoryRateLimiter keeps the buckets in the process, every instance of the
+// service counts on its own.
+type MemoryRateLimiter struct {
+	Clock Clock
+
+	mu      sync.Mutex
+	buckets map[string]*rateBucket
+	swept   time.Time
+}
+
+func NewMemoryRateLimiter() *MemoryRateLimiter {
+	return &MemoryRateLimiter{
+		Clock:   time.Now,
+		buckets: map[string]*rateBucket{},
+	}
+}
+
+// TakeRateToken takes a token from the bucket of key.
+func (m *MemoryRateLimiter) TakeRateToken(ctx context.Context, key string, limit RateLimit) (*RateResult, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.Clock()
+	if now.Sub(m.swept) >= rateSweepInterval {
+		// a full bucket is the same as a missing one
+		for k, b := range m.buckets {
+			if !now.Before(b.full) {
+				delete(m.buckets, k)
+			}
+		}
+		m.swept = now
+	}
+
+	b, ok := m.buckets[key]
+	if !ok {
+		b = &rateBucket{tokens: float64(limit.Burst), updated: now}
+		m.buckets[key] = b
+	}
+
+	var res *RateResult
+	b.tokens, res = limit.take(b.tokens, b.updated, now)
+	b.updated = now
+	b.full = now.Add(res.Reset)
+
+	return res, nil
+}
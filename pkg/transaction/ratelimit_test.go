@@ -0,0 +1,123 @@
+package transaction
+
+import (
+	sqlmock "gopkg.in/DATA-DOG/go-sqlmock.v1"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestMemoryRateLimiter(t *testing.T) {
+	now := testTime
+	m := NewMemoryRateLimiter()
+	m.Clock = func() time.Time {
+		return now
+	}
+	limit := RateLimit{Rate: 2, Burst: 3}
+
+	// the burst goes at once
+	for i := 2; i >= 0; i-- {
+		res, err := m.TakeRateToken(ctx, "a", limit)
+		if err != nil || !res.Allowed || res.Remaining != i {
+			t.Errorf("unexpected result %v, %v", res, err)
+			return
+		}
+	}
+
+	res, err := m.TakeRateToken(ctx, "a", limit)
+	expect := &RateResult{RetryAfter: 500 * time.Millisecond, Reset: 1500 * time.Millisecond}
+	if err != nil || !reflect.DeepEqual(res, expect) {
+		t.Errorf("results not match, want %v, have %v, %v", expect, res, err)
+		return
+	}
+
+	// other keys have their own buckets
+	res, err = m.TakeRateToken(ctx, "b", limit)
+	if err != nil || !res.Allowed {
+		t.Errorf("unexpected result %v, %v", res, err)
+		return
+	}
+
+	// refilled at Rate
+	now = now.Add(time.Second)
+	res, err = m.TakeRateToken(ctx, "a", limit)
+	if err != nil || !res.Allowed || res.Remaining != 1 {
+		t.Errorf("unexpected result %v, %v", res, err)
+		return
+	}
+
+	// full buckets are forgotten
+	now = now.Add(time.Hour)
+	_, err = m.TakeRateToken(ctx, "a", limit)
+	if err != nil || len(m.buckets) != 1 {
+		t.Errorf("expected one bucket, have %d, %v", len(m.buckets), err)
+	}
+}
+
+func TestTakeRateToken(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("cant create mock: %s", err)
+	}
+	defer db.Close()
+
+	repo := NewRepository(db)
+	repo.Clock = testClock
+	limit := RateLimit{Rate: 1, Burst: 5}
+
+	mock.ExpectBegin()
+	mock.
+		ExpectExec("INSERT INTO rate_limits \\(key, tokens, updated, full_at\\) VALUES \\(\\$1, \\$2, \\$3, \\$3\\) ON CONFLICT").
+		WithArgs("user:1:/balance/transfer", 5, testTime).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.
+		ExpectQuery("SELECT tokens, updated FROM rate_limits WHERE key = \\$1 FOR UPDATE").
+		WithArgs("user:1:/balance/transfer").
+		WillReturnRows(sqlmock.NewRows([]string{"tokens", "updated"}).AddRow(0.5, testTime.Add(-time.Second)))
+	mock.
+		ExpectExec("UPDATE rate_limits SET tokens = \\$2, updated = \\$3, full_at = \\$4 WHERE key = \\$1").
+		WithArgs("user:1:/balance/transfer", 0.5, testTime, testTime.Add(4500*time.Millisecond)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	res, err := repo.TakeRateToken(ctx, "user:1:/balance/transfer", limit)
+	if err != nil {
+		t.Errorf("unexpected err: %s", err)
+		return
+	}
+	expect := &RateResult{Allowed: true, Reset: 4500 * time.Millisecond}
+	if !reflect.DeepEqual(res, expect) {
+		t.Errorf("results not match, want %v, have %v", expect, res)
+		return
+	}
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestSweepRateLimits(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("cant create mock: %s", err)
+	}
+	defer db.Close()
+
+	repo := NewRepository(db)
+	repo.Clock = testClock
+
+	mock.
+		ExpectExec("DELETE FROM rate_limits WHERE full_at <= \\$1").
+		WithArgs(testTime).
+		WillReturnResult(sqlmock.NewResult(0, 3))
+
+	n, err := repo.SweepRateLimits(ctx)
+	if err != nil || n != 3 {
+		t.Errorf("unexpected result %d, %v", n, err)
+		return
+	}
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
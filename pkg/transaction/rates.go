@@ -0,0 +1,225 @@
+package transaction
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RateDateLayout is the format of the rate dates in the API and the CSV files.
+const RateDateLayout = "2006-01-02"
+
+// DefaultMaxRateAge is how much older than the asked day a stored rate may be,
+// two weeks cover the holidays without rates.
+const DefaultMaxRateAge = 14 * 24 * time.Hour
+
+// RateFunc returns how many RUB one unit of the currency costs now.
+type RateFunc func(ctx context.Context, currency string) (float64, error)
+
+type rateDateCtx struct{}
+
+// WithRateDate makes the balance and the history read with ctx converted at
+// the rates of day.
+func WithRateDate(ctx context.Context, day time.Time) context.Context {
+	return context.WithValue(ctx, rateDateCtx{}, rateDay(day))
+}
+
+// RateDate is the day given to ctx by WithRateDate.
+func RateDate(ctx context.Context) (time.Time, bool) {
+	day, ok := ctx.Value(rateDateCtx{}).(time.Time)
+	return day, ok
+}
+
+// rateDay is the day of t the rates are stored for.
+func rateDay(t time.Time) time.Time {
+	y, m, d := t.UTC().Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
+
+func (rate *Rate) validate() error {
+	if rate.Day.IsZero() || rate.Rate <= 0 || rate.Currency == BaseCurrency || len(rate.Currency) != 3 ||
+		strings.ToUpper(rate.Currency) != rate.Currency {
+		return ErrBadRates
+	}
+	return nil
+}
+
+// ParseRates reads the rates from CSV lines "date,currency,rate" with dates
+// like 2021-11-27, the first line may be a header.
+func ParseRates(in io.Reader) ([]*Rate, error) {
+	reader := csv.NewReader(in)
+	reader.FieldsPerRecord = 3
+	reader.TrimLeadingSpace = true
+
+	rates := make([]*Rate, 0)
+	for line := 1; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrBadRates, err)
+		}
+		if line == 1 && strings.EqualFold(record[0], "date") {
+			continue
+		}
+
+		day, err := time.Parse(RateDateLayout, record[0])
+		if err != nil {
+			return nil, fmt.Errorf("%w: line %d: bad date %q", ErrBadRates, line, record[0])
+		}
+		value, err := strconv.ParseFloat(record[2], 64)
+		if err != nil {
+			return nil, fmt.Errorf("%w: line %d: bad rate %q", ErrBadRates, line, record[2])
+		}
+		rate := &Rate{Day: day, Currency: strings.ToUpper(record[1]), Rate: value}
+		if rate.validate() != nil {
+			return nil, fmt.Errorf("%w: line %d", ErrBadRates, line)
+		}
+		rates = append(rates, rate)
+	}
+
+	return rates, nil
+}
+
+// SaveRates stores the rates, the ones of a stored day and currency replace
+// it. Nothing is stored when one of them is bad.
+func (r *RepositoryItem) SaveRates(ctx context.Context, rates []*Rate) (*RateImport, error) {
+	if len(rates) == 0 {
+		return nil, ErrBadRates
+	}
+	for _, rate := range rates {
+		err := rate.validate()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	ctx, cancel := r.operation(ctx)
+	defer cancel()
+
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	db := withContext(ctx, tx)
+
+	for _, rate := range rates {
+		_, err = db.Exec("INSERT INTO currency_rates (day, currency, rate) VALUES ($1, $2, $3) "+
+			"ON CONFLICT (currency, day) DO UPDATE SET rate = excluded.rate", rateDay(rate.Day), rate.Currency, rate.Rate)
+		if err != nil {
+			//nolint:errcheck
+			tx.Rollback()
+			return nil, err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return &RateImport{Imported: len(rates)}, nil
+}
+
+// rateAt returns the rate of the currency on day or the last one stored before
+// it, at most MaxRateAge older. Today's rate comes from LiveRate when it is not
+// stored yet and is stored, so the conversions of the day don't change.
+func (r *RepositoryItem) rateAt(ctx context.Context, currency string, day time.Time) (float64, error) {
+	var stored time.Time
+	var rate float64
+	err := r.read(ctx, func(db *sql.DB) error {
+		return db.QueryRowContext(ctx, "SELECT day, rate FROM currency_rates WHERE currency = $1 AND day <= $2 "+
+			"ORDER BY day DESC LIMIT 1", currency, day).Scan(&stored, &rate)
+	})
+	if err != nil && err != sql.ErrNoRows {
+		return 0, err
+	}
+	found := err == nil && !tooOld(stored, day, r.MaxRateAge)
+
+	today := rateDay(r.now())
+	if (found && rateDay(stored).Equal(day)) || r.LiveRate == nil || !day.Equal(today) {
+		if !found {
+			return 0, ErrRateNotFound
+		}
+		return rate, nil
+	}
+
+	live, err := r.LiveRate(ctx, currency)
+	if err != nil {
+		if found {
+			return rate, nil
+		}
+		return 0, err
+	}
+
+	_, err = r.DB.ExecContext(ctx, "INSERT INTO currency_rates (day, currency, rate) VALUES ($1, $2, $3) "+
+		"ON CONFLICT (currency, day) DO NOTHING", today, currency, live)
+	if err != nil {
+		return 0, err
+	}
+
+	return live, nil
+}
+
+// tooOld tells a rate stored for the stored day can't stand for day, 0 maxAge
+// doesn't limit the age.
+func tooOld(stored, day time.Time, maxAge time.Duration) bool {
+	return maxAge > 0 && day.Sub(rateDay(stored)) > maxAge
+}
+
+// ConvertTransactions converts the sums of the operations from RUB to the
+// currency at the rates of the day given by WithRateDate, by default at the
+// rates of the day of each operation.
+func (r *RepositoryItem) ConvertTransactions(ctx context.Context, info []*Transaction, currency string) error {
+	if currency == "" || currency == BaseCurrency {
+		return nil
+	}
+
+	ctx, cancel := r.operation(ctx)
+	defer cancel()
+
+	date, fixed := RateDate(ctx)
+	rates := map[time.Time]float64{}
+	for _, tr := range info {
+		day := date
+		if !fixed {
+			day = rateDay(tr.Created)
+		}
+
+		rate, ok := rates[day]
+		if !ok {
+			var err error
+			rate, err = r.rateAt(ctx, currency, day)
+			if err != nil {
+				return err
+			}
+			rates[day] = rate
+		}
+		tr.convert(rate)
+	}
+
+	return nil
+}
+
+// convert turns the sums of the operation from RUB to the currency costing
+// rate RUB.
+func (tr *Transaction) convert(rate float64) {
+	tr.Money /= rate
+	tr.Bonus /= rate
+	if tr.Balance != nil {
+		balance := *tr.Balance / rate
+		tr.Balance = &balance
+	}
+	if tr.Fee != nil {
+		tr.Fee.convert(rate)
+	}
+	for _, leg := range tr.Legs {
+		leg.convert(rate)
+	}
+}
@@ -0,0 +1,136 @@
+package transaction
+
+import (
+	"errors"
+	sqlmock "gopkg.in/DATA-DOG/go-sqlmock.v1"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseRates(t *testing.T) {
+	rates, err := ParseRates(strings.NewReader("date,currency,rate\n2021-10-29,usd,72.5\n2021-10-29, EUR, 84.1\n"))
+	if err != nil {
+		t.Errorf("unexpected err: %s", err)
+		return
+	}
+	expect := []*Rate{
+		{Day: october, Currency: "USD", Rate: 72.5},
+		{Day: october, Currency: "EUR", Rate: 84.1},
+	}
+	if !reflect.DeepEqual(rates, expect) {
+		t.Errorf("results not match, want %v, have %v", expect, rates)
+		return
+	}
+
+	for _, bad := range []string{"2021-10-29,USD", "29.10.2021,USD,72.5", "2021-10-29,USD,-1", "2021-10-29,RUB,1",
+		"2021-10-29,DOLLAR,72.5", "2021-10-29,USD,many"} {
+		_, err = ParseRates(strings.NewReader(bad))
+		if !errors.Is(err, ErrBadRates) {
+			t.Errorf("%q: expected ErrBadRates, got %v", bad, err)
+		}
+	}
+}
+
+func TestSaveRates(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("cant create mock: %s", err)
+	}
+	defer db.Close()
+
+	repo := NewRepository(db)
+
+	_, err = repo.SaveRates(ctx, []*Rate{{Day: october, Currency: "USD", Rate: 0}})
+	if err != ErrBadRates {
+		t.Errorf("expected ErrBadRates, got %v", err)
+		return
+	}
+
+	mock.ExpectBegin()
+	mock.
+		ExpectExec("INSERT INTO currency_rates \\(day, currency, rate\\) VALUES \\(\\$1, \\$2, \\$3\\) "+
+			"ON CONFLICT \\(currency, day\\) DO UPDATE SET rate = excluded.rate").
+		WithArgs(october, "USD", 72.5).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.
+		ExpectExec("INSERT INTO currency_rates").
+		WithArgs(october, "EUR", 84.1).
+		WillReturnResult(sqlmock.NewResult(2, 1))
+	mock.ExpectCommit()
+
+	res, err := repo.SaveRates(ctx, []*Rate{
+		{Day: october.Add(10 * time.Hour), Currency: "USD", Rate: 72.5},
+		{Day: october, Currency: "EUR", Rate: 84.1},
+	})
+	if err != nil || res.Imported != 2 {
+		t.Errorf("unexpected result %v, %v", res, err)
+		return
+	}
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestConvertTransactions(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("cant create mock: %s", err)
+	}
+	defer db.Close()
+
+	repo := NewRepository(db)
+	repo.Clock = testClock
+	repo.LiveRate = nil
+
+	history := func() []*Transaction {
+		balance, fee := 150.0, -5.0
+		return []*Transaction{
+			{ID: 1, Money: 200, Created: october.Add(10 * time.Hour)},
+			{ID: 2, Money: -50, Created: october.Add(12 * time.Hour), Balance: &balance,
+				Fee: &Transaction{ID: 3, Money: fee, Created: october.Add(12 * time.Hour)}},
+			{ID: 4, Money: -100, Bonus: 50, Created: testTime},
+		}
+	}
+
+	// every operation at the rate of its day, one query a day
+	expectRate(mock, "USD", october, october, 50)
+	expectRate(mock, "USD", today, today, 100)
+
+	info := history()
+	err = repo.ConvertTransactions(ctx, info, "USD")
+	if err != nil {
+		t.Errorf("unexpected err: %s", err)
+		return
+	}
+	if info[0].Money != 4 || info[1].Money != -1 || *info[1].Balance != 3 || info[1].Fee.Money != -0.1 ||
+		info[2].Money != -1 || info[2].Bonus != 0.5 {
+		t.Errorf("unexpected conversion %v %v %v", info[0], info[1], info[2])
+		return
+	}
+
+	// all of them at the rate of the date asked for
+	expectRate(mock, "USD", today, today, 100)
+
+	info = history()
+	err = repo.ConvertTransactions(WithRateDate(ctx, testTime), info, "USD")
+	if err != nil || info[0].Money != 2 || info[1].Money != -0.5 {
+		t.Errorf("unexpected conversion %v, %v", info, err)
+		return
+	}
+
+	// no rate for the date
+	expectNoRate(mock, "USD", october)
+
+	err = repo.ConvertTransactions(ctx, history(), "USD")
+	if err != ErrRateNotFound {
+		t.Errorf("expected ErrRateNotFound, got %v", err)
+		return
+	}
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
@@ -0,0 +1,166 @@
+package transaction
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+)
+
+// reconcileLockID is the advisory lock held by a running reconciliation.
+const reconcileLockID = 37
+
+// reconcileTolerance absorbs rounding of REAL balances.
+const reconcileTolerance = 0.01
+
+// Reconcile recomputes the balance of every account from its whole history,
+// the archived part of it taken from archived_balances, and stores the
+// accounts where users.balance differs. Everything is read in one snapshot,
+// so operations running meanwhile are either seen on both sides or on
+// neither. With fix a correcting entry is written for every mismatch: the
+// history is brought to the stored balance, which is what the client has been
+// spending. Only one reconciliation runs at a time. It reads every account, so
+// Timeout does not apply: only ctx bounds it.
+func (r *RepositoryItem) Reconcile(ctx context.Context, fix bool) (*ReconciliationRun, error) {
+	tx, err := r.DB.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead})
+	if err != nil {
+		return nil, err
+	}
+
+	run, err := r.reconcile(fix, withContext(ctx, tx))
+	if err != nil {
+		//nolint:errcheck
+		tx.Rollback()
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return run, nil
+}
+
+func (r *RepositoryItem) reconcile(fix bool, db TransactionInterface) (*ReconciliationRun, error) {
+	var locked bool
+	err := db.QueryRow("SELECT pg_try_advisory_xact_lock($1)", reconcileLockID).Scan(&locked)
+	if err != nil {
+		return nil, err
+	}
+	if !locked {
+		return nil, ErrReconcileRunning
+	}
+
+	run := &ReconciliationRun{StartedAt: r.now(), Fix: fix}
+	err = db.QueryRow("SELECT COUNT(*) FROM users").Scan(&run.Accounts)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query("SELECT m.id, m.balance, m.expected FROM (SELECT u.id, u.balance::float8 AS balance, "+
+		"COALESCE((SELECT balance FROM archived_balances a WHERE a.user_id = u.id), 0) + "+
+		"COALESCE((SELECT SUM(("+balanceEffect("u.id")+")::float8) FROM transaction t WHERE t.to_id = u.id OR t.from_id = u.id), 0) "+
+		"AS expected FROM users u) m WHERE ABS(m.balance - m.expected) >= $1 ORDER BY m.id", reconcileTolerance)
+	if err != nil {
+		return nil, err
+	}
+
+	run.Items = make([]*ReconciliationMismatch, 0)
+	for rows.Next() {
+		m := &ReconciliationMismatch{}
+		err = rows.Scan(&m.UserID, &m.Balance, &m.Expected)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		m.Balance = math.Round(m.Balance*100) / 100
+		m.Expected = math.Round(m.Expected*100) / 100
+		m.Difference = math.Round((m.Balance-m.Expected)*100) / 100
+		run.Items = append(run.Items, m)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	run.Mismatches = len(run.Items)
+
+	if fix {
+		for _, m := range run.Items {
+			m.CorrectionID, err = r.writeCorrection(m, db)
+			if err != nil {
+				return nil, err
+			}
+			run.Corrected++
+		}
+	}
+
+	run.FinishedAt = r.now()
+	err = db.QueryRow("INSERT INTO reconciliation_runs (started_at, finished_at, fix, accounts, mismatches, corrected) "+
+		"VALUES ($1, $2, $3, $4, $5, $6) returning id", run.StartedAt, run.FinishedAt, run.Fix, run.Accounts,
+		run.Mismatches, run.Corrected).Scan(&run.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, m := range run.Items {
+		_, err = db.Exec("INSERT INTO reconciliation_mismatches (run_id, user_id, balance, expected, difference, correction_id) "+
+			"VALUES ($1, $2, $3, $4, $5, $6)", run.ID, m.UserID, m.Balance, m.Expected, m.Difference, m.CorrectionID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return run, nil
+}
+
+// writeCorrection adds the difference to the history like a deposit, so it is
+// not counted by limits and can't be refunded.
+func (r *RepositoryItem) writeCorrection(m *ReconciliationMismatch, db TransactionInterface) (*int, error) {
+	var id int
+	err := db.QueryRow("INSERT INTO transaction (to_id, from_id, money, created) VALUES ($1, NULL, $2, $3) returning id",
+		m.UserID, m.Difference, r.now()).Scan(&id)
+	if err != nil {
+		return nil, fmt.Errorf("dont create transaction: %v", err)
+	}
+
+	return &id, nil
+}
+
+// GetLastReconciliation returns the last finished run with its mismatches.
+func (r *RepositoryItem) GetLastReconciliation(ctx context.Context) (*ReconciliationRun, error) {
+	ctx, cancel := r.operation(ctx)
+	defer cancel()
+
+	run := &ReconciliationRun{}
+	err := r.DB.QueryRowContext(ctx, "SELECT id, started_at, finished_at, fix, accounts, mismatches, corrected FROM reconciliation_runs "+
+		"ORDER BY id DESC LIMIT 1").Scan(&run.ID, &run.StartedAt, &run.FinishedAt, &run.Fix, &run.Accounts,
+		&run.Mismatches, &run.Corrected)
+	if err == sql.ErrNoRows {
+		return nil, ErrNoReconciliation
+	}
+	if err != nil {
+		return nil, err
+	}
+	run.StartedAt = run.StartedAt.UTC()
+	run.FinishedAt = run.FinishedAt.UTC()
+
+	rows, err := r.DB.QueryContext(ctx, "SELECT user_id, balance, expected, difference, correction_id FROM reconciliation_mismatches "+
+		"WHERE run_id = $1 ORDER BY user_id", run.ID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	run.Items = make([]*ReconciliationMismatch, 0)
+	for rows.Next() {
+		m := &ReconciliationMismatch{}
+		err = rows.Scan(&m.UserID, &m.Balance, &m.Expected, &m.Difference, &m.CorrectionID)
+		if err != nil {
+			return nil, err
+		}
+		run.Items = append(run.Items, m)
+	}
+
+	return run, rows.Err()
+}
@@ -0,0 +1,177 @@
+package transaction
+
+import (
+	"database/sql"
+	sqlmock "gopkg.in/DATA-DOG/go-sqlmock.v1"
+	"reflect"
+	"testing"
+)
+
+func TestReconcile(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("cant create mock: %s", err)
+	}
+	defer db.Close()
+	repo := NewRepository(db)
+	repo.Clock = testClock
+
+	mock.ExpectBegin()
+	mock.
+		ExpectQuery("SELECT pg_try_advisory_xact_lock").
+		WithArgs(reconcileLockID).
+		WillReturnRows(sqlmock.NewRows([]string{"locked"}).AddRow(true))
+	mock.
+		ExpectQuery("SELECT COUNT\\(\\*\\) FROM users").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(3))
+	mock.
+		ExpectQuery("SELECT m.id, m.balance, m.expected FROM").
+		WithArgs(reconcileTolerance).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "balance", "expected"}).
+			AddRow(1, 70.0, 100.0).
+			AddRow(2, 10.25, 0.0))
+	mock.
+		ExpectQuery("INSERT INTO transaction \\(to_id, from_id, money, created\\) VALUES \\(\\$1, NULL").
+		WithArgs(1, -30.0, testTime).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(10))
+	mock.
+		ExpectQuery("INSERT INTO transaction \\(to_id, from_id, money, created\\) VALUES \\(\\$1, NULL").
+		WithArgs(2, 10.25, testTime).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(11))
+	mock.
+		ExpectQuery("INSERT INTO reconciliation_runs").
+		WithArgs(testTime, testTime, true, 3, 2, 2).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(5))
+	correction := 10
+	mock.
+		ExpectExec("INSERT INTO reconciliation_mismatches").
+		WithArgs(5, 1, 70.0, 100.0, -30.0, &correction).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	correction2 := 11
+	mock.
+		ExpectExec("INSERT INTO reconciliation_mismatches").
+		WithArgs(5, 2, 10.25, 0.0, 10.25, &correction2).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	run, err := repo.Reconcile(ctx, true)
+	if err != nil {
+		t.Errorf("unexpected err: %s", err)
+		return
+	}
+	expect := &ReconciliationRun{
+		ID:         5,
+		StartedAt:  testTime,
+		FinishedAt: testTime,
+		Fix:        true,
+		Accounts:   3,
+		Mismatches: 2,
+		Corrected:  2,
+		Items: []*ReconciliationMismatch{
+			{UserID: 1, Balance: 70, Expected: 100, Difference: -30, CorrectionID: &correction},
+			{UserID: 2, Balance: 10.25, Expected: 0, Difference: 10.25, CorrectionID: &correction2},
+		},
+	}
+	if !reflect.DeepEqual(run, expect) {
+		t.Errorf("results not match, want %v, have %v", expect, run)
+		return
+	}
+
+	// report only
+	mock.ExpectBegin()
+	mock.
+		ExpectQuery("SELECT pg_try_advisory_xact_lock").
+		WithArgs(reconcileLockID).
+		WillReturnRows(sqlmock.NewRows([]string{"locked"}).AddRow(true))
+	mock.
+		ExpectQuery("SELECT COUNT\\(\\*\\) FROM users").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(3))
+	mock.
+		ExpectQuery("SELECT m.id, m.balance, m.expected FROM").
+		WithArgs(reconcileTolerance).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "balance", "expected"}).AddRow(1, 70.0, 100.0))
+	mock.
+		ExpectQuery("INSERT INTO reconciliation_runs").
+		WithArgs(testTime, testTime, false, 3, 1, 0).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(6))
+	mock.
+		ExpectExec("INSERT INTO reconciliation_mismatches").
+		WithArgs(6, 1, 70.0, 100.0, -30.0, nil).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	run, err = repo.Reconcile(ctx, false)
+	if err != nil || run.Corrected != 0 || run.Items[0].CorrectionID != nil {
+		t.Errorf("unexpected result %v, %v", run, err)
+		return
+	}
+
+	// another run holds the lock
+	mock.ExpectBegin()
+	mock.
+		ExpectQuery("SELECT pg_try_advisory_xact_lock").
+		WithArgs(reconcileLockID).
+		WillReturnRows(sqlmock.NewRows([]string{"locked"}).AddRow(false))
+	mock.ExpectRollback()
+
+	_, err = repo.Reconcile(ctx, false)
+	if err != ErrReconcileRunning {
+		t.Errorf("expected ErrReconcileRunning, got %v", err)
+		return
+	}
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestGetLastReconciliation(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("cant create mock: %s", err)
+	}
+	defer db.Close()
+	repo := NewRepository(db)
+
+	mock.
+		ExpectQuery("SELECT id, started_at, finished_at, fix, accounts, mismatches, corrected FROM reconciliation_runs").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "started_at", "finished_at", "fix", "accounts", "mismatches", "corrected"}).
+			AddRow(5, testTime, testTime, false, 3, 1, 0))
+	mock.
+		ExpectQuery("SELECT user_id, balance, expected, difference, correction_id FROM reconciliation_mismatches").
+		WithArgs(5).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "balance", "expected", "difference", "correction_id"}).
+			AddRow(1, 70.0, 100.0, -30.0, nil))
+
+	run, err := repo.GetLastReconciliation(ctx)
+	if err != nil {
+		t.Errorf("unexpected err: %s", err)
+		return
+	}
+	expect := &ReconciliationRun{
+		ID:         5,
+		StartedAt:  testTime,
+		FinishedAt: testTime,
+		Accounts:   3,
+		Mismatches: 1,
+		Items:      []*ReconciliationMismatch{{UserID: 1, Balance: 70, Expected: 100, Difference: -30}},
+	}
+	if !reflect.DeepEqual(run, expect) {
+		t.Errorf("results not match, want %v, have %v", expect, run)
+		return
+	}
+
+	mock.
+		ExpectQuery("SELECT id, started_at, finished_at, fix, accounts, mismatches, corrected FROM reconciliation_runs").
+		WillReturnError(sql.ErrNoRows)
+
+	_, err = repo.GetLastReconciliation(ctx)
+	if err != ErrNoReconciliation {
+		t.Errorf("expected ErrNoReconciliation, got %v", err)
+		return
+	}
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
@@ -0,0 +1,216 @@
+package transaction
+
+import (
+	"context"
+	"database/sql"
+	"math"
+)
+
+// RefundMoney returns money of a withdrawal or a transfer back to the payer.
+// Zero money refunds everything that is not refunded yet.
+func (r *RepositoryItem) RefundMoney(ctx context.Context, transactionID int, money float64) (*Transaction, error) {
+	if money < 0 {
+		return nil, ErrNegativeAmount
+	}
+
+	ctx, cancel := r.operation(ctx)
+	defer cancel()
+
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	tr, err := r.refundTransaction(transactionID, money, withContext(ctx, tx))
+	if err != nil {
+		//nolint:errcheck
+		tx.Rollback()
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return tr, nil
+}
+
+// refundTransaction refunds money of what the operation charged: a withdrawal
+// paid with bonuses returns their share of money to the grants it spent, and
+// the fee of the operation is refunded in the same proportion.
+func (r *RepositoryItem) refundTransaction(transactionID int, money float64, db TransactionInterface) (*Transaction, error) {
+	orig := &Transaction{}
+	// the row lock keeps concurrent refunds of one operation in order
+	err := db.QueryRow("SELECT to_id, from_id, money, refund_of FROM transaction WHERE id = $1 FOR UPDATE",
+		transactionID).Scan(&orig.ToID, &orig.FromID, &orig.Money, &orig.RefundOf)
+	if err == sql.ErrNoRows {
+		return nil, ErrTransactionNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// deposits have no payer, refunds are not refunded again
+	// and a split payment is refunded leg by leg
+	if orig.FromID == nil || orig.RefundOf != nil || orig.isSplit() {
+		return nil, ErrNotRefundable
+	}
+
+	var refunded float64
+	err = db.QueryRow("SELECT COALESCE(SUM(money), 0) FROM transaction WHERE refund_of = $1",
+		transactionID).Scan(&refunded)
+	if err != nil {
+		return nil, err
+	}
+
+	grants, err := refundableBonuses(transactionID, db)
+	if err != nil {
+		return nil, err
+	}
+	var bonusPaid, bonusLeft float64
+	for _, g := range grants {
+		bonusPaid += g.Amount
+		bonusLeft += g.Remaining
+	}
+
+	// withdrawals are stored with negative money
+	charged := math.Abs(orig.Money) + bonusPaid
+	left := math.Round((charged-refunded-(bonusPaid-bonusLeft))*100) / 100
+	if money == 0 {
+		money = left
+	}
+
+	if money <= 0 || money > left {
+		return nil, ErrRefundExceeded
+	}
+
+	// the last refund takes what is left, so the kopecks of the shares add up
+	bonus := bonusLeft
+	if money < left {
+		bonus = math.Min(bonusLeft, math.Round(money*bonusPaid/charged*100)/100)
+	}
+	paid := math.Round((money-bonus)*100) / 100
+
+	if orig.ToID != nil {
+		_, err = r.getMoneyFromDB(*orig.ToID, paid, db)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	balance, err := r.appendMoneyToUser(*orig.FromID, paid, db)
+	if err != nil {
+		return nil, err
+	}
+
+	tr, err := r.writeTransaction(orig.FromID, orig.ToID, paid, &transactionID, db)
+	if err != nil {
+		return nil, err
+	}
+	tr.Balance = &balance
+
+	err = r.refundBonuses(grants, bonus, tr.ID, db)
+	if err != nil {
+		return nil, err
+	}
+	tr.Bonus = bonus
+
+	tr.Fee, err = r.refundFee(transactionID, money/charged, money == left, db)
+	if err != nil {
+		return nil, err
+	}
+	if tr.Fee != nil {
+		tr.Balance = tr.Fee.Balance
+	}
+
+	return tr, nil
+}
+
+// refundableBonuses returns the grants the withdrawal spent, the oldest
+// first, with Amount the bonus money it spent from each and Remaining the part
+// of it not refunded yet.
+func refundableBonuses(transactionID int, db TransactionInterface) ([]*BonusGrant, error) {
+	rows, err := db.Query("SELECT grant_id, user_id, kind, SUM(amount) FROM bonus_movements WHERE kind IN ($2, $3) "+
+		"AND (transaction_id = $1 OR transaction_id IN (SELECT id FROM transaction WHERE refund_of = $1)) "+
+		"GROUP BY grant_id, user_id, kind ORDER BY grant_id", transactionID, BonusSpend, BonusRefund)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	grants := make([]*BonusGrant, 0)
+	byID := make(map[int]*BonusGrant)
+	for rows.Next() {
+		var grantID, userID int
+		var kind string
+		var amount float64
+		err = rows.Scan(&grantID, &userID, &kind, &amount)
+		if err != nil {
+			return nil, err
+		}
+		g, ok := byID[grantID]
+		if !ok {
+			g = &BonusGrant{ID: grantID, UserID: userID}
+			byID[grantID] = g
+			grants = append(grants, g)
+		}
+		// spends are negative, refunds positive
+		if kind == BonusSpend {
+			g.Amount -= amount
+		}
+		g.Remaining -= amount
+	}
+
+	return grants, rows.Err()
+}
+
+// refundBonuses returns bonus money to the grants it was spent from, the
+// newest first. The grants keep their expiry, an expired one is written off
+// again by ExpireBonuses.
+func (r *RepositoryItem) refundBonuses(grants []*BonusGrant, bonus float64, refundID int, db TransactionInterface) error {
+	for i := len(grants) - 1; i >= 0 && bonus > 0; i-- {
+		g := grants[i]
+		amount := math.Min(g.Remaining, bonus)
+		if amount <= 0 {
+			continue
+		}
+		_, err := db.Exec("UPDATE bonus_grants SET remaining = remaining + $1 WHERE id = $2", amount, g.ID)
+		if err != nil {
+			return err
+		}
+		err = r.writeBonusMovement(g, BonusRefund, amount, &refundID, db)
+		if err != nil {
+			return err
+		}
+		bonus = math.Round((bonus-amount)*100) / 100
+	}
+
+	return nil
+}
+
+// refundFee refunds the share of the fee of the operation, the rest of it
+// with the last refund. It returns nil when the operation had no fee.
+func (r *RepositoryItem) refundFee(transactionID int, share float64, last bool, db TransactionInterface) (*Transaction, error) {
+	var feeID int
+	var fee, refunded float64
+	err := db.QueryRow("SELECT id, money, (SELECT COALESCE(SUM(money), 0) FROM transaction WHERE refund_of = fee.id) "+
+		"FROM transaction fee WHERE fee_of = $1", transactionID).Scan(&feeID, &fee, &refunded)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	left := math.Round((fee-refunded)*100) / 100
+	money := left
+	if !last {
+		money = math.Min(left, math.Round(fee*share*100)/100)
+	}
+	if money <= 0 {
+		return nil, nil
+	}
+
+	return r.refundTransaction(feeID, money, db)
+}
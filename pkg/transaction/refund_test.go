@@ -0,0 +1,405 @@
+package transaction
+
+import (
+	"database/sql"
+	"fmt"
+	sqlmock "gopkg.in/DATA-DOG/go-sqlmock.v1"
+	"testing"
+	"time"
+)
+
+func expectNoRefundBonuses(mock sqlmock.Sqlmock, transactionID int) {
+	mock.
+		ExpectQuery("SELECT grant_id, user_id, kind, SUM\\(amount\\) FROM bonus_movements").
+		WithArgs(transactionID, BonusSpend, BonusRefund).
+		WillReturnRows(sqlmock.NewRows([]string{"grant_id", "user_id", "kind", "sum"}))
+}
+
+func expectNoRefundFee(mock sqlmock.Sqlmock, transactionID int) {
+	mock.
+		ExpectQuery("FROM transaction fee WHERE fee_of = \\$1").
+		WithArgs(transactionID).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "money", "refunded"}))
+}
+
+func TestRefundMoney(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("cant create mock: %s", err)
+	}
+	defer db.Close()
+	repo := NewRepository(db)
+	repo.Clock = testClock
+
+	elemID := 1
+	elemID2 := 2
+	transactionID := 5
+
+	// partial refund of a withdrawal
+	mock.ExpectBegin()
+	mock.
+		ExpectQuery("SELECT to_id, from_id, money, refund_of FROM transaction WHERE").
+		WithArgs(transactionID).
+		WillReturnRows(sqlmock.NewRows([]string{"to_id", "from_id", "money", "refund_of"}).
+			AddRow(nil, elemID, -100.0, nil))
+	mock.
+		ExpectQuery("SELECT COALESCE").
+		WithArgs(transactionID).
+		WillReturnRows(sqlmock.NewRows([]string{"sum"}).AddRow(50.0))
+	expectNoRefundBonuses(mock, transactionID)
+	mock.
+		ExpectQuery("SELECT balance, status, debit_blocked, credit_blocked, credit_limit FROM users WHERE").
+		WithArgs(elemID).
+		WillReturnRows(accountRows(10.0))
+	mock.
+		ExpectQuery("UPDATE users SET").
+		WithArgs(40.0, elemID).
+		WillReturnRows(sqlmock.NewRows([]string{"balance"}).AddRow(0.0))
+	mock.
+		ExpectQuery("INSERT INTO transaction").
+		WithArgs(&elemID, nil, 40.0, testTime, &transactionID).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created"}).AddRow(1, time.Now()))
+	expectNoRefundFee(mock, transactionID)
+	mock.ExpectCommit()
+
+	_, err = repo.RefundMoney(ctx, transactionID, 40)
+	if err != nil {
+		t.Errorf("unexpected err: %s", err)
+		return
+	}
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+		return
+	}
+
+	// full refund of a transfer reverses both sides
+	mock.ExpectBegin()
+	mock.
+		ExpectQuery("SELECT to_id, from_id, money, refund_of FROM transaction WHERE").
+		WithArgs(transactionID).
+		WillReturnRows(sqlmock.NewRows([]string{"to_id", "from_id", "money", "refund_of"}).
+			AddRow(elemID2, elemID, 30.0, nil))
+	mock.
+		ExpectQuery("SELECT COALESCE").
+		WithArgs(transactionID).
+		WillReturnRows(sqlmock.NewRows([]string{"sum"}).AddRow(0.0))
+	expectNoRefundBonuses(mock, transactionID)
+	mock.
+		ExpectQuery("SELECT balance, status, debit_blocked, credit_blocked, credit_limit FROM users WHERE").
+		WithArgs(elemID2).
+		WillReturnRows(accountRows(100.0))
+	mock.
+		ExpectQuery("UPDATE users SET").
+		WithArgs(30.0, elemID2).
+		WillReturnRows(sqlmock.NewRows([]string{"balance"}).AddRow(0.0))
+	mock.
+		ExpectQuery("SELECT balance, status, debit_blocked, credit_blocked, credit_limit FROM users WHERE").
+		WithArgs(elemID).
+		WillReturnRows(accountRows(0.0))
+	mock.
+		ExpectQuery("UPDATE users SET").
+		WithArgs(30.0, elemID).
+		WillReturnRows(sqlmock.NewRows([]string{"balance"}).AddRow(0.0))
+	mock.
+		ExpectQuery("INSERT INTO transaction").
+		WithArgs(&elemID, &elemID2, 30.0, testTime, &transactionID).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created"}).AddRow(1, time.Now()))
+	expectNoRefundFee(mock, transactionID)
+	mock.ExpectCommit()
+
+	_, err = repo.RefundMoney(ctx, transactionID, 0)
+	if err != nil {
+		t.Errorf("unexpected err: %s", err)
+		return
+	}
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestRefundMoneyError(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("cant create mock: %s", err)
+	}
+	defer db.Close()
+	repo := NewRepository(db)
+
+	elemID := 1
+	elemID2 := 2
+	transactionID := 5
+
+	_, err = repo.RefundMoney(ctx, transactionID, -10)
+	if err != ErrNegativeAmount {
+		t.Errorf("expected ErrNegativeAmount, got %v", err)
+		return
+	}
+
+	// expect begin
+	mock.ExpectBegin().WillReturnError(fmt.Errorf("error"))
+
+	_, err = repo.RefundMoney(ctx, transactionID, 10)
+	if err == nil {
+		t.Errorf("expected error, got nil")
+		return
+	}
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+
+	// no such transaction
+	mock.ExpectBegin()
+	mock.
+		ExpectQuery("SELECT to_id, from_id, money, refund_of FROM transaction WHERE").
+		WithArgs(transactionID).
+		WillReturnError(sql.ErrNoRows)
+	mock.ExpectRollback()
+
+	_, err = repo.RefundMoney(ctx, transactionID, 10)
+	if err != ErrTransactionNotFound {
+		t.Errorf("expected ErrTransactionNotFound, got %v", err)
+		return
+	}
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+
+	// deposit
+	mock.ExpectBegin()
+	mock.
+		ExpectQuery("SELECT to_id, from_id, money, refund_of FROM transaction WHERE").
+		WithArgs(transactionID).
+		WillReturnRows(sqlmock.NewRows([]string{"to_id", "from_id", "money", "refund_of"}).
+			AddRow(elemID, nil, 100.0, nil))
+	mock.ExpectRollback()
+
+	_, err = repo.RefundMoney(ctx, transactionID, 10)
+	if err != ErrNotRefundable {
+		t.Errorf("expected ErrNotRefundable, got %v", err)
+		return
+	}
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+
+	// refund of a refund
+	mock.ExpectBegin()
+	mock.
+		ExpectQuery("SELECT to_id, from_id, money, refund_of FROM transaction WHERE").
+		WithArgs(transactionID).
+		WillReturnRows(sqlmock.NewRows([]string{"to_id", "from_id", "money", "refund_of"}).
+			AddRow(elemID, elemID2, 10.0, 3))
+	mock.ExpectRollback()
+
+	_, err = repo.RefundMoney(ctx, transactionID, 10)
+	if err != ErrNotRefundable {
+		t.Errorf("expected ErrNotRefundable, got %v", err)
+		return
+	}
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+
+	// more than was charged
+	mock.ExpectBegin()
+	mock.
+		ExpectQuery("SELECT to_id, from_id, money, refund_of FROM transaction WHERE").
+		WithArgs(transactionID).
+		WillReturnRows(sqlmock.NewRows([]string{"to_id", "from_id", "money", "refund_of"}).
+			AddRow(nil, elemID, -100.0, nil))
+	mock.
+		ExpectQuery("SELECT COALESCE").
+		WithArgs(transactionID).
+		WillReturnRows(sqlmock.NewRows([]string{"sum"}).AddRow(80.0))
+	expectNoRefundBonuses(mock, transactionID)
+	mock.ExpectRollback()
+
+	_, err = repo.RefundMoney(ctx, transactionID, 30)
+	if err != ErrRefundExceeded {
+		t.Errorf("expected ErrRefundExceeded, got %v", err)
+		return
+	}
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+
+	// nothing left to refund
+	mock.ExpectBegin()
+	mock.
+		ExpectQuery("SELECT to_id, from_id, money, refund_of FROM transaction WHERE").
+		WithArgs(transactionID).
+		WillReturnRows(sqlmock.NewRows([]string{"to_id", "from_id", "money", "refund_of"}).
+			AddRow(nil, elemID, -100.0, nil))
+	mock.
+		ExpectQuery("SELECT COALESCE").
+		WithArgs(transactionID).
+		WillReturnRows(sqlmock.NewRows([]string{"sum"}).AddRow(100.0))
+	expectNoRefundBonuses(mock, transactionID)
+	mock.ExpectRollback()
+
+	_, err = repo.RefundMoney(ctx, transactionID, 0)
+	if err != ErrRefundExceeded {
+		t.Errorf("expected ErrRefundExceeded, got %v", err)
+		return
+	}
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+
+	// receiver of a transfer spent the money
+	mock.ExpectBegin()
+	mock.
+		ExpectQuery("SELECT to_id, from_id, money, refund_of FROM transaction WHERE").
+		WithArgs(transactionID).
+		WillReturnRows(sqlmock.NewRows([]string{"to_id", "from_id", "money", "refund_of"}).
+			AddRow(elemID2, elemID, 30.0, nil))
+	mock.
+		ExpectQuery("SELECT COALESCE").
+		WithArgs(transactionID).
+		WillReturnRows(sqlmock.NewRows([]string{"sum"}).AddRow(0.0))
+	expectNoRefundBonuses(mock, transactionID)
+	mock.
+		ExpectQuery("SELECT balance, status, debit_blocked, credit_blocked, credit_limit FROM users WHERE").
+		WithArgs(elemID2).
+		WillReturnRows(accountRows(10.0))
+	mock.ExpectRollback()
+
+	_, err = repo.RefundMoney(ctx, transactionID, 0)
+	if err != ErrNotEnoughMoney {
+		t.Errorf("expected ErrNotEnoughMoney, got %v", err)
+		return
+	}
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestRefundMoneyBonusFee(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("cant create mock: %s", err)
+	}
+	defer db.Close()
+	repo := NewRepository(db)
+	repo.Clock = testClock
+
+	elemID, revenueID, transactionID, feeID := 1, RevenueAccountID, 5, 11
+	expectRefund := func(refunded float64, bonuses *sqlmock.Rows) {
+		mock.
+			ExpectQuery("SELECT to_id, from_id, money, refund_of FROM transaction WHERE").
+			WithArgs(transactionID).
+			WillReturnRows(sqlmock.NewRows([]string{"to_id", "from_id", "money", "refund_of"}).
+				AddRow(nil, elemID, -20.0, nil))
+		mock.
+			ExpectQuery("SELECT COALESCE").
+			WithArgs(transactionID).
+			WillReturnRows(sqlmock.NewRows([]string{"sum"}).AddRow(refunded))
+		mock.
+			ExpectQuery("SELECT grant_id, user_id, kind, SUM\\(amount\\) FROM bonus_movements").
+			WithArgs(transactionID, BonusSpend, BonusRefund).
+			WillReturnRows(bonuses)
+		mock.
+			ExpectQuery("SELECT balance, status, debit_blocked, credit_blocked, credit_limit FROM users WHERE").
+			WithArgs(elemID).
+			WillReturnRows(accountRows(0))
+		mock.
+			ExpectQuery("UPDATE users SET balance = balance \\+").
+			WithArgs(10.0, elemID).
+			WillReturnRows(sqlmock.NewRows([]string{"balance"}).AddRow(10.0))
+		mock.
+			ExpectQuery("INSERT INTO transaction").
+			WithArgs(&elemID, nil, 10.0, testTime, &transactionID).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "created"}).AddRow(12, testTime))
+	}
+	expectBonusRefund := func(grantID int, amount float64) {
+		mock.
+			ExpectExec("UPDATE bonus_grants SET remaining = remaining \\+ \\$1 WHERE id = \\$2").
+			WithArgs(amount, grantID).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.
+			ExpectExec("INSERT INTO bonus_movements").
+			WithArgs(grantID, elemID, BonusRefund, amount, 12, testTime).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+	}
+	expectFeeRefund := func(refunded float64) {
+		mock.
+			ExpectQuery("FROM transaction fee WHERE fee_of = \\$1").
+			WithArgs(transactionID).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "money", "refunded"}).AddRow(feeID, 1.4, refunded))
+		mock.
+			ExpectQuery("SELECT to_id, from_id, money, refund_of FROM transaction WHERE").
+			WithArgs(feeID).
+			WillReturnRows(sqlmock.NewRows([]string{"to_id", "from_id", "money", "refund_of"}).
+				AddRow(revenueID, elemID, 1.4, nil))
+		mock.
+			ExpectQuery("SELECT COALESCE").
+			WithArgs(feeID).
+			WillReturnRows(sqlmock.NewRows([]string{"sum"}).AddRow(refunded))
+		expectNoRefundBonuses(mock, feeID)
+		mock.
+			ExpectQuery("SELECT balance, status, debit_blocked, credit_blocked, credit_limit FROM users WHERE").
+			WithArgs(revenueID).
+			WillReturnRows(accountRows(10))
+		mock.
+			ExpectQuery("UPDATE users SET balance = balance -").
+			WithArgs(0.7, revenueID).
+			WillReturnRows(sqlmock.NewRows([]string{"balance"}).AddRow(9.3))
+		mock.
+			ExpectQuery("SELECT balance, status, debit_blocked, credit_blocked, credit_limit FROM users WHERE").
+			WithArgs(elemID).
+			WillReturnRows(accountRows(10))
+		mock.
+			ExpectQuery("UPDATE users SET balance = balance \\+").
+			WithArgs(0.7, elemID).
+			WillReturnRows(sqlmock.NewRows([]string{"balance"}).AddRow(10.7))
+		mock.
+			ExpectQuery("INSERT INTO transaction").
+			WithArgs(&elemID, &revenueID, 0.7, testTime, &feeID).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "created"}).AddRow(13, testTime))
+		expectNoRefundFee(mock, feeID)
+	}
+
+	// half of a withdrawal of 20 real and 50 bonus money with a fee of 1.4:
+	// the shares of both parts and of the fee come back, the bonus to the
+	// newest grants first
+	mock.ExpectBegin()
+	expectRefund(0, sqlmock.NewRows([]string{"grant_id", "user_id", "kind", "sum"}).
+		AddRow(6, elemID, BonusSpend, -30.0).
+		AddRow(7, elemID, BonusSpend, -20.0))
+	expectBonusRefund(7, 20)
+	expectBonusRefund(6, 5)
+	expectFeeRefund(0)
+	mock.ExpectCommit()
+
+	tr, err := repo.RefundMoney(ctx, transactionID, 35)
+	if err != nil {
+		t.Errorf("unexpected err: %s", err)
+		return
+	}
+	if tr.Money != 10 || tr.Bonus != 25 || tr.Fee == nil || tr.Fee.Money != 0.7 || *tr.Fee.RefundOf != feeID ||
+		*tr.Balance != 10.7 {
+		t.Errorf("unexpected refund %v, fee %v", tr, tr.Fee)
+		return
+	}
+
+	// the rest of it
+	mock.ExpectBegin()
+	expectRefund(10, sqlmock.NewRows([]string{"grant_id", "user_id", "kind", "sum"}).
+		AddRow(6, elemID, BonusSpend, -30.0).
+		AddRow(6, elemID, BonusRefund, 5.0).
+		AddRow(7, elemID, BonusSpend, -20.0).
+		AddRow(7, elemID, BonusRefund, 20.0))
+	expectBonusRefund(6, 25)
+	expectFeeRefund(0.7)
+	mock.ExpectCommit()
+
+	tr, err = repo.RefundMoney(ctx, transactionID, 0)
+	if err != nil || tr.Money != 10 || tr.Bonus != 25 || tr.Fee.Money != 0.7 {
+		t.Errorf("unexpected refund %v, %v", tr, err)
+		return
+	}
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
@@ -0,0 +1,107 @@
+package transaction
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultMaxReplicaLag is how stale the balance and the history read from a
+// replica may be.
+const DefaultMaxReplicaLag = 5 * time.Second
+
+// ReplicaCheckInterval is how long the measured lag of a replica is trusted.
+// A failed replica is not used until the next check either.
+const ReplicaCheckInterval = time.Second
+
+// replicaLagQuery is the time since the last replayed transaction. A replica
+// that has replayed all it received is not behind, even when the primary has
+// been idle for a while.
+const replicaLagQuery = "SELECT CASE WHEN pg_last_wal_receive_lsn() = pg_last_wal_replay_lsn() THEN 0 " +
+	"ELSE COALESCE(EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp()), 0) END"
+
+type replica struct {
+	db *sql.DB
+
+	mu      sync.Mutex
+	checked time.Time
+	lag     time.Duration
+	failed  bool
+}
+
+type primaryCtx struct{}
+
+// WithPrimaryRead makes the reads with ctx go to the primary, so they see the
+// writes made before them.
+func WithPrimaryRead(ctx context.Context) context.Context {
+	return context.WithValue(ctx, primaryCtx{}, true)
+}
+
+// PrimaryRead tells whether ctx was made by WithPrimaryRead.
+func PrimaryRead(ctx context.Context) bool {
+	primary, _ := ctx.Value(primaryCtx{}).(bool)
+	return primary
+}
+
+// usable tells whether the replica is within maxLag, measuring the lag again
+// when the last check is too old.
+func (rep *replica) usable(ctx context.Context, now time.Time, maxLag time.Duration) bool {
+	rep.mu.Lock()
+	defer rep.mu.Unlock()
+
+	if now.Sub(rep.checked) >= ReplicaCheckInterval {
+		var seconds float64
+		err := rep.db.QueryRowContext(ctx, replicaLagQuery).Scan(&seconds)
+		if ctx.Err() != nil {
+			return false
+		}
+		rep.checked, rep.failed = now, err != nil
+		rep.lag = time.Duration(seconds * float64(time.Second))
+	}
+
+	return !rep.failed && rep.lag <= maxLag
+}
+
+func (rep *replica) fail(now time.Time) {
+	rep.mu.Lock()
+	defer rep.mu.Unlock()
+
+	rep.checked, rep.failed = now, true
+}
+
+// replicaFailed tells a failure of the replica from an answer the primary
+// would give too.
+func replicaFailed(ctx context.Context, err error) bool {
+	return err != nil && ctx.Err() == nil && err != sql.ErrNoRows && !errors.Is(err, ErrAccountNotFound) &&
+		!errors.Is(err, ErrTransactionNotFound)
+}
+
+// read runs a read-only query on a replica within MaxReplicaLag, taking them in
+// turn, and on the primary when there is none, when the replica fails or when
+// ctx asks for the primary.
+func (r *RepositoryItem) read(ctx context.Context, query func(db *sql.DB) error) error {
+	if len(r.replicas) == 0 || PrimaryRead(ctx) {
+		return query(r.DB)
+	}
+
+	now := r.Clock()
+	start := atomic.AddUint32(&r.nextReplica, 1)
+	for i := range r.replicas {
+		rep := r.replicas[(int(start)+i)%len(r.replicas)]
+		if !rep.usable(ctx, now, r.MaxReplicaLag) {
+			continue
+		}
+
+		err := query(rep.db)
+		if !replicaFailed(ctx, err) {
+			return err
+		}
+		rep.fail(now)
+		break
+	}
+
+	return query(r.DB)
+}
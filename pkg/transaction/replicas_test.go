@@ -0,0 +1,106 @@
+package transaction
+
+import (
+	"database/sql"
+	"errors"
+	sqlmock "gopkg.in/DATA-DOG/go-sqlmock.v1"
+	"testing"
+	"time"
+)
+
+func expectBalance(mock sqlmock.Sqlmock, balance float64) {
+	mock.
+		ExpectQuery("SELECT balance, credit_limit - held FROM users WHERE").
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"balance", "credit_limit"}).AddRow(balance, 0.0))
+	expectNoBonusBalance(mock, 1)
+}
+
+func expectLag(mock sqlmock.Sqlmock, seconds float64) {
+	mock.
+		ExpectQuery("SELECT CASE WHEN pg_last_wal_receive_lsn").
+		WillReturnRows(sqlmock.NewRows([]string{"lag"}).AddRow(seconds))
+}
+
+func TestReplicaRouting(t *testing.T) {
+	primary, primaryMock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("cant create mock: %s", err)
+	}
+	defer primary.Close()
+
+	replicaDB, replicaMock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("cant create mock: %s", err)
+	}
+	defer replicaDB.Close()
+
+	now := testTime
+	repo := NewRepository(primary, replicaDB)
+	repo.Clock = func() time.Time {
+		return now
+	}
+
+	balance := func(from string, want float64) {
+		t.Helper()
+		user, err := repo.GetUsersBalance(ctx, 1, "")
+		if err != nil {
+			t.Errorf("%s: unexpected err: %s", from, err)
+			return
+		}
+		if user.Balance != want {
+			t.Errorf("%s: expected balance %v, have %v", from, want, user.Balance)
+		}
+	}
+
+	// a fresh replica serves the reads, the lag is measured once a second
+	expectLag(replicaMock, 0.5)
+	expectBalance(replicaMock, 10)
+	expectBalance(replicaMock, 10)
+	balance("fresh replica", 10)
+	balance("fresh replica", 10)
+
+	// a replica behind MaxReplicaLag is skipped
+	now = now.Add(ReplicaCheckInterval)
+	expectLag(replicaMock, 30)
+	expectBalance(primaryMock, 20)
+	balance("stale replica", 20)
+
+	// a failed replica is skipped until the next check
+	now = now.Add(ReplicaCheckInterval)
+	expectLag(replicaMock, 0)
+	replicaMock.
+		ExpectQuery("SELECT balance, credit_limit - held FROM users WHERE").
+		WithArgs(1).
+		WillReturnError(errors.New("connection refused"))
+	expectBalance(primaryMock, 20)
+	expectBalance(primaryMock, 20)
+	balance("failed replica", 20)
+	balance("failed replica", 20)
+
+	// answers of the data are not failures
+	now = now.Add(ReplicaCheckInterval)
+	expectLag(replicaMock, 0)
+	replicaMock.
+		ExpectQuery("SELECT balance, credit_limit - held FROM users WHERE").
+		WithArgs(2).
+		WillReturnError(sql.ErrNoRows)
+	_, err = repo.GetUsersBalance(ctx, 2, "")
+	if err != ErrAccountNotFound {
+		t.Errorf("expected ErrAccountNotFound, have %v", err)
+	}
+
+	// read-your-writes goes to the primary
+	expectBalance(primaryMock, 30)
+	user, err := repo.GetUsersBalance(WithPrimaryRead(ctx), 1, "")
+	if err != nil || user.Balance != 30 {
+		t.Errorf("expected the balance of the primary, have %v, %v", user, err)
+	}
+
+	if err = primaryMock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+	if err = replicaMock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
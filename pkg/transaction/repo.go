@@ -1,58 +1,221 @@
 package transaction
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"math"
 	"sort"
 	"strings"
 	"time"
 )
 
+// Clock returns the current time, tests replace it to get stable timestamps.
+type Clock func() time.Time
+
+// DefaultTimeout bounds every repository operation unless its context ends
+// earlier.
+const DefaultTimeout = 10 * time.Second
+
 type RepositoryItem struct {
-	DB *sql.DB
+	// DB is the primary, all writes go there.
+	DB    *sql.DB
+	Clock Clock
+	// Timeout is the deadline of one operation, 0 leaves only the deadline of
+	// the caller's context.
+	Timeout time.Duration
+	// MaxReplicaLag is how far behind the primary a replica may be to serve
+	// the balance and the history.
+	MaxReplicaLag time.Duration
+	// ArchiveDir keeps the archived months of the history. Every instance
+	// serving the history needs it once archiving is used, empty turns
+	// archiving off.
+	ArchiveDir string
+	// RiskRules screen the debits before they are committed, none lets them
+	// all through unrecorded.
+	RiskRules []RiskRule
+	// ApprovalThreshold is the largest withdrawal or transfer run at once,
+	// larger ones wait for approval up to ApprovalTTL. 0 runs them all.
+	ApprovalThreshold float64
+	ApprovalTTL       time.Duration
+	// LiveRate gives today's rate of a currency when it is not stored, like
+	// CurrencyLayerRate. nil converts with the stored rates only.
+	LiveRate RateFunc
+	// MaxRateAge is how much older than the asked day a stored rate may be,
+	// 0 takes the last rate however old.
+	MaxRateAge time.Duration
+	// IdempotencyTimeout frees the key reserved by a request without an
+	// answer, IdempotencyTTL is how long an answer is kept.
+	IdempotencyTimeout time.Duration
+	IdempotencyTTL     time.Duration
+
+	replicas    []*replica
+	nextReplica uint32
 }
 
+// TransactionInterface runs the queries of one operation. The values passed
+// to the helpers are bound to the context of the operation by withContext.
 type TransactionInterface interface {
 	QueryRow(query string, args ...interface{}) *sql.Row
 	Exec(query string, args ...interface{}) (sql.Result, error)
 	Query(query string, args ...interface{}) (*sql.Rows, error)
 }
 
-func NewRepository(db *sql.DB) *RepositoryItem {
-	return &RepositoryItem{
-		DB: db,
+// contextExecutor is implemented by *sql.DB and *sql.Tx.
+type contextExecutor interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+type contextDB struct {
+	ctx context.Context
+	db  contextExecutor
+}
+
+// withContext runs the queries on db with ctx, so they are cancelled with it.
+// A transaction begun with ctx is rolled back when ctx is done.
+func withContext(ctx context.Context, db contextExecutor) TransactionInterface {
+	return contextDB{ctx: ctx, db: db}
+}
+
+func (c contextDB) QueryRow(query string, args ...interface{}) *sql.Row {
+	return c.db.QueryRowContext(c.ctx, query, args...)
+}
+
+func (c contextDB) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return c.db.ExecContext(c.ctx, query, args...)
+}
+
+func (c contextDB) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return c.db.QueryContext(c.ctx, query, args...)
+}
+
+// NewRepository works with the primary db, reads of the balance and the
+// history go to the replicas when they are given.
+func NewRepository(db *sql.DB, replicas ...*sql.DB) *RepositoryItem {
+	r := &RepositoryItem{
+		DB:                 db,
+		Clock:              time.Now,
+		Timeout:            DefaultTimeout,
+		MaxReplicaLag:      DefaultMaxReplicaLag,
+		MaxRateAge:         DefaultMaxRateAge,
+		IdempotencyTimeout: DefaultIdempotencyTimeout,
+		IdempotencyTTL:     DefaultIdempotencyTTL,
+	}
+	for _, replicaDB := range replicas {
+		r.replicas = append(r.replicas, &replica{db: replicaDB})
+	}
+	return r
+}
+
+// operation adds the deadline of the repository to ctx.
+func (r *RepositoryItem) operation(ctx context.Context) (context.Context, context.CancelFunc) {
+	if r.Timeout <= 0 {
+		return context.WithCancel(ctx)
 	}
+	return context.WithTimeout(ctx, r.Timeout)
+}
+
+// now is the timestamp stored with new rows: UTC with the microsecond
+// precision of postgres TIMESTAMPTZ.
+func (r *RepositoryItem) now() time.Time {
+	return r.Clock().UTC().Truncate(time.Microsecond)
 }
 
-func (r *RepositoryItem) GetUsersBalance(userID int, currency string) (*User, error) {
+func (r *RepositoryItem) GetUsersBalance(ctx context.Context, userID int, currency string) (*User, error) {
+	ctx, cancel := r.operation(ctx)
+	defer cancel()
+
 	tr := &User{
 		UserID:  userID,
 		Balance: 0,
 	}
 
-	err := r.DB.QueryRow(`SELECT balance FROM users WHERE id = $1`, userID).Scan(&tr.Balance)
+	var creditLimit float64
+	err := r.read(ctx, func(db *sql.DB) error {
+		// the held money is not available
+		err := db.QueryRowContext(ctx, `SELECT balance, credit_limit - held FROM users WHERE id = $1`, userID).Scan(&tr.Balance, &creditLimit)
+		if err != nil {
+			return err
+		}
+
+		tr.Bonuses, err = r.activeBonuses(ctx, userID, db)
+		return err
+	})
+	if err == sql.ErrNoRows {
+		return nil, ErrAccountNotFound
+	}
 	if err != nil {
 		return nil, err
 	}
 
-	if currency == "" || currency == "RUB" {
+	if len(tr.Bonuses) == 0 {
+		tr.Bonuses = nil
+	} else {
+		bonus := 0.0
+		for _, g := range tr.Bonuses {
+			bonus += g.Remaining
+		}
+		tr.Bonus = &bonus
+	}
+
+	// today's rate unless another date is asked for
+	day, ok := RateDate(ctx)
+	if !ok {
+		day = rateDay(r.now())
+	}
+	return convertBalance(ctx, tr, creditLimit, currency, func(ctx context.Context, currency string) (float64, error) {
+		return r.rateAt(ctx, currency, day)
+	})
+}
+
+// convertBalance fills the available money and converts the sums from RUB at
+// the rate given by rate.
+func convertBalance(ctx context.Context, tr *User, creditLimit float64, currency string, rate RateFunc) (*User, error) {
+	available := tr.Balance + creditLimit
+	tr.Available = &available
+
+	if currency == "" || currency == BaseCurrency {
 		return tr, nil
 	}
 
-	value, err := getCurrencyFromRub(currency)
+	value, err := rate(ctx, currency)
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	if errors.Is(err, ErrRateNotFound) {
+		return nil, err
+	}
 	if err != nil {
 		return nil, fmt.Errorf("didn`t convert currency: %s", currency)
 	}
 
 	tr.Balance /= value
+	available /= value
+	if tr.Bonus != nil {
+		*tr.Bonus /= value
+		for _, g := range tr.Bonuses {
+			g.Amount /= value
+			g.Remaining /= value
+		}
+	}
 
 	return tr, nil
 }
 
-func (r *RepositoryItem) CreateUsers(userID int) error {
+func (r *RepositoryItem) CreateUsers(ctx context.Context, userID int) error {
+	ctx, cancel := r.operation(ctx)
+	defer cancel()
+
+	return createUser(userID, withContext(ctx, r.DB))
+}
+
+func createUser(userID int, db TransactionInterface) error {
 	var id int
 	balance := 0
-	err := r.DB.QueryRow("INSERT INTO users (id, balance) VALUES ($1, $2) returning id", userID, balance).Scan(&id)
+	err := db.QueryRow("INSERT INTO users (id, balance) VALUES ($1, $2) returning id", userID, balance).Scan(&id)
 	if err != nil {
 		return fmt.Errorf("dont create such user")
 	}
@@ -60,191 +223,523 @@ func (r *RepositoryItem) CreateUsers(userID int) error {
 	return nil
 }
 
-func (r *RepositoryItem) AddMoney(userID int, money float64) error {
+func (r *RepositoryItem) AddMoney(ctx context.Context, userID int, money float64) (*Transaction, error) {
 	if money < 0 {
-		return fmt.Errorf("negative amount")
+		return nil, ErrNegativeAmount
 	}
 
-	tx, err := r.DB.Begin()
+	ctx, cancel := r.operation(ctx)
+	defer cancel()
+
+	tx, err := r.DB.BeginTx(ctx, nil)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	err = r.appendMoneyToUser(userID, money, tx)
+	tr, err := r.deposit(userID, money, withContext(ctx, tx))
 	if err != nil {
 		//nolint:errcheck
 		tx.Rollback()
-		return err
+		return nil, err
 	}
 
-	err = writeTransaction(&userID, nil, money, tx)
+	err = tx.Commit()
 	if err != nil {
-		//nolint:errcheck
-		tx.Rollback()
-		return err
+		return nil, err
 	}
 
-	//nolint:errcheck
-	tx.Commit()
-
-	return nil
+	return tr, nil
 }
 
-func (r *RepositoryItem) appendMoneyToUser(userID int, money float64, db TransactionInterface) error {
-	if money < 0 {
-		return fmt.Errorf("negative amount")
+func (r *RepositoryItem) deposit(userID int, money float64, db TransactionInterface) (*Transaction, error) {
+	balance, err := r.appendMoneyToUser(userID, money, db)
+	if err != nil {
+		return nil, err
 	}
 
-	_, err := r.GetUsersBalance(userID, "")
-	if err != nil && err != sql.ErrNoRows {
-		return err
+	tr, err := r.writeTransaction(&userID, nil, money, nil, db)
+	if err != nil {
+		return nil, err
 	}
+	tr.Balance = &balance
 
-	if err == sql.ErrNoRows {
-		err = r.CreateUsers(userID)
+	return tr, nil
+}
+
+func (r *RepositoryItem) appendMoneyToUser(userID int, money float64, db TransactionInterface) (float64, error) {
+	if money < 0 {
+		return 0, ErrNegativeAmount
+	}
+
+	// the first deposit still opens an account implicitly
+	acc, err := lockAccount(userID, db)
+	if err == ErrAccountNotFound {
+		err = createUser(userID, db)
 		if err != nil {
-			return err
+			return 0, err
 		}
+		acc = &Account{ID: userID, Status: AccountActive}
+	}
+	if err != nil {
+		return 0, err
 	}
 
-	_, err = db.Exec("UPDATE users SET balance = balance + $1 WHERE id = $2", money, userID)
+	err = acc.canCredit()
 	if err != nil {
-		return err
+		return 0, err
 	}
 
-	return nil
+	var balance float64
+	err = db.QueryRow("UPDATE users SET balance = balance + $1 WHERE id = $2 returning balance", money, userID).
+		Scan(&balance)
+	if err != nil {
+		return 0, err
+	}
+
+	err = r.recordOverdraft(userID, acc.Balance, balance, db)
+	if err != nil {
+		return 0, err
+	}
+
+	return balance, nil
 }
 
-func (r *RepositoryItem) getMoneyFromDB(userID int, money float64, db TransactionInterface) error {
+func (r *RepositoryItem) getMoneyFromDB(userID int, money float64, db TransactionInterface) (float64, error) {
 	if money < 0 {
-		return fmt.Errorf("negative amount")
+		return 0, ErrNegativeAmount
 	}
 
-	tr, err := r.GetUsersBalance(userID, "")
+	acc, err := lockAccount(userID, db)
 	if err != nil {
-		return err
+		return 0, err
+	}
+
+	err = acc.canDebit()
+	if err != nil {
+		return 0, err
 	}
 
-	if tr.Balance < money {
-		return fmt.Errorf("not enough money")
+	// the credit limit is how far below zero the account may go
+	if acc.Balance+acc.CreditLimit < money {
+		return 0, ErrNotEnoughMoney
 	}
 
-	_, err = db.Exec("UPDATE users SET balance = balance - $1 WHERE id = $2", money, userID)
+	// the money held for pending operations can't be spent
+	var balance float64
+	err = db.QueryRow("UPDATE users SET balance = balance - $1 WHERE id = $2 AND balance + credit_limit - held >= $1 "+
+		"returning balance", money, userID).Scan(&balance)
+	if err == sql.ErrNoRows {
+		return 0, ErrNotEnoughMoney
+	}
 	if err != nil {
-		return err // failed to withdraw money
+		return 0, err // failed to withdraw money
 	}
 
-	return nil
+	err = r.recordOverdraft(userID, acc.Balance, balance, db)
+	if err != nil {
+		return 0, err
+	}
+
+	return balance, nil
 }
 
-func (r *RepositoryItem) WithdrawMoney(userID int, money float64) error {
-	tx, err := r.DB.Begin()
+// WithdrawMoney debits money in the currency given by WithOperationCurrency,
+// RUB by default.
+func (r *RepositoryItem) WithdrawMoney(ctx context.Context, userID int, money float64) (*Transaction, error) {
+	money, cur, err := r.inRoubles(ctx, money)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	ctx, cancel := r.operation(ctx)
+	defer cancel()
 
-	err = r.getMoneyFromDB(userID, money, tx)
+	tx, err := r.DB.BeginTx(ctx, nil)
 	if err != nil {
+		return nil, err
+	}
+
+	tr, err := r.debit(&payment{Operation: OperationWithdraw, UserID: userID, Money: money, Service: Service(ctx),
+		RequestedBy: Requester(ctx), Currency: cur}, withContext(ctx, tx))
+	if err != nil && !isHeld(err) {
 		//nolint:errcheck
 		tx.Rollback()
-		return err
+		return nil, r.recordRisk(ctx, err)
 	}
 
-	err = writeTransaction(nil, &userID, -money, r.DB)
+	commitErr := tx.Commit()
+	if commitErr != nil {
+		return nil, commitErr
+	}
+
+	return tr, err
+}
+
+// withdraw debits the real money of a withdrawal, bonus is the part paid with
+// bonus money. The limits and the fee apply to both, the fee is paid with real
+// money.
+func (r *RepositoryItem) withdraw(userID int, money, bonus float64, cur opCurrency, db TransactionInterface) (*Transaction, error) {
+	balance, err := r.getMoneyFromDB(userID, money, db)
 	if err != nil {
-		//nolint:errcheck
-		tx.Rollback()
-		return err
+		return nil, err
 	}
 
-	//nolint:errcheck
-	tx.Commit()
+	err = r.checkLimits(userID, math.Round((money+bonus)*100)/100, false, db)
+	if err != nil {
+		return nil, err
+	}
 
-	return nil
+	tr, err := r.writeTransaction(nil, &userID, -money, nil, db)
+	if err != nil {
+		return nil, err
+	}
+
+	tr.Fee, err = r.chargeFee(OperationWithdraw, userID, math.Round((money+bonus)*100)/100, cur, tr.ID, db)
+	if err != nil {
+		return nil, err
+	}
+	if tr.Fee != nil {
+		balance = *tr.Fee.Balance
+	}
+	tr.Balance = &balance
+
+	return tr, nil
 }
 
-func (r *RepositoryItem) TransferMoney(fromUserID int, toUserID int, money float64) error {
+// TransferMoney moves money in the currency given by WithOperationCurrency,
+// RUB by default.
+func (r *RepositoryItem) TransferMoney(ctx context.Context, fromUserID int, toUserID int, money float64) (*Transaction, error) {
 	if money < 0 {
-		return fmt.Errorf("negative amount")
+		return nil, ErrNegativeAmount
 	}
-	tx, err := r.DB.Begin()
+	money, cur, err := r.inRoubles(ctx, money)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	err = r.getMoneyFromDB(fromUserID, money, tx)
+	ctx, cancel := r.operation(ctx)
+	defer cancel()
+
+	tx, err := r.DB.BeginTx(ctx, nil)
 	if err != nil {
+		return nil, err
+	}
+
+	tr, err := r.debit(&payment{Operation: OperationTransfer, UserID: fromUserID, ToUserID: &toUserID, Money: money,
+		RequestedBy: Requester(ctx), Currency: cur}, withContext(ctx, tx))
+	if err != nil && !isHeld(err) {
 		//nolint:errcheck
 		tx.Rollback()
-		return err
+		return nil, r.recordRisk(ctx, err)
+	}
+
+	commitErr := tx.Commit()
+	if commitErr != nil {
+		return nil, commitErr
 	}
 
-	err = r.appendMoneyToUser(toUserID, money, tx)
+	return tr, err
+}
+
+func (r *RepositoryItem) transfer(fromUserID int, toUserID int, money float64, cur opCurrency, db TransactionInterface) (*Transaction, error) {
+	balance, err := r.getMoneyFromDB(fromUserID, money, db)
 	if err != nil {
-		//nolint:errcheck
-		tx.Rollback()
-		return err
+		return nil, err
 	}
 
-	err = writeTransaction(&toUserID, &fromUserID, money, tx)
+	err = r.checkLimits(fromUserID, money, true, db)
 	if err != nil {
-		//nolint:errcheck
-		tx.Rollback()
-		return err
+		return nil, err
 	}
 
-	//nolint:errcheck
-	tx.Commit()
+	_, err = r.appendMoneyToUser(toUserID, money, db)
+	if err != nil {
+		return nil, err
+	}
 
-	return nil
+	tr, err := r.writeTransaction(&toUserID, &fromUserID, money, nil, db)
+	if err != nil {
+		return nil, err
+	}
+
+	tr.Fee, err = r.chargeFee(OperationTransfer, fromUserID, money, cur, tr.ID, db)
+	if err != nil {
+		return nil, err
+	}
+	if tr.Fee != nil {
+		balance = *tr.Fee.Balance
+	}
+	tr.Balance = &balance
+
+	return tr, nil
 }
 
-func writeTransaction(toID, fromID *int, money float64, db TransactionInterface) error {
-	var id int
-	created := time.Now().Format("2006-01-02 15:01")
+func (r *RepositoryItem) writeTransaction(toID, fromID *int, money float64, refundOf *int, db TransactionInterface) (*Transaction, error) {
+	tr := &Transaction{
+		ToID:     toID,
+		FromID:   fromID,
+		Money:    money,
+		RefundOf: refundOf,
+	}
 
-	err := db.QueryRow("INSERT INTO transaction (to_id, from_id, money, created) VALUES ($1, $2, $3, $4) returning id",
-		toID, fromID, money, created).Scan(&id)
+	err := db.QueryRow("INSERT INTO transaction (to_id, from_id, money, created, refund_of) VALUES ($1, $2, $3, $4, $5) returning id, created",
+		toID, fromID, money, r.now(), refundOf).Scan(&tr.ID, &tr.Created)
 	if err != nil {
-		return fmt.Errorf("dont create transaction: %v", err)
+		return nil, fmt.Errorf("dont create transaction: %v", err)
 	}
+	tr.Created = tr.Created.UTC()
 
-	return nil
+	return tr, nil
+}
+
+func (r *RepositoryItem) GetTransactionByID(ctx context.Context, transactionID int) (*Transaction, error) {
+	ctx, cancel := r.operation(ctx)
+	defer cancel()
+
+	var tr *Transaction
+	err := r.read(ctx, func(db *sql.DB) error {
+		tr = &Transaction{}
+		err := db.QueryRowContext(ctx, "SELECT id, to_id, from_id, money, created, refund_of, fee_of, split_of FROM transaction WHERE id = $1",
+			transactionID).Scan(&tr.ID, &tr.ToID, &tr.FromID, &tr.Money, &tr.Created, &tr.RefundOf, &tr.FeeOf, &tr.SplitOf)
+		if err == sql.ErrNoRows {
+			return ErrTransactionNotFound
+		}
+		if err != nil {
+			return err
+		}
+		tr.Created = tr.Created.UTC()
+
+		if tr.isSplit() {
+			tr.Legs, err = r.getSplitLegs(transactionID, withContext(ctx, db))
+		}
+		return err
+	})
+	if err == ErrTransactionNotFound {
+		return r.archivedTransaction(ctx, transactionID)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return tr, nil
+}
+
+func (r *RepositoryItem) GetTransaction(ctx context.Context, userID int, orderBy string) ([]*Transaction, error) {
+	ctx, cancel := r.operation(ctx)
+	defer cancel()
+
+	var info []*Transaction
+	var details *historyDetails
+	err := r.read(ctx, func(db *sql.DB) error {
+		rows, err := db.QueryContext(ctx, "SELECT id, to_id, from_id, money, created, refund_of, fee_of, split_of FROM transaction where to_id = $1 or from_id = $1 ORDER BY id",
+			userID)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		info, err = scanHistory(rows)
+		if err != nil {
+			return err
+		}
+
+		details, err = readHistoryDetails(ctx, userID, db)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	archived, err := r.archivedHistory(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if len(archived) > 0 {
+		info = append(archived, info...)
+		sort.SliceStable(info, func(i, j int) bool {
+			return info[i].ID < info[j].ID
+		})
+	}
+	details.fill(info)
+
+	return sortHistory(info, orderBy)
 }
 
-func (r *RepositoryItem) GetTransaction(userID int, orderBy string) ([]*Transaction, error) {
-	rows, err := r.DB.Query("SELECT to_id, from_id, money, created FROM transaction where to_id = $1 or from_id = $1",
-		userID)
+// GetTransactionPage is a page of GetTransaction: at most limit operations
+// after the operation after in the orderBy order, from the first one when
+// after is nil. Only the keys of after are used: its id and the money or the
+// date the history is ordered by, so it has to be taken before the page is
+// converted to another currency. The archived operations are read for every
+// page.
+func (r *RepositoryItem) GetTransactionPage(ctx context.Context, userID int, orderBy string, after *Transaction,
+	limit int) ([]*Transaction, error) {
+	less, err := historyOrder(orderBy)
+	if err != nil {
+		return nil, err
+	}
+	if limit <= 0 {
+		return nil, ErrBadOperation
+	}
+
+	query := "SELECT id, to_id, from_id, money, created, refund_of, fee_of, split_of FROM transaction " +
+		"WHERE (to_id = $1 OR from_id = $1)"
+	args := []interface{}{userID}
+	column := "id"
+	switch strings.ToLower(orderBy) {
+	case "date":
+		column = "created"
+		if after != nil {
+			query += " AND (created, id) > ($2, $3)"
+			args = append(args, after.Created, after.ID)
+		}
+	case "money":
+		column = "money"
+		if after != nil {
+			query += " AND (money, id) > ($2, $3)"
+			args = append(args, after.Money, after.ID)
+		}
+	default:
+		if after != nil {
+			query += " AND id > $2"
+			args = append(args, after.ID)
+		}
+	}
+	query += fmt.Sprintf(" ORDER BY %s, id LIMIT $%d", column, len(args)+1)
+	args = append(args, limit)
+
+	ctx, cancel := r.operation(ctx)
+	defer cancel()
+
+	var info []*Transaction
+	var details *historyDetails
+	err = r.read(ctx, func(db *sql.DB) error {
+		rows, err := db.QueryContext(ctx, query, args...)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		info, err = scanHistory(rows)
+		if err != nil {
+			return err
+		}
+
+		details, err = readHistoryDetails(ctx, userID, db)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	archived, err := r.archivedHistory(ctx, userID)
 	if err != nil {
 		return nil, err
 	}
+	if len(archived) > 0 {
+		for _, tr := range archived {
+			if after == nil || less(after, tr) {
+				info = append(info, tr)
+			}
+		}
+		sort.SliceStable(info, func(i, j int) bool {
+			return less(info[i], info[j])
+		})
+		if len(info) > limit {
+			info = info[:limit]
+		}
+	}
+	details.fill(info)
+
+	return info, nil
+}
 
+func scanHistory(rows *sql.Rows) ([]*Transaction, error) {
 	info := make([]*Transaction, 0, 10)
 	for rows.Next() {
 		curr := &Transaction{}
-		err = rows.Scan(&curr.ToID, &curr.FromID, &curr.Money, &curr.Created)
+		err := rows.Scan(&curr.ID, &curr.ToID, &curr.FromID, &curr.Money, &curr.Created, &curr.RefundOf, &curr.FeeOf, &curr.SplitOf)
 		if err != nil {
 			return nil, err
 		}
+		curr.Created = curr.Created.UTC()
 		info = append(info, curr)
 	}
 
-	if orderBy == "" {
-		return info, nil
+	return info, rows.Err()
+}
+
+// historyDetails are the bonus money and the pending holds shown with the rows
+// of the history of an account.
+type historyDetails struct {
+	bonus map[string]map[int]float64
+	holds map[int]*pendingHold
+}
+
+func readHistoryDetails(ctx context.Context, userID int, db *sql.DB) (*historyDetails, error) {
+	bonus, err := bonusSpent(ctx, userID, db)
+	if err != nil {
+		return nil, err
 	}
 
-	lowOrderBy := strings.ToLower(orderBy)
-	if lowOrderBy == "date" {
-		sort.Slice(info, func(i, j int) bool {
-			return info[i].Created.Before(info[j].Created)
-		})
-		return info, nil
-	} else if lowOrderBy == "money" {
-		sort.Slice(info, func(i, j int) bool {
-			return info[i].Money < info[j].Money
-		})
-		return info, nil
+	holds, err := pendingHistory(ctx, userID, db)
+	if err != nil {
+		return nil, err
+	}
+
+	return &historyDetails{bonus: bonus, holds: holds}, nil
+}
+
+func (d *historyDetails) fill(info []*Transaction) {
+	for _, curr := range info {
+		curr.Bonus = d.bonus[BonusSpend][curr.ID] + d.bonus[BonusRefund][curr.ID]
+		curr.BonusExpired = d.bonus[BonusWriteOff][curr.ID]
+		curr.BonusGranted = d.bonus[BonusGranted][curr.ID]
+		if h, ok := d.holds[curr.ID]; ok {
+			pendingID := h.PendingID
+			curr.PendingID, curr.PendingStatus, curr.Held = &pendingID, h.Status, h.Held
+		}
+	}
+}
+
+// historyOrder compares the operations by "date" or "money", ties and the
+// empty orderBy go by id.
+func historyOrder(orderBy string) (func(a, b *Transaction) bool, error) {
+	switch strings.ToLower(orderBy) {
+	case "":
+		return func(a, b *Transaction) bool {
+			return a.ID < b.ID
+		}, nil
+	case "date":
+		return func(a, b *Transaction) bool {
+			if !a.Created.Equal(b.Created) {
+				return a.Created.Before(b.Created)
+			}
+			return a.ID < b.ID
+		}, nil
+	case "money":
+		return func(a, b *Transaction) bool {
+			if a.Money != b.Money {
+				return a.Money < b.Money
+			}
+			return a.ID < b.ID
+		}, nil
 	}
 
 	return nil, fmt.Errorf("bad orderBy value")
 }
+
+// sortHistory orders the operations by "date" or "money", ties and the empty
+// orderBy keep the order of ids.
+func sortHistory(info []*Transaction, orderBy string) ([]*Transaction, error) {
+	if orderBy == "" {
+		return info, nil
+	}
+
+	less, err := historyOrder(orderBy)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(info, func(i, j int) bool {
+		return less(info[i], info[j])
+	})
+	return info, nil
+}
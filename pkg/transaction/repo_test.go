@@ -1,7 +1,9 @@
 package transaction
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	sqlmock "gopkg.in/DATA-DOG/go-sqlmock.v1"
 	"reflect"
@@ -9,6 +11,60 @@ import (
 	"time"
 )
 
+var testTime = time.Date(2021, 11, 27, 15, 4, 5, 123456000, time.UTC)
+
+var ctx = context.Background()
+
+func testClock() time.Time {
+	return testTime
+}
+
+func accountRows(balance float64) *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"balance", "status", "debit_blocked", "credit_blocked", "credit_limit"}).
+		AddRow(balance, AccountActive, false, false, 0.0)
+}
+
+func expectNoLimits(mock sqlmock.Sqlmock) {
+	mock.
+		ExpectQuery("SELECT user_id, max_operation, daily_debit, monthly_debit, hourly_transfers FROM limits").
+		WithArgs(DefaultLimitsID, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "max_operation", "daily_debit", "monthly_debit", "hourly_transfers"}))
+}
+
+func expectNoFee(mock sqlmock.Sqlmock, operation string) {
+	mock.
+		ExpectQuery("SELECT id, operation, currency, user_id, fixed, percent, min_fee, max_fee FROM fee_rules").
+		WithArgs(operation, BaseCurrency, 1).
+		WillReturnRows(sqlmock.NewRows(feeRuleColumnNames))
+}
+
+func expectNoBonuses(mock sqlmock.Sqlmock) {
+	mock.
+		ExpectQuery("SELECT id, remaining FROM bonus_grants WHERE").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "remaining"}))
+}
+
+func expectNoBonusBalance(mock sqlmock.Sqlmock, userID int) {
+	mock.
+		ExpectQuery("SELECT id, user_id, amount, remaining, services, created, expires_at FROM bonus_grants WHERE").
+		WithArgs(userID, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows(bonusColumnNames))
+}
+
+func expectNoPendingHistory(mock sqlmock.Sqlmock, userID int) {
+	mock.
+		ExpectQuery("SELECT e.history_id, e.pending_id, e.status, p.held FROM pending_events e").
+		WithArgs(userID).
+		WillReturnRows(sqlmock.NewRows([]string{"history_id", "pending_id", "status", "held"}))
+}
+
+func expectNoBonusSpent(mock sqlmock.Sqlmock, userID int) {
+	mock.
+		ExpectQuery("SELECT transaction_id, kind, ABS\\(SUM\\(amount\\)\\) FROM bonus_movements WHERE").
+		WithArgs(userID).
+		WillReturnRows(sqlmock.NewRows([]string{"transaction_id", "kind", "bonus"}))
+}
+
 func TestGetUsersBalance(t *testing.T) {
 	db, mock, err := sqlmock.New()
 	if err != nil {
@@ -18,24 +74,27 @@ func TestGetUsersBalance(t *testing.T) {
 
 	// good query
 	elemID := 1
-	rows := sqlmock.NewRows([]string{"balance"})
+	rows := sqlmock.NewRows([]string{"balance", "credit_limit"})
+	available := 167.4
 	expect := []*User{&User{
-		UserID:  elemID,
-		Balance: 67.4,
+		UserID:    elemID,
+		Balance:   67.4,
+		Available: &available,
 	}}
 
 	for _, item := range expect {
-		rows = rows.AddRow(item.Balance)
+		rows = rows.AddRow(item.Balance, 100.0)
 	}
 
 	mock.
-		ExpectQuery("SELECT balance FROM users WHERE").
+		ExpectQuery("SELECT balance, credit_limit - held FROM users WHERE").
 		WithArgs(elemID).
 		WillReturnRows(rows)
+	expectNoBonusBalance(mock, elemID)
 
 	repo := NewRepository(db)
 
-	tr, err := repo.GetUsersBalance(elemID, "")
+	tr, err := repo.GetUsersBalance(ctx, elemID, "")
 	if err != nil {
 		t.Errorf("unexpected err: %s", err)
 		return
@@ -63,12 +122,12 @@ func TestGetUsersBalanceErrors(t *testing.T) {
 	elemID := 1
 
 	mock.
-		ExpectQuery("SELECT balance FROM users WHERE").
+		ExpectQuery("SELECT balance, credit_limit - held FROM users WHERE").
 		WithArgs(elemID).
 		WillReturnError(fmt.Errorf("db_error"))
 
 	repo := NewRepository(db)
-	_, err = repo.GetUsersBalance(elemID, "")
+	_, err = repo.GetUsersBalance(ctx, elemID, "")
 
 	if err2 := mock.ExpectationsWereMet(); err2 != nil {
 		t.Errorf("there were unfulfilled expectations: %s", err)
@@ -78,6 +137,21 @@ func TestGetUsersBalanceErrors(t *testing.T) {
 		t.Errorf("expected error, got nil")
 		return
 	}
+
+	// unknown account
+	mock.
+		ExpectQuery("SELECT balance, credit_limit - held FROM users WHERE").
+		WithArgs(elemID).
+		WillReturnRows(sqlmock.NewRows([]string{"balance", "credit_limit"}))
+
+	_, err = repo.GetUsersBalance(ctx, elemID, "")
+	if err != ErrAccountNotFound {
+		t.Errorf("expected ErrAccountNotFound, got %v", err)
+		return
+	}
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
 }
 
 func TestCreateUsers(t *testing.T) {
@@ -103,7 +177,7 @@ func TestCreateUsers(t *testing.T) {
 
 	repo := NewRepository(db)
 
-	err = repo.CreateUsers(elemID)
+	err = repo.CreateUsers(ctx, elemID)
 	if err != nil {
 		t.Errorf("unexpected err: %s", err)
 		return
@@ -121,7 +195,7 @@ func TestCreateUsers(t *testing.T) {
 		WithArgs(elemID, 0).
 		WillReturnError(fmt.Errorf("dont create such user"))
 
-	err = repo.CreateUsers(elemID)
+	err = repo.CreateUsers(ctx, elemID)
 	if err2 := mock.ExpectationsWereMet(); err2 != nil {
 		t.Errorf("there were unfulfilled expectations: %s", err)
 		return
@@ -139,6 +213,7 @@ func TestAddMoney(t *testing.T) {
 	}
 	defer db.Close()
 	repo := NewRepository(db)
+	repo.Clock = testClock
 
 	rows := sqlmock.NewRows([]string{"id"})
 	elemID := 1
@@ -149,23 +224,23 @@ func TestAddMoney(t *testing.T) {
 
 	mock.ExpectBegin()
 	mock.
-		ExpectQuery("SELECT balance FROM users WHERE").
+		ExpectQuery("SELECT balance, status, debit_blocked, credit_blocked, credit_limit FROM users WHERE").
 		WithArgs(1).
-		WillReturnRows(rows)
+		WillReturnRows(accountRows(1.0))
 
 	mock.
-		ExpectExec("UPDATE users SET").
+		ExpectQuery("UPDATE users SET").
 		WithArgs(55.3, 1).
-		WillReturnResult(sqlmock.NewResult(1, 1))
+		WillReturnRows(sqlmock.NewRows([]string{"balance"}).AddRow(0.0))
 	rows.AddRow(1)
 	mock.
 		ExpectQuery("INSERT INTO transaction").
-		WithArgs(&elemID, nil, 55.3, time.Now().Format("2006-01-02 15:01")).
-		WillReturnRows(rows)
+		WithArgs(&elemID, nil, 55.3, testTime, nil).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created"}).AddRow(1, time.Now()))
 
 	mock.ExpectCommit()
 	// ok query
-	err = repo.AddMoney(1, 55.3)
+	_, err = repo.AddMoney(ctx, 1, 55.3)
 
 	if err != nil {
 		t.Errorf("unexpected err: %s", err)
@@ -185,8 +260,9 @@ func TestAddMoneyError(t *testing.T) {
 	}
 	defer db.Close()
 	repo := NewRepository(db)
+	repo.Clock = testClock
 
-	err = repo.AddMoney(1, -23.12)
+	_, err = repo.AddMoney(ctx, 1, -23.12)
 	if err == nil {
 		t.Errorf("expected error, got nil")
 		return
@@ -194,7 +270,7 @@ func TestAddMoneyError(t *testing.T) {
 	// expect begin
 	mock.ExpectBegin().WillReturnError(fmt.Errorf("shahajskd"))
 
-	err = repo.AddMoney(1, 23.12)
+	_, err = repo.AddMoney(ctx, 1, 23.12)
 	if err == nil {
 		t.Errorf("expected error, got nil")
 		return
@@ -206,9 +282,9 @@ func TestAddMoneyError(t *testing.T) {
 	//
 	mock.ExpectBegin()
 	mock.
-		ExpectQuery("SELECT balance FROM users WHERE").
+		ExpectQuery("SELECT balance, status, debit_blocked, credit_blocked, credit_limit FROM users WHERE").
 		WithArgs(1).WillReturnError(fmt.Errorf("no rows"))
-	err = repo.AddMoney(1, 23.12)
+	_, err = repo.AddMoney(ctx, 1, 23.12)
 	if err == nil {
 		t.Errorf("expected error, got nil")
 		return
@@ -220,14 +296,14 @@ func TestAddMoneyError(t *testing.T) {
 
 	mock.ExpectBegin()
 	mock.
-		ExpectQuery("SELECT balance FROM users WHERE").
+		ExpectQuery("SELECT balance, status, debit_blocked, credit_blocked, credit_limit FROM users WHERE").
 		WithArgs(1).
 		WillReturnError(sql.ErrNoRows)
 	mock.
 		ExpectQuery("INSERT INTO users").
 		WithArgs(1, 0).
 		WillReturnError(fmt.Errorf("dont create such user"))
-	err = repo.AddMoney(1, 23.12)
+	_, err = repo.AddMoney(ctx, 1, 23.12)
 	if err == nil {
 		t.Errorf("expected error, got nil")
 		return
@@ -246,16 +322,16 @@ func TestAddMoneyError(t *testing.T) {
 
 	mock.ExpectBegin()
 	mock.
-		ExpectQuery("SELECT balance FROM users WHERE").
+		ExpectQuery("SELECT balance, status, debit_blocked, credit_blocked, credit_limit FROM users WHERE").
 		WithArgs(1).
-		WillReturnRows(rows)
+		WillReturnRows(accountRows(1.0))
 
 	mock.
-		ExpectExec("UPDATE users SET").
+		ExpectQuery("UPDATE users SET").
 		WithArgs(55.3, 1).
 		WillReturnError(fmt.Errorf("error"))
 
-	err = repo.AddMoney(1, 55.3)
+	_, err = repo.AddMoney(ctx, 1, 55.3)
 	if err == nil {
 		t.Errorf("expected error, got nil")
 		return
@@ -267,21 +343,21 @@ func TestAddMoneyError(t *testing.T) {
 	// error write transaction
 	mock.ExpectBegin()
 	mock.
-		ExpectQuery("SELECT balance FROM users WHERE").
+		ExpectQuery("SELECT balance, status, debit_blocked, credit_blocked, credit_limit FROM users WHERE").
 		WithArgs(1).
-		WillReturnRows(rows)
+		WillReturnRows(accountRows(1.0))
 
 	mock.
-		ExpectExec("UPDATE users SET").
+		ExpectQuery("UPDATE users SET").
 		WithArgs(55.3, 1).
-		WillReturnResult(sqlmock.NewResult(1, 1))
+		WillReturnRows(sqlmock.NewRows([]string{"balance"}).AddRow(0.0))
 	rows.AddRow(1)
 	mock.
 		ExpectQuery("INSERT INTO transaction").
-		WithArgs(&elemID, nil, 55.3, time.Now().Format("2006-01-02 15:01")).
+		WithArgs(&elemID, nil, 55.3, testTime, nil).
 		WillReturnError(fmt.Errorf("don`t write transaction"))
 
-	err = repo.AddMoney(1, 55.3)
+	_, err = repo.AddMoney(ctx, 1, 55.3)
 	if err == nil {
 		t.Errorf("expected error, got nil")
 		return
@@ -291,6 +367,69 @@ func TestAddMoneyError(t *testing.T) {
 	}
 }
 
+// TestAddMoneyCancel checks that a deadline or a cancel in the middle of an
+// operation stops the query and rolls the transaction back.
+func TestAddMoneyCancel(t *testing.T) {
+	callerCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	for _, c := range []struct {
+		name    string
+		timeout time.Duration
+		ctx     context.Context
+		cancel  func()
+	}{
+		{"deadline of the repository", 10 * time.Millisecond, ctx, nil},
+		{"the caller is gone", 0, callerCtx, cancel},
+	} {
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatalf("cant create mock: %s", err)
+		}
+		repo := NewRepository(db)
+		repo.Clock = testClock
+		repo.Timeout = c.timeout
+
+		mock.ExpectBegin()
+		mock.
+			ExpectQuery("SELECT balance, status, debit_blocked, credit_blocked, credit_limit FROM users WHERE").
+			WithArgs(1).
+			WillDelayFor(time.Second).
+			WillReturnRows(accountRows(1.0))
+		mock.ExpectRollback()
+
+		if c.cancel != nil {
+			time.AfterFunc(10*time.Millisecond, c.cancel)
+		}
+		_, err = repo.AddMoney(c.ctx, 1, 55.3)
+		if err == nil {
+			t.Errorf("%s: expected error, got nil", c.name)
+		}
+
+		// database/sql rolls back in the background once the context is done
+		// and then lets the connection go
+		for i := 0; i < 100 && db.Stats().InUse > 0; i++ {
+			time.Sleep(time.Millisecond)
+		}
+		if err = mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("%s: there were unfulfilled expectations: %s", c.name, err)
+		}
+		db.Close()
+	}
+
+	// nothing is started after the end of the context
+	db, _, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("cant create mock: %s", err)
+	}
+	defer db.Close()
+	repo := NewRepository(db)
+
+	_, err = repo.AddMoney(callerCtx, 1, 55.3)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+}
+
 func TestWithdrawMoney(t *testing.T) {
 	db, mock, err := sqlmock.New()
 	if err != nil {
@@ -298,6 +437,7 @@ func TestWithdrawMoney(t *testing.T) {
 	}
 	defer db.Close()
 	repo := NewRepository(db)
+	repo.Clock = testClock
 
 	rows := sqlmock.NewRows([]string{"id"})
 	elemID := 1
@@ -305,26 +445,28 @@ func TestWithdrawMoney(t *testing.T) {
 	for _, item := range expect {
 		rows = rows.AddRow(item)
 	}
-	// err = repo.AddMoney(1, 1000)
+	// _, err = repo.AddMoney(ctx, 1, 1000)
 	mock.ExpectBegin()
 	mock.
-		ExpectQuery("SELECT balance FROM users WHERE").
+		ExpectQuery("SELECT balance, status, debit_blocked, credit_blocked, credit_limit FROM users WHERE").
 		WithArgs(1).
-		WillReturnRows(rows)
+		WillReturnRows(accountRows(1.0))
 
 	mock.
-		ExpectExec("UPDATE users SET").
+		ExpectQuery("UPDATE users SET").
 		WithArgs(0.0, 1).
-		WillReturnResult(sqlmock.NewResult(1, 1))
+		WillReturnRows(sqlmock.NewRows([]string{"balance"}).AddRow(0.0))
+	expectNoLimits(mock)
 	rows.AddRow(1)
 	mock.
 		ExpectQuery("INSERT INTO transaction").
-		WithArgs(nil, &elemID, 0.0, time.Now().Format("2006-01-02 15:01")).
-		WillReturnRows(rows)
+		WithArgs(nil, &elemID, 0.0, testTime, nil).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created"}).AddRow(1, time.Now()))
 
+	expectNoFee(mock, OperationWithdraw)
 	mock.ExpectCommit()
 	// ok query
-	err = repo.WithdrawMoney(1, 0.0)
+	_, err = repo.WithdrawMoney(ctx, 1, 0.0)
 
 	if err != nil {
 		t.Errorf("unexpected err: %s", err)
@@ -343,9 +485,10 @@ func TestWithdrawMoneyError(t *testing.T) {
 	}
 	defer db.Close()
 	repo := NewRepository(db)
+	repo.Clock = testClock
 
 	mock.ExpectBegin()
-	err = repo.WithdrawMoney(1, -223)
+	_, err = repo.WithdrawMoney(ctx, 1, -223)
 	if err == nil {
 		t.Errorf("expected error, got nil")
 		return
@@ -360,12 +503,13 @@ func TestWithdrawMoneyError(t *testing.T) {
 
 	// not enough money
 	mock.ExpectBegin()
+	expectNoBonuses(mock)
 	mock.
-		ExpectQuery("SELECT balance FROM users WHERE").
+		ExpectQuery("SELECT balance, status, debit_blocked, credit_blocked, credit_limit FROM users WHERE").
 		WithArgs(1).
-		WillReturnRows(rows)
+		WillReturnRows(accountRows(1.0))
 
-	err = repo.WithdrawMoney(1, 500)
+	_, err = repo.WithdrawMoney(ctx, 1, 500)
 	if err == nil {
 		t.Errorf("expected error, got nil")
 		return
@@ -374,7 +518,7 @@ func TestWithdrawMoneyError(t *testing.T) {
 	// expect begin
 	mock.ExpectBegin().WillReturnError(fmt.Errorf("error"))
 
-	err = repo.WithdrawMoney(1, 0.0)
+	_, err = repo.WithdrawMoney(ctx, 1, 0.0)
 	if err == nil {
 		t.Errorf("expected error, got nil")
 		return
@@ -386,9 +530,9 @@ func TestWithdrawMoneyError(t *testing.T) {
 	//  error select
 	mock.ExpectBegin()
 	mock.
-		ExpectQuery("SELECT balance FROM users WHERE").
+		ExpectQuery("SELECT balance, status, debit_blocked, credit_blocked, credit_limit FROM users WHERE").
 		WithArgs(1).WillReturnError(fmt.Errorf("no rows"))
-	err = repo.WithdrawMoney(1, 0.0)
+	_, err = repo.WithdrawMoney(ctx, 1, 0.0)
 	if err == nil {
 		t.Errorf("expected error, got nil")
 		return
@@ -405,16 +549,16 @@ func TestWithdrawMoneyError(t *testing.T) {
 
 	mock.ExpectBegin()
 	mock.
-		ExpectQuery("SELECT balance FROM users WHERE").
+		ExpectQuery("SELECT balance, status, debit_blocked, credit_blocked, credit_limit FROM users WHERE").
 		WithArgs(1).
-		WillReturnRows(rows)
+		WillReturnRows(accountRows(1.0))
 
 	mock.
-		ExpectExec("UPDATE users SET").
+		ExpectQuery("UPDATE users SET").
 		WithArgs(0.0, 1).
 		WillReturnError(fmt.Errorf("error"))
 
-	err = repo.WithdrawMoney(1, 0.0)
+	_, err = repo.WithdrawMoney(ctx, 1, 0.0)
 	if err == nil {
 		t.Errorf("expected error, got nil")
 		return
@@ -426,21 +570,22 @@ func TestWithdrawMoneyError(t *testing.T) {
 	// error write transaction
 	mock.ExpectBegin()
 	mock.
-		ExpectQuery("SELECT balance FROM users WHERE").
+		ExpectQuery("SELECT balance, status, debit_blocked, credit_blocked, credit_limit FROM users WHERE").
 		WithArgs(1).
-		WillReturnRows(rows)
+		WillReturnRows(accountRows(1.0))
 
 	mock.
-		ExpectExec("UPDATE users SET").
+		ExpectQuery("UPDATE users SET").
 		WithArgs(0.0, 1).
-		WillReturnResult(sqlmock.NewResult(1, 1))
+		WillReturnRows(sqlmock.NewRows([]string{"balance"}).AddRow(0.0))
+	expectNoLimits(mock)
 	rows.AddRow(1)
 	mock.
 		ExpectQuery("INSERT INTO transaction").
-		WithArgs(nil, &elemID, 0.0, time.Now().Format("2006-01-02 15:01")).
+		WithArgs(nil, &elemID, 0.0, testTime, nil).
 		WillReturnError(fmt.Errorf("don`t write transaction"))
 
-	err = repo.WithdrawMoney(1, 0.0)
+	_, err = repo.WithdrawMoney(ctx, 1, 0.0)
 	if err == nil {
 		t.Errorf("expected error, got nil")
 		return
@@ -457,6 +602,7 @@ func TestTransferMoney(t *testing.T) {
 	}
 	defer db.Close()
 	repo := NewRepository(db)
+	repo.Clock = testClock
 
 	rows := sqlmock.NewRows([]string{"id"})
 	rows2 := sqlmock.NewRows([]string{"id"})
@@ -469,35 +615,37 @@ func TestTransferMoney(t *testing.T) {
 
 	mock.ExpectBegin()
 	mock.
-		ExpectQuery("SELECT balance FROM users WHERE").
+		ExpectQuery("SELECT balance, status, debit_blocked, credit_blocked, credit_limit FROM users WHERE").
 		WithArgs(1).
-		WillReturnRows(rows)
+		WillReturnRows(accountRows(1.0))
 
 	mock.
-		ExpectExec("UPDATE users SET").
+		ExpectQuery("UPDATE users SET").
 		WithArgs(0.0, 1).
-		WillReturnResult(sqlmock.NewResult(1, 1))
+		WillReturnRows(sqlmock.NewRows([]string{"balance"}).AddRow(0.0))
+	expectNoLimits(mock)
 
 	rows2.AddRow(1)
 
 	mock.
-		ExpectQuery("SELECT balance FROM users WHERE").
+		ExpectQuery("SELECT balance, status, debit_blocked, credit_blocked, credit_limit FROM users WHERE").
 		WithArgs(2).
-		WillReturnRows(rows2)
+		WillReturnRows(accountRows(1.0))
 	mock.
-		ExpectExec("UPDATE users SET").
+		ExpectQuery("UPDATE users SET").
 		WithArgs(0.0, 2).
-		WillReturnResult(sqlmock.NewResult(2, 1))
+		WillReturnRows(sqlmock.NewRows([]string{"balance"}).AddRow(0.0))
 
 	rows.AddRow(1)
 	mock.
 		ExpectQuery("INSERT INTO transaction").
-		WithArgs(&elemID2, &elemID, 0.0, time.Now().Format("2006-01-02 15:01")).
-		WillReturnRows(rows)
+		WithArgs(&elemID2, &elemID, 0.0, testTime, nil).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created"}).AddRow(1, time.Now()))
 
+	expectNoFee(mock, OperationTransfer)
 	mock.ExpectCommit()
 	// ok query
-	err = repo.TransferMoney(1, 2, 0.0)
+	_, err = repo.TransferMoney(ctx, 1, 2, 0.0)
 	if err != nil {
 		t.Errorf("unexpected err: %s", err)
 		return
@@ -515,8 +663,9 @@ func TestTransferMoneyError(t *testing.T) {
 	}
 	defer db.Close()
 	repo := NewRepository(db)
+	repo.Clock = testClock
 
-	err = repo.TransferMoney(1, 2, -40.0)
+	_, err = repo.TransferMoney(ctx, 1, 2, -40.0)
 	if err == nil {
 		t.Errorf("expected error, got nil")
 		return
@@ -524,7 +673,7 @@ func TestTransferMoneyError(t *testing.T) {
 
 	mock.ExpectBegin().WillReturnError(fmt.Errorf("error"))
 
-	err = repo.TransferMoney(1, 2, 0.0)
+	_, err = repo.TransferMoney(ctx, 1, 2, 0.0)
 	if err == nil {
 		t.Errorf("expected error, got nil")
 		return
@@ -546,11 +695,11 @@ func TestTransferMoneyError(t *testing.T) {
 
 	mock.ExpectBegin()
 	mock.
-		ExpectQuery("SELECT balance FROM users WHERE").
+		ExpectQuery("SELECT balance, status, debit_blocked, credit_blocked, credit_limit FROM users WHERE").
 		WithArgs(1).
 		WillReturnError(fmt.Errorf("error"))
 
-	err = repo.TransferMoney(1, 2, 0.0)
+	_, err = repo.TransferMoney(ctx, 1, 2, 0.0)
 	if err == nil {
 		t.Errorf("expected error, got nil")
 		return
@@ -562,21 +711,22 @@ func TestTransferMoneyError(t *testing.T) {
 	// error r.appendMoneyToUser
 	mock.ExpectBegin()
 	mock.
-		ExpectQuery("SELECT balance FROM users WHERE").
+		ExpectQuery("SELECT balance, status, debit_blocked, credit_blocked, credit_limit FROM users WHERE").
 		WithArgs(1).
-		WillReturnRows(rows)
+		WillReturnRows(accountRows(1.0))
 
 	mock.
-		ExpectExec("UPDATE users SET").
+		ExpectQuery("UPDATE users SET").
 		WithArgs(0.0, 1).
-		WillReturnResult(sqlmock.NewResult(1, 1))
+		WillReturnRows(sqlmock.NewRows([]string{"balance"}).AddRow(0.0))
+	expectNoLimits(mock)
 
 	mock.
-		ExpectQuery("SELECT balance FROM users WHERE").
+		ExpectQuery("SELECT balance, status, debit_blocked, credit_blocked, credit_limit FROM users WHERE").
 		WithArgs(2).
 		WillReturnError(fmt.Errorf("error"))
 
-	err = repo.TransferMoney(1, 2, 0.0)
+	_, err = repo.TransferMoney(ctx, 1, 2, 0.0)
 	if err == nil {
 		t.Errorf("expected error, got nil")
 		return
@@ -588,33 +738,34 @@ func TestTransferMoneyError(t *testing.T) {
 	// error writeTransaction
 	mock.ExpectBegin()
 	mock.
-		ExpectQuery("SELECT balance FROM users WHERE").
+		ExpectQuery("SELECT balance, status, debit_blocked, credit_blocked, credit_limit FROM users WHERE").
 		WithArgs(1).
-		WillReturnRows(rows)
+		WillReturnRows(accountRows(1.0))
 
 	mock.
-		ExpectExec("UPDATE users SET").
+		ExpectQuery("UPDATE users SET").
 		WithArgs(0.0, 1).
-		WillReturnResult(sqlmock.NewResult(1, 1))
+		WillReturnRows(sqlmock.NewRows([]string{"balance"}).AddRow(0.0))
+	expectNoLimits(mock)
 
 	rows2.AddRow(1)
 
 	mock.
-		ExpectQuery("SELECT balance FROM users WHERE").
+		ExpectQuery("SELECT balance, status, debit_blocked, credit_blocked, credit_limit FROM users WHERE").
 		WithArgs(2).
-		WillReturnRows(rows2)
+		WillReturnRows(accountRows(1.0))
 	mock.
-		ExpectExec("UPDATE users SET").
+		ExpectQuery("UPDATE users SET").
 		WithArgs(0.0, 2).
-		WillReturnResult(sqlmock.NewResult(2, 1))
+		WillReturnRows(sqlmock.NewRows([]string{"balance"}).AddRow(0.0))
 
 	rows.AddRow(1)
 	mock.
 		ExpectQuery("INSERT INTO transaction").
-		WithArgs(&elemID2, &elemID, 0.0, time.Now().Format("2006-01-02 15:01")).
+		WithArgs(&elemID2, &elemID, 0.0, testTime, nil).
 		WillReturnError(fmt.Errorf("error"))
 
-	err = repo.TransferMoney(1, 2, 0.0)
+	_, err = repo.TransferMoney(ctx, 1, 2, 0.0)
 	if err == nil {
 		t.Errorf("expected error, got nil")
 		return
@@ -632,19 +783,21 @@ func TestGetTransaction(t *testing.T) {
 	defer db.Close()
 	repo := NewRepository(db)
 
-	rows := sqlmock.NewRows([]string{"to_id", "from_id", "money", "created"})
+	rows := sqlmock.NewRows([]string{"id", "to_id", "from_id", "money", "created", "refund_of", "fee_of", "split_of"})
 	elemID := 1
 	// expect := &Transaction{&elemID, &elemID, 0.0, time.Now()}
-	rows = rows.AddRow(elemID, elemID, 0.0, time.Now() /*.Format("2006-01-02 15:01")*/)
+	rows = rows.AddRow(1, elemID, elemID, 0.0, time.Now() /*.Format("2006-01-02 15:01")*/, nil, nil, nil)
 
 	// for _ = range expect {
 	mock.
-		ExpectQuery("SELECT to_id, from_id, money, created FROM transaction where").
+		ExpectQuery("SELECT id, to_id, from_id, money, created, refund_of, fee_of, split_of FROM transaction where").
 		WithArgs(elemID).
 		WillReturnRows(rows)
+	expectNoBonusSpent(mock, elemID)
+	expectNoPendingHistory(mock, elemID)
 	//}
 
-	_, err = repo.GetTransaction(elemID, "")
+	_, err = repo.GetTransaction(ctx, elemID, "")
 	if err != nil {
 		t.Errorf("unexpected err: %s", err)
 		return
@@ -655,14 +808,16 @@ func TestGetTransaction(t *testing.T) {
 	}
 
 	// date
-	rows.AddRow(elemID+1, elemID+1, 0.0, time.Now())
+	rows.AddRow(2, elemID+1, elemID+1, 0.0, time.Now(), nil, nil, nil)
 	mock.
-		ExpectQuery("SELECT to_id, from_id, money, created FROM transaction where").
+		ExpectQuery("SELECT id, to_id, from_id, money, created, refund_of, fee_of, split_of FROM transaction where").
 		WithArgs(elemID).
 		WillReturnRows(rows)
+	expectNoBonusSpent(mock, elemID)
+	expectNoPendingHistory(mock, elemID)
 	// }
 
-	_, err = repo.GetTransaction(elemID, "date")
+	_, err = repo.GetTransaction(ctx, elemID, "date")
 	if err != nil {
 		t.Errorf("unexpected err: %s", err)
 		return
@@ -675,17 +830,154 @@ func TestGetTransaction(t *testing.T) {
 	// money
 
 	mock.
-		ExpectQuery("SELECT to_id, from_id, money, created FROM transaction where").
+		ExpectQuery("SELECT id, to_id, from_id, money, created, refund_of, fee_of, split_of FROM transaction where").
 		WithArgs(elemID).
 		WillReturnRows(rows)
+	expectNoBonusSpent(mock, elemID)
+	expectNoPendingHistory(mock, elemID)
 	// }
 
-	_, err = repo.GetTransaction(elemID, "money")
+	_, err = repo.GetTransaction(ctx, elemID, "money")
+	if err != nil {
+		t.Errorf("unexpected err: %s", err)
+		return
+	}
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestGetTransactionOrder(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("cant create mock: %s", err)
+	}
+	defer db.Close()
+	repo := NewRepository(db)
+
+	elemID := 1
+	moscow := time.FixedZone("MSK", 3*60*60)
+
+	// same moment in different zones, ties are broken by id
+	rows := sqlmock.NewRows([]string{"id", "to_id", "from_id", "money", "created", "refund_of", "fee_of", "split_of"}).
+		AddRow(3, elemID, nil, 10.0, testTime.In(moscow), nil, nil, nil).
+		AddRow(1, elemID, nil, 30.0, testTime, nil, nil, nil).
+		AddRow(2, elemID, nil, 10.0, testTime.Add(-time.Microsecond), nil, nil, nil)
+	mock.
+		ExpectQuery("SELECT id, to_id, from_id, money, created, refund_of, fee_of, split_of FROM transaction where").
+		WithArgs(elemID).
+		WillReturnRows(rows)
+	expectNoBonusSpent(mock, elemID)
+	expectNoPendingHistory(mock, elemID)
+
+	info, err := repo.GetTransaction(ctx, elemID, "date")
+	if err != nil {
+		t.Errorf("unexpected err: %s", err)
+		return
+	}
+
+	ids := []int{info[0].ID, info[1].ID, info[2].ID}
+	if !reflect.DeepEqual(ids, []int{2, 1, 3}) {
+		t.Errorf("bad order by date: %v", ids)
+		return
+	}
+
+	if info[2].Created.Location() != time.UTC {
+		t.Errorf("expected UTC time, got %v", info[2].Created.Location())
+		return
+	}
+
+	rows = sqlmock.NewRows([]string{"id", "to_id", "from_id", "money", "created", "refund_of", "fee_of", "split_of"}).
+		AddRow(3, elemID, nil, 10.0, testTime, nil, nil, nil).
+		AddRow(1, elemID, nil, 30.0, testTime, nil, nil, nil).
+		AddRow(2, elemID, nil, 10.0, testTime, nil, nil, nil)
+	mock.
+		ExpectQuery("SELECT id, to_id, from_id, money, created, refund_of, fee_of, split_of FROM transaction where").
+		WithArgs(elemID).
+		WillReturnRows(rows)
+	expectNoBonusSpent(mock, elemID)
+	expectNoPendingHistory(mock, elemID)
+
+	info, err = repo.GetTransaction(ctx, elemID, "money")
 	if err != nil {
 		t.Errorf("unexpected err: %s", err)
 		return
 	}
 
+	ids = []int{info[0].ID, info[1].ID, info[2].ID}
+	if !reflect.DeepEqual(ids, []int{2, 3, 1}) {
+		t.Errorf("bad order by money: %v", ids)
+		return
+	}
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestGetTransactionPage(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("cant create mock: %s", err)
+	}
+	defer db.Close()
+	repo := NewRepository(db)
+
+	elemID := 1
+	columns := []string{"id", "to_id", "from_id", "money", "created", "refund_of", "fee_of", "split_of"}
+	mock.
+		ExpectQuery("SELECT id, to_id, from_id, money, created, refund_of, fee_of, split_of FROM transaction "+
+			"WHERE \\(to_id = \\$1 OR from_id = \\$1\\) ORDER BY money, id LIMIT \\$2").
+		WithArgs(elemID, 2).
+		WillReturnRows(sqlmock.NewRows(columns).
+			AddRow(2, elemID, nil, 10.0, testTime, nil, nil, nil).
+			AddRow(3, elemID, nil, 10.0, testTime, nil, nil, nil))
+	expectNoBonusSpent(mock, elemID)
+	expectNoPendingHistory(mock, elemID)
+
+	page, err := repo.GetTransactionPage(ctx, elemID, "money", nil, 2)
+	if err != nil || len(page) != 2 || page[0].ID != 2 || page[1].ID != 3 {
+		t.Errorf("unexpected first page %v, %v", page, err)
+		return
+	}
+
+	// the next page starts after the last operation in the order asked for
+	mock.
+		ExpectQuery("SELECT id, to_id, from_id, money, created, refund_of, fee_of, split_of FROM transaction "+
+			"WHERE \\(to_id = \\$1 OR from_id = \\$1\\) AND \\(money, id\\) > \\(\\$2, \\$3\\) ORDER BY money, id LIMIT \\$4").
+		WithArgs(elemID, 10.0, 3, 2).
+		WillReturnRows(sqlmock.NewRows(columns).
+			AddRow(1, elemID, nil, 30.0, testTime, nil, nil, nil))
+	expectNoBonusSpent(mock, elemID)
+	expectNoPendingHistory(mock, elemID)
+
+	page, err = repo.GetTransactionPage(ctx, elemID, "money", page[1], 2)
+	if err != nil || len(page) != 1 || page[0].ID != 1 {
+		t.Errorf("unexpected last page %v, %v", page, err)
+		return
+	}
+
+	mock.
+		ExpectQuery("SELECT id, to_id, from_id, money, created, refund_of, fee_of, split_of FROM transaction "+
+			"WHERE \\(to_id = \\$1 OR from_id = \\$1\\) AND \\(created, id\\) > \\(\\$2, \\$3\\) ORDER BY created, id LIMIT \\$4").
+		WithArgs(elemID, testTime, 3, 2).
+		WillReturnRows(sqlmock.NewRows(columns))
+	expectNoBonusSpent(mock, elemID)
+	expectNoPendingHistory(mock, elemID)
+
+	page, err = repo.GetTransactionPage(ctx, elemID, "date", &Transaction{ID: 3, Created: testTime}, 2)
+	if err != nil || len(page) != 0 {
+		t.Errorf("unexpected page by date %v, %v", page, err)
+		return
+	}
+
+	_, err = repo.GetTransactionPage(ctx, elemID, "color", nil, 2)
+	if err == nil {
+		t.Errorf("expected error on a bad order")
+		return
+	}
+
 	if err = mock.ExpectationsWereMet(); err != nil {
 		t.Errorf("there were unfulfilled expectations: %s", err)
 	}
@@ -702,11 +994,11 @@ func TestGetTransactionError(t *testing.T) {
 
 	// select error
 	mock.
-		ExpectQuery("SELECT to_id, from_id, money, created FROM transaction where").
+		ExpectQuery("SELECT id, to_id, from_id, money, created, refund_of, fee_of, split_of FROM transaction where").
 		WithArgs(1).
 		WillReturnError(fmt.Errorf("error"))
 
-	_, err = repo.GetTransaction(1, "")
+	_, err = repo.GetTransaction(ctx, 1, "")
 	if err == nil {
 		t.Errorf("expected error, got nil")
 		return
@@ -716,14 +1008,16 @@ func TestGetTransactionError(t *testing.T) {
 	}
 
 	// orderBy error
-	rows := sqlmock.NewRows([]string{"to_id", "from_id", "money", "created"})
-	rows = rows.AddRow(elemID, elemID, 0.0, time.Now())
+	rows := sqlmock.NewRows([]string{"id", "to_id", "from_id", "money", "created", "refund_of", "fee_of", "split_of"})
+	rows = rows.AddRow(1, elemID, elemID, 0.0, time.Now(), nil, nil, nil)
 	mock.
-		ExpectQuery("SELECT to_id, from_id, money, created FROM transaction where").
+		ExpectQuery("SELECT id, to_id, from_id, money, created, refund_of, fee_of, split_of FROM transaction where").
 		WithArgs(1).
 		WillReturnRows(rows)
+	expectNoBonusSpent(mock, 1)
+	expectNoPendingHistory(mock, 1)
 
-	_, err = repo.GetTransaction(1, "create43d")
+	_, err = repo.GetTransaction(ctx, 1, "create43d")
 	if err == nil {
 		t.Errorf("expected error, got nil")
 		return
@@ -733,17 +1027,17 @@ func TestGetTransactionError(t *testing.T) {
 	}*/
 
 	/* // scan error
-	rows := sqlmock.NewRows([]string{"to_id", "from_id", "money", "created"})
+	rows := sqlmock.NewRows([]string{"id", "to_id", "from_id", "money", "created", "refund_of", "fee_of", "split_of"})
 	elemID := 1
 
-	// rows = rows.AddRow(elemID, elemID, 0.0, time.Now())
+	// rows = rows.AddRow(1, elemID, elemID, 0.0, time.Now(), nil)
 	rows = rows.RowError(0, fmt.Errorf("errror"))
 	mock.
-		ExpectQuery("SELECT to_id, from_id, money, created FROM transaction where").
+		ExpectQuery("SELECT id, to_id, from_id, money, created, refund_of, fee_of, split_of FROM transaction where").
 		WithArgs(1).
 		WillReturnRows(rows)
 
-	_, err = repo.GetTransaction(elemID, "")
+	_, err = repo.GetTransaction(ctx, elemID, "")
 	if err == nil {
 		t.Errorf("expected error, got nil")
 		return
@@ -753,3 +1047,69 @@ func TestGetTransactionError(t *testing.T) {
 		return
 	}*/
 }
+
+func TestGetTransactionByID(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("cant create mock: %s", err)
+	}
+	defer db.Close()
+	repo := NewRepository(db)
+
+	elemID := 1
+	created := testTime
+	expect := &Transaction{
+		ID:      5,
+		ToID:    nil,
+		FromID:  &elemID,
+		Money:   -10,
+		Created: created,
+	}
+
+	mock.
+		ExpectQuery("SELECT id, to_id, from_id, money, created, refund_of, fee_of, split_of FROM transaction WHERE").
+		WithArgs(5).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "to_id", "from_id", "money", "created", "refund_of", "fee_of", "split_of"}).
+			AddRow(5, nil, elemID, -10.0, created, nil, nil, nil))
+
+	tr, err := repo.GetTransactionByID(ctx, 5)
+	if err != nil {
+		t.Errorf("unexpected err: %s", err)
+		return
+	}
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+		return
+	}
+	if !reflect.DeepEqual(tr, expect) {
+		t.Errorf("results not match, want %v, have %v", expect, tr)
+		return
+	}
+
+	// not found
+	mock.
+		ExpectQuery("SELECT id, to_id, from_id, money, created, refund_of, fee_of, split_of FROM transaction WHERE").
+		WithArgs(6).
+		WillReturnError(sql.ErrNoRows)
+
+	_, err = repo.GetTransactionByID(ctx, 6)
+	if err != ErrTransactionNotFound {
+		t.Errorf("expected ErrTransactionNotFound, got %v", err)
+		return
+	}
+
+	// db error
+	mock.
+		ExpectQuery("SELECT id, to_id, from_id, money, created, refund_of, fee_of, split_of FROM transaction WHERE").
+		WithArgs(7).
+		WillReturnError(fmt.Errorf("db_error"))
+
+	_, err = repo.GetTransactionByID(ctx, 7)
+	if err == nil || err == ErrTransactionNotFound {
+		t.Errorf("expected db error, got %v", err)
+		return
+	}
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
@@ -0,0 +1,559 @@
+package transaction
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Decisions of the risk checks, from the mildest.
+const (
+	RiskAllow = "allow"
+	RiskHold  = "hold"
+	RiskDeny  = "deny"
+)
+
+// Statuses of an operation held for review.
+const (
+	ReviewPending  = "pending"
+	ReviewApproved = "approved"
+	ReviewRejected = "rejected"
+)
+
+var riskSeverity = map[string]int{RiskAllow: 0, RiskHold: 1, RiskDeny: 2}
+
+// RiskOperation is a withdrawal, a transfer or a split payment the rules look
+// at. It is usually applied in the transaction the rules get, so the history
+// they read includes it; one held for approval is not yet, the rules count it
+// themselves.
+type RiskOperation struct {
+	Operation string
+	UserID    int
+	ToUserID  *int
+	Money     float64
+	At        time.Time
+	// Service is what a withdrawal pays for, a held one is paid for it too.
+	Service string
+	// Legs are the recipients of a split payment, a held one pays them too.
+	Legs []*SplitLeg
+	// Currency and Rate are what the money was asked in, empty for roubles.
+	Currency string
+	Rate     float64
+	// RequestedBy is who asked for the operation, it can't review it.
+	RequestedBy string
+}
+
+// RiskRule is one check of the pipeline. It returns nil when it has nothing
+// against the operation.
+type RiskRule interface {
+	Check(op *RiskOperation, db TransactionInterface) (*RiskVerdict, error)
+}
+
+// RiskError is an operation the risk checks stopped: denied, or held until
+// the review ReviewID is approved.
+type RiskError struct {
+	Decision string
+	Verdicts []*RiskVerdict
+	ReviewID int
+
+	op *RiskOperation
+}
+
+func (e *RiskError) Error() string {
+	reasons := make([]string, 0, len(e.Verdicts))
+	for _, v := range e.Verdicts {
+		if v.Decision == e.Decision {
+			reasons = append(reasons, v.Reason)
+		}
+	}
+	err := ErrRiskDenied
+	if e.Decision == RiskHold {
+		err = ErrRiskReview
+	}
+	if len(reasons) == 0 {
+		return err.Error()
+	}
+	return fmt.Sprintf("%s: %s", err, strings.Join(reasons, ", "))
+}
+
+func (e *RiskError) Is(target error) bool {
+	return (target == ErrRiskDenied && e.Decision == RiskDeny) || (target == ErrRiskReview && e.Decision == RiskHold)
+}
+
+// NewAccountRule stops large debits of accounts younger than MaxAge.
+type NewAccountRule struct {
+	MaxAge   time.Duration
+	MaxMoney float64
+	Decision string
+}
+
+func (rule *NewAccountRule) Check(op *RiskOperation, db TransactionInterface) (*RiskVerdict, error) {
+	if op.Money <= rule.MaxMoney {
+		return nil, nil
+	}
+
+	var created time.Time
+	err := db.QueryRow("SELECT created_at FROM users WHERE id = $1", op.UserID).Scan(&created)
+	if err != nil {
+		return nil, err
+	}
+	age := op.At.Sub(created)
+	if age >= rule.MaxAge {
+		return nil, nil
+	}
+
+	return &RiskVerdict{
+		Rule:     "new_account",
+		Decision: rule.Decision,
+		Reason:   fmt.Sprintf("%v from an account opened %v ago", op.Money, age.Round(time.Minute)),
+	}, nil
+}
+
+// FanOutRule stops a transfer or a split payment that makes the sender pay
+// more than MaxRecipients accounts within Window.
+type FanOutRule struct {
+	Window        time.Duration
+	MaxRecipients int
+	Decision      string
+}
+
+func (rule *FanOutRule) Check(op *RiskOperation, db TransactionInterface) (*RiskVerdict, error) {
+	if op.Operation != OperationTransfer && op.Operation != OperationSplit {
+		return nil, nil
+	}
+
+	paid := make(map[int]bool)
+	if op.ToUserID != nil {
+		paid[*op.ToUserID] = true
+	}
+	for _, leg := range op.Legs {
+		paid[leg.ToUserID] = true
+	}
+
+	rows, err := db.Query("SELECT DISTINCT to_id FROM transaction WHERE from_id = $1 AND to_id IS NOT NULL "+
+		"AND refund_of IS NULL AND fee_of IS NULL AND created > $2", op.UserID, op.At.Add(-rule.Window))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var toUserID int
+		err = rows.Scan(&toUserID)
+		if err != nil {
+			return nil, err
+		}
+		paid[toUserID] = true
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	recipients := len(paid)
+	if recipients <= rule.MaxRecipients {
+		return nil, nil
+	}
+
+	return &RiskVerdict{
+		Rule:     "fan_out",
+		Decision: rule.Decision,
+		Reason:   fmt.Sprintf("%d recipients within %v", recipients, rule.Window),
+	}, nil
+}
+
+// HoursRule stops debits of at least MinMoney between the hours From and To
+// (UTC, To excluded). From may be after To for a range over midnight.
+type HoursRule struct {
+	From     int
+	To       int
+	MinMoney float64
+	Decision string
+}
+
+func (rule *HoursRule) Check(op *RiskOperation, db TransactionInterface) (*RiskVerdict, error) {
+	hour := op.At.UTC().Hour()
+	inside := hour >= rule.From && hour < rule.To
+	if rule.From > rule.To {
+		inside = hour >= rule.From || hour < rule.To
+	}
+	if !inside || op.Money < rule.MinMoney {
+		return nil, nil
+	}
+
+	return &RiskVerdict{
+		Rule:     "unusual_hours",
+		Decision: rule.Decision,
+		Reason:   fmt.Sprintf("%v at %02d:00-%02d:00 UTC", op.Money, rule.From, rule.To),
+	}, nil
+}
+
+// ParseRiskRules reads the built-in rules separated by ";":
+//
+//	new_account <max age> <max money> <hold|deny>
+//	fan_out <window> <max recipients> <hold|deny>
+//	unusual_hours <from>-<to> <min money> <hold|deny>
+//
+// like "new_account 168h 10000 hold; fan_out 1h 5 deny".
+func ParseRiskRules(s string) ([]RiskRule, error) {
+	rules := make([]RiskRule, 0)
+	for _, item := range strings.Split(s, ";") {
+		fields := strings.Fields(item)
+		if len(fields) == 0 {
+			continue
+		}
+		bad := fmt.Errorf("bad risk rule %q", strings.TrimSpace(item))
+		if len(fields) != 4 || (fields[3] != RiskHold && fields[3] != RiskDeny) {
+			return nil, bad
+		}
+		money, err := strconv.ParseFloat(fields[2], 64)
+		if err != nil || money < 0 {
+			return nil, bad
+		}
+
+		switch fields[0] {
+		case "new_account":
+			age, err := time.ParseDuration(fields[1])
+			if err != nil || age <= 0 {
+				return nil, bad
+			}
+			rules = append(rules, &NewAccountRule{MaxAge: age, MaxMoney: money, Decision: fields[3]})
+		case "fan_out":
+			window, err := time.ParseDuration(fields[1])
+			if err != nil || window <= 0 || money != float64(int(money)) {
+				return nil, bad
+			}
+			rules = append(rules, &FanOutRule{Window: window, MaxRecipients: int(money), Decision: fields[3]})
+		case "unusual_hours":
+			var from, to int
+			_, err := fmt.Sscanf(fields[1], "%d-%d", &from, &to)
+			if err != nil || from < 0 || from > 23 || to < 0 || to > 24 || from == to {
+				return nil, bad
+			}
+			rules = append(rules, &HoursRule{From: from, To: to, MinMoney: money, Decision: fields[3]})
+		default:
+			return nil, bad
+		}
+	}
+
+	return rules, nil
+}
+
+// screen runs RiskRules on the operation applied in db as tr, nil for one
+// not applied yet. The strictest verdict decides, an operation no rule objects
+// to is allowed and its decision is recorded in db. A denied or held one
+// returns *RiskError, the caller rolls it back and records it with recordRisk.
+func (r *RepositoryItem) screen(op *RiskOperation, tr *Transaction, db TransactionInterface) error {
+	if len(r.RiskRules) == 0 {
+		return nil
+	}
+
+	verdicts := make([]*RiskVerdict, 0)
+	decision := RiskAllow
+	for _, rule := range r.RiskRules {
+		verdict, err := rule.Check(op, db)
+		if err != nil {
+			return err
+		}
+		if verdict == nil {
+			continue
+		}
+		verdicts = append(verdicts, verdict)
+		if riskSeverity[verdict.Decision] > riskSeverity[decision] {
+			decision = verdict.Decision
+		}
+	}
+
+	if decision != RiskAllow {
+		return &RiskError{Decision: decision, Verdicts: verdicts, op: op}
+	}
+
+	var transactionID *int
+	if tr != nil {
+		transactionID = &tr.ID
+	}
+	return r.writeRiskDecision(op, RiskAllow, verdicts, transactionID, nil, db)
+}
+
+// recordRisk stores the decision of an operation stopped by screen and, for
+// one held for review, queues it. Other errors are returned as they are.
+func (r *RepositoryItem) recordRisk(ctx context.Context, err error) error {
+	riskErr, ok := err.(*RiskError)
+	if !ok || riskErr.op == nil {
+		return err
+	}
+	op := riskErr.op
+
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	db := withContext(ctx, tx)
+
+	var reviewID *int
+	if riskErr.Decision == RiskHold {
+		verdicts, err := json.Marshal(riskErr.Verdicts)
+		if err != nil {
+			//nolint:errcheck
+			tx.Rollback()
+			return err
+		}
+		var legs interface{}
+		if len(op.Legs) > 0 {
+			data, err := json.Marshal(op.Legs)
+			if err != nil {
+				//nolint:errcheck
+				tx.Rollback()
+				return err
+			}
+			legs = data
+		}
+		// the money waits for the review on the account like the one of a
+		// pending operation, so the approval can't overdraw it
+		held, err := r.realShare(op.Operation, op.UserID, op.Money, op.Service, db)
+		if err == nil {
+			err = holdMoney(op.UserID, held, db)
+		}
+		if err != nil {
+			//nolint:errcheck
+			tx.Rollback()
+			return err
+		}
+		err = db.QueryRow("INSERT INTO risk_reviews (operation, user_id, to_id, money, verdicts, status, created, service, legs, "+
+			"currency, rate, requested_by, held) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13) returning id",
+			op.Operation, op.UserID, op.ToUserID, op.Money, verdicts, ReviewPending, op.At, op.Service, legs, op.Currency, op.Rate,
+			sql.NullString{String: op.RequestedBy, Valid: op.RequestedBy != ""}, held).Scan(&riskErr.ReviewID)
+		if err != nil {
+			//nolint:errcheck
+			tx.Rollback()
+			return err
+		}
+		reviewID = &riskErr.ReviewID
+	}
+
+	err = r.writeRiskDecision(op, riskErr.Decision, riskErr.Verdicts, nil, reviewID, db)
+	if err != nil {
+		//nolint:errcheck
+		tx.Rollback()
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	return riskErr
+}
+
+func (r *RepositoryItem) writeRiskDecision(op *RiskOperation, decision string, verdicts []*RiskVerdict,
+	transactionID, reviewID *int, db TransactionInterface) error {
+	data, err := json.Marshal(verdicts)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec("INSERT INTO risk_decisions (operation, user_id, to_id, money, decision, verdicts, transaction_id, review_id, created) "+
+		"VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)", op.Operation, op.UserID, op.ToUserID, op.Money, decision, data,
+		transactionID, reviewID, r.now())
+	return err
+}
+
+// GetRiskDecisions returns the decisions about the debits of the account,
+// the last first.
+func (r *RepositoryItem) GetRiskDecisions(ctx context.Context, userID int) ([]*RiskDecision, error) {
+	ctx, cancel := r.operation(ctx)
+	defer cancel()
+
+	rows, err := r.DB.QueryContext(ctx, "SELECT id, operation, user_id, to_id, money, decision, verdicts, transaction_id, review_id, created "+
+		"FROM risk_decisions WHERE user_id = $1 ORDER BY id DESC", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	decisions := make([]*RiskDecision, 0)
+	for rows.Next() {
+		d := &RiskDecision{}
+		var verdicts []byte
+		err = rows.Scan(&d.ID, &d.Operation, &d.UserID, &d.ToUserID, &d.Money, &d.Decision, &verdicts, &d.TransactionID,
+			&d.ReviewID, &d.Created)
+		if err != nil {
+			return nil, err
+		}
+		err = json.Unmarshal(verdicts, &d.Verdicts)
+		if err != nil {
+			return nil, err
+		}
+		d.Created = d.Created.UTC()
+		decisions = append(decisions, d)
+	}
+
+	return decisions, rows.Err()
+}
+
+var reviewColumns = "id, operation, user_id, to_id, money, verdicts, status, created, reviewer, decided_at, transaction_id, service, " +
+	"legs, currency, rate, requested_by, held"
+
+func scanReview(row interface{ Scan(...interface{}) error }) (*RiskReview, error) {
+	rv := &RiskReview{}
+	var verdicts, legs []byte
+	var requestedBy sql.NullString
+	err := row.Scan(&rv.ID, &rv.Operation, &rv.UserID, &rv.ToUserID, &rv.Money, &verdicts, &rv.Status, &rv.Created,
+		&rv.Reviewer, &rv.DecidedAt, &rv.TransactionID, &rv.Service, &legs, &rv.Currency, &rv.Rate, &requestedBy, &rv.Held)
+	if err != nil {
+		return nil, err
+	}
+	rv.RequestedBy = requestedBy.String
+	err = json.Unmarshal(verdicts, &rv.Verdicts)
+	if err != nil {
+		return nil, err
+	}
+	if len(legs) > 0 {
+		err = json.Unmarshal(legs, &rv.Legs)
+		if err != nil {
+			return nil, err
+		}
+	}
+	rv.Created = rv.Created.UTC()
+	if rv.DecidedAt != nil {
+		decided := rv.DecidedAt.UTC()
+		rv.DecidedAt = &decided
+	}
+
+	return rv, nil
+}
+
+// GetRiskReviews returns the review queue with the given status, all of it
+// for the empty one, the oldest first.
+func (r *RepositoryItem) GetRiskReviews(ctx context.Context, status string) ([]*RiskReview, error) {
+	ctx, cancel := r.operation(ctx)
+	defer cancel()
+
+	rows, err := r.DB.QueryContext(ctx, "SELECT "+reviewColumns+" FROM risk_reviews WHERE $1 = '' OR status = $1 ORDER BY id",
+		status)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	reviews := make([]*RiskReview, 0)
+	for rows.Next() {
+		rv, err := scanReview(rows)
+		if err != nil {
+			return nil, err
+		}
+		reviews = append(reviews, rv)
+	}
+
+	return reviews, rows.Err()
+}
+
+func (r *RepositoryItem) GetRiskReview(ctx context.Context, reviewID int) (*RiskReview, error) {
+	ctx, cancel := r.operation(ctx)
+	defer cancel()
+
+	rv, err := scanReview(r.DB.QueryRowContext(ctx, "SELECT "+reviewColumns+" FROM risk_reviews WHERE id = $1", reviewID))
+	if err == sql.ErrNoRows {
+		return nil, ErrReviewNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return rv, nil
+}
+
+// ApproveRiskReview runs the held operation as it was requested, without the
+// risk checks but with the balance, the freezes and the limits of now. When it
+// fails the review stays pending.
+func (r *RepositoryItem) ApproveRiskReview(ctx context.Context, reviewID int, reviewer string) (*RiskReview, error) {
+	return r.decideReview(ctx, reviewID, reviewer, ReviewApproved)
+}
+
+// RejectRiskReview drops the held operation.
+func (r *RepositoryItem) RejectRiskReview(ctx context.Context, reviewID int, reviewer string) (*RiskReview, error) {
+	return r.decideReview(ctx, reviewID, reviewer, ReviewRejected)
+}
+
+func (r *RepositoryItem) decideReview(ctx context.Context, reviewID int, reviewer, status string) (*RiskReview, error) {
+	if strings.TrimSpace(reviewer) == "" {
+		return nil, ErrBadReview
+	}
+
+	ctx, cancel := r.operation(ctx)
+	defer cancel()
+
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	rv, err := r.decide(reviewID, reviewer, status, withContext(ctx, tx))
+	if err != nil {
+		//nolint:errcheck
+		tx.Rollback()
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return rv, nil
+}
+
+func (r *RepositoryItem) decide(reviewID int, reviewer, status string, db TransactionInterface) (*RiskReview, error) {
+	rv, err := scanReview(db.QueryRow("SELECT "+reviewColumns+" FROM risk_reviews WHERE id = $1 FOR UPDATE", reviewID))
+	if err == sql.ErrNoRows {
+		return nil, ErrReviewNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if rv.Status != ReviewPending {
+		return nil, ErrReviewDecided
+	}
+	if rv.RequestedBy != "" && reviewer == rv.RequestedBy {
+		return nil, ErrSameApprover
+	}
+
+	err = releaseMoney(rv.UserID, rv.Held, db)
+	if err != nil {
+		return nil, err
+	}
+
+	op := &RiskOperation{Operation: rv.Operation, UserID: rv.UserID, ToUserID: rv.ToUserID, Money: rv.Money, At: r.now(),
+		Service: rv.Service, Legs: rv.Legs, Currency: rv.Currency, Rate: rv.Rate, RequestedBy: rv.RequestedBy}
+	decision := RiskDeny
+	if status == ReviewApproved {
+		decision = RiskAllow
+
+		tr, err := r.pay(&payment{Operation: rv.Operation, UserID: rv.UserID, ToUserID: rv.ToUserID, Money: rv.Money,
+			RequestedBy: rv.RequestedBy, Service: rv.Service, Legs: rv.Legs, Currency: opCurrency{Code: rv.Currency, Rate: rv.Rate}}, db)
+		if err != nil {
+			return nil, err
+		}
+		rv.TransactionID = &tr.ID
+	}
+
+	decided := r.now()
+	rv.Status, rv.Reviewer, rv.DecidedAt = status, &reviewer, &decided
+	_, err = db.Exec("UPDATE risk_reviews SET status = $2, reviewer = $3, decided_at = $4, transaction_id = $5 WHERE id = $1",
+		rv.ID, rv.Status, reviewer, decided, rv.TransactionID)
+	if err != nil {
+		return nil, err
+	}
+
+	verdicts := []*RiskVerdict{{Rule: "manual_review", Decision: decision, Reason: fmt.Sprintf("%s by %s", status, reviewer)}}
+	err = r.writeRiskDecision(op, decision, verdicts, rv.TransactionID, &rv.ID, db)
+	if err != nil {
+		return nil, err
+	}
+
+	return rv, nil
+}
@@ -0,0 +1,336 @@
+package transaction
+
+import (
+	"encoding/json"
+	"errors"
+	sqlmock "gopkg.in/DATA-DOG/go-sqlmock.v1"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseRiskRules(t *testing.T) {
+	rules, err := ParseRiskRules("new_account 168h 10000 hold; fan_out 1h 5 deny; unusual_hours 22-6 5000 hold;")
+	if err != nil {
+		t.Errorf("unexpected err: %s", err)
+		return
+	}
+	expect := []RiskRule{
+		&NewAccountRule{MaxAge: 168 * time.Hour, MaxMoney: 10000, Decision: RiskHold},
+		&FanOutRule{Window: time.Hour, MaxRecipients: 5, Decision: RiskDeny},
+		&HoursRule{From: 22, To: 6, MinMoney: 5000, Decision: RiskHold},
+	}
+	if !reflect.DeepEqual(rules, expect) {
+		t.Errorf("results not match, want %v, have %v", expect, rules)
+		return
+	}
+
+	for _, bad := range []string{"new_account 168h 10000", "new_account 168h 10000 allow", "fan_out 1h 2.5 deny",
+		"unusual_hours 6-6 0 hold", "unusual_hours 0-25 0 hold", "velocity 1h 5 deny"} {
+		_, err = ParseRiskRules(bad)
+		if err == nil {
+			t.Errorf("%q: expected error, got nil", bad)
+		}
+	}
+}
+
+func TestHoursRule(t *testing.T) {
+	rule := &HoursRule{From: 22, To: 6, MinMoney: 100, Decision: RiskHold}
+	night := time.Date(2021, 11, 27, 3, 0, 0, 0, time.UTC)
+
+	for _, c := range []struct {
+		at    time.Time
+		money float64
+		held  bool
+	}{
+		{night, 100, true},
+		{night.Add(20 * time.Hour), 500, true},
+		{night.Add(3 * time.Hour), 500, false},
+		{night, 99, false},
+	} {
+		verdict, err := rule.Check(&RiskOperation{Operation: OperationWithdraw, UserID: 1, Money: c.money, At: c.at}, nil)
+		if err != nil || (verdict != nil) != c.held {
+			t.Errorf("%v %v: unexpected verdict %v, %v", c.at, c.money, verdict, err)
+		}
+	}
+}
+
+func expectRiskWithdraw(mock sqlmock.Sqlmock, opened time.Time) {
+	elemID := 1
+	expectNoBonuses(mock)
+	mock.
+		ExpectQuery("SELECT balance, status, debit_blocked, credit_blocked, credit_limit FROM users WHERE").
+		WithArgs(1).
+		WillReturnRows(accountRows(20000))
+	mock.
+		ExpectQuery("UPDATE users SET").
+		WithArgs(15000.0, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"balance"}).AddRow(5000.0))
+	expectNoLimits(mock)
+	mock.
+		ExpectQuery("INSERT INTO transaction").
+		WithArgs(nil, &elemID, -15000.0, testTime, nil).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created"}).AddRow(7, testTime))
+	expectNoFee(mock, OperationWithdraw)
+	mock.
+		ExpectQuery("SELECT created_at FROM users WHERE id = \\$1").
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"created_at"}).AddRow(opened))
+}
+
+func TestWithdrawMoneyRisk(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("cant create mock: %s", err)
+	}
+	defer db.Close()
+
+	repo := NewRepository(db)
+	repo.Clock = testClock
+	repo.RiskRules = []RiskRule{&NewAccountRule{MaxAge: 24 * time.Hour, MaxMoney: 10000, Decision: RiskHold}}
+
+	// a new account is held, the operation is rolled back and queued
+	mock.ExpectBegin()
+	expectRiskWithdraw(mock, testTime.Add(-2*time.Hour))
+	mock.ExpectRollback()
+	mock.ExpectBegin()
+	expectNoBonuses(mock)
+	mock.
+		ExpectQuery("UPDATE users SET held = held").
+		WithArgs(15000.0, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"held"}).AddRow(15000.0))
+	mock.
+		ExpectQuery("INSERT INTO risk_reviews \\(operation, user_id, to_id, money, verdicts, status, created, service, legs, currency, rate, "+
+			"requested_by, held\\)").
+		WithArgs(OperationWithdraw, 1, nil, 15000.0, sqlmock.AnyArg(), ReviewPending, testTime, "", nil, "", 0.0, "shop", 15000.0).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(3))
+	mock.
+		ExpectExec("INSERT INTO risk_decisions").
+		WithArgs(OperationWithdraw, 1, nil, 15000.0, RiskHold, sqlmock.AnyArg(), nil, 3, testTime).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	_, err = repo.WithdrawMoney(WithRequester(ctx, "shop"), 1, 15000)
+	riskErr := &RiskError{}
+	if !errors.Is(err, ErrRiskReview) || !errors.As(err, &riskErr) || riskErr.ReviewID != 3 ||
+		len(riskErr.Verdicts) != 1 || riskErr.Verdicts[0].Rule != "new_account" {
+		t.Errorf("expected a held operation, got %v", err)
+		return
+	}
+
+	// an old one is allowed with the decision recorded
+	mock.ExpectBegin()
+	expectRiskWithdraw(mock, testTime.Add(-48*time.Hour))
+	mock.
+		ExpectExec("INSERT INTO risk_decisions").
+		WithArgs(OperationWithdraw, 1, nil, 15000.0, RiskAllow, []byte("[]"), 7, nil, testTime).
+		WillReturnResult(sqlmock.NewResult(2, 1))
+	mock.ExpectCommit()
+
+	tr, err := repo.WithdrawMoney(ctx, 1, 15000)
+	if err != nil || tr.ID != 7 {
+		t.Errorf("unexpected result %v, %v", tr, err)
+		return
+	}
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestPendingOperationRisk(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("cant create mock: %s", err)
+	}
+	defer db.Close()
+
+	repo := NewRepository(db)
+	repo.Clock = testClock
+	repo.ApprovalThreshold = 10000
+	repo.ApprovalTTL = time.Hour
+	repo.RiskRules = []RiskRule{&NewAccountRule{MaxAge: 24 * time.Hour, MaxMoney: 10000, Decision: RiskHold}}
+
+	// a debit above the threshold is screened before it is held, a new
+	// account waits for the review instead of the approval
+	mock.ExpectBegin()
+	mock.
+		ExpectQuery("SELECT created_at FROM users WHERE id = \\$1").
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"created_at"}).AddRow(testTime.Add(-2 * time.Hour)))
+	mock.ExpectRollback()
+	mock.ExpectBegin()
+	expectNoBonuses(mock)
+	mock.
+		ExpectQuery("UPDATE users SET held = held").
+		WithArgs(15000.0, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"held"}).AddRow(15000.0))
+	mock.
+		ExpectQuery("INSERT INTO risk_reviews").
+		WithArgs(OperationWithdraw, 1, nil, 15000.0, sqlmock.AnyArg(), ReviewPending, testTime, "", nil, "", 0.0, "shop", 15000.0).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(3))
+	mock.
+		ExpectExec("INSERT INTO risk_decisions").
+		WithArgs(OperationWithdraw, 1, nil, 15000.0, RiskHold, sqlmock.AnyArg(), nil, 3, testTime).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	_, err = repo.WithdrawMoney(WithRequester(ctx, "shop"), 1, 15000)
+	riskErr := &RiskError{}
+	if !errors.Is(err, ErrRiskReview) || !errors.As(err, &riskErr) || riskErr.ReviewID != 3 {
+		t.Errorf("expected a review, got %v", err)
+		return
+	}
+
+	// the approval is screened again, a hold can't wait and denies it
+	mock.ExpectBegin()
+	mock.
+		ExpectQuery("FROM pending_operations WHERE id = \\$1 FOR UPDATE").
+		WithArgs(4).
+		WillReturnRows(sqlmock.NewRows(pendingColumnNames).
+			AddRow(4, OperationWithdraw, 1, nil, 15000.0, PendingWaiting, "shop", nil, testTime, testTime.Add(time.Hour), nil, nil,
+				"", nil, "", 0.0, 15000.0))
+	mock.
+		ExpectExec("UPDATE users SET held = GREATEST").
+		WithArgs(15000.0, 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectRiskWithdraw(mock, testTime.Add(-2*time.Hour))
+	mock.ExpectRollback()
+	mock.ExpectBegin()
+	mock.
+		ExpectExec("INSERT INTO risk_decisions").
+		WithArgs(OperationWithdraw, 1, nil, 15000.0, RiskDeny, sqlmock.AnyArg(), nil, nil, testTime).
+		WillReturnResult(sqlmock.NewResult(2, 1))
+	mock.ExpectCommit()
+
+	_, err = repo.ApprovePendingOperation(ctx, 4, "alice")
+	if !errors.Is(err, ErrRiskDenied) || !errors.As(err, &riskErr) ||
+		riskErr.Verdicts[len(riskErr.Verdicts)-1].Rule != "pending_approval" {
+		t.Errorf("expected a denied approval, got %v", err)
+		return
+	}
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+var reviewColumnNames = []string{"id", "operation", "user_id", "to_id", "money", "verdicts", "status", "created",
+	"reviewer", "decided_at", "transaction_id", "service", "legs", "currency", "rate", "requested_by", "held"}
+
+func TestApproveRiskReview(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("cant create mock: %s", err)
+	}
+	defer db.Close()
+
+	repo := NewRepository(db)
+	repo.Clock = testClock
+
+	verdicts, _ := json.Marshal([]*RiskVerdict{{Rule: "new_account", Decision: RiskHold, Reason: "new"}})
+	created := testTime.Add(-time.Hour)
+	elemID := 1
+
+	mock.ExpectBegin()
+	mock.
+		ExpectQuery("SELECT id, operation, user_id, to_id, money, verdicts, status, created, reviewer, decided_at, transaction_id, " +
+			"service, legs, currency, rate, requested_by, held FROM risk_reviews WHERE id = \\$1 FOR UPDATE").
+		WithArgs(3).
+		WillReturnRows(sqlmock.NewRows(reviewColumnNames).
+			AddRow(3, OperationWithdraw, 1, nil, 50.0, verdicts, ReviewPending, created, nil, nil, nil, "", nil, "", 0.0, "shop", 50.0))
+	mock.
+		ExpectExec("UPDATE users SET held = GREATEST\\(held - \\$1, 0\\) WHERE id = \\$2").
+		WithArgs(50.0, 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectNoBonuses(mock)
+	mock.
+		ExpectQuery("SELECT balance, status, debit_blocked, credit_blocked, credit_limit FROM users WHERE").
+		WithArgs(1).
+		WillReturnRows(accountRows(100))
+	mock.
+		ExpectQuery("UPDATE users SET").
+		WithArgs(50.0, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"balance"}).AddRow(50.0))
+	expectNoLimits(mock)
+	mock.
+		ExpectQuery("INSERT INTO transaction").
+		WithArgs(nil, &elemID, -50.0, testTime, nil).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created"}).AddRow(8, testTime))
+	expectNoFee(mock, OperationWithdraw)
+	mock.
+		ExpectExec("UPDATE risk_reviews SET status = \\$2, reviewer = \\$3, decided_at = \\$4, transaction_id = \\$5 WHERE id = \\$1").
+		WithArgs(3, ReviewApproved, "alice", testTime, 8).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.
+		ExpectExec("INSERT INTO risk_decisions").
+		WithArgs(OperationWithdraw, 1, nil, 50.0, RiskAllow, sqlmock.AnyArg(), 8, 3, testTime).
+		WillReturnResult(sqlmock.NewResult(3, 1))
+	mock.ExpectCommit()
+
+	rv, err := repo.ApproveRiskReview(ctx, 3, "alice")
+	if err != nil {
+		t.Errorf("unexpected err: %s", err)
+		return
+	}
+	reviewer, transactionID := "alice", 8
+	expect := &RiskReview{
+		ID:            3,
+		Operation:     OperationWithdraw,
+		UserID:        1,
+		Money:         50,
+		Verdicts:      []*RiskVerdict{{Rule: "new_account", Decision: RiskHold, Reason: "new"}},
+		Status:        ReviewApproved,
+		Created:       created,
+		Reviewer:      &reviewer,
+		DecidedAt:     &testTime,
+		TransactionID: &transactionID,
+		RequestedBy:   "shop",
+		Held:          50,
+	}
+	if !reflect.DeepEqual(rv, expect) {
+		t.Errorf("results not match, want %v, have %v", expect, rv)
+		return
+	}
+
+	// a decided review stays as it is
+	mock.ExpectBegin()
+	mock.
+		ExpectQuery("FROM risk_reviews WHERE id = \\$1 FOR UPDATE").
+		WithArgs(3).
+		WillReturnRows(sqlmock.NewRows(reviewColumnNames).
+			AddRow(3, OperationWithdraw, 1, nil, 50.0, verdicts, ReviewApproved, created, "alice", testTime, 8, "", nil, "", 0.0, "shop", 50.0))
+	mock.ExpectRollback()
+
+	_, err = repo.RejectRiskReview(ctx, 3, "bob")
+	if !errors.Is(err, ErrReviewDecided) {
+		t.Errorf("expected ErrReviewDecided, got %v", err)
+		return
+	}
+
+	// the requester can't review the operation
+	mock.ExpectBegin()
+	mock.
+		ExpectQuery("FROM risk_reviews WHERE id = \\$1 FOR UPDATE").
+		WithArgs(4).
+		WillReturnRows(sqlmock.NewRows(reviewColumnNames).
+			AddRow(4, OperationWithdraw, 1, nil, 50.0, verdicts, ReviewPending, created, nil, nil, nil, "", nil, "", 0.0, "shop", 50.0))
+	mock.ExpectRollback()
+
+	_, err = repo.ApproveRiskReview(ctx, 4, "shop")
+	if !errors.Is(err, ErrSameApprover) {
+		t.Errorf("expected ErrSameApprover, got %v", err)
+		return
+	}
+
+	// a reviewer is required
+	_, err = repo.RejectRiskReview(ctx, 3, " ")
+	if !errors.Is(err, ErrBadReview) {
+		t.Errorf("expected ErrBadReview, got %v", err)
+		return
+	}
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
@@ -0,0 +1,318 @@
+package transaction
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+const (
+	ScheduleActive    = "active"
+	SchedulePaused    = "paused"
+	ScheduleCancelled = "cancelled"
+	ScheduleDone      = "done"
+	ScheduleFailed    = "failed"
+)
+
+const (
+	DefaultMaxAttempts = 3
+	DefaultRetryDelay  = int64(time.Hour / time.Second)
+)
+
+const scheduleColumns = "id, from_id, to_id, money, cron, interval_seconds, next_run, status, attempts, max_attempts, " +
+	"retry_delay_seconds, last_error, last_transaction_id, created_at, service, created_by"
+
+func scanSchedule(row scanner) (*Schedule, error) {
+	s := &Schedule{}
+	err := row.Scan(&s.ID, &s.FromID, &s.ToID, &s.Money, &s.Cron, &s.Interval, &s.NextRun, &s.Status, &s.Attempts,
+		&s.MaxAttempts, &s.RetryDelay, &s.LastError, &s.LastTransactionID, &s.CreatedAt, &s.Service, &s.CreatedBy)
+	if err == sql.ErrNoRows {
+		return nil, ErrScheduleNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	s.NextRun = s.NextRun.UTC()
+	s.CreatedAt = s.CreatedAt.UTC()
+
+	return s, nil
+}
+
+// following returns the run after the given time, false for one-off schedules.
+func (s *Schedule) following(after time.Time) (time.Time, bool) {
+	if s.Cron != "" {
+		c, err := parseCron(s.Cron)
+		if err != nil {
+			return time.Time{}, false
+		}
+		next := c.next(after)
+		return next, !next.IsZero()
+	}
+
+	if s.Interval > 0 {
+		// intervals are counted from the planned run, missed runs are skipped
+		interval := time.Duration(s.Interval) * time.Second
+		next := s.NextRun.Add(interval)
+		for !next.After(after) {
+			next = next.Add(interval)
+		}
+		return next, true
+	}
+
+	return time.Time{}, false
+}
+
+func (r *RepositoryItem) CreateSchedule(ctx context.Context, s *Schedule) (*Schedule, error) {
+	ctx, cancel := r.operation(ctx)
+	defer cancel()
+
+	if s.FromID <= 0 || (s.ToID != nil && *s.ToID <= 0) {
+		return nil, ErrBadAccountID
+	}
+	if s.Money < 0 {
+		return nil, ErrNegativeAmount
+	}
+	if s.Money == 0 || s.Interval < 0 || s.MaxAttempts < 0 || s.RetryDelay < 0 || (s.Cron != "" && s.Interval != 0) {
+		return nil, ErrBadSchedule
+	}
+	if s.Cron != "" {
+		_, err := parseCron(s.Cron)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if s.MaxAttempts == 0 {
+		s.MaxAttempts = DefaultMaxAttempts
+	}
+	if s.RetryDelay == 0 {
+		s.RetryDelay = DefaultRetryDelay
+	}
+
+	s.CreatedBy = Requester(ctx)
+	now := r.now()
+	if s.NextRun.IsZero() {
+		// recurring schedules start from the next run after now
+		s.NextRun = now
+		next, ok := s.following(now)
+		if !ok {
+			return nil, ErrBadSchedule
+		}
+		s.NextRun = next
+	}
+
+	return scanSchedule(r.DB.QueryRowContext(ctx, "INSERT INTO schedules (from_id, to_id, money, cron, interval_seconds, next_run, "+
+		"status, max_attempts, retry_delay_seconds, created_at, service, created_by) "+
+		"VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12) returning "+scheduleColumns, s.FromID, s.ToID, s.Money,
+		s.Cron, s.Interval, s.NextRun.UTC(), ScheduleActive, s.MaxAttempts, s.RetryDelay, now, s.Service, s.CreatedBy))
+}
+
+func (r *RepositoryItem) GetSchedules(ctx context.Context, userID int) ([]*Schedule, error) {
+	ctx, cancel := r.operation(ctx)
+	defer cancel()
+
+	rows, err := r.DB.QueryContext(ctx, "SELECT "+scheduleColumns+" FROM schedules WHERE from_id = $1 ORDER BY id", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	schedules := make([]*Schedule, 0)
+	for rows.Next() {
+		s, err := scanSchedule(rows)
+		if err != nil {
+			return nil, err
+		}
+		schedules = append(schedules, s)
+	}
+
+	return schedules, rows.Err()
+}
+
+func (r *RepositoryItem) PauseSchedule(ctx context.Context, scheduleID int) (*Schedule, error) {
+	return r.setScheduleStatus(ctx, scheduleID, SchedulePaused, ScheduleActive)
+}
+
+func (r *RepositoryItem) ResumeSchedule(ctx context.Context, scheduleID int) (*Schedule, error) {
+	return r.setScheduleStatus(ctx, scheduleID, ScheduleActive, SchedulePaused)
+}
+
+func (r *RepositoryItem) CancelSchedule(ctx context.Context, scheduleID int) (*Schedule, error) {
+	return r.setScheduleStatus(ctx, scheduleID, ScheduleCancelled, ScheduleActive, SchedulePaused)
+}
+
+// setScheduleStatus moves a schedule from one of the given statuses.
+// A schedule already in the wanted status is returned as it is.
+func (r *RepositoryItem) setScheduleStatus(ctx context.Context, scheduleID int, status string, from ...string) (*Schedule, error) {
+	ctx, cancel := r.operation(ctx)
+	defer cancel()
+
+	query := "UPDATE schedules SET status = $2 WHERE id = $1 AND status IN ($3"
+	args := []interface{}{scheduleID, status, from[0]}
+	if len(from) > 1 {
+		query += ", $4"
+		args = append(args, from[1])
+	}
+
+	s, err := scanSchedule(r.DB.QueryRowContext(ctx, query+") returning "+scheduleColumns, args...))
+	if err != ErrScheduleNotFound {
+		return s, err
+	}
+
+	s, err = scanSchedule(r.DB.QueryRowContext(ctx, "SELECT "+scheduleColumns+" FROM schedules WHERE id = $1", scheduleID))
+	if err != nil {
+		return nil, err
+	}
+	if s.Status != status {
+		return nil, ErrScheduleFinished
+	}
+
+	return s, nil
+}
+
+// ClaimSchedules leases due schedules to the worker. Other workers skip them
+// until the lease ends, so a schedule is run by one worker at a time.
+func (r *RepositoryItem) ClaimSchedules(ctx context.Context, workerID string, lease time.Duration, limit int) ([]*Schedule, error) {
+	ctx, cancel := r.operation(ctx)
+	defer cancel()
+
+	now := r.now()
+	rows, err := r.DB.QueryContext(ctx, "UPDATE schedules SET locked_by = $1, locked_until = $2 WHERE id IN "+
+		"(SELECT id FROM schedules WHERE status = $3 AND next_run <= $4 AND (locked_until IS NULL OR locked_until < $4) "+
+		"ORDER BY next_run LIMIT $5 FOR UPDATE SKIP LOCKED) returning "+scheduleColumns,
+		workerID, now.Add(lease), ScheduleActive, now, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	schedules := make([]*Schedule, 0)
+	for rows.Next() {
+		s, err := scanSchedule(rows)
+		if err != nil {
+			return nil, err
+		}
+		schedules = append(schedules, s)
+	}
+
+	return schedules, rows.Err()
+}
+
+// isPaymentError tells a refused payment from a failure of the database.
+func isPaymentError(err error) bool {
+	for _, target := range []error{ErrNotEnoughMoney, ErrNegativeAmount, ErrAccountNotFound, ErrAccountFrozen,
+		ErrAccountClosed, ErrLimitExceeded, ErrRiskDenied} {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// RunSchedule makes the payment of a claimed schedule. The payment and the
+// move to the next run are committed together and only while the worker still
+// holds the lease, so a run is never paid twice. A refused payment is retried
+// after the retry delay if there was not enough money, other refusals skip the
+// run, the denials of the risk rules too. A run above the approval threshold
+// is held in the name of the creator of the schedule and counts as made, like
+// one the risk rules hold for a review. Database errors leave the schedule to
+// be claimed again.
+func (r *RepositoryItem) RunSchedule(ctx context.Context, s *Schedule, workerID string) error {
+	ctx, cancel := r.operation(ctx)
+	defer cancel()
+
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	db := withContext(ctx, tx)
+
+	var nextRun time.Time
+	err = db.QueryRow("SELECT next_run FROM schedules WHERE id = $1 AND locked_by = $2 AND status = $3 FOR UPDATE",
+		s.ID, workerID, ScheduleActive).Scan(&nextRun)
+	if err == nil && !nextRun.Equal(s.NextRun) {
+		err = sql.ErrNoRows
+	}
+	if err == sql.ErrNoRows {
+		// the lease has ended and the run was made by another worker,
+		// or the schedule was paused or cancelled meanwhile
+		//nolint:errcheck
+		tx.Rollback()
+		return nil
+	}
+	if err != nil {
+		//nolint:errcheck
+		tx.Rollback()
+		return err
+	}
+
+	tr, err := r.debit(s.payment(), db)
+	if err == nil || isHeld(err) {
+		var transactionID *int
+		if tr != nil {
+			transactionID = &tr.ID
+		}
+		err = r.advanceSchedule(s, workerID, transactionID, nil, db)
+		if err != nil {
+			//nolint:errcheck
+			tx.Rollback()
+			return err
+		}
+
+		return tx.Commit()
+	}
+
+	//nolint:errcheck
+	tx.Rollback()
+	if isStopped(err) {
+		err = r.recordRisk(ctx, err)
+		if errors.Is(err, ErrRiskReview) {
+			return r.advanceSchedule(s, workerID, nil, nil, withContext(ctx, r.DB))
+		}
+	}
+	if !isPaymentError(err) {
+		return err
+	}
+
+	return r.advanceSchedule(s, workerID, nil, err, withContext(ctx, r.DB))
+}
+
+// payment is the payment of one run of the schedule.
+func (s *Schedule) payment() *payment {
+	if s.ToID == nil {
+		return &payment{Operation: OperationWithdraw, UserID: s.FromID, Money: s.Money, Service: s.Service,
+			RequestedBy: s.CreatedBy}
+	}
+	return &payment{Operation: OperationTransfer, UserID: s.FromID, ToUserID: s.ToID, Money: s.Money,
+		RequestedBy: s.CreatedBy}
+}
+
+func (r *RepositoryItem) advanceSchedule(s *Schedule, workerID string, transactionID *int, runErr error,
+	db TransactionInterface) error {
+	now := r.now()
+	nextRun, status, attempts := s.NextRun, ScheduleActive, 0
+
+	var lastError *string
+	if runErr != nil {
+		msg := runErr.Error()
+		lastError = &msg
+	}
+
+	if runErr != nil && errors.Is(runErr, ErrNotEnoughMoney) && s.Attempts+1 < s.MaxAttempts {
+		attempts = s.Attempts + 1
+		nextRun = now.Add(time.Duration(s.RetryDelay) * time.Second)
+	} else if next, ok := s.following(now); ok {
+		nextRun = next
+	} else if runErr != nil {
+		status = ScheduleFailed
+	} else {
+		status = ScheduleDone
+	}
+
+	_, err := db.Exec("UPDATE schedules SET next_run = $3, status = $4, attempts = $5, last_error = $6, "+
+		"last_transaction_id = COALESCE($7, last_transaction_id), locked_by = NULL, locked_until = NULL "+
+		"WHERE id = $1 AND locked_by = $2", s.ID, workerID, nextRun, status, attempts, lastError, transactionID)
+	return err
+}
@@ -0,0 +1,569 @@
+package transaction
+
+import (
+	"fmt"
+	sqlmock "gopkg.in/DATA-DOG/go-sqlmock.v1"
+	"reflect"
+	"testing"
+	"time"
+)
+
+var scheduleColumnNames = []string{"id", "from_id", "to_id", "money", "cron", "interval_seconds", "next_run", "status",
+	"attempts", "max_attempts", "retry_delay_seconds", "last_error", "last_transaction_id", "created_at", "service", "created_by"}
+
+func scheduleRow(rows *sqlmock.Rows, s *Schedule) *sqlmock.Rows {
+	return rows.AddRow(s.ID, s.FromID, s.ToID, s.Money, s.Cron, s.Interval, s.NextRun, s.Status, s.Attempts,
+		s.MaxAttempts, s.RetryDelay, s.LastError, s.LastTransactionID, s.CreatedAt, s.Service, s.CreatedBy)
+}
+
+func TestCreateSchedule(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("cant create mock: %s", err)
+	}
+	defer db.Close()
+	repo := NewRepository(db)
+	repo.Clock = testClock
+
+	toID := 2
+	nextRun := time.Date(2021, 11, 28, 9, 0, 0, 0, time.UTC)
+	expect := &Schedule{
+		ID:          1,
+		FromID:      1,
+		ToID:        &toID,
+		Money:       100,
+		Cron:        "0 9 * * *",
+		NextRun:     nextRun,
+		Status:      ScheduleActive,
+		MaxAttempts: DefaultMaxAttempts,
+		RetryDelay:  DefaultRetryDelay,
+		CreatedAt:   testTime,
+	}
+
+	// the first run is the next one after now
+	mock.
+		ExpectQuery("INSERT INTO schedules").
+		WithArgs(1, &toID, 100.0, "0 9 * * *", int64(0), nextRun, ScheduleActive, DefaultMaxAttempts,
+			DefaultRetryDelay, testTime, "", "").
+		WillReturnRows(scheduleRow(sqlmock.NewRows(scheduleColumnNames), expect))
+
+	s, err := repo.CreateSchedule(ctx, &Schedule{FromID: 1, ToID: &toID, Money: 100, Cron: "0 9 * * *"})
+	if err != nil {
+		t.Errorf("unexpected err: %s", err)
+		return
+	}
+	if !reflect.DeepEqual(s, expect) {
+		t.Errorf("results not match, want %v, have %v", expect, s)
+		return
+	}
+
+	// interval, made by the client of the request whatever the body says
+	mock.
+		ExpectQuery("INSERT INTO schedules").
+		WithArgs(1, nil, 100.0, "", int64(60), testTime.Add(time.Minute), ScheduleActive, 5, int64(10), testTime, "music", "shop").
+		WillReturnRows(scheduleRow(sqlmock.NewRows(scheduleColumnNames), expect))
+
+	_, err = repo.CreateSchedule(WithRequester(ctx, "shop"), &Schedule{FromID: 1, Money: 100, Interval: 60, MaxAttempts: 5,
+		RetryDelay: 10, Service: "music", CreatedBy: "alice"})
+	if err != nil {
+		t.Errorf("unexpected err: %s", err)
+		return
+	}
+
+	// bad schedules
+	cases := []struct {
+		s   *Schedule
+		err error
+	}{
+		{&Schedule{FromID: 0, Money: 100, NextRun: testTime}, ErrBadAccountID},
+		{&Schedule{FromID: 1, Money: -1, NextRun: testTime}, ErrNegativeAmount},
+		{&Schedule{FromID: 1, Money: 0, NextRun: testTime}, ErrBadSchedule},
+		{&Schedule{FromID: 1, Money: 100}, ErrBadSchedule},
+		{&Schedule{FromID: 1, Money: 100, Cron: "* * *"}, ErrBadSchedule},
+		{&Schedule{FromID: 1, Money: 100, Cron: "* * * * *", Interval: 60}, ErrBadSchedule},
+	}
+	for _, c := range cases {
+		_, err = repo.CreateSchedule(ctx, c.s)
+		if err != c.err {
+			t.Errorf("expected %v for %v, got %v", c.err, c.s, err)
+			return
+		}
+	}
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestGetSchedules(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("cant create mock: %s", err)
+	}
+	defer db.Close()
+	repo := NewRepository(db)
+
+	expect := []*Schedule{{
+		ID:          1,
+		FromID:      1,
+		Money:       100,
+		NextRun:     testTime,
+		Status:      ScheduleActive,
+		MaxAttempts: DefaultMaxAttempts,
+		RetryDelay:  DefaultRetryDelay,
+		CreatedAt:   testTime,
+	}}
+
+	mock.
+		ExpectQuery("SELECT id, from_id, to_id, money, cron, interval_seconds, next_run, status, .* FROM schedules WHERE").
+		WithArgs(1).
+		WillReturnRows(scheduleRow(sqlmock.NewRows(scheduleColumnNames), expect[0]))
+
+	schedules, err := repo.GetSchedules(ctx, 1)
+	if err != nil {
+		t.Errorf("unexpected err: %s", err)
+		return
+	}
+	if !reflect.DeepEqual(schedules, expect) {
+		t.Errorf("results not match, want %v, have %v", expect, schedules)
+		return
+	}
+
+	mock.
+		ExpectQuery("SELECT id, from_id, to_id, money, cron, interval_seconds, next_run, status, .* FROM schedules WHERE").
+		WithArgs(1).
+		WillReturnError(fmt.Errorf("db_error"))
+
+	_, err = repo.GetSchedules(ctx, 1)
+	if err == nil {
+		t.Errorf("expected error, got nil")
+		return
+	}
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestScheduleStatus(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("cant create mock: %s", err)
+	}
+	defer db.Close()
+	repo := NewRepository(db)
+
+	s := &Schedule{ID: 1, FromID: 1, Money: 100, NextRun: testTime, Status: SchedulePaused, CreatedAt: testTime}
+
+	mock.
+		ExpectQuery("UPDATE schedules SET status").
+		WithArgs(1, SchedulePaused, ScheduleActive).
+		WillReturnRows(scheduleRow(sqlmock.NewRows(scheduleColumnNames), s))
+
+	res, err := repo.PauseSchedule(ctx, 1)
+	if err != nil {
+		t.Errorf("unexpected err: %s", err)
+		return
+	}
+	if res.Status != SchedulePaused {
+		t.Errorf("schedule is not paused: %v", res)
+		return
+	}
+
+	// pausing a paused schedule changes nothing
+	mock.
+		ExpectQuery("UPDATE schedules SET status").
+		WithArgs(1, SchedulePaused, ScheduleActive).
+		WillReturnRows(sqlmock.NewRows(scheduleColumnNames))
+	mock.
+		ExpectQuery("SELECT id, from_id, to_id, money, cron, interval_seconds, next_run, status, .* FROM schedules WHERE").
+		WithArgs(1).
+		WillReturnRows(scheduleRow(sqlmock.NewRows(scheduleColumnNames), s))
+
+	_, err = repo.PauseSchedule(ctx, 1)
+	if err != nil {
+		t.Errorf("unexpected err: %s", err)
+		return
+	}
+
+	// cancelled schedules stay cancelled
+	s.Status = ScheduleCancelled
+	mock.
+		ExpectQuery("UPDATE schedules SET status").
+		WithArgs(1, ScheduleActive, SchedulePaused).
+		WillReturnRows(sqlmock.NewRows(scheduleColumnNames))
+	mock.
+		ExpectQuery("SELECT id, from_id, to_id, money, cron, interval_seconds, next_run, status, .* FROM schedules WHERE").
+		WithArgs(1).
+		WillReturnRows(scheduleRow(sqlmock.NewRows(scheduleColumnNames), s))
+
+	_, err = repo.ResumeSchedule(ctx, 1)
+	if err != ErrScheduleFinished {
+		t.Errorf("expected ErrScheduleFinished, got %v", err)
+		return
+	}
+
+	// not found
+	mock.
+		ExpectQuery("UPDATE schedules SET status").
+		WithArgs(2, ScheduleCancelled, ScheduleActive, SchedulePaused).
+		WillReturnRows(sqlmock.NewRows(scheduleColumnNames))
+	mock.
+		ExpectQuery("SELECT id, from_id, to_id, money, cron, interval_seconds, next_run, status, .* FROM schedules WHERE").
+		WithArgs(2).
+		WillReturnRows(sqlmock.NewRows(scheduleColumnNames))
+
+	_, err = repo.CancelSchedule(ctx, 2)
+	if err != ErrScheduleNotFound {
+		t.Errorf("expected ErrScheduleNotFound, got %v", err)
+		return
+	}
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestClaimSchedules(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("cant create mock: %s", err)
+	}
+	defer db.Close()
+	repo := NewRepository(db)
+	repo.Clock = testClock
+
+	s := &Schedule{ID: 1, FromID: 1, Money: 100, NextRun: testTime, Status: ScheduleActive, CreatedAt: testTime}
+
+	mock.
+		ExpectQuery("UPDATE schedules SET locked_by").
+		WithArgs("worker-1", testTime.Add(time.Minute), ScheduleActive, testTime, 10).
+		WillReturnRows(scheduleRow(sqlmock.NewRows(scheduleColumnNames), s))
+
+	schedules, err := repo.ClaimSchedules(ctx, "worker-1", time.Minute, 10)
+	if err != nil {
+		t.Errorf("unexpected err: %s", err)
+		return
+	}
+	if !reflect.DeepEqual(schedules, []*Schedule{s}) {
+		t.Errorf("results not match, want %v, have %v", s, schedules)
+		return
+	}
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestRunSchedule(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("cant create mock: %s", err)
+	}
+	defer db.Close()
+	repo := NewRepository(db)
+	repo.Clock = testClock
+
+	elemID := 1
+	s := &Schedule{
+		ID:          1,
+		FromID:      elemID,
+		Money:       10,
+		Interval:    3600,
+		NextRun:     testTime.Add(-time.Minute),
+		Status:      ScheduleActive,
+		MaxAttempts: 2,
+		RetryDelay:  60,
+		Service:     "music",
+	}
+	trID := 7
+
+	// the withdrawal spends the bonuses of its service, it and the next run are
+	// committed together
+	mock.ExpectBegin()
+	mock.
+		ExpectQuery("SELECT next_run FROM schedules WHERE").
+		WithArgs(1, "worker-1", ScheduleActive).
+		WillReturnRows(sqlmock.NewRows([]string{"next_run"}).AddRow(s.NextRun))
+	mock.
+		ExpectQuery("SELECT id, remaining FROM bonus_grants WHERE").
+		WithArgs(elemID, testTime, "music").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "remaining"}))
+	mock.
+		ExpectQuery("SELECT balance, status, debit_blocked, credit_blocked, credit_limit FROM users WHERE").
+		WithArgs(elemID).
+		WillReturnRows(accountRows(100))
+	mock.
+		ExpectQuery("UPDATE users SET").
+		WithArgs(10.0, elemID).
+		WillReturnRows(sqlmock.NewRows([]string{"balance"}).AddRow(90.0))
+	expectNoLimits(mock)
+	mock.
+		ExpectQuery("INSERT INTO transaction").
+		WithArgs(nil, &elemID, -10.0, testTime, nil).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created"}).AddRow(trID, testTime))
+	expectNoFee(mock, OperationWithdraw)
+	mock.
+		ExpectExec("UPDATE schedules SET next_run").
+		WithArgs(1, "worker-1", s.NextRun.Add(time.Hour), ScheduleActive, 0, nil, &trID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	err = repo.RunSchedule(ctx, s, "worker-1")
+	if err != nil {
+		t.Errorf("unexpected err: %s", err)
+		return
+	}
+
+	// not enough money, retried after the delay
+	msg := ErrNotEnoughMoney.Error()
+	mock.ExpectBegin()
+	mock.
+		ExpectQuery("SELECT next_run FROM schedules WHERE").
+		WithArgs(1, "worker-1", ScheduleActive).
+		WillReturnRows(sqlmock.NewRows([]string{"next_run"}).AddRow(s.NextRun))
+	expectNoBonuses(mock)
+	mock.
+		ExpectQuery("SELECT balance, status, debit_blocked, credit_blocked, credit_limit FROM users WHERE").
+		WithArgs(elemID).
+		WillReturnRows(accountRows(5))
+	mock.ExpectRollback()
+	mock.
+		ExpectExec("UPDATE schedules SET next_run").
+		WithArgs(1, "worker-1", testTime.Add(time.Minute), ScheduleActive, 1, &msg, nil).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	err = repo.RunSchedule(ctx, s, "worker-1")
+	if err != nil {
+		t.Errorf("unexpected err: %s", err)
+		return
+	}
+
+	// the last attempt of a one-off schedule fails it
+	oneOff := *s
+	oneOff.Interval = 0
+	oneOff.Attempts = 1
+	mock.ExpectBegin()
+	mock.
+		ExpectQuery("SELECT next_run FROM schedules WHERE").
+		WithArgs(1, "worker-1", ScheduleActive).
+		WillReturnRows(sqlmock.NewRows([]string{"next_run"}).AddRow(s.NextRun))
+	expectNoBonuses(mock)
+	mock.
+		ExpectQuery("SELECT balance, status, debit_blocked, credit_blocked, credit_limit FROM users WHERE").
+		WithArgs(elemID).
+		WillReturnRows(accountRows(5))
+	mock.ExpectRollback()
+	mock.
+		ExpectExec("UPDATE schedules SET next_run").
+		WithArgs(1, "worker-1", s.NextRun, ScheduleFailed, 0, &msg, nil).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	err = repo.RunSchedule(ctx, &oneOff, "worker-1")
+	if err != nil {
+		t.Errorf("unexpected err: %s", err)
+		return
+	}
+
+	// the lease is lost, nothing is paid
+	mock.ExpectBegin()
+	mock.
+		ExpectQuery("SELECT next_run FROM schedules WHERE").
+		WithArgs(1, "worker-1", ScheduleActive).
+		WillReturnRows(sqlmock.NewRows([]string{"next_run"}))
+	mock.ExpectRollback()
+
+	err = repo.RunSchedule(ctx, s, "worker-1")
+	if err != nil {
+		t.Errorf("unexpected err: %s", err)
+		return
+	}
+
+	// the run was already made
+	mock.ExpectBegin()
+	mock.
+		ExpectQuery("SELECT next_run FROM schedules WHERE").
+		WithArgs(1, "worker-1", ScheduleActive).
+		WillReturnRows(sqlmock.NewRows([]string{"next_run"}).AddRow(s.NextRun.Add(time.Hour)))
+	mock.ExpectRollback()
+
+	err = repo.RunSchedule(ctx, s, "worker-1")
+	if err != nil {
+		t.Errorf("unexpected err: %s", err)
+		return
+	}
+
+	// database errors are returned and the schedule is left as it is
+	mock.ExpectBegin()
+	mock.
+		ExpectQuery("SELECT next_run FROM schedules WHERE").
+		WithArgs(1, "worker-1", ScheduleActive).
+		WillReturnRows(sqlmock.NewRows([]string{"next_run"}).AddRow(s.NextRun))
+	expectNoBonuses(mock)
+	mock.
+		ExpectQuery("SELECT balance, status, debit_blocked, credit_blocked, credit_limit FROM users WHERE").
+		WithArgs(elemID).
+		WillReturnError(fmt.Errorf("db_error"))
+	mock.ExpectRollback()
+
+	err = repo.RunSchedule(ctx, s, "worker-1")
+	if err == nil {
+		t.Errorf("expected error, got nil")
+		return
+	}
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestRunScheduleApproval(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("cant create mock: %s", err)
+	}
+	defer db.Close()
+	repo := NewRepository(db)
+	repo.Clock = testClock
+	repo.ApprovalThreshold = 1000
+	repo.ApprovalTTL = time.Hour
+
+	toID := 2
+	s := &Schedule{
+		ID:          1,
+		FromID:      1,
+		ToID:        &toID,
+		Money:       5000,
+		Interval:    3600,
+		NextRun:     testTime.Add(-time.Minute),
+		Status:      ScheduleActive,
+		MaxAttempts: 2,
+		RetryDelay:  60,
+		CreatedBy:   "shop",
+	}
+
+	// the large run is held in the name of the creator, the schedule moves on
+	mock.ExpectBegin()
+	mock.
+		ExpectQuery("SELECT next_run FROM schedules WHERE").
+		WithArgs(1, "worker-1", ScheduleActive).
+		WillReturnRows(sqlmock.NewRows([]string{"next_run"}).AddRow(s.NextRun))
+	for _, id := range []int{1, toID} {
+		mock.
+			ExpectQuery("SELECT balance, status, debit_blocked, credit_blocked, credit_limit FROM users WHERE").
+			WithArgs(id).
+			WillReturnRows(accountRows(10000))
+	}
+	mock.
+		ExpectQuery("UPDATE users SET held = held").
+		WithArgs(5000.0, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"held"}).AddRow(5000.0))
+	mock.
+		ExpectQuery("INSERT INTO pending_operations").
+		WithArgs(OperationTransfer, 1, &toID, 5000.0, PendingWaiting, "shop", testTime, testTime.Add(time.Hour), "", nil, "", 0.0, 5000.0).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(4))
+	expectPendingHistory(mock, 1, 20)
+	mock.
+		ExpectExec("INSERT INTO pending_events").
+		WithArgs(4, PendingWaiting, "shop", nil, testTime, 20).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.
+		ExpectExec("UPDATE schedules SET next_run").
+		WithArgs(1, "worker-1", s.NextRun.Add(time.Hour), ScheduleActive, 0, nil, nil).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	err = repo.RunSchedule(ctx, s, "worker-1")
+	if err != nil {
+		t.Errorf("unexpected err: %s", err)
+		return
+	}
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestRunScheduleRisk(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("cant create mock: %s", err)
+	}
+	defer db.Close()
+	repo := NewRepository(db)
+	repo.Clock = testClock
+	repo.RiskRules = []RiskRule{&NewAccountRule{MaxAge: 24 * time.Hour, MaxMoney: 10000, Decision: RiskHold}}
+
+	s := &Schedule{
+		ID:          1,
+		FromID:      1,
+		Money:       15000,
+		Interval:    3600,
+		NextRun:     testTime.Add(-time.Minute),
+		Status:      ScheduleActive,
+		MaxAttempts: 2,
+		RetryDelay:  60,
+		CreatedBy:   "shop",
+	}
+	expectRun := func() {
+		mock.ExpectBegin()
+		mock.
+			ExpectQuery("SELECT next_run FROM schedules WHERE").
+			WithArgs(1, "worker-1", ScheduleActive).
+			WillReturnRows(sqlmock.NewRows([]string{"next_run"}).AddRow(s.NextRun))
+		expectRiskWithdraw(mock, testTime.Add(-2*time.Hour))
+		mock.ExpectRollback()
+	}
+
+	// a run held for review counts as made
+	expectRun()
+	mock.ExpectBegin()
+	expectNoBonuses(mock)
+	mock.
+		ExpectQuery("UPDATE users SET held = held").
+		WithArgs(15000.0, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"held"}).AddRow(15000.0))
+	mock.
+		ExpectQuery("INSERT INTO risk_reviews").
+		WithArgs(OperationWithdraw, 1, nil, 15000.0, sqlmock.AnyArg(), ReviewPending, testTime, "", nil, "", 0.0, "shop", 15000.0).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(3))
+	mock.
+		ExpectExec("INSERT INTO risk_decisions").
+		WithArgs(OperationWithdraw, 1, nil, 15000.0, RiskHold, sqlmock.AnyArg(), nil, 3, testTime).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+	mock.
+		ExpectExec("UPDATE schedules SET next_run").
+		WithArgs(1, "worker-1", s.NextRun.Add(time.Hour), ScheduleActive, 0, nil, nil).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	err = repo.RunSchedule(ctx, s, "worker-1")
+	if err != nil {
+		t.Errorf("unexpected err: %s", err)
+		return
+	}
+
+	// a denied one is skipped with the reason
+	repo.RiskRules[0].(*NewAccountRule).Decision = RiskDeny
+	expectRun()
+	mock.ExpectBegin()
+	mock.
+		ExpectExec("INSERT INTO risk_decisions").
+		WithArgs(OperationWithdraw, 1, nil, 15000.0, RiskDeny, sqlmock.AnyArg(), nil, nil, testTime).
+		WillReturnResult(sqlmock.NewResult(2, 1))
+	mock.ExpectCommit()
+	mock.
+		ExpectExec("UPDATE schedules SET next_run").
+		WithArgs(1, "worker-1", s.NextRun.Add(time.Hour), ScheduleActive, 0, sqlmock.AnyArg(), nil).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	err = repo.RunSchedule(ctx, s, "worker-1")
+	if err != nil {
+		t.Errorf("unexpected err: %s", err)
+		return
+	}
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
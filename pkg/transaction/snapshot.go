@@ -0,0 +1,189 @@
+package transaction
+
+import (
+	"context"
+	"database/sql"
+	"math"
+	"time"
+)
+
+// balanceEffect is how a history row changed the balance of the given user.
+// Withdrawals are stored with negative money and no recipient, the parent of a
+// split payment has positive money and no recipient and moves nothing.
+func balanceEffect(user string) string {
+	return "CASE WHEN t.to_id = " + user + " THEN t.money WHEN t.to_id IS NULL THEN LEAST(t.money, 0) " +
+		"ELSE -t.money END"
+}
+
+// balancesAt selects the balance of every account at $1: the last checkpoint
+// before it plus the history after the checkpoint. Checkpoints older than the
+// archived history are skipped, without a checkpoint the history still in the
+// table is added to the effect of the archived one.
+var balancesAt = "SELECT u.id AS user_id, COALESCE(cp.balance, ab.balance, 0) + COALESCE((SELECT SUM(" + balanceEffect("u.id") + ") " +
+	"FROM transaction t WHERE (t.to_id = u.id OR t.from_id = u.id) AND t.created > COALESCE(cp.at, '-infinity') " +
+	"AND t.created <= $1), 0) AS balance FROM users u LEFT JOIN archived_balances ab ON ab.user_id = u.id " +
+	"LEFT JOIN LATERAL (SELECT c.at, c.balance FROM balance_checkpoints c WHERE c.user_id = u.id AND c.at <= $1 " +
+	"AND c.at >= COALESCE((SELECT MAX(to_ts) FROM history_archives), '-infinity') ORDER BY c.at DESC LIMIT 1) cp ON true"
+
+// GetBalanceAt replays the history from the last checkpoint before at, times
+// before the end of the archived history give ErrHistoryArchived.
+func (r *RepositoryItem) GetBalanceAt(ctx context.Context, userID int, at time.Time) (*BalanceSnapshot, error) {
+	ctx, cancel := r.operation(ctx)
+	defer cancel()
+
+	if userID <= 0 && userID != RevenueAccountID {
+		return nil, ErrBadAccountID
+	}
+	at = at.UTC()
+
+	var exists bool
+	err := r.DB.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM users WHERE id = $1)", userID).Scan(&exists)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, ErrAccountNotFound
+	}
+
+	snapshot := &BalanceSnapshot{UserID: userID, At: at}
+	var horizon, from sql.NullTime
+	err = r.DB.QueryRowContext(ctx, "SELECT h.horizon, cp.at, COALESCE(cp.balance, ab.balance, 0) "+
+		"FROM (SELECT MAX(to_ts) AS horizon FROM history_archives) h "+
+		"LEFT JOIN LATERAL (SELECT at, balance FROM balance_checkpoints WHERE user_id = $1 AND at <= $2 "+
+		"AND at >= COALESCE(h.horizon, '-infinity') ORDER BY at DESC LIMIT 1) cp ON true "+
+		"LEFT JOIN archived_balances ab ON ab.user_id = $1", userID, at).Scan(&horizon, &from, &snapshot.Balance)
+	if err != nil {
+		return nil, err
+	}
+	if horizon.Valid && at.Before(horizon.Time) {
+		return nil, ErrHistoryArchived
+	}
+
+	var replayed float64
+	err = r.DB.QueryRowContext(ctx, "SELECT COALESCE(SUM("+balanceEffect("$1")+"), 0) FROM transaction t "+
+		"WHERE (t.to_id = $1 OR t.from_id = $1) AND t.created > $2 AND t.created <= $3", userID, from.Time, at).Scan(&replayed)
+	if err != nil {
+		return nil, err
+	}
+	snapshot.Balance = math.Round((snapshot.Balance+replayed)*100) / 100
+
+	return snapshot, nil
+}
+
+// CreateCheckpoint stores the balance of every account at the given time.
+// Operations are written with the time they start, so at should be far enough
+// in the past for the operations before it to be committed. Checkpoints that
+// exist already are kept. Like the reports it reads every account, so only ctx
+// bounds it.
+func (r *RepositoryItem) CreateCheckpoint(ctx context.Context, at time.Time) (int64, error) {
+	horizon, err := archiveHorizon(withContext(ctx, r.DB))
+	if err != nil {
+		return 0, err
+	}
+	if horizon.Valid && at.Before(horizon.Time) {
+		return 0, ErrHistoryArchived
+	}
+
+	res, err := r.DB.ExecContext(ctx, "INSERT INTO balance_checkpoints (user_id, at, balance) SELECT b.user_id, $1, b.balance FROM ("+
+		balancesAt+") b ON CONFLICT (user_id, at) DO NOTHING", at.UTC())
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
+
+// CreateBalanceReport snapshots the balance of every account at the given time.
+func (r *RepositoryItem) CreateBalanceReport(ctx context.Context, at time.Time) (*BalanceReport, error) {
+	at = at.UTC()
+	if at.After(r.now()) {
+		return nil, ErrBadReportTime
+	}
+
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	db := withContext(ctx, tx)
+
+	horizon, err := archiveHorizon(db)
+	if err != nil {
+		//nolint:errcheck
+		tx.Rollback()
+		return nil, err
+	}
+	if horizon.Valid && at.Before(horizon.Time) {
+		//nolint:errcheck
+		tx.Rollback()
+		return nil, ErrHistoryArchived
+	}
+
+	report := &BalanceReport{At: at}
+	err = db.QueryRow("INSERT INTO balance_reports (at, created_at) VALUES ($1, $2) returning id, created_at",
+		at, r.now()).Scan(&report.ID, &report.CreatedAt)
+	if err != nil {
+		//nolint:errcheck
+		tx.Rollback()
+		return nil, err
+	}
+	report.CreatedAt = report.CreatedAt.UTC()
+
+	res, err := db.Exec("INSERT INTO balance_report_items (report_id, user_id, balance) SELECT $2, b.user_id, b.balance FROM ("+
+		balancesAt+") b", at, report.ID)
+	if err != nil {
+		//nolint:errcheck
+		tx.Rollback()
+		return nil, err
+	}
+	report.Accounts, err = res.RowsAffected()
+	if err != nil {
+		//nolint:errcheck
+		tx.Rollback()
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return report, nil
+}
+
+func (r *RepositoryItem) GetBalanceReport(ctx context.Context, reportID int) (*BalanceReport, error) {
+	ctx, cancel := r.operation(ctx)
+	defer cancel()
+
+	report := &BalanceReport{ID: reportID}
+	err := r.DB.QueryRowContext(ctx, "SELECT at, created_at FROM balance_reports WHERE id = $1", reportID).
+		Scan(&report.At, &report.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrReportNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	report.At = report.At.UTC()
+	report.CreatedAt = report.CreatedAt.UTC()
+
+	rows, err := r.DB.QueryContext(ctx, "SELECT user_id, balance FROM balance_report_items WHERE report_id = $1 ORDER BY user_id",
+		reportID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	report.Items = make([]*BalanceSnapshot, 0)
+	for rows.Next() {
+		item := &BalanceSnapshot{At: report.At}
+		err = rows.Scan(&item.UserID, &item.Balance)
+		if err != nil {
+			return nil, err
+		}
+		item.Balance = math.Round(item.Balance*100) / 100
+		report.Items = append(report.Items, item)
+	}
+	report.Accounts = int64(len(report.Items))
+
+	return report, rows.Err()
+}
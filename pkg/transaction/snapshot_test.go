@@ -0,0 +1,261 @@
+package transaction
+
+import (
+	"database/sql"
+	"fmt"
+	sqlmock "gopkg.in/DATA-DOG/go-sqlmock.v1"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestGetBalanceAt(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("cant create mock: %s", err)
+	}
+	defer db.Close()
+	repo := NewRepository(db)
+
+	at := time.Date(2021, 11, 1, 0, 0, 0, 0, time.UTC)
+	checkpoint := time.Date(2021, 10, 31, 0, 0, 0, 0, time.UTC)
+
+	mock.
+		ExpectQuery("SELECT EXISTS").
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	mock.
+		ExpectQuery("SELECT h.horizon, cp.at, COALESCE\\(cp.balance, ab.balance, 0\\)").
+		WithArgs(1, at).
+		WillReturnRows(sqlmock.NewRows([]string{"horizon", "at", "balance"}).AddRow(nil, checkpoint, 100.0))
+	mock.
+		ExpectQuery("SELECT COALESCE\\(SUM\\(CASE WHEN t.to_id = \\$1 THEN t.money").
+		WithArgs(1, checkpoint, at).
+		WillReturnRows(sqlmock.NewRows([]string{"sum"}).AddRow(-30.1))
+
+	snapshot, err := repo.GetBalanceAt(ctx, 1, at.In(time.FixedZone("MSK", 3*60*60)))
+	if err != nil {
+		t.Errorf("unexpected err: %s", err)
+		return
+	}
+	expect := &BalanceSnapshot{UserID: 1, At: at, Balance: 69.9}
+	if !reflect.DeepEqual(snapshot, expect) {
+		t.Errorf("results not match, want %v, have %v", expect, snapshot)
+		return
+	}
+
+	// no checkpoint yet, the whole history is replayed
+	mock.
+		ExpectQuery("SELECT EXISTS").
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	mock.
+		ExpectQuery("SELECT h.horizon, cp.at").
+		WithArgs(1, at).
+		WillReturnRows(sqlmock.NewRows([]string{"horizon", "at", "balance"}).AddRow(nil, nil, 0.0))
+	mock.
+		ExpectQuery("SELECT COALESCE\\(SUM").
+		WithArgs(1, time.Time{}, at).
+		WillReturnRows(sqlmock.NewRows([]string{"sum"}).AddRow(50.0))
+
+	snapshot, err = repo.GetBalanceAt(ctx, 1, at)
+	if err != nil || snapshot.Balance != 50 {
+		t.Errorf("unexpected result %v, %v", snapshot, err)
+		return
+	}
+
+	// no checkpoint after the archived history, its effect is the start
+	horizon := time.Date(2021, 10, 1, 0, 0, 0, 0, time.UTC)
+	mock.
+		ExpectQuery("SELECT EXISTS").
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	mock.
+		ExpectQuery("SELECT h.horizon, cp.at").
+		WithArgs(1, at).
+		WillReturnRows(sqlmock.NewRows([]string{"horizon", "at", "balance"}).AddRow(horizon, nil, 40.0))
+	mock.
+		ExpectQuery("SELECT COALESCE\\(SUM").
+		WithArgs(1, time.Time{}, at).
+		WillReturnRows(sqlmock.NewRows([]string{"sum"}).AddRow(10.0))
+
+	snapshot, err = repo.GetBalanceAt(ctx, 1, at)
+	if err != nil || snapshot.Balance != 50 {
+		t.Errorf("unexpected result %v, %v", snapshot, err)
+		return
+	}
+
+	// archived period
+	mock.
+		ExpectQuery("SELECT EXISTS").
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	mock.
+		ExpectQuery("SELECT h.horizon, cp.at").
+		WithArgs(1, checkpoint).
+		WillReturnRows(sqlmock.NewRows([]string{"horizon", "at", "balance"}).AddRow(at, nil, 40.0))
+
+	_, err = repo.GetBalanceAt(ctx, 1, checkpoint)
+	if err != ErrHistoryArchived {
+		t.Errorf("expected ErrHistoryArchived, got %v", err)
+		return
+	}
+
+	// no account
+	mock.
+		ExpectQuery("SELECT EXISTS").
+		WithArgs(2).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+
+	_, err = repo.GetBalanceAt(ctx, 2, at)
+	if err != ErrAccountNotFound {
+		t.Errorf("expected ErrAccountNotFound, got %v", err)
+		return
+	}
+
+	// db error
+	mock.
+		ExpectQuery("SELECT EXISTS").
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	mock.
+		ExpectQuery("SELECT h.horizon, cp.at").
+		WithArgs(1, at).
+		WillReturnError(fmt.Errorf("db_error"))
+
+	_, err = repo.GetBalanceAt(ctx, 1, at)
+	if err == nil {
+		t.Errorf("expected db error, got nil")
+		return
+	}
+
+	_, err = repo.GetBalanceAt(ctx, -1, at)
+	if err != ErrBadAccountID {
+		t.Errorf("expected ErrBadAccountID, got %v", err)
+		return
+	}
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestCreateCheckpoint(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("cant create mock: %s", err)
+	}
+	defer db.Close()
+	repo := NewRepository(db)
+
+	at := time.Date(2021, 11, 1, 0, 0, 0, 0, time.UTC)
+	mock.
+		ExpectQuery("SELECT MAX\\(to_ts\\) FROM history_archives").
+		WillReturnRows(sqlmock.NewRows([]string{"max"}).AddRow(nil))
+	mock.
+		ExpectExec("INSERT INTO balance_checkpoints \\(user_id, at, balance\\) SELECT .* ON CONFLICT").
+		WithArgs(at).
+		WillReturnResult(sqlmock.NewResult(0, 3))
+
+	n, err := repo.CreateCheckpoint(ctx, at)
+	if err != nil || n != 3 {
+		t.Errorf("unexpected result %d, %v", n, err)
+		return
+	}
+
+	// archived period
+	mock.
+		ExpectQuery("SELECT MAX\\(to_ts\\) FROM history_archives").
+		WillReturnRows(sqlmock.NewRows([]string{"max"}).AddRow(at.AddDate(0, 1, 0)))
+
+	_, err = repo.CreateCheckpoint(ctx, at)
+	if err != ErrHistoryArchived {
+		t.Errorf("expected ErrHistoryArchived, got %v", err)
+		return
+	}
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestBalanceReport(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("cant create mock: %s", err)
+	}
+	defer db.Close()
+	repo := NewRepository(db)
+	repo.Clock = testClock
+
+	at := time.Date(2021, 11, 1, 0, 0, 0, 0, time.UTC)
+
+	mock.ExpectBegin()
+	mock.
+		ExpectQuery("SELECT MAX\\(to_ts\\) FROM history_archives").
+		WillReturnRows(sqlmock.NewRows([]string{"max"}).AddRow(nil))
+	mock.
+		ExpectQuery("INSERT INTO balance_reports").
+		WithArgs(at, testTime).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(1, testTime))
+	mock.
+		ExpectExec("INSERT INTO balance_report_items").
+		WithArgs(at, 1).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectCommit()
+
+	report, err := repo.CreateBalanceReport(ctx, at)
+	if err != nil {
+		t.Errorf("unexpected err: %s", err)
+		return
+	}
+	expect := &BalanceReport{ID: 1, At: at, CreatedAt: testTime, Accounts: 2}
+	if !reflect.DeepEqual(report, expect) {
+		t.Errorf("results not match, want %v, have %v", expect, report)
+		return
+	}
+
+	// future
+	_, err = repo.CreateBalanceReport(ctx, testTime.Add(time.Hour))
+	if err != ErrBadReportTime {
+		t.Errorf("expected ErrBadReportTime, got %v", err)
+		return
+	}
+
+	// read
+	mock.
+		ExpectQuery("SELECT at, created_at FROM balance_reports").
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"at", "created_at"}).AddRow(at, testTime))
+	mock.
+		ExpectQuery("SELECT user_id, balance FROM balance_report_items").
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "balance"}).AddRow(1, 10.0).AddRow(2, 0.0))
+
+	report, err = repo.GetBalanceReport(ctx, 1)
+	if err != nil {
+		t.Errorf("unexpected err: %s", err)
+		return
+	}
+	expect.Items = []*BalanceSnapshot{{UserID: 1, At: at, Balance: 10}, {UserID: 2, At: at}}
+	if !reflect.DeepEqual(report, expect) {
+		t.Errorf("results not match, want %v, have %v", expect, report)
+		return
+	}
+
+	// not found
+	mock.
+		ExpectQuery("SELECT at, created_at FROM balance_reports").
+		WithArgs(2).
+		WillReturnError(sql.ErrNoRows)
+
+	_, err = repo.GetBalanceReport(ctx, 2)
+	if err != ErrReportNotFound {
+		t.Errorf("expected ErrReportNotFound, got %v", err)
+		return
+	}
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
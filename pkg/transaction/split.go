@@ -0,0 +1,221 @@
+package transaction
+
+import (
+	"context"
+	"fmt"
+	"math"
+)
+
+// MaxSplitLegs is the most recipients of one split payment.
+const MaxSplitLegs = 100
+
+// A split payment is stored as a parent row with the payer and the total but
+// no recipient, and a transfer leg per recipient pointing to it by split_of.
+// Money is moved by the legs only, the parent just groups them.
+
+func toKopecks(money float64) int64 {
+	return int64(math.Round(money * 100))
+}
+
+// splitAmounts returns the money of every leg and the total. Percent legs are
+// rounded to kopecks, the last of them takes what rounding left over so the
+// legs add up to the total exactly.
+func (req *SplitRequest) splitAmounts() ([]float64, float64, error) {
+	if req.UserID <= 0 {
+		return nil, 0, ErrBadAccountID
+	}
+	if req.Money < 0 {
+		return nil, 0, ErrNegativeAmount
+	}
+	if len(req.Legs) == 0 || len(req.Legs) > MaxSplitLegs {
+		return nil, 0, ErrBadSplit
+	}
+
+	recipients := make(map[int]bool, len(req.Legs))
+	amounts := make([]int64, len(req.Legs))
+	var fixed int64
+	lastPercent := -1
+	for i, leg := range req.Legs {
+		if leg.ToUserID <= 0 || leg.ToUserID == req.UserID || recipients[leg.ToUserID] {
+			return nil, 0, ErrBadAccountID
+		}
+		recipients[leg.ToUserID] = true
+
+		if leg.Money < 0 || leg.Percent < 0 {
+			return nil, 0, ErrNegativeAmount
+		}
+		if (leg.Money == 0) == (leg.Percent == 0) {
+			// exactly one of them is set
+			return nil, 0, ErrBadSplit
+		}
+
+		if leg.Percent != 0 {
+			lastPercent = i
+			continue
+		}
+		amounts[i] = toKopecks(leg.Money)
+		fixed += amounts[i]
+	}
+
+	total := toKopecks(req.Money)
+	if total == 0 {
+		if lastPercent >= 0 {
+			return nil, 0, ErrBadSplit
+		}
+		total = fixed
+	}
+
+	if lastPercent >= 0 {
+		shared := fixed
+		percentLegs := int64(1)
+		for i, leg := range req.Legs {
+			if leg.Percent != 0 && i != lastPercent {
+				amounts[i] = int64(math.Round(float64(total) * leg.Percent / 100))
+				shared += amounts[i]
+				percentLegs++
+			}
+		}
+		amounts[lastPercent] = total - shared
+
+		// the rest may differ from the percent by rounding only
+		want := int64(math.Round(float64(total) * req.Legs[lastPercent].Percent / 100))
+		if amounts[lastPercent]-want > percentLegs || want-amounts[lastPercent] > percentLegs {
+			return nil, 0, ErrBadSplit
+		}
+	}
+
+	var sum int64
+	for _, amount := range amounts {
+		if amount <= 0 {
+			return nil, 0, ErrBadSplit
+		}
+		sum += amount
+	}
+	if sum != total {
+		return nil, 0, ErrBadSplit
+	}
+
+	money := make([]float64, len(amounts))
+	for i, amount := range amounts {
+		money[i] = float64(amount) / 100
+	}
+
+	return money, float64(total) / 100, nil
+}
+
+// SplitMoney debits the payer once and credits every leg in one transaction.
+// Limits and the transfer fee apply to the total. A total above the approval
+// threshold is held like a transfer, the risk rules screen the others.
+func (r *RepositoryItem) SplitMoney(ctx context.Context, req *SplitRequest) (*Transaction, error) {
+	amounts, total, err := req.splitAmounts()
+	if err != nil {
+		return nil, err
+	}
+	legs := make([]*SplitLeg, len(req.Legs))
+	for i, leg := range req.Legs {
+		legs[i] = &SplitLeg{ToUserID: leg.ToUserID, Money: amounts[i]}
+	}
+
+	ctx, cancel := r.operation(ctx)
+	defer cancel()
+
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	tr, err := r.debit(&payment{Operation: OperationSplit, UserID: req.UserID, Money: total, Legs: legs,
+		RequestedBy: Requester(ctx)}, withContext(ctx, tx))
+	if err != nil && !isHeld(err) {
+		//nolint:errcheck
+		tx.Rollback()
+		return nil, r.recordRisk(ctx, err)
+	}
+
+	commitErr := tx.Commit()
+	if commitErr != nil {
+		return nil, commitErr
+	}
+
+	return tr, err
+}
+
+// split pays the legs, each of them with its fixed Money, total is their sum.
+func (r *RepositoryItem) split(fromUserID int, legs []*SplitLeg, total float64, db TransactionInterface) (*Transaction, error) {
+	balance, err := r.getMoneyFromDB(fromUserID, total, db)
+	if err != nil {
+		return nil, err
+	}
+
+	err = r.checkLimits(fromUserID, total, true, db)
+	if err != nil {
+		return nil, err
+	}
+
+	tr, err := r.writeTransaction(nil, &fromUserID, total, nil, db)
+	if err != nil {
+		return nil, err
+	}
+
+	tr.Legs = make([]*Transaction, 0, len(legs))
+	for _, leg := range legs {
+		toUserID := leg.ToUserID
+		_, err = r.appendMoneyToUser(toUserID, leg.Money, db)
+		if err != nil {
+			return nil, err
+		}
+
+		legTr := &Transaction{
+			ToID:    &toUserID,
+			FromID:  &fromUserID,
+			Money:   leg.Money,
+			SplitOf: &tr.ID,
+		}
+		err = db.QueryRow("INSERT INTO transaction (to_id, from_id, money, created, split_of) VALUES ($1, $2, $3, $4, $5) returning id, created",
+			legTr.ToID, legTr.FromID, legTr.Money, r.now(), legTr.SplitOf).Scan(&legTr.ID, &legTr.Created)
+		if err != nil {
+			return nil, fmt.Errorf("dont create transaction: %v", err)
+		}
+		legTr.Created = legTr.Created.UTC()
+		tr.Legs = append(tr.Legs, legTr)
+	}
+
+	tr.Fee, err = r.chargeFee(OperationTransfer, fromUserID, total, opCurrency{}, tr.ID, db)
+	if err != nil {
+		return nil, err
+	}
+	if tr.Fee != nil {
+		balance = *tr.Fee.Balance
+	}
+	tr.Balance = &balance
+
+	return tr, nil
+}
+
+// isSplit tells the parent row of a split payment: it has a payer and no
+// recipient like a withdrawal, but withdrawals are stored with negative money.
+func (tr *Transaction) isSplit() bool {
+	return tr.ToID == nil && tr.FromID != nil && tr.Money > 0
+}
+
+func (r *RepositoryItem) getSplitLegs(transactionID int, db TransactionInterface) ([]*Transaction, error) {
+	rows, err := db.Query("SELECT id, to_id, from_id, money, created, refund_of, fee_of, split_of FROM transaction "+
+		"WHERE split_of = $1 ORDER BY id", transactionID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	legs := make([]*Transaction, 0)
+	for rows.Next() {
+		curr := &Transaction{}
+		err = rows.Scan(&curr.ID, &curr.ToID, &curr.FromID, &curr.Money, &curr.Created, &curr.RefundOf, &curr.FeeOf, &curr.SplitOf)
+		if err != nil {
+			return nil, err
+		}
+		curr.Created = curr.Created.UTC()
+		legs = append(legs, curr)
+	}
+
+	return legs, rows.Err()
+}
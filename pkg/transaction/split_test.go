@@ -0,0 +1,424 @@
+package transaction
+
+import (
+	"errors"
+	sqlmock "gopkg.in/DATA-DOG/go-sqlmock.v1"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSplitAmounts(t *testing.T) {
+	cases := []struct {
+		req     *SplitRequest
+		amounts []float64
+		total   float64
+		err     error
+	}{
+		{
+			req:     &SplitRequest{UserID: 1, Legs: []*SplitLeg{{ToUserID: 2, Money: 70}, {ToUserID: 3, Money: 30.5}}},
+			amounts: []float64{70, 30.5},
+			total:   100.5,
+		},
+		{
+			req: &SplitRequest{UserID: 1, Money: 100, Legs: []*SplitLeg{{ToUserID: 2, Percent: 33.3}, {ToUserID: 3, Percent: 33.3},
+				{ToUserID: 4, Percent: 33.4}}},
+			amounts: []float64{33.3, 33.3, 33.4},
+			total:   100,
+		},
+		{
+			// rounding leftovers go to the last percent leg
+			req:     &SplitRequest{UserID: 1, Money: 0.1, Legs: []*SplitLeg{{ToUserID: 2, Percent: 50}, {ToUserID: 3, Percent: 50}}},
+			amounts: []float64{0.05, 0.05},
+			total:   0.1,
+		},
+		{
+			req:     &SplitRequest{UserID: 1, Money: 1000, Legs: []*SplitLeg{{ToUserID: 2, Money: 50}, {ToUserID: 3, Percent: 95}}},
+			amounts: []float64{50, 950},
+			total:   1000,
+		},
+		{
+			req: &SplitRequest{UserID: 1, Money: 100, Legs: []*SplitLeg{{ToUserID: 2, Percent: 50}, {ToUserID: 3, Percent: 40}}},
+			err: ErrBadSplit,
+		},
+		{
+			req: &SplitRequest{UserID: 1, Money: 100, Legs: []*SplitLeg{{ToUserID: 2, Money: 50}, {ToUserID: 3, Money: 40}}},
+			err: ErrBadSplit,
+		},
+		{
+			req: &SplitRequest{UserID: 1, Legs: []*SplitLeg{{ToUserID: 2, Percent: 100}}},
+			err: ErrBadSplit,
+		},
+		{
+			req: &SplitRequest{UserID: 1, Legs: []*SplitLeg{{ToUserID: 2, Money: 10, Percent: 10}}},
+			err: ErrBadSplit,
+		},
+		{
+			req: &SplitRequest{UserID: 1, Legs: []*SplitLeg{}},
+			err: ErrBadSplit,
+		},
+		{
+			req: &SplitRequest{UserID: 1, Legs: []*SplitLeg{{ToUserID: 2, Money: 10}, {ToUserID: 2, Money: 10}}},
+			err: ErrBadAccountID,
+		},
+		{
+			req: &SplitRequest{UserID: 1, Legs: []*SplitLeg{{ToUserID: 1, Money: 10}}},
+			err: ErrBadAccountID,
+		},
+		{
+			req: &SplitRequest{UserID: 1, Legs: []*SplitLeg{{ToUserID: 2, Money: -10}}},
+			err: ErrNegativeAmount,
+		},
+	}
+
+	for i, c := range cases {
+		amounts, total, err := c.req.splitAmounts()
+		if err != c.err {
+			t.Errorf("case %d: expected %v, got %v", i, c.err, err)
+			continue
+		}
+		if err == nil && (!reflect.DeepEqual(amounts, c.amounts) || total != c.total) {
+			t.Errorf("case %d: want %v %v, have %v %v", i, c.amounts, c.total, amounts, total)
+		}
+	}
+}
+
+func expectLeg(mock sqlmock.Sqlmock, fromID, toID int, money float64, parentID, legID int) {
+	mock.
+		ExpectQuery("SELECT balance, status, debit_blocked, credit_blocked, credit_limit FROM users WHERE").
+		WithArgs(toID).
+		WillReturnRows(accountRows(0))
+	mock.
+		ExpectQuery("UPDATE users SET").
+		WithArgs(money, toID).
+		WillReturnRows(sqlmock.NewRows([]string{"balance"}).AddRow(money))
+	mock.
+		ExpectQuery("INSERT INTO transaction \\(to_id, from_id, money, created, split_of\\)").
+		WithArgs(&toID, &fromID, money, testTime, &parentID).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created"}).AddRow(legID, testTime))
+}
+
+func TestSplitMoney(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("cant create mock: %s", err)
+	}
+	defer db.Close()
+	repo := NewRepository(db)
+	repo.Clock = testClock
+
+	elemID := 1
+	parentID := 10
+
+	mock.ExpectBegin()
+	mock.
+		ExpectQuery("SELECT balance, status, debit_blocked, credit_blocked, credit_limit FROM users WHERE").
+		WithArgs(elemID).
+		WillReturnRows(accountRows(500))
+	mock.
+		ExpectQuery("UPDATE users SET").
+		WithArgs(100.0, elemID).
+		WillReturnRows(sqlmock.NewRows([]string{"balance"}).AddRow(400.0))
+	expectNoLimits(mock)
+	mock.
+		ExpectQuery("INSERT INTO transaction \\(to_id, from_id, money, created, refund_of\\)").
+		WithArgs(nil, &elemID, 100.0, testTime, nil).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created"}).AddRow(parentID, testTime))
+	expectLeg(mock, elemID, 2, 90, parentID, 11)
+	expectLeg(mock, elemID, 3, 10, parentID, 12)
+	expectNoFee(mock, OperationTransfer)
+	mock.ExpectCommit()
+
+	tr, err := repo.SplitMoney(ctx, &SplitRequest{UserID: elemID, Money: 100, Legs: []*SplitLeg{
+		{ToUserID: 2, Percent: 90},
+		{ToUserID: 3, Money: 10},
+	}})
+	if err != nil {
+		t.Errorf("unexpected err: %s", err)
+		return
+	}
+	if tr.ID != parentID || tr.Money != 100 || *tr.Balance != 400 || len(tr.Legs) != 2 {
+		t.Errorf("unexpected split %v", tr)
+		return
+	}
+	for i, leg := range tr.Legs {
+		if leg.ID != 11+i || *leg.SplitOf != parentID || *leg.FromID != elemID {
+			t.Errorf("unexpected leg %v", leg)
+			return
+		}
+	}
+
+	// a refused leg rolls the whole payment back
+	mock.ExpectBegin()
+	mock.
+		ExpectQuery("SELECT balance, status, debit_blocked, credit_blocked, credit_limit FROM users WHERE").
+		WithArgs(elemID).
+		WillReturnRows(accountRows(500))
+	mock.
+		ExpectQuery("UPDATE users SET").
+		WithArgs(100.0, elemID).
+		WillReturnRows(sqlmock.NewRows([]string{"balance"}).AddRow(400.0))
+	expectNoLimits(mock)
+	mock.
+		ExpectQuery("INSERT INTO transaction \\(to_id, from_id, money, created, refund_of\\)").
+		WithArgs(nil, &elemID, 100.0, testTime, nil).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created"}).AddRow(parentID, testTime))
+	mock.
+		ExpectQuery("SELECT balance, status, debit_blocked, credit_blocked, credit_limit FROM users WHERE").
+		WithArgs(2).
+		WillReturnRows(sqlmock.NewRows([]string{"balance", "status", "debit_blocked", "credit_blocked", "credit_limit"}).
+			AddRow(0.0, AccountClosed, false, false, 0.0))
+	mock.ExpectRollback()
+
+	_, err = repo.SplitMoney(ctx, &SplitRequest{UserID: elemID, Legs: []*SplitLeg{
+		{ToUserID: 2, Money: 60},
+		{ToUserID: 3, Money: 40},
+	}})
+	if err != ErrAccountClosed {
+		t.Errorf("expected ErrAccountClosed, got %v", err)
+		return
+	}
+
+	// not enough money
+	mock.ExpectBegin()
+	mock.
+		ExpectQuery("SELECT balance, status, debit_blocked, credit_blocked, credit_limit FROM users WHERE").
+		WithArgs(elemID).
+		WillReturnRows(accountRows(50))
+	mock.ExpectRollback()
+
+	_, err = repo.SplitMoney(ctx, &SplitRequest{UserID: elemID, Legs: []*SplitLeg{
+		{ToUserID: 2, Money: 60},
+		{ToUserID: 3, Money: 40},
+	}})
+	if err != ErrNotEnoughMoney {
+		t.Errorf("expected ErrNotEnoughMoney, got %v", err)
+		return
+	}
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestSplitMoneyApproval(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("cant create mock: %s", err)
+	}
+	defer db.Close()
+	repo := NewRepository(db)
+	repo.Clock = testClock
+	repo.ApprovalThreshold = 100
+	repo.ApprovalTTL = time.Hour
+
+	elemID := 1
+	parentID := 10
+	legs := `[{"id_to":2,"money":90},{"id_to":3,"money":60}]`
+
+	// above the threshold the total is held with the legs
+	mock.ExpectBegin()
+	for _, id := range []int{elemID, 2, 3} {
+		mock.
+			ExpectQuery("SELECT balance, status, debit_blocked, credit_blocked, credit_limit FROM users WHERE").
+			WithArgs(id).
+			WillReturnRows(accountRows(500))
+	}
+	mock.
+		ExpectQuery("UPDATE users SET held = held").
+		WithArgs(150.0, elemID).
+		WillReturnRows(sqlmock.NewRows([]string{"held"}).AddRow(150.0))
+	mock.
+		ExpectQuery("INSERT INTO pending_operations").
+		WithArgs(OperationSplit, elemID, nil, 150.0, PendingWaiting, "shop", testTime, testTime.Add(time.Hour), "",
+			[]byte(legs), "", 0.0, 150.0).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(4))
+	expectPendingHistory(mock, elemID, 20)
+	mock.
+		ExpectExec("INSERT INTO pending_events").
+		WithArgs(4, PendingWaiting, "shop", nil, testTime, 20).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	_, err = repo.SplitMoney(WithRequester(ctx, "shop"), &SplitRequest{UserID: elemID, Money: 150, Legs: []*SplitLeg{
+		{ToUserID: 2, Percent: 60},
+		{ToUserID: 3, Money: 60},
+	}})
+	pendingErr := &PendingError{}
+	if !errors.As(err, &pendingErr) || pendingErr.Pending.ID != 4 || len(pendingErr.Pending.Legs) != 2 {
+		t.Errorf("expected a pending split, got %v", err)
+		return
+	}
+
+	// the approval pays the legs as they were held
+	mock.ExpectBegin()
+	mock.
+		ExpectQuery("FROM pending_operations WHERE id = \\$1 FOR UPDATE").
+		WithArgs(4).
+		WillReturnRows(sqlmock.NewRows(pendingColumnNames).
+			AddRow(4, OperationSplit, elemID, nil, 150.0, PendingWaiting, "shop", nil, testTime, testTime.Add(time.Hour), nil,
+				nil, "", []byte(legs), "", 0.0, 150.0))
+	mock.
+		ExpectExec("UPDATE users SET held = GREATEST").
+		WithArgs(150.0, elemID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.
+		ExpectQuery("SELECT balance, status, debit_blocked, credit_blocked, credit_limit FROM users WHERE").
+		WithArgs(elemID).
+		WillReturnRows(accountRows(500))
+	mock.
+		ExpectQuery("UPDATE users SET").
+		WithArgs(150.0, elemID).
+		WillReturnRows(sqlmock.NewRows([]string{"balance"}).AddRow(350.0))
+	expectNoLimits(mock)
+	mock.
+		ExpectQuery("INSERT INTO transaction \\(to_id, from_id, money, created, refund_of\\)").
+		WithArgs(nil, &elemID, 150.0, testTime, nil).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created"}).AddRow(parentID, testTime))
+	expectLeg(mock, elemID, 2, 90, parentID, 11)
+	expectLeg(mock, elemID, 3, 60, parentID, 12)
+	expectNoFee(mock, OperationTransfer)
+	mock.
+		ExpectExec("UPDATE pending_operations SET status").
+		WithArgs(4, PendingApproved, "alice", testTime, parentID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectPendingHistory(mock, elemID, 21)
+	mock.
+		ExpectExec("INSERT INTO pending_events").
+		WithArgs(4, PendingApproved, "alice", parentID, testTime, 21).
+		WillReturnResult(sqlmock.NewResult(2, 1))
+	mock.
+		ExpectQuery("FROM pending_events WHERE pending_id = \\$1").
+		WithArgs(4).
+		WillReturnRows(sqlmock.NewRows([]string{"status", "actor", "transaction_id", "created"}))
+	mock.ExpectCommit()
+
+	p, err := repo.ApprovePendingOperation(ctx, 4, "alice")
+	if err != nil || *p.TransactionID != parentID {
+		t.Errorf("unexpected result %v, %v", p, err)
+		return
+	}
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestGetSplitTransaction(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("cant create mock: %s", err)
+	}
+	defer db.Close()
+	repo := NewRepository(db)
+
+	elemID := 1
+	columns := []string{"id", "to_id", "from_id", "money", "created", "refund_of", "fee_of", "split_of"}
+
+	mock.
+		ExpectQuery("SELECT id, to_id, from_id, money, created, refund_of, fee_of, split_of FROM transaction WHERE id").
+		WithArgs(10).
+		WillReturnRows(sqlmock.NewRows(columns).AddRow(10, nil, elemID, 100.0, testTime, nil, nil, nil))
+	mock.
+		ExpectQuery("SELECT id, to_id, from_id, money, created, refund_of, fee_of, split_of FROM transaction WHERE split_of").
+		WithArgs(10).
+		WillReturnRows(sqlmock.NewRows(columns).
+			AddRow(11, 2, elemID, 90.0, testTime, nil, nil, 10).
+			AddRow(12, 3, elemID, 10.0, testTime, nil, nil, 10))
+
+	tr, err := repo.GetTransactionByID(ctx, 10)
+	if err != nil {
+		t.Errorf("unexpected err: %s", err)
+		return
+	}
+	if len(tr.Legs) != 2 || *tr.Legs[0].ToID != 2 || *tr.Legs[1].SplitOf != 10 {
+		t.Errorf("unexpected split %v", tr)
+		return
+	}
+
+	// the parent is not refunded, its legs are
+	mock.ExpectBegin()
+	mock.
+		ExpectQuery("SELECT to_id, from_id, money, refund_of FROM transaction WHERE id").
+		WithArgs(10).
+		WillReturnRows(sqlmock.NewRows([]string{"to_id", "from_id", "money", "refund_of"}).AddRow(nil, elemID, 100.0, nil))
+	mock.ExpectRollback()
+
+	_, err = repo.RefundMoney(ctx, 10, 0)
+	if err != ErrNotRefundable {
+		t.Errorf("expected ErrNotRefundable, got %v", err)
+		return
+	}
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestSplitMoneyRisk(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("cant create mock: %s", err)
+	}
+	defer db.Close()
+	repo := NewRepository(db)
+	repo.Clock = testClock
+	repo.RiskRules = []RiskRule{&FanOutRule{Window: time.Hour, MaxRecipients: 1, Decision: RiskHold}}
+
+	elemID := 1
+	parentID := 10
+
+	// paying two accounts at once fans out, the split is rolled back and
+	// queued for review with its legs
+	mock.ExpectBegin()
+	mock.
+		ExpectQuery("SELECT balance, status, debit_blocked, credit_blocked, credit_limit FROM users WHERE").
+		WithArgs(elemID).
+		WillReturnRows(accountRows(500))
+	mock.
+		ExpectQuery("UPDATE users SET").
+		WithArgs(100.0, elemID).
+		WillReturnRows(sqlmock.NewRows([]string{"balance"}).AddRow(400.0))
+	expectNoLimits(mock)
+	mock.
+		ExpectQuery("INSERT INTO transaction \\(to_id, from_id, money, created, refund_of\\)").
+		WithArgs(nil, &elemID, 100.0, testTime, nil).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created"}).AddRow(parentID, testTime))
+	expectLeg(mock, elemID, 2, 90, parentID, 11)
+	expectLeg(mock, elemID, 3, 10, parentID, 12)
+	expectNoFee(mock, OperationTransfer)
+	mock.
+		ExpectQuery("SELECT DISTINCT to_id FROM transaction").
+		WithArgs(elemID, testTime.Add(-time.Hour)).
+		WillReturnRows(sqlmock.NewRows([]string{"to_id"}).AddRow(2).AddRow(3))
+	mock.ExpectRollback()
+	mock.ExpectBegin()
+	mock.
+		ExpectQuery("UPDATE users SET held = held").
+		WithArgs(100.0, elemID).
+		WillReturnRows(sqlmock.NewRows([]string{"held"}).AddRow(100.0))
+	mock.
+		ExpectQuery("INSERT INTO risk_reviews").
+		WithArgs(OperationSplit, elemID, nil, 100.0, sqlmock.AnyArg(), ReviewPending, testTime, "",
+			[]byte(`[{"id_to":2,"money":90},{"id_to":3,"money":10}]`), "", 0.0, nil, 100.0).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(3))
+	mock.
+		ExpectExec("INSERT INTO risk_decisions").
+		WithArgs(OperationSplit, elemID, nil, 100.0, RiskHold, sqlmock.AnyArg(), nil, 3, testTime).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	_, err = repo.SplitMoney(ctx, &SplitRequest{UserID: elemID, Money: 100, Legs: []*SplitLeg{
+		{ToUserID: 2, Percent: 90},
+		{ToUserID: 3, Money: 10},
+	}})
+	riskErr := &RiskError{}
+	if !errors.Is(err, ErrRiskReview) || !errors.As(err, &riskErr) || riskErr.ReviewID != 3 {
+		t.Errorf("expected a split held for review, got %v", err)
+		return
+	}
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
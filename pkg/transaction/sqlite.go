@@ -0,0 +1,615 @@
+package transaction
+
+import (
+	"context"
+	"database/sql"
+	"math"
+	"time"
+)
+
+// sqliteSchema is the ledger of SQLiteStorage: the accounts, the history, the
+// overdrafts and the rates, like the same tables of script/script.sql.
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS users
+(
+    id             INTEGER PRIMARY KEY,
+    balance        REAL      NOT NULL DEFAULT 0,
+    external_ref   TEXT UNIQUE,
+    status         TEXT      NOT NULL DEFAULT 'active',
+    debit_blocked  BOOLEAN   NOT NULL DEFAULT FALSE,
+    credit_blocked BOOLEAN   NOT NULL DEFAULT FALSE,
+    created_at     TIMESTAMP NOT NULL,
+    closed_at      TIMESTAMP,
+    credit_limit   REAL      NOT NULL DEFAULT 0
+);
+
+CREATE TABLE IF NOT EXISTS "transaction"
+(
+    id        INTEGER PRIMARY KEY AUTOINCREMENT,
+    to_id     INTEGER REFERENCES users (id),
+    from_id   INTEGER REFERENCES users (id),
+    money     REAL      NOT NULL,
+    created   TIMESTAMP NOT NULL,
+    refund_of INTEGER REFERENCES "transaction" (id)
+);
+
+CREATE INDEX IF NOT EXISTS transaction_to_id ON "transaction" (to_id);
+CREATE INDEX IF NOT EXISTS transaction_from_id ON "transaction" (from_id);
+CREATE INDEX IF NOT EXISTS transaction_refund_of ON "transaction" (refund_of);
+
+CREATE TABLE IF NOT EXISTS overdraft_history
+(
+    id      INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER   NOT NULL REFERENCES users (id),
+    event   TEXT      NOT NULL,
+    balance REAL      NOT NULL,
+    created TIMESTAMP NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS currency_rates
+(
+    day      TIMESTAMP NOT NULL,
+    currency TEXT      NOT NULL,
+    rate     REAL      NOT NULL,
+    PRIMARY KEY (currency, day)
+);
+`
+
+// SQLiteStorage keeps the ledger in an SQLite database, for local runs and
+// single instances. The operations run one at a time on the only connection
+// of the pool, so a transaction never waits for a lock of another one.
+type SQLiteStorage struct {
+	DB    *sql.DB
+	Clock func() time.Time
+	// MaxRateAge is how much older than the asked day a stored rate may be,
+	// 0 takes the last rate however old.
+	MaxRateAge time.Duration
+}
+
+// NewSQLiteStorage creates the tables in db unless they exist. db is opened
+// by the caller with an SQLite driver, it is left with one connection.
+func NewSQLiteStorage(db *sql.DB) (*SQLiteStorage, error) {
+	db.SetMaxOpenConns(1)
+
+	s := &SQLiteStorage{
+		DB:         db,
+		Clock:      time.Now,
+		MaxRateAge: DefaultMaxRateAge,
+	}
+
+	_, err := db.Exec(sqliteSchema)
+	if err != nil {
+		return nil, err
+	}
+	_, err = db.Exec("INSERT INTO users (id, external_ref, created_at) VALUES (?, 'system:revenue', ?) "+
+		"ON CONFLICT DO NOTHING", RevenueAccountID, s.now())
+	if err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func (s *SQLiteStorage) now() time.Time {
+	return s.Clock().UTC().Truncate(time.Microsecond)
+}
+
+// update runs the operation in a transaction, committed unless it fails.
+func (s *SQLiteStorage) update(ctx context.Context, operation func(db TransactionInterface) error) error {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	err = operation(withContext(ctx, tx))
+	if err != nil {
+		//nolint:errcheck
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *SQLiteStorage) account(userID int, db TransactionInterface) (*Account, error) {
+	return scanAccount(db.QueryRow("SELECT "+accountColumns+" FROM users WHERE id = ?", userID))
+}
+
+func (s *SQLiteStorage) createAccount(userID int, externalRef *string, db TransactionInterface) (*Account, error) {
+	_, err := db.Exec("INSERT INTO users (id, external_ref, created_at) VALUES (?, ?, ?)", userID, externalRef, s.now())
+	if err != nil {
+		return nil, err
+	}
+	return s.account(userID, db)
+}
+
+func (s *SQLiteStorage) changeBalance(acc *Account, money float64, db TransactionInterface) error {
+	before := acc.Balance
+	acc.Balance += money
+
+	_, err := db.Exec("UPDATE users SET balance = ? WHERE id = ?", acc.Balance, acc.ID)
+	if err != nil {
+		return err
+	}
+
+	var event string
+	switch {
+	case before >= 0 && acc.Balance < 0:
+		event = OverdraftEntered
+	case before < 0 && acc.Balance >= 0:
+		event = OverdraftLeft
+	default:
+		return nil
+	}
+
+	_, err = db.Exec("INSERT INTO overdraft_history (user_id, event, balance, created) VALUES (?, ?, ?, ?)",
+		acc.ID, event, acc.Balance, s.now())
+	return err
+}
+
+func (s *SQLiteStorage) credit(userID int, money float64, db TransactionInterface) (float64, error) {
+	if money < 0 {
+		return 0, ErrNegativeAmount
+	}
+
+	// the first deposit still opens an account implicitly
+	acc, err := s.account(userID, db)
+	if err == ErrAccountNotFound {
+		acc, err = s.createAccount(userID, nil, db)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	err = acc.canCredit()
+	if err != nil {
+		return 0, err
+	}
+
+	err = s.changeBalance(acc, money, db)
+	if err != nil {
+		return 0, err
+	}
+	return acc.Balance, nil
+}
+
+func (s *SQLiteStorage) debit(userID int, money float64, db TransactionInterface) (float64, error) {
+	if money < 0 {
+		return 0, ErrNegativeAmount
+	}
+
+	acc, err := s.account(userID, db)
+	if err != nil {
+		return 0, err
+	}
+
+	err = acc.canDebit()
+	if err != nil {
+		return 0, err
+	}
+
+	if acc.Balance+acc.CreditLimit < money {
+		return 0, ErrNotEnoughMoney
+	}
+
+	err = s.changeBalance(acc, -money, db)
+	if err != nil {
+		return 0, err
+	}
+	return acc.Balance, nil
+}
+
+func (s *SQLiteStorage) write(toID, fromID *int, money float64, refundOf *int, balance float64, db TransactionInterface) (*Transaction, error) {
+	tr := &Transaction{
+		ToID:     toID,
+		FromID:   fromID,
+		Money:    money,
+		Created:  s.now(),
+		RefundOf: refundOf,
+		Balance:  &balance,
+	}
+
+	res, err := db.Exec("INSERT INTO \"transaction\" (to_id, from_id, money, created, refund_of) VALUES (?, ?, ?, ?, ?)",
+		tr.ToID, tr.FromID, tr.Money, tr.Created, tr.RefundOf)
+	if err != nil {
+		return nil, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+	tr.ID = int(id)
+
+	return tr, nil
+}
+
+func (s *SQLiteStorage) GetUsersBalance(ctx context.Context, userID int, currency string) (*User, error) {
+	tr := &User{UserID: userID}
+	var creditLimit float64
+	err := s.DB.QueryRowContext(ctx, "SELECT balance, credit_limit FROM users WHERE id = ?", userID).
+		Scan(&tr.Balance, &creditLimit)
+	if err == sql.ErrNoRows {
+		return nil, ErrAccountNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// today's rate unless another date is asked for
+	day, ok := RateDate(ctx)
+	if !ok {
+		day = rateDay(s.now())
+	}
+	return convertBalance(ctx, tr, creditLimit, currency, func(ctx context.Context, currency string) (float64, error) {
+		return s.rateAt(ctx, currency, day)
+	})
+}
+
+// SaveRates stores the rates, the ones of a stored day and currency replace
+// it. Nothing is stored when one of them is bad.
+func (s *SQLiteStorage) SaveRates(ctx context.Context, rates []*Rate) (*RateImport, error) {
+	if len(rates) == 0 {
+		return nil, ErrBadRates
+	}
+	for _, rate := range rates {
+		err := rate.validate()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err := s.update(ctx, func(db TransactionInterface) error {
+		for _, rate := range rates {
+			_, err := db.Exec("INSERT INTO currency_rates (day, currency, rate) VALUES (?, ?, ?) "+
+				"ON CONFLICT (currency, day) DO UPDATE SET rate = excluded.rate", rateDay(rate.Day), rate.Currency, rate.Rate)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &RateImport{Imported: len(rates)}, nil
+}
+
+// rateAt returns the rate of the currency on day or the last one stored before
+// it, at most MaxRateAge older. There are no live rates, only the stored ones.
+func (s *SQLiteStorage) rateAt(ctx context.Context, currency string, day time.Time) (float64, error) {
+	var stored time.Time
+	var rate float64
+	err := s.DB.QueryRowContext(ctx, "SELECT day, rate FROM currency_rates WHERE currency = ? AND day <= ? "+
+		"ORDER BY day DESC LIMIT 1", currency, day).Scan(&stored, &rate)
+	if err == sql.ErrNoRows || (err == nil && tooOld(stored, day, s.MaxRateAge)) {
+		return 0, ErrRateNotFound
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	return rate, nil
+}
+
+// inRoubles converts the money asked in the currency of the operation to RUB
+// at today's stored rate.
+func (s *SQLiteStorage) inRoubles(ctx context.Context, money float64) (float64, error) {
+	code := OperationCurrency(ctx)
+	if code == "" || code == BaseCurrency {
+		return money, nil
+	}
+
+	rate, err := s.rateAt(ctx, code, rateDay(s.now()))
+	if err != nil {
+		return 0, err
+	}
+
+	return math.Round(money*rate*100) / 100, nil
+}
+
+func (s *SQLiteStorage) AddMoney(ctx context.Context, userID int, money float64) (*Transaction, error) {
+	var tr *Transaction
+	err := s.update(ctx, func(db TransactionInterface) error {
+		balance, err := s.credit(userID, money, db)
+		if err != nil {
+			return err
+		}
+
+		tr, err = s.write(&userID, nil, money, nil, balance, db)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return tr, nil
+}
+
+func (s *SQLiteStorage) WithdrawMoney(ctx context.Context, userID int, money float64) (*Transaction, error) {
+	money, err := s.inRoubles(ctx, money)
+	if err != nil {
+		return nil, err
+	}
+
+	var tr *Transaction
+	err = s.update(ctx, func(db TransactionInterface) error {
+		balance, err := s.debit(userID, money, db)
+		if err != nil {
+			return err
+		}
+
+		tr, err = s.write(nil, &userID, -money, nil, balance, db)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return tr, nil
+}
+
+func (s *SQLiteStorage) TransferMoney(ctx context.Context, fromUserID int, toUserID int, money float64) (*Transaction, error) {
+	money, err := s.inRoubles(ctx, money)
+	if err != nil {
+		return nil, err
+	}
+
+	var tr *Transaction
+	err = s.update(ctx, func(db TransactionInterface) error {
+		balance, err := s.debit(fromUserID, money, db)
+		if err != nil {
+			return err
+		}
+
+		_, err = s.credit(toUserID, money, db)
+		if err != nil {
+			return err
+		}
+
+		tr, err = s.write(&toUserID, &fromUserID, money, nil, balance, db)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return tr, nil
+}
+
+// RefundMoney returns money of a withdrawal or a transfer back to the payer.
+// Zero money refunds everything that is not refunded yet.
+func (s *SQLiteStorage) RefundMoney(ctx context.Context, transactionID int, money float64) (*Transaction, error) {
+	if money < 0 {
+		return nil, ErrNegativeAmount
+	}
+
+	var tr *Transaction
+	err := s.update(ctx, func(db TransactionInterface) error {
+		orig, err := s.transaction(transactionID, db)
+		if err != nil {
+			return err
+		}
+
+		// deposits have no payer and refunds are not refunded again
+		if orig.FromID == nil || orig.RefundOf != nil {
+			return ErrNotRefundable
+		}
+
+		var refunded float64
+		err = db.QueryRow("SELECT COALESCE(SUM(money), 0) FROM \"transaction\" WHERE refund_of = ?", transactionID).
+			Scan(&refunded)
+		if err != nil {
+			return err
+		}
+
+		// withdrawals are stored with negative money
+		charged := math.Abs(orig.Money)
+		if money == 0 {
+			money = charged - refunded
+		}
+
+		if money <= 0 || refunded+money > charged {
+			return ErrRefundExceeded
+		}
+
+		if orig.ToID != nil {
+			_, err = s.debit(*orig.ToID, money, db)
+			if err != nil {
+				return err
+			}
+		}
+
+		balance, err := s.credit(*orig.FromID, money, db)
+		if err != nil {
+			return err
+		}
+
+		tr, err = s.write(orig.FromID, orig.ToID, money, &transactionID, balance, db)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return tr, nil
+}
+
+func (s *SQLiteStorage) transaction(transactionID int, db TransactionInterface) (*Transaction, error) {
+	tr := &Transaction{}
+	err := db.QueryRow("SELECT id, to_id, from_id, money, created, refund_of FROM \"transaction\" WHERE id = ?", transactionID).
+		Scan(&tr.ID, &tr.ToID, &tr.FromID, &tr.Money, &tr.Created, &tr.RefundOf)
+	if err == sql.ErrNoRows {
+		return nil, ErrTransactionNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	tr.Created = tr.Created.UTC()
+
+	return tr, nil
+}
+
+func (s *SQLiteStorage) GetTransaction(ctx context.Context, userID int, orderBy string) ([]*Transaction, error) {
+	rows, err := s.DB.QueryContext(ctx, "SELECT id, to_id, from_id, money, created, refund_of FROM \"transaction\" "+
+		"WHERE to_id = ? OR from_id = ? ORDER BY id", userID, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	info := make([]*Transaction, 0, 10)
+	for rows.Next() {
+		curr := &Transaction{}
+		err = rows.Scan(&curr.ID, &curr.ToID, &curr.FromID, &curr.Money, &curr.Created, &curr.RefundOf)
+		if err != nil {
+			return nil, err
+		}
+		curr.Created = curr.Created.UTC()
+		info = append(info, curr)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return sortHistory(info, orderBy)
+}
+
+func (s *SQLiteStorage) GetTransactionByID(ctx context.Context, transactionID int) (*Transaction, error) {
+	return s.transaction(transactionID, withContext(ctx, s.DB))
+}
+
+func (s *SQLiteStorage) CreateAccount(ctx context.Context, userID int, externalRef string) (*Account, error) {
+	if userID <= 0 {
+		return nil, ErrBadAccountID
+	}
+
+	var ref *string
+	if externalRef != "" {
+		ref = &externalRef
+	}
+
+	var acc *Account
+	err := s.update(ctx, func(db TransactionInterface) error {
+		var taken bool
+		err := db.QueryRow("SELECT EXISTS (SELECT 1 FROM users WHERE id = ? OR external_ref = ?)", userID, ref).Scan(&taken)
+		if err != nil {
+			return err
+		}
+		if taken {
+			return ErrAccountExists
+		}
+
+		acc, err = s.createAccount(userID, ref, db)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return acc, nil
+}
+
+func (s *SQLiteStorage) GetAccount(ctx context.Context, userID int) (*Account, error) {
+	return s.account(userID, withContext(ctx, s.DB))
+}
+
+// FreezeAccount blocks debits and/or credits, both are blocked when none is chosen.
+func (s *SQLiteStorage) FreezeAccount(ctx context.Context, userID int, debit, credit bool) (*Account, error) {
+	if !debit && !credit {
+		debit, credit = true, true
+	}
+
+	return s.updateAccount(ctx, userID, func(acc *Account) error {
+		acc.setBlocks(acc.DebitBlocked || debit, acc.CreditBlocked || credit)
+		return nil
+	})
+}
+
+// UnfreezeAccount lifts the chosen blocks, both are lifted when none is chosen.
+func (s *SQLiteStorage) UnfreezeAccount(ctx context.Context, userID int, debit, credit bool) (*Account, error) {
+	if !debit && !credit {
+		debit, credit = true, true
+	}
+
+	return s.updateAccount(ctx, userID, func(acc *Account) error {
+		acc.setBlocks(acc.DebitBlocked && !debit, acc.CreditBlocked && !credit)
+		return nil
+	})
+}
+
+func (s *SQLiteStorage) CloseAccount(ctx context.Context, userID int) (*Account, error) {
+	return s.updateAccount(ctx, userID, func(acc *Account) error {
+		if math.Abs(acc.Balance) >= reconcileTolerance {
+			return ErrAccountNotEmpty
+		}
+
+		closedAt := s.now()
+		acc.Status, acc.ClosedAt = AccountClosed, &closedAt
+		return nil
+	})
+}
+
+// SetCreditLimit lets the account be debited down to -limit. A lower limit
+// does not touch a balance that is already below it, only new debits fail.
+func (s *SQLiteStorage) SetCreditLimit(ctx context.Context, userID int, limit float64) (*Account, error) {
+	if limit < 0 {
+		return nil, ErrNegativeAmount
+	}
+
+	return s.updateAccount(ctx, userID, func(acc *Account) error {
+		acc.CreditLimit = limit
+		return nil
+	})
+}
+
+func (s *SQLiteStorage) GetOverdraftHistory(ctx context.Context, userID int) ([]*OverdraftEvent, error) {
+	rows, err := s.DB.QueryContext(ctx, "SELECT id, user_id, event, balance, created FROM overdraft_history "+
+		"WHERE user_id = ? ORDER BY id", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := make([]*OverdraftEvent, 0)
+	for rows.Next() {
+		curr := &OverdraftEvent{}
+		err = rows.Scan(&curr.ID, &curr.UserID, &curr.Event, &curr.Balance, &curr.Created)
+		if err != nil {
+			return nil, err
+		}
+		curr.Created = curr.Created.UTC()
+		events = append(events, curr)
+	}
+
+	return events, rows.Err()
+}
+
+// updateAccount changes an open account, closed accounts stay as they are.
+func (s *SQLiteStorage) updateAccount(ctx context.Context, userID int, update func(acc *Account) error) (*Account, error) {
+	var acc *Account
+	err := s.update(ctx, func(db TransactionInterface) error {
+		var err error
+		acc, err = s.account(userID, db)
+		if err != nil {
+			return err
+		}
+		if acc.Status == AccountClosed {
+			return ErrAccountClosed
+		}
+
+		err = update(acc)
+		if err != nil {
+			return err
+		}
+
+		_, err = db.Exec("UPDATE users SET status = ?, debit_blocked = ?, credit_blocked = ?, credit_limit = ?, closed_at = ? "+
+			"WHERE id = ?", acc.Status, acc.DebitBlocked, acc.CreditBlocked, acc.CreditLimit, acc.ClosedAt, acc.ID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return acc, nil
+}
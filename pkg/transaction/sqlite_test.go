@@ -0,0 +1,30 @@
+package transaction_test
+
+import (
+	"autumn-2021-intern-assignment/pkg/transaction"
+	"autumn-2021-intern-assignment/pkg/transaction/storagetest"
+	"database/sql"
+	_ "github.com/mattn/go-sqlite3"
+	"path/filepath"
+	"testing"
+)
+
+// TestSQLiteStorage runs the suite against a database file of its own for
+// every subtest.
+func TestSQLiteStorage(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) transaction.Storage {
+		db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "ledger.db")+"?_foreign_keys=on")
+		if err != nil {
+			t.Fatalf("cant open the database: %s", err)
+		}
+		t.Cleanup(func() {
+			db.Close()
+		})
+
+		st, err := transaction.NewSQLiteStorage(db)
+		if err != nil {
+			t.Fatalf("cant create the tables: %s", err)
+		}
+		return st
+	})
+}
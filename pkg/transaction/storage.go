@@ -0,0 +1,39 @@
+package transaction
+
+import "context"
+
+// Storage is the ledger: balances, money operations with their history and
+// the life of accounts. RepositoryItem keeps it in Postgres, SQLiteStorage in
+// SQLite and MemoryStorage in memory, all of them pass the conformance suite
+// of the storagetest package.
+// Every operation is atomic: a refused one leaves nothing behind, and so does
+// one whose context ends before it is done.
+//
+// Fees, limits, schedules, splits, reports and the live rates are Postgres
+// only. A Postgres storage without fee rules and limits behaves like the
+// other ones.
+type Storage interface {
+	GetUsersBalance(ctx context.Context, userID int, currency string) (*User, error)
+	AddMoney(ctx context.Context, userID int, money float64) (*Transaction, error)
+	WithdrawMoney(ctx context.Context, userID int, money float64) (*Transaction, error)
+	TransferMoney(ctx context.Context, fromUserID int, toUserID int, money float64) (*Transaction, error)
+	RefundMoney(ctx context.Context, transactionID int, money float64) (*Transaction, error)
+	GetTransaction(ctx context.Context, userID int, orderBy string) ([]*Transaction, error)
+	GetTransactionByID(ctx context.Context, transactionID int) (*Transaction, error)
+
+	CreateAccount(ctx context.Context, userID int, externalRef string) (*Account, error)
+	GetAccount(ctx context.Context, userID int) (*Account, error)
+	FreezeAccount(ctx context.Context, userID int, debit, credit bool) (*Account, error)
+	UnfreezeAccount(ctx context.Context, userID int, debit, credit bool) (*Account, error)
+	CloseAccount(ctx context.Context, userID int) (*Account, error)
+	SetCreditLimit(ctx context.Context, userID int, limit float64) (*Account, error)
+	GetOverdraftHistory(ctx context.Context, userID int) ([]*OverdraftEvent, error)
+
+	SaveRates(ctx context.Context, rates []*Rate) (*RateImport, error)
+}
+
+var (
+	_ Storage = (*RepositoryItem)(nil)
+	_ Storage = (*MemoryStorage)(nil)
+	_ Storage = (*SQLiteStorage)(nil)
+)
@@ -0,0 +1,500 @@
+// Package storagetest is the conformance suite of transaction.Storage: every
+// backend has to pass it. Sums are exact in float32, the Postgres storage
+// keeps balances as REAL.
+package storagetest
+
+import (
+	"autumn-2021-intern-assignment/pkg/transaction"
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Run runs the suite, newStorage has to return an empty storage on every call.
+func Run(t *testing.T, newStorage func(t *testing.T) transaction.Storage) {
+	for _, c := range []struct {
+		name string
+		test func(t *testing.T, st transaction.Storage)
+	}{
+		{"Deposit", testDeposit},
+		{"Withdraw", testWithdraw},
+		{"Transfer", testTransfer},
+		{"Refund", testRefund},
+		{"History", testHistory},
+		{"Accounts", testAccounts},
+		{"Freeze", testFreeze},
+		{"CreditLimit", testCreditLimit},
+		{"Concurrency", testConcurrency},
+		{"Cancel", testCancel},
+		{"Rates", testRates},
+	} {
+		test := c.test
+		t.Run(c.name, func(t *testing.T) {
+			test(t, newStorage(t))
+		})
+	}
+}
+
+var ctx = context.Background()
+
+func checkBalance(t *testing.T, st transaction.Storage, userID int, want float64) {
+	t.Helper()
+	user, err := st.GetUsersBalance(ctx, userID, "")
+	if err != nil {
+		t.Fatalf("balance of %d: unexpected err: %s", userID, err)
+	}
+	if user.Balance != want {
+		t.Fatalf("balance of %d: want %v, have %v", userID, want, user.Balance)
+	}
+}
+
+func checkErr(t *testing.T, what string, err, want error) {
+	t.Helper()
+	if !errors.Is(err, want) {
+		t.Fatalf("%s: want %v, have %v", what, want, err)
+	}
+}
+
+func checkHistoryLen(t *testing.T, st transaction.Storage, userID, want int) {
+	t.Helper()
+	history, err := st.GetTransaction(ctx, userID, "")
+	if err != nil {
+		t.Fatalf("history of %d: unexpected err: %s", userID, err)
+	}
+	if len(history) != want {
+		t.Fatalf("history of %d: want %d operations, have %d", userID, want, len(history))
+	}
+}
+
+func deposit(t *testing.T, st transaction.Storage, userID int, money float64) *transaction.Transaction {
+	t.Helper()
+	tr, err := st.AddMoney(ctx, userID, money)
+	if err != nil {
+		t.Fatalf("deposit to %d: unexpected err: %s", userID, err)
+	}
+	return tr
+}
+
+func testDeposit(t *testing.T, st transaction.Storage) {
+	_, err := st.GetUsersBalance(ctx, 1, "")
+	checkErr(t, "balance of an unknown account", err, transaction.ErrAccountNotFound)
+
+	// the first deposit opens the account
+	tr := deposit(t, st, 1, 100)
+	if tr.ID <= 0 || tr.ToID == nil || *tr.ToID != 1 || tr.FromID != nil || tr.Money != 100 ||
+		tr.Balance == nil || *tr.Balance != 100 || tr.Created.IsZero() || tr.Created.Location().String() != "UTC" {
+		t.Fatalf("unexpected deposit %+v", tr)
+	}
+	tr = deposit(t, st, 1, 25.5)
+	if *tr.Balance != 125.5 {
+		t.Fatalf("want balance 125.5, have %v", *tr.Balance)
+	}
+	checkBalance(t, st, 1, 125.5)
+
+	acc, err := st.GetAccount(ctx, 1)
+	if err != nil || acc.Status != transaction.AccountActive || acc.Balance != 125.5 {
+		t.Fatalf("unexpected account %+v, %v", acc, err)
+	}
+
+	user, err := st.GetUsersBalance(ctx, 1, "")
+	if err != nil || user.Available == nil || *user.Available != 125.5 {
+		t.Fatalf("unexpected balance %+v, %v", user, err)
+	}
+
+	_, err = st.AddMoney(ctx, 1, -1)
+	checkErr(t, "negative deposit", err, transaction.ErrNegativeAmount)
+	checkBalance(t, st, 1, 125.5)
+	checkHistoryLen(t, st, 1, 2)
+}
+
+func testWithdraw(t *testing.T, st transaction.Storage) {
+	_, err := st.WithdrawMoney(ctx, 1, 10)
+	checkErr(t, "withdraw from an unknown account", err, transaction.ErrAccountNotFound)
+
+	deposit(t, st, 1, 100)
+	tr, err := st.WithdrawMoney(ctx, 1, 30)
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+	if tr.FromID == nil || *tr.FromID != 1 || tr.ToID != nil || tr.Money != -30 || *tr.Balance != 70 {
+		t.Fatalf("unexpected withdrawal %+v", tr)
+	}
+
+	_, err = st.WithdrawMoney(ctx, 1, 70.25)
+	checkErr(t, "withdraw over the balance", err, transaction.ErrNotEnoughMoney)
+	_, err = st.WithdrawMoney(ctx, 1, -1)
+	checkErr(t, "negative withdraw", err, transaction.ErrNegativeAmount)
+
+	checkBalance(t, st, 1, 70)
+	checkHistoryLen(t, st, 1, 2)
+
+	_, err = st.WithdrawMoney(ctx, 1, 70)
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+	checkBalance(t, st, 1, 0)
+}
+
+func testTransfer(t *testing.T, st transaction.Storage) {
+	deposit(t, st, 1, 100)
+
+	// the recipient is opened like with a deposit
+	tr, err := st.TransferMoney(ctx, 1, 2, 40)
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+	if *tr.FromID != 1 || *tr.ToID != 2 || tr.Money != 40 || *tr.Balance != 60 {
+		t.Fatalf("unexpected transfer %+v", tr)
+	}
+	checkBalance(t, st, 1, 60)
+	checkBalance(t, st, 2, 40)
+
+	_, err = st.TransferMoney(ctx, 1, 2, 60.5)
+	checkErr(t, "transfer over the balance", err, transaction.ErrNotEnoughMoney)
+	_, err = st.TransferMoney(ctx, 1, 2, -1)
+	checkErr(t, "negative transfer", err, transaction.ErrNegativeAmount)
+	_, err = st.TransferMoney(ctx, 3, 1, 1)
+	checkErr(t, "transfer from an unknown account", err, transaction.ErrAccountNotFound)
+
+	// the payer is debited first, the refused credit has to roll it back
+	_, err = st.FreezeAccount(ctx, 2, false, true)
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+	_, err = st.TransferMoney(ctx, 1, 2, 10)
+	checkErr(t, "transfer to a frozen account", err, transaction.ErrAccountFrozen)
+
+	checkBalance(t, st, 1, 60)
+	checkBalance(t, st, 2, 40)
+	checkHistoryLen(t, st, 1, 2)
+	checkHistoryLen(t, st, 2, 1)
+}
+
+func testRefund(t *testing.T, st transaction.Storage) {
+	dep := deposit(t, st, 1, 100)
+	withdrawal, err := st.WithdrawMoney(ctx, 1, 30)
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+	transfer, err := st.TransferMoney(ctx, 1, 2, 50)
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+
+	_, err = st.RefundMoney(ctx, dep.ID, 0)
+	checkErr(t, "refund of a deposit", err, transaction.ErrNotRefundable)
+	_, err = st.RefundMoney(ctx, transfer.ID+100, 0)
+	checkErr(t, "refund of an unknown operation", err, transaction.ErrTransactionNotFound)
+	_, err = st.RefundMoney(ctx, transfer.ID, -1)
+	checkErr(t, "negative refund", err, transaction.ErrNegativeAmount)
+
+	// partly, then the rest
+	tr, err := st.RefundMoney(ctx, withdrawal.ID, 10)
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+	if *tr.ToID != 1 || tr.FromID != nil || tr.Money != 10 || *tr.RefundOf != withdrawal.ID || *tr.Balance != 30 {
+		t.Fatalf("unexpected refund %+v", tr)
+	}
+	_, err = st.RefundMoney(ctx, withdrawal.ID, 20.5)
+	checkErr(t, "refund over the charged money", err, transaction.ErrRefundExceeded)
+	tr, err = st.RefundMoney(ctx, withdrawal.ID, 0)
+	if err != nil || tr.Money != 20 {
+		t.Fatalf("unexpected refund %+v, %v", tr, err)
+	}
+	_, err = st.RefundMoney(ctx, withdrawal.ID, 0)
+	checkErr(t, "refund of a refunded operation", err, transaction.ErrRefundExceeded)
+	_, err = st.RefundMoney(ctx, tr.ID, 0)
+	checkErr(t, "refund of a refund", err, transaction.ErrNotRefundable)
+
+	// a transfer is taken back from the recipient
+	_, err = st.WithdrawMoney(ctx, 2, 45)
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+	_, err = st.RefundMoney(ctx, transfer.ID, 0)
+	checkErr(t, "refund over the balance of the recipient", err, transaction.ErrNotEnoughMoney)
+	checkBalance(t, st, 1, 50)
+
+	tr, err = st.RefundMoney(ctx, transfer.ID, 5)
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+	if *tr.ToID != 1 || *tr.FromID != 2 || *tr.Balance != 55 {
+		t.Fatalf("unexpected refund %+v", tr)
+	}
+	checkBalance(t, st, 1, 55)
+	checkBalance(t, st, 2, 0)
+}
+
+func testHistory(t *testing.T, st transaction.Storage) {
+	deposit(t, st, 1, 100)
+	deposit(t, st, 2, 1)
+	_, err := st.TransferMoney(ctx, 1, 2, 50)
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+	deposit(t, st, 1, 20)
+
+	history, err := st.GetTransaction(ctx, 1, "")
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+	if len(history) != 3 {
+		t.Fatalf("want 3 operations, have %d", len(history))
+	}
+	for i, tr := range history {
+		if i > 0 && tr.ID <= history[i-1].ID {
+			t.Fatalf("history is not ordered by id: %d after %d", tr.ID, history[i-1].ID)
+		}
+		if tr.Balance != nil {
+			t.Fatalf("history has balances: %+v", tr)
+		}
+
+		byID, err := st.GetTransactionByID(ctx, tr.ID)
+		if err != nil {
+			t.Fatalf("unexpected err: %s", err)
+		}
+		if byID.ID != tr.ID || byID.Money != tr.Money || !byID.Created.Equal(tr.Created) {
+			t.Fatalf("operation %d: want %+v, have %+v", tr.ID, tr, byID)
+		}
+	}
+
+	byMoney, err := st.GetTransaction(ctx, 1, "money")
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+	if byMoney[0].Money != 20 || byMoney[1].Money != 50 || byMoney[2].Money != 100 {
+		t.Fatalf("history is not ordered by money: %v, %v, %v", byMoney[0].Money, byMoney[1].Money, byMoney[2].Money)
+	}
+
+	byDate, err := st.GetTransaction(ctx, 1, "date")
+	if err != nil || len(byDate) != 3 {
+		t.Fatalf("unexpected history %v, %v", byDate, err)
+	}
+
+	_, err = st.GetTransaction(ctx, 1, "color")
+	if err == nil {
+		t.Fatalf("expected error for a bad order")
+	}
+
+	_, err = st.GetTransactionByID(ctx, history[2].ID+100)
+	checkErr(t, "unknown operation", err, transaction.ErrTransactionNotFound)
+
+	history, err = st.GetTransaction(ctx, 3, "")
+	if err != nil || len(history) != 0 {
+		t.Fatalf("want no history, have %v, %v", history, err)
+	}
+}
+
+func testAccounts(t *testing.T, st transaction.Storage) {
+	acc, err := st.CreateAccount(ctx, 1, "crm-1")
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+	if acc.ID != 1 || acc.ExternalRef == nil || *acc.ExternalRef != "crm-1" || acc.Status != transaction.AccountActive ||
+		acc.Balance != 0 || acc.CreatedAt.IsZero() || acc.ClosedAt != nil ||
+		len(acc.Currencies) != 1 || acc.Currencies[0] != transaction.BaseCurrency {
+		t.Fatalf("unexpected account %+v", acc)
+	}
+
+	_, err = st.CreateAccount(ctx, 1, "")
+	checkErr(t, "taken id", err, transaction.ErrAccountExists)
+	_, err = st.CreateAccount(ctx, 2, "crm-1")
+	checkErr(t, "taken reference", err, transaction.ErrAccountExists)
+	_, err = st.CreateAccount(ctx, 0, "")
+	checkErr(t, "bad id", err, transaction.ErrBadAccountID)
+	_, err = st.GetAccount(ctx, 2)
+	checkErr(t, "unknown account", err, transaction.ErrAccountNotFound)
+
+	got, err := st.GetAccount(ctx, 1)
+	if err != nil || got.ID != 1 || *got.ExternalRef != "crm-1" || !got.CreatedAt.Equal(acc.CreatedAt) {
+		t.Fatalf("unexpected account %+v, %v", got, err)
+	}
+
+	deposit(t, st, 1, 10)
+	_, err = st.CloseAccount(ctx, 1)
+	checkErr(t, "close with money", err, transaction.ErrAccountNotEmpty)
+	_, err = st.WithdrawMoney(ctx, 1, 10)
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+
+	acc, err = st.CloseAccount(ctx, 1)
+	if err != nil || acc.Status != transaction.AccountClosed || acc.ClosedAt == nil {
+		t.Fatalf("unexpected account %+v, %v", acc, err)
+	}
+
+	_, err = st.AddMoney(ctx, 1, 10)
+	checkErr(t, "deposit to a closed account", err, transaction.ErrAccountClosed)
+	_, err = st.FreezeAccount(ctx, 1, true, false)
+	checkErr(t, "freeze of a closed account", err, transaction.ErrAccountClosed)
+	_, err = st.CloseAccount(ctx, 1)
+	checkErr(t, "close of a closed account", err, transaction.ErrAccountClosed)
+	_, err = st.CloseAccount(ctx, 2)
+	checkErr(t, "close of an unknown account", err, transaction.ErrAccountNotFound)
+}
+
+func testFreeze(t *testing.T, st transaction.Storage) {
+	deposit(t, st, 1, 100)
+
+	acc, err := st.FreezeAccount(ctx, 1, true, false)
+	if err != nil || acc.Status != transaction.AccountFrozen || !acc.DebitBlocked || acc.CreditBlocked {
+		t.Fatalf("unexpected account %+v, %v", acc, err)
+	}
+	_, err = st.WithdrawMoney(ctx, 1, 10)
+	checkErr(t, "debit of a frozen account", err, transaction.ErrAccountFrozen)
+	deposit(t, st, 1, 10)
+
+	// nothing chosen means both
+	acc, err = st.FreezeAccount(ctx, 1, false, false)
+	if err != nil || !acc.DebitBlocked || !acc.CreditBlocked {
+		t.Fatalf("unexpected account %+v, %v", acc, err)
+	}
+	_, err = st.AddMoney(ctx, 1, 10)
+	checkErr(t, "credit of a frozen account", err, transaction.ErrAccountFrozen)
+
+	acc, err = st.UnfreezeAccount(ctx, 1, true, false)
+	if err != nil || acc.Status != transaction.AccountFrozen || acc.DebitBlocked || !acc.CreditBlocked {
+		t.Fatalf("unexpected account %+v, %v", acc, err)
+	}
+	acc, err = st.UnfreezeAccount(ctx, 1, false, false)
+	if err != nil || acc.Status != transaction.AccountActive || acc.DebitBlocked || acc.CreditBlocked {
+		t.Fatalf("unexpected account %+v, %v", acc, err)
+	}
+	checkBalance(t, st, 1, 110)
+
+	_, err = st.FreezeAccount(ctx, 2, true, true)
+	checkErr(t, "freeze of an unknown account", err, transaction.ErrAccountNotFound)
+}
+
+func testCreditLimit(t *testing.T, st transaction.Storage) {
+	deposit(t, st, 1, 10)
+
+	_, err := st.SetCreditLimit(ctx, 1, -1)
+	checkErr(t, "negative limit", err, transaction.ErrNegativeAmount)
+	_, err = st.SetCreditLimit(ctx, 2, 10)
+	checkErr(t, "limit of an unknown account", err, transaction.ErrAccountNotFound)
+
+	acc, err := st.SetCreditLimit(ctx, 1, 50)
+	if err != nil || acc.CreditLimit != 50 {
+		t.Fatalf("unexpected account %+v, %v", acc, err)
+	}
+
+	user, err := st.GetUsersBalance(ctx, 1, "")
+	if err != nil || user.Balance != 10 || *user.Available != 60 {
+		t.Fatalf("unexpected balance %+v, %v", user, err)
+	}
+
+	_, err = st.WithdrawMoney(ctx, 1, 60.5)
+	checkErr(t, "withdraw over the limit", err, transaction.ErrNotEnoughMoney)
+	tr, err := st.WithdrawMoney(ctx, 1, 40)
+	if err != nil || *tr.Balance != -30 {
+		t.Fatalf("unexpected withdrawal %+v, %v", tr, err)
+	}
+	deposit(t, st, 1, 5)
+	deposit(t, st, 1, 25)
+
+	events, err := st.GetOverdraftHistory(ctx, 1)
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+	if len(events) != 2 || events[0].Event != transaction.OverdraftEntered || events[0].Balance != -30 ||
+		events[1].Event != transaction.OverdraftLeft || events[1].Balance != 0 || events[0].UserID != 1 {
+		t.Fatalf("unexpected overdraft history %+v", events)
+	}
+
+	events, err = st.GetOverdraftHistory(ctx, 2)
+	if err != nil || len(events) != 0 {
+		t.Fatalf("want no events, have %v, %v", events, err)
+	}
+}
+
+func testConcurrency(t *testing.T, st transaction.Storage) {
+	deposit(t, st, 1, 50)
+
+	// no withdrawal may see a balance another one has already spent
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	succeeded := 0
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := st.TransferMoney(ctx, 1, 2, 1)
+			if err == nil {
+				mu.Lock()
+				succeeded++
+				mu.Unlock()
+			} else if !errors.Is(err, transaction.ErrNotEnoughMoney) {
+				t.Errorf("unexpected err: %s", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if succeeded != 50 {
+		t.Fatalf("want 50 transfers, have %d", succeeded)
+	}
+	checkBalance(t, st, 1, 0)
+	checkBalance(t, st, 2, 50)
+}
+
+func testCancel(t *testing.T, st transaction.Storage) {
+	deposit(t, st, 1, 50)
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+
+	_, err := st.TransferMoney(canceled, 1, 2, 10)
+	checkErr(t, "canceled transfer", err, context.Canceled)
+	_, err = st.GetUsersBalance(canceled, 1, "")
+	checkErr(t, "canceled read", err, context.Canceled)
+
+	checkBalance(t, st, 1, 50)
+	checkHistoryLen(t, st, 1, 1)
+	_, err = st.GetAccount(ctx, 2)
+	checkErr(t, "recipient of a canceled transfer", err, transaction.ErrAccountNotFound)
+}
+
+func testRates(t *testing.T, st transaction.Storage) {
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	_, err := st.SaveRates(ctx, []*transaction.Rate{
+		{Day: today, Currency: "USD", Rate: 75},
+		{Day: today.AddDate(0, 0, -1), Currency: "USD", Rate: 50},
+		{Day: today.AddDate(0, 0, -30), Currency: "GBP", Rate: 100},
+	})
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+	_, err = st.SaveRates(ctx, []*transaction.Rate{{Day: today, Currency: "RUB", Rate: 1}})
+	checkErr(t, "rate of the base currency", err, transaction.ErrBadRates)
+
+	// the balance is converted at the stored rate of the day
+	deposit(t, st, 1, 150)
+	user, err := st.GetUsersBalance(ctx, 1, "USD")
+	if err != nil || user.Balance != 2 {
+		t.Fatalf("unexpected balance %+v, %v", user, err)
+	}
+	user, err = st.GetUsersBalance(transaction.WithRateDate(ctx, today.AddDate(0, 0, -1)), 1, "USD")
+	if err != nil || user.Balance != 3 {
+		t.Fatalf("unexpected balance %+v, %v", user, err)
+	}
+
+	// a rate too old stands for no rate
+	_, err = st.GetUsersBalance(ctx, 1, "GBP")
+	checkErr(t, "balance at an old rate", err, transaction.ErrRateNotFound)
+	_, err = st.GetUsersBalance(ctx, 1, "EUR")
+	checkErr(t, "balance without a rate", err, transaction.ErrRateNotFound)
+
+	// money asked in a currency is debited in roubles
+	tr, err := st.WithdrawMoney(transaction.WithOperationCurrency(ctx, "USD"), 1, 1)
+	if err != nil || tr.Money != -75 || *tr.Balance != 75 {
+		t.Fatalf("unexpected withdrawal %+v, %v", tr, err)
+	}
+	_, err = st.TransferMoney(transaction.WithOperationCurrency(ctx, "EUR"), 1, 2, 1)
+	checkErr(t, "transfer without a rate", err, transaction.ErrRateNotFound)
+	checkBalance(t, st, 1, 75)
+}
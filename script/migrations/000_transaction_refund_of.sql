@@ -0,0 +1,9 @@
+-- Adds the link from a refund to the operation it returns. It comes before
+-- the other migrations: 012_transaction_partitions.sql copies the column.
+
+BEGIN;
+
+ALTER TABLE transaction
+    ADD COLUMN IF NOT EXISTS refund_of BIGINT REFERENCES transaction (ID);
+
+COMMIT;
@@ -0,0 +1,19 @@
+-- Moves transaction.created from TIMESTAMP to TIMESTAMPTZ.
+-- Old rows were written by the app in the container time zone, which is UTC.
+-- Their minutes hold the month and seconds are dropped ("2006-01-02 15:01"
+-- layout), so only the date and the hour of such rows are reliable. The real
+-- minutes are not stored anywhere and can't be recovered: the old rows are cut
+-- to the start of their hour instead of keeping the month as minutes. A row of
+-- the new code is told apart by its seconds, it only has none by chance.
+
+BEGIN;
+
+UPDATE transaction
+SET created = date_trunc('hour', created)
+WHERE date_part('second', created) = 0
+  AND date_part('minute', created) = date_part('month', created);
+
+ALTER TABLE transaction
+    ALTER COLUMN created TYPE TIMESTAMPTZ USING created AT TIME ZONE 'UTC';
+
+COMMIT;
@@ -0,0 +1,14 @@
+-- Adds account lifecycle fields to users.
+-- Existing users become active accounts opened at the time of the migration.
+
+BEGIN;
+
+ALTER TABLE users
+    ADD COLUMN IF NOT EXISTS external_ref   TEXT UNIQUE,
+    ADD COLUMN IF NOT EXISTS status         TEXT        NOT NULL DEFAULT 'active',
+    ADD COLUMN IF NOT EXISTS debit_blocked  BOOLEAN     NOT NULL DEFAULT FALSE,
+    ADD COLUMN IF NOT EXISTS credit_blocked BOOLEAN     NOT NULL DEFAULT FALSE,
+    ADD COLUMN IF NOT EXISTS created_at     TIMESTAMPTZ NOT NULL DEFAULT now(),
+    ADD COLUMN IF NOT EXISTS closed_at      TIMESTAMPTZ;
+
+COMMIT;
@@ -0,0 +1,17 @@
+-- Adds spending limits. user_id 0 holds the defaults for all accounts.
+-- The index keeps the daily, monthly and hourly sums of an account cheap.
+
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS limits
+(
+    user_id          BIGINT PRIMARY KEY,
+    max_operation    REAL,
+    daily_debit      REAL,
+    monthly_debit    REAL,
+    hourly_transfers INTEGER
+);
+
+CREATE INDEX IF NOT EXISTS transaction_from_id_created ON transaction (from_id, created);
+
+COMMIT;
@@ -0,0 +1,18 @@
+-- Adds per-account credit limits and the history of overdraft changes.
+-- Existing accounts get no credit.
+
+BEGIN;
+
+ALTER TABLE users
+    ADD COLUMN IF NOT EXISTS credit_limit REAL NOT NULL DEFAULT 0;
+
+CREATE TABLE IF NOT EXISTS overdraft_history
+(
+    ID      BIGSERIAL PRIMARY KEY,
+    user_id BIGINT      NOT NULL REFERENCES users (ID),
+    event   TEXT        NOT NULL,
+    balance REAL        NOT NULL,
+    created TIMESTAMPTZ NOT NULL
+);
+
+COMMIT;
@@ -0,0 +1,23 @@
+-- Adds fee rules, the link from a fee to its operation and the system
+-- revenue account (id 0) fees are booked to.
+
+BEGIN;
+
+ALTER TABLE transaction
+    ADD COLUMN IF NOT EXISTS fee_of BIGINT REFERENCES transaction (ID);
+
+CREATE TABLE IF NOT EXISTS fee_rules
+(
+    ID        BIGSERIAL PRIMARY KEY,
+    operation TEXT NOT NULL,
+    currency  TEXT NOT NULL DEFAULT 'RUB',
+    user_id   BIGINT REFERENCES users (ID),
+    fixed     REAL NOT NULL DEFAULT 0,
+    percent   REAL NOT NULL DEFAULT 0,
+    min_fee   REAL,
+    max_fee   REAL
+);
+
+INSERT INTO users (id, balance, external_ref) VALUES (0, 0, 'system:revenue') ON CONFLICT DO NOTHING;
+
+COMMIT;
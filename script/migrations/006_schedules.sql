@@ -0,0 +1,27 @@
+-- Adds scheduled and recurring payments.
+
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS schedules
+(
+    ID                  BIGSERIAL PRIMARY KEY,
+    from_id             BIGINT      NOT NULL REFERENCES users (ID),
+    to_id               BIGINT REFERENCES users (ID),
+    money               REAL        NOT NULL,
+    cron                TEXT        NOT NULL DEFAULT '',
+    interval_seconds    BIGINT      NOT NULL DEFAULT 0,
+    next_run            TIMESTAMPTZ NOT NULL,
+    status              TEXT        NOT NULL DEFAULT 'active',
+    attempts            INTEGER     NOT NULL DEFAULT 0,
+    max_attempts        INTEGER     NOT NULL DEFAULT 3,
+    retry_delay_seconds BIGINT      NOT NULL DEFAULT 3600,
+    last_error          TEXT,
+    last_transaction_id BIGINT REFERENCES transaction (ID),
+    locked_by           TEXT,
+    locked_until        TIMESTAMPTZ,
+    created_at          TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS schedules_due ON schedules (next_run) WHERE status = 'active';
+
+COMMIT;
@@ -0,0 +1,10 @@
+-- Adds the link from a leg of a split payment to its parent operation.
+
+BEGIN;
+
+ALTER TABLE transaction
+    ADD COLUMN IF NOT EXISTS split_of BIGINT REFERENCES transaction (ID);
+
+CREATE INDEX IF NOT EXISTS transaction_split_of_idx ON transaction (split_of) WHERE split_of IS NOT NULL;
+
+COMMIT;
@@ -0,0 +1,31 @@
+-- Adds daily balance checkpoints historical balances are replayed from and
+-- the tables for balance reports.
+
+BEGIN;
+
+CREATE INDEX IF NOT EXISTS transaction_to_id_created ON transaction (to_id, created);
+
+CREATE TABLE IF NOT EXISTS balance_checkpoints
+(
+    user_id BIGINT      NOT NULL REFERENCES users (ID),
+    at      TIMESTAMPTZ NOT NULL,
+    balance REAL        NOT NULL,
+    PRIMARY KEY (user_id, at)
+);
+
+CREATE TABLE IF NOT EXISTS balance_reports
+(
+    ID         BIGSERIAL PRIMARY KEY,
+    at         TIMESTAMPTZ NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS balance_report_items
+(
+    report_id BIGINT NOT NULL REFERENCES balance_reports (ID) ON DELETE CASCADE,
+    user_id   BIGINT NOT NULL REFERENCES users (ID),
+    balance   REAL   NOT NULL,
+    PRIMARY KEY (report_id, user_id)
+);
+
+COMMIT;
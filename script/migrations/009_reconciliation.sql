@@ -0,0 +1,27 @@
+-- Adds the results of balance reconciliation runs.
+
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS reconciliation_runs
+(
+    ID          BIGSERIAL PRIMARY KEY,
+    started_at  TIMESTAMPTZ NOT NULL,
+    finished_at TIMESTAMPTZ NOT NULL,
+    fix         BOOLEAN     NOT NULL DEFAULT FALSE,
+    accounts    INTEGER     NOT NULL,
+    mismatches  INTEGER     NOT NULL,
+    corrected   INTEGER     NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS reconciliation_mismatches
+(
+    run_id        BIGINT           NOT NULL REFERENCES reconciliation_runs (ID) ON DELETE CASCADE,
+    user_id       BIGINT           NOT NULL REFERENCES users (ID),
+    balance       DOUBLE PRECISION NOT NULL,
+    expected      DOUBLE PRECISION NOT NULL,
+    difference    DOUBLE PRECISION NOT NULL,
+    correction_id BIGINT REFERENCES transaction (ID),
+    PRIMARY KEY (run_id, user_id)
+);
+
+COMMIT;
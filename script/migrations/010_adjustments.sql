@@ -0,0 +1,13 @@
+-- Adds the audit trail of manual balance adjustments.
+
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS adjustments
+(
+    transaction_id BIGINT      PRIMARY KEY REFERENCES transaction (ID),
+    reason         TEXT        NOT NULL,
+    author         TEXT        NOT NULL,
+    created        TIMESTAMPTZ NOT NULL
+);
+
+COMMIT;
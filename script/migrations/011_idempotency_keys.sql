@@ -0,0 +1,16 @@
+-- Adds the idempotency keys of POST requests with their stored answers.
+
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS idempotency_keys
+(
+    key         VARCHAR(255) PRIMARY KEY,
+    fingerprint TEXT        NOT NULL,
+    status      INTEGER,
+    body        BYTEA,
+    created     TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idempotency_keys_created_idx ON idempotency_keys (created);
+
+COMMIT;
@@ -0,0 +1,84 @@
+-- Partitions the history by month for archiving and adds the archive tables.
+-- The references to operations stop being foreign keys: an archived operation
+-- is no longer in the table.
+
+BEGIN;
+
+ALTER TABLE schedules DROP CONSTRAINT IF EXISTS schedules_last_transaction_id_fkey;
+ALTER TABLE reconciliation_mismatches DROP CONSTRAINT IF EXISTS reconciliation_mismatches_correction_id_fkey;
+ALTER TABLE adjustments DROP CONSTRAINT IF EXISTS adjustments_transaction_id_fkey;
+
+ALTER TABLE transaction RENAME TO transaction_old;
+ALTER INDEX transaction_pkey RENAME TO transaction_old_pkey;
+ALTER INDEX IF EXISTS transaction_from_id_created RENAME TO transaction_old_from_id_created;
+ALTER INDEX IF EXISTS transaction_to_id_created RENAME TO transaction_old_to_id_created;
+ALTER INDEX IF EXISTS transaction_split_of_idx RENAME TO transaction_old_split_of_idx;
+
+CREATE TABLE transaction
+(
+    ID BIGINT NOT NULL DEFAULT nextval('transaction_id_seq'),
+    to_id BIGINT,
+    from_id BIGINT,
+    money REAL NOT NULL,
+    created TIMESTAMPTZ NOT NULL,
+    refund_of BIGINT,
+    fee_of BIGINT,
+    split_of BIGINT,
+    PRIMARY KEY (ID, created),
+    FOREIGN KEY (to_id) REFERENCES users(ID),
+    FOREIGN KEY (from_id) REFERENCES users(ID)
+    ) PARTITION BY RANGE (created);
+
+CREATE TABLE transaction_default PARTITION OF transaction DEFAULT;
+
+CREATE OR REPLACE FUNCTION create_transaction_partition(at TIMESTAMPTZ) RETURNS TEXT AS
+$$
+DECLARE
+    month TIMESTAMP := date_trunc('month', at AT TIME ZONE 'UTC');
+    name  TEXT      := 'transaction_' || to_char(month, 'YYYY_MM');
+BEGIN
+    EXECUTE format('CREATE TABLE IF NOT EXISTS %I PARTITION OF transaction FOR VALUES FROM (%L) TO (%L)',
+                   name, month AT TIME ZONE 'UTC', (month + INTERVAL '1 month') AT TIME ZONE 'UTC');
+    RETURN name;
+END
+$$ LANGUAGE plpgsql;
+
+-- a partition for every month from the first operation to the next month
+SELECT create_transaction_partition(month)
+FROM generate_series(
+             (SELECT date_trunc('month', COALESCE(MIN(created), now()) AT TIME ZONE 'UTC') AT TIME ZONE 'UTC'
+              FROM transaction_old),
+             now() + INTERVAL '1 month', INTERVAL '1 month') month;
+
+INSERT INTO transaction (ID, to_id, from_id, money, created, refund_of, fee_of, split_of)
+SELECT ID, to_id, from_id, money, created, refund_of, fee_of, split_of
+FROM transaction_old;
+
+ALTER SEQUENCE transaction_id_seq OWNED BY transaction.ID;
+DROP TABLE transaction_old;
+
+CREATE INDEX IF NOT EXISTS transaction_from_id_created ON transaction (from_id, created);
+CREATE INDEX IF NOT EXISTS transaction_to_id_created ON transaction (to_id, created);
+CREATE INDEX IF NOT EXISTS transaction_split_of_idx ON transaction (split_of) WHERE split_of IS NOT NULL;
+CREATE INDEX IF NOT EXISTS transaction_refund_of_idx ON transaction (refund_of) WHERE refund_of IS NOT NULL;
+
+CREATE TABLE IF NOT EXISTS history_archives
+(
+    partition   TEXT PRIMARY KEY,
+    from_ts     TIMESTAMPTZ NOT NULL,
+    to_ts       TIMESTAMPTZ NOT NULL,
+    rows        BIGINT      NOT NULL,
+    min_id      BIGINT,
+    max_id      BIGINT,
+    file        TEXT        NOT NULL,
+    sha256      TEXT        NOT NULL,
+    archived_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS archived_balances
+(
+    user_id BIGINT           PRIMARY KEY REFERENCES users (ID),
+    balance DOUBLE PRECISION NOT NULL
+);
+
+COMMIT;
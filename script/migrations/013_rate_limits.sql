@@ -0,0 +1,12 @@
+-- Adds the token buckets of the rate limiter shared by the instances.
+
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS rate_limits
+(
+    key     TEXT PRIMARY KEY,
+    tokens  DOUBLE PRECISION NOT NULL,
+    updated TIMESTAMPTZ      NOT NULL
+);
+
+COMMIT;
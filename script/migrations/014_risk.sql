@@ -0,0 +1,40 @@
+-- Adds the decisions of the risk checks and the queue of the operations held
+-- for review.
+
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS risk_reviews
+(
+    ID             BIGSERIAL PRIMARY KEY,
+    operation      TEXT             NOT NULL,
+    user_id        BIGINT           NOT NULL REFERENCES users (ID),
+    to_id          BIGINT REFERENCES users (ID),
+    money          DOUBLE PRECISION NOT NULL,
+    verdicts       JSONB            NOT NULL,
+    status         TEXT             NOT NULL DEFAULT 'pending'
+        CHECK (status IN ('pending', 'approved', 'rejected')),
+    created        TIMESTAMPTZ      NOT NULL,
+    reviewer       TEXT,
+    decided_at     TIMESTAMPTZ,
+    transaction_id BIGINT
+);
+
+CREATE INDEX IF NOT EXISTS risk_reviews_status_idx ON risk_reviews (status);
+
+CREATE TABLE IF NOT EXISTS risk_decisions
+(
+    ID             BIGSERIAL PRIMARY KEY,
+    operation      TEXT             NOT NULL,
+    user_id        BIGINT           NOT NULL REFERENCES users (ID),
+    to_id          BIGINT REFERENCES users (ID),
+    money          DOUBLE PRECISION NOT NULL,
+    decision       TEXT             NOT NULL CHECK (decision IN ('allow', 'hold', 'deny')),
+    verdicts       JSONB            NOT NULL,
+    transaction_id BIGINT,
+    review_id      BIGINT REFERENCES risk_reviews (ID),
+    created        TIMESTAMPTZ      NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS risk_decisions_user_id_idx ON risk_decisions (user_id, created);
+
+COMMIT;
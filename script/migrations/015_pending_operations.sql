@@ -0,0 +1,41 @@
+-- Adds the large operations waiting for approval. Their money is held in
+-- users.held, it is not available until they are decided.
+
+BEGIN;
+
+ALTER TABLE users
+    ADD COLUMN IF NOT EXISTS held REAL NOT NULL DEFAULT 0;
+
+CREATE TABLE IF NOT EXISTS pending_operations
+(
+    ID             BIGSERIAL PRIMARY KEY,
+    operation      TEXT             NOT NULL,
+    user_id        BIGINT           NOT NULL REFERENCES users (ID),
+    to_id          BIGINT REFERENCES users (ID),
+    money          DOUBLE PRECISION NOT NULL,
+    status         TEXT             NOT NULL DEFAULT 'pending'
+        CHECK (status IN ('pending', 'approved', 'rejected', 'expired')),
+    requested_by   TEXT,
+    decided_by     TEXT,
+    created        TIMESTAMPTZ      NOT NULL,
+    expires_at     TIMESTAMPTZ      NOT NULL,
+    decided_at     TIMESTAMPTZ,
+    transaction_id BIGINT
+);
+
+CREATE INDEX IF NOT EXISTS pending_operations_status_idx ON pending_operations (status, expires_at);
+
+-- pending_events are the state transitions of the pending operations.
+CREATE TABLE IF NOT EXISTS pending_events
+(
+    ID             BIGSERIAL PRIMARY KEY,
+    pending_id     BIGINT      NOT NULL REFERENCES pending_operations (ID),
+    status         TEXT        NOT NULL,
+    actor          TEXT,
+    transaction_id BIGINT,
+    created        TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS pending_events_pending_id_idx ON pending_events (pending_id);
+
+COMMIT;
@@ -0,0 +1,33 @@
+-- Adds the bonus money of the accounts. It is spent before the real money on
+-- the withdrawals for its services and is written off when it expires.
+
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS bonus_grants
+(
+    ID         BIGSERIAL PRIMARY KEY,
+    user_id    BIGINT           NOT NULL REFERENCES users (ID),
+    amount     DOUBLE PRECISION NOT NULL CHECK (amount > 0),
+    remaining  DOUBLE PRECISION NOT NULL CHECK (remaining >= 0),
+    services   TEXT[]           NOT NULL DEFAULT '{}',
+    created    TIMESTAMPTZ      NOT NULL,
+    expires_at TIMESTAMPTZ      NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS bonus_grants_user_id_idx ON bonus_grants (user_id, expires_at);
+
+-- bonus_movements are the grants, spends and write-offs of the bonuses.
+CREATE TABLE IF NOT EXISTS bonus_movements
+(
+    ID             BIGSERIAL PRIMARY KEY,
+    grant_id       BIGINT           NOT NULL REFERENCES bonus_grants (ID),
+    user_id        BIGINT           NOT NULL REFERENCES users (ID),
+    kind           TEXT             NOT NULL CHECK (kind IN ('grant', 'spend', 'expire')),
+    amount         DOUBLE PRECISION NOT NULL,
+    transaction_id BIGINT,
+    created        TIMESTAMPTZ      NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS bonus_movements_user_id_idx ON bonus_movements (user_id, kind);
+
+COMMIT;
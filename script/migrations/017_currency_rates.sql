@@ -0,0 +1,14 @@
+-- Adds the daily currency rates, the balance and the history are converted
+-- with them so the conversions of a day don't change.
+
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS currency_rates
+(
+    day      DATE             NOT NULL,
+    currency TEXT             NOT NULL,
+    rate     DOUBLE PRECISION NOT NULL CHECK (rate > 0),
+    PRIMARY KEY (currency, day)
+);
+
+COMMIT;
@@ -0,0 +1,16 @@
+-- Adds the service the held, reviewed and scheduled withdrawals pay for, so
+-- they spend its bonuses when they are made. The existing ones pay for no
+-- service.
+
+BEGIN;
+
+ALTER TABLE schedules
+    ADD COLUMN IF NOT EXISTS service TEXT NOT NULL DEFAULT '';
+
+ALTER TABLE risk_reviews
+    ADD COLUMN IF NOT EXISTS service TEXT NOT NULL DEFAULT '';
+
+ALTER TABLE pending_operations
+    ADD COLUMN IF NOT EXISTS service TEXT NOT NULL DEFAULT '';
+
+COMMIT;
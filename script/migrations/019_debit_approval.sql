@@ -0,0 +1,14 @@
+-- Holds the split payments, the batch items and the scheduled runs above the
+-- approval threshold too. A held split keeps its legs, a schedule keeps the
+-- client that made it, the requester of its held runs. The existing schedules
+-- have no known creator.
+
+BEGIN;
+
+ALTER TABLE pending_operations
+    ADD COLUMN IF NOT EXISTS legs JSONB;
+
+ALTER TABLE schedules
+    ADD COLUMN IF NOT EXISTS created_by TEXT NOT NULL DEFAULT '';
+
+COMMIT;
@@ -0,0 +1,9 @@
+-- Screens the split payments, the batch items and the scheduled runs too. A
+-- split held for review keeps its legs to pay them on approval.
+
+BEGIN;
+
+ALTER TABLE risk_reviews
+    ADD COLUMN IF NOT EXISTS legs JSONB;
+
+COMMIT;
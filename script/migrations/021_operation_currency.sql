@@ -0,0 +1,20 @@
+-- Withdrawals and transfers may be asked in another currency, their fee is
+-- charged by the rule of that currency. A held one keeps the currency and the
+-- rate it was converted at, empty for roubles. Fee rules can't have min_fee
+-- above max_fee, the existing ones are not checked.
+
+BEGIN;
+
+ALTER TABLE pending_operations
+    ADD COLUMN IF NOT EXISTS currency TEXT             NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS rate     DOUBLE PRECISION NOT NULL DEFAULT 0;
+
+ALTER TABLE risk_reviews
+    ADD COLUMN IF NOT EXISTS currency TEXT             NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS rate     DOUBLE PRECISION NOT NULL DEFAULT 0;
+
+ALTER TABLE fee_rules DROP CONSTRAINT IF EXISTS fee_rules_bounds;
+ALTER TABLE fee_rules
+    ADD CONSTRAINT fee_rules_bounds CHECK (min_fee IS NULL OR max_fee IS NULL OR min_fee <= max_fee) NOT VALID;
+
+COMMIT;
@@ -0,0 +1,14 @@
+-- Idempotency keys belong to the API client that sent them, the same key of
+-- two clients names two requests. The existing keys are left to the client ''
+-- and expire.
+
+BEGIN;
+
+ALTER TABLE idempotency_keys
+    ADD COLUMN IF NOT EXISTS client TEXT NOT NULL DEFAULT '';
+
+ALTER TABLE idempotency_keys DROP CONSTRAINT IF EXISTS idempotency_keys_pkey;
+ALTER TABLE idempotency_keys
+    ADD CONSTRAINT idempotency_keys_pkey PRIMARY KEY (client, key);
+
+COMMIT;
@@ -0,0 +1,17 @@
+-- Indexes the archived months by the users in them, so the history of a user
+-- reads only its months. The months archived before are not indexed and are
+-- still read for every user.
+
+BEGIN;
+
+ALTER TABLE history_archives
+    ADD COLUMN IF NOT EXISTS users_indexed BOOLEAN NOT NULL DEFAULT false;
+
+CREATE TABLE IF NOT EXISTS history_archive_users
+(
+    partition TEXT   NOT NULL REFERENCES history_archives (partition),
+    user_id   BIGINT NOT NULL,
+    PRIMARY KEY (user_id, partition)
+);
+
+COMMIT;
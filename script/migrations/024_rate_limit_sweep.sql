@@ -0,0 +1,19 @@
+-- Remembers when each shared token bucket is full again, so the idle full
+-- buckets can be deleted. The buckets kept before count as full since their
+-- last request: deleting one at most gives its key a fresh burst.
+
+BEGIN;
+
+ALTER TABLE rate_limits
+    ADD COLUMN IF NOT EXISTS full_at TIMESTAMPTZ;
+
+UPDATE rate_limits
+SET full_at = updated
+WHERE full_at IS NULL;
+
+ALTER TABLE rate_limits
+    ALTER COLUMN full_at SET NOT NULL;
+
+CREATE INDEX IF NOT EXISTS rate_limits_full_at ON rate_limits (full_at);
+
+COMMIT;
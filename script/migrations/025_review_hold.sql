@@ -0,0 +1,11 @@
+-- Keeps who asked for an operation held for review, they can't decide it
+-- themselves, and the money held on the account of the payer until the
+-- review is decided. The reviews queued before hold nothing.
+
+BEGIN;
+
+ALTER TABLE risk_reviews
+    ADD COLUMN IF NOT EXISTS requested_by TEXT,
+    ADD COLUMN IF NOT EXISTS held         DOUBLE PRECISION NOT NULL DEFAULT 0;
+
+COMMIT;
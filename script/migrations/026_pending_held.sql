@@ -0,0 +1,13 @@
+-- Keeps the money held for each pending operation: a withdrawal pays with its
+-- bonuses first, so only the real part of it is held. The operations stored
+-- before held all their money.
+
+BEGIN;
+
+ALTER TABLE pending_operations
+    ADD COLUMN IF NOT EXISTS held DOUBLE PRECISION NOT NULL DEFAULT 0;
+
+UPDATE pending_operations
+SET held = money;
+
+COMMIT;
@@ -0,0 +1,12 @@
+-- A refund of a withdrawal paid with bonuses returns their share to the
+-- grants it spent, the movement is of the new kind 'refund'.
+
+BEGIN;
+
+ALTER TABLE bonus_movements
+    DROP CONSTRAINT IF EXISTS bonus_movements_kind_check;
+
+ALTER TABLE bonus_movements
+    ADD CONSTRAINT bonus_movements_kind_check CHECK (kind IN ('grant', 'spend', 'expire', 'refund'));
+
+COMMIT;
@@ -0,0 +1,10 @@
+-- Links each state transition of a pending operation to its row in the
+-- history of the payer: the hold and the release of its money are rows
+-- without money. The transitions made before have none.
+
+BEGIN;
+
+ALTER TABLE pending_events
+    ADD COLUMN IF NOT EXISTS history_id BIGINT;
+
+COMMIT;
@@ -1,17 +1,337 @@
 CREATE TABLE IF NOT EXISTS users
 (
-    ID      BIGSERIAL PRIMARY KEY,
-    balance real
+    ID             BIGSERIAL PRIMARY KEY,
+    balance        real,
+    external_ref   TEXT UNIQUE,
+    status         TEXT        NOT NULL DEFAULT 'active',
+    debit_blocked  BOOLEAN     NOT NULL DEFAULT FALSE,
+    credit_blocked BOOLEAN     NOT NULL DEFAULT FALSE,
+    created_at     TIMESTAMPTZ NOT NULL DEFAULT now(),
+    closed_at      TIMESTAMPTZ,
+    credit_limit   REAL        NOT NULL DEFAULT 0,
+    -- held is the money of the operations waiting for approval
+    held           REAL        NOT NULL DEFAULT 0
 );
 
 
+-- The history is partitioned by month, old months are moved to archive files.
+-- References to operations are not foreign keys: an archived operation is
+-- no longer in the table.
 CREATE TABLE IF NOT EXISTS transaction
 (
-    ID BIGSERIAL PRIMARY KEY,
+    ID BIGSERIAL,
     to_id BIGINT,
     from_id BIGINT,
     money REAL NOT NULL,
-    created TIMESTAMP NOT NULL,
+    created TIMESTAMPTZ NOT NULL,
+    refund_of BIGINT,
+    fee_of BIGINT,
+    split_of BIGINT,
+    PRIMARY KEY (ID, created),
     FOREIGN KEY (to_id) REFERENCES users(ID),
     FOREIGN KEY (from_id) REFERENCES users(ID)
-    );
\ No newline at end of file
+    ) PARTITION BY RANGE (created);
+
+-- operations of the months without a partition
+CREATE TABLE IF NOT EXISTS transaction_default PARTITION OF transaction DEFAULT;
+
+-- create_transaction_partition creates the partition of the month of at (UTC)
+-- and returns its name.
+CREATE OR REPLACE FUNCTION create_transaction_partition(at TIMESTAMPTZ) RETURNS TEXT AS
+$$
+DECLARE
+    month TIMESTAMP := date_trunc('month', at AT TIME ZONE 'UTC');
+    name  TEXT      := 'transaction_' || to_char(month, 'YYYY_MM');
+BEGIN
+    EXECUTE format('CREATE TABLE IF NOT EXISTS %I PARTITION OF transaction FOR VALUES FROM (%L) TO (%L)',
+                   name, month AT TIME ZONE 'UTC', (month + INTERVAL '1 month') AT TIME ZONE 'UTC');
+    RETURN name;
+END
+$$ LANGUAGE plpgsql;
+
+SELECT create_transaction_partition(now());
+SELECT create_transaction_partition(now() + INTERVAL '1 month');
+
+CREATE TABLE IF NOT EXISTS limits
+(
+    user_id          BIGINT PRIMARY KEY,
+    max_operation    REAL,
+    daily_debit      REAL,
+    monthly_debit    REAL,
+    hourly_transfers INTEGER
+);
+
+CREATE INDEX IF NOT EXISTS transaction_from_id_created ON transaction (from_id, created);
+CREATE INDEX IF NOT EXISTS transaction_split_of_idx ON transaction (split_of) WHERE split_of IS NOT NULL;
+CREATE INDEX IF NOT EXISTS transaction_refund_of_idx ON transaction (refund_of) WHERE refund_of IS NOT NULL;
+
+CREATE TABLE IF NOT EXISTS overdraft_history
+(
+    ID      BIGSERIAL PRIMARY KEY,
+    user_id BIGINT      NOT NULL REFERENCES users (ID),
+    event   TEXT        NOT NULL,
+    balance REAL        NOT NULL,
+    created TIMESTAMPTZ NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS fee_rules
+(
+    ID        BIGSERIAL PRIMARY KEY,
+    operation TEXT NOT NULL,
+    currency  TEXT NOT NULL DEFAULT 'RUB',
+    user_id   BIGINT REFERENCES users (ID),
+    fixed     REAL NOT NULL DEFAULT 0,
+    percent   REAL NOT NULL DEFAULT 0,
+    min_fee   REAL,
+    max_fee   REAL,
+    CONSTRAINT fee_rules_bounds CHECK (min_fee IS NULL OR max_fee IS NULL OR min_fee <= max_fee)
+);
+
+INSERT INTO users (id, balance, external_ref) VALUES (0, 0, 'system:revenue') ON CONFLICT DO NOTHING;
+
+CREATE TABLE IF NOT EXISTS schedules
+(
+    ID                  BIGSERIAL PRIMARY KEY,
+    from_id             BIGINT      NOT NULL REFERENCES users (ID),
+    to_id               BIGINT REFERENCES users (ID),
+    money               REAL        NOT NULL,
+    cron                TEXT        NOT NULL DEFAULT '',
+    interval_seconds    BIGINT      NOT NULL DEFAULT 0,
+    next_run            TIMESTAMPTZ NOT NULL,
+    status              TEXT        NOT NULL DEFAULT 'active',
+    attempts            INTEGER     NOT NULL DEFAULT 0,
+    max_attempts        INTEGER     NOT NULL DEFAULT 3,
+    retry_delay_seconds BIGINT      NOT NULL DEFAULT 3600,
+    last_error          TEXT,
+    last_transaction_id BIGINT,
+    locked_by           TEXT,
+    locked_until        TIMESTAMPTZ,
+    created_at          TIMESTAMPTZ NOT NULL DEFAULT now(),
+    service             TEXT        NOT NULL DEFAULT '',
+    created_by          TEXT        NOT NULL DEFAULT ''
+);
+
+CREATE INDEX IF NOT EXISTS schedules_due ON schedules (next_run) WHERE status = 'active';
+
+CREATE INDEX IF NOT EXISTS transaction_to_id_created ON transaction (to_id, created);
+
+CREATE TABLE IF NOT EXISTS balance_checkpoints
+(
+    user_id BIGINT      NOT NULL REFERENCES users (ID),
+    at      TIMESTAMPTZ NOT NULL,
+    balance REAL        NOT NULL,
+    PRIMARY KEY (user_id, at)
+);
+
+CREATE TABLE IF NOT EXISTS balance_reports
+(
+    ID         BIGSERIAL PRIMARY KEY,
+    at         TIMESTAMPTZ NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS balance_report_items
+(
+    report_id BIGINT NOT NULL REFERENCES balance_reports (ID) ON DELETE CASCADE,
+    user_id   BIGINT NOT NULL REFERENCES users (ID),
+    balance   REAL   NOT NULL,
+    PRIMARY KEY (report_id, user_id)
+);
+
+
+CREATE TABLE IF NOT EXISTS reconciliation_runs
+(
+    ID          BIGSERIAL PRIMARY KEY,
+    started_at  TIMESTAMPTZ NOT NULL,
+    finished_at TIMESTAMPTZ NOT NULL,
+    fix         BOOLEAN     NOT NULL DEFAULT FALSE,
+    accounts    INTEGER     NOT NULL,
+    mismatches  INTEGER     NOT NULL,
+    corrected   INTEGER     NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS reconciliation_mismatches
+(
+    run_id        BIGINT           NOT NULL REFERENCES reconciliation_runs (ID) ON DELETE CASCADE,
+    user_id       BIGINT           NOT NULL REFERENCES users (ID),
+    balance       DOUBLE PRECISION NOT NULL,
+    expected      DOUBLE PRECISION NOT NULL,
+    difference    DOUBLE PRECISION NOT NULL,
+    correction_id BIGINT,
+    PRIMARY KEY (run_id, user_id)
+);
+
+
+CREATE TABLE IF NOT EXISTS adjustments
+(
+    transaction_id BIGINT      PRIMARY KEY,
+    reason         TEXT        NOT NULL,
+    author         TEXT        NOT NULL,
+    created        TIMESTAMPTZ NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS idempotency_keys
+(
+    client      TEXT         NOT NULL DEFAULT '',
+    key         VARCHAR(255) NOT NULL,
+    fingerprint TEXT         NOT NULL,
+    status      INTEGER,
+    body        BYTEA,
+    created     TIMESTAMPTZ  NOT NULL,
+    PRIMARY KEY (client, key)
+);
+
+CREATE INDEX IF NOT EXISTS idempotency_keys_created_idx ON idempotency_keys (created);
+
+
+CREATE TABLE IF NOT EXISTS history_archives
+(
+    partition     TEXT PRIMARY KEY,
+    from_ts       TIMESTAMPTZ NOT NULL,
+    to_ts         TIMESTAMPTZ NOT NULL,
+    rows          BIGINT      NOT NULL,
+    min_id        BIGINT,
+    max_id        BIGINT,
+    file          TEXT        NOT NULL,
+    sha256        TEXT        NOT NULL,
+    archived_at   TIMESTAMPTZ NOT NULL,
+    users_indexed BOOLEAN     NOT NULL DEFAULT false
+);
+
+-- history_archive_users are the users with operations in every archived month.
+CREATE TABLE IF NOT EXISTS history_archive_users
+(
+    partition TEXT   NOT NULL REFERENCES history_archives (partition),
+    user_id   BIGINT NOT NULL,
+    PRIMARY KEY (user_id, partition)
+);
+
+-- archived_balances is how the archived operations changed every balance.
+CREATE TABLE IF NOT EXISTS archived_balances
+(
+    user_id BIGINT           PRIMARY KEY REFERENCES users (ID),
+    balance DOUBLE PRECISION NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS rate_limits
+(
+    key     TEXT PRIMARY KEY,
+    tokens  DOUBLE PRECISION NOT NULL,
+    updated TIMESTAMPTZ      NOT NULL,
+    full_at TIMESTAMPTZ      NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS rate_limits_full_at ON rate_limits (full_at);
+
+CREATE TABLE IF NOT EXISTS risk_reviews
+(
+    ID             BIGSERIAL PRIMARY KEY,
+    operation      TEXT             NOT NULL,
+    user_id        BIGINT           NOT NULL REFERENCES users (ID),
+    to_id          BIGINT REFERENCES users (ID),
+    money          DOUBLE PRECISION NOT NULL,
+    verdicts       JSONB            NOT NULL,
+    status         TEXT             NOT NULL DEFAULT 'pending'
+        CHECK (status IN ('pending', 'approved', 'rejected')),
+    created        TIMESTAMPTZ      NOT NULL,
+    reviewer       TEXT,
+    decided_at     TIMESTAMPTZ,
+    transaction_id BIGINT,
+    service        TEXT             NOT NULL DEFAULT '',
+    legs           JSONB,
+    currency       TEXT             NOT NULL DEFAULT '',
+    rate           DOUBLE PRECISION NOT NULL DEFAULT 0,
+    requested_by   TEXT,
+    held           DOUBLE PRECISION NOT NULL DEFAULT 0
+);
+
+CREATE INDEX IF NOT EXISTS risk_reviews_status_idx ON risk_reviews (status);
+
+CREATE TABLE IF NOT EXISTS risk_decisions
+(
+    ID             BIGSERIAL PRIMARY KEY,
+    operation      TEXT             NOT NULL,
+    user_id        BIGINT           NOT NULL REFERENCES users (ID),
+    to_id          BIGINT REFERENCES users (ID),
+    money          DOUBLE PRECISION NOT NULL,
+    decision       TEXT             NOT NULL CHECK (decision IN ('allow', 'hold', 'deny')),
+    verdicts       JSONB            NOT NULL,
+    transaction_id BIGINT,
+    review_id      BIGINT REFERENCES risk_reviews (ID),
+    created        TIMESTAMPTZ      NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS risk_decisions_user_id_idx ON risk_decisions (user_id, created);
+
+CREATE TABLE IF NOT EXISTS pending_operations
+(
+    ID             BIGSERIAL PRIMARY KEY,
+    operation      TEXT             NOT NULL,
+    user_id        BIGINT           NOT NULL REFERENCES users (ID),
+    to_id          BIGINT REFERENCES users (ID),
+    money          DOUBLE PRECISION NOT NULL,
+    status         TEXT             NOT NULL DEFAULT 'pending'
+        CHECK (status IN ('pending', 'approved', 'rejected', 'expired')),
+    requested_by   TEXT,
+    decided_by     TEXT,
+    created        TIMESTAMPTZ      NOT NULL,
+    expires_at     TIMESTAMPTZ      NOT NULL,
+    decided_at     TIMESTAMPTZ,
+    transaction_id BIGINT,
+    service        TEXT             NOT NULL DEFAULT '',
+    legs           JSONB,
+    currency       TEXT             NOT NULL DEFAULT '',
+    rate           DOUBLE PRECISION NOT NULL DEFAULT 0,
+    held           DOUBLE PRECISION NOT NULL DEFAULT 0
+);
+
+CREATE INDEX IF NOT EXISTS pending_operations_status_idx ON pending_operations (status, expires_at);
+
+-- pending_events are the state transitions of the pending operations.
+CREATE TABLE IF NOT EXISTS pending_events
+(
+    ID             BIGSERIAL PRIMARY KEY,
+    pending_id     BIGINT      NOT NULL REFERENCES pending_operations (ID),
+    status         TEXT        NOT NULL,
+    actor          TEXT,
+    transaction_id BIGINT,
+    created        TIMESTAMPTZ NOT NULL,
+    history_id     BIGINT
+);
+
+CREATE INDEX IF NOT EXISTS pending_events_pending_id_idx ON pending_events (pending_id);
+
+CREATE TABLE IF NOT EXISTS bonus_grants
+(
+    ID         BIGSERIAL PRIMARY KEY,
+    user_id    BIGINT           NOT NULL REFERENCES users (ID),
+    amount     DOUBLE PRECISION NOT NULL CHECK (amount > 0),
+    remaining  DOUBLE PRECISION NOT NULL CHECK (remaining >= 0),
+    services   TEXT[]           NOT NULL DEFAULT '{}',
+    created    TIMESTAMPTZ      NOT NULL,
+    expires_at TIMESTAMPTZ      NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS bonus_grants_user_id_idx ON bonus_grants (user_id, expires_at);
+
+-- bonus_movements are the grants, spends, write-offs and refunds of the bonuses.
+CREATE TABLE IF NOT EXISTS bonus_movements
+(
+    ID             BIGSERIAL PRIMARY KEY,
+    grant_id       BIGINT           NOT NULL REFERENCES bonus_grants (ID),
+    user_id        BIGINT           NOT NULL REFERENCES users (ID),
+    kind           TEXT             NOT NULL CHECK (kind IN ('grant', 'spend', 'expire', 'refund')),
+    amount         DOUBLE PRECISION NOT NULL,
+    transaction_id BIGINT,
+    created        TIMESTAMPTZ      NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS bonus_movements_user_id_idx ON bonus_movements (user_id, kind);
+
+CREATE TABLE IF NOT EXISTS currency_rates
+(
+    day      DATE             NOT NULL,
+    currency TEXT             NOT NULL,
+    rate     DOUBLE PRECISION NOT NULL CHECK (rate > 0),
+    PRIMARY KEY (currency, day)
+);